@@ -2,59 +2,107 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"salmoncannon/config"
+	"salmoncannon/connections"
 	"salmoncannon/limiter"
 	"salmoncannon/status"
 )
 
+// BridgeController pauses and resumes running bridges by name.
+// SetBridgeEnabled returns false if no bridge with that name is running.
+type BridgeController interface {
+	SetBridgeEnabled(name string, enabled bool) bool
+}
+
 // Server is a small HTTP API server that serves info about bridges.
-// Construct with NewServer(cfg, listenAddr)
+// Construct with NewServer(cfg, listenAddr, controller). listenAddr is a
+// TCP host:port, or a UNIX socket path after UnixPrefix.
 type Server struct {
 	cfg        *config.SalmonCannonConfig
+	controller BridgeController
+	bridgesMu  sync.RWMutex
+	bridges    []config.SalmonBridgeConfig
+	reloader   Reloader // see SetReloader, guarded by bridgesMu
 	listenAddr string
 	httpSrv    *http.Server
 	ln         net.Listener
+
+	wsSubscribers atomic.Int32
+	draining      atomic.Bool   // see SetDraining
+	wsDone        chan struct{} // closed by Stop to end live status streams
+	stopOnce      sync.Once
+}
+
+// NewServer creates a new API server instance. controller may be nil, in
+// which case the enable/disable endpoints report every bridge as unknown.
+func NewServer(cfg *config.SalmonCannonConfig, listenAddr string, controller BridgeController) *Server {
+	return &Server{cfg: cfg, controller: controller, bridges: cfg.Bridges, listenAddr: listenAddr,
+		wsDone: make(chan struct{})}
 }
 
-// NewServer creates a new API server instance.
-func NewServer(cfg *config.SalmonCannonConfig, listenAddr string) *Server {
-	return &Server{cfg: cfg, listenAddr: listenAddr}
+// SetBridges replaces the bridge list served by the API, e.g. after a
+// config reload.
+func (s *Server) SetBridges(bridges []config.SalmonBridgeConfig) {
+	s.bridgesMu.Lock()
+	defer s.bridgesMu.Unlock()
+	s.bridges = bridges
+}
+
+func (s *Server) currentBridges() []config.SalmonBridgeConfig {
+	s.bridgesMu.RLock()
+	defer s.bridgesMu.RUnlock()
+	return s.bridges
 }
 
 // Start begins listening and serving. It returns after the server has started or an error.
 func (s *Server) Start() error {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/v1/bridges", s.handleBridges)
-	mux.HandleFunc("/api/v1/status", s.handleStatus)
-
 	h := &http.Server{
 		Addr:    s.listenAddr,
-		Handler: mux,
+		Handler: s.routes(),
 	}
 	s.httpSrv = h
 
-	ln, err := net.Listen("tcp", s.listenAddr)
-	if err != nil {
-		return err
-	}
-	s.ln = ln
-
 	// Check if TLS is configured
 	useTLS := s.cfg.ApiConfig != nil &&
 		s.cfg.ApiConfig.TLSCert != "" &&
 		s.cfg.ApiConfig.TLSKey != ""
+	if useTLS {
+		tlsCfg, err := s.cfg.ApiConfig.TLSConfig()
+		if err != nil {
+			return err
+		}
+		cert, err := tls.LoadX509KeyPair(s.cfg.ApiConfig.TLSCert, s.cfg.ApiConfig.TLSKey)
+		if err != nil {
+			return fmt.Errorf("load TLS certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+		h.TLSConfig = tlsCfg
+	}
+
+	ln, err := listen(s.listenAddr)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
 
 	go func() {
 		var err error
 		if useTLS {
 			log.Printf("api: starting HTTPS server on %s", s.listenAddr)
-			err = h.ServeTLS(ln, s.cfg.ApiConfig.TLSCert, s.cfg.ApiConfig.TLSKey)
+			// The certificate is already in h.TLSConfig
+			err = h.ServeTLS(ln, "", "")
 		} else {
 			log.Printf("api: starting HTTP server on %s", s.listenAddr)
 			err = h.Serve(ln)
@@ -69,12 +117,18 @@ func (s *Server) Start() error {
 
 // Stop attempts a graceful shutdown with a 5s timeout.
 func (s *Server) Stop() error {
+	// Shutdown doesn't wait for hijacked websocket connections
+	s.stopOnce.Do(func() { close(s.wsDone) })
 	if s.httpSrv == nil {
 		return nil
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	return s.httpSrv.Shutdown(ctx)
+	err := s.httpSrv.Shutdown(ctx)
+	// Serve may not have taken the listener over yet; for a UNIX socket
+	// closing it also removes the socket file
+	s.ln.Close()
+	return err
 }
 
 // bridgeDTO is the JSON shape returned for each bridge
@@ -94,6 +148,35 @@ type statusDTO struct {
 	LastPingMs           int64   `json:"last_ping_ms"`
 	Alive                bool    `json:"alive"`
 	TransferredBytes     uint64  `json:"transferred_bytes"`
+	ActiveEndpoint       string  `json:"active_endpoint,omitempty"`
+
+	// Last failure reaching the far side, cleared by the next success
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitzero"`
+
+	// Client connections by how they reached the bridge
+	ActiveSocks    int64 `json:"active_socks"`
+	ActiveHTTP     int64 `json:"active_http"`
+	ActiveRedirect int64 `json:"active_redirect"`
+	TotalSocks     int64 `json:"total_socks"`
+	TotalHTTP      int64 `json:"total_http"`
+	TotalRedirect  int64 `json:"total_redirect"`
+
+	// Rejections since start
+	HandshakeFailures int64 `json:"handshake_failures"`
+	AuthMismatch      int64 `json:"auth_method_mismatch"`
+	AllowlistBlocks   int64 `json:"allowlist_blocks"`
+	PoolSaturated     int64 `json:"pool_saturated"`
+	DialFailures      int64 `json:"dial_failures"`
+	ClientLimit       int64 `json:"client_limit"`
+	ClientRateLimit   int64 `json:"client_rate_limit"`
+	OutClientLimit    int64 `json:"out_client_limit"`
+
+	// Stream payload compression, both directions; the ratio is 1.0 when
+	// nothing was compressed
+	UncompressedBytes int64   `json:"uncompressed_bytes"`
+	CompressedBytes   int64   `json:"compressed_bytes"`
+	CompressionRatio  float64 `json:"compression_ratio"`
 }
 
 func (s *Server) handleBridges(w http.ResponseWriter, r *http.Request) {
@@ -103,8 +186,9 @@ func (s *Server) handleBridges(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	list := make([]bridgeDTO, 0, len(s.cfg.Bridges))
-	for i, b := range s.cfg.Bridges {
+	bridges := s.currentBridges()
+	list := make([]bridgeDTO, 0, len(bridges))
+	for i, b := range bridges {
 		list = append(list, bridgeDTO{Name: b.Name, Circuit: b.Name, ID: i})
 	}
 
@@ -115,6 +199,216 @@ func (s *Server) handleBridges(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// bridgeStateDTO is the JSON shape returned after enabling/disabling a bridge
+type bridgeStateDTO struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// handleBridgeToggle serves POST /api/v1/bridges/{name}/enable and /disable.
+// Disabling stops new connections; streams already open run until they close.
+func (s *Server) handleBridgeToggle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var enabled bool
+	switch r.PathValue("action") {
+	case "enable":
+		enabled = true
+	case "disable":
+		enabled = false
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	name := r.PathValue("name")
+	if s.controller == nil || !s.controller.SetBridgeEnabled(name, enabled) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	log.Printf("api: bridge %s enabled=%v", name, enabled)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(bridgeStateDTO{Name: name, Enabled: enabled}); err != nil {
+		log.Printf("api: encode error: %v", err)
+	}
+}
+
+// bridgeRateDTO is the JSON shape accepted and returned by the rate limit
+// endpoint. 0 means unlimited.
+type bridgeRateDTO struct {
+	Name        string `json:"name,omitempty"`
+	BytesPerSec int64  `json:"bytes_per_sec"`
+}
+
+// handleBridgeRateLimit serves PUT /api/v1/bridges/{name}/ratelimit. The new
+// rate applies to open connections straight away and lasts until the bridge
+// is restarted or its SBTotalBandwidthLimit changes on reload.
+func (s *Server) handleBridgeRateLimit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.PathValue("name")
+	lim, ok := bridgeLimiter(name)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var req bridgeRateDTO
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.BytesPerSec < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	lim.SetRate(req.BytesPerSec)
+	log.Printf("api: bridge %s rate limit set to %d bytes/s", name, req.BytesPerSec)
+
+	resp := bridgeRateDTO{Name: name}
+	if !lim.Unlimited() {
+		resp.BytesPerSec = lim.GetMaxRate()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(resp); err != nil {
+		log.Printf("api: encode error: %v", err)
+	}
+}
+
+// redacted replaces secrets in the bridge config endpoint's output.
+const redacted = "REDACTED"
+
+// handleBridgeConfig serves GET /api/v1/bridges/{name}/config: the bridge's
+// config after defaults, keyed by SB option name, with secrets redacted. The
+// response is a list since a near and a far may share a name. Like the reload
+// endpoint it is forbidden when no AuthToken is set.
+func (s *Server) handleBridgeConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// requireToken has checked the token, if there is one
+	if s.authToken() == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	name := r.PathValue("name")
+	var list []map[string]any
+	for _, b := range s.currentBridges() {
+		if b.Name == name {
+			list = append(list, BridgeConfigFields(b))
+		}
+	}
+	if len(list) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(list); err != nil {
+		log.Printf("api: encode error: %v", err)
+	}
+}
+
+// BridgeConfigFields maps a bridge's config to its YAML option names, so
+// the output reads like the config file. Secrets are redacted.
+func BridgeConfigFields(b config.SalmonBridgeConfig) map[string]any {
+	if b.SharedSecret != "" {
+		b.SharedSecret = redacted
+	}
+	if len(b.SocksUsers) > 0 {
+		users := make(map[string]string, len(b.SocksUsers))
+		for user := range b.SocksUsers {
+			users[user] = redacted
+		}
+		b.SocksUsers = users
+	}
+
+	fields := make(map[string]any)
+	v := reflect.ValueOf(b)
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = v.Field(i).Interface()
+	}
+	return fields
+}
+
+// connectionDTO is the JSON shape returned for each QUIC connection of a
+// bridge
+type connectionDTO struct {
+	RemoteAddr    string    `json:"remote_addr"`
+	CreatedAt     time.Time `json:"created_at"`
+	ActiveStreams int32     `json:"active_streams"`
+	BytesSent     uint64    `json:"bytes_sent"`
+	BytesReceived uint64    `json:"bytes_received"`
+	Draining      bool      `json:"draining,omitempty"`
+}
+
+// handleBridgeConnections serves GET /api/v1/bridges/{name}/connections: the
+// bridge's open QUIC connections with the bytes each has carried, to spot a
+// pool where one connection takes all the load.
+func (s *Server) handleBridgeConnections(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.PathValue("name")
+	poolInterface, ok := status.GlobalConnMonitorRef.GetConnStats(name)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	pool, ok := poolInterface.(*connections.SalmonQuic)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	stats := pool.Stats()
+	list := make([]connectionDTO, 0, len(stats))
+	for _, c := range stats {
+		list = append(list, connectionDTO{
+			RemoteAddr:    c.RemoteAddr,
+			CreatedAt:     c.CreatedAt,
+			ActiveStreams: c.ActiveStreams,
+			BytesSent:     c.BytesSent,
+			BytesReceived: c.BytesReceived,
+			Draining:      c.Draining,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(list); err != nil {
+		log.Printf("api: encode error: %v", err)
+	}
+}
+
+// bridgeLimiter returns the shared limiter registered for a bridge.
+func bridgeLimiter(name string) (*limiter.SharedLimiter, bool) {
+	limiterInterface, ok := status.GlobalConnMonitorRef.GetLimiter(name)
+	if !ok {
+		return nil, false
+	}
+	lim, ok := limiterInterface.(*limiter.SharedLimiter)
+	return lim, ok
+}
+
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method != http.MethodGet {
@@ -122,11 +416,23 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	list := make([]statusDTO, 0, len(s.cfg.Bridges))
+	list := s.statusSnapshot()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(list); err != nil {
+		log.Printf("api: encode error: %v", err)
+	}
+}
+
+// statusSnapshot builds the status of every configured bridge.
+func (s *Server) statusSnapshot() []statusDTO {
+	bridges := s.currentBridges()
+	list := make([]statusDTO, 0, len(bridges))
 
 	// Import the status package to access the limiter registry
 	// We'll need to iterate through registered limiters
-	for _, b := range s.cfg.Bridges {
+	for _, b := range bridges {
 		maxRateBps := int64(b.TotalBandwidthLimit) * 8 // Convert bytes to bits
 
 		// Try to get the active rate from the registered limiter
@@ -137,6 +443,12 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 				// GetActiveRate returns bytes per second, convert to bits per second
 				activeRateBps = float64(limiter.GetActiveRate()) * 8.0
 				transferredBytes = limiter.GetBytesTransferred()
+				// Reflect overrides made through the rate limit endpoint
+				if limiter.Unlimited() {
+					maxRateBps = -8
+				} else {
+					maxRateBps = limiter.GetMaxRate() * 8
+				}
 			}
 		}
 
@@ -147,6 +459,10 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 		lastPingMs := status.GlobalConnMonitorRef.GetPing(b.Name)
 		alive := status.GlobalConnMonitorRef.GetStatus(b.Name)
 		streamCount := status.GlobalConnMonitorRef.GetStreamCount(b.Name)
+		rejects := status.GlobalConnMonitorRef.Rejections(b.Name)
+		ingress := status.GlobalConnMonitorRef.Ingress(b.Name)
+		lastErr, _ := status.GlobalConnMonitorRef.LastError(b.Name)
+		compression := status.GlobalConnMonitorRef.Compression(b.Name)
 
 		list = append(list, statusDTO{
 			BridgeName:           b.Name,
@@ -157,12 +473,68 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 			LastPingMs:           lastPingMs,
 			ActiveStreams:        streamCount,
 			TransferredBytes:     transferredBytes,
+			ActiveEndpoint:       status.GlobalConnMonitorRef.GetEndpoint(b.Name),
+			LastError:            lastErr.Message,
+			LastErrorTime:        lastErr.Time,
+			ActiveSocks:          ingress.ActiveSOCKS,
+			ActiveHTTP:           ingress.ActiveHTTP,
+			ActiveRedirect:       ingress.ActiveRedirect,
+			TotalSocks:           ingress.TotalSOCKS,
+			TotalHTTP:            ingress.TotalHTTP,
+			TotalRedirect:        ingress.TotalRedirect,
+			HandshakeFailures:    rejects.HandshakeFailures,
+			AuthMismatch:         rejects.AuthMismatch,
+			AllowlistBlocks:      rejects.AllowlistBlocks,
+			PoolSaturated:        rejects.PoolSaturated,
+			DialFailures:         rejects.DialFailures,
+			ClientLimit:          rejects.ClientLimit,
+			ClientRateLimit:      rejects.ClientRateLimit,
+			OutClientLimit:       rejects.OutClientLimit,
+			UncompressedBytes:    compression.UncompressedBytes,
+			CompressedBytes:      compression.CompressedBytes,
+			CompressionRatio:     compression.Ratio(),
 		})
 	}
+	return list
+}
+
+// statusHistoryDTO is the JSON shape returned for a bridge's bandwidth history
+type statusHistoryDTO struct {
+	BridgeName string              `json:"bridge_name"`
+	IntervalMs int64               `json:"interval_ms"`
+	Samples    []status.RateSample `json:"samples"`
+}
+
+// handleStatusHistory serves GET /api/v1/status/history?bridge=NAME with the
+// sampled bandwidth of one bridge, oldest sample first.
+func (s *Server) handleStatusHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
 
+	name := r.URL.Query().Get("bridge")
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	known := false
+	for _, b := range s.currentBridges() {
+		if b.Name == name {
+			known = true
+			break
+		}
+	}
+	if !known {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	samples, interval := status.GlobalConnMonitorRef.GetRateHistory(name)
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
-	if err := enc.Encode(list); err != nil {
+	if err := enc.Encode(statusHistoryDTO{BridgeName: name, IntervalMs: interval.Milliseconds(), Samples: samples}); err != nil {
 		log.Printf("api: encode error: %v", err)
 	}
 }
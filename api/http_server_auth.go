@@ -0,0 +1,56 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+)
+
+// authToken returns ApiConfig.AuthToken, or "" when none is set.
+func (s *Server) authToken() string {
+	if s.cfg.ApiConfig == nil {
+		return ""
+	}
+	return s.cfg.ApiConfig.AuthToken
+}
+
+// requireToken wraps next so it only runs for requests carrying the
+// ApiConfig.AuthToken bearer token, answering 401 to the rest. Without a
+// token every request goes through, as before tokens existed.
+func (s *Server) requireToken(next http.Handler) http.Handler {
+	token := s.authToken()
+	if token == "" {
+		return next
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routes returns the API's handler with every endpoint behind requireToken.
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/bridges", s.handleBridges)
+	mux.HandleFunc("/api/v1/bridges/{name}/{action}", s.handleBridgeToggle)
+	mux.HandleFunc("/api/v1/bridges/{name}/ratelimit", s.handleBridgeRateLimit)
+	mux.HandleFunc("/api/v1/bridges/{name}/config", s.handleBridgeConfig)
+	mux.HandleFunc("/api/v1/bridges/{name}/connections", s.handleBridgeConnections)
+	mux.HandleFunc("/api/v1/reload", s.handleReload)
+	mux.HandleFunc("/api/v1/status", s.handleStatus)
+	mux.HandleFunc("/api/v1/status/history", s.handleStatusHistory)
+	mux.HandleFunc("/api/v1/status/ws", s.handleStatusWS)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+
+	if s.authToken() == "" {
+		log.Printf("api: no AuthToken set, every endpoint is open to anyone who can reach %s", s.listenAddr)
+	}
+	return s.requireToken(mux)
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"salmoncannon/config"
+)
+
+// serve runs one request through srv's routes, with a bearer token unless
+// token is empty.
+func serve(handler http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequireToken(t *testing.T) {
+	ctrl := &fakeController{state: map[string]bool{"auth-bridge": true}}
+	cfg := &config.SalmonCannonConfig{
+		ApiConfig: &config.ApiConfig{AuthToken: "s3cret-token"},
+		Bridges:   []config.SalmonBridgeConfig{{Name: "auth-bridge"}},
+	}
+	handler := NewServer(cfg, ":0", ctrl).routes()
+
+	endpoints := []struct {
+		method, path string
+	}{
+		{http.MethodGet, "/api/v1/bridges"},
+		{http.MethodPost, "/api/v1/bridges/auth-bridge/disable"},
+		{http.MethodPut, "/api/v1/bridges/auth-bridge/ratelimit"},
+		{http.MethodGet, "/api/v1/bridges/auth-bridge/config"},
+		{http.MethodGet, "/api/v1/bridges/auth-bridge/connections"},
+		{http.MethodGet, "/api/v1/status"},
+		{http.MethodGet, "/api/v1/status/history"},
+		{http.MethodGet, "/api/v1/status/ws"},
+		{http.MethodGet, "/metrics"},
+		{http.MethodGet, "/healthz"},
+		{http.MethodGet, "/readyz"},
+	}
+	for _, e := range endpoints {
+		for _, token := range []string{"", "wrong", "s3cret-token-longer"} {
+			w := serve(handler, e.method, e.path, token)
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("%s %s with token %q: expected 401, got %d", e.method, e.path, token, w.Code)
+			}
+			if w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Fatalf("%s %s: expected a WWW-Authenticate challenge", e.method, e.path)
+			}
+		}
+	}
+	if !ctrl.state["auth-bridge"] {
+		t.Fatalf("expected an unauthorized disable to leave the bridge enabled")
+	}
+
+	// The right token reaches the handlers
+	if w := serve(handler, http.MethodGet, "/api/v1/bridges", "s3cret-token"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 with the token, got %d", w.Code)
+	}
+	if w := serve(handler, http.MethodGet, "/healthz", "s3cret-token"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 from /healthz with the token, got %d", w.Code)
+	}
+	if w := serve(handler, http.MethodPost, "/api/v1/bridges/auth-bridge/disable", "s3cret-token"); w.Code != http.StatusOK || ctrl.state["auth-bridge"] {
+		t.Fatalf("expected the bridge to be disabled with the token, got %d", w.Code)
+	}
+}
+
+func TestRequireToken_NoTokenConfigured(t *testing.T) {
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	ctrl := &fakeController{state: map[string]bool{"open-bridge": true}}
+	cfg := &config.SalmonCannonConfig{Bridges: []config.SalmonBridgeConfig{{Name: "open-bridge"}}}
+	handler := NewServer(cfg, ":0", ctrl).routes()
+	if got := strings.Count(logs.String(), "no AuthToken set"); got != 1 {
+		t.Fatalf("expected one warning about the missing AuthToken, got %d:\n%s", got, logs.String())
+	}
+
+	if w := serve(handler, http.MethodGet, "/api/v1/bridges", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the API to stay open without a token, got %d", w.Code)
+	}
+	if w := serve(handler, http.MethodPost, "/api/v1/bridges/open-bridge/disable", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected enable/disable to stay open without a token, got %d", w.Code)
+	}
+	// Secrets are never served without a token
+	if w := serve(handler, http.MethodGet, "/api/v1/bridges/open-bridge/config", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 from the config endpoint without a token, got %d", w.Code)
+	}
+
+	logs.Reset()
+	cfg.ApiConfig = &config.ApiConfig{AuthToken: "s3cret-token"}
+	NewServer(cfg, ":0", ctrl).routes()
+	if strings.Contains(logs.String(), "no AuthToken set") {
+		t.Fatalf("expected no warning with a token set, got:\n%s", logs.String())
+	}
+}
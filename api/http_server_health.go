@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"salmoncannon/status"
+)
+
+// healthDTO is the JSON shape returned by /healthz and /readyz
+type healthDTO struct {
+	Status string `json:"status"`
+}
+
+const (
+	healthOK       = "ok"
+	healthDraining = "draining"
+	healthNotReady = "not ready"
+)
+
+// SetDraining marks the process as shutting down. From then on /healthz and
+// /readyz answer 503 so load balancers stop sending new clients while the
+// bridges drain.
+func (s *Server) SetDraining() {
+	s.draining.Store(true)
+}
+
+// ready reports whether the process should get new clients: it is not
+// draining and, if it runs any near bridges, at least one of them has heard
+// from its far side recently. A far only process is ready while it runs.
+func (s *Server) ready() bool {
+	if s.draining.Load() {
+		return false
+	}
+	nears := 0
+	for _, b := range s.currentBridges() {
+		if !b.Connect {
+			continue
+		}
+		nears++
+		if status.GlobalConnMonitorRef.GetStatus(b.Name) {
+			return true
+		}
+	}
+	return nears == 0
+}
+
+// handleHealthz serves GET /healthz: 200 while the process runs, 503 once it
+// is draining for shutdown.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		writeHealth(w, r, http.StatusServiceUnavailable, healthDraining)
+		return
+	}
+	writeHealth(w, r, http.StatusOK, healthOK)
+}
+
+// handleReadyz serves GET /readyz: 200 once a near bridge can reach its far
+// side, 503 before then and while draining.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case s.draining.Load():
+		writeHealth(w, r, http.StatusServiceUnavailable, healthDraining)
+	case !s.ready():
+		writeHealth(w, r, http.StatusServiceUnavailable, healthNotReady)
+	default:
+		writeHealth(w, r, http.StatusOK, healthOK)
+	}
+}
+
+func writeHealth(w http.ResponseWriter, r *http.Request, code int, state string) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.WriteHeader(code)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(healthDTO{Status: state}); err != nil {
+		log.Printf("api: encode error: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"salmoncannon/config"
+)
+
+// unixClient is an HTTP client that dials the socket at path whatever the
+// request URL says.
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestServerUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	cfg := &config.SalmonCannonConfig{Bridges: []config.SalmonBridgeConfig{{Name: "unix-bridge"}}}
+	srv := NewServer(cfg, UnixPrefix+path, nil)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("socket not created: %v", err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != unixSocketMode {
+		t.Fatalf("expected a socket with mode %o, got %v", unixSocketMode, fi.Mode())
+	}
+
+	resp, err := unixClient(path).Get("http://salmoncannon/api/v1/bridges")
+	if err != nil {
+		t.Fatalf("request over the socket failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var bridges []bridgeDTO
+	if err := json.NewDecoder(resp.Body).Decode(&bridges); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || len(bridges) != 1 || bridges[0].Name != "unix-bridge" {
+		t.Fatalf("unexpected response %d: %+v", resp.StatusCode, bridges)
+	}
+
+	if err := srv.Stop(); err != nil {
+		t.Fatalf("stop failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket to be removed on stop, got %v", err)
+	}
+}
+
+func TestListenUnix_StaleAndBusySockets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+
+	// A socket left behind by a process that died is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	ln, err := listen(UnixPrefix + path)
+	if err != nil {
+		t.Fatalf("expected a stale socket to be replaced, got %v", err)
+	}
+	defer ln.Close()
+
+	// One that is still served is not
+	if _, err := listen(UnixPrefix + path); err == nil {
+		t.Fatalf("expected a socket in use to be refused")
+	}
+
+	// Nor is a file that is not a socket
+	file := filepath.Join(t.TempDir(), "api.sock")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := listen(UnixPrefix + file); err == nil {
+		t.Fatalf("expected a regular file to be refused")
+	}
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"salmoncannon/limiter"
+	"salmoncannon/status"
+)
+
+// metricsWriter builds a Prometheus text exposition (format 0.0.4).
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (m *metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(&m.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (m *metricsWriter) value(name string, v any) {
+	fmt.Fprintf(&m.buf, "%s %v\n", name, v)
+}
+
+func (m *metricsWriter) bridgeValue(name, bridge string, v any) {
+	fmt.Fprintf(&m.buf, "%s{bridge=\"%s\"} %v\n", name, escapeLabel(bridge), v)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	mon := status.GlobalConnMonitorRef
+	counts := mon.Counts()
+	var m metricsWriter
+
+	m.header("salmoncannon_active_socks_connections", "gauge", "SOCKS client connections currently open.")
+	m.value("salmoncannon_active_socks_connections", counts.ActiveSOCKS)
+	m.header("salmoncannon_socks_connections_total", "counter", "SOCKS client connections served since start.")
+	m.value("salmoncannon_socks_connections_total", counts.TotalSOCKS)
+	m.header("salmoncannon_active_http_connections", "gauge", "HTTP proxy client connections currently open.")
+	m.value("salmoncannon_active_http_connections", counts.ActiveHTTP)
+	m.header("salmoncannon_http_connections_total", "counter", "HTTP proxy client connections served since start.")
+	m.value("salmoncannon_http_connections_total", counts.TotalHTTP)
+	m.header("salmoncannon_active_redirect_connections", "gauge", "SOCKS redirector connections currently open.")
+	m.value("salmoncannon_active_redirect_connections", counts.ActiveRedirect)
+	m.header("salmoncannon_redirect_connections_total", "counter", "SOCKS redirector connections handed to a bridge since start.")
+	m.value("salmoncannon_redirect_connections_total", counts.TotalRedirect)
+	m.header("salmoncannon_active_out_connections", "gauge", "Far side connections to destinations currently open.")
+	m.value("salmoncannon_active_out_connections", counts.ActiveOUT)
+	m.header("salmoncannon_out_connections_total", "counter", "Far side connections to destinations made since start.")
+	m.value("salmoncannon_out_connections_total", counts.TotalOUT)
+
+	draining, ready := 0, 0
+	if s.draining.Load() {
+		draining = 1
+	}
+	if s.ready() {
+		ready = 1
+	}
+	m.header("salmoncannon_draining", "gauge", "1 while the process drains its bridges for shutdown.")
+	m.value("salmoncannon_draining", draining)
+	m.header("salmoncannon_ready", "gauge", "1 if /readyz reports ready.")
+	m.value("salmoncannon_ready", ready)
+
+	bridges := s.currentBridges()
+
+	m.header("salmoncannon_active_streams", "gauge", "QUIC streams currently open per bridge.")
+	for _, b := range bridges {
+		m.bridgeValue("salmoncannon_active_streams", b.Name, mon.GetStreamCount(b.Name))
+	}
+	m.header("salmoncannon_last_ping_ms", "gauge", "Round trip of the last status ping in milliseconds, -1 if none.")
+	for _, b := range bridges {
+		m.bridgeValue("salmoncannon_last_ping_ms", b.Name, mon.GetPing(b.Name))
+	}
+	m.header("salmoncannon_bridge_alive", "gauge", "1 if the bridge answered a status ping recently.")
+	for _, b := range bridges {
+		alive := 0
+		if mon.GetStatus(b.Name) {
+			alive = 1
+		}
+		m.bridgeValue("salmoncannon_bridge_alive", b.Name, alive)
+	}
+	m.header("salmoncannon_transferred_bytes_total", "counter", "Bytes passed through the bridge limiter.")
+	for _, b := range bridges {
+		if limiterInterface, ok := mon.GetLimiter(b.Name); ok {
+			if sl, ok := limiterInterface.(*limiter.SharedLimiter); ok {
+				m.bridgeValue("salmoncannon_transferred_bytes_total", b.Name, sl.GetBytesTransferred())
+			}
+		}
+	}
+
+	rejections := []struct {
+		name, help string
+		value      func(status.RejectCounts) int64
+	}{
+		{"salmoncannon_socks_handshake_failures_total", "SOCKS requests that could not be parsed or authenticated.",
+			func(c status.RejectCounts) int64 { return c.HandshakeFailures }},
+		{"salmoncannon_socks_auth_method_mismatch_total", "SOCKS5 clients that offered no auth method the bridge accepts.",
+			func(c status.RejectCounts) int64 { return c.AuthMismatch }},
+		{"salmoncannon_allowlist_blocks_total", "Clients or targets refused by an allowed in/out address list.",
+			func(c status.RejectCounts) int64 { return c.AllowlistBlocks }},
+		{"salmoncannon_pool_saturated_total", "Streams refused because every QUIC connection was at its stream limit.",
+			func(c status.RejectCounts) int64 { return c.PoolSaturated }},
+		{"salmoncannon_dial_failures_total", "Targets that could not be connected to.",
+			func(c status.RejectCounts) int64 { return c.DialFailures }},
+		{"salmoncannon_client_limit_total", "Clients refused because the bridge was at SBMaxConcurrentClients.",
+			func(c status.RejectCounts) int64 { return c.ClientLimit }},
+		{"salmoncannon_client_rate_limit_total", "Connections refused because their client IP exceeded SBPerClientConnRate.",
+			func(c status.RejectCounts) int64 { return c.ClientRateLimit }},
+		{"salmoncannon_out_client_limit_total", "Target connections refused because their client was at SBMaxOutPerClient.",
+			func(c status.RejectCounts) int64 { return c.OutClientLimit }},
+	}
+	for _, r := range rejections {
+		m.header(r.name, "counter", r.help)
+		for _, b := range bridges {
+			m.bridgeValue(r.name, b.Name, r.value(mon.Rejections(b.Name)))
+		}
+	}
+
+	m.header("salmoncannon_uncompressed_bytes_total", "counter", "Stream payload bytes before compression, both directions.")
+	for _, b := range bridges {
+		m.bridgeValue("salmoncannon_uncompressed_bytes_total", b.Name, mon.Compression(b.Name).UncompressedBytes)
+	}
+	m.header("salmoncannon_compressed_bytes_total", "counter", "The same payload bytes as they crossed the tunnel compressed.")
+	for _, b := range bridges {
+		m.bridgeValue("salmoncannon_compressed_bytes_total", b.Name, mon.Compression(b.Name).CompressedBytes)
+	}
+	m.header("salmoncannon_compression_ratio", "gauge", "Uncompressed over compressed bytes since start, 1 when nothing was compressed.")
+	for _, b := range bridges {
+		m.bridgeValue("salmoncannon_compression_ratio", b.Name, mon.Compression(b.Name).Ratio())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := w.Write(m.buf.Bytes()); err != nil {
+		log.Printf("api: metrics write error: %v", err)
+	}
+}
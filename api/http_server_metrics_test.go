@@ -0,0 +1,100 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"salmoncannon/config"
+	"salmoncannon/limiter"
+	"salmoncannon/status"
+)
+
+func TestHandleMetrics_PrometheusText(t *testing.T) {
+	cfg := &config.SalmonCannonConfig{
+		Bridges: []config.SalmonBridgeConfig{
+			{Name: "metrics-one"},
+			{Name: "metrics-two"},
+		},
+	}
+
+	status.GlobalConnMonitorRef.RegisterLimiter("metrics-one", limiter.NewSharedLimiter(1024))
+	status.GlobalConnMonitorRef.ResetStreamCount("metrics-one")
+	status.GlobalConnMonitorRef.AddStream("metrics-one")
+	status.GlobalConnMonitorRef.AddStream("metrics-one")
+	status.GlobalConnMonitorRef.RegisterPing("metrics-one", 42)
+	status.GlobalConnMonitorRef.IncDialFailure("metrics-one")
+	status.GlobalConnMonitorRef.IncAllowlistBlock("metrics-one")
+	status.GlobalConnMonitorRef.IncAllowlistBlock("metrics-one")
+
+	srv := NewServer(cfg, ":0", nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+
+	srv.handleMetrics(w, req)
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("unexpected content type: %s", ct)
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	text := string(body)
+
+	want := []string{
+		"# TYPE salmoncannon_active_socks_connections gauge",
+		"# TYPE salmoncannon_socks_connections_total counter",
+		"# TYPE salmoncannon_active_redirect_connections gauge",
+		"# TYPE salmoncannon_active_out_connections gauge",
+		`salmoncannon_active_streams{bridge="metrics-one"} 2`,
+		`salmoncannon_active_streams{bridge="metrics-two"} 0`,
+		`salmoncannon_last_ping_ms{bridge="metrics-one"} 42`,
+		`salmoncannon_last_ping_ms{bridge="metrics-two"} -1`,
+		`salmoncannon_bridge_alive{bridge="metrics-one"} 1`,
+		`salmoncannon_bridge_alive{bridge="metrics-two"} 0`,
+		`salmoncannon_transferred_bytes_total{bridge="metrics-one"} 0`,
+		"# TYPE salmoncannon_dial_failures_total counter",
+		`salmoncannon_dial_failures_total{bridge="metrics-one"} 1`,
+		`salmoncannon_allowlist_blocks_total{bridge="metrics-one"} 2`,
+		`salmoncannon_pool_saturated_total{bridge="metrics-two"} 0`,
+		`salmoncannon_socks_handshake_failures_total{bridge="metrics-two"} 0`,
+		`salmoncannon_socks_auth_method_mismatch_total{bridge="metrics-two"} 0`,
+		`salmoncannon_client_limit_total{bridge="metrics-two"} 0`,
+		`salmoncannon_client_rate_limit_total{bridge="metrics-two"} 0`,
+	}
+	for _, line := range want {
+		if !strings.Contains(text, line) {
+			t.Errorf("metrics output missing %q\n%s", line, text)
+		}
+	}
+	if strings.Contains(text, `salmoncannon_transferred_bytes_total{bridge="metrics-two"}`) {
+		t.Errorf("expected no transferred bytes for bridge without a limiter")
+	}
+}
+
+func TestHandleMetrics_MethodNotAllowed(t *testing.T) {
+	srv := NewServer(&config.SalmonCannonConfig{}, ":0", nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/metrics", nil)
+	w := httptest.NewRecorder()
+
+	srv.handleMetrics(w, req)
+
+	if w.Result().StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405 got %d", w.Result().StatusCode)
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got := escapeLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Fatalf("unexpected escape: %s", got)
+	}
+}
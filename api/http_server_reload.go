@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// ErrReloadInProgress is returned by a Reloader while another reload runs.
+var ErrReloadInProgress = errors.New("a reload is already in progress")
+
+// ReloadSummary lists the bridges a reload touched by name.
+type ReloadSummary struct {
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Recreated []string `json:"recreated"`
+	Updated   []string `json:"updated"`
+}
+
+// Reloader re-reads the config and applies its bridges, as SIGHUP does. A
+// config that fails to load leaves the running bridges untouched.
+type Reloader interface {
+	Reload() (ReloadSummary, error)
+}
+
+// SetReloader sets what POST /api/v1/reload runs. Without one the endpoint
+// answers 404.
+func (s *Server) SetReloader(r Reloader) {
+	s.bridgesMu.Lock()
+	defer s.bridgesMu.Unlock()
+	s.reloader = r
+}
+
+// reloadDTO is the JSON shape returned by the reload endpoint
+type reloadDTO struct {
+	ReloadSummary
+	Error string `json:"error,omitempty"`
+}
+
+// handleReload serves POST /api/v1/reload: it reloads the config and
+// returns what changed. It answers 409 while another reload, from the API
+// or SIGHUP, is running and 500 with the error when the config does not
+// load or a bridge fails to start. It is forbidden when no AuthToken is
+// set.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// requireToken has checked the token, if there is one
+	if s.authToken() == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	s.bridgesMu.RLock()
+	reloader := s.reloader
+	s.bridgesMu.RUnlock()
+	if reloader == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	log.Printf("api: reloading config")
+	summary, err := reloader.Reload()
+	resp := reloadDTO{ReloadSummary: summary}
+	code := http.StatusOK
+	if err != nil {
+		resp.Error = err.Error()
+		code = http.StatusInternalServerError
+		if errors.Is(err, ErrReloadInProgress) {
+			code = http.StatusConflict
+		}
+		log.Printf("api: reload failed: %v", err)
+	}
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(resp); err != nil {
+		log.Printf("api: encode error: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"salmoncannon/config"
+)
+
+type fakeReloader struct {
+	summary ReloadSummary
+	err     error
+	calls   int
+}
+
+func (f *fakeReloader) Reload() (ReloadSummary, error) {
+	f.calls++
+	return f.summary, f.err
+}
+
+func TestHandleReload(t *testing.T) {
+	cfg := &config.SalmonCannonConfig{ApiConfig: &config.ApiConfig{AuthToken: "s3cret-token"}}
+	srv := NewServer(cfg, ":0", nil)
+	handler := srv.routes()
+
+	if w := serve(handler, http.MethodPost, "/api/v1/reload", "s3cret-token"); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without a reloader, got %d", w.Code)
+	}
+
+	reloader := &fakeReloader{summary: ReloadSummary{Added: []string{"new"}, Removed: []string{}, Recreated: []string{}, Updated: []string{"old"}}}
+	srv.SetReloader(reloader)
+
+	if w := serve(handler, http.MethodPost, "/api/v1/reload", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the token, got %d", w.Code)
+	}
+	if w := serve(handler, http.MethodGet, "/api/v1/reload", "s3cret-token"); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for GET, got %d", w.Code)
+	}
+	if reloader.calls != 0 {
+		t.Fatalf("expected no reload from rejected requests, got %d", reloader.calls)
+	}
+
+	w := serve(handler, http.MethodPost, "/api/v1/reload", "s3cret-token")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var got reloadDTO
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode %s: %v", w.Body, err)
+	}
+	if len(got.Added) != 1 || got.Added[0] != "new" || len(got.Updated) != 1 || got.Error != "" {
+		t.Fatalf("unexpected summary %+v", got)
+	}
+
+	reloader.err = ErrReloadInProgress
+	if w := serve(handler, http.MethodPost, "/api/v1/reload", "s3cret-token"); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 while a reload runs, got %d", w.Code)
+	}
+
+	reloader.summary = ReloadSummary{}
+	reloader.err = errors.New("failed to load config: yaml: line 1: did not find expected node content")
+	w = serve(handler, http.MethodPost, "/api/v1/reload", "s3cret-token")
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 for an invalid config, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "did not find expected node content") {
+		t.Fatalf("expected the parse error in the response, got %s", w.Body)
+	}
+}
+
+func TestHandleReload_NoTokenConfigured(t *testing.T) {
+	srv := NewServer(&config.SalmonCannonConfig{}, ":0", nil)
+	reloader := &fakeReloader{}
+	srv.SetReloader(reloader)
+	if w := serve(srv.routes(), http.MethodPost, "/api/v1/reload", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without an AuthToken, got %d", w.Code)
+	}
+	if reloader.calls != 0 {
+		t.Fatalf("expected no reload without an AuthToken")
+	}
+}
@@ -1,10 +1,13 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"salmoncannon/config"
 	"salmoncannon/limiter"
@@ -30,8 +33,11 @@ func TestHandleStatus_ReturnsJSONList(t *testing.T) {
 	limiter2 := limiter.NewSharedLimiter(512 * 1024)
 	status.GlobalConnMonitorRef.RegisterLimiter("bridge-one", limiter1)
 	status.GlobalConnMonitorRef.RegisterLimiter("bridge-two", limiter2)
+	status.GlobalConnMonitorRef.RegisterEndpoint("bridge-one", "10.0.0.2")
+	status.GlobalConnMonitorRef.IncPoolSaturated("bridge-one")
+	status.GlobalConnMonitorRef.RegisterError("bridge-two", errors.New("connection refused"))
 
-	srv := NewServer(cfg, ":0")
+	srv := NewServer(cfg, ":0", nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/status", nil)
 	w := httptest.NewRecorder()
@@ -63,6 +69,16 @@ func TestHandleStatus_ReturnsJSONList(t *testing.T) {
 		t.Fatalf("unexpected max rate: got %d want %d", list[0].MaxRateBitsPerSec, expectedMaxBps)
 	}
 
+	if list[0].ActiveEndpoint != "10.0.0.2" {
+		t.Fatalf("unexpected active endpoint: %q", list[0].ActiveEndpoint)
+	}
+	if list[0].PoolSaturated != 1 || list[0].DialFailures != 0 {
+		t.Fatalf("unexpected rejection counts: pool %d dial %d", list[0].PoolSaturated, list[0].DialFailures)
+	}
+	if list[0].LastError != "" || !list[0].LastErrorTime.IsZero() {
+		t.Fatalf("expected no last error, got %q", list[0].LastError)
+	}
+
 	// Check bridge-two
 	if list[1].BridgeName != "bridge-two" {
 		t.Fatalf("unexpected bridge name: %s", list[1].BridgeName)
@@ -71,11 +87,14 @@ func TestHandleStatus_ReturnsJSONList(t *testing.T) {
 	if list[1].MaxRateBitsPerSec != expectedMaxBps2 {
 		t.Fatalf("unexpected max rate: got %d want %d", list[1].MaxRateBitsPerSec, expectedMaxBps2)
 	}
+	if list[1].LastError != "connection refused" || list[1].LastErrorTime.IsZero() {
+		t.Fatalf("unexpected last error: %q at %v", list[1].LastError, list[1].LastErrorTime)
+	}
 }
 
 func TestHandleStatus_MethodNotAllowed(t *testing.T) {
 	cfg := &config.SalmonCannonConfig{}
-	srv := NewServer(cfg, ":0")
+	srv := NewServer(cfg, ":0", nil)
 
 	req := httptest.NewRequest(http.MethodPost, "/api/v1/status", nil)
 	w := httptest.NewRecorder()
@@ -89,3 +108,46 @@ func TestHandleStatus_MethodNotAllowed(t *testing.T) {
 		t.Fatalf("expected status 405 got %d", res.StatusCode)
 	}
 }
+
+func TestHandleStatusHistory(t *testing.T) {
+	cfg := &config.SalmonCannonConfig{
+		Bridges: []config.SalmonBridgeConfig{{Name: "history-bridge"}},
+	}
+	status.GlobalConnMonitorRef.RegisterLimiter("history-bridge", limiter.NewSharedLimiter(1024))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	status.GlobalConnMonitorRef.StartRateHistory(ctx, 10*time.Millisecond, 50)
+	time.Sleep(60 * time.Millisecond)
+
+	srv := NewServer(cfg, ":0", nil)
+
+	w := httptest.NewRecorder()
+	srv.handleStatusHistory(w, httptest.NewRequest(http.MethodGet, "/api/v1/status/history?bridge=history-bridge", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", w.Code)
+	}
+	var hist statusHistoryDTO
+	if err := json.NewDecoder(w.Body).Decode(&hist); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if hist.BridgeName != "history-bridge" {
+		t.Errorf("unexpected bridge name: %s", hist.BridgeName)
+	}
+	if hist.IntervalMs != 10 {
+		t.Errorf("unexpected interval: %d", hist.IntervalMs)
+	}
+	if len(hist.Samples) == 0 {
+		t.Errorf("expected samples in history")
+	}
+
+	for target, want := range map[string]int{
+		"/api/v1/status/history":                http.StatusBadRequest,
+		"/api/v1/status/history?bridge=missing": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		srv.handleStatusHistory(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != want {
+			t.Errorf("%s: expected status %d got %d", target, want, w.Code)
+		}
+	}
+}
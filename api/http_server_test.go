@@ -12,13 +12,18 @@ import (
 	"fmt"
 	"io"
 	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"salmoncannon/config"
+	"salmoncannon/connections"
+	"salmoncannon/limiter"
+	"salmoncannon/status"
 )
 
 func TestHandleBridges_ReturnsJSONList(t *testing.T) {
@@ -29,7 +34,7 @@ func TestHandleBridges_ReturnsJSONList(t *testing.T) {
 		},
 	}
 
-	srv := NewServer(cfg, ":0")
+	srv := NewServer(cfg, ":0", nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/bridges", nil)
 	w := httptest.NewRecorder()
@@ -144,7 +149,7 @@ func TestServerTLS_WithValidCert(t *testing.T) {
 		},
 	}
 
-	srv := NewServer(cfg, "127.0.0.1:0")
+	srv := NewServer(cfg, "127.0.0.1:0", nil)
 	if err := srv.Start(); err != nil {
 		t.Fatalf("failed to start server: %v", err)
 	}
@@ -199,7 +204,7 @@ func TestServerHTTP_WithoutTLSConfig(t *testing.T) {
 		ApiConfig: nil, // No TLS config
 	}
 
-	srv := NewServer(cfg, "127.0.0.1:0")
+	srv := NewServer(cfg, "127.0.0.1:0", nil)
 	if err := srv.Start(); err != nil {
 		t.Fatalf("failed to start server: %v", err)
 	}
@@ -236,3 +241,398 @@ func TestServerHTTP_WithoutTLSConfig(t *testing.T) {
 		t.Fatalf("unexpected response: %+v", bridges)
 	}
 }
+
+type fakeController struct {
+	state map[string]bool
+}
+
+func (f *fakeController) SetBridgeEnabled(name string, enabled bool) bool {
+	if _, ok := f.state[name]; !ok {
+		return false
+	}
+	f.state[name] = enabled
+	return true
+}
+
+func TestHandleBridgeToggle(t *testing.T) {
+	ctrl := &fakeController{state: map[string]bool{"bridge-one": true}}
+	srv := NewServer(&config.SalmonCannonConfig{}, ":0", ctrl)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/bridges/{name}/{action}", srv.handleBridgeToggle)
+
+	cases := []struct {
+		method string
+		path   string
+		code   int
+		state  bool
+	}{
+		{http.MethodPost, "/api/v1/bridges/bridge-one/disable", http.StatusOK, false},
+		{http.MethodPost, "/api/v1/bridges/bridge-one/enable", http.StatusOK, true},
+		{http.MethodPost, "/api/v1/bridges/missing/disable", http.StatusNotFound, true},
+		{http.MethodPost, "/api/v1/bridges/bridge-one/explode", http.StatusNotFound, true},
+		{http.MethodGet, "/api/v1/bridges/bridge-one/disable", http.StatusMethodNotAllowed, true},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		res := w.Result()
+		if res.StatusCode != c.code {
+			t.Fatalf("%s %s: expected status %d got %d", c.method, c.path, c.code, res.StatusCode)
+		}
+		if c.code == http.StatusOK {
+			var got bridgeStateDTO
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Name != "bridge-one" || got.Enabled != c.state {
+				t.Fatalf("%s: unexpected response %+v", c.path, got)
+			}
+		}
+		res.Body.Close()
+		if ctrl.state["bridge-one"] != c.state {
+			t.Fatalf("%s: expected bridge enabled=%v", c.path, c.state)
+		}
+	}
+}
+
+func TestHandleBridgeToggle_NoController(t *testing.T) {
+	srv := NewServer(&config.SalmonCannonConfig{}, ":0", nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/bridges/{name}/{action}", srv.handleBridgeToggle)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/bridges/bridge-one/disable", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404 got %d", w.Result().StatusCode)
+	}
+}
+
+func TestHandleBridgeRateLimit(t *testing.T) {
+	lim := limiter.NewSharedLimiter(1024 * 1024)
+	status.GlobalConnMonitorRef.RegisterLimiter("rate-bridge", lim)
+	srv := NewServer(&config.SalmonCannonConfig{}, ":0", nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/bridges/{name}/{action}", srv.handleBridgeToggle)
+	mux.HandleFunc("/api/v1/bridges/{name}/ratelimit", srv.handleBridgeRateLimit)
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+		rate   int64 // expected response and limiter rate, 0 for unlimited
+	}{
+		{http.MethodPut, "/api/v1/bridges/rate-bridge/ratelimit", `{"bytes_per_sec": 65536}`, http.StatusOK, 65536},
+		{http.MethodPut, "/api/v1/bridges/rate-bridge/ratelimit", `{"bytes_per_sec": -1}`, http.StatusBadRequest, 65536},
+		{http.MethodPut, "/api/v1/bridges/rate-bridge/ratelimit", `not json`, http.StatusBadRequest, 65536},
+		{http.MethodPut, "/api/v1/bridges/missing/ratelimit", `{"bytes_per_sec": 1}`, http.StatusNotFound, 65536},
+		{http.MethodGet, "/api/v1/bridges/rate-bridge/ratelimit", "", http.StatusMethodNotAllowed, 65536},
+		{http.MethodPut, "/api/v1/bridges/rate-bridge/ratelimit", `{"bytes_per_sec": 0}`, http.StatusOK, 0},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		res := w.Result()
+		if res.StatusCode != c.code {
+			t.Fatalf("%s %s %s: expected status %d got %d", c.method, c.path, c.body, c.code, res.StatusCode)
+		}
+		if c.code == http.StatusOK {
+			var got bridgeRateDTO
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Name != "rate-bridge" || got.BytesPerSec != c.rate {
+				t.Fatalf("%s: unexpected response %+v", c.body, got)
+			}
+		}
+		res.Body.Close()
+
+		if c.rate == 0 {
+			if !lim.Unlimited() {
+				t.Fatalf("%s: expected the limiter to be unlimited, got %d", c.body, lim.GetMaxRate())
+			}
+		} else if got := lim.GetMaxRate(); got != c.rate {
+			t.Fatalf("%s: expected max rate %d got %d", c.body, c.rate, got)
+		}
+	}
+}
+
+func TestHandleBridgeConnections(t *testing.T) {
+	sq := connections.NewSalmonQuic(0, "127.0.0.1", "conns-bridge", nil, nil, "")
+	defer sq.Close()
+	srv := NewServer(&config.SalmonCannonConfig{}, ":0", nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/bridges/{name}/{action}", srv.handleBridgeToggle)
+	mux.HandleFunc("/api/v1/bridges/{name}/connections", srv.handleBridgeConnections)
+
+	cases := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/api/v1/bridges/conns-bridge/connections", http.StatusOK},
+		{http.MethodGet, "/api/v1/bridges/missing/connections", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/bridges/conns-bridge/connections", http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		res := w.Result()
+		if res.StatusCode != c.code {
+			t.Fatalf("%s %s: expected status %d got %d", c.method, c.path, c.code, res.StatusCode)
+		}
+		if c.code == http.StatusOK {
+			// A pool that has not dialed yet has no connections
+			var got []connectionDTO
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got == nil || len(got) != 0 {
+				t.Fatalf("expected an empty list, got %+v", got)
+			}
+		}
+		res.Body.Close()
+	}
+}
+
+func TestHandleHealthAndReadiness(t *testing.T) {
+	cfg := &config.SalmonCannonConfig{
+		Bridges: []config.SalmonBridgeConfig{
+			{Name: "health-far"},
+			{Name: "health-near", Connect: true},
+		},
+	}
+	srv := NewServer(cfg, ":0", nil)
+
+	check := func(state, path string, handler http.HandlerFunc, code int, want string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, path, nil))
+		res := w.Result()
+		defer res.Body.Close()
+		if res.StatusCode != code {
+			t.Fatalf("%s: %s expected status %d got %d", state, path, code, res.StatusCode)
+		}
+		var got healthDTO
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatalf("%s: failed to decode %s: %v", state, path, err)
+		}
+		if got.Status != want {
+			t.Fatalf("%s: %s expected %q got %q", state, path, want, got.Status)
+		}
+	}
+
+	// The near has not reached its far side yet
+	check("not ready", "/healthz", srv.handleHealthz, http.StatusOK, healthOK)
+	check("not ready", "/readyz", srv.handleReadyz, http.StatusServiceUnavailable, healthNotReady)
+
+	status.GlobalConnMonitorRef.RegisterPing("health-near", 5)
+	check("ready", "/healthz", srv.handleHealthz, http.StatusOK, healthOK)
+	check("ready", "/readyz", srv.handleReadyz, http.StatusOK, healthOK)
+
+	srv.SetDraining()
+	check("draining", "/healthz", srv.handleHealthz, http.StatusServiceUnavailable, healthDraining)
+	check("draining", "/readyz", srv.handleReadyz, http.StatusServiceUnavailable, healthDraining)
+
+	w := httptest.NewRecorder()
+	srv.handleHealthz(w, httptest.NewRequest(http.MethodPost, "/healthz", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for POST, got %d", w.Code)
+	}
+}
+
+func TestHandleReadyz_FarOnly(t *testing.T) {
+	cfg := &config.SalmonCannonConfig{
+		Bridges: []config.SalmonBridgeConfig{{Name: "ready-far-only"}},
+	}
+	srv := NewServer(cfg, ":0", nil)
+
+	w := httptest.NewRecorder()
+	srv.handleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected a far only process to be ready, got %d", w.Code)
+	}
+}
+
+func TestHandleBridgeConfig(t *testing.T) {
+	cfg := &config.SalmonCannonConfig{
+		ApiConfig: &config.ApiConfig{AuthToken: "s3cret-token"},
+		Bridges: []config.SalmonBridgeConfig{
+			{Name: "cfg-bridge", Connect: true, FarIp: "10.0.0.1", SharedSecret: "hunter2",
+				SocksUsers: map[string]string{"alice": "password"}},
+		},
+	}
+	cfg.SetDefaults()
+	srv := NewServer(cfg, ":0", nil)
+	mux := srv.routes()
+
+	get := func(path, token string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	if res := get("/api/v1/bridges/cfg-bridge/config", ""); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", res.StatusCode)
+	}
+	if res := get("/api/v1/bridges/cfg-bridge/config", "wrong"); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with a wrong token, got %d", res.StatusCode)
+	}
+	if res := get("/api/v1/bridges/missing/config", "s3cret-token"); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown bridge, got %d", res.StatusCode)
+	}
+
+	res := get("/api/v1/bridges/cfg-bridge/config", "s3cret-token")
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", res.StatusCode)
+	}
+	body, _ := io.ReadAll(res.Body)
+	if strings.Contains(string(body), "hunter2") || strings.Contains(string(body), "password") {
+		t.Fatalf("secrets leaked in config: %s", body)
+	}
+	var list []map[string]any
+	if err := json.Unmarshal(body, &list); err != nil || len(list) != 1 {
+		t.Fatalf("failed to decode response %s: %v", body, err)
+	}
+	got := list[0]
+	if got["SBSharedSecret"] != redacted {
+		t.Errorf("expected SBSharedSecret to be redacted, got %v", got["SBSharedSecret"])
+	}
+	if users, _ := got["SBSocksUsers"].(map[string]any); users["alice"] != redacted {
+		t.Errorf("expected SBSocksUsers passwords to be redacted, got %v", got["SBSocksUsers"])
+	}
+	// Not in the config, so this is SetDefaults' value
+	if got["SBIdleTimeout"] != "1m0s" {
+		t.Errorf("expected default SBIdleTimeout 1m0s, got %v", got["SBIdleTimeout"])
+	}
+	if got["SBFarIp"] != "10.0.0.1" {
+		t.Errorf("unexpected SBFarIp %v", got["SBFarIp"])
+	}
+}
+
+func TestHandleBridgeConfig_NoTokenConfigured(t *testing.T) {
+	cfg := &config.SalmonCannonConfig{Bridges: []config.SalmonBridgeConfig{{Name: "cfg-bridge"}}}
+	srv := NewServer(cfg, ":0", nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/bridges/cfg-bridge/config", nil)
+	req.SetPathValue("name", "cfg-bridge")
+	w := httptest.NewRecorder()
+	srv.handleBridgeConfig(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without an AuthToken, got %d", w.Code)
+	}
+}
+
+func TestHandleStatus_IngressCounts(t *testing.T) {
+	cfg := &config.SalmonCannonConfig{Bridges: []config.SalmonBridgeConfig{{Name: "ingress-api"}}}
+	srv := NewServer(cfg, ":0", nil)
+
+	mon := status.GlobalConnMonitorRef
+	mon.IncSOCKS("ingress-api")
+	mon.IncHTTP("ingress-api")
+	mon.DecHTTP("ingress-api")
+	mon.IncRedirect("ingress-api")
+	mon.IncRedirect("ingress-api")
+	defer func() {
+		mon.DecSOCKS("ingress-api")
+		mon.DecRedirect("ingress-api")
+		mon.DecRedirect("ingress-api")
+	}()
+
+	w := httptest.NewRecorder()
+	srv.handleStatus(w, httptest.NewRequest(http.MethodGet, "/api/v1/status", nil))
+
+	var list []map[string]any
+	if err := json.NewDecoder(w.Result().Body).Decode(&list); err != nil || len(list) != 1 {
+		t.Fatalf("failed to decode status: %v (%d entries)", err, len(list))
+	}
+	want := map[string]float64{
+		"active_socks": 1, "total_socks": 1,
+		"active_http": 0, "total_http": 1,
+		"active_redirect": 2, "total_redirect": 2,
+		// No compressed streams, so the ratio stays at 1
+		"uncompressed_bytes": 0, "compressed_bytes": 0, "compression_ratio": 1,
+	}
+	for field, v := range want {
+		if list[0][field] != v {
+			t.Errorf("expected %s %v, got %v", field, v, list[0][field])
+		}
+	}
+}
+
+func TestServerTLS_MinVersion(t *testing.T) {
+	certFile, keyFile := generateTestCert(t)
+	cfg := &config.SalmonCannonConfig{
+		Bridges:   []config.SalmonBridgeConfig{{Name: "test-bridge"}},
+		ApiConfig: &config.ApiConfig{TLSCert: certFile, TLSKey: keyFile, TLSMinVersion: config.TLSVersion13},
+	}
+	srv := NewServer(cfg, "127.0.0.1:0", nil)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer srv.Stop()
+	addr := srv.ln.Addr().String()
+
+	dial := func(version uint16) error {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", addr,
+			&tls.Config{InsecureSkipVerify: true, MinVersion: version, MaxVersion: version})
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	if err := dial(tls.VersionTLS11); err == nil {
+		t.Fatalf("expected a TLS 1.1 client to be rejected")
+	}
+	if err := dial(tls.VersionTLS12); err == nil {
+		t.Fatalf("expected a TLS 1.2 client to be rejected")
+	}
+	if err := dial(tls.VersionTLS13); err != nil {
+		t.Fatalf("expected a TLS 1.3 client to connect, got %v", err)
+	}
+}
+
+func TestServerTLS_CipherSuites(t *testing.T) {
+	certFile, keyFile := generateTestCert(t)
+	cfg := &config.SalmonCannonConfig{
+		Bridges: []config.SalmonBridgeConfig{{Name: "test-bridge"}},
+		ApiConfig: &config.ApiConfig{TLSCert: certFile, TLSKey: keyFile,
+			TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}},
+	}
+	srv := NewServer(cfg, "127.0.0.1:0", nil)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	dial := func(suites []uint16) (*tls.Conn, error) {
+		return tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", srv.ln.Addr().String(),
+			&tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12, CipherSuites: suites})
+	}
+	conn, err := dial(nil)
+	if err != nil {
+		t.Fatalf("TLS 1.2 handshake failed: %v", err)
+	}
+	defer conn.Close()
+	if got := conn.ConnectionState().CipherSuite; got != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Fatalf("expected the configured suite, got %s", tls.CipherSuiteName(got))
+	}
+	if _, err := dial([]uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256}); err == nil {
+		t.Fatalf("expected a client without the configured suite to be rejected")
+	}
+}
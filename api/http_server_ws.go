@@ -0,0 +1,80 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Most live status streams served at once. Further upgrades get a 503.
+var maxStatusSubscribers int32 = 16
+
+// How often live status streams push a snapshot.
+var statusPushInterval = time.Second
+
+// statusWSMessage is one websocket frame. "status" frames carry the same
+// list as /api/v1/status; "event" frames report a bridge going up or down.
+type statusWSMessage struct {
+	Type    string      `json:"type"`
+	Bridges []statusDTO `json:"bridges,omitempty"`
+	Bridge  string      `json:"bridge,omitempty"`
+	Alive   *bool       `json:"alive,omitempty"`
+}
+
+// handleStatusWS serves /api/v1/status/ws, pushing a status snapshot every
+// statusPushInterval plus an event whenever a bridge's alive state changes.
+func (s *Server) handleStatusWS(w http.ResponseWriter, r *http.Request) {
+	if s.wsSubscribers.Add(1) > maxStatusSubscribers {
+		s.wsSubscribers.Add(-1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	defer s.wsSubscribers.Add(-1)
+
+	// websocket.Server skips the Origin check websocket.Handler does; the
+	// rest of the API has no browser origin restrictions either
+	websocket.Server{Handler: s.streamStatus}.ServeHTTP(w, r)
+}
+
+func (s *Server) streamStatus(ws *websocket.Conn) {
+	defer ws.Close()
+
+	// Clients only listen, so a failed read means they went away
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, ws)
+		close(gone)
+	}()
+
+	ticker := time.NewTicker(statusPushInterval)
+	defer ticker.Stop()
+
+	alive := make(map[string]bool)
+	for {
+		list := s.statusSnapshot()
+		for _, st := range list {
+			was, seen := alive[st.BridgeName]
+			alive[st.BridgeName] = st.Alive
+			if !seen || was == st.Alive {
+				continue
+			}
+			now := st.Alive
+			if err := websocket.JSON.Send(ws, statusWSMessage{Type: "event", Bridge: st.BridgeName, Alive: &now}); err != nil {
+				return
+			}
+		}
+		if err := websocket.JSON.Send(ws, statusWSMessage{Type: "status", Bridges: list}); err != nil {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-gone:
+			return
+		case <-s.wsDone:
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"salmoncannon/config"
+	"salmoncannon/status"
+
+	"golang.org/x/net/websocket"
+)
+
+func dialStatusWS(t *testing.T, ts *httptest.Server) (*websocket.Conn, error) {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/v1/status/ws"
+	return websocket.Dial(url, "", ts.URL)
+}
+
+func TestHandleStatusWS_PushesSnapshots(t *testing.T) {
+	oldInterval := statusPushInterval
+	statusPushInterval = 50 * time.Millisecond
+	defer func() { statusPushInterval = oldInterval }()
+
+	cfg := &config.SalmonCannonConfig{
+		Bridges: []config.SalmonBridgeConfig{{Name: "ws-bridge", TotalBandwidthLimit: config.SizeString(1024)}},
+	}
+	srv := NewServer(cfg, ":0", nil)
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleStatusWS))
+	defer ts.Close()
+	defer srv.Stop()
+
+	ws, err := dialStatusWS(t, ts)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var msg statusWSMessage
+	for i := 0; i < 2; i++ {
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatalf("frame %d: receive failed: %v", i, err)
+		}
+		if msg.Type != "status" {
+			t.Fatalf("frame %d: expected status frame, got %q", i, msg.Type)
+		}
+		if len(msg.Bridges) != 1 || msg.Bridges[0].BridgeName != "ws-bridge" {
+			t.Fatalf("frame %d: unexpected bridges %+v", i, msg.Bridges)
+		}
+		if msg.Bridges[0].MaxRateBitsPerSec != 1024*8 {
+			t.Fatalf("frame %d: unexpected max rate %d", i, msg.Bridges[0].MaxRateBitsPerSec)
+		}
+	}
+
+	// The bridge coming up is reported as an event before the next snapshot
+	status.GlobalConnMonitorRef.RegisterPing("ws-bridge", 3)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		msg = statusWSMessage{}
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatalf("receive failed: %v", err)
+		}
+		if msg.Type == "event" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no up event received")
+		}
+	}
+	if msg.Bridge != "ws-bridge" || msg.Alive == nil || !*msg.Alive {
+		t.Fatalf("unexpected event %+v", msg)
+	}
+}
+
+func TestHandleStatusWS_CapsSubscribers(t *testing.T) {
+	oldMax := maxStatusSubscribers
+	maxStatusSubscribers = 1
+	defer func() { maxStatusSubscribers = oldMax }()
+
+	srv := NewServer(&config.SalmonCannonConfig{}, ":0", nil)
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleStatusWS))
+	defer ts.Close()
+	defer srv.Stop()
+
+	first, err := dialStatusWS(t, ts)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	if _, err := dialStatusWS(t, ts); err == nil {
+		t.Fatalf("expected second subscriber to be refused")
+	}
+
+	// A disconnect frees the slot
+	first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.wsSubscribers.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	second, err := dialStatusWS(t, ts)
+	if err != nil {
+		t.Fatalf("expected a slot after the first client left: %v", err)
+	}
+	second.Close()
+}
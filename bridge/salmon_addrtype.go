@@ -0,0 +1,104 @@
+package bridge
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"salmoncannon/config"
+)
+
+// Address types carried after ADDR_TYPE_HEADER. The values match SOCKS5 ATYP.
+const (
+	AddrTypeIPv4   = 0x01
+	AddrTypeDomain = 0x03
+	AddrTypeIPv6   = 0x04
+)
+
+// AddrTypeOf infers the address type of host: an IP literal is IPv4 or
+// IPv6, anything else is a domain name.
+func AddrTypeOf(host string) byte {
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return AddrTypeDomain
+	case ip.To4() != nil:
+		return AddrTypeIPv4
+	}
+	return AddrTypeIPv6
+}
+
+func addrTypeName(addrType byte) string {
+	switch addrType {
+	case AddrTypeIPv4:
+		return config.AddressTypeIPv4
+	case AddrTypeDomain:
+		return config.AddressTypeDomain
+	case AddrTypeIPv6:
+		return config.AddressTypeIPv6
+	}
+	return fmt.Sprintf("0x%02x", addrType)
+}
+
+// SetAllowedOutAddressTypes limits the far side to targets the client gave
+// as one of types (config.AddressTypeIPv4, AddressTypeIPv6 or
+// AddressTypeDomain). An empty list allows every type.
+func (s *SalmonBridge) SetAllowedOutAddressTypes(types []string) error {
+	if len(types) == 0 {
+		s.allowedOutTypes = nil
+		return nil
+	}
+	allowed := make(map[byte]bool, len(types))
+	for _, t := range types {
+		switch t {
+		case config.AddressTypeIPv4:
+			allowed[AddrTypeIPv4] = true
+		case config.AddressTypeDomain:
+			allowed[AddrTypeDomain] = true
+		case config.AddressTypeIPv6:
+			allowed[AddrTypeIPv6] = true
+		default:
+			return fmt.Errorf("unknown address type %q (must be %q, %q or %q)", t,
+				config.AddressTypeIPv4, config.AddressTypeIPv6, config.AddressTypeDomain)
+		}
+	}
+	s.allowedOutTypes = allowed
+	return nil
+}
+
+func writeAddrTypeHeader(w io.Writer, addrType byte) error {
+	_, err := w.Write([]byte{ADDR_TYPE_HEADER, addrType})
+	return err
+}
+
+// readAddrTypeHeader reads the address type following an ADDR_TYPE_HEADER.
+func readAddrTypeHeader(r io.Reader) (byte, error) {
+	addrType, err := ReadHeaderType(r)
+	if err != nil {
+		return 0, err
+	}
+	switch addrType {
+	case AddrTypeIPv4, AddrTypeDomain, AddrTypeIPv6:
+		return addrType, nil
+	}
+	return 0, fmt.Errorf("unknown address type 0x%02x", addrType)
+}
+
+// targetAddrType settles the address type of target given the one the near
+// declared (0 if it sent none). A declared domain that is really an IP
+// literal counts as that IP type so it cannot slip past a domain only
+// policy; a declared IP type must come with an IP literal.
+func targetAddrType(target string, declared byte) (byte, error) {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return 0, err
+	}
+	inferred := AddrTypeOf(host)
+	switch {
+	case declared == 0, declared == AddrTypeDomain:
+		return inferred, nil
+	case inferred == AddrTypeDomain:
+		return 0, fmt.Errorf("target %s is not an IP but was sent as %s", target, addrTypeName(declared))
+	}
+	// An IPv4-mapped IPv6 address prints as IPv4 but keeps its declared type
+	return declared, nil
+}
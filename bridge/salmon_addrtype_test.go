@@ -0,0 +1,188 @@
+package bridge
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"salmoncannon/config"
+	"salmoncannon/utils"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+func TestAddrTypeOf(t *testing.T) {
+	tests := map[string]byte{
+		"10.0.0.1":        AddrTypeIPv4,
+		"::1":             AddrTypeIPv6,
+		"2001:db8::1":     AddrTypeIPv6,
+		"example.com":     AddrTypeDomain,
+		"localhost":       AddrTypeDomain,
+		"::ffff:10.0.0.1": AddrTypeIPv4,
+	}
+	for host, want := range tests {
+		if got := AddrTypeOf(host); got != want {
+			t.Errorf("AddrTypeOf(%q) = 0x%02x, want 0x%02x", host, got, want)
+		}
+	}
+}
+
+func TestAddrTypeHeader_RoundTrip(t *testing.T) {
+	for _, addrType := range []byte{AddrTypeIPv4, AddrTypeDomain, AddrTypeIPv6} {
+		var buf bytes.Buffer
+		if err := writeAddrTypeHeader(&buf, addrType); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if hdr, _ := ReadHeaderType(&buf); hdr != ADDR_TYPE_HEADER {
+			t.Fatalf("expected ADDR_TYPE_HEADER, got 0x%02x", hdr)
+		}
+		got, err := readAddrTypeHeader(&buf)
+		if err != nil || got != addrType {
+			t.Fatalf("read = 0x%02x, %v; want 0x%02x", got, err, addrType)
+		}
+	}
+	if _, err := readAddrTypeHeader(bytes.NewReader([]byte{0x02})); err == nil {
+		t.Fatalf("expected unknown address type to be rejected")
+	}
+}
+
+func TestTargetAddrType(t *testing.T) {
+	tests := []struct {
+		target   string
+		declared byte
+		want     byte
+		wantErr  bool
+	}{
+		{"example.com:80", 0, AddrTypeDomain, false},
+		{"10.0.0.1:80", 0, AddrTypeIPv4, false},
+		{"[::1]:80", 0, AddrTypeIPv6, false},
+		{"example.com:80", AddrTypeDomain, AddrTypeDomain, false},
+		{"10.0.0.1:80", AddrTypeIPv4, AddrTypeIPv4, false},
+		{"[::1]:80", AddrTypeIPv6, AddrTypeIPv6, false},
+		// A domain that is really an IP counts as the IP
+		{"10.0.0.1:80", AddrTypeDomain, AddrTypeIPv4, false},
+		// IPv4-mapped IPv6 keeps the type the client used
+		{"10.0.0.1:80", AddrTypeIPv6, AddrTypeIPv6, false},
+		{"example.com:80", AddrTypeIPv4, 0, true},
+		{"example.com", AddrTypeDomain, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := targetAddrType(tt.target, tt.declared)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("targetAddrType(%q, 0x%02x) = 0x%02x, %v; want 0x%02x, err %v", tt.target, tt.declared, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSalmonBridge_SetAllowedOutAddressTypes(t *testing.T) {
+	sb := &SalmonBridge{}
+	if err := sb.SetAllowedOutAddressTypes([]string{"ipv4", "hostname"}); err == nil {
+		t.Fatalf("expected unknown address type to be rejected")
+	}
+	if err := sb.SetAllowedOutAddressTypes([]string{config.AddressTypeDomain}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sb.shouldBlockFarOutConn("10.0.0.1:80", AddrTypeIPv4) {
+		t.Errorf("expected IPv4 target to be blocked")
+	}
+	if sb.shouldBlockFarOutConn("example.com:80", AddrTypeDomain) {
+		t.Errorf("expected domain target to be allowed")
+	}
+	if err := sb.SetAllowedOutAddressTypes(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sb.shouldBlockFarOutConn("10.0.0.1:80", AddrTypeIPv4) {
+		t.Errorf("expected an empty list to allow every type")
+	}
+}
+
+// echoListener accepts connections on network/addr and echoes them back.
+func echoListener(t *testing.T, network, addr string) net.Listener {
+	t.Helper()
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", addr, err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln
+}
+
+// echoesThrough reports whether data sent to host:port through near comes
+// back, i.e. whether the far side let the target through.
+func echoesThrough(t *testing.T, near *SalmonBridge, host string, port int, addrType byte) bool {
+	t.Helper()
+	conn, err := near.NewNearConnAddrType(host, port, addrType)
+	var dialErr *DialError
+	if errors.As(err, &dialErr) && dialErr.Code == DialNotAllowed {
+		return false
+	}
+	if err != nil {
+		t.Fatalf("failed to open near conn: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return false
+	}
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	return err == nil && string(buf) == "ping"
+}
+
+func TestSalmonBridge_AddressTypeEndToEnd(t *testing.T) {
+	ln4 := echoListener(t, "tcp4", "127.0.0.1:0")
+	defer ln4.Close()
+	port4 := ln4.Addr().(*net.TCPAddr).Port
+
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-addrtype"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	farBridge := NewSalmonBridge("test-addrtype", "127.0.0.1", 42065, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), "test-secret")
+	if err := farBridge.SetAllowedOutAddressTypes([]string{config.AddressTypeDomain, config.AddressTypeIPv6}); err != nil {
+		t.Fatalf("failed to set address types: %v", err)
+	}
+	defer farBridge.Close()
+	go farBridge.NewFarListen()
+	time.Sleep(700 * time.Millisecond)
+
+	nearBridge := NewSalmonBridge("test-addrtype", "127.0.0.1", 42065, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "test-secret")
+	defer nearBridge.Close()
+
+	if !echoesThrough(t, nearBridge, "localhost", port4, AddrTypeDomain) {
+		t.Errorf("expected domain target to be allowed")
+	}
+	if echoesThrough(t, nearBridge, "127.0.0.1", port4, AddrTypeIPv4) {
+		t.Errorf("expected IPv4 target to be refused")
+	}
+	if echoesThrough(t, nearBridge, "127.0.0.1", port4, AddrTypeDomain) {
+		t.Errorf("expected an IP sent as a domain to be refused")
+	}
+
+	ln6, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	ln6.Close()
+	ln6 = echoListener(t, "tcp6", ln6.Addr().String())
+	defer ln6.Close()
+	if !echoesThrough(t, nearBridge, "::1", ln6.Addr().(*net.TCPAddr).Port, AddrTypeIPv6) {
+		t.Errorf("expected IPv6 target to be allowed")
+	}
+}
@@ -0,0 +1,177 @@
+package bridge
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"salmoncannon/status"
+	"strconv"
+	"sync"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+// DefaultBindTimeout is how long the far side waits for the inbound
+// connection of a SOCKS BIND before giving up.
+const DefaultBindTimeout = 2 * time.Minute
+
+// NearBind is a pending SOCKS BIND. The far side is listening on BoundAddr
+// for a single inbound connection; Accept waits for it to arrive.
+type NearBind struct {
+	BoundAddr string
+
+	s       *SalmonBridge
+	stream  *quic.Stream
+	cleanup func()
+	keys    streamKeys
+
+	closeOnce sync.Once
+}
+
+// NewNearBind asks the far side to listen for one inbound connection on
+// behalf of a client. host and port are the peer the client expects to
+// connect; an unspecified address accepts any peer.
+func (s *SalmonBridge) NewNearBind(host string, port int) (*NearBind, error) {
+	stream, cleanup, err, _ := s.sq.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	b := &NearBind{s: s, stream: stream, cleanup: cleanup}
+
+	if _, err := stream.Write([]byte{BIND_HEADER}); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("write bind header: %w", err)
+	}
+	b.keys, err = s.writeConnectHeader(stream, net.JoinHostPort(host, strconv.Itoa(port)), AddrTypeOf(host), nil)
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("write bind target: %w", err)
+	}
+
+	stream.SetReadDeadline(time.Now().Add(15 * time.Second))
+	b.BoundAddr, err = ReadBindReply(stream, s.sharedSecret)
+	stream.SetReadDeadline(time.Time{})
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("read bind address: %w", err)
+	}
+	return b, nil
+}
+
+// Accept waits for the far side to report the inbound connection and
+// returns a conn relaying it along with the peer's address. It fails when
+// the far side times out waiting. Accept must be called at most once.
+func (b *NearBind) Accept() (net.Conn, string, error) {
+	peer, err := ReadBindReply(b.stream, b.s.sharedSecret)
+	if err != nil {
+		b.Close()
+		return nil, "", fmt.Errorf("bind accept: %w", err)
+	}
+
+	clientSide, internal := newHalfPipe()
+	// The pipe goroutine owns the stream from here, so Close is a no-op
+	b.closeOnce.Do(func() {})
+	go func() {
+		defer b.cleanup()
+		defer internal.Close()
+		defer b.stream.Close()
+		b.s.pipeNear(b.stream, internal, b.keys)
+	}()
+	return clientSide, peer, nil
+}
+
+// Close abandons a bind that has not been accepted.
+func (b *NearBind) Close() {
+	b.closeOnce.Do(func() {
+		b.stream.CancelRead(0)
+		b.stream.Close()
+		b.cleanup()
+	})
+}
+
+// SetBindAddress sets the local IP the far side listens on for SOCKS BIND
+// and reports back to clients. Empty listens on all interfaces and reports
+// 0.0.0.0, leaving clients to use the address they already know.
+func (s *SalmonBridge) SetBindAddress(addr string) {
+	s.bindAddress = addr
+}
+
+// bindPeerAllowed reports whether an inbound bind connection from peer is
+// acceptable: it must match the expected address, if one was given, and
+// pass the outbound allow list.
+func (s *SalmonBridge) bindPeerAllowed(peer net.Addr, expected string) bool {
+	peerHost, _, _ := net.SplitHostPort(peer.String())
+	expectedHost, _, _ := net.SplitHostPort(expected)
+	if ip := net.ParseIP(expectedHost); ip != nil && !ip.IsUnspecified() {
+		if !ip.Equal(net.ParseIP(peerHost)) {
+			return false
+		}
+	}
+	allowedOut := s.allowedOut.Load()
+	return allowedOut.Empty() || allowedOut.Matches(peerHost)
+}
+
+// handleBind serves a BIND stream on the far side: listen, report the
+// bound address, accept a single inbound connection, report the peer and
+// relay it.
+func (s *SalmonBridge) handleBind(stream *quic.Stream, headerType byte, expected string, keys streamKeys) {
+	defer stream.Close()
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(s.bindAddress, "0"))
+	if err != nil {
+		log.Printf("FAR: Bridge %s bind listen error: %v", s.BridgeName, err)
+		stream.CancelRead(0)
+		return
+	}
+	defer ln.Close()
+
+	advertiseHost := s.bindAddress
+	if advertiseHost == "" {
+		advertiseHost = "0.0.0.0"
+	}
+	_, boundPort, _ := net.SplitHostPort(ln.Addr().String())
+	if err := WriteBindReply(stream, net.JoinHostPort(advertiseHost, boundPort), s.sharedSecret); err != nil {
+		log.Printf("FAR: Bridge %s bind reply error: %v", s.BridgeName, err)
+		stream.CancelRead(0)
+		return
+	}
+
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(s.bindTimeout))
+	var dst net.Conn
+	for dst == nil {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("FAR: Bridge %s bind on port %s got no inbound connection: %v", s.BridgeName, boundPort, err)
+			stream.CancelRead(0)
+			return
+		}
+		if !s.bindPeerAllowed(conn.RemoteAddr(), expected) {
+			log.Printf("FAR: Bridge %s bind rejected inbound connection from %s (expected %s)", s.BridgeName, conn.RemoteAddr(), expected)
+			conn.Close()
+			continue
+		}
+		dst = conn
+	}
+	// Only a single inbound connection is relayed
+	ln.Close()
+
+	defer func() {
+		dst.Close()
+		status.GlobalConnMonitorRef.DecOUT()
+	}()
+	status.GlobalConnMonitorRef.IncOUT()
+
+	if err := WriteBindReply(stream, dst.RemoteAddr().String(), s.sharedSecret); err != nil {
+		log.Printf("FAR: Bridge %s bind peer reply error: %v", s.BridgeName, err)
+		stream.CancelRead(0)
+		return
+	}
+
+	if headerType == CONNECT_GCM_HEADER {
+		BidiPipeGcm(stream, dst, keys.writeKey, keys.readKey, s.pipeOptions(stream, keys.compression))
+	} else {
+		BidiPipe(stream, dst, keys.writeIv, keys.writeKey, keys.readIv, keys.readKey, s.pipeOptions(stream, keys.compression))
+	}
+	status.GlobalConnMonitorRef.RemoveStream(s.BridgeName)
+}
@@ -0,0 +1,137 @@
+package bridge
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"salmoncannon/utils"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+// startBindBridges runs a far and near bridge pair on farPort.
+func startBindBridges(t *testing.T, name string, farPort int, secret string, mode string) (*SalmonBridge, *SalmonBridge) {
+	t.Helper()
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{name},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	farBridge := NewSalmonBridge(name, "127.0.0.1", farPort, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), secret)
+	farBridge.SetBindAddress("127.0.0.1")
+	if err := farBridge.SetCipherMode(mode); err != nil {
+		t.Fatalf("failed to set far cipher mode: %v", err)
+	}
+	go farBridge.NewFarListen()
+	t.Cleanup(farBridge.Close)
+	time.Sleep(700 * time.Millisecond)
+
+	nearBridge := NewSalmonBridge(name, "127.0.0.1", farPort, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), secret)
+	if err := nearBridge.SetCipherMode(mode); err != nil {
+		t.Fatalf("failed to set near cipher mode: %v", err)
+	}
+	t.Cleanup(nearBridge.Close)
+	return farBridge, nearBridge
+}
+
+func TestSalmonBridge_BindEndToEnd(t *testing.T) {
+	for _, tc := range []struct {
+		name, secret, mode string
+		port               int
+	}{
+		{"test-bind-plain", "", "", 42050},
+		{"test-bind-ctr", "bind-secret", "ctr", 42051},
+		{"test-bind-gcm", "bind-secret", "gcm", 42052},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, nearBridge := startBindBridges(t, tc.name, tc.port, tc.secret, tc.mode)
+
+			bind, err := nearBridge.NewNearBind("0.0.0.0", 0)
+			if err != nil {
+				t.Fatalf("bind failed: %v", err)
+			}
+			defer bind.Close()
+
+			host, _, err := net.SplitHostPort(bind.BoundAddr)
+			if err != nil || host != "127.0.0.1" {
+				t.Fatalf("unexpected bound address %q: %v", bind.BoundAddr, err)
+			}
+
+			// The inbound peer connects to the far side's bound address
+			peer, err := net.Dial("tcp", bind.BoundAddr)
+			if err != nil {
+				t.Fatalf("failed to dial bound address: %v", err)
+			}
+			defer peer.Close()
+
+			conn, peerAddr, err := bind.Accept()
+			if err != nil {
+				t.Fatalf("accept failed: %v", err)
+			}
+			defer conn.Close()
+			if peerAddr != peer.LocalAddr().String() {
+				t.Errorf("expected peer address %s, got %s", peer.LocalAddr(), peerAddr)
+			}
+
+			conn.SetDeadline(time.Now().Add(3 * time.Second))
+			peer.SetDeadline(time.Now().Add(3 * time.Second))
+			if _, err := peer.Write([]byte("220 hello")); err != nil {
+				t.Fatalf("peer write failed: %v", err)
+			}
+			buf := make([]byte, 9)
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "220 hello" {
+				t.Fatalf("unexpected data from peer %q: %v", buf, err)
+			}
+			if _, err := conn.Write([]byte("QUIT")); err != nil {
+				t.Fatalf("client write failed: %v", err)
+			}
+			buf = make([]byte, 4)
+			if _, err := io.ReadFull(peer, buf); err != nil || string(buf) != "QUIT" {
+				t.Fatalf("unexpected data from client %q: %v", buf, err)
+			}
+		})
+	}
+}
+
+func TestSalmonBridge_BindTimeout(t *testing.T) {
+	farBridge, nearBridge := startBindBridges(t, "test-bind-timeout", 42053, "", "")
+	farBridge.bindTimeout = 300 * time.Millisecond
+
+	bind, err := nearBridge.NewNearBind("0.0.0.0", 0)
+	if err != nil {
+		t.Fatalf("bind failed: %v", err)
+	}
+	defer bind.Close()
+
+	start := time.Now()
+	if _, _, err := bind.Accept(); err == nil {
+		t.Fatalf("expected accept to fail when no peer connects")
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Fatalf("accept took too long to time out: %v", time.Since(start))
+	}
+
+	// The listener is gone once the bind has timed out
+	if conn, err := net.DialTimeout("tcp", bind.BoundAddr, time.Second); err == nil {
+		conn.Close()
+		t.Fatalf("expected bound port to be closed after timeout")
+	}
+}
+
+func TestSalmonBridge_BindRejectsUnexpectedPeer(t *testing.T) {
+	farBridge, _ := startBindBridges(t, "test-bind-peer", 42054, "", "")
+	peer := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+
+	if !farBridge.bindPeerAllowed(peer, "0.0.0.0:0") {
+		t.Errorf("unspecified address should accept any peer")
+	}
+	if !farBridge.bindPeerAllowed(peer, "127.0.0.1:21") {
+		t.Errorf("matching address should be accepted")
+	}
+	if farBridge.bindPeerAllowed(peer, "10.0.0.1:21") {
+		t.Errorf("other addresses should be rejected")
+	}
+}
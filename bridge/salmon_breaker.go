@@ -0,0 +1,113 @@
+package bridge
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+// StreamErrTargetUnavailable is the stream error code the far side cancels
+// with when a target's circuit breaker is open, so the near can tell it
+// apart from a single failed dial.
+const StreamErrTargetUnavailable quic.StreamErrorCode = 0x10
+
+var ErrTargetUnavailable = errors.New("target circuit breaker open")
+
+// dialBreaker stops the far side dialing targets that keep refusing. After
+// threshold consecutive failures within window, dials to that target are
+// refused for cooldown. Once the cooldown ends dials go through again: one
+// success closes the breaker, one failure reopens it.
+// A nil dialBreaker allows everything.
+type dialBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	targets   map[string]*breakerState
+}
+
+type breakerState struct {
+	failures     int
+	firstFailure time.Time
+	openUntil    time.Time // zero while the breaker has never tripped
+}
+
+func newDialBreaker(threshold int, window time.Duration, cooldown time.Duration) *dialBreaker {
+	if threshold <= 0 || cooldown <= 0 {
+		return nil
+	}
+	return &dialBreaker{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		targets:   make(map[string]*breakerState),
+	}
+}
+
+// allow reports whether target may be dialed at now.
+func (b *dialBreaker) allow(target string, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st, ok := b.targets[target]
+	if !ok {
+		return true
+	}
+	if now.Before(st.openUntil) {
+		return false
+	}
+	if st.openUntil.IsZero() && b.window > 0 && now.Sub(st.firstFailure) > b.window {
+		// Failures too old to matter
+		delete(b.targets, target)
+	}
+	return true
+}
+
+// record updates target's breaker with the result of a dial.
+func (b *dialBreaker) record(target string, err error, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if st, ok := b.targets[target]; ok && !st.openUntil.IsZero() {
+			log.Printf("FAR: Target %s recovered, closing circuit breaker", target)
+		}
+		delete(b.targets, target)
+		return
+	}
+
+	st, ok := b.targets[target]
+	if !ok {
+		st = &breakerState{firstFailure: now}
+		b.targets[target] = st
+	}
+	if !st.openUntil.IsZero() {
+		// The trial dial after a cooldown failed
+		st.openUntil = now.Add(b.cooldown)
+		log.Printf("FAR: Target %s still failing, circuit breaker open for %v", target, b.cooldown)
+		return
+	}
+	if b.window > 0 && now.Sub(st.firstFailure) > b.window {
+		st.failures = 0
+		st.firstFailure = now
+	}
+	st.failures++
+	if st.failures >= b.threshold {
+		st.openUntil = now.Add(b.cooldown)
+		log.Printf("FAR: Target %s failed %d dials in a row, circuit breaker open for %v", target, st.failures, b.cooldown)
+	}
+}
+
+// SetDialBreaker makes the far side stop dialing a target for cooldown after
+// threshold consecutive failed dials within window. A threshold <= 0
+// disables it.
+func (s *SalmonBridge) SetDialBreaker(threshold int, window time.Duration, cooldown time.Duration) {
+	s.breaker = newDialBreaker(threshold, window, cooldown)
+}
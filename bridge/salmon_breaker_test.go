@@ -0,0 +1,157 @@
+package bridge
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"salmoncannon/utils"
+	"strconv"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+var errDialRefused = errors.New("connection refused")
+
+func TestDialBreaker_TripCooldownRecover(t *testing.T) {
+	b := newDialBreaker(3, time.Minute, 10*time.Second)
+	now := time.Now()
+	target := "10.0.0.1:80"
+
+	for i := 0; i < 3; i++ {
+		if !b.allow(target, now) {
+			t.Fatalf("expected dial %d to be allowed before tripping", i)
+		}
+		b.record(target, errDialRefused, now)
+	}
+	if b.allow(target, now.Add(time.Second)) {
+		t.Fatalf("expected breaker to be open after 3 failures")
+	}
+	if !b.allow("10.0.0.2:80", now) {
+		t.Fatalf("expected other targets to be unaffected")
+	}
+
+	// Cooldown over: one trial that fails reopens straight away
+	now = now.Add(11 * time.Second)
+	if !b.allow(target, now) {
+		t.Fatalf("expected a trial dial after cooldown")
+	}
+	b.record(target, errDialRefused, now)
+	if b.allow(target, now.Add(time.Second)) {
+		t.Fatalf("expected a failed trial to reopen the breaker")
+	}
+
+	// Next trial succeeds and closes it
+	now = now.Add(11 * time.Second)
+	if !b.allow(target, now) {
+		t.Fatalf("expected a trial dial after the second cooldown")
+	}
+	b.record(target, nil, now)
+	b.record(target, errDialRefused, now)
+	if !b.allow(target, now) {
+		t.Fatalf("expected a single failure after recovery not to trip the breaker")
+	}
+}
+
+func TestDialBreaker_FailuresOutsideWindowDontTrip(t *testing.T) {
+	b := newDialBreaker(2, time.Second, time.Minute)
+	now := time.Now()
+	target := "10.0.0.1:80"
+
+	b.record(target, errDialRefused, now)
+	b.record(target, errDialRefused, now.Add(2*time.Second))
+	if !b.allow(target, now.Add(2*time.Second)) {
+		t.Fatalf("expected failures spread past the window not to trip")
+	}
+	b.record(target, errDialRefused, now.Add(2500*time.Millisecond))
+	if b.allow(target, now.Add(2500*time.Millisecond)) {
+		t.Fatalf("expected two failures within the window to trip")
+	}
+}
+
+func TestDialBreaker_NilAllowsEverything(t *testing.T) {
+	b := newDialBreaker(0, time.Minute, time.Minute)
+	if b != nil {
+		t.Fatalf("expected threshold 0 to disable the breaker")
+	}
+	b.record("x:1", errDialRefused, time.Now())
+	if !b.allow("x:1", time.Now()) {
+		t.Fatalf("expected nil breaker to allow dials")
+	}
+}
+
+// openTargetStream opens a raw stream to target and returns the error the
+// far side ends it with.
+func openTargetStream(t *testing.T, near *SalmonBridge, target string) error {
+	t.Helper()
+	stream, cleanup, err, _ := near.Quic().OpenStream()
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer cleanup()
+	defer stream.Close()
+	if err := WriteTargetHeader(stream, target); err != nil {
+		t.Fatalf("failed to write header: %v", err)
+	}
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadAll(stream)
+	return err
+}
+
+func TestSalmonBridge_DialBreakerEndToEnd(t *testing.T) {
+	// Grab a free port and close it so dials are refused
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	target := "127.0.0.1:" + strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-breaker"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	farBridge := NewSalmonBridge("test-breaker", "127.0.0.1", 42064, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), "")
+	farBridge.SetDialBreaker(2, time.Minute, 300*time.Millisecond)
+	defer farBridge.Close()
+	go farBridge.NewFarListen()
+	time.Sleep(700 * time.Millisecond)
+
+	nearBridge := NewSalmonBridge("test-breaker", "127.0.0.1", 42064, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "")
+	defer nearBridge.Close()
+
+	var streamErr *quic.StreamError
+	for i := 0; i < 2; i++ {
+		if err := openTargetStream(t, nearBridge, target); errors.As(err, &streamErr) && streamErr.ErrorCode == StreamErrTargetUnavailable {
+			t.Fatalf("dial %d: breaker tripped too early", i)
+		}
+	}
+	err = openTargetStream(t, nearBridge, target)
+	if !errors.As(err, &streamErr) || streamErr.ErrorCode != StreamErrTargetUnavailable {
+		t.Fatalf("expected stream to be cancelled with StreamErrTargetUnavailable, got %v", err)
+	}
+
+	// Bring the target up; after the cooldown the next dial gets through
+	ln, err = net.Listen("tcp", target)
+	if err != nil {
+		t.Skipf("could not reclaim target port: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	time.Sleep(400 * time.Millisecond)
+	if err := openTargetStream(t, nearBridge, target); err != nil {
+		t.Fatalf("expected dial to go through after cooldown, got %v", err)
+	}
+}
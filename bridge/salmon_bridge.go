@@ -1,15 +1,23 @@
 package bridge
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/netip"
+	"salmoncannon/config"
 	"salmoncannon/connections"
+	"salmoncannon/crypt"
 	"salmoncannon/limiter"
+	"salmoncannon/logging"
 	"salmoncannon/status"
-	"slices"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	quic "github.com/quic-go/quic-go"
@@ -19,36 +27,149 @@ type SalmonBridge struct {
 	BridgeName string
 	sq         *connections.SalmonQuic // Handler for QUIC connections
 
-	sl                  *limiter.SharedLimiter
-	connector           bool
-	allowedOutAddresses []string
+	sl         *limiter.SharedLimiter
+	connector  bool
+	allowedOut atomic.Pointer[config.AddressFilter]
 
-	sharedSecret string
+	allowedOutTypes map[byte]bool // far side, nil allows every address type
+	allowedOutPorts map[int]bool  // far side, nil allows every port
+	deniedOutPorts  map[int]bool  // far side, checked before allowedOutPorts
+
+	sharedSecret  string
+	cipherMode    string
+	compression   string // requested by the near side for its streams
+	compressStats *status.CompressionCounter
+
+	streamIdleTimeout time.Duration // 0 disables idle teardown
+	coalesceDelay     time.Duration // 0 writes each chunk to the stream as read
+	streamLimit       int64         // bytes/s per relayed stream, 0 for none
+	breaker           *dialBreaker  // far side, nil when disabled
+	relayBufs         *BufferPool   // nil uses DefaultRelayBufferSize
+
+	dialResultTimeout time.Duration // near side wait for the far's dial result
+
+	// SOCKS BIND listener settings (far side)
+	bindAddress string
+	bindTimeout time.Duration
+
+	egressInterface string      // far side, "" dials on the default route
+	targetPool      *targetPool // far side, nil when disabled
+
+	epoch uint64 // far side, random id of this instance sent in control hellos
+
+	sendProxyProtocol bool // near sends client addresses, far prefixes PROXY v2
+	sendClientAddr    bool // near sends client addresses for the far's outLimit
+	outLimit          *outLimiter
+
+	// Near side direct dials (SBFallbackDirect)
+	resolver  *net.Resolver // nil uses net.DefaultResolver
+	remoteDNS bool          // refuse hostname targets instead of resolving them
 }
 
 func NewSalmonBridge(name string, address string, port int, tlscfg *tls.Config,
 	qcfg *quic.Config, sl *limiter.SharedLimiter, connector bool, interfaceName string,
 	allowedOutAddresses []string, sharedSecret string) *SalmonBridge {
 	sq := connections.NewSalmonQuic(port, address, name, tlscfg, qcfg, interfaceName)
-	return &SalmonBridge{
-		BridgeName:          name,
-		sl:                  sl,
-		sq:                  sq,
-		connector:           connector,
-		allowedOutAddresses: allowedOutAddresses,
-		sharedSecret:        sharedSecret,
+	allowedOut, err := config.ParseAddressFilter(allowedOutAddresses)
+	if err != nil {
+		// LoadConfig rejects these up front, so only direct callers get here
+		log.Printf("BRIDGE: Bridge %s ignoring invalid allowed out addresses: %v", name, err)
+		allowedOut = &config.AddressFilter{}
+	}
+	sb := &SalmonBridge{
+		BridgeName:   name,
+		sl:           sl,
+		sq:           sq,
+		connector:    connector,
+		sharedSecret: sharedSecret,
+		cipherMode:   crypt.CipherModeCtr,
+		compression:  CompressionNone,
+		bindTimeout:  DefaultBindTimeout,
+
+		compressStats: status.GlobalConnMonitorRef.CompressionCounter(name),
+
+		dialResultTimeout: DefaultDialResultTimeout,
+		epoch:             newEpoch(),
+	}
+	sb.allowedOut.Store(allowedOut)
+	return sb
+}
+
+// SetAllowedOut swaps the far side destination filter. Streams opened after
+// the call are checked against the new filter.
+func (s *SalmonBridge) SetAllowedOut(filter *config.AddressFilter) {
+	if filter == nil {
+		filter = &config.AddressFilter{}
+	}
+	s.allowedOut.Store(filter)
+}
+
+// Close stops the far listener, if any, and closes every pooled QUIC
+// connection. Streams in flight are torn down with their connection.
+func (s *SalmonBridge) Close() {
+	s.sq.Close()
+	s.targetPool.close()
+}
+
+// Shutdown refuses new streams, lets open ones finish until ctx is done and
+// then closes the bridge. It returns how many streams were cut off.
+func (s *SalmonBridge) Shutdown(ctx context.Context) int32 {
+	return s.sq.Shutdown(ctx)
+}
+
+// SetCipherMode selects how stream payloads are encrypted when a shared
+// secret is set: crypt.CipherModeCtr (default) or crypt.CipherModeGcm.
+// Both sides of a bridge must use the same mode.
+func (s *SalmonBridge) SetCipherMode(mode string) error {
+	switch mode {
+	case "", crypt.CipherModeCtr:
+		s.cipherMode = crypt.CipherModeCtr
+	case crypt.CipherModeGcm:
+		s.cipherMode = crypt.CipherModeGcm
+	default:
+		return fmt.Errorf("unknown cipher mode %q (must be %q or %q)", mode, crypt.CipherModeCtr, crypt.CipherModeGcm)
 	}
+	return nil
+}
+
+// SetStreamIdleTimeout closes a relayed stream once neither direction has
+// moved data for timeout. 0 disables it.
+func (s *SalmonBridge) SetStreamIdleTimeout(timeout time.Duration) {
+	s.streamIdleTimeout = timeout
+}
+
+// SetStreamBandwidthLimit holds each relayed stream to bytesPerSec on top of
+// the bridge's shared limit. 0 disables it.
+func (s *SalmonBridge) SetStreamBandwidthLimit(bytesPerSec int64) {
+	s.streamLimit = max(bytesPerSec, 0)
+}
+
+// Quic returns the QUIC connection pool backing this bridge so callers can
+// tune it after construction.
+func (s *SalmonBridge) Quic() *connections.SalmonQuic {
+	return s.sq
+}
+
+// Limiter returns the shared bandwidth limiter applied to this bridge's
+// streams.
+func (s *SalmonBridge) Limiter() *limiter.SharedLimiter {
+	return s.sl
 }
 
 // =========================================================
 // Near side: dial far, open a new QUIC stream per TCP conn
 // =========================================================
 
-func (s *SalmonBridge) StatusCheck() {
+// StatusCheck pings the far side and records the round trip time, or the
+// error that stopped it, with the connection monitor. The error is returned
+// as well for callers that act on it.
+func (s *SalmonBridge) StatusCheck() error {
 	stream, cleanup, err, qconn := s.sq.OpenStream()
 	if err != nil {
-		log.Printf("NEAR: Bridge %s status check connect error: %v", s.BridgeName, err)
-		return
+		status.GlobalConnMonitorRef.RegisterError(s.BridgeName, fmt.Errorf("status check: %w", err))
+		logging.Log(logging.Event{Bridge: s.BridgeName, Type: logging.EventPing, Err: err},
+			"NEAR: Bridge %s status check connect error: %v", s.BridgeName, err)
+		return err
 	}
 	defer stream.Close()
 	defer cleanup()
@@ -56,9 +177,14 @@ func (s *SalmonBridge) StatusCheck() {
 	startTime := time.Now()
 	written, err := stream.Write([]byte{STATUS_HEADER})
 	if err != nil || written != 1 {
-		log.Printf("NEAR: Bridge %s status check write error: %v", s.BridgeName, err)
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+		status.GlobalConnMonitorRef.RegisterError(s.BridgeName, fmt.Errorf("status check write: %w", err))
+		logging.Log(logging.Event{Bridge: s.BridgeName, Type: logging.EventPing, Err: err},
+			"NEAR: Bridge %s status check write error: %v", s.BridgeName, err)
 		s.sq.CloseConnection(qconn)
-		return
+		return err
 	}
 
 	// Read response
@@ -66,26 +192,67 @@ func (s *SalmonBridge) StatusCheck() {
 	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
 	n, err := stream.Read(buf)
 	if err != nil || n != 1 || buf[0] != STATUS_ACK {
-		log.Printf("NEAR: Bridge %s status check read error: %v", s.BridgeName, err)
+		if err == nil {
+			err = fmt.Errorf("unexpected reply 0x%02x", buf[0])
+		}
+		status.GlobalConnMonitorRef.RegisterError(s.BridgeName, fmt.Errorf("status check read: %w", err))
+		logging.Log(logging.Event{Bridge: s.BridgeName, Type: logging.EventPing, Err: err},
+			"NEAR: Bridge %s status check read error: %v", s.BridgeName, err)
 		s.sq.CloseConnection(qconn)
-		return
+		return err
 	}
 
 	elapsed := time.Since(startTime)
 	// convert to ms
 	status.GlobalConnMonitorRef.RegisterPing(s.BridgeName, elapsed.Milliseconds())
+	status.GlobalConnMonitorRef.ClearError(s.BridgeName)
+	logging.Log(logging.Event{Bridge: s.BridgeName, Type: logging.EventPing, Latency: elapsed}, "")
 
 	written, err = stream.Write([]byte{STATUS_ACK})
 	if err != nil || written != 1 {
-		log.Printf("NEAR: Bridge %s status check final write error: %v", s.BridgeName, err)
+		logging.Log(logging.Event{Bridge: s.BridgeName, Type: logging.EventPing, Err: err},
+			"NEAR: Bridge %s status check final write error: %v", s.BridgeName, err)
+		// The ping itself got through
 		s.sq.CloseConnection(qconn)
-		return
+		return nil
 	}
 
 	// Listen for the far side to close the stream
 	buf = make([]byte, 1)
 	stream.SetReadDeadline(time.Now().Add(3 * time.Second))
 	_, _ = stream.Read(buf)
+	return nil
+}
+
+// keepalivePing runs the status exchange on stream without recording a
+// ping time. It is used by the pool keepalive, so it never touches the
+// bandwidth limiter.
+func (s *SalmonBridge) keepalivePing(stream *quic.Stream) error {
+	if _, err := stream.Write([]byte{STATUS_HEADER}); err != nil {
+		return fmt.Errorf("write status header: %w", err)
+	}
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		return fmt.Errorf("read status ack: %w", err)
+	}
+	if buf[0] != STATUS_ACK {
+		return fmt.Errorf("unexpected status reply 0x%02x", buf[0])
+	}
+	if _, err := stream.Write([]byte{STATUS_ACK}); err != nil {
+		return fmt.Errorf("write status ack: %w", err)
+	}
+	return nil
+}
+
+// SetKeepalive pings each pooled connection every interval and evicts one
+// after maxFailures missed pings in a row. Pings go over the connection's
+// control stream, or a status exchange on a stream of their own when the
+// far side has no control streams. An interval <= 0 disables it.
+func (s *SalmonBridge) SetKeepalive(interval time.Duration, maxFailures int) {
+	if interval > 0 {
+		s.sq.SetControl(s.controlHandshake)
+	}
+	s.sq.SetKeepalive(interval, maxFailures, s.keepalivePing)
 }
 
 func (s *SalmonBridge) tryConnect() (net.Conn, net.Conn, *quic.Stream, func(), error) {
@@ -97,19 +264,130 @@ func (s *SalmonBridge) tryConnect() (net.Conn, net.Conn, *quic.Stream, func(), e
 
 	// Only create the pipe after we successfully have a stream
 	// This prevents pipe leaks if stream creation fails
-	clientSide, internal := net.Pipe()
+	clientSide, internal := newHalfPipe()
 	return clientSide, internal, stream, cleanup, nil
 }
 
+// streamKeys holds the per-stream cipher material picked by the near side,
+// nil when the bridge has no shared secret, and the stream's compression.
+type streamKeys struct {
+	readIv, writeIv, readKey, writeKey []byte
+	compression                        string
+}
+
+// writeConnectHeader writes the connect header for target in the format
+// matching the bridge's secret, cipher mode and compression, tagged with the
+// address type the client used and, with SBSendProxyProtocol or
+// SBMaxOutPerClient, its address.
+func (s *SalmonBridge) writeConnectHeader(stream *quic.Stream, target string, addrType byte, client net.Addr) (streamKeys, error) {
+	keys := streamKeys{compression: s.compression}
+	if err := writeCompressHeader(stream, s.compression); err != nil {
+		return keys, err
+	}
+	if tcpAddr, ok := client.(*net.TCPAddr); ok && (s.sendProxyProtocol || s.sendClientAddr) {
+		if err := writeClientAddrHeader(stream, tcpAddr.AddrPort()); err != nil {
+			return keys, err
+		}
+	}
+	if err := writeAddrTypeHeader(stream, addrType); err != nil {
+		return keys, err
+	}
+	if s.sharedSecret == "" {
+		return keys, WriteTargetHeader(stream, target)
+	}
+	keys.readIv = make([]byte, 16)
+	keys.writeIv = make([]byte, 16)
+	keys.readKey = make([]byte, 32)
+	keys.writeKey = make([]byte, 32)
+	rand.Read(keys.readIv)
+	rand.Read(keys.writeIv)
+	rand.Read(keys.readKey)
+	rand.Read(keys.writeKey)
+	writeHeader := WriteTargetHeaderEnc
+	if s.cipherMode == crypt.CipherModeGcm {
+		writeHeader = WriteTargetHeaderGcm
+	}
+	err := writeHeader(stream, target, keys.readIv, keys.writeIv, keys.readKey, keys.writeKey, s.sharedSecret)
+	return keys, err
+}
+
+// pipeOptions returns the bridge's relay settings for stream, whose payloads
+// use compression.
+func (s *SalmonBridge) pipeOptions(stream *quic.Stream, compression string) PipeOptions {
+	return PipeOptions{
+		Limiter:       s.sl,
+		IdleTimeout:   s.streamIdleTimeout,
+		CoalesceDelay: s.coalesceDelay,
+		Compression:   compression,
+		CompressStats: s.compressStats,
+		Buffers:       s.relayBufs,
+		StreamLimit:   s.streamLimit,
+		Counter:       s.sq.StreamCounter(stream),
+	}
+}
+
+// pipeNear pumps data between a near side conn and its stream.
+func (s *SalmonBridge) pipeNear(stream *quic.Stream, conn net.Conn, keys streamKeys) {
+	if s.sharedSecret != "" && s.cipherMode == crypt.CipherModeGcm {
+		BidiPipeGcm(stream, conn, keys.readKey, keys.writeKey, s.pipeOptions(stream, keys.compression))
+	} else {
+		BidiPipe(stream, conn, keys.readIv, keys.readKey, keys.writeIv, keys.writeKey, s.pipeOptions(stream, keys.compression))
+	}
+}
+
 // NewNearConn returns a net.Conn to the caller. Internally, it opens a new QUIC
 
 // stream, sends a small header identifying the remote target (host:port),
-// and then pipes bytes bidirectionally.
+// waits for the far side to connect to it and then pipes bytes
+// bidirectionally. If the far side could not connect the error is a
+// *DialError carrying the reason.
 func (s *SalmonBridge) NewNearConn(host string, port int) (net.Conn, error) {
+	return s.NewNearConnAddrType(host, port, AddrTypeOf(host))
+}
+
+// NewNearConnAddrType is NewNearConn for a target the client gave with a
+// known address type, e.g. the ATYP of a SOCKS5 request.
+func (s *SalmonBridge) NewNearConnAddrType(host string, port int, addrType byte) (net.Conn, error) {
+	return s.NewNearConnFrom(host, port, addrType, nil)
+}
+
+// NewNearConnFrom is NewNearConnAddrType on behalf of client, whose address
+// is passed on for the far side's PROXY header when SetSendProxyProtocol is
+// on. A nil client, or one that is not TCP, sends no address.
+func (s *SalmonBridge) NewNearConnFrom(host string, port int, addrType byte, client net.Addr) (net.Conn, error) {
 
 	clientSide, internal, stream, cleanup, err := s.tryConnect()
 
 	if err != nil {
+		status.GlobalConnMonitorRef.RegisterError(s.BridgeName, err)
+		return nil, err
+	}
+
+	// 1) Send a small header carrying target address.
+	keys, err := s.writeConnectHeader(stream, net.JoinHostPort(host, strconv.Itoa(port)), addrType, client)
+	if err == nil {
+		// 2) Wait for the far side to dial it.
+		stream.SetReadDeadline(time.Now().Add(s.dialResultTimeout))
+		err = readDialResult(stream)
+		stream.SetReadDeadline(time.Time{})
+		if isTimeout(err) {
+			err = fmt.Errorf("no dial result from far side within %s: %w", s.dialResultTimeout, err)
+		}
+	}
+	// A dial result, good or bad, means the far side is reachable
+	var dialErr *DialError
+	if err == nil || errors.As(err, &dialErr) {
+		status.GlobalConnMonitorRef.ClearError(s.BridgeName)
+	} else {
+		status.GlobalConnMonitorRef.RegisterError(s.BridgeName, err)
+	}
+	if err != nil {
+		// Cancel read to unblock far side quickly.
+		stream.CancelRead(0)
+		stream.Close()
+		internal.Close()
+		clientSide.Close()
+		cleanup()
 		return nil, err
 	}
 
@@ -119,36 +397,8 @@ func (s *SalmonBridge) NewNearConn(host string, port int) (net.Conn, error) {
 		defer internal.Close()
 		defer stream.Close()
 
-		var readIv, writeIv, readKey, writeKey []byte
-
-		// 1) Send a small header carrying target address.
-		target := fmt.Sprintf("%s:%d", host, port)
-		if s.sharedSecret == "" {
-			if err := WriteTargetHeader(stream, target); err != nil {
-				log.Printf("NEAR: write header error: %v", err)
-				// If we fail before copying, cancel read to unblock far side quickly.
-				stream.CancelRead(0)
-				return
-			}
-		} else {
-			readIv = make([]byte, 16)
-			writeIv = make([]byte, 16)
-			readKey = make([]byte, 32)
-			writeKey = make([]byte, 32)
-			rand.Read(readIv)
-			rand.Read(writeIv)
-			rand.Read(readKey)
-			rand.Read(writeKey)
-			if err := WriteTargetHeaderEnc(stream, target, readIv, writeIv, readKey, writeKey, s.sharedSecret); err != nil {
-				log.Printf("NEAR: write encrypted header error: %v", err)
-				// If we fail before copying, cancel read to unblock far side quickly.
-				stream.CancelRead(0)
-				return
-			}
-		}
-
-		// 2) Pump data both ways.
-		BidiPipe(stream, internal, s.sl, readIv, readKey, writeIv, writeKey)
+		// 3) Pump data both ways.
+		s.pipeNear(stream, internal, keys)
 	}()
 
 	return clientSide, nil
@@ -157,12 +407,19 @@ func (s *SalmonBridge) NewNearConn(host string, port int) (net.Conn, error) {
 // =========================================================
 // Far side: accept streams, read header, dial target, pipe
 // =========================================================
-func (s *SalmonBridge) shouldBlockFarOutConn(outHostFull string) bool {
-	if len(s.allowedOutAddresses) == 0 {
+func (s *SalmonBridge) shouldBlockFarOutConn(outHostFull string, addrType byte) bool {
+	if s.allowedOutTypes != nil && !s.allowedOutTypes[addrType] {
+		return true
+	}
+	outAddr, outPort, _ := net.SplitHostPort(outHostFull)
+	if !s.outPortAllowed(outPort) {
+		return true
+	}
+	allowedOut := s.allowedOut.Load()
+	if allowedOut.Empty() {
 		return false
 	}
-	nearAddr, _, _ := net.SplitHostPort(outHostFull)
-	return !slices.Contains(s.allowedOutAddresses, nearAddr)
+	return !allowedOut.Matches(outAddr)
 }
 
 func (s *SalmonBridge) handleStatusPing(stream *quic.Stream) {
@@ -197,6 +454,82 @@ func (s *SalmonBridge) handleIncomingStream(stream *quic.Stream) {
 		return
 	}
 
+	if headerType == CONTROL_HEADER {
+		s.handleControlStream(stream)
+		return
+	}
+
+	bind := false
+	if headerType == BIND_HEADER {
+		// A bind carries a normal connect header after the bind marker
+		bind = true
+		headerType, err = ReadHeaderType(stream)
+		if err != nil || headerType == STATUS_HEADER || headerType == BIND_HEADER || headerType == UDP_ASSOC_HEADER {
+			log.Printf("FAR: Bridge %s read bind header error: %v", s.BridgeName, err)
+			stream.CancelRead(0)
+			stream.Close()
+			return
+		}
+	}
+
+	udp := false
+	if headerType == UDP_ASSOC_HEADER {
+		// As for a bind, the connect header follows with the stream's keys
+		udp = true
+		headerType, err = ReadHeaderType(stream)
+		if err != nil || headerType == STATUS_HEADER || headerType == BIND_HEADER || headerType == UDP_ASSOC_HEADER {
+			log.Printf("FAR: Bridge %s read UDP associate header error: %v", s.BridgeName, err)
+			stream.CancelRead(0)
+			stream.Close()
+			return
+		}
+	}
+
+	compression := CompressionNone
+	if headerType == COMPRESS_HEADER {
+		compression, err = readCompressHeader(stream)
+		if err == nil {
+			headerType, err = ReadHeaderType(stream)
+		}
+		if err != nil || headerType == STATUS_HEADER || headerType == BIND_HEADER || headerType == COMPRESS_HEADER {
+			log.Printf("FAR: Bridge %s read compression header error: %v", s.BridgeName, err)
+			stream.CancelRead(0)
+			stream.Close()
+			return
+		}
+	}
+
+	// Only sent by nears with SBSendProxyProtocol or SBMaxOutPerClient set
+	var clientAddr netip.AddrPort
+	if headerType == CLIENT_ADDR_HEADER {
+		clientAddr, err = readClientAddrHeader(stream)
+		if err == nil {
+			headerType, err = ReadHeaderType(stream)
+		}
+		if err != nil || headerType == STATUS_HEADER || headerType == BIND_HEADER || headerType == COMPRESS_HEADER || headerType == CLIENT_ADDR_HEADER {
+			log.Printf("FAR: Bridge %s read client address header error: %v", s.BridgeName, err)
+			stream.CancelRead(0)
+			stream.Close()
+			return
+		}
+	}
+
+	// Absent when the near predates address types, inferred from the target then
+	var addrType byte
+	if headerType == ADDR_TYPE_HEADER {
+		addrType, err = readAddrTypeHeader(stream)
+		if err == nil {
+			headerType, err = ReadHeaderType(stream)
+		}
+		if err != nil || headerType == STATUS_HEADER || headerType == BIND_HEADER || headerType == COMPRESS_HEADER ||
+			headerType == CLIENT_ADDR_HEADER || headerType == ADDR_TYPE_HEADER {
+			log.Printf("FAR: Bridge %s read address type header error: %v", s.BridgeName, err)
+			stream.CancelRead(0)
+			stream.Close()
+			return
+		}
+	}
+
 	if headerType == STATUS_HEADER {
 		// Handle status request
 		// log.Printf("FAR: Bridge %s received status ping", s.BridgeName)
@@ -225,7 +558,17 @@ func (s *SalmonBridge) handleIncomingStream(stream *quic.Stream) {
 			return
 		}
 	}
-	if headerType == CONNECT_ENC_HEADER {
+	if headerType == CONNECT_ENC_HEADER || headerType == CONNECT_GCM_HEADER {
+		wantHeader := byte(CONNECT_ENC_HEADER)
+		if s.cipherMode == crypt.CipherModeGcm {
+			wantHeader = CONNECT_GCM_HEADER
+		}
+		if headerType != wantHeader {
+			log.Printf("FAR: Bridge %s received header 0x%02x but is configured for cipher mode %s", s.BridgeName, headerType, s.cipherMode)
+			stream.CancelRead(0)
+			stream.Close()
+			return
+		}
 		target, readIv, writeIv, readKey, writeKey, err = ReadTargetHeaderEnc(stream, s.sharedSecret)
 		if err != nil {
 			log.Printf("FAR: Bridge %s read encrypted header error: %v", s.BridgeName, err)
@@ -234,34 +577,119 @@ func (s *SalmonBridge) handleIncomingStream(stream *quic.Stream) {
 			return
 		}
 	}
-	// 2) Check for allowed outbound IPs/Hostnames
-	if s.shouldBlockFarOutConn(target) {
-		log.Printf("FAR: Bridge %s target addr not found in allow list: %s", s.BridgeName, target)
-		stream.CancelRead(0)
-		stream.Close()
+	if bind {
+		if target == "" {
+			log.Printf("FAR: Bridge %s received bind with unknown header 0x%02x", s.BridgeName, headerType)
+			stream.CancelRead(0)
+			stream.Close()
+			return
+		}
+		// The allow list is checked against the inbound peer instead
+		s.handleBind(stream, headerType, target, streamKeys{readIv, writeIv, readKey, writeKey, compression})
+		return
+	}
+	if udp {
+		if target == "" {
+			log.Printf("FAR: Bridge %s received UDP associate with unknown header 0x%02x", s.BridgeName, headerType)
+			stream.CancelRead(0)
+			stream.Close()
+			return
+		}
+		// Each packet names its own target, checked as it is sent
+		s.handleUDPAssociate(stream, streamKeys{readIv, writeIv, readKey, writeKey, compression})
 		return
 	}
 
-	// 3) Dial target TCP.
-	dst, err := net.Dial("tcp", target)
+	// 2) Check for allowed outbound IPs/Hostnames
+	if err := validateTarget(target); err != nil {
+		log.Printf("FAR: Bridge %s malformed target %q: %v", s.BridgeName, target, err)
+		s.refuseStream(stream, DialFailed)
+		return
+	}
+	addrType, err = targetAddrType(target, addrType)
 	if err != nil {
-		log.Printf("FAR: dial on bridge %s failed %s error: %v", s.BridgeName, target, err)
-		stream.CancelRead(0)
-		stream.Close()
+		log.Printf("FAR: Bridge %s bad target %q: %v", s.BridgeName, target, err)
+		s.refuseStream(stream, DialFailed)
+		return
+	}
+	if s.shouldBlockFarOutConn(target, addrType) {
+		status.GlobalConnMonitorRef.IncAllowlistBlock(s.BridgeName)
+		log.Printf("FAR: Bridge %s target not allowed by the out address/port lists: %s (%s)", s.BridgeName, target, addrTypeName(addrType))
+		s.refuseStream(stream, DialNotAllowed)
 		return
 	}
+
+	client := s.streamClient(stream, clientAddr)
+	if !s.outLimit.acquire(client) {
+		status.GlobalConnMonitorRef.IncOutClientLimit(s.BridgeName)
+		log.Printf("FAR: Bridge %s client %s at %d target connections, refusing %s", s.BridgeName, client, s.outLimit.max, target)
+		stream.CancelRead(StreamErrClientLimit)
+		stream.CancelWrite(StreamErrClientLimit)
+		return
+	}
+	defer s.outLimit.release(client)
+
+	// 3) Dial target TCP, or reuse an idle connection to it. A PROXY header
+	// names one client, so those connections are never shared.
+	dialStart := time.Now()
+	var dst net.Conn
+	if !s.sendProxyProtocol {
+		dst = s.targetPool.get(client, target)
+	}
+	if dst == nil {
+		if !s.breaker.allow(target, dialStart) {
+			status.GlobalConnMonitorRef.IncDialFailure(s.BridgeName)
+			logging.Log(logging.Event{Bridge: s.BridgeName, Type: logging.EventDialFailure, Target: target, Err: ErrTargetUnavailable},
+				"FAR: dial on bridge %s skipped for %s: %v", s.BridgeName, target, ErrTargetUnavailable)
+			stream.CancelRead(StreamErrTargetUnavailable)
+			stream.CancelWrite(StreamErrTargetUnavailable)
+			return
+		}
+		dst, err = s.dialTarget(target)
+		s.breaker.record(target, err, time.Now())
+		if err != nil {
+			status.GlobalConnMonitorRef.IncDialFailure(s.BridgeName)
+			logging.Log(logging.Event{Bridge: s.BridgeName, Type: logging.EventDialFailure, Target: target, Latency: time.Since(dialStart), Err: err},
+				"FAR: dial on bridge %s failed %s error: %v", s.BridgeName, target, err)
+			s.refuseStream(stream, dialFailureCode(err))
+			return
+		}
+	}
+	if !s.sendProxyProtocol {
+		dst = s.targetPool.wrap(client, target, dst)
+	}
+	logging.Log(logging.Event{Bridge: s.BridgeName, Type: logging.EventOpen, Target: target, Latency: time.Since(dialStart)}, "")
 	// Ensure we close both sides.
 	defer func() {
 		dst.Close()
 		stream.Close()
 		status.GlobalConnMonitorRef.DecOUT()
+		logging.Log(logging.Event{Bridge: s.BridgeName, Type: logging.EventClose, Target: target, Latency: time.Since(dialStart)}, "")
 	}()
 
 	// Increment active OUT connections
 	status.GlobalConnMonitorRef.IncOUT()
 
+	if s.sendProxyProtocol {
+		// Before any client bytes so the target sees it first
+		if _, err := dst.Write(proxyHeaderV2(clientAddr, dst.RemoteAddr())); err != nil {
+			log.Printf("FAR: Bridge %s write PROXY header to %s error: %v", s.BridgeName, target, err)
+			writeDialResult(stream, DialFailed)
+			return
+		}
+	}
+
+	if err := writeDialResult(stream, DialOK); err != nil {
+		log.Printf("FAR: Bridge %s write dial result error: %v", s.BridgeName, err)
+		return
+	}
+
 	// 4) Pipe bytes both directions.
-	BidiPipe(stream, dst, s.sl, writeIv, writeKey, readIv, readKey)
+	if headerType == CONNECT_GCM_HEADER {
+		BidiPipeGcm(stream, dst, writeKey, readKey, s.pipeOptions(stream, compression))
+	} else {
+		BidiPipe(stream, dst, writeIv, writeKey, readIv, readKey, s.pipeOptions(stream, compression))
+	}
 	status.GlobalConnMonitorRef.RemoveStream(s.BridgeName)
 }
 
@@ -269,3 +697,9 @@ func (s *SalmonBridge) NewFarListen() error {
 	// Pass it down the the quic stream with the handler
 	return s.sq.NewFarListen(s.handleIncomingStream)
 }
+
+// StopFarListen stops the far listener, letting open streams finish until
+// ctx is done, so NewFarListen can run again. It returns the streams cut off.
+func (s *SalmonBridge) StopFarListen(ctx context.Context) int32 {
+	return s.sq.StopFarListen(ctx)
+}
@@ -2,9 +2,13 @@ package bridge
 
 import (
 	"crypto/tls"
+	"errors"
+	"io"
 	"net"
 	"net/http"
+	"salmoncannon/status"
 	"salmoncannon/utils"
+	"strings"
 	"testing"
 	"time"
 
@@ -318,7 +322,7 @@ func TestSalmonBridge_FailFarBridgeIpCheck(t *testing.T) {
 	quicCfg := &quic.Config{EnableDatagrams: false}
 
 	// Far bridge (listener)
-	farPort := 42000 ///////////////////// Wrong ip so it should fail
+	farPort := 42010 ///////////////////// Wrong ip so it should fail
 	farBridge := NewSalmonBridge("test1", "127.0.0.2", farPort, tlsCfg, quicCfg, nil,
 		false, "", make([]string, 0), "nil")
 	go func() {
@@ -331,19 +335,12 @@ func TestSalmonBridge_FailFarBridgeIpCheck(t *testing.T) {
 	nearBridge := NewSalmonBridge("test1", "127.0.0.1", farPort, tlsCfg, quicCfg, nil,
 		true, "", make([]string, 0), "nil")
 
-	// Open a connection from near to the HTTP server
-	conn, _ := nearBridge.NewNearConn("127.0.0.1", 1124)
-
-	// Wait for conn to fail as the check is AFTER connect
-	time.Sleep(700 * time.Millisecond)
-
-	written, werr := conn.Write([]byte("test"))
-
-	if werr == nil || written != 0 {
+	// The far never answers the stream, so opening it fails
+	conn, err := nearBridge.NewNearConn("127.0.0.1", 1124)
+	if err == nil {
+		conn.Close()
 		t.Fatalf("expected connection to fail far ip check, but it succeeded")
 	}
-
-	defer conn.Close()
 }
 
 func TestSalmonBridge_FailFarIpFilterCheck(t *testing.T) {
@@ -391,29 +388,312 @@ func TestSalmonBridge_FailFarIpFilterCheck(t *testing.T) {
 
 	// Open a connection from near to the HTTP server
 	conn, err := nearBridge.NewNearConn("127.0.0.1", 9992)
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) || dialErr.Code != DialNotAllowed {
+		if err == nil {
+			conn.Close()
+		}
+		t.Fatalf("This requiest should have been blocked on the Far IP filter, got %v", err)
+	}
+
+	// Verify HTTP server got the request
+	select {
+	case <-recv:
+		t.Fatalf("HTTP server should not have received the request")
+	case <-time.After(2 * time.Second):
+		// Success
+	}
+}
+
+func TestSalmonBridge_GcmCipherModeEndToEnd(t *testing.T) {
+	// Start a simple HTTP server
+	recv := make(chan struct{}, 1) // buffered so handler doesn't block
+
+	httpServer := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/gcm" {
+				recv <- struct{}{}
+			}
+			w.WriteHeader(200)
+			w.Write([]byte("ok"))
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:9994")
+	if err != nil {
+		t.Fatalf("failed to start http server: %v", err)
+	}
+	defer ln.Close()
+
+	go httpServer.Serve(ln)
+
+	// TLS and QUIC config
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-gcm"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	// Far bridge (listener)
+	farPort := 42040
+	farBridge := NewSalmonBridge("test-gcm", "127.0.0.1", farPort, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), "gcm-secret")
+	if err := farBridge.SetCipherMode("gcm"); err != nil {
+		t.Fatalf("failed to set far cipher mode: %v", err)
+	}
+	go func() {
+		farBridge.NewFarListen()
+	}()
+	// Wait for far to start
+	time.Sleep(700 * time.Millisecond)
+
+	// Near bridge (connector)
+	nearBridge := NewSalmonBridge("test-gcm", "127.0.0.1", farPort, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "gcm-secret")
+	if err := nearBridge.SetCipherMode("gcm"); err != nil {
+		t.Fatalf("failed to set near cipher mode: %v", err)
+	}
+
+	conn, err := nearBridge.NewNearConn("127.0.0.1", 9994)
 	if err != nil {
 		t.Fatalf("near bridge failed: %v", err)
 	}
 	defer conn.Close()
 
-	// Send HTTP request manually
-	req := "GET /test HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n"
+	req := "GET /gcm HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n"
 	if _, err := conn.Write([]byte(req)); err != nil {
 		t.Fatalf("failed to write request: %v", err)
 	}
 
-	// Read response
 	buf := make([]byte, 1024)
 	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	if _, err := conn.Read(buf); err == nil {
-		t.Fatalf("This requiest should have been blocked on the Far IP filter")
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if !strings.HasPrefix(string(buf[:n]), "HTTP/1.1 200") {
+		t.Fatalf("unexpected response: %q", buf[:n])
 	}
 
-	// Verify HTTP server got the request
 	select {
 	case <-recv:
-		t.Fatalf("HTTP server should not have received the request")
-	case <-time.After(2 * time.Second):
 		// Success
+	case <-time.After(2 * time.Second):
+		t.Fatalf("HTTP server did not receive request")
+	}
+}
+
+func TestSalmonBridge_CipherModeMismatchRejected(t *testing.T) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-gcm2"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	// Far expects GCM, near sends CTR
+	farPort := 42041
+	farBridge := NewSalmonBridge("test-gcm2", "127.0.0.1", farPort, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), "gcm-secret")
+	farBridge.SetCipherMode("gcm")
+	go func() {
+		farBridge.NewFarListen()
+	}()
+	time.Sleep(700 * time.Millisecond)
+
+	nearBridge := NewSalmonBridge("test-gcm2", "127.0.0.1", farPort, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "gcm-secret")
+
+	conn, err := nearBridge.NewNearConn("127.0.0.1", 9995)
+	if err == nil {
+		conn.Close()
+		t.Fatalf("expected stream to be rejected on cipher mode mismatch")
+	}
+}
+
+func TestSalmonBridge_SetCipherModeInvalid(t *testing.T) {
+	b := NewSalmonBridge("test-mode", "127.0.0.1", 42042, &tls.Config{}, &quic.Config{},
+		nil, true, "", make([]string, 0), "")
+	if err := b.SetCipherMode("rot13"); err == nil {
+		t.Fatalf("expected error for unknown cipher mode")
+	}
+	if err := b.SetCipherMode(""); err != nil {
+		t.Fatalf("expected empty cipher mode to default to ctr: %v", err)
+	}
+}
+
+func TestSalmonBridge_KeepalivePing(t *testing.T) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-keepalive"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	farPort := 42042
+	farBridge := NewSalmonBridge("test-keepalive", "127.0.0.1", farPort, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), "")
+	defer farBridge.Close()
+	go func() {
+		farBridge.NewFarListen()
+	}()
+	time.Sleep(700 * time.Millisecond)
+
+	nearBridge := NewSalmonBridge("test-keepalive", "127.0.0.1", farPort, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "")
+	defer nearBridge.Close()
+
+	stream, cleanup, err, _ := nearBridge.Quic().OpenStream()
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer cleanup()
+	defer stream.Close()
+
+	stream.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := nearBridge.keepalivePing(stream); err != nil {
+		t.Fatalf("expected keepalive ping to succeed against far bridge: %v", err)
+	}
+}
+
+func TestSalmonBridge_LastError(t *testing.T) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-lasterr"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	nearBridge := NewSalmonBridge("test-lasterr", "127.0.0.1", 42066, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "")
+	defer nearBridge.Close()
+	nearBridge.Quic().SetTimeouts(300*time.Millisecond, 0)
+	nearBridge.Quic().SetReconnectBackoff(0, 0)
+
+	// Nothing is listening yet
+	if _, err := nearBridge.NewNearConn("127.0.0.1", 9); err == nil {
+		t.Fatalf("expected no far side to fail")
+	}
+	lastErr, ok := status.GlobalConnMonitorRef.LastError("test-lasterr")
+	if !ok || lastErr.Message == "" || lastErr.Time.IsZero() {
+		t.Fatalf("expected the failure to be recorded, got %+v", lastErr)
+	}
+
+	farBridge := NewSalmonBridge("test-lasterr", "127.0.0.1", 42066, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), "")
+	defer farBridge.Close()
+	go farBridge.NewFarListen()
+	time.Sleep(700 * time.Millisecond)
+
+	// The target refuses, but the far answered, so the bridge is fine
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+	var dialErr *DialError
+	if _, err := nearBridge.NewNearConn("127.0.0.1", closedPort); !errors.As(err, &dialErr) {
+		t.Fatalf("expected a dial error from the far side, got %v", err)
+	}
+	if lastErr, ok := status.GlobalConnMonitorRef.LastError("test-lasterr"); ok {
+		t.Fatalf("expected reaching the far side to clear the error, got %+v", lastErr)
+	}
+}
+
+func TestSalmonBridge_DialResultTimeout(t *testing.T) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-dialwait"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	// A far side that takes the stream but never reports a dial result
+	ln, err := quic.ListenAddr("127.0.0.1:42067", tlsCfg, quicCfg)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept(t.Context())
+		if err != nil {
+			return
+		}
+		for {
+			if _, err := conn.AcceptStream(t.Context()); err != nil {
+				return
+			}
+		}
+	}()
+
+	nearBridge := NewSalmonBridge("test-dialwait", "127.0.0.1", 42067, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "")
+	defer nearBridge.Close()
+	nearBridge.dialResultTimeout = 300 * time.Millisecond
+
+	start := time.Now()
+	_, err = nearBridge.NewNearConn("127.0.0.1", 9)
+	if err == nil || !strings.Contains(err.Error(), "no dial result") {
+		t.Fatalf("expected a dial result timeout, got %v", err)
+	}
+	var dialErr *DialError
+	if errors.As(err, &dialErr) {
+		t.Fatalf("a silent far side is not a dial error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected to give up promptly, took %s", elapsed)
+	}
+	if _, ok := status.GlobalConnMonitorRef.LastError("test-dialwait"); !ok {
+		t.Fatalf("expected the silent far side to be recorded as the last error")
+	}
+}
+
+func TestSalmonBridge_ConnectionByteCounters(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer target.Close()
+	go func() {
+		c, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-conn-stats"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	farPort := 42078
+	farBridge := NewSalmonBridge("test-conn-stats", "127.0.0.1", farPort, tlsCfg, quicCfg,
+		nil, false, "", []string{"127.0.0.1"}, "")
+	defer farBridge.Close()
+	go farBridge.NewFarListen()
+	time.Sleep(700 * time.Millisecond)
+
+	nearBridge := NewSalmonBridge("test-conn-stats", "127.0.0.1", farPort, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "")
+	defer nearBridge.Close()
+
+	conn, err := nearBridge.NewNearConn("127.0.0.1", target.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatalf("near bridge failed: %v", err)
+	}
+	defer conn.Close()
+
+	payload := make([]byte, 64*1024)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	go conn.Write(payload)
+	if _, err := io.ReadFull(conn, make([]byte, len(payload))); err != nil {
+		t.Fatalf("failed to read echo: %v", err)
+	}
+
+	// Each side's one connection carried the payload both ways
+	want := uint64(len(payload))
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		near, far := nearBridge.Quic().Stats(), farBridge.Quic().Stats()
+		if len(near) == 1 && len(far) == 1 &&
+			near[0].BytesSent >= want && near[0].BytesReceived >= want &&
+			far[0].BytesSent >= want && far[0].BytesReceived >= want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected one connection each side carrying %d bytes both ways, got near %+v far %+v", want, near, far)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := nearBridge.Quic().Stats()[0].ActiveStreams; got != 1 {
+		t.Errorf("expected the open stream to be counted, got %d", got)
 	}
 }
@@ -0,0 +1,64 @@
+package bridge
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultRelayBufferSize is the copy buffer size used when a bridge does not
+// set one. It matches the io.Copy default.
+const DefaultRelayBufferSize = 32 * 1024
+
+// BufferPool hands out relay copy buffers of one size. Each copy takes its
+// own buffer and returns it when done, so a buffer is never shared by two
+// copies at once. A nil *BufferPool uses the default sized pool.
+type BufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+var relayPools sync.Map // int -> *BufferPool
+
+// RelayBufferPool returns the shared pool for size, creating it on first
+// use. size <= 0 gives the DefaultRelayBufferSize pool.
+func RelayBufferPool(size int) *BufferPool {
+	if size <= 0 {
+		size = DefaultRelayBufferSize
+	}
+	if p, ok := relayPools.Load(size); ok {
+		return p.(*BufferPool)
+	}
+	p := &BufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	actual, _ := relayPools.LoadOrStore(size, p)
+	return actual.(*BufferPool)
+}
+
+// Size returns the length of the buffers in the pool.
+func (p *BufferPool) Size() int {
+	if p == nil {
+		return RelayBufferPool(0).size
+	}
+	return p.size
+}
+
+// Copy is io.CopyBuffer with a buffer borrowed from the pool. It reads no
+// more than one buffer ahead of what dst has taken, so a slow dst holds src
+// back instead of data queueing up in memory.
+func (p *BufferPool) Copy(dst io.Writer, src io.Reader) (int64, error) {
+	if p == nil {
+		p = RelayBufferPool(0)
+	}
+	bufp := p.pool.Get().(*[]byte)
+	defer p.pool.Put(bufp)
+	return io.CopyBuffer(dst, src, *bufp)
+}
+
+// SetRelayBufferSize sets the buffer size used to copy stream payloads.
+// size <= 0 keeps DefaultRelayBufferSize.
+func (s *SalmonBridge) SetRelayBufferSize(size int) {
+	s.relayBufs = RelayBufferPool(size)
+}
@@ -0,0 +1,129 @@
+package bridge
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// onlyReader hides any WriterTo so copies have to use their buffer.
+type onlyReader struct{ io.Reader }
+
+// onlyWriter hides any ReaderFrom for the same reason.
+type onlyWriter struct{ io.Writer }
+
+func TestRelayBufferPool(t *testing.T) {
+	if RelayBufferPool(0) != RelayBufferPool(DefaultRelayBufferSize) {
+		t.Fatalf("expected size <= 0 to share the default pool")
+	}
+	if RelayBufferPool(4096) != RelayBufferPool(4096) {
+		t.Fatalf("expected one pool per size")
+	}
+	var nilPool *BufferPool
+	if nilPool.Size() != DefaultRelayBufferSize {
+		t.Fatalf("expected nil pool to use the default size, got %d", nilPool.Size())
+	}
+
+	// Concurrent copies must each get their own buffer
+	payload := bytes.Repeat([]byte("salmon"), 10000)
+	pool := RelayBufferPool(1024)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out bytes.Buffer
+			n, err := pool.Copy(onlyWriter{&out}, onlyReader{bytes.NewReader(payload)})
+			if err != nil || n != int64(len(payload)) || !bytes.Equal(out.Bytes(), payload) {
+				t.Errorf("copy mismatch: n=%d err=%v", n, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSetRelayBufferSize(t *testing.T) {
+	sb := &SalmonBridge{}
+	sb.SetRelayBufferSize(8192)
+	if sb.relayBufs.Size() != 8192 {
+		t.Fatalf("expected 8192 byte buffers, got %d", sb.relayBufs.Size())
+	}
+	sb.SetRelayBufferSize(0)
+	if sb.relayBufs.Size() != DefaultRelayBufferSize {
+		t.Fatalf("expected size <= 0 to use the default, got %d", sb.relayBufs.Size())
+	}
+}
+
+// fastSource hands out up to limit bytes as fast as they are asked for.
+type fastSource struct {
+	read  atomic.Int64
+	limit int64
+}
+
+func (f *fastSource) Read(p []byte) (int, error) {
+	left := f.limit - f.read.Load()
+	if left <= 0 {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), left))
+	f.read.Add(int64(n))
+	return n, nil
+}
+
+// slowTunnel takes a small chunk per write, like a tunnel held back by flow
+// control, and checks how far the source has run ahead of it.
+type slowTunnel struct {
+	src      *fastSource
+	written  int64
+	maxAhead int64
+}
+
+func (s *slowTunnel) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		n := min(len(p), 512)
+		time.Sleep(100 * time.Microsecond)
+		s.written += int64(n)
+		s.maxAhead = max(s.maxAhead, s.src.read.Load()-s.written)
+		p = p[n:]
+	}
+	return total, nil
+}
+
+func TestRelayCopyBoundsInFlightBytes(t *testing.T) {
+	// The relay never reads more from the source than one buffer ahead of
+	// what the tunnel took, so a slow tunnel cannot make it queue data
+	pool := RelayBufferPool(4096)
+	src := &fastSource{limit: 256 * 1024}
+	tunnel := &slowTunnel{src: src}
+	if _, err := pool.Copy(onlyWriter{tunnel}, onlyReader{src}); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if tunnel.written != src.limit {
+		t.Fatalf("expected %d bytes through the tunnel, got %d", src.limit, tunnel.written)
+	}
+	if tunnel.maxAhead > int64(pool.Size()) {
+		t.Fatalf("source ran %d bytes ahead of the tunnel, want at most %d", tunnel.maxAhead, pool.Size())
+	}
+}
+
+var relayPayload = bytes.Repeat([]byte{0x5a}, 256*1024)
+
+func BenchmarkRelayCopy(b *testing.B) {
+	b.Run("io.Copy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			io.Copy(onlyWriter{io.Discard}, onlyReader{bytes.NewReader(relayPayload)})
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		pool := RelayBufferPool(DefaultRelayBufferSize)
+		for i := 0; i < b.N; i++ {
+			pool.Copy(onlyWriter{io.Discard}, onlyReader{bytes.NewReader(relayPayload)})
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package bridge
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// coalesceMaxBytes is the most a coalescingWriter holds back. Writes this
+// big or bigger go straight through, after anything already pending.
+const coalesceMaxBytes = 16 * 1024
+
+// MaxCoalesceDelay caps SetCoalesceDelay, so batching can never add more
+// than a barely noticeable delay.
+const MaxCoalesceDelay = 100 * time.Millisecond
+
+// SetCoalesceDelay batches the small writes this side sends into a stream
+// for up to delay, so chatty protocols go out as fewer, larger writes
+// through compression, encryption and QUIC. Large writes are never held.
+// 0 disables it.
+func (s *SalmonBridge) SetCoalesceDelay(delay time.Duration) {
+	s.coalesceDelay = min(max(delay, 0), MaxCoalesceDelay)
+}
+
+// coalescingWriter buffers small writes until delay has passed since the
+// first of them or coalesceMaxBytes are pending, then writes them to w as
+// one. A write that fails from the timer is returned by the next Write or
+// flush.
+type coalescingWriter struct {
+	w     io.Writer
+	delay time.Duration
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer
+	err   error
+}
+
+// newCoalescingWriter wraps w, or returns nil when delay is 0. The methods
+// of a nil *coalescingWriter do nothing.
+func newCoalescingWriter(w io.Writer, delay time.Duration) *coalescingWriter {
+	if delay <= 0 {
+		return nil
+	}
+	return &coalescingWriter{w: w, delay: delay}
+}
+
+func (c *coalescingWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if len(c.buf)+len(p) > coalesceMaxBytes {
+		if err := c.flushLocked(); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) >= coalesceMaxBytes {
+		return c.w.Write(p)
+	}
+
+	if len(c.buf) == 0 {
+		if c.timer == nil {
+			c.timer = time.AfterFunc(c.delay, c.timedFlush)
+		} else {
+			c.timer.Reset(c.delay)
+		}
+	}
+	// p belongs to the caller's copy buffer, which is reused
+	c.buf = append(c.buf, p...)
+	if len(c.buf) == coalesceMaxBytes {
+		if err := c.flushLocked(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (c *coalescingWriter) timedFlush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.flushLocked()
+	}
+}
+
+// flush writes out anything pending, e.g. before the stream is half-closed.
+func (c *coalescingWriter) flush() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return c.flushLocked()
+}
+
+// stop drops anything pending once the stream is being torn down.
+func (c *coalescingWriter) stop() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.buf = nil
+	c.err = io.ErrClosedPipe
+}
+
+func (c *coalescingWriter) flushLocked() error {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	if len(c.buf) == 0 {
+		return nil
+	}
+	_, err := c.w.Write(c.buf)
+	c.buf = c.buf[:0]
+	if err != nil {
+		c.err = err
+	}
+	return err
+}
@@ -0,0 +1,246 @@
+package bridge
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"io"
+	"net"
+	"salmoncannon/crypt"
+	"salmoncannon/utils"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+// recordingWriter keeps every write it gets as a separate chunk.
+type recordingWriter struct {
+	mu     sync.Mutex
+	chunks [][]byte
+	at     []time.Time
+}
+
+func (r *recordingWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.chunks = append(r.chunks, append([]byte(nil), p...))
+	r.at = append(r.at, time.Now())
+	return len(p), nil
+}
+
+func (r *recordingWriter) writes() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]byte(nil), r.chunks...)
+}
+
+func TestCoalescingWriter_BatchesSmallWrites(t *testing.T) {
+	rec := &recordingWriter{}
+	c := newCoalescingWriter(rec, time.Hour)
+	for i := 0; i < 10; i++ {
+		if n, err := c.Write([]byte("ping")); n != 4 || err != nil {
+			t.Fatalf("write returned %d %v", n, err)
+		}
+	}
+	if got := rec.writes(); len(got) != 0 {
+		t.Fatalf("expected small writes to be held, got %d", len(got))
+	}
+	if err := c.flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	got := rec.writes()
+	if len(got) != 1 || !bytes.Equal(got[0], bytes.Repeat([]byte("ping"), 10)) {
+		t.Fatalf("expected one batched write, got %q", got)
+	}
+}
+
+func TestCoalescingWriter_LargeWritesBypass(t *testing.T) {
+	rec := &recordingWriter{}
+	c := newCoalescingWriter(rec, time.Hour)
+	large := make([]byte, coalesceMaxBytes)
+	rand.Read(large)
+
+	c.Write([]byte("head"))
+	c.Write(large)
+	got := rec.writes()
+	// The pending bytes go first so nothing is reordered
+	if len(got) != 2 || string(got[0]) != "head" || !bytes.Equal(got[1], large) {
+		t.Fatalf("expected the pending write then the large one, got %d writes", len(got))
+	}
+
+	// Filling up to the threshold flushes without waiting for the timer
+	small := make([]byte, coalesceMaxBytes/4)
+	for i := 0; i < 4; i++ {
+		c.Write(small)
+	}
+	if got := rec.writes(); len(got) != 3 || len(got[2]) != coalesceMaxBytes {
+		t.Fatalf("expected a full batch to be written, got %d writes", len(got))
+	}
+}
+
+func TestCoalescingWriter_LatencyBound(t *testing.T) {
+	const delay = 5 * time.Millisecond
+	rec := &recordingWriter{}
+	c := newCoalescingWriter(rec, delay)
+
+	for round := 0; round < 3; round++ {
+		start := time.Now()
+		c.Write([]byte("key"))
+		c.Write([]byte("stroke"))
+		deadline := time.Now().Add(time.Second)
+		for len(rec.writes()) <= round && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		got := rec.writes()
+		if len(got) != round+1 || string(got[round]) != "keystroke" {
+			t.Fatalf("round %d: expected one batched write, got %q", round, got)
+		}
+		rec.mu.Lock()
+		waited := rec.at[round].Sub(start)
+		rec.mu.Unlock()
+		// Generous slack for a loaded CI machine, still far below a second
+		if waited < delay || waited > delay+100*time.Millisecond {
+			t.Fatalf("round %d: expected the batch after about %v, got %v", round, delay, waited)
+		}
+	}
+}
+
+func TestCoalescingWriter_Disabled(t *testing.T) {
+	if c := newCoalescingWriter(&recordingWriter{}, 0); c != nil {
+		t.Fatalf("expected no coalescing for a 0 delay")
+	}
+	var c *coalescingWriter
+	if err := c.flush(); err != nil {
+		t.Fatalf("expected a nil writer to flush cleanly, got %v", err)
+	}
+	c.stop()
+
+	b := &SalmonBridge{}
+	b.SetCoalesceDelay(time.Hour)
+	if b.coalesceDelay != MaxCoalesceDelay {
+		t.Fatalf("expected the delay capped at %v, got %v", MaxCoalesceDelay, b.coalesceDelay)
+	}
+}
+
+func TestCoalescingWriter_StopDropsPending(t *testing.T) {
+	rec := &recordingWriter{}
+	c := newCoalescingWriter(rec, time.Millisecond)
+	c.Write([]byte("late"))
+	c.stop()
+	time.Sleep(20 * time.Millisecond)
+	if got := rec.writes(); len(got) != 0 {
+		t.Fatalf("expected nothing written after stop, got %q", got)
+	}
+	if _, err := c.Write([]byte("more")); err == nil {
+		t.Fatalf("expected writes after stop to fail")
+	}
+}
+
+// discardConn counts and drops what is written to it.
+type discardConn struct {
+	net.Conn
+	n atomic.Int64
+}
+
+func (d *discardConn) Write(p []byte) (int, error) {
+	d.n.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// BenchmarkCoalesceSmallWrites sends 64 byte writes through an AES-GCM
+// tunnel, which seals every write it gets as its own frame.
+func BenchmarkCoalesceSmallWrites(b *testing.B) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	payload := make([]byte, 64)
+	for _, delay := range []time.Duration{0, time.Millisecond} {
+		name := "direct"
+		if delay > 0 {
+			name = "coalesced"
+		}
+		b.Run(name, func(b *testing.B) {
+			wire := &discardConn{}
+			tunnel := crypt.AesGcmWrapConn(wire, key, key)
+			var out io.Writer = tunnel
+			batch := newCoalescingWriter(tunnel, delay)
+			if batch != nil {
+				out = batch
+			}
+			b.SetBytes(int64(len(payload)))
+			for i := 0; i < b.N; i++ {
+				out.Write(payload)
+			}
+			batch.flush()
+			b.ReportMetric(float64(wire.n.Load())/float64(b.N*len(payload)), "wire/payload")
+		})
+	}
+}
+
+func TestSalmonBridge_CoalesceEndToEnd(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	targetPort := ln.Addr().(*net.TCPAddr).Port
+
+	name := "test-coalesce"
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{name},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	farBridge := NewSalmonBridge(name, "127.0.0.1", 42079, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), "coalesce-secret")
+	farBridge.SetCoalesceDelay(2 * time.Millisecond)
+	defer farBridge.Close()
+	go farBridge.NewFarListen()
+	time.Sleep(700 * time.Millisecond)
+
+	nearBridge := NewSalmonBridge(name, "127.0.0.1", 42079, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "coalesce-secret")
+	nearBridge.SetCoalesceDelay(2 * time.Millisecond)
+	defer nearBridge.Close()
+
+	conn, err := nearBridge.NewNearConn("127.0.0.1", targetPort)
+	if err != nil {
+		t.Fatalf("near bridge failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Interactive traffic: each small message must come back without the
+	// next write to push it out
+	buf := make([]byte, 5)
+	for i := 0; i < 20; i++ {
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+			t.Fatalf("round %d: echo failed: %q %v", i, buf, err)
+		}
+	}
+
+	// A half-close flushes whatever is still held back
+	conn.Write([]byte("bye"))
+	conn.(interface{ CloseWrite() error }).CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	rest, err := io.ReadAll(conn)
+	if err != nil || string(rest) != "bye" {
+		t.Fatalf("expected the tail before EOF, got %q %v", rest, err)
+	}
+}
@@ -0,0 +1,227 @@
+package bridge
+
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+	"salmoncannon/status"
+
+	"github.com/golang/snappy"
+)
+
+const CompressionNone = "none"
+const CompressionFlate = "flate"
+const CompressionSnappy = "snappy"
+
+// Algorithm IDs carried after COMPRESS_HEADER
+const compressIdFlate = 0x01
+const compressIdSnappy = 0x02
+
+// Bytes written before deciding whether a stream is worth compressing.
+const compressSampleBytes = 16 * 1024
+
+// If the sample shrinks by less than this, the stream is most likely already
+// compressed (TLS, images, archives) and the rest is sent as stored blocks.
+const compressMinSavingRatio = 0.95
+
+// SetCompression selects the stream payload compression the near side asks
+// for: CompressionNone (default), CompressionFlate or CompressionSnappy. The
+// far side follows whatever each stream's header requests.
+func (s *SalmonBridge) SetCompression(mode string) error {
+	switch mode {
+	case "", CompressionNone:
+		s.compression = CompressionNone
+	case CompressionFlate, CompressionSnappy:
+		s.compression = mode
+	default:
+		return fmt.Errorf("unknown compression %q (must be %q, %q or %q)", mode,
+			CompressionNone, CompressionFlate, CompressionSnappy)
+	}
+	return nil
+}
+
+// writeCompressHeader announces the compression for a stream. Nothing is
+// written when compression is off so uncompressed streams are unchanged.
+func writeCompressHeader(w io.Writer, mode string) error {
+	switch mode {
+	case "", CompressionNone:
+		return nil
+	case CompressionFlate:
+		_, err := w.Write([]byte{COMPRESS_HEADER, compressIdFlate})
+		return err
+	case CompressionSnappy:
+		_, err := w.Write([]byte{COMPRESS_HEADER, compressIdSnappy})
+		return err
+	}
+	return fmt.Errorf("unknown compression %q", mode)
+}
+
+// readCompressHeader reads the algorithm ID following a COMPRESS_HEADER.
+func readCompressHeader(r io.Reader) (string, error) {
+	id, err := ReadHeaderType(r)
+	if err != nil {
+		return "", err
+	}
+	switch id {
+	case compressIdFlate:
+		return CompressionFlate, nil
+	case compressIdSnappy:
+		return CompressionSnappy, nil
+	}
+	return "", fmt.Errorf("unknown compression id 0x%02x", id)
+}
+
+// compressTunnel wraps tunnel so writes are compressed and reads
+// decompressed. It must sit outside any encryption so data is compressed
+// before it is encrypted. Bytes before and after compression, in both
+// directions, are added to stats when it is not nil.
+func compressTunnel(tunnel io.ReadWriter, mode string, stats *status.CompressionCounter) io.ReadWriter {
+	counter := &countingWriter{w: tunnel}
+	readCounter := &countingReader{r: tunnel}
+	switch mode {
+	case CompressionFlate:
+		// Only fails for an invalid level
+		fw, _ := flate.NewWriter(counter, flate.DefaultCompression)
+		return &flateTunnel{
+			tunnel:      tunnel,
+			reader:      flate.NewReader(readCounter),
+			writer:      fw,
+			counter:     counter,
+			readCounter: readCounter,
+			stats:       stats,
+		}
+	case CompressionSnappy:
+		return &snappyTunnel{
+			reader:      snappy.NewReader(readCounter),
+			writer:      snappy.NewBufferedWriter(counter),
+			counter:     counter,
+			readCounter: readCounter,
+			stats:       stats,
+		}
+	}
+	return tunnel
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// flateTunnel flushes after every write so interactive traffic isn't held
+// back waiting for a full block.
+type flateTunnel struct {
+	tunnel  io.ReadWriter
+	reader  io.ReadCloser
+	writer  *flate.Writer
+	counter *countingWriter
+
+	readCounter *countingReader
+	readTotal   int64 // compressed bytes read so far and reported to stats
+	stats       *status.CompressionCounter
+
+	sampled int64 // plaintext bytes written while sampling
+	decided bool
+}
+
+func (t *flateTunnel) Read(p []byte) (int, error) {
+	n, err := t.reader.Read(p)
+	// The reader reads ahead, so the compressed side is credited as it comes in
+	read := t.readCounter.n
+	t.stats.Add(int64(n), read-t.readTotal)
+	t.readTotal = read
+	return n, err
+}
+
+func (t *flateTunnel) Write(p []byte) (int, error) {
+	before := t.counter.n
+	n, err := t.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if err := t.writer.Flush(); err != nil {
+		return n, err
+	}
+	t.stats.Add(int64(n), t.counter.n-before)
+	if !t.decided {
+		t.sampled += int64(n)
+		if t.sampled >= compressSampleBytes {
+			t.decided = true
+			if float64(t.counter.n) > float64(t.sampled)*compressMinSavingRatio {
+				// Deflate streams are a sequence of blocks, so after a flush a
+				// new writer can carry on with stored blocks. The reader
+				// can't tell the difference.
+				t.writer, _ = flate.NewWriter(t.counter, flate.NoCompression)
+			}
+		}
+	}
+	return n, nil
+}
+
+// CloseWrite ends the deflate stream so the reader sees a clean EOF.
+func (t *flateTunnel) CloseWrite() error {
+	before := t.counter.n
+	err := t.writer.Close()
+	t.stats.Add(0, t.counter.n-before)
+	return err
+}
+
+// snappyTunnel uses the snappy framing format. Each write is flushed as its
+// own chunks, and chunks that don't shrink are sent uncompressed by the
+// format itself, so no sampling is needed. Much cheaper on CPU than flate
+// at the cost of a worse ratio.
+type snappyTunnel struct {
+	reader  *snappy.Reader
+	writer  *snappy.Writer
+	counter *countingWriter
+
+	readCounter *countingReader
+	readTotal   int64 // compressed bytes read so far and reported to stats
+	stats       *status.CompressionCounter
+}
+
+func (t *snappyTunnel) Read(p []byte) (int, error) {
+	n, err := t.reader.Read(p)
+	read := t.readCounter.n
+	t.stats.Add(int64(n), read-t.readTotal)
+	t.readTotal = read
+	return n, err
+}
+
+func (t *snappyTunnel) Write(p []byte) (int, error) {
+	before := t.counter.n
+	n, err := t.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if err := t.writer.Flush(); err != nil {
+		return n, err
+	}
+	t.stats.Add(int64(n), t.counter.n-before)
+	return n, nil
+}
+
+// CloseWrite flushes anything buffered. Snappy frames need no trailer, so
+// the reader sees EOF as soon as the tunnel itself ends.
+func (t *snappyTunnel) CloseWrite() error {
+	before := t.counter.n
+	err := t.writer.Flush()
+	t.stats.Add(0, t.counter.n-before)
+	return err
+}
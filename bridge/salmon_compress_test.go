@@ -0,0 +1,257 @@
+package bridge
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"io"
+	"net"
+	"salmoncannon/crypt"
+	"salmoncannon/status"
+	"salmoncannon/utils"
+	"strings"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+func textPayload(size int) []byte {
+	line := "GET /index.html HTTP/1.1\r\nHost: example.com\r\nAccept: text/html\r\n\r\n"
+	return []byte(strings.Repeat(line, size/len(line)+1)[:size])
+}
+
+// compressRoundTrip compresses payload into a buffer in chunks, then reads it
+// back. It returns the decompressed bytes and the compressed size.
+func compressRoundTrip(t testing.TB, mode string, payload []byte) ([]byte, int) {
+	t.Helper()
+	var wire bytes.Buffer
+	w := compressTunnel(&wire, mode, nil)
+	for off := 0; off < len(payload); off += 4096 {
+		end := min(off+4096, len(payload))
+		if _, err := w.Write(payload[off:end]); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	if err := w.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+		t.Fatalf("close write failed: %v", err)
+	}
+	compressed := wire.Len()
+
+	got, err := io.ReadAll(compressTunnel(&wire, mode, nil))
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	return got, compressed
+}
+
+func TestCompressTunnel_RoundTripText(t *testing.T) {
+	payload := textPayload(128 * 1024)
+	for _, mode := range []string{CompressionFlate, CompressionSnappy} {
+		got, compressed := compressRoundTrip(t, mode, payload)
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("%s: round trip mismatch", mode)
+		}
+		if compressed > len(payload)/4 {
+			t.Errorf("%s: expected text to compress well, %d -> %d bytes", mode, len(payload), compressed)
+		}
+	}
+}
+
+func TestCompressTunnel_IncompressibleSentStored(t *testing.T) {
+	payload := make([]byte, 256*1024)
+	rand.Read(payload)
+
+	var wire bytes.Buffer
+	w := compressTunnel(&wire, CompressionFlate, nil).(*flateTunnel)
+	w.Write(payload[:compressSampleBytes])
+	if !w.decided {
+		t.Fatalf("expected a decision after %d bytes", compressSampleBytes)
+	}
+
+	// Snappy stores chunks that don't shrink on its own
+	for _, mode := range []string{CompressionFlate, CompressionSnappy} {
+		got, compressed := compressRoundTrip(t, mode, payload)
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("%s: round trip mismatch", mode)
+		}
+		// Stored blocks only add a few bytes of framing per flush
+		if compressed > len(payload)+len(payload)/100 {
+			t.Errorf("%s: expected random data to pass through, %d -> %d bytes", mode, len(payload), compressed)
+		}
+	}
+}
+
+func TestCompressTunnel_NoneIsPassthrough(t *testing.T) {
+	var wire bytes.Buffer
+	if compressTunnel(&wire, CompressionNone, nil) != io.ReadWriter(&wire) {
+		t.Fatalf("expected CompressionNone to return the tunnel unchanged")
+	}
+}
+
+func TestCompressTunnel_CountsBytes(t *testing.T) {
+	mon := &status.ConnectionMonitor{}
+	stats := mon.CompressionCounter("count-compress")
+	payload := textPayload(64 * 1024)
+
+	var wire bytes.Buffer
+	w := compressTunnel(&wire, CompressionFlate, stats)
+	if _, err := w.Write(payload); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if err := w.(*flateTunnel).CloseWrite(); err != nil {
+		t.Fatalf("close write failed: %v", err)
+	}
+	compressed := int64(wire.Len())
+	sent := mon.Compression("count-compress")
+	if sent.UncompressedBytes != int64(len(payload)) || sent.CompressedBytes != compressed {
+		t.Fatalf("expected %d -> %d bytes after writing, got %+v", len(payload), compressed, sent)
+	}
+
+	got, err := io.ReadAll(compressTunnel(&wire, CompressionFlate, stats))
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("round trip failed: %v", err)
+	}
+	// Reading back counts the same bytes a second time
+	both := mon.Compression("count-compress")
+	if both.UncompressedBytes != 2*int64(len(payload)) || both.CompressedBytes != 2*compressed {
+		t.Fatalf("expected %d -> %d bytes after reading, got %+v", 2*len(payload), 2*compressed, both)
+	}
+	if want := float64(len(payload)) / float64(compressed); both.Ratio() != want {
+		t.Errorf("expected ratio %v, got %v", want, both.Ratio())
+	}
+}
+
+func TestCompressHeader_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeCompressHeader(&buf, CompressionNone); err != nil || buf.Len() != 0 {
+		t.Fatalf("expected nothing written for none, got %d bytes (err %v)", buf.Len(), err)
+	}
+	for _, want := range []string{CompressionFlate, CompressionSnappy} {
+		if err := writeCompressHeader(&buf, want); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		if hdr, _ := ReadHeaderType(&buf); hdr != COMPRESS_HEADER {
+			t.Fatalf("expected COMPRESS_HEADER, got 0x%02x", hdr)
+		}
+		mode, err := readCompressHeader(&buf)
+		if err != nil || mode != want {
+			t.Fatalf("expected %s, got %q (err %v)", want, mode, err)
+		}
+	}
+	if _, err := readCompressHeader(bytes.NewReader([]byte{0x7f})); err == nil {
+		t.Fatalf("expected unknown compression id to be rejected")
+	}
+}
+
+func TestSalmonBridge_SetCompressionInvalid(t *testing.T) {
+	b := NewSalmonBridge("test-compress-invalid", "127.0.0.1", 0, nil, nil,
+		nil, true, "", make([]string, 0), "")
+	if err := b.SetCompression("lz4"); err == nil {
+		t.Fatalf("expected unsupported compression to be rejected")
+	}
+	if err := b.SetCompression(CompressionSnappy); err != nil || b.compression != CompressionSnappy {
+		t.Fatalf("expected snappy to be accepted, got %q (err %v)", b.compression, err)
+	}
+	if err := b.SetCompression(""); err != nil || b.compression != CompressionNone {
+		t.Fatalf("expected empty compression to mean none, got %q (err %v)", b.compression, err)
+	}
+}
+
+func TestSalmonBridge_CompressionEndToEnd(t *testing.T) {
+	// Echo server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	targetPort := ln.Addr().(*net.TCPAddr).Port
+
+	cases := []struct {
+		name     string
+		port     int
+		secret   string
+		cipher   string
+		compress string
+	}{
+		{"plain", 42061, "", crypt.CipherModeCtr, CompressionFlate},
+		{"ctr", 42062, "compress-secret", crypt.CipherModeCtr, CompressionFlate},
+		{"gcm", 42063, "compress-secret", crypt.CipherModeGcm, CompressionFlate},
+		{"snappy", 42082, "compress-secret", crypt.CipherModeGcm, CompressionSnappy},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			name := "test-compress-" + tc.name
+			tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{name},
+				Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+			quicCfg := &quic.Config{EnableDatagrams: false}
+
+			// The far side follows the near's choice without being configured
+			farBridge := NewSalmonBridge(name, "127.0.0.1", tc.port, tlsCfg, quicCfg,
+				nil, false, "", make([]string, 0), tc.secret)
+			farBridge.SetCipherMode(tc.cipher)
+			defer farBridge.Close()
+			go farBridge.NewFarListen()
+			time.Sleep(700 * time.Millisecond)
+
+			nearBridge := NewSalmonBridge(name, "127.0.0.1", tc.port, tlsCfg, quicCfg,
+				nil, true, "", make([]string, 0), tc.secret)
+			nearBridge.SetCipherMode(tc.cipher)
+			if err := nearBridge.SetCompression(tc.compress); err != nil {
+				t.Fatalf("failed to set compression: %v", err)
+			}
+			defer nearBridge.Close()
+
+			conn, err := nearBridge.NewNearConn("127.0.0.1", targetPort)
+			if err != nil {
+				t.Fatalf("near bridge failed: %v", err)
+			}
+			defer conn.Close()
+
+			payload := textPayload(64 * 1024)
+			go conn.Write(payload)
+
+			got := make([]byte, len(payload))
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := io.ReadFull(conn, got); err != nil {
+				t.Fatalf("failed to read echo: %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("echoed payload does not match")
+			}
+		})
+	}
+}
+
+func BenchmarkCompressTunnel(b *testing.B) {
+	random := make([]byte, 1024*1024)
+	rand.Read(random)
+	payloads := map[string][]byte{
+		"text":   textPayload(1024 * 1024),
+		"random": random,
+	}
+	for _, mode := range []string{CompressionFlate, CompressionSnappy} {
+		for name, payload := range payloads {
+			b.Run(mode+"/"+name, func(b *testing.B) {
+				b.SetBytes(int64(len(payload)))
+				var compressed int
+				for i := 0; i < b.N; i++ {
+					_, compressed = compressRoundTrip(b, mode, payload)
+				}
+				b.ReportMetric(float64(compressed)/float64(len(payload)), "ratio")
+			})
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package bridge
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"salmoncannon/connections"
+	"sync"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+// A control stream carries frames of a type byte, a 2 byte big endian
+// payload length and the payload. Either side skips frame types it does not
+// know, so new ones can be added without breaking older peers.
+const (
+	ctrlHello = 0x01 // payload: the sender's controlVersion, then from a far its 8 byte epoch
+	ctrlPing  = 0x02 // payload: 8 byte sequence number
+	ctrlPong  = 0x03 // payload: the sequence number of the ping answered
+)
+
+// controlVersion is the control protocol version this side speaks. From
+// version 2 on the far's hello carries its epoch.
+const controlVersion = 2
+
+// maxControlPayload bounds the payload of one control frame.
+const maxControlPayload = 4096
+
+// controlTimeout bounds the far's wait for a near's hello and every write
+// on a control stream.
+const controlTimeout = 5 * time.Second
+
+func writeControlFrame(w io.Writer, typ byte, payload []byte) error {
+	if len(payload) > maxControlPayload {
+		return fmt.Errorf("control frame payload too long: %d bytes", len(payload))
+	}
+	frame := make([]byte, 3+len(payload))
+	frame[0] = typ
+	binary.BigEndian.PutUint16(frame[1:3], uint16(len(payload)))
+	copy(frame[3:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readControlFrame(r io.Reader) (byte, []byte, error) {
+	var hdr [3]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint16(hdr[1:]))
+	if n > maxControlPayload {
+		return 0, nil, fmt.Errorf("control frame payload too long: %d bytes", n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], payload, nil
+}
+
+// readControlHello reads the hello that starts a control stream and returns
+// the peer's control protocol version and epoch, 0 if it sent none.
+func readControlHello(r io.Reader) (byte, uint64, error) {
+	typ, payload, err := readControlFrame(r)
+	if err != nil {
+		return 0, 0, fmt.Errorf("read control hello: %w", err)
+	}
+	if typ != ctrlHello || len(payload) < 1 {
+		return 0, 0, fmt.Errorf("expected control hello, got frame 0x%02x", typ)
+	}
+	var epoch uint64
+	if len(payload) >= 9 {
+		epoch = binary.BigEndian.Uint64(payload[1:9])
+	}
+	return payload[0], epoch, nil
+}
+
+// newEpoch returns a random non-zero id for a far bridge instance. A far
+// started again on the same address gets a new one, so its near can tell
+// connections to the old instance apart from ones to the new.
+func newEpoch() uint64 {
+	var b [8]byte
+	for {
+		rand.Read(b[:])
+		if epoch := binary.BigEndian.Uint64(b[:]); epoch != 0 {
+			return epoch
+		}
+	}
+}
+
+// farHello is the payload of the far's hello: its version and epoch.
+func (s *SalmonBridge) farHello() []byte {
+	payload := make([]byte, 9)
+	payload[0] = controlVersion
+	binary.BigEndian.PutUint64(payload[1:], s.epoch)
+	return payload
+}
+
+// controlStream is one side of a connection's control stream once the
+// hellos are exchanged. Both sides answer pings, so either may check the
+// other is still there.
+type controlStream struct {
+	stream      *quic.Stream
+	peerVersion byte
+	peerEpoch   uint64 // the far's epoch as seen by a near, 0 if it sent none
+	writeMu     sync.Mutex
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]chan struct{} // pings waiting for their pong
+	err     error                    // why serve stopped, set before done is closed
+	done    chan struct{}
+}
+
+func newControlStream(stream *quic.Stream, peerVersion byte, peerEpoch uint64) *controlStream {
+	return &controlStream{
+		stream:      stream,
+		peerVersion: peerVersion,
+		peerEpoch:   peerEpoch,
+		pending:     make(map[uint64]chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (c *controlStream) write(typ byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.stream.SetWriteDeadline(time.Now().Add(controlTimeout))
+	return writeControlFrame(c.stream, typ, payload)
+}
+
+// serve reads frames until the stream fails, answering pings and waking
+// the Ping waiting on each pong.
+func (c *controlStream) serve() {
+	var err error
+	for err == nil {
+		var typ byte
+		var payload []byte
+		typ, payload, err = readControlFrame(c.stream)
+		if err != nil {
+			break
+		}
+		switch typ {
+		case ctrlPing:
+			err = c.write(ctrlPong, payload)
+		case ctrlPong:
+			if len(payload) != 8 {
+				continue
+			}
+			seq := binary.BigEndian.Uint64(payload)
+			c.mu.Lock()
+			if ch, ok := c.pending[seq]; ok {
+				close(ch)
+				delete(c.pending, seq)
+			}
+			c.mu.Unlock()
+		}
+	}
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+	close(c.done)
+}
+
+// Ping sends a ping and waits up to timeout for its pong.
+func (c *controlStream) Ping(timeout time.Duration) error {
+	c.mu.Lock()
+	c.seq++
+	seq := c.seq
+	answered := make(chan struct{})
+	c.pending[seq] = answered
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, seq)
+		c.mu.Unlock()
+	}()
+
+	var payload [8]byte
+	binary.BigEndian.PutUint64(payload[:], seq)
+	if err := c.write(ctrlPing, payload[:]); err != nil {
+		return fmt.Errorf("write control ping: %w", err)
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-answered:
+		return nil
+	case <-c.done:
+		return fmt.Errorf("control stream closed: %w", c.err)
+	case <-timer.C:
+		return fmt.Errorf("control ping not answered within %v", timeout)
+	}
+}
+
+func (c *controlStream) Epoch() uint64 {
+	return c.peerEpoch
+}
+
+func (c *controlStream) Close() error {
+	c.stream.CancelRead(0)
+	return c.stream.Close()
+}
+
+// controlHandshake opens a connection's control stream from the near side.
+// It sends CONTROL_HEADER and a hello, then waits for the far's hello. A
+// far that predates control streams drops the stream and fails it.
+func (s *SalmonBridge) controlHandshake(stream *quic.Stream) (connections.ControlChannel, error) {
+	if _, err := stream.Write([]byte{CONTROL_HEADER}); err != nil {
+		return nil, fmt.Errorf("write control header: %w", err)
+	}
+	if err := writeControlFrame(stream, ctrlHello, []byte{controlVersion}); err != nil {
+		return nil, fmt.Errorf("write control hello: %w", err)
+	}
+	version, epoch, err := readControlHello(stream)
+	if err != nil {
+		return nil, err
+	}
+	c := newControlStream(stream, version, epoch)
+	go c.serve()
+	return c, nil
+}
+
+// handleControlStream answers a near's control handshake and serves the
+// control stream until its connection goes away.
+func (s *SalmonBridge) handleControlStream(stream *quic.Stream) {
+	// It lives as long as the connection, so draining must not wait on it
+	s.sq.DetachFarStream(stream)
+	defer stream.Close()
+
+	stream.SetReadDeadline(time.Now().Add(controlTimeout))
+	version, _, err := readControlHello(stream)
+	if err != nil {
+		log.Printf("FAR: Bridge %s control handshake error: %v", s.BridgeName, err)
+		stream.CancelRead(0)
+		return
+	}
+	stream.SetReadDeadline(time.Time{})
+
+	c := newControlStream(stream, version, 0)
+	if err := c.write(ctrlHello, s.farHello()); err != nil {
+		log.Printf("FAR: Bridge %s control hello write error: %v", s.BridgeName, err)
+		stream.CancelRead(0)
+		return
+	}
+	c.serve()
+}
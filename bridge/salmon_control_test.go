@@ -0,0 +1,116 @@
+package bridge
+
+import (
+	"bytes"
+	"crypto/tls"
+	"salmoncannon/utils"
+	"strings"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+func TestControlFrame_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeControlFrame(&buf, ctrlHello, []byte{controlVersion}); err != nil {
+		t.Fatalf("write hello: %v", err)
+	}
+	if err := writeControlFrame(&buf, ctrlPing, []byte{0, 0, 0, 0, 0, 0, 0, 7}); err != nil {
+		t.Fatalf("write ping: %v", err)
+	}
+
+	version, epoch, err := readControlHello(&buf)
+	if err != nil || version != controlVersion || epoch != 0 {
+		t.Fatalf("expected hello with version %d and no epoch, got %d %x %v", controlVersion, version, epoch, err)
+	}
+	typ, payload, err := readControlFrame(&buf)
+	if err != nil || typ != ctrlPing || !bytes.Equal(payload, []byte{0, 0, 0, 0, 0, 0, 0, 7}) {
+		t.Fatalf("unexpected frame 0x%02x %v %v", typ, payload, err)
+	}
+}
+
+func TestControlHello_Epoch(t *testing.T) {
+	far := &SalmonBridge{epoch: newEpoch()}
+	if far.epoch == 0 {
+		t.Fatalf("expected a non-zero epoch")
+	}
+	var buf bytes.Buffer
+	writeControlFrame(&buf, ctrlHello, far.farHello())
+	version, epoch, err := readControlHello(&buf)
+	if err != nil || version != controlVersion || epoch != far.epoch {
+		t.Fatalf("expected version %d epoch %x, got %d %x %v", controlVersion, far.epoch, version, epoch, err)
+	}
+	if other := newEpoch(); other == far.epoch {
+		t.Fatalf("expected a new instance to pick a different epoch")
+	}
+}
+
+func TestControlFrame_Rejects(t *testing.T) {
+	if err := writeControlFrame(&bytes.Buffer{}, ctrlPing, make([]byte, maxControlPayload+1)); err == nil {
+		t.Fatalf("expected an oversized payload to be refused")
+	}
+	if _, _, err := readControlFrame(bytes.NewReader([]byte{ctrlPing, 0xff, 0xff})); err == nil {
+		t.Fatalf("expected an oversized length to be refused")
+	}
+
+	var buf bytes.Buffer
+	writeControlFrame(&buf, ctrlPong, nil)
+	if _, _, err := readControlHello(&buf); err == nil || !strings.Contains(err.Error(), "expected control hello") {
+		t.Fatalf("expected a non hello first frame to fail the handshake, got %v", err)
+	}
+}
+
+func TestSalmonBridge_ControlStream(t *testing.T) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-control"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	farPort := 42077
+	farBridge := NewSalmonBridge("test-control", "127.0.0.1", farPort, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), "")
+	defer farBridge.Close()
+	go farBridge.NewFarListen()
+	time.Sleep(700 * time.Millisecond)
+
+	nearBridge := NewSalmonBridge("test-control", "127.0.0.1", farPort, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "")
+	defer nearBridge.Close()
+
+	stream, cleanup, err, _ := nearBridge.Quic().OpenStream()
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer cleanup()
+
+	stream.SetDeadline(time.Now().Add(3 * time.Second))
+	control, err := nearBridge.controlHandshake(stream)
+	if err != nil {
+		t.Fatalf("control handshake failed: %v", err)
+	}
+	defer control.Close()
+	stream.SetDeadline(time.Time{})
+	if v := control.(*controlStream).peerVersion; v != controlVersion {
+		t.Errorf("expected far control version %d, got %d", controlVersion, v)
+	}
+	if e := control.Epoch(); e == 0 || e != farBridge.epoch {
+		t.Errorf("expected far epoch %x, got %x", farBridge.epoch, e)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := control.Ping(time.Second); err != nil {
+			t.Fatalf("control ping %d failed: %v", i, err)
+		}
+	}
+
+	// The control stream stays open without holding up a far drain
+	if got := farBridge.Quic().ActiveStreams(); got != 0 {
+		t.Errorf("expected the far control stream not to count as active, got %d", got)
+	}
+
+	// Once the far is gone pings fail instead of hanging
+	farBridge.Close()
+	if err := control.Ping(time.Second); err == nil {
+		t.Fatalf("expected a ping to fail once the far closed")
+	}
+}
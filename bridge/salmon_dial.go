@@ -0,0 +1,117 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+// Outcomes of the far side's dial, sent after DIAL_RESULT. The values match
+// the SOCKS5 reply codes so the near can pass them straight to its client.
+const (
+	DialOK                 = 0x00
+	DialFailed             = 0x01
+	DialNotAllowed         = 0x02
+	DialNetworkUnreachable = 0x03
+	DialHostUnreachable    = 0x04
+	DialConnectionRefused  = 0x05
+)
+
+// DialError is returned by NewNearConn when the far side could not connect
+// to the target. Code is one of the Dial* reasons.
+type DialError struct {
+	Code byte
+	Err  error // set when the reason came from a stream error instead
+}
+
+func (e *DialError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("far dial failed: %v", e.Err)
+	}
+	return "far dial failed: " + dialResultName(e.Code)
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+func dialResultName(code byte) string {
+	switch code {
+	case DialOK:
+		return "ok"
+	case DialFailed:
+		return "general failure"
+	case DialNotAllowed:
+		return "not allowed"
+	case DialNetworkUnreachable:
+		return "network unreachable"
+	case DialHostUnreachable:
+		return "host unreachable"
+	case DialConnectionRefused:
+		return "connection refused"
+	}
+	return fmt.Sprintf("unknown reason 0x%02x", code)
+}
+
+// dialFailureCode maps a net.Dial error to the reason sent to the near.
+func dialFailureCode(err error) byte {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return DialConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return DialNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr):
+		return DialHostUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return DialHostUnreachable
+	}
+	return DialFailed
+}
+
+func writeDialResult(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{DIAL_RESULT, code})
+	return err
+}
+
+// DefaultDialResultTimeout bounds how long the near side waits for the far
+// side to report its dial outcome, so a far that stops answering fails the
+// client instead of hanging it. It is generous enough for slow targets.
+const DefaultDialResultTimeout = 30 * time.Second
+
+// readDialResult waits for the far side's dial outcome and returns nil once
+// the target is connected, or a *DialError saying why it is not.
+func readDialResult(r io.Reader) error {
+	var buf [2]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		var streamErr *quic.StreamError
+		if errors.As(err, &streamErr) && streamErr.ErrorCode == StreamErrTargetUnavailable {
+			return &DialError{Code: DialHostUnreachable, Err: ErrTargetUnavailable}
+		}
+		if errors.As(err, &streamErr) && streamErr.ErrorCode == StreamErrClientLimit {
+			return &DialError{Code: DialNotAllowed, Err: ErrClientLimit}
+		}
+		return fmt.Errorf("read dial result: %w", err)
+	}
+	if buf[0] != DIAL_RESULT {
+		return fmt.Errorf("unexpected frame 0x%02x, want dial result", buf[0])
+	}
+	if buf[1] != DialOK {
+		return &DialError{Code: buf[1]}
+	}
+	return nil
+}
+
+// refuseStream tells the near why its target was not connected and ends
+// the stream.
+func (s *SalmonBridge) refuseStream(stream *quic.Stream, code byte) {
+	writeDialResult(stream, code)
+	stream.CancelRead(0)
+	stream.Close()
+}
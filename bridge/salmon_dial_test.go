@@ -0,0 +1,58 @@
+package bridge
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func opErr(err error) error {
+	return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+}
+
+func TestDialFailureCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want byte
+	}{
+		{"refused", opErr(syscall.ECONNREFUSED), DialConnectionRefused},
+		{"network unreachable", opErr(syscall.ENETUNREACH), DialNetworkUnreachable},
+		{"host unreachable", opErr(syscall.EHOSTUNREACH), DialHostUnreachable},
+		{"dns", &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "x.invalid", IsNotFound: true}}, DialHostUnreachable},
+		{"timeout", &net.OpError{Op: "dial", Net: "tcp", Err: timeoutErr{}}, DialHostUnreachable},
+		{"other", errors.New("boom"), DialFailed},
+	}
+	for _, tt := range tests {
+		if got := dialFailureCode(tt.err); got != tt.want {
+			t.Errorf("%s: dialFailureCode = 0x%02x, want 0x%02x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDialResult_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	writeDialResult(&buf, DialOK)
+	if err := readDialResult(&buf); err != nil {
+		t.Fatalf("expected DialOK to read as nil, got %v", err)
+	}
+
+	writeDialResult(&buf, DialConnectionRefused)
+	var dialErr *DialError
+	if err := readDialResult(&buf); !errors.As(err, &dialErr) || dialErr.Code != DialConnectionRefused {
+		t.Fatalf("expected connection refused DialError, got %v", err)
+	}
+
+	if err := readDialResult(bytes.NewReader([]byte{BIND_REPLY, 0})); err == nil || errors.As(err, &dialErr) {
+		t.Fatalf("expected unexpected frame error, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package bridge
+
+import (
+	"errors"
+	"log"
+	"net"
+	"salmoncannon/status"
+	"strconv"
+)
+
+// errRemoteDNS refuses a direct dial that would resolve a hostname locally.
+var errRemoteDNS = errors.New("hostname would be resolved on the near host")
+
+// SetResolver sets the resolver direct dials use for hostname targets. nil
+// uses net.DefaultResolver. Tunnelled targets are always resolved by the far.
+func (s *SalmonBridge) SetResolver(r *net.Resolver) {
+	s.resolver = r
+}
+
+// SetRemoteDNS, when on, refuses direct dials to hostname targets so that
+// names are only ever resolved by the far side. IP targets still connect.
+func (s *SalmonBridge) SetRemoteDNS(remote bool) {
+	s.remoteDNS = remote
+}
+
+// NewDirectConn dials host:port straight from this host, bypassing the far
+// side. It applies the same allowed out addresses and address types a far
+// side would, and reports failures as a *DialError like NewNearConn.
+func (s *SalmonBridge) NewDirectConn(host string, port int, addrType byte) (net.Conn, error) {
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	addrType, err := targetAddrType(target, addrType)
+	if err != nil {
+		return nil, &DialError{Code: DialFailed, Err: err}
+	}
+	if s.shouldBlockFarOutConn(target, addrType) {
+		status.GlobalConnMonitorRef.IncAllowlistBlock(s.BridgeName)
+		log.Printf("NEAR: Bridge %s direct target not allowed by the out address/port lists: %s (%s)", s.BridgeName, target, addrTypeName(addrType))
+		return nil, &DialError{Code: DialNotAllowed}
+	}
+	if s.remoteDNS && addrType == AddrTypeDomain {
+		log.Printf("NEAR: Bridge %s not dialing %s directly, remote DNS is required", s.BridgeName, target)
+		return nil, &DialError{Code: DialNotAllowed, Err: errRemoteDNS}
+	}
+	d := net.Dialer{Resolver: s.resolver}
+	conn, err := d.Dial("tcp", target)
+	if err != nil {
+		status.GlobalConnMonitorRef.IncDialFailure(s.BridgeName)
+		return nil, &DialError{Code: dialFailureCode(err), Err: err}
+	}
+	return conn, nil
+}
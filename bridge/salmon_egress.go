@@ -0,0 +1,56 @@
+package bridge
+
+import (
+	"context"
+	"log"
+	"net"
+	"runtime"
+	"syscall"
+)
+
+// SetEgressInterface ties the far side's outbound target dials to ifname
+// via SO_BINDTODEVICE, so tunnel traffic leaves on that uplink whatever the
+// routing table says. An empty name dials on the default route. Only Linux
+// can bind to a device; other platforms keep the default route.
+func (s *SalmonBridge) SetEgressInterface(ifname string) {
+	if ifname != "" && runtime.GOOS != "linux" {
+		log.Printf("FAR: Bridge %s egress interface %s is only supported on Linux, dialing on the default route", s.BridgeName, ifname)
+		ifname = ""
+	}
+	s.egressInterface = ifname
+}
+
+// egressControl binds a socket to the egress interface, nil when none is set.
+func (s *SalmonBridge) egressControl() func(network, address string, c syscall.RawConn) error {
+	if s.egressInterface == "" {
+		return nil
+	}
+	ifname := s.egressInterface
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		if err := c.Control(func(fd uintptr) {
+			serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
+		}); err != nil {
+			return err
+		}
+		return serr
+	}
+}
+
+// dialTarget opens the far side's TCP connection to target, bound to the
+// egress interface when one is set.
+func (s *SalmonBridge) dialTarget(target string) (net.Conn, error) {
+	d := net.Dialer{Control: s.egressControl()}
+	return d.Dial("tcp", target)
+}
+
+// listenUDPTarget opens the far side's UDP socket for a UDP association,
+// bound to the egress interface when one is set.
+func (s *SalmonBridge) listenUDPTarget() (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: s.egressControl()}
+	pc, err := lc.ListenPacket(context.Background(), "udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	return pc.(*net.UDPConn), nil
+}
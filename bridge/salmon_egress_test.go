@@ -0,0 +1,42 @@
+package bridge
+
+import (
+	"net"
+	"runtime"
+	"testing"
+)
+
+func TestDialTarget_EgressInterface(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_BINDTODEVICE is Linux only")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	s := NewSalmonBridge("test-egress", "127.0.0.1", 0, nil, nil, nil, false, "", nil, "")
+	conn, err := s.dialTarget(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("expected the default route dial to work, got %v", err)
+	}
+	conn.Close()
+
+	s.SetEgressInterface("nonexistent-interface-12345")
+	if conn, err := s.dialTarget(ln.Addr().String()); err == nil {
+		conn.Close()
+		t.Fatalf("expected binding to a missing interface to fail the dial")
+	} else if code := dialFailureCode(err); code != DialFailed {
+		t.Fatalf("expected a general failure code, got 0x%02x (%v)", code, err)
+	}
+}
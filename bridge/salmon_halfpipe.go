@@ -0,0 +1,67 @@
+package bridge
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// halfPipe is one end of an in-memory pipe that, unlike net.Pipe, can be
+// half-closed. CloseWrite makes the peer's reads return io.EOF while data
+// still flows the other way, so a client's shutdown(SHUT_WR) reaches the
+// tunnel as a FIN rather than a full close.
+type halfPipe struct {
+	net.Conn
+	peer *halfPipe
+
+	eof     chan struct{} // closed by the peer's CloseWrite
+	eofOnce sync.Once
+}
+
+// newHalfPipe returns both ends of a half-closable pipe.
+func newHalfPipe() (net.Conn, net.Conn) {
+	a, b := net.Pipe()
+	ha := &halfPipe{Conn: a, eof: make(chan struct{})}
+	hb := &halfPipe{Conn: b, eof: make(chan struct{}), peer: ha}
+	ha.peer = hb
+	return ha, hb
+}
+
+func (p *halfPipe) Read(b []byte) (int, error) {
+	select {
+	case <-p.eof:
+		return 0, io.EOF
+	default:
+	}
+	n, err := p.Conn.Read(b)
+	if err != nil {
+		// CloseWrite forces a deadline to wake a blocked read
+		select {
+		case <-p.eof:
+			return n, io.EOF
+		default:
+		}
+	}
+	return n, err
+}
+
+func (p *halfPipe) Write(b []byte) (int, error) {
+	select {
+	case <-p.peer.eof:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	return p.Conn.Write(b)
+}
+
+// CloseWrite ends this side's writes; the peer reads io.EOF once it has
+// read everything written before. net.Pipe writes block until read, so
+// nothing is in flight by the time this runs.
+func (p *halfPipe) CloseWrite() error {
+	p.peer.eofOnce.Do(func() {
+		close(p.peer.eof)
+		p.peer.Conn.SetReadDeadline(time.Now())
+	})
+	return nil
+}
@@ -0,0 +1,119 @@
+package bridge
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"salmoncannon/crypt"
+	"salmoncannon/utils"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+func TestHalfPipe_CloseWrite(t *testing.T) {
+	a, b := newHalfPipe()
+	defer a.Close()
+	defer b.Close()
+
+	go func() {
+		a.Write([]byte("request"))
+		a.(interface{ CloseWrite() error }).CloseWrite()
+	}()
+	b.SetDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(b)
+	if err != nil || string(got) != "request" {
+		t.Fatalf("expected request then EOF, got %q %v", got, err)
+	}
+
+	// The other direction still works
+	go b.Write([]byte("response"))
+	a.SetDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(a, buf); err != nil || string(buf) != "response" {
+		t.Fatalf("expected response after half-close, got %q %v", buf, err)
+	}
+	if _, err := a.Write([]byte("more")); err == nil {
+		t.Fatalf("expected write after CloseWrite to fail")
+	}
+}
+
+func TestSalmonBridge_HalfClose(t *testing.T) {
+	// Reads the whole request before answering, like an HTTP/1.0 server
+	// reading until the client shuts down its write side
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				req, err := io.ReadAll(c)
+				if err != nil {
+					return
+				}
+				c.Write([]byte("got " + string(req)))
+			}()
+		}
+	}()
+	targetPort := ln.Addr().(*net.TCPAddr).Port
+
+	cases := []struct {
+		name   string
+		port   int
+		secret string
+		cipher string
+	}{
+		{"plain", 42071, "", crypt.CipherModeCtr},
+		{"ctr", 42072, "half-secret", crypt.CipherModeCtr},
+		{"gcm", 42073, "half-secret", crypt.CipherModeGcm},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			name := "test-half-" + tc.name
+			tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{name},
+				Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+			quicCfg := &quic.Config{EnableDatagrams: false}
+
+			farBridge := NewSalmonBridge(name, "127.0.0.1", tc.port, tlsCfg, quicCfg,
+				nil, false, "", make([]string, 0), tc.secret)
+			farBridge.SetCipherMode(tc.cipher)
+			defer farBridge.Close()
+			go farBridge.NewFarListen()
+			time.Sleep(700 * time.Millisecond)
+
+			nearBridge := NewSalmonBridge(name, "127.0.0.1", tc.port, tlsCfg, quicCfg,
+				nil, true, "", make([]string, 0), tc.secret)
+			nearBridge.SetCipherMode(tc.cipher)
+			defer nearBridge.Close()
+
+			conn, err := nearBridge.NewNearConn("127.0.0.1", targetPort)
+			if err != nil {
+				t.Fatalf("near bridge failed: %v", err)
+			}
+			defer conn.Close()
+
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := conn.Write([]byte("request")); err != nil {
+				t.Fatalf("failed to write request: %v", err)
+			}
+			cw, ok := conn.(interface{ CloseWrite() error })
+			if !ok {
+				t.Fatalf("expected the near conn to support CloseWrite")
+			}
+			cw.CloseWrite()
+
+			got, err := io.ReadAll(conn)
+			if err != nil || string(got) != "got request" {
+				t.Fatalf("expected the full response after half-close, got %q %v", got, err)
+			}
+		})
+	}
+}
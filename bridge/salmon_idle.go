@@ -0,0 +1,100 @@
+package bridge
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IdleTimer tears a relay down once neither direction has moved data for
+// its timeout. Both directions of a relay share one timer so a long
+// download does not time out the quiet upload side. A nil *IdleTimer never
+// fires.
+type IdleTimer struct {
+	timeout time.Duration
+	stopped atomic.Bool
+	expired atomic.Bool
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// NewIdleTimer returns a timer for timeout, or nil if timeout <= 0.
+func NewIdleTimer(timeout time.Duration) *IdleTimer {
+	if timeout <= 0 {
+		return nil
+	}
+	return &IdleTimer{timeout: timeout, last: time.Now()}
+}
+
+func (t *IdleTimer) touch() {
+	t.mu.Lock()
+	t.last = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *IdleTimer) idleFor() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Since(t.last)
+}
+
+// Stop marks the relay as closing so deadlines forced to unblock a reader
+// are not mistaken for an active peer and retried.
+func (t *IdleTimer) Stop() {
+	if t != nil {
+		t.stopped.Store(true)
+	}
+}
+
+// Expired reports whether a reader gave up on an idle relay. Its io.EOF
+// then means the relay timed out rather than that the peer half-closed.
+func (t *IdleTimer) Expired() bool {
+	return t != nil && t.expired.Load()
+}
+
+// Reader wraps r so each Read runs under a read deadline set through
+// setDeadline. When the deadline passes and the relay has been idle for the
+// whole timeout the read returns io.EOF; otherwise it waits again.
+func (t *IdleTimer) Reader(r io.Reader, setDeadline func(time.Time) error) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &idleReader{r: r, setDeadline: setDeadline, timer: t}
+}
+
+type idleReader struct {
+	r           io.Reader
+	setDeadline func(time.Time) error
+	timer       *IdleTimer
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	for {
+		r.setDeadline(time.Now().Add(r.timer.timeout))
+		n, err := r.r.Read(p)
+		if n > 0 {
+			r.timer.touch()
+		}
+		if n == 0 && isTimeout(err) && !r.timer.stopped.Load() {
+			if r.timer.idleFor() < r.timer.timeout {
+				continue // the other direction is still active
+			}
+			r.timer.expired.Store(true)
+			return 0, io.EOF
+		}
+		return n, err
+	}
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
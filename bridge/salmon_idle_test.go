@@ -0,0 +1,121 @@
+package bridge
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"salmoncannon/utils"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+func TestIdleTimer_Disabled(t *testing.T) {
+	if NewIdleTimer(0) != nil {
+		t.Fatalf("expected no timer for a zero timeout")
+	}
+	var timer *IdleTimer
+	r, _ := net.Pipe()
+	if timer.Reader(r, r.SetReadDeadline) != io.Reader(r) {
+		t.Fatalf("expected nil timer to return the reader unchanged")
+	}
+	timer.Stop()
+}
+
+func TestIdleTimer_SilentPeerReturnsEOF(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	timer := NewIdleTimer(100 * time.Millisecond)
+	r := timer.Reader(a, a.SetReadDeadline)
+
+	start := time.Now()
+	n, err := r.Read(make([]byte, 8))
+	if n != 0 || err != io.EOF {
+		t.Fatalf("expected idle read to return EOF, got %d %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("unexpected idle teardown after %v", elapsed)
+	}
+}
+
+func TestIdleTimer_OtherDirectionKeepsAlive(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	timer := NewIdleTimer(100 * time.Millisecond)
+	r := timer.Reader(a, a.SetReadDeadline)
+
+	// Activity in the other direction for 300ms, then this side gets data
+	go func() {
+		for i := 0; i < 6; i++ {
+			time.Sleep(50 * time.Millisecond)
+			timer.touch()
+		}
+		b.Write([]byte("late"))
+	}()
+
+	buf := make([]byte, 8)
+	n, err := r.Read(buf)
+	if err != nil || string(buf[:n]) != "late" {
+		t.Fatalf("expected read to survive while the relay was active, got %q %v", buf[:n], err)
+	}
+}
+
+func TestSalmonBridge_StreamIdleTimeout(t *testing.T) {
+	// Target accepts and then never sends or closes
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-idle"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	farBridge := NewSalmonBridge("test-idle", "127.0.0.1", 42060, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), "")
+	farBridge.SetStreamIdleTimeout(300 * time.Millisecond)
+	defer farBridge.Close()
+	go farBridge.NewFarListen()
+	time.Sleep(700 * time.Millisecond)
+
+	nearBridge := NewSalmonBridge("test-idle", "127.0.0.1", 42060, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "")
+	defer nearBridge.Close()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	conn, err := nearBridge.NewNearConn("127.0.0.1", addr.Port)
+	if err != nil {
+		t.Fatalf("near bridge failed: %v", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expected silent stream to be torn down")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("stream was not torn down by the idle timeout, took %v", elapsed)
+	}
+	if active := nearBridge.Quic().ActiveStreams(); active != 0 {
+		time.Sleep(100 * time.Millisecond)
+		if active = nearBridge.Quic().ActiveStreams(); active != 0 {
+			t.Errorf("expected the stream to be released, %d still active", active)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package bridge
+
+import (
+	"errors"
+	"net/netip"
+	"sync"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+// StreamErrClientLimit is the stream error code the far side cancels with
+// when the client behind a stream already has SBMaxOutPerClient target
+// connections open.
+const StreamErrClientLimit quic.StreamErrorCode = 0x11
+
+var ErrClientLimit = errors.New("client has too many target connections open")
+
+// outLimiter counts the far side's open target connections per client IP.
+// A nil outLimiter allows everything.
+type outLimiter struct {
+	mu   sync.Mutex
+	max  int
+	open map[netip.Addr]int
+}
+
+func newOutLimiter(max int) *outLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &outLimiter{max: max, open: make(map[netip.Addr]int)}
+}
+
+// acquire takes a connection slot for client, or reports false when it has
+// none left. Every successful acquire must be released.
+func (l *outLimiter) acquire(client netip.Addr) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open[client] >= l.max {
+		return false
+	}
+	l.open[client]++
+	return true
+}
+
+func (l *outLimiter) release(client netip.Addr) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open[client] <= 1 {
+		delete(l.open, client)
+		return
+	}
+	l.open[client]--
+}
+
+// SetMaxOutPerClient caps how many target connections the far side keeps
+// open for one client. The client is the address a near passes along, or
+// the near itself when it sends none. On a near a cap above 0 makes it pass
+// its clients' addresses along. 0 removes the cap.
+func (s *SalmonBridge) SetMaxOutPerClient(max int) {
+	s.outLimit = newOutLimiter(max)
+	s.sendClientAddr = max > 0
+}
@@ -0,0 +1,139 @@
+package bridge
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"salmoncannon/status"
+	"salmoncannon/utils"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+func TestOutLimiter(t *testing.T) {
+	var none *outLimiter
+	if !none.acquire(netip.MustParseAddr("10.0.0.1")) {
+		t.Fatalf("expected a nil limiter to allow everything")
+	}
+	none.release(netip.MustParseAddr("10.0.0.1"))
+
+	l := newOutLimiter(2)
+	a, b := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")
+	if !l.acquire(a) || !l.acquire(a) {
+		t.Fatalf("expected two connections to be allowed")
+	}
+	if l.acquire(a) {
+		t.Fatalf("expected a third connection to be refused")
+	}
+	if !l.acquire(b) {
+		t.Fatalf("expected another client to be unaffected")
+	}
+	l.release(a)
+	if !l.acquire(a) {
+		t.Fatalf("expected a slot to free up once a connection closes")
+	}
+	l.release(a)
+	l.release(a)
+	l.release(b)
+	if len(l.open) != 0 {
+		t.Fatalf("expected no clients left, got %v", l.open)
+	}
+}
+
+func TestSalmonBridge_MaxOutPerClient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	targetPort := ln.Addr().(*net.TCPAddr).Port
+
+	name := "test-out-limit"
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{name},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	farBridge := NewSalmonBridge(name, "127.0.0.1", 42080, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), "")
+	farBridge.SetMaxOutPerClient(1)
+	defer farBridge.Close()
+	go farBridge.NewFarListen()
+	time.Sleep(700 * time.Millisecond)
+
+	nearBridge := NewSalmonBridge(name, "127.0.0.1", 42080, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "")
+	nearBridge.SetMaxOutPerClient(1)
+	defer nearBridge.Close()
+
+	open := func(client string) (net.Conn, error) {
+		return nearBridge.NewNearConnFrom("127.0.0.1", targetPort, AddrTypeIPv4, net.TCPAddrFromAddrPort(netip.MustParseAddrPort(client)))
+	}
+	rejected := status.GlobalConnMonitorRef.Rejections(name).OutClientLimit
+	first, err := open("10.0.0.1:5000")
+	if err != nil {
+		t.Fatalf("expected the first connection to open, got %v", err)
+	}
+
+	// The same client from another port is over the limit
+	_, err = open("10.0.0.1:5001")
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) || dialErr.Code != DialNotAllowed || !errors.Is(err, ErrClientLimit) {
+		t.Fatalf("expected a client limit refusal, got %v", err)
+	}
+	if got := status.GlobalConnMonitorRef.Rejections(name).OutClientLimit - rejected; got != 1 {
+		t.Fatalf("expected 1 out client limit rejection, got %d", got)
+	}
+
+	other, err := open("10.0.0.2:5000")
+	if err != nil {
+		t.Fatalf("expected another client to be unaffected, got %v", err)
+	}
+	defer other.Close()
+
+	// Closing the first connection frees its slot once the far notices
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := open("10.0.0.1:5002")
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the client to get a slot back, last error %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// A near that does not pass client addresses along counts as one client
+	plainNear := NewSalmonBridge(name, "127.0.0.1", 42080, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "")
+	defer plainNear.Close()
+	client := net.TCPAddrFromAddrPort(netip.MustParseAddrPort("10.0.0.3:5000"))
+	conn, err := plainNear.NewNearConnFrom("127.0.0.1", targetPort, AddrTypeIPv4, client)
+	if err != nil {
+		t.Fatalf("expected the near's first connection to open, got %v", err)
+	}
+	defer conn.Close()
+	client = net.TCPAddrFromAddrPort(netip.MustParseAddrPort("10.0.0.4:5000"))
+	if _, err := plainNear.NewNearConnFrom("127.0.0.1", targetPort, AddrTypeIPv4, client); !errors.Is(err, ErrClientLimit) {
+		t.Fatalf("expected the near's second connection to be refused, got %v", err)
+	}
+}
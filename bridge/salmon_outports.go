@@ -0,0 +1,34 @@
+package bridge
+
+import "strconv"
+
+// SetOutPorts limits the far side to targets on one of allowed ports and
+// never on one of denied, which wins when a port is in both. An empty list
+// does not restrict.
+func (s *SalmonBridge) SetOutPorts(allowed, denied []int) {
+	s.allowedOutPorts = portSet(allowed)
+	s.deniedOutPorts = portSet(denied)
+}
+
+func portSet(ports []int) map[int]bool {
+	if len(ports) == 0 {
+		return nil
+	}
+	set := make(map[int]bool, len(ports))
+	for _, p := range ports {
+		set[p] = true
+	}
+	return set
+}
+
+// outPortAllowed reports whether a target on port may be dialed.
+func (s *SalmonBridge) outPortAllowed(port string) bool {
+	if s.allowedOutPorts == nil && s.deniedOutPorts == nil {
+		return true
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || s.deniedOutPorts[p] {
+		return false
+	}
+	return s.allowedOutPorts == nil || s.allowedOutPorts[p]
+}
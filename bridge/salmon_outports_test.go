@@ -0,0 +1,37 @@
+package bridge
+
+import "testing"
+
+func TestSalmonBridge_SetOutPorts(t *testing.T) {
+	sb := &SalmonBridge{}
+	if sb.shouldBlockFarOutConn("10.0.0.1:25", AddrTypeIPv4) {
+		t.Fatalf("expected no port lists to allow every port")
+	}
+
+	sb.SetOutPorts([]int{443, 80}, []int{80})
+	tests := []struct {
+		target   string
+		addrType byte
+		blocked  bool
+	}{
+		{"10.0.0.1:443", AddrTypeIPv4, false},      // allowed port
+		{"example.com:443", AddrTypeDomain, false}, // allowed port, domain target
+		{"[2001:db8::1]:443", AddrTypeIPv6, false}, // allowed port, IPv6 target
+		{"10.0.0.1:80", AddrTypeIPv4, true},        // denied wins over allowed
+		{"10.0.0.1:8080", AddrTypeIPv4, true},      // not in the allow list
+	}
+	for _, tt := range tests {
+		if got := sb.shouldBlockFarOutConn(tt.target, tt.addrType); got != tt.blocked {
+			t.Errorf("shouldBlockFarOutConn(%q) = %v, want %v", tt.target, got, tt.blocked)
+		}
+	}
+
+	// A deny list alone leaves every other port open
+	sb.SetOutPorts(nil, []int{25})
+	if !sb.shouldBlockFarOutConn("mail.example.com:25", AddrTypeDomain) {
+		t.Errorf("expected denied port to be blocked")
+	}
+	if sb.shouldBlockFarOutConn("example.com:8443", AddrTypeDomain) {
+		t.Errorf("expected a port outside the deny list to be allowed")
+	}
+}
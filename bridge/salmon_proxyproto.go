@@ -0,0 +1,78 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+)
+
+// proxyV2Signature opens every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV2Local = 0x20 // version 2, LOCAL command
+	proxyV2Proxy = 0x21 // version 2, PROXY command
+
+	proxyV2TCP4 = 0x11
+	proxyV2TCP6 = 0x21
+)
+
+// SetSendProxyProtocol turns on PROXY protocol v2 for tunnelled streams. The
+// near side then passes each client's address to the far, which writes it
+// in a PROXY header to the target before relaying.
+func (s *SalmonBridge) SetSendProxyProtocol(send bool) {
+	s.sendProxyProtocol = send
+}
+
+func writeClientAddrHeader(w io.Writer, addr netip.AddrPort) error {
+	text := addr.String()
+	if len(text) > 255 {
+		return fmt.Errorf("client address too long")
+	}
+	hdr := append([]byte{CLIENT_ADDR_HEADER, byte(len(text))}, text...)
+	_, err := w.Write(hdr)
+	return err
+}
+
+// readClientAddrHeader reads the address following a CLIENT_ADDR_HEADER.
+func readClientAddrHeader(r io.Reader) (netip.AddrPort, error) {
+	var n [1]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	text := make([]byte, n[0])
+	if _, err := io.ReadFull(r, text); err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.ParseAddrPort(string(text))
+}
+
+// proxyHeaderV2 builds the PROXY protocol v2 header for a connection from
+// src to dst. Without a valid src, or when dst is not TCP, the header uses
+// the LOCAL command and carries no addresses, so the target falls back to
+// the real peer address.
+func proxyHeaderV2(src netip.AddrPort, dst net.Addr) []byte {
+	hdr := append([]byte{}, proxyV2Signature...)
+	tcpDst, ok := dst.(*net.TCPAddr)
+	if !src.IsValid() || !ok {
+		return append(hdr, proxyV2Local, 0x00, 0x00, 0x00)
+	}
+	dstAddr := tcpDst.AddrPort()
+	srcIP, dstIP := src.Addr().Unmap(), dstAddr.Addr().Unmap()
+
+	if srcIP.Is4() && dstIP.Is4() {
+		hdr = append(hdr, proxyV2Proxy, proxyV2TCP4, 0x00, 12)
+		hdr = append(hdr, srcIP.AsSlice()...)
+		hdr = append(hdr, dstIP.AsSlice()...)
+	} else {
+		// Mixed families are both sent as IPv6, IPv4 as mapped addresses
+		src16, dst16 := srcIP.As16(), dstIP.As16()
+		hdr = append(hdr, proxyV2Proxy, proxyV2TCP6, 0x00, 36)
+		hdr = append(hdr, src16[:]...)
+		hdr = append(hdr, dst16[:]...)
+	}
+	hdr = binary.BigEndian.AppendUint16(hdr, src.Port())
+	return binary.BigEndian.AppendUint16(hdr, dstAddr.Port())
+}
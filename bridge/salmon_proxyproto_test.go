@@ -0,0 +1,135 @@
+package bridge
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/netip"
+	"salmoncannon/utils"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+func TestProxyHeaderV2(t *testing.T) {
+	sig := "\r\n\r\n\x00\r\nQUIT\n"
+	cases := []struct {
+		name string
+		src  netip.AddrPort
+		dst  net.Addr
+		want string
+	}{
+		{
+			"ipv4",
+			netip.MustParseAddrPort("192.0.2.7:5555"),
+			&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443},
+			sig + "\x21\x11\x00\x0c" + "\xc0\x00\x02\x07" + "\xc6\x33\x64\x01" + "\x15\xb3" + "\x01\xbb",
+		},
+		{
+			"ipv6",
+			netip.MustParseAddrPort("[2001:db8::7]:5555"),
+			&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+			sig + "\x21\x21\x00\x24" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x07" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+				"\x15\xb3" + "\x01\xbb",
+		},
+		{
+			"mixed families",
+			netip.MustParseAddrPort("192.0.2.7:5555"),
+			&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+			sig + "\x21\x21\x00\x24" +
+				"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\xff\xc0\x00\x02\x07" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+				"\x15\xb3" + "\x01\xbb",
+		},
+		{
+			"no client",
+			netip.AddrPort{},
+			&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443},
+			sig + "\x20\x00\x00\x00",
+		},
+	}
+	for _, tc := range cases {
+		if got := proxyHeaderV2(tc.src, tc.dst); !bytes.Equal(got, []byte(tc.want)) {
+			t.Errorf("%s: expected header % x, got % x", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestClientAddrHeaderRoundTrip(t *testing.T) {
+	for _, addr := range []string{"192.0.2.7:5555", "[2001:db8::7]:80"} {
+		var buf bytes.Buffer
+		if err := writeClientAddrHeader(&buf, netip.MustParseAddrPort(addr)); err != nil {
+			t.Fatalf("failed to write %s: %v", addr, err)
+		}
+		if headerType, _ := ReadHeaderType(&buf); headerType != CLIENT_ADDR_HEADER {
+			t.Fatalf("expected client address header, got 0x%02x", headerType)
+		}
+		got, err := readClientAddrHeader(&buf)
+		if err != nil || got.String() != addr {
+			t.Fatalf("expected %s, got %s: %v", addr, got, err)
+		}
+	}
+	if _, err := readClientAddrHeader(bytes.NewReader([]byte{4, 'j', 'u', 'n', 'k'})); err == nil {
+		t.Fatalf("expected an error for a malformed client address")
+	}
+}
+
+func TestSalmonBridge_SendsProxyHeader(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	headers := make(chan []byte, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		// An IPv4 header and the client's first bytes
+		buf := make([]byte, 28+5)
+		io.ReadFull(c, buf)
+		headers <- buf
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-proxyproto"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	farBridge := NewSalmonBridge("test-proxyproto", "127.0.0.1", 42075, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), "")
+	farBridge.SetSendProxyProtocol(true)
+	defer farBridge.Close()
+	go farBridge.NewFarListen()
+	time.Sleep(700 * time.Millisecond)
+
+	nearBridge := NewSalmonBridge("test-proxyproto", "127.0.0.1", 42075, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "")
+	nearBridge.SetSendProxyProtocol(true)
+	defer nearBridge.Close()
+
+	client := &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 5555}
+	conn, err := nearBridge.NewNearConnFrom("127.0.0.1", port, AddrTypeIPv4, client)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+
+	select {
+	case got := <-headers:
+		want := proxyHeaderV2(netip.MustParseAddrPort("192.0.2.7:5555"), ln.Addr())
+		if !bytes.Equal(got[:28], want) || string(got[28:]) != "hello" {
+			t.Fatalf("expected PROXY header % x then hello, got % x", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("target never received the PROXY header")
+	}
+}
@@ -2,11 +2,15 @@ package bridge
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
+	"salmoncannon/connections"
 	"salmoncannon/crypt"
 	"salmoncannon/limiter"
+	"salmoncannon/status"
 	"sync"
 	"time"
 
@@ -17,6 +21,41 @@ const STATUS_HEADER = 0x01
 const CONNECT_HEADER = 0x02
 const STATUS_ACK = 0x03
 const CONNECT_ENC_HEADER = 0x04
+const CONNECT_GCM_HEADER = 0x05
+
+// BIND_HEADER prefixes a normal connect header to ask the far side to
+// listen for one inbound connection instead of dialing the target.
+const BIND_HEADER = 0x06
+
+// BIND_REPLY carries an address back from the far side during a bind:
+// first the address it listens on, then the peer that connected.
+const BIND_REPLY = 0x07
+
+// COMPRESS_HEADER and a one byte algorithm ID prefix a connect header when
+// the near side wants the stream payload compressed.
+const COMPRESS_HEADER = 0x08
+
+// ADDR_TYPE_HEADER and a one byte address type (AddrTypeIPv4, AddrTypeDomain
+// or AddrTypeIPv6) prefix a connect header to say how the client gave the
+// target, so the far side can apply policy by type.
+const ADDR_TYPE_HEADER = 0x09
+
+// DIAL_RESULT and a one byte reason (DialOK or a Dial* failure) are sent
+// back by the far side once it has tried to connect to the target.
+const DIAL_RESULT = 0x0A
+
+// UDP_ASSOC_HEADER prefixes a normal connect header to ask the far side for
+// a UDP socket. Packets then travel as QUIC datagrams, see
+// NearUDPAssociation.
+const UDP_ASSOC_HEADER = 0x0B
+
+// CLIENT_ADDR_HEADER and a length-prefixed "ip:port" prefix a connect header
+// to pass the near side client's address on for the far's PROXY header.
+const CLIENT_ADDR_HEADER = 0x0C
+
+// CONTROL_HEADER opens a connection's control stream instead of a relayed
+// one. Control frames follow, see controlStream.
+const CONTROL_HEADER = 0x0D
 
 const CONNECT_ENC_PAYLOAD_SIZE = 192
 
@@ -38,11 +77,21 @@ func WriteTargetHeader(w io.Writer, addr string) error {
 }
 
 func WriteTargetHeaderEnc(w io.Writer, addr string, readIv []byte, writeIv []byte, readKey []byte, writeKey []byte, sharedSecret string) error {
+	return writeTargetHeaderEnc(w, CONNECT_ENC_HEADER, addr, readIv, writeIv, readKey, writeKey, sharedSecret)
+}
+
+// WriteTargetHeaderGcm writes the same encrypted header as WriteTargetHeaderEnc
+// but tags it so the far side knows the stream payload is AES-GCM framed.
+func WriteTargetHeaderGcm(w io.Writer, addr string, readIv []byte, writeIv []byte, readKey []byte, writeKey []byte, sharedSecret string) error {
+	return writeTargetHeaderEnc(w, CONNECT_GCM_HEADER, addr, readIv, writeIv, readKey, writeKey, sharedSecret)
+}
+
+func writeTargetHeaderEnc(w io.Writer, headerType byte, addr string, readIv []byte, writeIv []byte, readKey []byte, writeKey []byte, sharedSecret string) error {
 	if len(addr) > 65535 {
 		return fmt.Errorf("target address too long")
 	}
 	var hdr [3]byte
-	hdr[0] = CONNECT_ENC_HEADER
+	hdr[0] = headerType
 	addrToWriteEnc, err := crypt.EncryptBytesWithSecret([]byte(addr), sharedSecret)
 	if err != nil {
 		return fmt.Errorf("failed to encrypt target header: %v", err)
@@ -67,6 +116,57 @@ func WriteTargetHeaderEnc(w io.Writer, addr string, readIv []byte, writeIv []byt
 	return err
 }
 
+// WriteBindReply sends a BIND_REPLY frame carrying addr ("host:port"). The
+// address is encrypted when a shared secret is set.
+func WriteBindReply(w io.Writer, addr string, sharedSecret string) error {
+	payload := []byte(addr)
+	if sharedSecret != "" {
+		enc, err := crypt.EncryptBytesWithSecret(payload, sharedSecret)
+		if err != nil {
+			return fmt.Errorf("encrypt bind reply: %w", err)
+		}
+		payload = enc
+	}
+	if len(payload) > 65535 {
+		return fmt.Errorf("bind reply too long")
+	}
+	var hdr [3]byte
+	hdr[0] = BIND_REPLY
+	binary.BigEndian.PutUint16(hdr[1:], uint16(len(payload)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// ReadBindReply reads a BIND_REPLY frame and returns the address it carries.
+func ReadBindReply(r io.Reader, sharedSecret string) (string, error) {
+	var hdr [3]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return "", err
+	}
+	if hdr[0] != BIND_REPLY {
+		return "", fmt.Errorf("unexpected frame 0x%02x, want bind reply", hdr[0])
+	}
+	n := int(binary.BigEndian.Uint16(hdr[1:]))
+	if n == 0 {
+		return "", fmt.Errorf("empty bind reply")
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	if sharedSecret != "" {
+		dec, err := crypt.DecryptBytesWithSecret(buf, sharedSecret)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt bind reply: %v", err)
+		}
+		buf = dec
+	}
+	return string(buf), nil
+}
+
 func ReadHeaderType(r io.Reader) (byte, error) {
 	var hdrType [1]byte
 	if _, err := io.ReadFull(r, hdrType[:]); err != nil {
@@ -128,57 +228,155 @@ func ReadTargetHeaderEnc(r io.Reader, sharedSecret string) (string, []byte, []by
 	return target, readIv, writeIv, readKey, writeKey, nil
 }
 
-// bidiPipe moves bytes both ways until EOF on both directions.
+// quicStreamConn adapts a QUIC stream to net.Conn so it can be wrapped by
+// the crypt package. Addresses are not meaningful for a single stream.
+type quicStreamConn struct {
+	*quic.Stream
+	counter *connections.ByteCounter // the stream's connection, may be nil
+}
+
+func (c *quicStreamConn) Read(p []byte) (int, error) {
+	n, err := c.Stream.Read(p)
+	c.counter.AddReceived(n)
+	return n, err
+}
+
+func (c *quicStreamConn) Write(p []byte) (int, error) {
+	n, err := c.Stream.Write(p)
+	c.counter.AddSent(n)
+	return n, err
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr  { return nil }
+func (c *quicStreamConn) RemoteAddr() net.Addr { return nil }
+
+// PipeOptions are the per-stream settings of a relay. The zero value relays
+// with no limits, batching, compression or byte counting, through default
+// sized buffers.
+type PipeOptions struct {
+	Limiter       *limiter.SharedLimiter     // shared bandwidth limit, nil for none
+	IdleTimeout   time.Duration              // close once neither side sends for this long, 0 for never
+	CoalesceDelay time.Duration              // batch small writes towards the stream, 0 for off
+	Compression   string                     // compression of stream payloads, see SetCompression
+	CompressStats *status.CompressionCounter // bytes before and after compression, nil to skip
+	Buffers       *BufferPool                // copy buffers, nil for the default size
+	StreamLimit   int64                      // bytes/s for this stream alone, 0 for none
+	Counter       *connections.ByteCounter   // the stream's connection's byte counter, nil to skip
+}
+
+// BidiPipe moves bytes both ways until EOF on both directions.
 // Semantics:
 // - When client->stream copy finishes, we FIN the stream write side (stream.Close()).
-// - When stream->client copy finishes, we close the TCP socket.
+// - When stream->client copy finishes, we CloseWrite the TCP socket, or
+// close it when it can't be half-closed.
+// - A half-closed direction leaves the other running, so a client may send
+// EOF and still read the whole response.
 // - On errors, we best-effort cancel the other direction to unblock.
-func BidiPipe(stream *quic.Stream, tcp net.Conn,
-	limiter *limiter.SharedLimiter, readIv []byte, readKey []byte, writeIv []byte, writeKey []byte) {
+// - With opts.IdleTimeout > 0, both sides are closed once neither direction
+// has moved data for that long.
+// - opts.Compression other than CompressionNone compresses before
+// encrypting, adding the bytes before and after to opts.CompressStats.
+// - With opts.CoalesceDelay > 0, small writes towards the stream are batched
+// for up to that long before they are compressed and encrypted.
+// - Copies use buffers from opts.Buffers (nil for the default size).
+// - With opts.StreamLimit > 0, tcp is also held to that many bytes/s of its
+// own, under opts.Limiter.
+// - Bytes on the stream are added to opts.Counter, the stream's
+// connection's counter, when it is not nil.
+func BidiPipe(stream *quic.Stream, tcp net.Conn, readIv, readKey, writeIv, writeKey []byte, opts PipeOptions) {
+	raw := &quicStreamConn{Stream: stream, counter: opts.Counter}
+	var tunnel io.ReadWriter = raw
+	if len(readIv) != 0 && len(readKey) != 0 {
+		// CTR is symmetric, so encrypting on the stream side with the keys
+		// swapped puts the same bytes on the wire as wrapping tcp, while
+		// leaving room for compression to run first.
+		tunnel = crypt.AesWrapConn(raw, writeIv, writeKey, readIv, readKey)
+	}
+	pipe(compressTunnel(tunnel, opts.Compression, opts.CompressStats), stream, tcp, opts)
+}
+
+// BidiPipeGcm is BidiPipe for bridges using AES-GCM. Data on the stream is
+// sealed into authenticated frames, with readKey for what comes in and
+// writeKey for what goes out; a frame that fails authentication tears the
+// stream down.
+func BidiPipeGcm(stream *quic.Stream, tcp net.Conn, readKey, writeKey []byte, opts PipeOptions) {
+	tunnel := crypt.AesGcmWrapConn(&quicStreamConn{Stream: stream, counter: opts.Counter}, readKey, writeKey)
+	if tunnel == nil {
+		log.Printf("BRIDGE: invalid AES-GCM key, closing stream")
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+		tcp.Close()
+		return
+	}
+	pipe(compressTunnel(tunnel, opts.Compression, opts.CompressStats), stream, tcp, opts)
+}
+
+// pipe copies between tunnel (the stream, possibly wrapped) and tcp.
+// stream is used for the QUIC level close/cancel signalling.
+func pipe(tunnel io.ReadWriter, stream *quic.Stream, tcp net.Conn, opts PipeOptions) {
+	idle := NewIdleTimer(opts.IdleTimeout)
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	if len(readIv) != 0 && len(readKey) != 0 {
-		tcp = crypt.AesWrapConn(tcp, readIv, readKey, writeIv, writeKey)
+	// Both directions share the stream's own bucket, the shared one on top
+	limited := tcp
+	if opts.StreamLimit > 0 {
+		limited = limiter.WrapConnRate(limited, opts.StreamLimit)
+	}
+	if opts.Limiter != nil {
+		limited = opts.Limiter.WrapConn(limited)
 	}
 
 	// Copy tcp -> stream
 	go func() {
 		defer wg.Done()
 
-		var src io.Reader
-		if limiter != nil {
-			src = limiter.WrapConn(tcp)
-		} else {
-			src = io.Reader(tcp)
+		var out io.Writer = tunnel
+		batch := newCoalescingWriter(tunnel, opts.CoalesceDelay)
+		if batch != nil {
+			out = batch
 		}
-
-		if _, err := io.Copy(stream, src); err != nil {
+		_, err := opts.Buffers.Copy(out, idle.Reader(limited, tcp.SetReadDeadline))
+		if err == nil {
+			err = batch.flush()
+		}
+		if err != nil || idle.Expired() {
+			batch.stop()
 			stream.CancelWrite(0)
+			stream.Close()
+			// Force the other direction to stop by canceling stream read
+			idle.Stop()
+			stream.CancelRead(0)
+			return
 		}
+		if cw, ok := tunnel.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+		// A half-close: the FIN goes through and the other direction keeps
+		// running until the far end finishes too
 		stream.Close()
-		// Force the other direction to stop by setting deadline
-		tcp.SetReadDeadline(time.Now())
 	}()
 
 	// Copy stream -> tcp
 	go func() {
 		defer wg.Done()
 
-		var dst io.Writer
-		if limiter != nil {
-			dst = limiter.WrapConn(tcp)
-		} else {
-			dst = io.Writer(tcp)
+		_, err := opts.Buffers.Copy(limited, idle.Reader(tunnel, stream.SetReadDeadline))
+		if cw, ok := tcp.(interface{ CloseWrite() error }); ok && err == nil && !idle.Expired() {
+			// Pass the FIN on, tcp may still have more to send
+			cw.CloseWrite()
+			return
 		}
-
-		if _, err := io.Copy(dst, stream); err != nil {
-			stream.CancelRead(0)
+		if errors.Is(err, crypt.ErrAuthFailed) || errors.Is(err, crypt.ErrRekeyMarker) {
+			log.Printf("BRIDGE: %v, tearing down stream", err)
+			stream.CancelWrite(0)
 		}
+		idle.Stop()
 		tcp.Close()
 		// Force the other direction to stop by canceling stream read
 		stream.CancelRead(0)
 	}()
 
 	wg.Wait()
+	tcp.Close()
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"testing"
+	"testing/iotest"
 )
 
 // =========================
@@ -66,6 +67,30 @@ func TestReadTargetHeader_ValidInput(t *testing.T) {
 	}
 }
 
+func TestReadTargetHeaderEnc_Fragmented(t *testing.T) {
+	// The encrypted header carries the stream's CTR keys and IVs; a stream
+	// may hand it over in pieces
+	readIv, writeIv := make([]byte, 16), make([]byte, 16)
+	readKey, writeKey := make([]byte, 32), make([]byte, 32)
+	for _, b := range [][]byte{readIv, writeIv, readKey, writeKey} {
+		rand.Read(b)
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteTargetHeaderEnc(buf, "example.com:443", readIv, writeIv, readKey, writeKey, "sharedSecret"); err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+
+	r := iotest.OneByteReader(bytes.NewReader(buf.Bytes()[1:]))
+	addr, outReadIv, outWriteIv, outReadKey, outWriteKey, err := ReadTargetHeaderEnc(r, "sharedSecret")
+	if err != nil {
+		t.Fatalf("expected nil err, got %v", err)
+	}
+	if addr != "example.com:443" || !bytes.Equal(outReadIv, readIv) || !bytes.Equal(outWriteIv, writeIv) ||
+		!bytes.Equal(outReadKey, readKey) || !bytes.Equal(outWriteKey, writeKey) {
+		t.Fatalf("header read one byte at a time does not match what was written")
+	}
+}
+
 func TestReadTargetHeader_EmptyInput(t *testing.T) {
 	// Write a buffer with length 0 in the header
 	buf := &bytes.Buffer{}
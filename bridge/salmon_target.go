@@ -0,0 +1,60 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Longest DNS name (RFC 1035) and the longest host:port built from one.
+const (
+	maxHostnameLen = 253
+	maxTargetLen   = maxHostnameLen + len(":65535")
+)
+
+// validateTarget checks that a CONNECT target from a stream header is a
+// host:port the far side should dial: an IP literal without a zone or a
+// well-formed DNS name, and a numeric port from 1 to 65535.
+func validateTarget(target string) error {
+	if len(target) > maxTargetLen {
+		return fmt.Errorf("target is %d bytes, longer than %d", len(target), maxTargetLen)
+	}
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Zone() != "" {
+			return fmt.Errorf("IP %q has a zone", host)
+		}
+		return nil
+	}
+	return validateHostname(host)
+}
+
+// validateHostname accepts letters, digits, '-' and '_' in dot separated
+// labels of 1-63 bytes, with an optional trailing dot.
+func validateHostname(host string) error {
+	name := strings.TrimSuffix(host, ".")
+	if name == "" || len(name) > maxHostnameLen {
+		return fmt.Errorf("invalid hostname length %d", len(host))
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("invalid hostname %q", host)
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("invalid character %q in hostname", c)
+			}
+		}
+	}
+	return nil
+}
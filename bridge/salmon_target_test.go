@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"crypto/tls"
+	"errors"
+	"salmoncannon/utils"
+	"strings"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+func TestValidateTarget(t *testing.T) {
+	longLabel := strings.Repeat("a", 63)
+	longName := strings.Repeat(longLabel+".", 3) + strings.Repeat("b", 61) // 253 bytes
+
+	valid := []string{
+		"example.com:443",
+		"EXAMPLE.com.:80",
+		"_srv.example-host.internal:8080",
+		"localhost:1",
+		"127.0.0.1:65535",
+		"[2001:db8::1]:443",
+		"[::ffff:10.0.0.1]:22",
+		longName + ":65535",
+	}
+	for _, target := range valid {
+		if err := validateTarget(target); err != nil {
+			t.Errorf("%q: expected valid, got %v", target, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"oversized":      strings.Repeat("a", maxTargetLen) + ":80",
+		"long name":      longName + "b:80",
+		"long label":     longLabel + "a.com:80",
+		"no port":        "example.com",
+		"empty host":     ":80",
+		"port zero":      "example.com:0",
+		"port too big":   "example.com:65536",
+		"named port":     "example.com:https",
+		"signed port":    "example.com:+80",
+		"control char":   "exam\x00ple.com:80",
+		"newline":        "example.com\r\nHost: evil:80",
+		"space":          "exa mple.com:80",
+		"empty label":    "example..com:80",
+		"ipv6 zone":      "[fe80::1%eth0]:80",
+		"unbracketed v6": "2001:db8::1:443",
+		"slash":          "example.com/path:80",
+	}
+	for name, target := range invalid {
+		if err := validateTarget(target); err == nil {
+			t.Errorf("%s: expected %q to be rejected", name, target)
+		}
+	}
+}
+
+func TestSalmonBridge_RefusesMalformedTarget(t *testing.T) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-badtarget"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	farBridge := NewSalmonBridge("test-badtarget", "127.0.0.1", 42074, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), "")
+	defer farBridge.Close()
+	go farBridge.NewFarListen()
+	time.Sleep(700 * time.Millisecond)
+
+	nearBridge := NewSalmonBridge("test-badtarget", "127.0.0.1", 42074, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "")
+	defer nearBridge.Close()
+
+	var dialErr *DialError
+	_, err := nearBridge.NewNearConnAddrType("exam\nple.com", 80, AddrTypeDomain)
+	if !errors.As(err, &dialErr) || dialErr.Code != DialFailed {
+		t.Fatalf("expected the far to refuse the target, got %v", err)
+	}
+}
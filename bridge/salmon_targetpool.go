@@ -0,0 +1,316 @@
+package bridge
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+// targetPoolProbe bounds the read that checks an idle connection is still
+// clean before it is handed out.
+const targetPoolProbe = time.Millisecond
+
+// targetPool keeps idle far side connections to targets so the next stream
+// from the same client to the same target can skip the dial. Connections are
+// never shared between clients, since a target may tie state such as a
+// login to its connection. It holds at most size connections in total,
+// each for at most idleTimeout.
+// A nil targetPool pools nothing.
+type targetPool struct {
+	mu          sync.Mutex
+	size        int
+	idleTimeout time.Duration
+	idle        map[targetKey][]idleTarget // newest last
+	count       int
+	closed      bool
+}
+
+// targetKey is what a pooled connection may be reused for: the client the
+// stream came from, as streamClient sees it, and the target it went to.
+type targetKey struct {
+	client netip.Addr
+	target string
+}
+
+type idleTarget struct {
+	conn  net.Conn
+	since time.Time
+}
+
+func newTargetPool(size int, idleTimeout time.Duration) *targetPool {
+	if size <= 0 || idleTimeout <= 0 {
+		return nil
+	}
+	return &targetPool{size: size, idleTimeout: idleTimeout, idle: make(map[targetKey][]idleTarget)}
+}
+
+// SetTargetPool lets the far side keep up to size idle target connections
+// for idleTimeout and reuse them for new streams to the same target. A size
+// <= 0 disables it.
+func (s *SalmonBridge) SetTargetPool(size int, idleTimeout time.Duration) {
+	s.targetPool.close()
+	s.targetPool = newTargetPool(size, idleTimeout)
+}
+
+// streamClient picks the client a far side stream is on behalf of: the
+// address its near passed along, or else the near.
+func (s *SalmonBridge) streamClient(stream *quic.Stream, clientAddr netip.AddrPort) netip.Addr {
+	if clientAddr.IsValid() {
+		return clientAddr.Addr().Unmap()
+	}
+	if addr, ok := s.sq.FarStreamRemoteAddr(stream).(*net.UDPAddr); ok {
+		return addr.AddrPort().Addr().Unmap()
+	}
+	return netip.Addr{}
+}
+
+// get returns an idle connection from client to target, or nil if there is
+// none.
+// Connections that went stale, were closed by the target or have unread
+// data waiting are closed instead of being returned.
+func (p *targetPool) get(client netip.Addr, target string) net.Conn {
+	if p == nil {
+		return nil
+	}
+	key := targetKey{client, target}
+	for {
+		p.mu.Lock()
+		p.sweepLocked(time.Now())
+		conns := p.idle[key]
+		if len(conns) == 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		it := conns[len(conns)-1]
+		p.removeLocked(key, len(conns)-1)
+		p.mu.Unlock()
+
+		if clean(it.conn) {
+			return it.conn
+		}
+		it.conn.Close()
+	}
+}
+
+// put keeps conn for reuse, or closes it if the pool is full or closed.
+func (p *targetPool) put(key targetKey, conn net.Conn) {
+	if p == nil {
+		conn.Close()
+		return
+	}
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sweepLocked(now)
+	if p.closed || p.count >= p.size {
+		conn.Close()
+		return
+	}
+	p.idle[key] = append(p.idle[key], idleTarget{conn: conn, since: now})
+	p.count++
+}
+
+// close drops every idle connection and stops the pool taking more.
+func (p *targetPool) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, conns := range p.idle {
+		for _, it := range conns {
+			it.conn.Close()
+		}
+		delete(p.idle, key)
+	}
+	p.count = 0
+	p.closed = true
+}
+
+// Len returns how many idle connections the pool holds.
+func (p *targetPool) Len() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.count
+}
+
+// sweepLocked closes connections idle for longer than idleTimeout. Callers
+// hold mu.
+func (p *targetPool) sweepLocked(now time.Time) {
+	for key, conns := range p.idle {
+		for i := len(conns) - 1; i >= 0; i-- {
+			if now.Sub(conns[i].since) >= p.idleTimeout {
+				conns[i].conn.Close()
+				p.removeLocked(key, i)
+				conns = p.idle[key]
+			}
+		}
+	}
+}
+
+func (p *targetPool) removeLocked(key targetKey, i int) {
+	conns := append(p.idle[key][:i], p.idle[key][i+1:]...)
+	if len(conns) == 0 {
+		delete(p.idle, key)
+	} else {
+		p.idle[key] = conns
+	}
+	p.count--
+}
+
+// clean reports whether an idle conn has nothing to read and is still open,
+// so the next stream starts on a quiet connection.
+func clean(conn net.Conn) bool {
+	var one [1]byte
+	conn.SetReadDeadline(time.Now().Add(targetPoolProbe))
+	n, err := conn.Read(one[:])
+	conn.SetReadDeadline(time.Time{})
+	return n == 0 && isTimeout(err)
+}
+
+// wrap returns dst as a connection that goes back to the pool when the
+// relay closes it, provided the exchange on it is known to be complete.
+// A dst that cannot be half-closed is returned as is, so the target still
+// sees the near side finish sending.
+func (p *targetPool) wrap(client netip.Addr, target string, dst net.Conn) net.Conn {
+	if p == nil {
+		return dst
+	}
+	if _, ok := dst.(interface{ CloseWrite() error }); !ok {
+		return dst
+	}
+	return &pooledConn{Conn: dst, pool: p, key: targetKey{client, target}}
+}
+
+// pooledConn is a target connection the relay may hand back to its pool.
+// When the near side finishes sending, CloseWrite passes the FIN on to the
+// target, which may answer it, and the connection is never reused. Close
+// only returns a connection to the pool when the exchange is known to be
+// complete: the target answered the last thing written to it, nothing half
+// closed it and it saw no error. That is the case when the relay is torn
+// down with the connection still open, e.g. by SBStreamIdleTimeout, after
+// a request/response exchange.
+type pooledConn struct {
+	net.Conn
+	pool *targetPool
+	key  targetKey
+
+	mu         sync.Mutex
+	reading    bool // a Read is in flight
+	answered   bool // the target sent something since the last write
+	halfClosed bool
+	broken     bool
+	closed     bool
+}
+
+func (c *pooledConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	c.reading = true
+	c.mu.Unlock()
+
+	n, err := c.Conn.Read(p)
+
+	c.mu.Lock()
+	c.reading = false
+	if n > 0 {
+		c.answered = true
+	}
+	if err != nil && !isTimeout(err) {
+		c.broken = true
+	}
+	if c.closed {
+		// Closed while this Read was in flight, so handing the connection
+		// back falls to us. Anything read now has nowhere to go.
+		if n > 0 {
+			c.broken = true
+		}
+		reuse := c.reusableLocked()
+		c.mu.Unlock()
+		c.release(reuse)
+		return 0, net.ErrClosed
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *pooledConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	n, err := c.Conn.Write(p)
+	c.mu.Lock()
+	if err != nil {
+		c.broken = true
+	} else if n > 0 {
+		c.answered = false
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+// SetReadDeadline leaves the connection alone once it is closed, as it may
+// already be back in the pool.
+func (c *pooledConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+// CloseWrite half-closes the target so it sees the near side finish, and
+// keeps the connection out of the pool since it cannot be used again.
+func (c *pooledConn) CloseWrite() error {
+	c.mu.Lock()
+	c.halfClosed = true
+	c.mu.Unlock()
+	return c.Conn.(interface{ CloseWrite() error }).CloseWrite()
+}
+
+func (c *pooledConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	if c.reading {
+		// Wake the Read, it releases the connection once it returns
+		c.mu.Unlock()
+		return c.Conn.SetReadDeadline(time.Now())
+	}
+	reuse := c.reusableLocked()
+	c.mu.Unlock()
+	return c.release(reuse)
+}
+
+// reusableLocked reports whether the exchange on the connection finished
+// cleanly. Callers hold mu.
+func (c *pooledConn) reusableLocked() bool {
+	return c.answered && !c.halfClosed && !c.broken
+}
+
+// release returns the connection to the pool when reuse is set and closes
+// it otherwise.
+func (c *pooledConn) release(reuse bool) error {
+	if !reuse {
+		return c.Conn.Close()
+	}
+	c.Conn.SetDeadline(time.Time{})
+	c.pool.put(c.key, c.Conn)
+	return nil
+}
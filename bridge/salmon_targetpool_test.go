@@ -0,0 +1,315 @@
+package bridge
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"salmoncannon/utils"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	peer := <-accepted
+	t.Cleanup(func() {
+		c.Close()
+		peer.Close()
+	})
+	return c, peer
+}
+
+var (
+	clientA = netip.MustParseAddr("192.0.2.1")
+	clientB = netip.MustParseAddr("192.0.2.2")
+)
+
+func TestTargetPool_ReusesIdleConnections(t *testing.T) {
+	p := newTargetPool(1, time.Minute)
+	c, _ := tcpPair(t)
+	p.put(targetKey{clientA, "a:1"}, c)
+	if got := p.get(clientA, "b:1"); got != nil {
+		t.Fatalf("expected no connection for another target")
+	}
+	if got := p.get(clientB, "a:1"); got != nil {
+		t.Fatalf("expected no connection for another client")
+	}
+	if got := p.get(clientA, "a:1"); got != c {
+		t.Fatalf("expected the pooled connection back, got %v", got)
+	}
+	if p.Len() != 0 {
+		t.Fatalf("expected the pool to be empty after get, has %d", p.Len())
+	}
+
+	// Full pools close what they cannot keep
+	other, _ := tcpPair(t)
+	p.put(targetKey{clientA, "a:1"}, c)
+	p.put(targetKey{clientA, "a:1"}, other)
+	if p.Len() != 1 {
+		t.Fatalf("expected the pool to hold 1 connection, has %d", p.Len())
+	}
+	if _, err := other.Write([]byte("x")); err == nil {
+		t.Fatalf("expected the connection past the pool size to be closed")
+	}
+}
+
+func TestTargetPool_DiscardsUncleanConnections(t *testing.T) {
+	p := newTargetPool(4, time.Minute)
+	key := targetKey{clientA, "a:1"}
+
+	chatty, chattyPeer := tcpPair(t)
+	p.put(key, chatty)
+	chattyPeer.Write([]byte("late reply"))
+	time.Sleep(20 * time.Millisecond)
+
+	hungUp, hungUpPeer := tcpPair(t)
+	p.put(key, hungUp)
+	hungUpPeer.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	if got := p.get(clientA, "a:1"); got != nil {
+		t.Fatalf("expected connections with unread data or closed by the target to be discarded")
+	}
+
+	expiring := newTargetPool(4, 20*time.Millisecond)
+	c, _ := tcpPair(t)
+	expiring.put(key, c)
+	time.Sleep(50 * time.Millisecond)
+	if got := expiring.get(clientA, "a:1"); got != nil {
+		t.Fatalf("expected an expired connection to be discarded")
+	}
+}
+
+func TestPooledConn_OnlyCompleteExchangesReturn(t *testing.T) {
+	p := newTargetPool(4, time.Minute)
+	request := func(pc net.Conn, peer net.Conn) {
+		t.Helper()
+		pc.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		io.ReadFull(peer, buf)
+		peer.Write([]byte("pong"))
+		if _, err := io.ReadFull(pc, buf); err != nil {
+			t.Fatalf("failed to read the answer: %v", err)
+		}
+	}
+
+	// Answered and closed with the connection still open: back to the pool
+	c, peer := tcpPair(t)
+	pc := p.wrap(clientA, "a:1", c)
+	request(pc, peer)
+	pc.Close()
+	if p.Len() != 1 {
+		t.Fatalf("expected the answered connection to be pooled")
+	}
+
+	// Half-closed: the FIN reaches the target and the connection is dropped
+	c, peer = tcpPair(t)
+	pc = p.wrap(clientA, "a:1", c)
+	request(pc, peer)
+	pc.(interface{ CloseWrite() error }).CloseWrite()
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the target to see EOF, got %v", err)
+	}
+	pc.Close()
+	if p.Len() != 1 {
+		t.Fatalf("expected a half-closed connection not to be pooled, have %d", p.Len())
+	}
+
+	// Closed by the target: discarded
+	c, peer = tcpPair(t)
+	pc = p.wrap(clientA, "a:1", c)
+	peer.Close()
+	pc.Read(make([]byte, 1))
+	pc.Close()
+	if p.Len() != 1 {
+		t.Fatalf("expected a connection the target closed not to be pooled, have %d", p.Len())
+	}
+
+	// Torn down before the target answered: discarded
+	c, _ = tcpPair(t)
+	pc = p.wrap(clientA, "a:1", c)
+	pc.Write([]byte("ping"))
+	pc.Close()
+	if p.Len() != 1 {
+		t.Fatalf("expected an unanswered connection not to be pooled, have %d", p.Len())
+	}
+
+	// Closed while a Read waits on it: the Read gives it back and the
+	// relay cannot touch it afterwards
+	c, peer = tcpPair(t)
+	pc = p.wrap(clientA, "a:1", c)
+	request(pc, peer)
+	read := make(chan error, 1)
+	go func() {
+		_, err := pc.Read(make([]byte, 1))
+		read <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	pc.Close()
+	if err := <-read; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected the pending Read to fail once closed, got %v", err)
+	}
+	if p.Len() != 2 {
+		t.Fatalf("expected the connection to be pooled by the pending Read, have %d", p.Len())
+	}
+	if _, err := pc.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected writes after Close to fail, got %v", err)
+	}
+}
+
+// targetPoolBridges starts a far with a target pool and SBStreamIdleTimeout
+// and a near to it on port.
+func targetPoolBridges(t *testing.T, port int) (far, near *SalmonBridge) {
+	t.Helper()
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-targetpool"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+	quicCfg := &quic.Config{EnableDatagrams: false}
+
+	far = NewSalmonBridge("test-targetpool", "127.0.0.1", port, tlsCfg, quicCfg,
+		nil, false, "", make([]string, 0), "")
+	far.SetTargetPool(4, time.Minute)
+	far.SetStreamIdleTimeout(300 * time.Millisecond)
+	t.Cleanup(far.Close)
+	go far.NewFarListen()
+	time.Sleep(700 * time.Millisecond)
+
+	near = NewSalmonBridge("test-targetpool", "127.0.0.1", port, tlsCfg, quicCfg,
+		nil, true, "", make([]string, 0), "")
+	t.Cleanup(near.Close)
+	return far, near
+}
+
+func TestSalmonBridge_ReusesTargetConnections(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	var accepts atomic.Int32
+	var hangUp atomic.Bool
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepts.Add(1)
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(c, buf); err != nil {
+						return
+					}
+					c.Write([]byte("pong"))
+					if hangUp.Load() {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+	_, nearBridge := targetPoolBridges(t, 42076)
+
+	// One ping per stream, left open until the far tears the idle stream
+	// down with the target connection still open
+	exchange := func() {
+		t.Helper()
+		conn, err := nearBridge.NewNearConn("127.0.0.1", port)
+		if err != nil {
+			t.Fatalf("failed to open stream: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "pong" {
+			t.Fatalf("unexpected reply %q: %v", buf, err)
+		}
+		if _, err := conn.Read(buf); err == nil {
+			t.Fatalf("expected the far to tear the idle stream down")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	exchange()
+	exchange()
+	if n := accepts.Load(); n != 1 {
+		t.Fatalf("expected both streams to share one target connection, target saw %d", n)
+	}
+
+	// A connection the target hangs up on is not handed out again
+	hangUp.Store(true)
+	exchange()
+	exchange()
+	if n := accepts.Load(); n != 2 {
+		t.Fatalf("expected a fresh dial after the target hung up, target saw %d", n)
+	}
+}
+
+// A target that only answers once it reads EOF gets the near side's FIN,
+// and its connection is not pooled afterwards.
+func TestSalmonBridge_TargetPoolPassesEOF(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				data, err := io.ReadAll(c)
+				if err != nil {
+					return
+				}
+				c.Write(data)
+			}()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+	farBridge, nearBridge := targetPoolBridges(t, 42081)
+
+	conn, err := nearBridge.NewNearConn("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("echo me"))
+	conn.(interface{ CloseWrite() error }).CloseWrite()
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != "echo me" {
+		t.Fatalf("expected the target to echo once it saw EOF, got %q: %v", got, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := farBridge.targetPool.Len(); n != 0 {
+		t.Fatalf("expected the half-closed connection not to be pooled, pool has %d", n)
+	}
+}
@@ -0,0 +1,272 @@
+package bridge
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"salmoncannon/connections"
+	"salmoncannon/status"
+	"strconv"
+	"sync"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+// maxUDPPacket is the largest UDP payload read from a target.
+const maxUDPPacket = 64 * 1024
+
+// udpResolveCacheSize bounds the far side's per-association cache of
+// resolved target names.
+const udpResolveCacheSize = 256
+
+// NearUDPAssociation relays UDP packets through the far side for a SOCKS
+// UDP ASSOCIATE. Packets travel as QUIC datagrams, so a lost packet is lost
+// rather than holding up the ones behind it. The association's stream
+// stays open until Close and ends the far side's socket with it.
+//
+// Packets are in SOCKS5 UDP form without RSV and FRAG: ATYP, DST.ADDR,
+// DST.PORT and DATA. Replies carry the sender's address in the same spot.
+type NearUDPAssociation struct {
+	stream  *quic.Stream
+	flow    *connections.StreamDatagrams
+	cleanup func()
+	aead    datagramCipher
+
+	closeOnce sync.Once
+}
+
+// NewNearUDPAssociation asks the far side for a UDP socket. The bridge must
+// have QUIC datagrams enabled on both sides.
+func (s *SalmonBridge) NewNearUDPAssociation() (*NearUDPAssociation, error) {
+	stream, flow, cleanup, err := s.sq.OpenDatagramStream()
+	if err != nil {
+		return nil, err
+	}
+	a := &NearUDPAssociation{stream: stream, flow: flow, cleanup: cleanup}
+
+	if _, err := stream.Write([]byte{UDP_ASSOC_HEADER}); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("write UDP associate header: %w", err)
+	}
+	// The target is unused, but the header carries the keys
+	keys, err := s.writeConnectHeader(stream, "0.0.0.0:0", AddrTypeIPv4, nil)
+	if err == nil {
+		stream.SetReadDeadline(time.Now().Add(s.dialResultTimeout))
+		err = readDialResult(stream)
+		stream.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	a.aead = newDatagramCipher(keys.writeKey, keys.readKey)
+	return a, nil
+}
+
+// Send relays pkt to the target it names.
+func (a *NearUDPAssociation) Send(pkt []byte) error {
+	return a.flow.Send(a.aead.seal(pkt))
+}
+
+// Receive waits for the next packet from a target. Packets that do not
+// decrypt are skipped.
+func (a *NearUDPAssociation) Receive(ctx context.Context) ([]byte, error) {
+	for {
+		p, err := a.flow.Receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if pkt, err := a.aead.open(p); err == nil {
+			return pkt, nil
+		}
+	}
+}
+
+// Close ends the association and the far side's socket.
+func (a *NearUDPAssociation) Close() {
+	a.closeOnce.Do(func() {
+		a.stream.CancelRead(0)
+		a.stream.Close()
+		a.cleanup()
+	})
+}
+
+// handleUDPAssociate serves a UDP association on the far side: packets from
+// the near are sent from one UDP socket and whatever comes back to it is
+// returned, until the near closes the stream.
+func (s *SalmonBridge) handleUDPAssociate(stream *quic.Stream, keys streamKeys) {
+	defer status.GlobalConnMonitorRef.RemoveStream(s.BridgeName)
+	defer stream.Close()
+
+	flow, err := s.sq.FarStreamDatagrams(stream)
+	if err != nil {
+		log.Printf("FAR: Bridge %s refused UDP associate: %v", s.BridgeName, err)
+		s.refuseStream(stream, DialNotAllowed)
+		return
+	}
+	defer flow.Close()
+
+	conn, err := s.listenUDPTarget()
+	if err != nil {
+		log.Printf("FAR: Bridge %s UDP associate listen error: %v", s.BridgeName, err)
+		s.refuseStream(stream, DialFailed)
+		return
+	}
+	defer conn.Close()
+	status.GlobalConnMonitorRef.IncOUT()
+	defer status.GlobalConnMonitorRef.DecOUT()
+
+	if err := writeDialResult(stream, DialOK); err != nil {
+		log.Printf("FAR: Bridge %s write dial result error: %v", s.BridgeName, err)
+		return
+	}
+	// The far seals with the key the near opens with
+	aead := newDatagramCipher(keys.readKey, keys.writeKey)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		// Nothing more is sent on the stream, it ending ends the association
+		io.Copy(io.Discard, stream)
+		cancel()
+		conn.Close()
+	}()
+
+	go func() {
+		buf := make([]byte, maxUDPPacket)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			pkt := append(appendUDPAddr(nil, from), buf[:n]...)
+			// Too large for a datagram on this path is dropped like any loss
+			flow.Send(aead.seal(pkt))
+		}
+	}()
+
+	resolved := make(map[string]*net.UDPAddr)
+	for {
+		p, err := flow.Receive(ctx)
+		if err != nil {
+			return
+		}
+		pkt, err := aead.open(p)
+		if err != nil {
+			continue
+		}
+		addrType, host, port, payload, err := parseUDPAddr(pkt)
+		if err != nil {
+			continue
+		}
+		target := net.JoinHostPort(host, strconv.Itoa(port))
+		if s.shouldBlockFarOutConn(target, addrType) {
+			status.GlobalConnMonitorRef.IncAllowlistBlock(s.BridgeName)
+			continue
+		}
+		addr, ok := resolved[target]
+		if !ok {
+			if addr, err = net.ResolveUDPAddr("udp", target); err != nil {
+				status.GlobalConnMonitorRef.IncDialFailure(s.BridgeName)
+				continue
+			}
+			if len(resolved) >= udpResolveCacheSize {
+				clear(resolved)
+			}
+			resolved[target] = addr
+		}
+		conn.WriteToUDP(payload, addr)
+	}
+}
+
+// appendUDPAddr appends addr to b as ATYP, address and port.
+func appendUDPAddr(b []byte, addr *net.UDPAddr) []byte {
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		b = append(append(b, AddrTypeIPv4), ip4...)
+	} else {
+		b = append(append(b, AddrTypeIPv6), addr.IP.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(addr.Port))
+}
+
+// parseUDPAddr splits a packet into the address it names and its payload.
+func parseUDPAddr(pkt []byte) (addrType byte, host string, port int, payload []byte, err error) {
+	if len(pkt) < 1 {
+		return 0, "", 0, nil, errors.New("empty UDP packet")
+	}
+	addrType = pkt[0]
+	rest := pkt[1:]
+	switch addrType {
+	case AddrTypeIPv4, AddrTypeIPv6:
+		n := net.IPv4len
+		if addrType == AddrTypeIPv6 {
+			n = net.IPv6len
+		}
+		if len(rest) < n+2 {
+			return 0, "", 0, nil, errors.New("short UDP packet address")
+		}
+		host = net.IP(rest[:n]).String()
+		rest = rest[n:]
+	case AddrTypeDomain:
+		if len(rest) < 1 || len(rest) < 1+int(rest[0])+2 {
+			return 0, "", 0, nil, errors.New("short UDP packet address")
+		}
+		host = string(rest[1 : 1+rest[0]])
+		rest = rest[1+rest[0]:]
+	default:
+		return 0, "", 0, nil, fmt.Errorf("unknown UDP packet address type 0x%02x", addrType)
+	}
+	port = int(binary.BigEndian.Uint16(rest))
+	return addrType, host, port, rest[2:], nil
+}
+
+// datagramCipher seals datagrams with AES-GCM under a random nonce when the
+// bridge has a shared secret. Without one the zero value passes them
+// through, leaving the QUIC TLS layer as the only protection.
+type datagramCipher struct {
+	sealer, opener cipher.AEAD
+}
+
+// newDatagramCipher builds the cipher for one side of an association from
+// the stream keys; nil keys mean no shared secret.
+func newDatagramCipher(sealKey, openKey []byte) datagramCipher {
+	if sealKey == nil || openKey == nil {
+		return datagramCipher{}
+	}
+	return datagramCipher{sealer: newGcm(sealKey), opener: newGcm(openKey)}
+}
+
+func newGcm(key []byte) cipher.AEAD {
+	// The stream keys are always 32 bytes, valid for AES-256
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+func (c datagramCipher) seal(p []byte) []byte {
+	if c.sealer == nil {
+		return p
+	}
+	nonce := make([]byte, c.sealer.NonceSize(), c.sealer.NonceSize()+len(p)+c.sealer.Overhead())
+	rand.Read(nonce)
+	return c.sealer.Seal(nonce, nonce, p, nil)
+}
+
+func (c datagramCipher) open(p []byte) ([]byte, error) {
+	if c.opener == nil {
+		return p, nil
+	}
+	n := c.opener.NonceSize()
+	if len(p) < n {
+		return nil, errors.New("datagram too short")
+	}
+	return c.opener.Open(nil, p[:n], p[n:], nil)
+}
@@ -0,0 +1,153 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"salmoncannon/utils"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+func TestUDPAddr_RoundTrip(t *testing.T) {
+	for _, addr := range []*net.UDPAddr{
+		{IP: net.ParseIP("192.0.2.1"), Port: 53},
+		{IP: net.ParseIP("2001:db8::1"), Port: 5353},
+	} {
+		pkt := append(appendUDPAddr(nil, addr), "data"...)
+		addrType, host, port, payload, err := parseUDPAddr(pkt)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", addr, err)
+		}
+		if addrType != AddrTypeOf(host) || host != addr.IP.String() || port != addr.Port || string(payload) != "data" {
+			t.Fatalf("expected %s with data, got type 0x%02x %s:%d %q", addr, addrType, host, port, payload)
+		}
+	}
+
+	pkt := append([]byte{AddrTypeDomain, 11}, "example.com"...)
+	pkt = append(pkt, 0, 80)
+	if _, host, port, payload, err := parseUDPAddr(pkt); err != nil || host != "example.com" || port != 80 || len(payload) != 0 {
+		t.Fatalf("expected example.com:80, got %s:%d %q %v", host, port, payload, err)
+	}
+
+	for _, bad := range [][]byte{nil, {AddrTypeIPv4, 1, 2}, {AddrTypeDomain, 5, 'a'}, {0x02, 0, 0}} {
+		if _, _, _, _, err := parseUDPAddr(bad); err == nil {
+			t.Errorf("expected %v to fail", bad)
+		}
+	}
+}
+
+func TestDatagramCipher(t *testing.T) {
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 32)
+	near := newDatagramCipher(key1, key2)
+	far := newDatagramCipher(key2, key1)
+
+	sealed := near.seal([]byte("hello"))
+	if bytes.Contains(sealed, []byte("hello")) {
+		t.Fatalf("expected the payload to be encrypted")
+	}
+	if p, err := far.open(sealed); err != nil || string(p) != "hello" {
+		t.Fatalf("expected far to open the near's datagram, got %q %v", p, err)
+	}
+	if _, err := near.open(sealed); err == nil {
+		t.Fatalf("expected a datagram sealed for the far not to open on the near")
+	}
+
+	var plain datagramCipher
+	if p, err := plain.open(plain.seal([]byte("hi"))); err != nil || string(p) != "hi" {
+		t.Fatalf("expected no shared secret to pass datagrams through, got %q %v", p, err)
+	}
+}
+
+// startUDPEcho answers every UDP packet with the same bytes.
+func startUDPEcho(t *testing.T) *net.UDPAddr {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], from)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestSalmonBridge_UDPAssociate(t *testing.T) {
+	echo := startUDPEcho(t)
+
+	for i, secret := range []string{"", "udp-secret"} {
+		farPort := 42068 + i
+		tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-udp"},
+			Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+		quicCfg := &quic.Config{EnableDatagrams: true}
+
+		farBridge := NewSalmonBridge("test-udp", "127.0.0.1", farPort, tlsCfg, quicCfg,
+			nil, false, "", make([]string, 0), secret)
+		defer farBridge.Close()
+		go farBridge.NewFarListen()
+		time.Sleep(700 * time.Millisecond)
+
+		nearBridge := NewSalmonBridge("test-udp", "127.0.0.1", farPort, tlsCfg, quicCfg,
+			nil, true, "", make([]string, 0), secret)
+		defer nearBridge.Close()
+
+		assoc, err := nearBridge.NewNearUDPAssociation()
+		if err != nil {
+			t.Fatalf("secret %q: failed to associate: %v", secret, err)
+		}
+
+		pkt := append(appendUDPAddr(nil, echo), "ping"...)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		var reply []byte
+		// Datagrams may be lost, so keep asking until one comes back
+		for reply == nil && ctx.Err() == nil {
+			if err := assoc.Send(pkt); err != nil {
+				t.Fatalf("secret %q: failed to send: %v", secret, err)
+			}
+			recvCtx, recvCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			reply, _ = assoc.Receive(recvCtx)
+			recvCancel()
+		}
+		cancel()
+		if !bytes.Equal(reply, pkt) {
+			t.Fatalf("secret %q: expected the echo from %s, got %v", secret, echo, reply)
+		}
+		assoc.Close()
+	}
+}
+
+func TestSalmonBridge_UDPAssociateNeedsDatagrams(t *testing.T) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test-udp-off"},
+		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()}}
+
+	farBridge := NewSalmonBridge("test-udp-off", "127.0.0.1", 42070, tlsCfg, &quic.Config{EnableDatagrams: false},
+		nil, false, "", make([]string, 0), "")
+	defer farBridge.Close()
+	go farBridge.NewFarListen()
+	time.Sleep(700 * time.Millisecond)
+
+	nearBridge := NewSalmonBridge("test-udp-off", "127.0.0.1", 42070, tlsCfg, &quic.Config{EnableDatagrams: true},
+		nil, true, "", make([]string, 0), "")
+	defer nearBridge.Close()
+
+	if _, err := nearBridge.NewNearUDPAssociation(); err == nil {
+		t.Fatalf("expected a far side without datagrams to refuse the association")
+	}
+	// Streams still work for reliable flows
+	if _, err := nearBridge.NewNearConn("127.0.0.1", 9); err == nil || !errors.As(err, new(*DialError)) {
+		t.Fatalf("expected the far side to answer a stream, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// AddressRegexPrefix marks an address filter entry as a regular expression
+// over hostnames, e.g. "re:^.*\.example\.com$".
+const AddressRegexPrefix = "re:"
+
+// AddressFilter matches hosts against a list of literal IPs, CIDR ranges,
+// hostnames and hostname patterns, as used by SBAllowedInAddresses /
+// SBAllowedOutAddresses.
+type AddressFilter struct {
+	prefixes []netip.Prefix
+	ips      []netip.Addr
+	hosts    []string
+	patterns []*regexp.Regexp
+}
+
+// ParseAddressFilter parses entries such as "10.0.0.1", "10.0.0.0/8",
+// "fd00::/8", "example.com" or "re:^.*\.example\.com$". Anything else
+// containing a '/' must be a valid CIDR.
+func ParseAddressFilter(entries []string) (*AddressFilter, error) {
+	f := &AddressFilter{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if expr, ok := strings.CutPrefix(entry, AddressRegexPrefix); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex %q: %w", entry, err)
+			}
+			f.patterns = append(f.patterns, re)
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+			}
+			f.prefixes = append(f.prefixes, prefix.Masked())
+			continue
+		}
+		if ip, err := netip.ParseAddr(entry); err == nil {
+			f.ips = append(f.ips, ip.Unmap())
+			continue
+		}
+		f.hosts = append(f.hosts, strings.ToLower(entry))
+	}
+	return f, nil
+}
+
+// Empty reports whether the filter has no entries (i.e. allows everything).
+func (f *AddressFilter) Empty() bool {
+	return f == nil || (len(f.prefixes) == 0 && len(f.ips) == 0 && len(f.hosts) == 0 && len(f.patterns) == 0)
+}
+
+// HasPatterns reports whether the filter has any regex entries.
+func (f *AddressFilter) HasPatterns() bool {
+	return f != nil && len(f.patterns) > 0
+}
+
+// Matches reports whether host (an IP literal or hostname, without port)
+// is covered by the filter. IPs are tested against literal and CIDR entries,
+// hostnames are compared case-insensitively and matched, lower-cased,
+// against the regex entries.
+func (f *AddressFilter) Matches(host string) bool {
+	if f == nil {
+		return false
+	}
+	if ip, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		ip = ip.Unmap()
+		for _, allowed := range f.ips {
+			if allowed == ip {
+				return true
+			}
+		}
+		for _, prefix := range f.prefixes {
+			if prefix.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	host = strings.ToLower(host)
+	for _, allowed := range f.hosts {
+		if allowed == host {
+			return true
+		}
+	}
+	for _, re := range f.patterns {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
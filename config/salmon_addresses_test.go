@@ -0,0 +1,194 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAddressFilter_CIDRMatch(t *testing.T) {
+	f, err := ParseAddressFilter([]string{"10.1.2.0/24"})
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	for _, host := range []string{"10.1.2.0", "10.1.2.1", "10.1.2.255"} {
+		if !f.Matches(host) {
+			t.Errorf("expected %s to match 10.1.2.0/24", host)
+		}
+	}
+}
+
+func TestAddressFilter_CIDRNoMatch(t *testing.T) {
+	f, err := ParseAddressFilter([]string{"10.1.2.0/24"})
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	for _, host := range []string{"10.1.3.1", "192.168.1.1", "example.com", "::1"} {
+		if f.Matches(host) {
+			t.Errorf("expected %s not to match 10.1.2.0/24", host)
+		}
+	}
+}
+
+func TestAddressFilter_MixedList(t *testing.T) {
+	f, err := ParseAddressFilter([]string{
+		"127.0.0.1",
+		"192.168.0.0/16",
+		"fd00::/8",
+		"Example.com",
+	})
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	cases := []struct {
+		host   string
+		expect bool
+	}{
+		{"127.0.0.1", true},
+		{"127.0.0.2", false},
+		{"192.168.44.3", true},
+		{"::ffff:192.168.1.1", true}, // IPv4-mapped IPv6
+		{"fd12:3456::1", true},
+		{"[fd12:3456::1]", true},
+		{"fe80::1", false},
+		{"example.com", true},
+		{"EXAMPLE.COM", true},
+		{"www.example.com", false},
+	}
+	for _, c := range cases {
+		if got := f.Matches(c.host); got != c.expect {
+			t.Errorf("Matches(%q) = %v, want %v", c.host, got, c.expect)
+		}
+	}
+}
+
+func TestAddressFilter_RegexEntries(t *testing.T) {
+	f, err := ParseAddressFilter([]string{
+		"10.0.0.1",
+		"192.168.0.0/16",
+		"api.internal",
+		`re:^.*\.example\.com$`,
+		"re:^cdn[0-9]+\\.net$",
+	})
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	if f.Empty() || !f.HasPatterns() {
+		t.Fatalf("expected a non-empty filter with patterns")
+	}
+	cases := []struct {
+		host   string
+		expect bool
+	}{
+		{"10.0.0.1", true},
+		{"192.168.3.4", true},
+		{"api.internal", true},
+		{"www.example.com", true},
+		{"WWW.Example.COM", true}, // matched lower-cased
+		{"a.b.example.com", true},
+		{"example.com", false},
+		{"example.com.evil.org", false},
+		{"cdn12.net", true},
+		{"cdn.net", false},
+		{"10.0.0.2", false}, // patterns only apply to hostnames
+	}
+	for _, c := range cases {
+		if got := f.Matches(c.host); got != c.expect {
+			t.Errorf("Matches(%q) = %v, want %v", c.host, got, c.expect)
+		}
+	}
+
+	// Only patterns still makes a non-empty filter
+	only, err := ParseAddressFilter([]string{"re:^x$"})
+	if err != nil || only.Empty() {
+		t.Fatalf("expected a pattern-only filter to be non-empty, got %v", err)
+	}
+
+	if _, err := ParseAddressFilter([]string{"re:^(unclosed$"}); err == nil || !strings.Contains(err.Error(), "invalid regex") {
+		t.Fatalf("expected an invalid regex to fail, got %v", err)
+	}
+}
+
+func TestAddressFilter_EmptyAndNil(t *testing.T) {
+	f, err := ParseAddressFilter(nil)
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	if !f.Empty() {
+		t.Errorf("expected empty filter")
+	}
+	var nilFilter *AddressFilter
+	if !nilFilter.Empty() {
+		t.Errorf("expected nil filter to be empty")
+	}
+	if nilFilter.Matches("127.0.0.1") {
+		t.Errorf("expected nil filter to match nothing")
+	}
+}
+
+func TestAddressFilter_InvalidCIDR(t *testing.T) {
+	if _, err := ParseAddressFilter([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("expected error for invalid CIDR")
+	}
+}
+
+func TestLoadConfig_ParsesAddressFilters(t *testing.T) {
+	yamlData := `
+SalmonBridges:
+  - SBName: "cidr-bridge"
+    SBSocksListenPort: 1080
+    SBConnect: true
+    SBFarPort: 55001
+    SBFarIp: "127.0.0.1"
+    SBAllowedInAddresses:
+      - "10.0.0.0/8"
+    SBAllowedOutAddresses:
+      - "example.com"
+      - "192.168.1.0/24"
+`
+	tmpfile, err := os.CreateTemp("", "test_cidr_*.yml")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.WriteString(yamlData)
+	tmpfile.Close()
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	b := cfg.Bridges[0]
+	if !b.AllowedInFilter.Matches("10.20.30.40") {
+		t.Errorf("expected in filter to allow 10.20.30.40")
+	}
+	if !b.AllowedOutFilter.Matches("192.168.1.9") || !b.AllowedOutFilter.Matches("example.com") {
+		t.Errorf("expected out filter to allow CIDR and hostname entries")
+	}
+}
+
+func TestLoadConfig_InvalidCIDR(t *testing.T) {
+	yamlData := `
+SalmonBridges:
+  - SBName: "bad-cidr"
+    SBConnect: false
+    SBNearPort: 55001
+    SBAllowedOutAddresses:
+      - "10.0.0.0/99"
+`
+	tmpfile, err := os.CreateTemp("", "test_bad_cidr_*.yml")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.WriteString(yamlData)
+	tmpfile.Close()
+
+	_, err = LoadConfig(tmpfile.Name())
+	if err == nil {
+		t.Fatalf("expected LoadConfig to fail on invalid CIDR")
+	}
+	if !strings.Contains(err.Error(), "bad-cidr") {
+		t.Errorf("expected error to name the bridge, got: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+)
+
+// Values for ApiConfig.TLSMinVersion.
+const (
+	TLSVersion12 = "1.2"
+	TLSVersion13 = "1.3"
+)
+
+// TLSConfig returns the API's TLS settings: TLSMinVersion and
+// TLSCipherSuites, without a certificate. An empty TLSMinVersion is TLS 1.2
+// and no TLSCipherSuites leaves Go's default suites.
+func (a *ApiConfig) TLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch a.TLSMinVersion {
+	case "", TLSVersion12:
+	case TLSVersion13:
+		cfg.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("TLSMinVersion must be %q or %q, got %q", TLSVersion12, TLSVersion13, a.TLSMinVersion)
+	}
+	if len(a.TLSCipherSuites) == 0 {
+		return cfg, nil
+	}
+	if cfg.MinVersion == tls.VersionTLS13 {
+		// Go does not let TLS 1.3 suites be chosen
+		return nil, fmt.Errorf("TLSCipherSuites only apply to TLS 1.2, they cannot be set with TLSMinVersion %q", TLSVersion13)
+	}
+	for _, name := range a.TLSCipherSuites {
+		id, err := cipherSuiteID(name)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+	// The server will not start HTTP/2 without one of these
+	if !slices.Contains(cfg.CipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) &&
+		!slices.Contains(cfg.CipherSuites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+		return nil, fmt.Errorf("TLSCipherSuites must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, HTTP/2 requires one")
+	}
+	return cfg, nil
+}
+
+// cipherSuiteID looks up a TLS 1.2 cipher suite by its IANA name, e.g.
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". Suites Go considers insecure
+// are refused.
+func cipherSuiteID(name string) (uint16, error) {
+	for _, s := range tls.CipherSuites() {
+		if s.Name == name {
+			if !slices.Contains(s.SupportedVersions, tls.VersionTLS12) {
+				return 0, fmt.Errorf("TLSCipherSuites entry %q is a TLS 1.3 suite, which cannot be chosen", name)
+			}
+			return s.ID, nil
+		}
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.Name == name {
+			return 0, fmt.Errorf("TLSCipherSuites entry %q is insecure", name)
+		}
+	}
+	return 0, fmt.Errorf("TLSCipherSuites entry %q is not a known cipher suite", name)
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"crypto/tls"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApiConfig_TLSConfig(t *testing.T) {
+	cfg, err := (&ApiConfig{}).TLSConfig()
+	if err != nil || cfg.MinVersion != tls.VersionTLS12 || cfg.CipherSuites != nil {
+		t.Fatalf("expected TLS 1.2 and default suites, got %+v, %v", cfg, err)
+	}
+
+	cfg, err = (&ApiConfig{TLSMinVersion: TLSVersion13}).TLSConfig()
+	if err != nil || cfg.MinVersion != tls.VersionTLS13 {
+		t.Fatalf("expected TLS 1.3, got %+v, %v", cfg, err)
+	}
+
+	suites := []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}
+	cfg, err = (&ApiConfig{TLSCipherSuites: suites}).TLSConfig()
+	want := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+	if err != nil || !slices.Equal(cfg.CipherSuites, want) {
+		t.Fatalf("expected suites %v, got %v, %v", want, cfg.CipherSuites, err)
+	}
+}
+
+func TestApiConfig_TLSConfigErrors(t *testing.T) {
+	tests := []struct {
+		api  ApiConfig
+		want string
+	}{
+		{ApiConfig{TLSMinVersion: "1.1"}, `TLSMinVersion must be "1.2" or "1.3"`},
+		{ApiConfig{TLSMinVersion: "TLS1.3"}, `TLSMinVersion must be "1.2" or "1.3"`},
+		{ApiConfig{TLSCipherSuites: []string{"TLS_NOPE"}}, "not a known cipher suite"},
+		{ApiConfig{TLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, "is insecure"},
+		{ApiConfig{TLSCipherSuites: []string{"TLS_AES_128_GCM_SHA256"}}, "TLS 1.3 suite"},
+		{ApiConfig{TLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}}, "HTTP/2 requires one"},
+		{ApiConfig{TLSMinVersion: TLSVersion13, TLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}, "only apply to TLS 1.2"},
+	}
+	for _, tt := range tests {
+		if _, err := tt.api.TLSConfig(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.api, tt.want, err)
+		}
+	}
+}
+
+func TestValidate_ApiTLS(t *testing.T) {
+	cfg := &SalmonCannonConfig{ApiConfig: &ApiConfig{Port: 8080}}
+	cfg.SetDefaults()
+	if cfg.ApiConfig.TLSMinVersion != TLSVersion12 {
+		t.Fatalf("expected TLS 1.2 by default, got %q", cfg.ApiConfig.TLSMinVersion)
+	}
+	cfg.ApiConfig.TLSMinVersion = "1.0"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "ApiConfig.TLSMinVersion") {
+		t.Fatalf("expected TLSMinVersion error, got %v", err)
+	}
+}
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"net"
 	"os"
 	"strconv"
 	"strings"
@@ -17,6 +20,14 @@ type GlobalLogConfig struct {
 	MaxBackups int    `yaml:"MaxBackups,omitempty"`
 	MaxAge     int    `yaml:"MaxAge,omitempty"` // days
 	Compress   bool   `yaml:"Compress,omitempty"`
+	Format     string `yaml:"Format,omitempty"` // "text" or "json", default "text"
+}
+
+// GlobalMetricsConfig pushes the bridge metrics to a StatsD server
+type GlobalMetricsConfig struct {
+	Endpoint string         `yaml:"Endpoint"`           // StatsD host:port, UDP
+	Interval DurationString `yaml:"Interval,omitempty"` // default "10s"
+	Prefix   string         `yaml:"Prefix,omitempty"`   // default "salmoncannon"
 }
 
 type QuicConfig struct {
@@ -40,18 +51,45 @@ func (q *QuicConfig) SetDefaults() {
 type ApiConfig struct {
 	Hostname string `yaml:"Hostname,omitempty"`
 	Port     int    `yaml:"Port,omitempty"`
+	Socket   string `yaml:"Socket,omitempty"`  // UNIX socket path to listen on instead of Hostname and Port
 	TLSCert  string `yaml:"TLSCert,omitempty"` // Path to TLS certificate file
 	TLSKey   string `yaml:"TLSKey,omitempty"`  // Path to TLS key file
+
+	TLSMinVersion   string   `yaml:"TLSMinVersion,omitempty"`   // TLSVersion12 or TLSVersion13, default "1.2"
+	TLSCipherSuites []string `yaml:"TLSCipherSuites,omitempty"` // TLS 1.2 suites by IANA name, default Go's
+
+	AuthToken string `yaml:"AuthToken,omitempty"` // bearer token for every endpoint; the reload and bridge config endpoints are off without one
+
+	HistoryInterval  DurationString `yaml:"HistoryInterval,omitempty"`  // bandwidth sample interval, default "5s"
+	HistoryRetention DurationString `yaml:"HistoryRetention,omitempty"` // how much history to keep, default "1h"
+}
+
+// ListenAddr is the address the API listens on: "unix:" and the Socket
+// path when one is set, otherwise Hostname:Port.
+func (a *ApiConfig) ListenAddr() string {
+	if a.Socket != "" {
+		return "unix:" + a.Socket
+	}
+	return net.JoinHostPort(a.Hostname, strconv.Itoa(a.Port))
+}
+
+// HistorySamples is the number of bandwidth samples kept per bridge.
+func (a *ApiConfig) HistorySamples() int {
+	if a.HistoryInterval <= 0 {
+		return 0
+	}
+	return int(a.HistoryRetention.Duration() / a.HistoryInterval.Duration())
 }
 
 type SocksRedirectConfig struct {
 	Hostname string `yaml:"Hostname,omitempty"`
 	Port     int    `yaml:"Port,omitempty"`
-	// Map of partial destination addresses and names of bridges to direct them through
+	// Destination patterns (see BridgeFor) and names of bridges to direct them through
 	Redirects map[string]string `yaml:"Redirects,omitempty"`
 }
 
-// DurationString supports "10s", "5m" (only lowercase s/m)
+// DurationString supports "500ms", "10s", "5m", "1h" and "7d" (lowercase
+// only), where a day is 24h. A bare number is taken as seconds.
 type DurationString time.Duration
 
 func (d *DurationString) UnmarshalYAML(value *yaml.Node) error {
@@ -64,8 +102,16 @@ func (d *DurationString) UnmarshalYAML(value *yaml.Node) error {
 		*d = DurationString(time.Duration(v) * time.Second)
 		return nil
 	}
-	if !(strings.HasSuffix(s, "s") || strings.HasSuffix(s, "m") || strings.HasSuffix(s, "ms")) {
-		return fmt.Errorf("invalid duration: %s (must end with 's' or 'm', or 'ms')", s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		v, err := strconv.ParseFloat(days, 64)
+		if err != nil || !(math.Abs(v) <= float64(math.MaxInt64)/float64(24*time.Hour)) {
+			return fmt.Errorf("invalid duration: %s", s)
+		}
+		*d = DurationString(time.Duration(v * float64(24*time.Hour)))
+		return nil
+	}
+	if !(strings.HasSuffix(s, "s") || strings.HasSuffix(s, "m") || strings.HasSuffix(s, "h")) {
+		return fmt.Errorf("invalid duration: %s (must end with 'ms', 's', 'm', 'h' or 'd')", s)
 	}
 	dur, err := time.ParseDuration(s)
 	if err != nil {
@@ -79,9 +125,44 @@ func (d DurationString) Duration() time.Duration {
 	return time.Duration(d)
 }
 
-// SizeString supports "10K", "10M", "1G" (uppercase only)
+// MarshalJSON writes the duration as a string like "10s" rather than
+// nanoseconds.
+func (d DurationString) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration().String())
+}
+
+// SizeString is a size in bytes. Suffixes are case-insensitive and follow
+// two conventions:
+//
+//   - "K", "M" and "G" are decimal bits, for bandwidth: "100M" is 100
+//     megabits a second, 12,500,000 bytes.
+//   - "KB", "MB" and "GB" and their "Ki", "Mi", "Gi" (or "KiB", "MiB",
+//     "GiB") spellings are binary bytes, for buffers: "1MB" and "1Mi" are
+//     both 1,048,576 bytes.
+//
+// A bare number is bytes.
 type SizeString int64
 
+// sizeSuffixes maps each suffix to its multiplier, longer suffixes first so
+// "KB" is not read as "K" followed by a stray "B".
+var sizeSuffixes = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"kib", 1 << 10},
+	{"mib", 1 << 20},
+	{"gib", 1 << 30},
+	{"kb", 1 << 10},
+	{"mb", 1 << 20},
+	{"gb", 1 << 30},
+	{"ki", 1 << 10},
+	{"mi", 1 << 20},
+	{"gi", 1 << 30},
+	{"k", 1000 / 8},
+	{"m", 1000 * 1000 / 8},
+	{"g", 1000 * 1000 * 1000 / 8},
+}
+
 func (s *SizeString) UnmarshalYAML(value *yaml.Node) error {
 	raw := value.Value
 	if value.Tag == "!!int" {
@@ -97,34 +178,20 @@ func (s *SizeString) UnmarshalYAML(value *yaml.Node) error {
 		return fmt.Errorf("empty size string")
 	}
 	multiplier := int64(1)
-	switch {
-	case strings.HasSuffix(raw, "K"):
-		multiplier = 1000 / 8
-		raw = strings.TrimSuffix(raw, "K")
-	case strings.HasSuffix(raw, "KB"):
-		multiplier = 1024
-		raw = strings.TrimSuffix(raw, "KB")
-	case strings.HasSuffix(raw, "M"):
-		multiplier = (1000 * 1000) / 8
-		raw = strings.TrimSuffix(raw, "M")
-	case strings.HasSuffix(raw, "MB"):
-		multiplier = 1024 * 1024
-		raw = strings.TrimSuffix(raw, "MB")
-	case strings.HasSuffix(raw, "G"):
-		multiplier = (1000 * 1000 * 1000) / 8
-		raw = strings.TrimSuffix(raw, "G")
-	case strings.HasSuffix(raw, "GB"):
-		multiplier = 1024 * 1024 * 1024
-		raw = strings.TrimSuffix(raw, "GB")
-	default:
-		// Only accept numbers or uppercase suffix
-		if _, err := strconv.ParseInt(raw, 10, 64); err != nil {
-			return fmt.Errorf("invalid size string: %s (must end with 'K','M','G')", value.Value)
+	lower := strings.ToLower(raw)
+	for _, u := range sizeSuffixes {
+		if strings.HasSuffix(lower, u.suffix) {
+			multiplier = u.multiplier
+			raw = strings.TrimSpace(raw[:len(raw)-len(u.suffix)])
+			break
 		}
 	}
 	v, err := strconv.ParseInt(raw, 10, 64)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid size string: %s (must be a number of bytes, or end with K, M, G for bits or KB, MB, GB, Ki, Mi, Gi for bytes)", value.Value)
+	}
+	if v > math.MaxInt64/multiplier || v < math.MinInt64/multiplier {
+		return fmt.Errorf("invalid size string: %s (too large)", value.Value)
 	}
 	*s = SizeString(v * multiplier)
 	return nil
@@ -135,29 +202,150 @@ type SalmonBridgeConfig struct {
 	Name                 string         `yaml:"SBName"`
 	SocksListenPort      int            `yaml:"SBSocksListenPort"`
 	Connect              bool           `yaml:"SBConnect"`
-	StatusCheckFrequency DurationString `yaml:"SBStatusCheckFrequency"`
+	StatusCheckFrequency DurationString `yaml:"SBStatusCheckFrequency"` // near only, default "10s"
 	NearPort             int            `yaml:"SBNearPort,omitempty"`
 	FarPort              int            `yaml:"SBFarPort,omitempty"`
 	FarIp                string         `yaml:"SBFarIp"`
+	FarIps               []string       `yaml:"SBFarIps,omitempty"` // near only, far hosts in failover order
+
+	SocksListenAddress      string         `yaml:"SBSocksListenAddress,omitempty"`      // e.g. "127.0.0.1" or "unix:/run/salmon.sock"
+	HttpListenPort          int            `yaml:"SBHttpListenPort,omitempty"`          // optional HTTP proxy listen port (near only)
+	SocksListenInterface    string         `yaml:"SBSocksListenInterface,omitempty"`    // near only, Linux only, default ""
+	SocksListenNetwork      string         `yaml:"SBSocksListenNetwork,omitempty"`      // near only, "tcp", "tcp4" or "tcp6", default "tcp"
+	IdleTimeout             DurationString `yaml:"SBIdleTimeout,omitempty"`             // default "60s"
+	InitialPacketSize       int            `yaml:"SBInitialPacketSize,omitempty"`       // default 1350
+	TotalBandwidthLimit     SizeString     `yaml:"SBTotalBandwidthLimit,omitempty"`     // default "100M"
+	PerStreamBandwidthLimit SizeString     `yaml:"SBPerStreamBandwidthLimit,omitempty"` // default 0, unlimited
+	MaxRecieveBufferSize    SizeString     `yaml:"SBMaxRecieveBufferSize,omitempty"`    // default "400MB", min "7MB"
+	InterfaceName           string         `yaml:"SBInterfaceName,omitempty"`           // default ""
+	AllowedInAddresses      []string       `yaml:"SBAllowedInAddresses,omitempty"`      // default []
+	AllowedOutAddresses     []string       `yaml:"SBAllowedOutAddresses,omitempty"`     // default []
+	AllowedOutAddressTypes  []string       `yaml:"SBAllowedOutAddressTypes,omitempty"`  // far only, AddressType* values, default [] (all)
+	AllowedOutPorts         []int          `yaml:"SBAllowedOutPorts,omitempty"`         // far only, target ports, default [] (all)
+	DeniedOutPorts          []int          `yaml:"SBDeniedOutPorts,omitempty"`          // far only, target ports, wins over SBAllowedOutPorts, default []
+	SharedSecret            string         `yaml:"SBSharedSecret,omitempty"`            // optional AES key for encrypting traffic
+	CipherMode              string         `yaml:"SBCipherMode,omitempty"`              // "ctr" or "gcm", default "ctr"
+	Compression             string         `yaml:"SBCompression,omitempty"`             // near only, "none", "flate" or "snappy", default "none"
+	BindAddress             string         `yaml:"SBBindAddress,omitempty"`             // far only, IP to listen on for SOCKS BIND
+	FarEgressInterface      string         `yaml:"SBFarEgressInterface,omitempty"`      // far only, Linux only, default ""
+
+	Alpn               string `yaml:"SBAlpn,omitempty"`               // both sides must match, TLS ALPN of the QUIC tunnel, default "salmon-bridge"
+	FarCertFile        string `yaml:"SBFarCertFile,omitempty"`        // far only, PEM certificate for the QUIC listener
+	FarKeyFile         string `yaml:"SBFarKeyFile,omitempty"`         // far only, PEM key for SBFarCertFile
+	FarCertFingerprint string `yaml:"SBFarCertFingerprint,omitempty"` // near only, SHA-256 of the far certificate to pin
+	TlsCaFile          string `yaml:"SBTlsCaFile,omitempty"`          // near only, PEM CA bundle the far certificate must chain to
+	TlsServerName      string `yaml:"SBTlsServerName,omitempty"`      // near only, name expected in the far certificate, default the far host
+	Enable0RTT         bool   `yaml:"SBEnable0RTT,omitempty"`         // both sides, TLS session resumption with QUIC 0-RTT, default false
+
+	SocksUsers  map[string]string `yaml:"SBSocksUsers,omitempty"`  // username → bcrypt hash or plaintext password (near only)
+	AuthFile    string            `yaml:"SBAuthFile,omitempty"`    // near only, htpasswd-style user:password file, reread when it changes
+	AuthCommand string            `yaml:"SBAuthCommand,omitempty"` // near only, program given username and password on stdin, exit 0 accepts
+
+	FallbackDirect bool `yaml:"SBFallbackDirect,omitempty"` // near only, dial targets directly when the far is unreachable
+	RemoteDNS      bool `yaml:"SBRemoteDNS,omitempty"`      // near only, never resolve target hostnames on the near host, default false
+
+	RequireFarOnStart bool `yaml:"SBRequireFarOnStart,omitempty"` // near only, fail startup when the far is unreachable, default false
 
-	SocksListenAddress   string         `yaml:"SBSocksListenAddress,omitempty"`   // e.g. "127.0.0.1"
-	HttpListenPort       int            `yaml:"SBHttpListenPort,omitempty"`       // optional HTTP proxy listen port (near only)
-	IdleTimeout          DurationString `yaml:"SBIdleTimeout,omitempty"`          // default "10s"
-	InitialPacketSize    int            `yaml:"SBInitialPacketSize,omitempty"`    // default 1350
-	TotalBandwidthLimit  SizeString     `yaml:"SBTotalBandwidthLimit,omitempty"`  // default "100M"
-	MaxRecieveBufferSize SizeString     `yaml:"SBMaxRecieveBufferSize,omitempty"` // default "500MB"
-	InterfaceName        string         `yaml:"SBInterfaceName,omitempty"`        // default ""
-	AllowedInAddresses   []string       `yaml:"SBAllowedInAddresses,omitempty"`   // default []
-	AllowedOutAddresses  []string       `yaml:"SBAllowedOutAddresses,omitempty"`  // default []
-	SharedSecret         string         `yaml:"SBSharedSecret,omitempty"`         // optional AES key for encrypting traffic
+	DatagramMode      bool `yaml:"SBDatagramMode,omitempty"`      // both sides must match, carries SOCKS UDP ASSOCIATE, default false
+	SendProxyProtocol bool `yaml:"SBSendProxyProtocol,omitempty"` // both sides, far prefixes target connections with a PROXY v2 header, default false
+	MaxOutPerClient   int  `yaml:"SBMaxOutPerClient,omitempty"`   // both sides, far caps target connections per client, default 0 (unlimited)
+
+	ReconnectBackoffMin DurationString `yaml:"SBReconnectBackoffMin,omitempty"` // default "100ms"
+	ReconnectBackoffMax DurationString `yaml:"SBReconnectBackoffMax,omitempty"` // default "30s"
+
+	DialTimeout        DurationString `yaml:"SBDialTimeout,omitempty"`        // near only, default "10s"
+	StreamOpenTimeout  DurationString `yaml:"SBStreamOpenTimeout,omitempty"`  // near only, default "15s"
+	StreamOpenAttempts int            `yaml:"SBStreamOpenAttempts,omitempty"` // near only, connections tried per stream, default 3
+	HandshakeTimeout   DurationString `yaml:"SBHandshakeTimeout,omitempty"`   // near only, whole SOCKS handshake, default "10s"
+
+	// QUIC pool sizing, defaults come from QuicConfig
+	MaxConnectionsPerBridge int            `yaml:"SBMaxConnectionsPerBridge,omitempty"`
+	MaxStreamsPerConnection int            `yaml:"SBMaxStreamsPerConnection,omitempty"`
+	ConnectionIdleTimeout   DurationString `yaml:"SBConnectionIdleTimeout,omitempty"`
+
+	MaxConcurrentClients int     `yaml:"SBMaxConcurrentClients,omitempty"` // near only, default 0, unlimited
+	PerClientConnRate    float64 `yaml:"SBPerClientConnRate,omitempty"`    // near only, new connections/s per client IP, default 0, unlimited
+	PerClientConnBurst   int     `yaml:"SBPerClientConnBurst,omitempty"`   // near only, default SBPerClientConnRate rounded up
+
+	ShutdownGracePeriod DurationString `yaml:"SBShutdownGracePeriod,omitempty"` // default "10s"
+	StreamIdleTimeout   DurationString `yaml:"SBStreamIdleTimeout,omitempty"`   // default 0, disabled
+	RelayBufferSize     SizeString     `yaml:"SBRelayBufferSize,omitempty"`     // default "32KB"
+	CoalesceDelay       DurationString `yaml:"SBCoalesceDelay,omitempty"`       // both sides, batch small writes into a stream for up to this long, default 0, disabled
+
+	KeepaliveInterval DurationString `yaml:"SBKeepaliveInterval,omitempty"` // near only, default "15s"
+	KeepaliveFailures int            `yaml:"SBKeepaliveFailures,omitempty"` // near only, default 3
+
+	StreamQueueDepth   int            `yaml:"SBStreamQueueDepth,omitempty"`   // near only, streams waiting on a saturated pool, -1 disables, default 64
+	StreamQueueTimeout DurationString `yaml:"SBStreamQueueTimeout,omitempty"` // near only, how long each waits, default "5s"
+
+	DialFailureThreshold int            `yaml:"SBDialFailureThreshold,omitempty"` // far only, default 0, disabled
+	DialFailureWindow    DurationString `yaml:"SBDialFailureWindow,omitempty"`    // far only, default "30s"
+	DialFailureCooldown  DurationString `yaml:"SBDialFailureCooldown,omitempty"`  // far only, default "30s"
+
+	TargetPoolSize        int            `yaml:"SBTargetPoolSize,omitempty"`        // far only, idle target connections kept for reuse, default 0, disabled
+	TargetPoolIdleTimeout DurationString `yaml:"SBTargetPoolIdleTimeout,omitempty"` // far only, default "30s"
+
+	ListenSockets    int    `yaml:"SBListenSockets,omitempty"`    // far only, SO_REUSEPORT sockets on the QUIC port, default 1
+	FarListenNetwork string `yaml:"SBFarListenNetwork,omitempty"` // far only, "udp", "udp4" or "udp6", default "udp"
+
+	UDPReceiveBuffer SizeString `yaml:"SBUdpReceiveBuffer,omitempty"` // both sides, SO_RCVBUF of the QUIC sockets, default 0, left to the OS and quic-go
+	UDPSendBuffer    SizeString `yaml:"SBUdpSendBuffer,omitempty"`    // both sides, SO_SNDBUF of the QUIC sockets, default 0, left to the OS and quic-go
+
+	// Parsed forms of AllowedInAddresses / AllowedOutAddresses, built by LoadConfig
+	AllowedInFilter  *AddressFilter `yaml:"-"`
+	AllowedOutFilter *AddressFilter `yaml:"-"`
+}
+
+// DefaultAlpn is the TLS ALPN protocol bridges negotiate when SBAlpn is not
+// set. Both sides of a bridge must use the same one.
+const DefaultAlpn = "salmon-bridge"
+
+// Values for AllowedOutAddressTypes: how the client gave the target.
+const (
+	AddressTypeIPv4   = "ipv4"
+	AddressTypeIPv6   = "ipv6"
+	AddressTypeDomain = "domain"
+)
+
+// UnixSocketPrefix marks an SBSocksListenAddress as a UNIX socket path.
+const UnixSocketPrefix = "unix:"
+
+// SocksUnixPath returns the socket path when SBSocksListenAddress is given
+// as "unix:/path", and "" when the SOCKS listener is TCP.
+func (b *SalmonBridgeConfig) SocksUnixPath() string {
+	path, ok := strings.CutPrefix(b.SocksListenAddress, UnixSocketPrefix)
+	if !ok {
+		return ""
+	}
+	return path
+}
+
+// ParseAddressFilters builds AllowedInFilter and AllowedOutFilter from the
+// raw address lists. Entries may be IPs, CIDR ranges or hostnames.
+func (b *SalmonBridgeConfig) ParseAddressFilters() error {
+	in, err := ParseAddressFilter(b.AllowedInAddresses)
+	if err != nil {
+		return fmt.Errorf("bridge %s SBAllowedInAddresses: %w", b.Name, err)
+	}
+	out, err := ParseAddressFilter(b.AllowedOutAddresses)
+	if err != nil {
+		return fmt.Errorf("bridge %s SBAllowedOutAddresses: %w", b.Name, err)
+	}
+	b.AllowedInFilter = in
+	b.AllowedOutFilter = out
+	return nil
 }
 
-// SalmonBounceConfig holds config for UDP relay instances
+// SalmonBounceConfig holds config for UDP/TCP relay instances
 type SalmonBounceConfig struct {
 	Name        string            `yaml:"SBName"`
 	ListenAddr  string            `yaml:"SBListenAddr"`            // e.g. ":8080" or "0.0.0.0:8080"
-	RouteMap    map[string]string `yaml:"SBRouteMap"`              // client IP → backend address
+	RouteMap    map[string]string `yaml:"SBRouteMap"`              // client IP → "backend[=weight],..."
 	IdleTimeout DurationString    `yaml:"SBIdleTimeout,omitempty"` // session idle timeout, default 60s
+	Protocol    string            `yaml:"SBProtocol,omitempty"`    // "udp", "tcp" or "both", default "udp"
+
+	HealthCheckInterval DurationString `yaml:"SBHealthCheckInterval,omitempty"` // 0 disables backend health checks
+	HealthCheckTimeout  DurationString `yaml:"SBHealthCheckTimeout,omitempty"`  // default 2s
 }
 
 // Config holds all SalmonBridgeConfigs
@@ -168,6 +356,7 @@ type SalmonCannonConfig struct {
 	ApiConfig           *ApiConfig           `yaml:"ApiConfig,omitempty"`
 	SocksRedirectConfig *SocksRedirectConfig `yaml:"SocksRedirect,omitempty"`
 	QuicConfig          *QuicConfig          `yaml:"QuicConfig,omitempty"`
+	GlobalMetrics       *GlobalMetricsConfig `yaml:"GlobalMetrics,omitempty"`
 }
 
 // SetDefaults sets default values for optional fields
@@ -182,10 +371,19 @@ func (c *SalmonCannonConfig) SetDefaults() {
 			if b.NearPort == 0 {
 				c.Bridges[i].NearPort = b.FarPort
 			}
+			if b.StatusCheckFrequency == 0 {
+				c.Bridges[i].StatusCheckFrequency = DurationString(10 * time.Second)
+			}
+			if b.SocksListenNetwork == "" {
+				c.Bridges[i].SocksListenNetwork = "tcp"
+			}
 		} else {
 			if b.FarPort == 0 {
 				c.Bridges[i].FarPort = b.NearPort
 			}
+			if b.FarListenNetwork == "" {
+				c.Bridges[i].FarListenNetwork = "udp"
+			}
 		}
 
 		if b.IdleTimeout == 0 {
@@ -202,10 +400,68 @@ func (c *SalmonCannonConfig) SetDefaults() {
 		if len(b.InterfaceName) == 0 {
 			c.Bridges[i].InterfaceName = ""
 		}
+		if len(b.CipherMode) == 0 {
+			c.Bridges[i].CipherMode = "ctr"
+		}
+		if len(b.Compression) == 0 {
+			c.Bridges[i].Compression = "none"
+		}
+		if b.ReconnectBackoffMin == 0 {
+			c.Bridges[i].ReconnectBackoffMin = DurationString(100 * time.Millisecond)
+		}
+		if b.ReconnectBackoffMax == 0 {
+			c.Bridges[i].ReconnectBackoffMax = DurationString(30 * time.Second)
+		}
+		if b.Connect && len(b.FarIps) == 0 && b.FarIp != "" {
+			c.Bridges[i].FarIps = []string{b.FarIp}
+		}
+		if b.DialTimeout == 0 {
+			c.Bridges[i].DialTimeout = DurationString(10 * time.Second)
+		}
+		if b.StreamOpenTimeout == 0 {
+			c.Bridges[i].StreamOpenTimeout = DurationString(15 * time.Second)
+		}
+		if b.StreamOpenAttempts == 0 {
+			c.Bridges[i].StreamOpenAttempts = 3
+		}
+		if b.HandshakeTimeout == 0 {
+			c.Bridges[i].HandshakeTimeout = DurationString(10 * time.Second)
+		}
+		if b.Alpn == "" {
+			c.Bridges[i].Alpn = DefaultAlpn
+		}
+		if b.TargetPoolIdleTimeout == 0 {
+			c.Bridges[i].TargetPoolIdleTimeout = DurationString(30 * time.Second)
+		}
+		if b.ShutdownGracePeriod == 0 {
+			c.Bridges[i].ShutdownGracePeriod = DurationString(10 * time.Second)
+		}
+		if b.KeepaliveInterval == 0 {
+			c.Bridges[i].KeepaliveInterval = DurationString(15 * time.Second)
+		}
+		if b.PerClientConnRate > 0 && b.PerClientConnBurst == 0 {
+			c.Bridges[i].PerClientConnBurst = int(math.Ceil(b.PerClientConnRate))
+		}
+		if b.KeepaliveFailures == 0 {
+			c.Bridges[i].KeepaliveFailures = 3
+		}
+		if b.StreamQueueDepth == 0 {
+			c.Bridges[i].StreamQueueDepth = 64
+		}
+		if b.StreamQueueTimeout == 0 {
+			c.Bridges[i].StreamQueueTimeout = DurationString(5 * time.Second)
+		}
+		if b.DialFailureWindow == 0 {
+			c.Bridges[i].DialFailureWindow = DurationString(30 * time.Second)
+		}
+		if b.DialFailureCooldown == 0 {
+			c.Bridges[i].DialFailureCooldown = DurationString(30 * time.Second)
+		}
+		if b.RelayBufferSize == 0 {
+			c.Bridges[i].RelayBufferSize = SizeString(32 * 1024)
+		}
 		if b.MaxRecieveBufferSize == 0 {
 			c.Bridges[i].MaxRecieveBufferSize = SizeString(419430400) // 400MB
-		} else if b.MaxRecieveBufferSize <= 1024*1024*7 {
-			fmt.Errorf("MaxBufferSize is too low. Cannot be below 7MB.")
 		}
 	}
 
@@ -217,6 +473,12 @@ func (c *SalmonCannonConfig) SetDefaults() {
 		if b.RouteMap == nil {
 			c.Bounces[i].RouteMap = make(map[string]string)
 		}
+		if len(b.Protocol) == 0 {
+			c.Bounces[i].Protocol = "udp"
+		}
+		if b.HealthCheckTimeout == 0 {
+			c.Bounces[i].HealthCheckTimeout = DurationString(2 * time.Second)
+		}
 	}
 	if c.QuicConfig == nil {
 		c.QuicConfig = &QuicConfig{
@@ -235,6 +497,37 @@ func (c *SalmonCannonConfig) SetDefaults() {
 			c.QuicConfig.IdleCleanupTimeout = DurationString(5 * time.Minute)
 		}
 	}
+	// Per bridge pool sizing falls back to the global QuicConfig
+	for i, b := range c.Bridges {
+		if b.MaxConnectionsPerBridge == 0 {
+			c.Bridges[i].MaxConnectionsPerBridge = c.QuicConfig.MaxConnectionsPerBridge
+		}
+		if b.MaxStreamsPerConnection == 0 {
+			c.Bridges[i].MaxStreamsPerConnection = c.QuicConfig.MaxStreamsPerConnection
+		}
+		if b.ConnectionIdleTimeout == 0 {
+			c.Bridges[i].ConnectionIdleTimeout = c.QuicConfig.IdleCleanupTimeout
+		}
+	}
+	if c.ApiConfig != nil {
+		if c.ApiConfig.HistoryInterval == 0 {
+			c.ApiConfig.HistoryInterval = DurationString(5 * time.Second)
+		}
+		if c.ApiConfig.HistoryRetention == 0 {
+			c.ApiConfig.HistoryRetention = DurationString(time.Hour)
+		}
+		if c.ApiConfig.TLSMinVersion == "" {
+			c.ApiConfig.TLSMinVersion = TLSVersion12
+		}
+	}
+	if c.GlobalMetrics != nil {
+		if c.GlobalMetrics.Interval == 0 {
+			c.GlobalMetrics.Interval = DurationString(10 * time.Second)
+		}
+		if c.GlobalMetrics.Prefix == "" {
+			c.GlobalMetrics.Prefix = "salmoncannon"
+		}
+	}
 	// Set global log defaults if not provided
 	if c.GlobalLog == nil {
 		c.GlobalLog = &GlobalLogConfig{
@@ -243,6 +536,7 @@ func (c *SalmonCannonConfig) SetDefaults() {
 			MaxBackups: 1,
 			MaxAge:     1,
 			Compress:   false,
+			Format:     "text",
 		}
 	} else {
 		if c.GlobalLog.Filename == "" {
@@ -257,6 +551,9 @@ func (c *SalmonCannonConfig) SetDefaults() {
 		if c.GlobalLog.MaxAge == 0 {
 			c.GlobalLog.MaxAge = 28
 		}
+		if c.GlobalLog.Format == "" {
+			c.GlobalLog.Format = "text"
+		}
 		// Compress defaults to false, so no need to set
 	}
 
@@ -269,9 +566,26 @@ func LoadConfig(path string) (*SalmonCannonConfig, error) {
 		return nil, err
 	}
 	var cfg SalmonCannonConfig
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	if err := decodeConfig(data, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.finalize(); err != nil {
 		return nil, err
 	}
-	cfg.SetDefaults()
 	return &cfg, nil
 }
+
+// finalize fills in defaults, validates and parses the address filters of a
+// freshly read config.
+func (c *SalmonCannonConfig) finalize() error {
+	c.SetDefaults()
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	for i := range c.Bridges {
+		if err := c.Bridges[i].ParseAddressFilters(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// LoadConfigPath loads path with LoadConfigDir if it is a directory and
+// LoadConfig otherwise.
+func LoadConfigPath(path string) (*SalmonCannonConfig, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return LoadConfigDir(path)
+	}
+	return LoadConfig(path)
+}
+
+// LoadConfigDir loads every *.yml and *.yaml file in dir, in name order, and
+// merges them into one config. Bridges and bounces from all files are
+// concatenated; GlobalLog, ApiConfig, SocksRedirect, QuicConfig and
+// GlobalMetrics may each be set in only one file, normally a base file such
+// as 00-base.yml. A bridge name used by two files for the same side is an
+// error.
+func LoadConfigDir(dir string) (*SalmonCannonConfig, error) {
+	var files []string
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	slices.Sort(files)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *.yml files in %s", dir)
+	}
+
+	// A near and a far may share a name, as in a single file
+	type bridgeKey struct {
+		name    string
+		connect bool
+	}
+	bridgeFiles := make(map[bridgeKey]string)
+	globalFiles := make(map[string]string)
+
+	var merged SalmonCannonConfig
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var cfg SalmonCannonConfig
+		if err := decodeConfig(data, &cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		for _, b := range cfg.Bridges {
+			key := bridgeKey{b.Name, b.Connect}
+			if other, ok := bridgeFiles[key]; ok {
+				return nil, fmt.Errorf("bridge %q is defined in both %s and %s", b.Name, other, file)
+			}
+			bridgeFiles[key] = file
+		}
+		merged.Bridges = append(merged.Bridges, cfg.Bridges...)
+		merged.Bounces = append(merged.Bounces, cfg.Bounces...)
+
+		for _, g := range []struct {
+			section string
+			set     bool
+			apply   func()
+		}{
+			{"GlobalLog", cfg.GlobalLog != nil, func() { merged.GlobalLog = cfg.GlobalLog }},
+			{"ApiConfig", cfg.ApiConfig != nil, func() { merged.ApiConfig = cfg.ApiConfig }},
+			{"SocksRedirect", cfg.SocksRedirectConfig != nil, func() { merged.SocksRedirectConfig = cfg.SocksRedirectConfig }},
+			{"QuicConfig", cfg.QuicConfig != nil, func() { merged.QuicConfig = cfg.QuicConfig }},
+			{"GlobalMetrics", cfg.GlobalMetrics != nil, func() { merged.GlobalMetrics = cfg.GlobalMetrics }},
+		} {
+			if !g.set {
+				continue
+			}
+			if other, ok := globalFiles[g.section]; ok {
+				return nil, fmt.Errorf("%s is set in both %s and %s", g.section, other, file)
+			}
+			globalFiles[g.section] = file
+			g.apply()
+		}
+	}
+
+	if err := merged.finalize(); err != nil {
+		return nil, err
+	}
+	return &merged, nil
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConfigDir_Merges(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"00-base.yml": `
+ApiConfig:
+  Hostname: 127.0.0.1
+  Port: 8080
+SalmonBridges:
+  - SBName: one
+    SBSocksListenPort: 1080
+    SBConnect: true
+    SBFarIp: 10.0.0.1
+    SBFarPort: 1111
+`,
+		"10-more.yaml": `
+SalmonBridges:
+  - SBName: two
+    SBSocksListenPort: 1081
+    SBConnect: true
+    SBFarIp: 10.0.0.2
+    SBFarPort: 1111
+  - SBName: one
+    SBNearPort: 1111
+`,
+		"notes.txt": "not yaml at all: [",
+	})
+
+	cfg, err := LoadConfigDir(dir)
+	if err != nil {
+		t.Fatalf("failed to load dir: %v", err)
+	}
+	if len(cfg.Bridges) != 3 || cfg.Bridges[0].Name != "one" || cfg.Bridges[1].Name != "two" {
+		t.Fatalf("expected bridges from both files in order, got %+v", cfg.Bridges)
+	}
+	if cfg.ApiConfig == nil || cfg.ApiConfig.Port != 8080 {
+		t.Fatalf("expected the base file's ApiConfig, got %+v", cfg.ApiConfig)
+	}
+	// Defaults are applied after the merge
+	if cfg.Bridges[1].SocksListenAddress != "127.0.0.1" || cfg.Bridges[1].AllowedInFilter == nil {
+		t.Errorf("expected defaults on merged bridges, got %+v", cfg.Bridges[1])
+	}
+	if cfg.QuicConfig == nil || cfg.Bridges[2].MaxStreamsPerConnection != cfg.QuicConfig.MaxStreamsPerConnection {
+		t.Errorf("expected QuicConfig defaults on merged bridges")
+	}
+
+	// LoadConfigPath picks the directory loader
+	if byPath, err := LoadConfigPath(dir); err != nil || len(byPath.Bridges) != 3 {
+		t.Fatalf("expected LoadConfigPath to load the directory, got %v", err)
+	}
+}
+
+func TestLoadConfigDir_Errors(t *testing.T) {
+	bridge := func(name string, port int) string {
+		return fmt.Sprintf("SalmonBridges:\n  - SBName: %s\n    SBSocksListenPort: %d\n"+
+			"    SBConnect: true\n    SBFarIp: 10.0.0.1\n    SBFarPort: 1111\n", name, port)
+	}
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"duplicate bridge", map[string]string{"a.yml": bridge("one", 1080), "b.yml": bridge("one", 1081)},
+			`bridge "one" is defined in both`},
+		{"global twice", map[string]string{"a.yml": "GlobalLog:\n  Format: json\n", "b.yml": "GlobalLog:\n  Format: text\n"},
+			"GlobalLog is set in both"},
+		{"bad yaml", map[string]string{"a.yml": "SalmonBridges: ["}, "a.yml"},
+		{"empty", map[string]string{"readme.md": "nothing"}, "no *.yml files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigDir(writeConfigFiles(t, tt.files))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
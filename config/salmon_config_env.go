@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envRef matches ${VAR} and ${VAR:-fallback}.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// decodeConfig unmarshals a YAML config into cfg, expanding ${VAR} and
+// ${VAR:-fallback} references in values from the environment first.
+func decodeConfig(data []byte, cfg *SalmonCannonConfig) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	if err := expandEnvNode(&root); err != nil {
+		return err
+	}
+	if root.Kind == 0 {
+		return nil // empty document
+	}
+	return root.Decode(cfg)
+}
+
+// expandEnvNode expands environment references in every scalar under n.
+// Expanding values rather than the raw file keeps comments out of it and
+// stops a value containing YAML syntax from changing the document.
+func expandEnvNode(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode && strings.Contains(n.Value, "${") {
+		value, err := expandEnv(n.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		n.Value = value
+		// Unquoted values are typed by what they expand to, so a port
+		// given as ${PORT} is still an int
+		if n.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			n.Tag = plainScalarTag(value)
+		}
+	}
+	for _, c := range n.Content {
+		if err := expandEnvNode(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandEnv replaces ${VAR} with the variable's value and ${VAR:-fallback}
+// with the value, or fallback when VAR is unset or empty. A ${VAR} that is
+// not set is an error.
+func expandEnv(s string) (string, error) {
+	var missing []string
+	out := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		m := envRef.FindStringSubmatch(ref)
+		value, ok := os.LookupEnv(m[1])
+		if m[2] != "" {
+			if value == "" {
+				return m[3]
+			}
+			return value
+		}
+		if !ok {
+			missing = append(missing, m[1])
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// plainScalarTag is the tag YAML would give value written unquoted, for
+// the types the config uses.
+func plainScalarTag(value string) string {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return "!!int"
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return "!!float"
+	}
+	if value == "true" || value == "false" {
+		return "!!bool"
+	}
+	return "!!str"
+}
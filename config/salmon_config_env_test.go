@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("SC_TEST_SET", "value")
+	t.Setenv("SC_TEST_EMPTY", "")
+	os.Unsetenv("SC_TEST_UNSET")
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{"plain", "plain", ""},
+		{"${SC_TEST_SET}", "value", ""},
+		{"a-${SC_TEST_SET}-b", "a-value-b", ""},
+		{"${SC_TEST_UNSET:-fallback}", "fallback", ""},
+		{"${SC_TEST_EMPTY:-fallback}", "fallback", ""},
+		{"${SC_TEST_SET:-fallback}", "value", ""},
+		{"${SC_TEST_UNSET:-}", "", ""},
+		{"${SC_TEST_EMPTY}", "", ""},
+		{"$SC_TEST_SET and $", "$SC_TEST_SET and $", ""},
+		{"${SC_TEST_UNSET}", "", "SC_TEST_UNSET is not set"},
+	}
+	for _, tt := range tests {
+		got, err := expandEnv(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expandEnv(%q): expected error %q, got %v", tt.in, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("expandEnv(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadConfig_ExpandsEnv(t *testing.T) {
+	// Characters that would break the YAML if substituted into the raw file
+	t.Setenv("SC_TEST_SECRET", "s3cret: #not-a-comment")
+	t.Setenv("SC_TEST_PORT", "1090")
+	os.Unsetenv("SC_TEST_UNSET")
+
+	path := filepath.Join(t.TempDir(), "scconfig.yml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+	}
+	write(`
+# ${SC_TEST_UNSET} in a comment is left alone
+SalmonBridges:
+  - SBName: env
+    SBSocksListenPort: ${SC_TEST_PORT}
+    SBConnect: true
+    SBFarIp: ${SC_TEST_UNSET:-10.0.0.1}
+    SBFarPort: 1111
+    SBSharedSecret: "${SC_TEST_SECRET}"
+    SBIdleTimeout: ${SC_TEST_UNSET:-30s}
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	b := cfg.Bridges[0]
+	if b.SocksListenPort != 1090 || b.FarIp != "10.0.0.1" || b.SharedSecret != "s3cret: #not-a-comment" || b.IdleTimeout.Duration().Seconds() != 30 {
+		t.Fatalf("unexpected expansion: port %d far %q secret %q idle %v", b.SocksListenPort, b.FarIp, b.SharedSecret, b.IdleTimeout.Duration())
+	}
+
+	write("SalmonBridges:\n  - SBName: env\n    SBSharedSecret: ${SC_TEST_UNSET}\n")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "SC_TEST_UNSET is not set") {
+		t.Fatalf("expected an unset variable error, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -19,7 +20,10 @@ func TestDurationString_UnmarshalYAML(t *testing.T) {
 		{"5m", 5 * time.Minute, false},
 		{"15", 15 * time.Second, false}, // int tag
 		{"bad", 0, true},
-		{"10h", 0, true},
+		{"10h", 10 * time.Hour, false},
+		{"2d", 48 * time.Hour, false},
+		{"1.5d", 36 * time.Hour, false},
+		{"xd", 0, true},
 	}
 	for _, c := range cases {
 		var node yaml.Node
@@ -50,8 +54,22 @@ func TestSizeString_UnmarshalYAML(t *testing.T) {
 		{"1GB", 1 << 30, false},
 		{"100", 100, false},
 		{"bad", 0, true},
-		{"10k", 0, true}, // lowercase not allowed
 		{"50MB", 52428800, false},
+		{"10k", 1000 * 10 / 8, false},
+		{"10kb", 1024 * 10, false},
+		{"100m", 100 * 1000 * 1000 / 8, false},
+		{"2Mb", 2 << 20, false},
+		{"1g", 1000 * 1000 * 1000 / 8, false},
+		{"1gb", 1 << 30, false},
+		{"64Ki", 64 << 10, false},
+		{"64KiB", 64 << 10, false},
+		{"8Mi", 8 << 20, false},
+		{"8mib", 8 << 20, false},
+		{"2Gi", 2 << 30, false},
+		{"500 MB", 500 << 20, false},
+		{"10T", 0, true},
+		{"MB", 0, true},
+		{"9999999999GB", 0, true},
 	}
 	for _, c := range cases {
 		var node yaml.Node
@@ -87,6 +105,102 @@ func TestSetDefaults(t *testing.T) {
 	if b.MaxRecieveBufferSize != SizeString(419430400) {
 		t.Errorf("MaxRecieveBufferSize default not set to expected value, got %d", b.MaxRecieveBufferSize)
 	}
+	if b.RelayBufferSize != SizeString(32*1024) {
+		t.Errorf("RelayBufferSize default not set, got %d", b.RelayBufferSize)
+	}
+	if b.CipherMode != "ctr" {
+		t.Errorf("CipherMode default not set, got %q", b.CipherMode)
+	}
+	if b.Compression != "none" {
+		t.Errorf("Compression default not set, got %q", b.Compression)
+	}
+	if b.ReconnectBackoffMin != DurationString(100*time.Millisecond) {
+		t.Errorf("ReconnectBackoffMin default not set, got %v", b.ReconnectBackoffMin.Duration())
+	}
+	if b.ReconnectBackoffMax != DurationString(30*time.Second) {
+		t.Errorf("ReconnectBackoffMax default not set, got %v", b.ReconnectBackoffMax.Duration())
+	}
+	if b.MaxConnectionsPerBridge != 1 || b.MaxStreamsPerConnection != 500 {
+		t.Errorf("pool sizing defaults not taken from QuicConfig, got %d/%d", b.MaxConnectionsPerBridge, b.MaxStreamsPerConnection)
+	}
+	if b.ConnectionIdleTimeout != DurationString(5*time.Minute) {
+		t.Errorf("ConnectionIdleTimeout default not set, got %v", b.ConnectionIdleTimeout.Duration())
+	}
+	if b.ShutdownGracePeriod != DurationString(10*time.Second) {
+		t.Errorf("ShutdownGracePeriod default not set, got %v", b.ShutdownGracePeriod.Duration())
+	}
+	if b.DialTimeout != DurationString(10*time.Second) || b.StreamOpenTimeout != DurationString(15*time.Second) {
+		t.Errorf("timeout defaults not set, got %v/%v", b.DialTimeout.Duration(), b.StreamOpenTimeout.Duration())
+	}
+	if b.HandshakeTimeout != DurationString(10*time.Second) {
+		t.Errorf("HandshakeTimeout default not set, got %v", b.HandshakeTimeout.Duration())
+	}
+	if b.Alpn != DefaultAlpn {
+		t.Errorf("Alpn default not set, got %q", b.Alpn)
+	}
+	if b.KeepaliveInterval != DurationString(15*time.Second) || b.KeepaliveFailures != 3 {
+		t.Errorf("keepalive defaults not set, got %v/%d", b.KeepaliveInterval.Duration(), b.KeepaliveFailures)
+	}
+	if b.DialFailureThreshold != 0 || b.DialFailureWindow != DurationString(30*time.Second) || b.DialFailureCooldown != DurationString(30*time.Second) {
+		t.Errorf("dial breaker defaults not set, got %d/%v/%v", b.DialFailureThreshold, b.DialFailureWindow.Duration(), b.DialFailureCooldown.Duration())
+	}
+}
+
+func TestSetDefaults_StatusCheckFrequency(t *testing.T) {
+	cfg := SalmonCannonConfig{
+		Bridges: []SalmonBridgeConfig{{Connect: true}, {Connect: true, StatusCheckFrequency: DurationString(time.Second)}, {}},
+	}
+	cfg.SetDefaults()
+	if got := cfg.Bridges[0].StatusCheckFrequency; got != DurationString(10*time.Second) {
+		t.Errorf("expected near status checks every 10s by default, got %v", got.Duration())
+	}
+	if got := cfg.Bridges[1].StatusCheckFrequency; got != DurationString(time.Second) {
+		t.Errorf("expected the configured frequency to be kept, got %v", got.Duration())
+	}
+	if got := cfg.Bridges[2].StatusCheckFrequency; got != 0 {
+		t.Errorf("expected no status checks on a far, got %v", got.Duration())
+	}
+}
+
+func TestSetDefaults_PerBridgePoolSizing(t *testing.T) {
+	cfg := SalmonCannonConfig{
+		Bridges: []SalmonBridgeConfig{
+			{Name: "inherits"},
+			{Name: "tuned", MaxConnectionsPerBridge: 4, MaxStreamsPerConnection: 20, ConnectionIdleTimeout: DurationString(time.Minute)},
+		},
+		QuicConfig: &QuicConfig{MaxConnectionsPerBridge: 2, MaxStreamsPerConnection: 300},
+	}
+	cfg.SetDefaults()
+
+	inherits := cfg.Bridges[0]
+	if inherits.MaxConnectionsPerBridge != 2 || inherits.MaxStreamsPerConnection != 300 {
+		t.Errorf("expected bridge to inherit QuicConfig sizing, got %d/%d", inherits.MaxConnectionsPerBridge, inherits.MaxStreamsPerConnection)
+	}
+	if inherits.ConnectionIdleTimeout != DurationString(5*time.Minute) {
+		t.Errorf("expected bridge to inherit idle timeout, got %v", inherits.ConnectionIdleTimeout.Duration())
+	}
+
+	tuned := cfg.Bridges[1]
+	if tuned.MaxConnectionsPerBridge != 4 || tuned.MaxStreamsPerConnection != 20 || tuned.ConnectionIdleTimeout != DurationString(time.Minute) {
+		t.Errorf("expected per bridge sizing to be kept, got %+v", tuned)
+	}
+}
+
+func TestSetDefaults_PerClientConnBurst(t *testing.T) {
+	cfg := SalmonCannonConfig{
+		Bridges: []SalmonBridgeConfig{
+			{Name: "off"},
+			{Name: "rate", PerClientConnRate: 2.5},
+			{Name: "burst", PerClientConnRate: 2.5, PerClientConnBurst: 10},
+		},
+	}
+	cfg.SetDefaults()
+
+	for i, want := range []int{0, 3, 10} {
+		if got := cfg.Bridges[i].PerClientConnBurst; got != want {
+			t.Errorf("bridge %s: expected burst %d, got %d", cfg.Bridges[i].Name, want, got)
+		}
+	}
 }
 
 func TestLoadConfig(t *testing.T) {
@@ -129,6 +243,9 @@ func TestLoadConfig(t *testing.T) {
 	if b.Name != "test" || b.SocksListenPort != 1080 || b.Connect != true || b.FarPort != 1100 || b.FarIp != "127.0.0.1" {
 		t.Errorf("bridge fields not parsed correctly: %+v", b)
 	}
+	if len(b.FarIps) != 1 || b.FarIps[0] != "127.0.0.1" {
+		t.Errorf("expected FarIps to default to FarIp, got %v", b.FarIps)
+	}
 	if b.IdleTimeout != DurationString(15*time.Second) {
 		t.Errorf("IdleTimeout not parsed correctly")
 	}
@@ -173,6 +290,9 @@ func TestGlobalLogConfig_Defaults(t *testing.T) {
 	if cfg.GlobalLog.Compress != false {
 		t.Errorf("Compress default not set, got %v", cfg.GlobalLog.Compress)
 	}
+	if cfg.GlobalLog.Format != "text" {
+		t.Errorf("Format default not set, got %q", cfg.GlobalLog.Format)
+	}
 }
 
 func TestGlobalLogConfig_ParseYAML(t *testing.T) {
@@ -229,6 +349,32 @@ SalmonBridges:
 	}
 }
 
+func TestGlobalLogConfig_Format(t *testing.T) {
+	for format, wantErr := range map[string]bool{"json": false, "text": false, "xml": true} {
+		f, err := os.CreateTemp("", "salmon_config_test.yaml")
+		if err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString("GlobalLog:\n  Format: \"" + format + "\"\n")
+		f.Close()
+
+		cfg, err := LoadConfig(f.Name())
+		if wantErr {
+			if err == nil {
+				t.Errorf("expected format %q to be rejected", format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("LoadConfig failed for %q: %v", format, err)
+		}
+		if cfg.GlobalLog.Format != format {
+			t.Errorf("Format not parsed correctly, got %q", cfg.GlobalLog.Format)
+		}
+	}
+}
+
 func TestApiConfig_ParseYAML(t *testing.T) {
 	yamlData := `ApiConfig:
   Hostname: "localhost"
@@ -255,6 +401,26 @@ func TestApiConfig_ParseYAML(t *testing.T) {
 	if cfg.ApiConfig.Port != 8080 {
 		t.Errorf("Port not parsed correctly, got %d", cfg.ApiConfig.Port)
 	}
+	if cfg.ApiConfig.HistoryInterval.Duration() != 5*time.Second {
+		t.Errorf("HistoryInterval default not set, got %v", cfg.ApiConfig.HistoryInterval.Duration())
+	}
+	if cfg.ApiConfig.HistoryRetention.Duration() != time.Hour {
+		t.Errorf("HistoryRetention default not set, got %v", cfg.ApiConfig.HistoryRetention.Duration())
+	}
+	if got := cfg.ApiConfig.HistorySamples(); got != 720 {
+		t.Errorf("expected 720 history samples, got %d", got)
+	}
+}
+
+func TestApiConfig_ListenAddr(t *testing.T) {
+	a := &ApiConfig{Hostname: "::1", Port: 8080}
+	if got := a.ListenAddr(); got != "[::1]:8080" {
+		t.Errorf("expected [::1]:8080, got %q", got)
+	}
+	a.Socket = "/run/salmoncannon/api.sock"
+	if got := a.ListenAddr(); got != "unix:/run/salmoncannon/api.sock" {
+		t.Errorf("expected the socket to win over Hostname and Port, got %q", got)
+	}
 }
 
 func TestSocksRedirectConfig_ParseYAML(t *testing.T) {
@@ -305,6 +471,7 @@ func TestSalmonBounceConfig_ParseYAML(t *testing.T) {
       "192.168.1.1": "backend1:9090"
       "192.168.1.2": "backend2:9091"
     SBIdleTimeout: "30s"
+    SBProtocol: "both"
   - SBName: "bounce-two"
     SBListenAddr: ":8081"
     SBRouteMap:
@@ -343,6 +510,9 @@ func TestSalmonBounceConfig_ParseYAML(t *testing.T) {
 	if b1.IdleTimeout != DurationString(30*time.Second) {
 		t.Errorf("bounce 1: expected IdleTimeout 30s, got %v", b1.IdleTimeout)
 	}
+	if b1.Protocol != "both" {
+		t.Errorf("bounce 1: expected Protocol 'both', got %q", b1.Protocol)
+	}
 
 	// Check second bounce (should have default idle timeout)
 	b2 := cfg.Bounces[1]
@@ -358,6 +528,9 @@ func TestSalmonBounceConfig_ParseYAML(t *testing.T) {
 	if b2.IdleTimeout != DurationString(60*time.Second) {
 		t.Errorf("bounce 2: expected default IdleTimeout 60s, got %v", b2.IdleTimeout)
 	}
+	if b2.Protocol != "udp" {
+		t.Errorf("bounce 2: expected default Protocol 'udp', got %q", b2.Protocol)
+	}
 }
 
 func TestQuicConfig_SetDefaults(t *testing.T) {
@@ -532,3 +705,30 @@ IdleCleanupTimeout: not-a-duration
 		})
 	}
 }
+
+func TestLoadConfig_StreamQueue(t *testing.T) {
+	for depth, wantErr := range map[string]bool{"-2": true, "10001": true, "-1": false, "100": false} {
+		f, err := os.CreateTemp("", "salmon_config_test.yaml")
+		if err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString("SalmonBridges:\n  - SBName: queue\n    SBStreamQueueDepth: " + depth + "\n")
+		f.Close()
+
+		_, err = LoadConfig(f.Name())
+		if wantErr && (err == nil || !strings.Contains(err.Error(), "SBStreamQueueDepth")) {
+			t.Errorf("expected depth %s to be rejected, got %v", depth, err)
+		}
+		if !wantErr && err != nil {
+			t.Errorf("expected depth %s to load, got %v", depth, err)
+		}
+	}
+
+	cfg := SalmonCannonConfig{Bridges: []SalmonBridgeConfig{{Name: "queue"}}}
+	cfg.SetDefaults()
+	if cfg.Bridges[0].StreamQueueDepth != 64 || cfg.Bridges[0].StreamQueueTimeout != DurationString(5*time.Second) {
+		t.Fatalf("expected a 64 deep, 5s queue by default, got %d and %v",
+			cfg.Bridges[0].StreamQueueDepth, cfg.Bridges[0].StreamQueueTimeout.Duration())
+	}
+}
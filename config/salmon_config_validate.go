@@ -0,0 +1,262 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Smallest MaxRecieveBufferSize quic-go can work with.
+const minRecieveBufferSize = 7 * 1024 * 1024
+
+// Bounds for UDPReceiveBuffer and UDPSendBuffer. quic-go already asks for
+// 7MB itself, and Linux takes at most half of INT_MAX.
+const (
+	minUDPBufferSize = 7 * 1024 * 1024
+	maxUDPBufferSize = 1024 * 1024 * 1024
+)
+
+// Bounds for RelayBufferSize; smaller buffers cost a syscall per few
+// packets, larger ones pin memory for every idle relay.
+const (
+	minRelayBufferSize = 1024
+	maxRelayBufferSize = 16 * 1024 * 1024
+)
+
+// Longest SBCoalesceDelay, matching bridge.MaxCoalesceDelay.
+const maxCoalesceDelay = 100 * time.Millisecond
+
+// Most connections a near tries per stream, so a pool of dead connections
+// cannot stall a client for long.
+const maxStreamOpenAttempts = 10
+
+// Most streams a near queues on a saturated pool. Each holds a client
+// connection open while it waits.
+const maxStreamQueueDepth = 10000
+
+// goos is runtime.GOOS, swappable in tests.
+var goos = runtime.GOOS
+
+// Validate checks a config after SetDefaults and returns every problem it
+// finds joined into one error, or nil.
+func (c *SalmonCannonConfig) Validate() error {
+	var errs []error
+	addErr := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.GlobalLog != nil {
+		if f := c.GlobalLog.Format; f != "text" && f != "json" {
+			addErr("GlobalLog.Format must be \"text\" or \"json\", got %q", f)
+		}
+	}
+
+	if c.ApiConfig != nil {
+		if _, err := c.ApiConfig.TLSConfig(); err != nil {
+			addErr("ApiConfig.%v", err)
+		}
+	}
+
+	if m := c.GlobalMetrics; m != nil {
+		if _, port, err := net.SplitHostPort(m.Endpoint); err != nil || port == "" {
+			addErr("GlobalMetrics.Endpoint must be host:port, got %q", m.Endpoint)
+		}
+		if m.Interval < 0 {
+			addErr("GlobalMetrics.Interval must be positive, got %v", m.Interval.Duration())
+		}
+	}
+
+	if c.SocksRedirectConfig != nil {
+		for _, pattern := range slices.Sorted(maps.Keys(c.SocksRedirectConfig.Redirects)) {
+			if err := validRedirectPattern(pattern); err != nil {
+				addErr("SocksRedirect.Redirects key %q: %v", pattern, err)
+			}
+		}
+	}
+
+	// A near and a far may share a name, two of the same kind may not
+	type bridgeKey struct {
+		name    string
+		connect bool
+	}
+	names := make(map[bridgeKey]bool)
+	var listeners []listenerAddr
+	unixPaths := make(map[string]string) // socket path -> bridge
+
+	for _, b := range c.Bridges {
+		key := bridgeKey{b.Name, b.Connect}
+		if names[key] {
+			addErr("bridge %q: duplicate SBName", b.Name)
+		}
+		names[key] = true
+
+		if b.MaxRecieveBufferSize > 0 && b.MaxRecieveBufferSize < minRecieveBufferSize {
+			addErr("bridge %q: SBMaxRecieveBufferSize %d is below the 7MB minimum", b.Name, int64(b.MaxRecieveBufferSize))
+		}
+		if b.RelayBufferSize < minRelayBufferSize || b.RelayBufferSize > maxRelayBufferSize {
+			addErr("bridge %q: SBRelayBufferSize %d must be between 1KB and 16MB", b.Name, int64(b.RelayBufferSize))
+		}
+		if b.UDPReceiveBuffer != 0 && (b.UDPReceiveBuffer < minUDPBufferSize || b.UDPReceiveBuffer > maxUDPBufferSize) {
+			addErr("bridge %q: SBUdpReceiveBuffer %d must be between 7MB and 1GB", b.Name, int64(b.UDPReceiveBuffer))
+		}
+		if b.UDPSendBuffer != 0 && (b.UDPSendBuffer < minUDPBufferSize || b.UDPSendBuffer > maxUDPBufferSize) {
+			addErr("bridge %q: SBUdpSendBuffer %d must be between 7MB and 1GB", b.Name, int64(b.UDPSendBuffer))
+		}
+		if b.MaxOutPerClient < 0 {
+			addErr("bridge %q: SBMaxOutPerClient %d must not be negative", b.Name, b.MaxOutPerClient)
+		}
+		if b.StreamOpenAttempts < 1 || b.StreamOpenAttempts > maxStreamOpenAttempts {
+			addErr("bridge %q: SBStreamOpenAttempts %d must be between 1 and %d", b.Name, b.StreamOpenAttempts, maxStreamOpenAttempts)
+		}
+		if b.StreamQueueDepth < -1 || b.StreamQueueDepth > maxStreamQueueDepth {
+			addErr("bridge %q: SBStreamQueueDepth %d must be between -1 and %d", b.Name, b.StreamQueueDepth, maxStreamQueueDepth)
+		}
+		if b.StreamQueueTimeout < 0 {
+			addErr("bridge %q: SBStreamQueueTimeout %v must not be negative", b.Name, b.StreamQueueTimeout.Duration())
+		}
+		if b.CoalesceDelay < 0 || b.CoalesceDelay.Duration() > maxCoalesceDelay {
+			addErr("bridge %q: SBCoalesceDelay %v must be between 0 and %v", b.Name, b.CoalesceDelay.Duration(), maxCoalesceDelay)
+		}
+		if len(b.Alpn) > 255 {
+			addErr("bridge %q: SBAlpn must be at most 255 bytes", b.Name)
+		}
+		if b.ListenSockets < 0 {
+			addErr("bridge %q: SBListenSockets %d must not be negative", b.Name, b.ListenSockets)
+		}
+		if b.TargetPoolSize < 0 {
+			addErr("bridge %q: SBTargetPoolSize %d must not be negative", b.Name, b.TargetPoolSize)
+		}
+		if b.PerStreamBandwidthLimit < 0 {
+			addErr("bridge %q: SBPerStreamBandwidthLimit %d must not be negative", b.Name, int64(b.PerStreamBandwidthLimit))
+		}
+		if b.MaxConcurrentClients < 0 {
+			addErr("bridge %q: SBMaxConcurrentClients %d must not be negative", b.Name, b.MaxConcurrentClients)
+		}
+		if b.PerClientConnRate < 0 {
+			addErr("bridge %q: SBPerClientConnRate %g must not be negative", b.Name, b.PerClientConnRate)
+		}
+		if b.PerClientConnBurst < 0 {
+			addErr("bridge %q: SBPerClientConnBurst %d must not be negative", b.Name, b.PerClientConnBurst)
+		}
+		if b.InterfaceName != "" && goos != "linux" {
+			addErr("bridge %q: SBInterfaceName is only supported on Linux", b.Name)
+		}
+		if b.FarEgressInterface != "" && goos != "linux" {
+			addErr("bridge %q: SBFarEgressInterface is only supported on Linux", b.Name)
+		}
+		if _, err := ParseAddressFilter(b.AllowedOutAddresses); err != nil {
+			addErr("bridge %q: SBAllowedOutAddresses: %v", b.Name, err)
+		}
+		if in, err := ParseAddressFilter(b.AllowedInAddresses); err != nil {
+			addErr("bridge %q: SBAllowedInAddresses: %v", b.Name, err)
+		} else if in.HasPatterns() {
+			// Clients are always IPs, a hostname pattern would never match
+			addErr("bridge %q: SBAllowedInAddresses cannot have %q entries, they only match hostnames", b.Name, AddressRegexPrefix)
+		}
+		for _, t := range b.AllowedOutAddressTypes {
+			if t != AddressTypeIPv4 && t != AddressTypeIPv6 && t != AddressTypeDomain {
+				addErr("bridge %q: SBAllowedOutAddressTypes entry %q must be %q, %q or %q", b.Name, t, AddressTypeIPv4, AddressTypeIPv6, AddressTypeDomain)
+			}
+		}
+		for _, p := range b.AllowedOutPorts {
+			if p < 1 || p > 65535 {
+				addErr("bridge %q: SBAllowedOutPorts entry %d must be between 1 and 65535", b.Name, p)
+			}
+		}
+		for _, p := range b.DeniedOutPorts {
+			if p < 1 || p > 65535 {
+				addErr("bridge %q: SBDeniedOutPorts entry %d must be between 1 and 65535", b.Name, p)
+			}
+		}
+		if b.FarListenNetwork != "" && !slices.Contains([]string{"udp", "udp4", "udp6"}, b.FarListenNetwork) {
+			addErr("bridge %q: SBFarListenNetwork %q must be \"udp\", \"udp4\" or \"udp6\"", b.Name, b.FarListenNetwork)
+		}
+		if b.SocksListenNetwork != "" && !slices.Contains([]string{"tcp", "tcp4", "tcp6"}, b.SocksListenNetwork) {
+			addErr("bridge %q: SBSocksListenNetwork %q must be \"tcp\", \"tcp4\" or \"tcp6\"", b.Name, b.SocksListenNetwork)
+		}
+		if !b.Connect {
+			continue
+		}
+
+		if b.FarIp == "" && len(b.FarIps) == 0 {
+			addErr("bridge %q: SBFarIp must be set when SBConnect is true", b.Name)
+		}
+		if b.AuthCommand != "" && strings.TrimSpace(b.AuthCommand) == "" {
+			addErr("bridge %q: SBAuthCommand needs a program to run", b.Name)
+		}
+		if strings.HasPrefix(b.SocksListenAddress, UnixSocketPrefix) {
+			path := b.SocksUnixPath()
+			switch {
+			case path == "":
+				addErr("bridge %q: SBSocksListenAddress %q needs a socket path", b.Name, b.SocksListenAddress)
+			case unixPaths[path] != "":
+				addErr("bridge %q: SBSocksListenAddress %q is already used by bridge %q", b.Name, b.SocksListenAddress, unixPaths[path])
+			default:
+				unixPaths[path] = b.Name
+			}
+			// Only the SOCKS listener can be a UNIX socket, and its clients
+			// have no IP to bind or filter on
+			if b.HttpListenPort > 0 {
+				addErr("bridge %q: SBHttpListenPort needs a TCP SBSocksListenAddress", b.Name)
+			}
+			if b.SocksListenInterface != "" {
+				addErr("bridge %q: SBSocksListenInterface cannot be used with a UNIX SBSocksListenAddress", b.Name)
+			}
+			if len(b.AllowedInAddresses) > 0 {
+				addErr("bridge %q: SBAllowedInAddresses cannot filter clients of a UNIX SBSocksListenAddress", b.Name)
+			}
+			continue
+		}
+		if ip := net.ParseIP(b.SocksListenAddress); ip != nil && !ip.IsUnspecified() {
+			if (b.SocksListenNetwork == "tcp4" && ip.To4() == nil) || (b.SocksListenNetwork == "tcp6" && ip.To4() != nil) {
+				addErr("bridge %q: SBSocksListenAddress %s cannot be listened on with SBSocksListenNetwork %q", b.Name, b.SocksListenAddress, b.SocksListenNetwork)
+			}
+		}
+		for _, l := range []listenerAddr{
+			{b.Name, "SBSocksListenPort", b.SocksListenAddress, b.SocksListenPort},
+			{b.Name, "SBHttpListenPort", b.SocksListenAddress, b.HttpListenPort},
+		} {
+			if l.port == 0 {
+				continue
+			}
+			for _, other := range listeners {
+				if l.overlaps(other) {
+					addErr("bridge %q: %s %d is already used by %s of bridge %q", l.bridge, l.field, l.port, other.field, other.bridge)
+				}
+			}
+			listeners = append(listeners, l)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// listenerAddr is a local port a near bridge listens on.
+type listenerAddr struct {
+	bridge string
+	field  string
+	host   string
+	port   int
+}
+
+// overlaps reports whether two listeners would fight over the same port. An
+// unspecified address binds every interface, so it clashes with any host.
+func (l listenerAddr) overlaps(other listenerAddr) bool {
+	if l.port != other.port {
+		return false
+	}
+	return l.host == other.host || isUnspecified(l.host) || isUnspecified(other.host)
+}
+
+func isUnspecified(host string) bool {
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
@@ -0,0 +1,410 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func validNear(name string, port int) SalmonBridgeConfig {
+	return SalmonBridgeConfig{Name: name, Connect: true, FarIp: "10.0.0.1", FarPort: 1111, SocksListenPort: port}
+}
+
+func validateBridges(bridges ...SalmonBridgeConfig) error {
+	cfg := &SalmonCannonConfig{Bridges: bridges}
+	cfg.SetDefaults()
+	return cfg.Validate()
+}
+
+func TestValidate_ValidConfig(t *testing.T) {
+	far := SalmonBridgeConfig{Name: "one", NearPort: 1111}
+	if err := validateBridges(validNear("one", 1080), validNear("two", 1081), far); err != nil {
+		t.Fatalf("expected valid config, got %v", err)
+	}
+}
+
+func TestValidate_DuplicateNames(t *testing.T) {
+	err := validateBridges(validNear("dup", 1080), validNear("dup", 1081))
+	if err == nil || !strings.Contains(err.Error(), `bridge "dup": duplicate SBName`) {
+		t.Fatalf("expected duplicate name error, got %v", err)
+	}
+}
+
+func TestValidate_OverlappingListenPorts(t *testing.T) {
+	err := validateBridges(validNear("a", 1080), validNear("b", 1080))
+	if err == nil || !strings.Contains(err.Error(), `SBSocksListenPort 1080 is already used by SBSocksListenPort of bridge "a"`) {
+		t.Fatalf("expected overlapping port error, got %v", err)
+	}
+
+	// The HTTP listener counts too
+	withHttp := validNear("c", 1090)
+	withHttp.HttpListenPort = 1080
+	if err := validateBridges(validNear("a", 1080), withHttp); err == nil {
+		t.Fatalf("expected HTTP port clash to be reported")
+	}
+
+	// Same port on different addresses is fine, unless one binds everything
+	other := validNear("d", 1080)
+	other.SocksListenAddress = "127.0.0.2"
+	if err := validateBridges(validNear("a", 1080), other); err != nil {
+		t.Fatalf("expected different addresses not to clash, got %v", err)
+	}
+	other.SocksListenAddress = "0.0.0.0"
+	if err := validateBridges(validNear("a", 1080), other); err == nil {
+		t.Fatalf("expected 0.0.0.0 to clash with 127.0.0.1")
+	}
+}
+
+func TestValidate_SmallReceiveBuffer(t *testing.T) {
+	b := validNear("small", 1080)
+	b.MaxRecieveBufferSize = SizeString(1024 * 1024)
+	err := validateBridges(b)
+	if err == nil || !strings.Contains(err.Error(), "below the 7MB minimum") {
+		t.Fatalf("expected buffer size error, got %v", err)
+	}
+}
+
+func TestValidate_RelayBufferSize(t *testing.T) {
+	for _, size := range []SizeString{512, 32 * 1024 * 1024} {
+		b := validNear("relay", 1080)
+		b.RelayBufferSize = size
+		err := validateBridges(b)
+		if err == nil || !strings.Contains(err.Error(), "SBRelayBufferSize") {
+			t.Fatalf("expected relay buffer size error for %d, got %v", size, err)
+		}
+	}
+}
+
+func TestValidate_CoalesceDelay(t *testing.T) {
+	for _, d := range []time.Duration{-time.Millisecond, time.Second} {
+		b := validNear("coalesce", 1080)
+		b.CoalesceDelay = DurationString(d)
+		if err := validateBridges(b); err == nil || !strings.Contains(err.Error(), "SBCoalesceDelay") {
+			t.Fatalf("expected coalesce delay error for %v, got %v", d, err)
+		}
+	}
+	b := validNear("coalesce", 1080)
+	b.CoalesceDelay = DurationString(time.Millisecond)
+	if err := validateBridges(b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValidate_MaxConcurrentClients(t *testing.T) {
+	b := validNear("clients", 1080)
+	b.MaxConcurrentClients = -1
+	err := validateBridges(b)
+	if err == nil || !strings.Contains(err.Error(), "SBMaxConcurrentClients") {
+		t.Fatalf("expected max concurrent clients error, got %v", err)
+	}
+}
+
+func TestValidate_ListenSockets(t *testing.T) {
+	far := SalmonBridgeConfig{Name: "far", NearPort: 1111, ListenSockets: 4}
+	if err := validateBridges(far); err != nil {
+		t.Fatalf("expected 4 listen sockets to be fine, got %v", err)
+	}
+	far.ListenSockets = -1
+	err := validateBridges(far)
+	if err == nil || !strings.Contains(err.Error(), "SBListenSockets") {
+		t.Fatalf("expected listen sockets error, got %v", err)
+	}
+}
+
+func TestValidate_UnixSocksListener(t *testing.T) {
+	a := validNear("unix-a", 0)
+	a.SocksListenAddress = "unix:/run/salmon-a.sock"
+	b := validNear("unix-b", 0)
+	b.SocksListenAddress = "unix:/run/salmon-b.sock"
+	// A port clash with a TCP listener does not apply to a socket path
+	if err := validateBridges(a, b, validNear("tcp", 1080)); err != nil {
+		t.Fatalf("expected UNIX socket listeners to be fine, got %v", err)
+	}
+
+	b.SocksListenAddress = a.SocksListenAddress
+	err := validateBridges(a, b)
+	if err == nil || !strings.Contains(err.Error(), `is already used by bridge "unix-a"`) {
+		t.Fatalf("expected duplicate socket path error, got %v", err)
+	}
+
+	empty := validNear("unix-empty", 0)
+	empty.SocksListenAddress = "unix:"
+	err = validateBridges(empty)
+	if err == nil || !strings.Contains(err.Error(), "needs a socket path") {
+		t.Fatalf("expected empty socket path error, got %v", err)
+	}
+
+	a.HttpListenPort = 8080
+	a.SocksListenInterface = "eth0"
+	a.AllowedInAddresses = []string{"10.0.0.0/8"}
+	err = validateBridges(a)
+	for _, want := range []string{"SBHttpListenPort", "SBSocksListenInterface", "SBAllowedInAddresses"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %s error, got %v", want, err)
+		}
+	}
+}
+
+func TestValidate_ListenNetworks(t *testing.T) {
+	far := SalmonBridgeConfig{Name: "far", NearPort: 1111, FarListenNetwork: "udp4"}
+	near := validNear("near", 1080)
+	near.SocksListenNetwork = "tcp4"
+	if err := validateBridges(far, near); err != nil {
+		t.Fatalf("expected udp4 and tcp4 to be fine, got %v", err)
+	}
+
+	far.FarListenNetwork = "tcp"
+	near.SocksListenNetwork = "udp"
+	err := validateBridges(far, near)
+	for _, want := range []string{"SBFarListenNetwork", "SBSocksListenNetwork"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %s error, got %v", want, err)
+		}
+	}
+
+	// The default 127.0.0.1 cannot be listened on over IPv6 only
+	near.SocksListenNetwork = "tcp6"
+	err = validateBridges(near)
+	if err == nil || !strings.Contains(err.Error(), "cannot be listened on") {
+		t.Fatalf("expected address family error, got %v", err)
+	}
+	near.SocksListenAddress = "::1"
+	if err := validateBridges(near); err != nil {
+		t.Fatalf("expected ::1 over tcp6 to be fine, got %v", err)
+	}
+}
+
+func TestValidate_AddressRegex(t *testing.T) {
+	far := SalmonBridgeConfig{Name: "far", NearPort: 1111,
+		AllowedOutAddresses: []string{"10.0.0.0/8", "example.org", `re:^.*\.example\.com$`}}
+	if err := validateBridges(far); err != nil {
+		t.Fatalf("expected mixed entries to be fine, got %v", err)
+	}
+
+	far.AllowedOutAddresses = []string{"re:^(oops$"}
+	err := validateBridges(far)
+	if err == nil || !strings.Contains(err.Error(), `bridge "far": SBAllowedOutAddresses: invalid regex`) {
+		t.Fatalf("expected regex compile error, got %v", err)
+	}
+
+	near := validNear("near", 1080)
+	near.AllowedInAddresses = []string{"re:^host$"}
+	err = validateBridges(near)
+	if err == nil || !strings.Contains(err.Error(), "SBAllowedInAddresses cannot have") {
+		t.Fatalf("expected regex in SBAllowedInAddresses to be refused, got %v", err)
+	}
+}
+
+func TestValidate_PerClientConnRate(t *testing.T) {
+	b := validNear("conn-rate", 1080)
+	b.PerClientConnRate = -1
+	b.PerClientConnBurst = -2
+	err := validateBridges(b)
+	if err == nil || !strings.Contains(err.Error(), "SBPerClientConnRate") || !strings.Contains(err.Error(), "SBPerClientConnBurst") {
+		t.Fatalf("expected connection rate errors, got %v", err)
+	}
+}
+
+func TestValidate_ConnectWithoutFarIp(t *testing.T) {
+	b := validNear("nofar", 1080)
+	b.FarIp = ""
+	err := validateBridges(b)
+	if err == nil || !strings.Contains(err.Error(), "SBFarIp must be set") {
+		t.Fatalf("expected missing far IP error, got %v", err)
+	}
+}
+
+func TestValidate_AllowedOutAddressTypes(t *testing.T) {
+	far := SalmonBridgeConfig{Name: "far", NearPort: 1111, AllowedOutAddressTypes: []string{"domain", "ipv6"}}
+	if err := validateBridges(far); err != nil {
+		t.Fatalf("expected valid address types, got %v", err)
+	}
+	far.AllowedOutAddressTypes = []string{"domain", "hostname"}
+	err := validateBridges(far)
+	if err == nil || !strings.Contains(err.Error(), `SBAllowedOutAddressTypes entry "hostname"`) {
+		t.Fatalf("expected unknown address type error, got %v", err)
+	}
+}
+
+func TestValidate_InterfaceNameOffLinux(t *testing.T) {
+	oldGoos := goos
+	defer func() { goos = oldGoos }()
+
+	b := validNear("iface", 1080)
+	b.InterfaceName = "eth0"
+	goos = "linux"
+	if err := validateBridges(b); err != nil {
+		t.Fatalf("expected interface name to be fine on linux, got %v", err)
+	}
+	goos = "darwin"
+	if err := validateBridges(b); err == nil || !strings.Contains(err.Error(), "only supported on Linux") {
+		t.Fatalf("expected interface name error, got %v", err)
+	}
+}
+
+func TestValidate_FarEgressInterfaceOffLinux(t *testing.T) {
+	oldGoos := goos
+	defer func() { goos = oldGoos }()
+
+	b := SalmonBridgeConfig{Name: "egress", NearPort: 1111, FarEgressInterface: "eth1"}
+	goos = "linux"
+	if err := validateBridges(b); err != nil {
+		t.Fatalf("expected egress interface to be fine on linux, got %v", err)
+	}
+	goos = "darwin"
+	if err := validateBridges(b); err == nil || !strings.Contains(err.Error(), "SBFarEgressInterface is only supported on Linux") {
+		t.Fatalf("expected egress interface error, got %v", err)
+	}
+}
+
+func TestValidate_ReportsEveryProblem(t *testing.T) {
+	noFar := validNear("b", 1080)
+	noFar.FarIp = ""
+	err := validateBridges(validNear("a", 1080), noFar, validNear("a", 1090))
+	if err == nil {
+		t.Fatalf("expected errors")
+	}
+	if lines := strings.Split(err.Error(), "\n"); len(lines) != 3 {
+		t.Fatalf("expected 3 problems, got %d: %v", len(lines), err)
+	}
+}
+
+func TestLoadConfig_FailsValidation(t *testing.T) {
+	yamlData := `SalmonBridges:
+  - SBName: "dup"
+    SBConnect: true
+    SBFarIp: "10.0.0.1"
+    SBFarPort: 1111
+    SBSocksListenPort: 1080
+  - SBName: "dup"
+    SBConnect: true
+    SBFarPort: 1111
+    SBSocksListenPort: 1080
+`
+	f, err := os.CreateTemp("", "salmon_config_test.yaml")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(yamlData)
+	f.Close()
+
+	_, err = LoadConfig(f.Name())
+	if err == nil {
+		t.Fatalf("expected LoadConfig to fail")
+	}
+	for _, want := range []string{"duplicate SBName", "SBFarIp must be set", "SBSocksListenPort 1080"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %q, got %v", want, err)
+		}
+	}
+}
+
+func TestLoadConfig_RejectsSmallReceiveBuffer(t *testing.T) {
+	yamlData := `SalmonBridges:
+  - SBName: "tiny"
+    SBConnect: true
+    SBFarIp: "10.0.0.1"
+    SBFarPort: 1111
+    SBSocksListenPort: 1080
+    SBMaxRecieveBufferSize: 1MB
+`
+	f, err := os.CreateTemp("", "salmon_config_test.yaml")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(yamlData)
+	f.Close()
+
+	_, err = LoadConfig(f.Name())
+	if err == nil || !strings.Contains(err.Error(), `bridge "tiny": SBMaxRecieveBufferSize 1048576 is below the 7MB minimum`) {
+		t.Fatalf("expected LoadConfig to reject a 1MB receive buffer, got %v", err)
+	}
+}
+
+func TestValidate_AuthCommand(t *testing.T) {
+	b := validNear("auth", 1080)
+	b.AuthCommand = "   "
+	if err := validateBridges(b); err == nil || !strings.Contains(err.Error(), "SBAuthCommand") {
+		t.Fatalf("expected a blank SBAuthCommand to be rejected, got %v", err)
+	}
+	b.AuthCommand = "/usr/local/bin/check-socks-user --realm corp"
+	if err := validateBridges(b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValidate_GlobalMetrics(t *testing.T) {
+	for _, endpoint := range []string{"", "statsd.local", "statsd.local:"} {
+		cfg := &SalmonCannonConfig{GlobalMetrics: &GlobalMetricsConfig{Endpoint: endpoint}}
+		cfg.SetDefaults()
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "GlobalMetrics.Endpoint") {
+			t.Fatalf("expected endpoint error for %q, got %v", endpoint, err)
+		}
+	}
+
+	cfg := &SalmonCannonConfig{GlobalMetrics: &GlobalMetricsConfig{Endpoint: "127.0.0.1:8125"}}
+	cfg.SetDefaults()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.GlobalMetrics.Interval.Duration() != 10*time.Second || cfg.GlobalMetrics.Prefix != "salmoncannon" {
+		t.Fatalf("expected default interval and prefix, got %v %q", cfg.GlobalMetrics.Interval.Duration(), cfg.GlobalMetrics.Prefix)
+	}
+}
+
+func TestValidate_OutPorts(t *testing.T) {
+	far := SalmonBridgeConfig{Name: "far", NearPort: 1111, AllowedOutPorts: []int{443, 80}, DeniedOutPorts: []int{25}}
+	if err := validateBridges(far); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	far.AllowedOutPorts = []int{0}
+	far.DeniedOutPorts = []int{65536}
+	err := validateBridges(far)
+	if err == nil || !strings.Contains(err.Error(), "SBAllowedOutPorts entry 0") || !strings.Contains(err.Error(), "SBDeniedOutPorts entry 65536") {
+		t.Fatalf("expected out port range errors, got %v", err)
+	}
+}
+
+func TestValidate_StreamOpenAttempts(t *testing.T) {
+	for _, n := range []int{-1, maxStreamOpenAttempts + 1} {
+		b := validNear("attempts", 1080)
+		b.StreamOpenAttempts = n
+		if err := validateBridges(b); err == nil || !strings.Contains(err.Error(), "SBStreamOpenAttempts") {
+			t.Fatalf("expected stream open attempts error for %d, got %v", n, err)
+		}
+	}
+	cfg := &SalmonCannonConfig{Bridges: []SalmonBridgeConfig{validNear("attempts", 1080)}}
+	cfg.SetDefaults()
+	if cfg.Bridges[0].StreamOpenAttempts != 3 {
+		t.Fatalf("expected 3 attempts by default, got %d", cfg.Bridges[0].StreamOpenAttempts)
+	}
+}
+
+func TestValidate_MaxOutPerClient(t *testing.T) {
+	far := SalmonBridgeConfig{Name: "far", NearPort: 1111, MaxOutPerClient: 4}
+	if err := validateBridges(far); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	far.MaxOutPerClient = -1
+	if err := validateBridges(far); err == nil || !strings.Contains(err.Error(), "SBMaxOutPerClient") {
+		t.Fatalf("expected max out per client error, got %v", err)
+	}
+}
+
+func TestValidate_UDPBuffers(t *testing.T) {
+	b := validNear("udp-buffers", 1080)
+	b.UDPReceiveBuffer = SizeString(16 * 1024 * 1024)
+	b.UDPSendBuffer = SizeString(8 * 1024 * 1024)
+	if err := validateBridges(b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b.UDPReceiveBuffer = SizeString(1024 * 1024)
+	b.UDPSendBuffer = SizeString(2 * 1024 * 1024 * 1024)
+	err := validateBridges(b)
+	if err == nil || !strings.Contains(err.Error(), "SBUdpReceiveBuffer") || !strings.Contains(err.Error(), "SBUdpSendBuffer") {
+		t.Fatalf("expected UDP buffer range errors, got %v", err)
+	}
+}
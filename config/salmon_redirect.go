@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"strings"
+)
+
+// RedirectDefault is the Redirects key for destinations no other key matches.
+const RedirectDefault = "*"
+
+// BridgeFor returns the bridge to redirect host through, or "" if no key
+// matches. Keys are an exact hostname or IP, "*.example.com" for any
+// subdomain of example.com, a CIDR range for IP targets, or RedirectDefault.
+// An exact key beats a wildcard or range, a longer suffix or narrower range
+// beats a wider one, and the default only applies when nothing else does.
+func (c *SocksRedirectConfig) BridgeFor(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+	var best string
+	bestScore := -1
+	for pattern := range c.Redirects {
+		score := redirectScore(pattern, host)
+		// Ties go to the smaller key so the choice doesn't depend on map order
+		if score > bestScore || (score == bestScore && score >= 0 && pattern < best) {
+			best, bestScore = pattern, score
+		}
+	}
+	if bestScore < 0 {
+		return ""
+	}
+	return c.Redirects[best]
+}
+
+// redirectScore rates how specifically pattern matches host, -1 if it does
+// not match at all.
+func redirectScore(pattern, host string) int {
+	if pattern == RedirectDefault {
+		return 0
+	}
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		if strings.HasSuffix(host, "."+suffix) {
+			return 1 + len(suffix)
+		}
+		return -1
+	}
+	if strings.Contains(pattern, "/") {
+		prefix, err := netip.ParsePrefix(pattern)
+		ip, ipErr := netip.ParseAddr(host)
+		if err != nil || ipErr != nil || !prefix.Contains(ip.Unmap()) {
+			return -1
+		}
+		return 1 + prefix.Bits()
+	}
+	if pattern == host {
+		return math.MaxInt32
+	}
+	return -1
+}
+
+// validRedirectPattern reports why pattern can never match, or nil.
+func validRedirectPattern(pattern string) error {
+	switch {
+	case pattern == RedirectDefault:
+		return nil
+	case strings.Contains(pattern, "/"):
+		if _, err := netip.ParsePrefix(pattern); err != nil {
+			return fmt.Errorf("invalid CIDR range: %v", err)
+		}
+	case strings.HasPrefix(pattern, "*."):
+		if strings.Contains(pattern[2:], "*") || len(pattern) == 2 {
+			return fmt.Errorf("wildcards must be a single leading \"*.\"")
+		}
+	case pattern == "" || strings.Contains(pattern, "*"):
+		return fmt.Errorf("must be a hostname, IP, CIDR range, \"*.domain\" or \"*\"")
+	}
+	return nil
+}
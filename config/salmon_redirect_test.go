@@ -0,0 +1,63 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSocksRedirectConfig_BridgeFor(t *testing.T) {
+	cfg := &SocksRedirectConfig{Redirects: map[string]string{
+		"example.com":       "bridge-com",
+		"*.example.com":     "bridge-sub",
+		"*.api.example.com": "bridge-api",
+		"10.0.0.0/8":        "bridge-lan",
+		"10.1.2.3":          "bridge-host",
+		RedirectDefault:     "bridge-default",
+	}}
+	cases := []struct {
+		host, want string
+	}{
+		{"example.com", "bridge-com"},
+		{"EXAMPLE.com.", "bridge-com"},
+		{"www.example.com", "bridge-sub"},
+		{"v1.api.example.com", "bridge-api"}, // longer suffix wins
+		{"10.9.8.7", "bridge-lan"},
+		{"10.1.2.3", "bridge-host"}, // exact beats the range
+		{"notexample.com.evil.net", "bridge-default"},
+		{"notexample.com", "bridge-default"},
+		{"example.com.evil.net", "bridge-default"},
+		{"11.0.0.1", "bridge-default"},
+	}
+	for _, tc := range cases {
+		if got := cfg.BridgeFor(tc.host); got != tc.want {
+			t.Errorf("%s: expected %q, got %q", tc.host, tc.want, got)
+		}
+	}
+
+	delete(cfg.Redirects, RedirectDefault)
+	if got := cfg.BridgeFor("notexample.com.evil.net"); got != "" {
+		t.Errorf("expected no bridge without a default, got %q", got)
+	}
+}
+
+func TestValidate_RedirectPatterns(t *testing.T) {
+	cfg := &SalmonCannonConfig{SocksRedirectConfig: &SocksRedirectConfig{Redirects: map[string]string{
+		"example.com": "a", "*.example.com": "a", "10.0.0.0/8": "a", "*": "a",
+		"10.0.0.0/33": "a", "foo.*.com": "a", "*.": "a",
+	}}}
+	cfg.SetDefaults()
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatalf("expected invalid redirect keys to be rejected")
+	}
+	for _, bad := range []string{`"10.0.0.0/33"`, `"foo.*.com"`, `"*."`} {
+		if !strings.Contains(err.Error(), "SocksRedirect.Redirects key "+bad) {
+			t.Errorf("expected an error for %s, got %v", bad, err)
+		}
+	}
+	for _, good := range []string{`"example.com"`, `"*.example.com"`, `"10.0.0.0/8"`, `"*":`} {
+		if strings.Contains(err.Error(), "key "+good) {
+			t.Errorf("unexpected error for %s: %v", good, err)
+		}
+	}
+}
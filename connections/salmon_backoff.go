@@ -0,0 +1,67 @@
+package connections
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+var DefaultReconnectBackoffMin = 100 * time.Millisecond
+var DefaultReconnectBackoffMax = 30 * time.Second
+
+// dialBackoff tracks consecutive dial failures to the far side and spaces out
+// re-dials exponentially so a burst of clients can't hammer a dead far host.
+// It is not safe for concurrent use; SalmonQuic guards it with connectionsMu.
+type dialBackoff struct {
+	min      time.Duration
+	max      time.Duration
+	failures int
+	next     time.Time
+}
+
+// remaining returns how long until the next dial is allowed (0 if now).
+func (b *dialBackoff) remaining() time.Duration {
+	if b.failures == 0 {
+		return 0
+	}
+	wait := time.Until(b.next)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// delay returns the un-jittered backoff for the current failure count.
+func (b *dialBackoff) delay() time.Duration {
+	if b.failures == 0 || b.min <= 0 {
+		return 0
+	}
+	d := b.min
+	for i := 1; i < b.failures; i++ {
+		d *= 2
+		if b.max > 0 && d >= b.max {
+			return b.max
+		}
+	}
+	if b.max > 0 && d > b.max {
+		d = b.max
+	}
+	return d
+}
+
+// failure records a failed dial and schedules the next permitted attempt.
+// Jitter picks a point in [delay/2, delay) so concurrent near sides spread out.
+func (b *dialBackoff) failure() time.Duration {
+	b.failures++
+	d := b.delay()
+	if d > 1 {
+		d = d/2 + rand.N(d/2)
+	}
+	b.next = time.Now().Add(d)
+	return d
+}
+
+// reset clears the failure count after a successful dial.
+func (b *dialBackoff) reset() {
+	b.failures = 0
+	b.next = time.Time{}
+}
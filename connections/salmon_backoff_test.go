@@ -0,0 +1,96 @@
+package connections
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+func TestDialBackoff_DoublesUpToMax(t *testing.T) {
+	b := dialBackoff{min: 100 * time.Millisecond, max: 1 * time.Second}
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		1 * time.Second,
+		1 * time.Second,
+	}
+	for i, want := range expected {
+		b.failures = i + 1
+		if got := b.delay(); got != want {
+			t.Errorf("failure %d: expected delay %v, got %v", i+1, want, got)
+		}
+	}
+}
+
+func TestDialBackoff_JitterWithinBounds(t *testing.T) {
+	b := dialBackoff{min: 100 * time.Millisecond, max: 30 * time.Second}
+
+	for i := 0; i < 8; i++ {
+		got := b.failure()
+		full := b.delay()
+		if got < full/2 || got >= full {
+			t.Errorf("failure %d: jittered delay %v outside [%v, %v)", b.failures, got, full/2, full)
+		}
+		if b.remaining() <= 0 {
+			t.Errorf("failure %d: expected remaining backoff > 0", b.failures)
+		}
+	}
+}
+
+func TestDialBackoff_Reset(t *testing.T) {
+	b := dialBackoff{min: 100 * time.Millisecond, max: 30 * time.Second}
+	b.failure()
+	b.failure()
+
+	b.reset()
+
+	if b.failures != 0 {
+		t.Errorf("expected failures reset to 0, got %d", b.failures)
+	}
+	if b.remaining() != 0 {
+		t.Errorf("expected no remaining backoff after reset, got %v", b.remaining())
+	}
+}
+
+func TestOpenStreamBackoffFailsFast(t *testing.T) {
+	clientTLSConfig := &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"quic-test"},
+	}
+	qcfg := &quic.Config{
+		HandshakeIdleTimeout: 200 * time.Millisecond,
+	}
+
+	MaxConnectionsPerBridge = 1
+	MaxStreamsPerConnection = 10
+
+	// Nothing listens on this port so the first dial fails
+	sq := NewSalmonQuic(1, "127.0.0.1", "test-backoff", clientTLSConfig, qcfg, "")
+	sq.SetReconnectBackoff(5*time.Second, 10*time.Second)
+
+	_, _, err, _ := sq.OpenStream()
+	if err == nil {
+		t.Fatal("expected first OpenStream to fail")
+	}
+
+	// The second attempt must not dial again while the backoff is in effect
+	start := time.Now()
+	_, _, err, _ = sq.OpenStream()
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("expected second OpenStream to fail during backoff")
+	}
+	if !strings.Contains(err.Error(), "next dial in") {
+		t.Errorf("expected backoff error, got: %v", err)
+	}
+	if elapsed > 100*time.Millisecond {
+		t.Errorf("expected OpenStream to fail fast during backoff, took %v", elapsed)
+	}
+}
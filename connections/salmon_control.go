@@ -0,0 +1,117 @@
+package connections
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"salmoncannon/status"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// controlOpenTimeout bounds opening a connection's control stream and the
+// handshake run over it.
+const controlOpenTimeout = 5 * time.Second
+
+// ControlChannel is the long lived control stream of one pooled connection.
+// It carries out of band coordination such as keepalives so they do not
+// need a stream of their own each time.
+type ControlChannel interface {
+	// Ping does one keepalive round trip, failing if it takes longer than
+	// timeout or the control stream has died.
+	Ping(timeout time.Duration) error
+	// Epoch is the random id the far instance picked when it started, or 0
+	// if it sent none. A far restarted on the same address has a new one.
+	Epoch() uint64
+	Close() error
+}
+
+// ControlHandshake sets up a ControlChannel on a stream opened right after
+// a connection is dialed. A far side that does not take part fails the
+// handshake, and the connection carries on without a control channel.
+type ControlHandshake func(stream *quic.Stream) (ControlChannel, error)
+
+// SetControl opens a control stream on every connection the pool dials from
+// now on and runs handshake over it. The keepalive then pings over the
+// control stream instead of opening a stream per ping.
+func (s *SalmonQuic) SetControl(handshake ControlHandshake) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	s.controlHandshake = handshake
+}
+
+// startControlLocked opens qconn's control channel in the background so
+// the first stream does not wait on it. The caller must hold connectionsMu.
+func (s *SalmonQuic) startControlLocked(qconn *quicConnection) {
+	handshake := s.controlHandshake
+	if handshake == nil {
+		return
+	}
+	go func() {
+		control, err := s.openControl(qconn, handshake)
+		if err != nil {
+			log.Printf("NEAR: Bridge %s connection has no control stream, keepalives use a stream each: %v", s.BridgeName, err)
+			return
+		}
+		qconn.mu.Lock()
+		if qconn.conn == nil {
+			// Closed while the handshake ran
+			qconn.mu.Unlock()
+			control.Close()
+			return
+		}
+		qconn.control = control
+		qconn.mu.Unlock()
+		s.noteFarEpoch(qconn, control.Epoch())
+	}()
+}
+
+// openControl opens a stream directly on qconn, bypassing the pool's stream
+// accounting like keepalive pings do, and runs handshake over it.
+func (s *SalmonQuic) openControl(qconn *quicConnection, handshake ControlHandshake) (ControlChannel, error) {
+	qconn.mu.Lock()
+	conn := qconn.conn
+	qconn.mu.Unlock()
+	if conn == nil {
+		return nil, fmt.Errorf("connection is closed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), controlOpenTimeout)
+	defer cancel()
+	// Not latency sensitive, so it waits out the handshake rather than go
+	// in 0-RTT and be lost if the far rejects it
+	if _, err := conn.NextConnection(ctx); err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream.SetDeadline(time.Now().Add(controlOpenTimeout))
+	control, err := handshake(stream)
+	if err != nil {
+		stream.CancelRead(0)
+		stream.Close()
+		return nil, err
+	}
+	stream.SetDeadline(time.Time{})
+	return control, nil
+}
+
+// controlChannel returns qconn's control channel, or nil if it has none.
+func (qc *quicConnection) controlChannel() ControlChannel {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	return qc.control
+}
+
+// DetachFarStream stops a far side stream counting as active, so a stream
+// that lives as long as its connection, such as a control stream, does not
+// hold up Shutdown or StopFarListen while they wait for streams to drain.
+func (s *SalmonQuic) DetachFarStream(stream *quic.Stream) {
+	if _, loaded := s.detachedFarStreams.LoadOrStore(stream, struct{}{}); !loaded {
+		s.farStreams.Add(-1)
+		status.GlobalConnMonitorRef.RemoveStream(s.BridgeName)
+	}
+}
@@ -0,0 +1,179 @@
+package connections
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+)
+
+// datagramQueueSize is how many received datagrams a stream buffers before
+// further ones are dropped. Datagrams are unreliable anyway, so a slow
+// reader loses packets rather than stalling every stream on the connection.
+const datagramQueueSize = 256
+
+// datagramIdLen prefixes every datagram with the stream it belongs to.
+const datagramIdLen = 8
+
+// ErrDatagramsUnsupported is returned when a connection was set up without
+// QUIC datagrams on one side or the other.
+var ErrDatagramsUnsupported = errors.New("QUIC datagrams not enabled on both sides")
+
+// StreamDatagrams carries unreliable QUIC datagrams that belong to a stream.
+// Both sides tag datagrams with the stream's ID, so each stream on a
+// connection gets its own flow. The stream stays open for the flow's
+// lifetime; it is how either side learns the other has finished.
+type StreamDatagrams struct {
+	mux *datagramMux
+	id  quic.StreamID
+	in  chan []byte
+
+	closeOnce sync.Once
+}
+
+// Send sends p as a single datagram. Payloads above the path's datagram
+// size fail with a *quic.DatagramTooLargeError; nothing is fragmented.
+func (d *StreamDatagrams) Send(p []byte) error {
+	buf := make([]byte, datagramIdLen+len(p))
+	binary.BigEndian.PutUint64(buf, uint64(d.id))
+	copy(buf[datagramIdLen:], p)
+	return d.mux.conn.SendDatagram(buf)
+}
+
+// Receive waits for the next datagram for this stream. It fails once ctx
+// is done, the flow is closed or the connection goes away.
+func (d *StreamDatagrams) Receive(ctx context.Context) ([]byte, error) {
+	select {
+	case p, ok := <-d.in:
+		if !ok {
+			return nil, fmt.Errorf("datagram flow %d closed", d.id)
+		}
+		return p, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops delivering datagrams to this flow.
+func (d *StreamDatagrams) Close() {
+	d.closeOnce.Do(func() {
+		d.mux.remove(d)
+	})
+}
+
+// datagramMux reads a connection's datagrams and hands each one to the
+// flow its stream ID names. Datagrams for unknown flows are dropped.
+type datagramMux struct {
+	conn *quic.Conn
+
+	mu    sync.Mutex
+	flows map[quic.StreamID]*StreamDatagrams
+	done  bool
+}
+
+func (m *datagramMux) remove(d *StreamDatagrams) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.flows[d.id] == d {
+		delete(m.flows, d.id)
+		close(d.in)
+	}
+}
+
+func (m *datagramMux) receiveLoop() {
+	defer func() {
+		m.mu.Lock()
+		m.done = true
+		for id, d := range m.flows {
+			delete(m.flows, id)
+			close(d.in)
+		}
+		m.mu.Unlock()
+	}()
+	for {
+		p, err := m.conn.ReceiveDatagram(m.conn.Context())
+		if err != nil {
+			return
+		}
+		if len(p) < datagramIdLen {
+			continue
+		}
+		id := quic.StreamID(binary.BigEndian.Uint64(p))
+		m.mu.Lock()
+		if d, ok := m.flows[id]; ok {
+			select {
+			case d.in <- p[datagramIdLen:]:
+			default:
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// streamDatagrams registers a datagram flow for stream on conn, starting
+// the connection's receive loop the first time.
+func (s *SalmonQuic) streamDatagrams(conn *quic.Conn, stream *quic.Stream) (*StreamDatagrams, error) {
+	if !s.qcfg.EnableDatagrams || !conn.ConnectionState().SupportsDatagrams {
+		return nil, ErrDatagramsUnsupported
+	}
+	m, loaded := s.datagramMuxes.LoadOrStore(conn, &datagramMux{conn: conn, flows: make(map[quic.StreamID]*StreamDatagrams)})
+	mux := m.(*datagramMux)
+	if !loaded {
+		go func() {
+			mux.receiveLoop()
+			s.datagramMuxes.Delete(conn)
+		}()
+	}
+
+	d := &StreamDatagrams{mux: mux, id: stream.StreamID(), in: make(chan []byte, datagramQueueSize)}
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	if mux.done {
+		return nil, fmt.Errorf("connection closed")
+	}
+	mux.flows[d.id] = d
+	return d, nil
+}
+
+// OpenDatagramStream opens a stream from the pool with a datagram flow
+// attached. The cleanup function MUST be called when done, as for
+// OpenStream; it also closes the flow.
+func (s *SalmonQuic) OpenDatagramStream() (*quic.Stream, *StreamDatagrams, func(), error) {
+	stream, cleanup, err, qconn := s.OpenStream()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	qconn.mu.Lock()
+	conn := qconn.conn
+	qconn.mu.Unlock()
+	if conn == nil {
+		stream.CancelRead(0)
+		stream.Close()
+		cleanup()
+		return nil, nil, nil, fmt.Errorf("connection is closed")
+	}
+	d, err := s.streamDatagrams(conn, stream)
+	if err != nil {
+		stream.CancelRead(0)
+		stream.Close()
+		cleanup()
+		return nil, nil, nil, err
+	}
+	return stream, d, func() {
+		d.Close()
+		cleanup()
+	}, nil
+}
+
+// FarStreamDatagrams attaches a datagram flow to a stream the far side is
+// handling. Close the flow when the stream is done.
+func (s *SalmonQuic) FarStreamDatagrams(stream *quic.Stream) (*StreamDatagrams, error) {
+	c, ok := s.farStreamConns.Load(stream)
+	if !ok {
+		return nil, ErrDatagramsUnsupported
+	}
+	return s.streamDatagrams(c.(*quic.Conn), stream)
+}
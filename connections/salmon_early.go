@@ -0,0 +1,90 @@
+package connections
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+
+	"github.com/quic-go/quic-go"
+)
+
+// quicListener is a far listener socket, a *quic.Listener or, with 0-RTT
+// enabled, a *quic.EarlyListener.
+type quicListener interface {
+	Accept(ctx context.Context) (*quic.Conn, error)
+	Close() error
+}
+
+// SetEnable0RTT turns on TLS session resumption with QUIC 0-RTT. A near
+// keeps the session tickets its far hands out and dials with them, so the
+// first streams of a re-dialed connection go out with the handshake rather
+// than one round trip after it. A far accepts 0-RTT from the next
+// NewFarListen on, but only acts on a connection's streams once its
+// handshake completes, so a replayed 0-RTT flight never reaches a target.
+func (s *SalmonQuic) SetEnable0RTT(enabled bool) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	s.enable0RTT = enabled
+	if !enabled {
+		return
+	}
+	if s.tlscfg != nil && s.tlscfg.ClientSessionCache == nil {
+		s.tlscfg = s.tlscfg.Clone()
+		s.tlscfg.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	if s.qcfg != nil && !s.qcfg.Allow0RTT {
+		s.qcfg = s.qcfg.Clone()
+		s.qcfg.Allow0RTT = true
+	} else if s.qcfg == nil {
+		s.qcfg = &quic.Config{Allow0RTT: true}
+	}
+}
+
+// dialQuic dials addr over pc, or over a socket of its own when pc is nil.
+// The caller must hold connectionsMu.
+func (s *SalmonQuic) dialQuic(ctx context.Context, pc net.PacketConn, addr string) (*quic.Conn, error) {
+	if pc == nil {
+		if s.enable0RTT {
+			return quic.DialAddrEarly(ctx, addr, s.tlscfg, s.qcfg)
+		}
+		return quic.DialAddr(ctx, addr, s.tlscfg, s.qcfg)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.enable0RTT {
+		return quic.DialEarly(ctx, pc, udpAddr, s.tlscfg, s.qcfg)
+	}
+	return quic.Dial(ctx, pc, udpAddr, s.tlscfg, s.qcfg)
+}
+
+// listenQuic starts the far listener on pc. The caller must hold
+// connectionsMu for reading.
+func (s *SalmonQuic) listenQuic(pc net.PacketConn) (quicListener, error) {
+	if s.enable0RTT {
+		return quic.ListenEarly(pc, s.tlscfg, s.qcfg)
+	}
+	return quic.Listen(pc, s.tlscfg, s.qcfg)
+}
+
+// finishHandshake waits for an early dialed connection's handshake. If the
+// far turned its 0-RTT data down, streams opened before then failed with
+// quic.Err0RTTRejected; afterwards the connection takes new ones again.
+func (s *SalmonQuic) finishHandshake(conn *quic.Conn) {
+	select {
+	case <-conn.HandshakeComplete():
+	case <-conn.Context().Done():
+		return
+	}
+	// Returns straight away now, and clears the rejected state if any
+	_, _ = conn.NextConnection(context.Background())
+	state := conn.ConnectionState()
+	switch {
+	case state.Used0RTT:
+		log.Printf("NEAR: Bridge %s resumed its session with 0-RTT", s.BridgeName)
+	case state.TLS.DidResume:
+		log.Printf("NEAR: Bridge %s resumed its session, far side declined 0-RTT", s.BridgeName)
+	}
+}
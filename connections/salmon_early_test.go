@@ -0,0 +1,90 @@
+package connections
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// echoStream opens a stream on client and checks one echo round trip,
+// returning the connection it went over.
+func echoStream(t *testing.T, client *SalmonQuic) *quicConnection {
+	t.Helper()
+	stream, cleanup, err, qconn := client.OpenStream()
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer cleanup()
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stream.Write([]byte("ping")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		t.Fatalf("echo failed: %v", err)
+	}
+	return qconn
+}
+
+func TestEnable0RTTResumesSession(t *testing.T) {
+	serverTLSConfig, err := generateTLSConfig()
+	if err != nil {
+		t.Fatalf("Failed to generate server TLS config: %v", err)
+	}
+	clientTLSConfig := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"quic-test"}}
+	qcfg := &quic.Config{MaxIdleTimeout: 5 * time.Second}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve UDP port: %v", err)
+	}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+
+	far := NewSalmonQuic(port, "", "early-far", serverTLSConfig, qcfg, "")
+	far.SetEnable0RTT(true)
+	done := make(chan error, 1)
+	go func() {
+		done <- far.NewFarListen(func(stream *quic.Stream) {
+			io.Copy(stream, stream)
+			stream.Close()
+		})
+	}()
+	defer func() {
+		far.StopFarListen(context.Background())
+		far.Close()
+		<-done
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewSalmonQuic(port, "127.0.0.1", "early-near", clientTLSConfig, qcfg, "")
+	defer client.Close()
+	client.SetEnable0RTT(true)
+	if clientTLSConfig.ClientSessionCache != nil {
+		t.Fatalf("expected the caller's TLS config to be left alone")
+	}
+
+	first := echoStream(t, client)
+	if first.conn.ConnectionState().TLS.DidResume {
+		t.Fatalf("expected the first dial to do a full handshake")
+	}
+	// The far sends its ticket after the handshake
+	time.Sleep(100 * time.Millisecond)
+	client.CloseConnection(first)
+
+	second := echoStream(t, client)
+	if second == first {
+		t.Fatalf("expected a new connection after the first was closed")
+	}
+	<-second.conn.HandshakeComplete()
+	state := second.conn.ConnectionState()
+	if !state.TLS.DidResume || !state.Used0RTT {
+		t.Fatalf("expected the re-dial to resume with 0-RTT, got resumed=%v 0-RTT=%v", state.TLS.DidResume, state.Used0RTT)
+	}
+}
@@ -0,0 +1,59 @@
+package connections
+
+import "log"
+
+// noteFarEpoch records the epoch qconn's control handshake reported. When it
+// differs from the last one seen for the same far address, the far was
+// restarted there, and every pooled connection that reached the old
+// instance is closed and dropped from the pool. Those may still look alive
+// until they time out, but streams sent over them would never reach the new
+// far, so new streams go over connections to the new instance instead.
+func (s *SalmonQuic) noteFarEpoch(qconn *quicConnection, epoch uint64) {
+	if epoch == 0 {
+		return
+	}
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+
+	qconn.mu.Lock()
+	if qconn.conn == nil {
+		qconn.mu.Unlock()
+		return
+	}
+	qconn.epoch = epoch
+	remote := qconn.conn.RemoteAddr().String()
+	qconn.mu.Unlock()
+
+	if s.farEpochs == nil {
+		s.farEpochs = make(map[string]uint64)
+	}
+	previous := s.farEpochs[remote]
+	s.farEpochs[remote] = epoch
+	if previous == 0 || previous == epoch {
+		return
+	}
+
+	kept := s.connections[:0]
+	stale := 0
+	for _, conn := range s.connections {
+		conn.mu.Lock()
+		old := conn.conn != nil && conn.epoch != 0 && conn.epoch != epoch &&
+			conn.conn.RemoteAddr().String() == remote
+		if old {
+			conn.closeLocked("far side restarted")
+		}
+		conn.mu.Unlock()
+		if old {
+			stale++
+			continue
+		}
+		kept = append(kept, conn)
+	}
+	// Clear the tail so closed connections can be collected
+	for i := len(kept); i < len(s.connections); i++ {
+		s.connections[i] = nil
+	}
+	s.connections = kept
+	log.Printf("NEAR: Bridge %s far side at %s restarted (epoch %016x, was %016x), closed %d connections to the old instance",
+		s.BridgeName, remote, epoch, previous, stale)
+}
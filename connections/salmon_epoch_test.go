@@ -0,0 +1,91 @@
+package connections
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// epochControl is a ControlChannel reporting a fixed far epoch.
+type epochControl struct {
+	epoch uint64
+}
+
+func (c *epochControl) Ping(timeout time.Duration) error { return nil }
+func (c *epochControl) Epoch() uint64                    { return c.epoch }
+func (c *epochControl) Close() error                     { return nil }
+
+func connEpoch(qconn *quicConnection) uint64 {
+	qconn.mu.Lock()
+	defer qconn.mu.Unlock()
+	return qconn.epoch
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFarRestartClosesStaleConnections(t *testing.T) {
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "epoch", clientTLSConfig, qcfg, "")
+	defer sq.Close()
+	sq.SetPoolLimits(3, 1, time.Minute)
+
+	// Each new connection's handshake reports the next epoch, as if the far
+	// restarted between the first and second dial
+	var mu sync.Mutex
+	epochs := []uint64{1, 2, 2}
+	sq.SetControl(func(stream *quic.Stream) (ControlChannel, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		epoch := epochs[0]
+		epochs = epochs[1:]
+		return &epochControl{epoch: epoch}, nil
+	})
+
+	stale, cleanup1, err, first := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	defer cleanup1()
+	waitFor(t, "the first epoch", func() bool { return connEpoch(first) == 1 })
+
+	stream2, cleanup2, err, second := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	defer cleanup2()
+	defer stream2.Close()
+	if second == first {
+		t.Fatalf("expected a second connection with one stream per connection")
+	}
+	waitFor(t, "the old instance's connection to close", func() bool { return !first.isAlive() })
+	if poolSize(sq) != 1 {
+		t.Fatalf("expected only the new instance's connection pooled, pool size %d", poolSize(sq))
+	}
+	// Data for the old instance is dropped rather than sent
+	if _, err := stale.Write([]byte("stale")); err == nil {
+		t.Fatalf("expected writes on the old instance's stream to fail")
+	}
+
+	// Another connection to the same instance leaves the pool alone
+	stream3, cleanup3, err, third := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	defer cleanup3()
+	defer stream3.Close()
+	waitFor(t, "the third epoch", func() bool { return connEpoch(third) == 2 })
+	if !second.isAlive() || poolSize(sq) != 2 {
+		t.Fatalf("expected both connections to the new instance pooled, pool size %d", poolSize(sq))
+	}
+}
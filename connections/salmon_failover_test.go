@@ -0,0 +1,106 @@
+package connections
+
+import (
+	"fmt"
+	"net"
+	"salmoncannon/status"
+	"testing"
+	"time"
+)
+
+// closedUDPPort returns a local UDP port with nothing listening on it.
+func closedUDPPort(t *testing.T) int {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve UDP port: %v", err)
+	}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+	return port
+}
+
+func TestEndpointAddr(t *testing.T) {
+	sq := NewSalmonQuic(5000, "127.0.0.1", "endpoint-addr", nil, nil, "")
+	if got := sq.endpointAddr("10.0.0.1"); got != "10.0.0.1:5000" {
+		t.Errorf("expected bridge port to be appended, got %s", got)
+	}
+	if got := sq.endpointAddr("10.0.0.1:6000"); got != "10.0.0.1:6000" {
+		t.Errorf("expected explicit port to be kept, got %s", got)
+	}
+	if got := sq.endpointAddr("::1"); got != "[::1]:5000" {
+		t.Errorf("expected IPv6 host to be bracketed, got %s", got)
+	}
+}
+
+func TestFailoverWhenPrimaryRefuses(t *testing.T) {
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	primary := fmt.Sprintf("127.0.0.1:%d", closedUDPPort(t))
+	secondary := fmt.Sprintf("127.0.0.1:%d", port)
+
+	sq := NewSalmonQuic(port, "127.0.0.1", "failover-refused", clientTLSConfig, qcfg, "")
+	sq.dialTimeout = 500 * time.Millisecond
+	sq.SetFarEndpoints([]string{primary, secondary})
+	defer sq.Close()
+
+	if got := sq.ActiveEndpoint(); got != primary {
+		t.Fatalf("expected primary to start active, got %s", got)
+	}
+
+	stream, cleanup, err, _ := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("expected failover to secondary, got error: %v", err)
+	}
+	stream.Close()
+	cleanup()
+
+	if got := sq.ActiveEndpoint(); got != secondary {
+		t.Errorf("expected secondary to be active, got %s", got)
+	}
+	if got := status.GlobalConnMonitorRef.GetEndpoint("failover-refused"); got != secondary {
+		t.Errorf("expected status monitor to report %s, got %s", secondary, got)
+	}
+}
+
+func TestFailoverOnConnectionDeath(t *testing.T) {
+	primaryListener, clientTLSConfig, qcfg := startDiscardListener(t)
+	primaryPort := primaryListener.Addr().(*net.UDPAddr).Port
+	secondaryPort, _, _ := startDiscardServer(t)
+	primary := fmt.Sprintf("127.0.0.1:%d", primaryPort)
+	secondary := fmt.Sprintf("127.0.0.1:%d", secondaryPort)
+
+	sq := NewSalmonQuic(primaryPort, "127.0.0.1", "failover-death", clientTLSConfig, qcfg, "")
+	sq.dialTimeout = 500 * time.Millisecond
+	sq.SetFarEndpoints([]string{primary, secondary})
+	defer sq.Close()
+
+	stream, cleanup, err, _ := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	stream.Close()
+	cleanup()
+	if got := sq.ActiveEndpoint(); got != primary {
+		t.Fatalf("expected primary to be active, got %s", got)
+	}
+
+	// Kill the primary: its connection dies and it no longer answers dials
+	primaryListener.Close()
+	deadline := time.Now().Add(3 * time.Second)
+	for poolSize(sq) > 0 && time.Now().Before(deadline) {
+		sq.connectionsMu.Lock()
+		sq.evictDeadConnectionsLocked()
+		sq.connectionsMu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	stream, cleanup, err, _ = sq.OpenStream()
+	if err != nil {
+		t.Fatalf("expected failover to secondary, got error: %v", err)
+	}
+	stream.Close()
+	cleanup()
+	if got := sq.ActiveEndpoint(); got != secondary {
+		t.Errorf("expected secondary to be active, got %s", got)
+	}
+}
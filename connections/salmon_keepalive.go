@@ -0,0 +1,92 @@
+package connections
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// KeepalivePing performs one round trip on a freshly opened stream and
+// returns an error if the far side did not answer in time.
+type KeepalivePing func(stream *quic.Stream) error
+
+// SetKeepalive starts pinging every pooled connection each interval. A
+// connection whose ping fails maxFailures times in a row is evicted so new
+// streams re-dial. This catches half-open connections that the QUIC idle
+// timeout would take much longer to notice. An interval <= 0 disables it.
+// Only the first call starts the loop.
+func (s *SalmonQuic) SetKeepalive(interval time.Duration, maxFailures int, ping KeepalivePing) {
+	if interval <= 0 || ping == nil {
+		return
+	}
+	if maxFailures <= 0 {
+		maxFailures = 1
+	}
+	s.keepaliveOnce.Do(func() {
+		go s.keepaliveLoop(interval, maxFailures, ping)
+	})
+}
+
+func (s *SalmonQuic) keepaliveLoop(interval time.Duration, maxFailures int, ping KeepalivePing) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		s.connectionsMu.RLock()
+		conns := make([]*quicConnection, len(s.connections))
+		copy(conns, s.connections)
+		s.connectionsMu.RUnlock()
+
+		for _, qconn := range conns {
+			err := s.pingConnection(qconn, interval, ping)
+			if err == nil {
+				qconn.pingFailures = 0
+				continue
+			}
+			qconn.pingFailures++
+			log.Printf("NEAR: Bridge %s keepalive ping failed (%d/%d): %v", s.BridgeName, qconn.pingFailures, maxFailures, err)
+			if qconn.pingFailures >= maxFailures {
+				log.Printf("NEAR: Bridge %s evicting dead connection (active streams: %d)", s.BridgeName, atomic.LoadInt32(&qconn.activeStreams))
+				s.CloseConnection(qconn)
+			}
+		}
+	}
+}
+
+// pingConnection pings over qconn's control stream if it has one. Otherwise
+// it opens a stream directly on qconn, bypassing the pool's stream
+// accounting and the bandwidth limiter, and runs ping over it.
+func (s *SalmonQuic) pingConnection(qconn *quicConnection, timeout time.Duration, ping KeepalivePing) error {
+	if control := qconn.controlChannel(); control != nil {
+		return control.Ping(timeout)
+	}
+	qconn.mu.Lock()
+	conn := qconn.conn
+	qconn.mu.Unlock()
+	if conn == nil {
+		return fmt.Errorf("connection is closed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(timeout))
+	if err := ping(stream); err != nil {
+		stream.CancelRead(0)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package connections
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+func poolSize(sq *SalmonQuic) int {
+	sq.connectionsMu.RLock()
+	defer sq.connectionsMu.RUnlock()
+	return len(sq.connections)
+}
+
+func TestKeepaliveKeepsHealthyConnection(t *testing.T) {
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "keepalive-ok", clientTLSConfig, qcfg, "")
+	defer sq.Close()
+
+	var pings atomic.Int32
+	sq.SetKeepalive(50*time.Millisecond, 2, func(stream *quic.Stream) error {
+		pings.Add(1)
+		return nil
+	})
+
+	stream, cleanup, err, _ := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	stream.Close()
+	cleanup()
+
+	time.Sleep(300 * time.Millisecond)
+	if pings.Load() < 2 {
+		t.Errorf("expected several keepalive pings, got %d", pings.Load())
+	}
+	if poolSize(sq) != 1 {
+		t.Errorf("expected healthy connection to stay pooled, pool size %d", poolSize(sq))
+	}
+}
+
+func TestKeepaliveEvictsDeadConnection(t *testing.T) {
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "keepalive-dead", clientTLSConfig, qcfg, "")
+	defer sq.Close()
+
+	// The discard server never answers, so a real ping would time out
+	sq.SetKeepalive(50*time.Millisecond, 3, func(stream *quic.Stream) error {
+		buf := make([]byte, 1)
+		if _, err := stream.Read(buf); err != nil {
+			return err
+		}
+		return errors.New("unexpected reply")
+	})
+
+	stream, cleanup, err, _ := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	defer cleanup()
+	defer stream.Close()
+
+	time.Sleep(120 * time.Millisecond)
+	if poolSize(sq) != 1 {
+		t.Fatalf("expected connection to survive until the failure threshold, pool size %d", poolSize(sq))
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for poolSize(sq) != 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if poolSize(sq) != 0 {
+		t.Fatalf("expected dead connection to be evicted")
+	}
+
+	// New streams re-dial
+	stream2, cleanup2, err, _ := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream after eviction failed: %v", err)
+	}
+	stream2.Close()
+	cleanup2()
+}
+
+// countingControl is a ControlChannel that counts its pings.
+type countingControl struct {
+	pings  atomic.Int32
+	closed atomic.Bool
+}
+
+func (c *countingControl) Ping(timeout time.Duration) error {
+	c.pings.Add(1)
+	return nil
+}
+
+func (c *countingControl) Epoch() uint64 {
+	return 0
+}
+
+func (c *countingControl) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func TestKeepaliveUsesControlChannel(t *testing.T) {
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "keepalive-control", clientTLSConfig, qcfg, "")
+
+	control := &countingControl{}
+	sq.SetControl(func(stream *quic.Stream) (ControlChannel, error) {
+		return control, nil
+	})
+	var streamPings atomic.Int32
+	sq.SetKeepalive(50*time.Millisecond, 2, func(stream *quic.Stream) error {
+		streamPings.Add(1)
+		return nil
+	})
+
+	stream, cleanup, err, _ := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	stream.Close()
+	cleanup()
+
+	time.Sleep(300 * time.Millisecond)
+	if control.pings.Load() < 2 {
+		t.Errorf("expected keepalives over the control channel, got %d", control.pings.Load())
+	}
+	if streamPings.Load() > 1 {
+		t.Errorf("expected no stream pings once the control channel is up, got %d", streamPings.Load())
+	}
+
+	sq.Close()
+	if !control.closed.Load() {
+		t.Errorf("expected the control channel to close with its connection")
+	}
+}
+
+func TestKeepaliveFallsBackWithoutControl(t *testing.T) {
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "keepalive-nocontrol", clientTLSConfig, qcfg, "")
+	defer sq.Close()
+
+	// A far without control streams fails the handshake
+	sq.SetControl(func(stream *quic.Stream) (ControlChannel, error) {
+		return nil, errors.New("stream reset")
+	})
+	var streamPings atomic.Int32
+	sq.SetKeepalive(50*time.Millisecond, 2, func(stream *quic.Stream) error {
+		streamPings.Add(1)
+		return nil
+	})
+
+	stream, cleanup, err, _ := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	stream.Close()
+	cleanup()
+
+	time.Sleep(300 * time.Millisecond)
+	if streamPings.Load() < 2 {
+		t.Errorf("expected keepalives on streams of their own, got %d", streamPings.Load())
+	}
+	if poolSize(sq) != 1 {
+		t.Errorf("expected the connection to stay pooled, pool size %d", poolSize(sq))
+	}
+}
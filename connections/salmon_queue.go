@@ -0,0 +1,88 @@
+package connections
+
+import (
+	"errors"
+	"fmt"
+	"salmoncannon/status"
+	"time"
+)
+
+// errPoolSaturated is returned by selectConnection when every connection is
+// at its stream limit and no more may be dialed.
+var errPoolSaturated = errors.New("all connections are at maximum stream capacity")
+
+// SetStreamQueue sets how many OpenStream calls may wait for a stream slot
+// while the pool is saturated, and how long each waits before it fails.
+// Calls beyond depth fail straight away; a depth of 0 turns the queue off.
+// A timeout <= 0 keeps the current setting.
+func (s *SalmonQuic) SetStreamQueue(depth int, timeout time.Duration) {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	s.queueDepth = max(depth, 0)
+	if timeout > 0 {
+		s.queueTimeout = timeout
+	}
+}
+
+// slotFreed wakes every caller queued in selectConnectionQueued so they try
+// the pool again. It is called when a stream closes, a connection leaves
+// the pool or the pool limits change.
+func (s *SalmonQuic) slotFreed() {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	if s.queueWaiting > 0 {
+		close(s.queueWake)
+		s.queueWake = make(chan struct{})
+	}
+}
+
+// selectConnectionQueued is selectConnection, except that when the pool is
+// saturated the caller queues for a stream slot to free, up to the queue
+// timeout, rather than failing straight away.
+func (s *SalmonQuic) selectConnectionQueued(skip []*quicConnection) (*quicConnection, error) {
+	qconn, err := s.selectConnection(skip)
+	if !errors.Is(err, errPoolSaturated) {
+		return qconn, err
+	}
+
+	s.queueMu.Lock()
+	if s.queueWaiting >= s.queueDepth {
+		s.queueMu.Unlock()
+		status.GlobalConnMonitorRef.IncPoolSaturated(s.BridgeName)
+		if s.queueDepth > 0 {
+			return nil, fmt.Errorf("%w and %d streams are already queued", err, s.queueDepth)
+		}
+		return nil, err
+	}
+	s.queueWaiting++
+	timeout := s.queueTimeout
+	s.queueMu.Unlock()
+	defer func() {
+		s.queueMu.Lock()
+		s.queueWaiting--
+		s.queueMu.Unlock()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		// Take the wake channel before trying again so a slot freed in
+		// between is not missed
+		s.queueMu.Lock()
+		wake := s.queueWake
+		s.queueMu.Unlock()
+
+		qconn, err = s.selectConnection(skip)
+		if !errors.Is(err, errPoolSaturated) {
+			return qconn, err
+		}
+		select {
+		case <-wake:
+		case <-timer.C:
+			status.GlobalConnMonitorRef.IncPoolSaturated(s.BridgeName)
+			return nil, fmt.Errorf("%w after waiting %v for a stream to close", err, timeout)
+		case <-s.done:
+			return nil, fmt.Errorf("bridge %s is closed", s.BridgeName)
+		}
+	}
+}
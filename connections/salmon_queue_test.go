@@ -0,0 +1,111 @@
+package connections
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"salmoncannon/status"
+)
+
+// A stream opened on a saturated pool waits in the queue and gets the slot
+// of a stream that closes.
+func TestStreamQueueWaitsForFreedSlot(t *testing.T) {
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "queue-free", clientTLSConfig, qcfg, "")
+	defer sq.Close()
+	sq.SetPoolLimits(1, 1, time.Minute)
+	sq.SetStreamQueue(1, 5*time.Second)
+
+	stream, cleanup, err, _ := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("first stream: %v", err)
+	}
+	stream.Write([]byte("x"))
+
+	type result struct {
+		cleanup func()
+		err     error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		_, cleanup, err, _ := sq.OpenStream()
+		opened <- result{cleanup, err}
+	}()
+
+	select {
+	case r := <-opened:
+		t.Fatalf("expected the second stream to wait for a slot, got err %v", r.err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	stream.Close()
+	cleanup()
+
+	select {
+	case r := <-opened:
+		if r.err != nil {
+			t.Fatalf("expected the queued stream to open once a slot freed, got %v", r.err)
+		}
+		r.cleanup()
+	case <-time.After(3 * time.Second):
+		t.Fatal("queued stream was not woken when a stream closed")
+	}
+	if got := status.GlobalConnMonitorRef.Rejections("queue-free").PoolSaturated; got != 0 {
+		t.Errorf("expected no saturated opens, got %d", got)
+	}
+}
+
+// A queued stream fails once the queue timeout passes without a slot
+// freeing, and streams beyond the queue depth fail straight away.
+func TestStreamQueueTimesOutAndFillsUp(t *testing.T) {
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "queue-full", clientTLSConfig, qcfg, "")
+	defer sq.Close()
+	sq.SetPoolLimits(1, 1, time.Minute)
+	sq.SetStreamQueue(1, 500*time.Millisecond)
+
+	stream, cleanup, err, _ := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("first stream: %v", err)
+	}
+	defer cleanup()
+	stream.Write([]byte("x"))
+
+	queued := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		_, _, err, _ := sq.OpenStream()
+		queued <- err
+	}()
+
+	// Wait for the first caller to take the only queue place
+	deadline := time.Now().Add(time.Second)
+	for {
+		sq.queueMu.Lock()
+		waiting := sq.queueWaiting
+		sq.queueMu.Unlock()
+		if waiting == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("second stream never queued")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	_, _, err, _ = sq.OpenStream()
+	if err == nil || !strings.Contains(err.Error(), "already queued") {
+		t.Fatalf("expected a full queue to fail straight away, got %v", err)
+	}
+
+	err = <-queued
+	if err == nil || !strings.Contains(err.Error(), "after waiting") {
+		t.Fatalf("expected the queued stream to time out, got %v", err)
+	}
+	if waited := time.Since(start); waited < 500*time.Millisecond {
+		t.Errorf("queued stream gave up after %v, before its timeout", waited)
+	}
+	if got := status.GlobalConnMonitorRef.Rejections("queue-full").PoolSaturated; got != 2 {
+		t.Errorf("expected 2 saturated opens, got %d", got)
+	}
+}
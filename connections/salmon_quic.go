@@ -3,14 +3,17 @@ package connections
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"runtime"
 	"salmoncannon/status"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
-	"syscall"
 	"time"
 
 	"github.com/quic-go/quic-go"
@@ -21,7 +24,21 @@ type quicConnection struct {
 	pconn         net.PacketConn
 	activeStreams int32 // atomic counter
 	createdAt     time.Time
+	lastUsed      atomic.Int64 // unix nanos of the last stream open or close
+	streamLimit   atomic.Int32 // far side's stream limit once hit, 0 until then
 	mu            sync.Mutex
+
+	// Over the pool's connection cap after it was lowered: takes no new
+	// streams and is closed once its last one ends, guarded by the pool's
+	// connectionsMu
+	draining bool
+
+	pingFailures int // consecutive failed keepalive pings, owned by keepaliveLoop
+
+	control ControlChannel // nil until the control handshake is done, guarded by mu
+	epoch   uint64         // far instance reached, 0 until known, guarded by mu
+
+	bytes ByteCounter // stream payload moved over the connection, see Stats
 }
 
 type SalmonQuic struct {
@@ -35,44 +52,187 @@ type SalmonQuic struct {
 	tlscfg        *tls.Config
 	interfaceName string
 	cleanupOnce   sync.Once
+	backoff       dialBackoff // guarded by connectionsMu
+
+	// Far endpoints in priority order and the index of the one new
+	// connections dial first, guarded by connectionsMu
+	endpoints      []string
+	activeEndpoint int
+
+	// Dial and stream open timeouts, guarded by connectionsMu
+	dialTimeout       time.Duration
+	streamOpenTimeout time.Duration
+
+	streamOpenAttempts int // see SetStreamOpenAttempts, guarded by connectionsMu
+
+	// Far listener UDP sockets and their network, see SetListenSockets and
+	// SetListenNetwork, guarded by connectionsMu
+	listenSockets int
+	listenNetwork string
+
+	enable0RTT bool // see SetEnable0RTT, guarded by connectionsMu
+
+	// UDP socket buffer sizes, see SetUDPBuffers, guarded by connectionsMu
+	udpReceiveBuffer int
+	udpSendBuffer    int
+	udpBuffersLogged sync.Once
+
+	// Pool sizing, guarded by connectionsMu
+	maxConnections int
+	maxStreams     int32
+	idleTimeout    time.Duration
+
+	// Callers waiting for a stream slot on a saturated pool, see
+	// SetStreamQueue, guarded by queueMu
+	queueMu      sync.Mutex
+	queueDepth   int
+	queueTimeout time.Duration
+	queueWaiting int
+	queueWake    chan struct{} // closed and replaced by slotFreed
+
+	// Far side listener state, guarded by connectionsMu
+	far      *farListener // nil when not listening
+	closed   bool
+	draining bool // no new connections or far streams, guarded by connectionsMu
+
+	farStreams         atomic.Int32 // far side streams being handled
+	detachedFarStreams sync.Map     // *quic.Stream -> struct{}, see DetachFarStream
+
+	controlHandshake ControlHandshake // see SetControl, guarded by connectionsMu
+
+	farEpochs map[string]uint64 // newest epoch seen per far address, see noteFarEpoch, guarded by connectionsMu
+
+	streamCounters sync.Map // *quic.Stream -> its connection's *ByteCounter, see StreamCounter
+
+	// Datagram flows, see StreamDatagrams
+	datagramMuxes  sync.Map // *quic.Conn -> *datagramMux
+	farStreamConns sync.Map // far side *quic.Stream -> its *quic.Conn while handled
+
+	done          chan struct{} // closed by Close
+	keepaliveOnce sync.Once
+
+	// Cancelled by Shutdown and Close so a dial in flight does not hold
+	// connectionsMu until dialTimeout
+	dialCtx    context.Context
+	dialCancel context.CancelFunc
 }
 
 func NewSalmonQuic(port int, address string, name string, tlscfg *tls.Config,
 	qcfg *quic.Config, interfaceName string) *SalmonQuic {
 	sq := &SalmonQuic{
-		BridgeName:    name,
-		BridgeAddress: address,
-		BridgePort:    port,
-		tlscfg:        tlscfg,
-		qcfg:          qcfg,
-		interfaceName: interfaceName,
-		connections:   make([]*quicConnection, 0, MaxConnectionsPerBridge),
+		BridgeName:     name,
+		BridgeAddress:  address,
+		BridgePort:     port,
+		tlscfg:         tlscfg,
+		qcfg:           qcfg,
+		interfaceName:  interfaceName,
+		connections:    make([]*quicConnection, 0, MaxConnectionsPerBridge),
+		maxConnections: MaxConnectionsPerBridge,
+		maxStreams:     MaxStreamsPerConnection,
+		idleTimeout:    ConnectionIdleTimeout,
+		backoff: dialBackoff{
+			min: DefaultReconnectBackoffMin,
+			max: DefaultReconnectBackoffMax,
+		},
+		endpoints:          []string{address},
+		dialTimeout:        DialTimeout,
+		streamOpenTimeout:  StreamOpenTimeout,
+		streamOpenAttempts: StreamOpenAttempts,
+		queueDepth:         StreamQueueDepth,
+		queueTimeout:       StreamQueueTimeout,
+		queueWake:          make(chan struct{}),
+		done:               make(chan struct{}),
+		listenSockets:      1,
+		listenNetwork:      "udp",
 	}
+	sq.dialCtx, sq.dialCancel = context.WithCancel(context.Background())
 	// Reset the stream map for this bridge
 	status.GlobalConnMonitorRef.ResetStreamCount(name)
-
-	// Start cleanup goroutine
-	// sq.cleanupOnce.Do(func() {
-	// 	go sq.connectionCleanupLoop()
-	// })
+	status.GlobalConnMonitorRef.RegisterConnStats(name, sq)
 	return sq
 }
 
-func listenPacketOnInterface(network, ifname string) (net.PacketConn, error) {
+// SetReconnectBackoff sets the bounds of the exponential delay applied between
+// failed dials to the far side. A min of 0 disables backoff.
+func (s *SalmonQuic) SetReconnectBackoff(min, max time.Duration) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	s.backoff.min = min
+	s.backoff.max = max
+}
+
+// SetFarEndpoints sets the far hosts ("host" or "host:port") to dial, in
+// priority order. New connections go to the endpoint that last answered;
+// when it cannot be reached the next one in the list is tried. An empty
+// list is ignored.
+func (s *SalmonQuic) SetFarEndpoints(addresses []string) {
+	if len(addresses) == 0 {
+		return
+	}
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	s.endpoints = slices.Clone(addresses)
+	s.activeEndpoint = 0
+	status.GlobalConnMonitorRef.RegisterEndpoint(s.BridgeName, s.endpoints[0])
+}
+
+// ActiveEndpoint returns the far host new connections are dialed to.
+func (s *SalmonQuic) ActiveEndpoint() string {
+	s.connectionsMu.RLock()
+	defer s.connectionsMu.RUnlock()
+	return s.endpoints[s.activeEndpoint]
+}
+
+// SetTimeouts sets how long dialing a new QUIC connection to the far side
+// and opening a stream on a pooled connection may take. Values <= 0 keep
+// the current setting.
+func (s *SalmonQuic) SetTimeouts(dialTimeout, streamOpenTimeout time.Duration) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	if dialTimeout > 0 {
+		s.dialTimeout = dialTimeout
+	}
+	if streamOpenTimeout > 0 {
+		s.streamOpenTimeout = streamOpenTimeout
+	}
+}
+
+// SetStreamOpenAttempts sets how many connections OpenStream tries before
+// it fails the stream. A connection that fails to open one is closed and
+// the next attempt goes to another pooled connection or a fresh dial.
+// Values <= 0 keep the current setting.
+func (s *SalmonQuic) SetStreamOpenAttempts(attempts int) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	if attempts > 0 {
+		s.streamOpenAttempts = attempts
+	}
+}
+
+// SetPoolLimits sets how many QUIC connections this bridge may hold, how
+// many streams each may carry and the idle timeout. Values <= 0 keep the
+// current setting. Lowering maxConnections below the connections already
+// open drains the excess rather than cutting their streams off.
+func (s *SalmonQuic) SetPoolLimits(maxConnections int, maxStreams int32, idleTimeout time.Duration) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	if maxConnections > 0 {
+		s.maxConnections = maxConnections
+		s.rebalanceDrainingLocked()
+	}
+	if maxStreams > 0 {
+		s.maxStreams = maxStreams
+	}
+	if idleTimeout > 0 {
+		s.idleTimeout = idleTimeout
+	}
+	s.slotFreed()
+}
+
+func listenPacketOnInterface(network, ifname string, receive, send int) (net.PacketConn, error) {
 	// Platform-specific SO_BINDTODEVICE first (only supported on Linux)
 	if runtime.GOOS == "linux" {
-		lc := net.ListenConfig{
-			Control: func(network, address string, c syscall.RawConn) error {
-				var serr error
-				if err := c.Control(func(fd uintptr) {
-					serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
-				}); err != nil {
-					// RawConn.Control returned an error
-					return err
-				}
-				return serr
-			},
-		}
+		lc := net.ListenConfig{Control: listenControl(ifname, false, receive, send)}
 		pc, err := lc.ListenPacket(context.Background(), network, "0.0.0.0:0")
 		if err == nil {
 			return pc, nil
@@ -81,23 +241,12 @@ func listenPacketOnInterface(network, ifname string) (net.PacketConn, error) {
 	return nil, fmt.Errorf("no usable address found on interface %s", ifname)
 }
 
-func listenPacketOnInterfaceForListen(network, ifname string, port int) (net.PacketConn, error) {
+func listenPacketOnInterfaceForListen(network, ifname string, port int, reusePort bool, receive, send int) (net.PacketConn, error) {
 	addr := fmt.Sprintf(":%d", port)
 
 	// Linux SO_BINDTODEVICE — binds the socket to the interface itself.
 	if runtime.GOOS == "linux" {
-		lc := net.ListenConfig{
-			Control: func(_network, _address string, c syscall.RawConn) error {
-				var serr error
-				if err := c.Control(func(fd uintptr) {
-					serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
-				}); err != nil {
-					// RawConn.Control returned an error
-					return err
-				}
-				return serr
-			},
-		}
+		lc := net.ListenConfig{Control: listenControl(ifname, reusePort, receive, send)}
 		if pc, err := lc.ListenPacket(context.Background(), network, addr); err == nil {
 			return pc, nil
 		}
@@ -105,11 +254,34 @@ func listenPacketOnInterfaceForListen(network, ifname string, port int) (net.Pac
 	return nil, fmt.Errorf("no usable address found on interface %s", ifname)
 }
 
-// createNewConnection creates a new QUIC connection
-func (s *SalmonQuic) createNewConnection(ctx context.Context) (*quicConnection, error) {
-	addr := fmt.Sprintf("%s:%d", s.BridgeAddress, s.BridgePort)
+// endpointAddr returns the host:port to dial for an endpoint. Endpoints
+// without a port use BridgePort.
+func (s *SalmonQuic) endpointAddr(endpoint string) string {
+	if _, _, err := net.SplitHostPort(endpoint); err == nil {
+		return endpoint
+	}
+	return net.JoinHostPort(endpoint, strconv.Itoa(s.BridgePort))
+}
+
+// tlsAlertNoApplicationProtocol is the QUIC error code of a TLS
+// no_application_protocol alert, sent when the ALPNs have nothing in common.
+const tlsAlertNoApplicationProtocol = quic.TransportErrorCode(0x100 + 120)
+
+// explainDialError points at SBAlpn when the far refused the handshake
+// because the two sides negotiate different ALPNs.
+func (s *SalmonQuic) explainDialError(err error) error {
+	var transportErr *quic.TransportError
+	if errors.As(err, &transportErr) && transportErr.ErrorCode == tlsAlertNoApplicationProtocol {
+		return fmt.Errorf("%w (far side does not accept ALPN %q, SBAlpn must match on both sides)", err, strings.Join(s.tlscfg.NextProtos, ","))
+	}
+	return err
+}
+
+// createNewConnection creates a new QUIC connection to endpoint
+func (s *SalmonQuic) createNewConnection(ctx context.Context, endpoint string) (*quicConnection, error) {
+	addr := s.endpointAddr(endpoint)
 
-	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	dialCtx, cancel := context.WithTimeout(ctx, s.dialTimeout)
 	defer cancel()
 
 	var qc *quic.Conn
@@ -119,98 +291,234 @@ func (s *SalmonQuic) createNewConnection(ctx context.Context) (*quicConnection,
 	// If an interface name is provided, create a PacketConn bound to that interface
 	// Only supported on Linux via SO_BINDTODEVICE
 	if s.interfaceName != "" {
-		pc, err = listenPacketOnInterface("udp", s.interfaceName)
+		pc, err = listenPacketOnInterface("udp", s.interfaceName, s.udpReceiveBuffer, s.udpSendBuffer)
 		if err != nil {
 			return nil, fmt.Errorf("bind to interface %q: %w", s.interfaceName, err)
 		}
 
-		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		qc, err = s.dialQuic(dialCtx, pc, addr)
 		if err != nil {
 			_ = pc.Close()
-			return nil, fmt.Errorf("resolve UDP addr %s: %w", addr, err)
-		}
-		qc, err = quic.Dial(dialCtx, pc, udpAddr, s.tlscfg, s.qcfg)
-		if err != nil {
-			_ = pc.Close()
-			return nil, fmt.Errorf("dial QUIC %s via interface %s: %w", addr, s.interfaceName, err)
+			return nil, fmt.Errorf("dial QUIC %s via interface %s: %w", addr, s.interfaceName, s.explainDialError(err))
 		}
 
-		log.Printf("NEAR: New QUIC bridge for %s connected to far host %s:%d via interface %s", s.BridgeName, s.BridgeAddress, s.BridgePort, s.interfaceName)
+		log.Printf("NEAR: New QUIC bridge for %s connected to far host %s via interface %s", s.BridgeName, addr, s.interfaceName)
 	} else {
-		// Default: dial without binding to a specific interface
-		qc, err = quic.DialAddr(dialCtx, addr, s.tlscfg, s.qcfg)
+		// Default: dial without binding to a specific interface, from a
+		// socket of our own only when its buffers need setting
+		if s.udpReceiveBuffer > 0 || s.udpSendBuffer > 0 {
+			pc, err = listenPacketWithBuffers(s.udpReceiveBuffer, s.udpSendBuffer)
+			if err != nil {
+				return nil, fmt.Errorf("open UDP socket: %w", err)
+			}
+		}
+		qc, err = s.dialQuic(dialCtx, pc, addr)
 		if err != nil {
-			return nil, fmt.Errorf("dial QUIC %s: %w", addr, err)
+			if pc != nil {
+				_ = pc.Close()
+			}
+			return nil, fmt.Errorf("dial QUIC %s: %w", addr, s.explainDialError(err))
 		}
 
-		log.Printf("NEAR: New QUIC bridge for %s connected to far host %s:%d", s.BridgeName, s.BridgeAddress, s.BridgePort)
+		log.Printf("NEAR: New QUIC bridge for %s connected to far host %s", s.BridgeName, addr)
 	}
 
+	s.logUDPBuffers("NEAR", pc, s.udpReceiveBuffer, s.udpSendBuffer)
+
 	qconnection := &quicConnection{
 		conn:          qc,
 		pconn:         pc,
 		activeStreams: 0,
 		createdAt:     time.Now(),
 	}
+	qconnection.lastUsed.Store(qconnection.createdAt.UnixNano())
+	if s.enable0RTT {
+		go s.finishHandshake(qc)
+	}
 
 	return qconnection, nil
 }
 
-// selectConnection finds a suitable connection or creates a new one
-func (s *SalmonQuic) selectConnection() (*quicConnection, error) {
+// isAlive reports whether the underlying QUIC connection is still usable.
+// A connection whose context is done has been closed by either side (peer
+// restart, idle timeout, stateless reset) and will never open another stream.
+func (qc *quicConnection) isAlive() bool {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	return qc.conn != nil && qc.conn.Context().Err() == nil
+}
+
+// closeLocked tears down the QUIC connection and its packet conn.
+// The caller must hold qc.mu.
+func (qc *quicConnection) closeLocked(reason string) {
+	if qc.control != nil {
+		_ = qc.control.Close()
+		qc.control = nil
+	}
+	if qc.conn != nil {
+		_ = qc.conn.CloseWithError(0, reason)
+		qc.conn = nil
+	}
+	if qc.pconn != nil {
+		_ = qc.pconn.Close()
+		qc.pconn = nil
+	}
+}
+
+// evictDeadConnectionsLocked removes every dead connection from the pool.
+// The caller must hold connectionsMu.
+func (s *SalmonQuic) evictDeadConnectionsLocked() {
+	alive := s.connections[:0]
+	for _, conn := range s.connections {
+		if conn.isAlive() {
+			alive = append(alive, conn)
+			continue
+		}
+		conn.mu.Lock()
+		conn.closeLocked("stale connection")
+		conn.mu.Unlock()
+		log.Printf("NEAR: Evicted stale connection for %s (active streams: %d)", s.BridgeName, atomic.LoadInt32(&conn.activeStreams))
+	}
+	// Clear the tail so evicted connections can be collected
+	for i := len(alive); i < len(s.connections); i++ {
+		s.connections[i] = nil
+	}
+	s.connections = alive
+}
+
+// rebalanceDrainingLocked marks the least busy connections over
+// maxConnections as draining, or takes connections back out of draining if
+// the cap went up again. The caller must hold connectionsMu.
+func (s *SalmonQuic) rebalanceDrainingLocked() {
+	byLoad := slices.Clone(s.connections)
+	slices.SortStableFunc(byLoad, func(a, b *quicConnection) int {
+		return int(atomic.LoadInt32(&b.activeStreams) - atomic.LoadInt32(&a.activeStreams))
+	})
+	for i, conn := range byLoad {
+		draining := i >= s.maxConnections
+		if draining && !conn.draining {
+			log.Printf("NEAR: Bridge %s draining a connection over the %d connection cap (active streams: %d)",
+				s.BridgeName, s.maxConnections, atomic.LoadInt32(&conn.activeStreams))
+		}
+		conn.draining = draining
+	}
+}
+
+// selectConnection finds a suitable connection or creates a new one.
+// Connections in skip are passed over.
+func (s *SalmonQuic) selectConnection(skip []*quicConnection) (*quicConnection, error) {
 	s.connectionsMu.Lock()
 	defer s.connectionsMu.Unlock()
 
-	// Can we to create a new connection
-	if len(s.connections) < MaxConnectionsPerBridge {
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-		defer cancel()
+	if s.closed {
+		return nil, fmt.Errorf("bridge %s is closed", s.BridgeName)
+	}
+	if s.draining {
+		return nil, fmt.Errorf("bridge %s is shutting down", s.BridgeName)
+	}
 
-		newConnection, err := s.createNewConnection(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create new connection: %w", err)
-		}
+	// Drop connections the far side has already closed so they don't
+	// count against maxConnections
+	s.evictDeadConnectionsLocked()
 
-		s.connections = append(s.connections, newConnection)
-		status.GlobalConnMonitorRef.AddStream(s.BridgeName)
-		log.Printf("NEAR: Created new connection (total: %d/%d) for %s", len(s.connections), MaxConnectionsPerBridge, s.BridgeName)
-		return newConnection, nil
-	} else {
-		// Find the connection with the least number of active streams
-		var selected *quicConnection
-		var minStreams int32 = MaxStreamsPerConnection
-		for _, conn := range s.connections {
-			activeStreams := atomic.LoadInt32(&conn.activeStreams)
-			if activeStreams < MaxStreamsPerConnection && activeStreams < minStreams {
-				selected = conn
-				minStreams = activeStreams
+	// Can we to create a new connection. Draining ones are on their way
+	// out and do not count towards the cap.
+	serving := 0
+	for _, conn := range s.connections {
+		if !conn.draining {
+			serving++
+		}
+	}
+	if serving < s.maxConnections {
+		wait := s.backoff.remaining()
+		if wait > 0 && len(s.connections) == 0 {
+			// Far side recently refused us and there is nothing else to use
+			return nil, fmt.Errorf("far side unreachable, next dial in %v", wait.Round(time.Millisecond))
+		}
+		if wait == 0 {
+			newConnection, err := s.dialEndpointsLocked()
+			if err != nil {
+				delay := s.backoff.failure()
+				log.Printf("NEAR: Bridge %s dial failed %d time(s) in a row, backing off %v", s.BridgeName, s.backoff.failures, delay.Round(time.Millisecond))
+				if len(s.connections) == 0 {
+					return nil, fmt.Errorf("failed to create new connection: %w", err)
+				}
+			} else {
+				s.backoff.reset()
+				s.connections = append(s.connections, newConnection)
+				s.startControlLocked(newConnection)
+				s.cleanupOnce.Do(func() {
+					go s.connectionCleanupLoop()
+				})
+				status.GlobalConnMonitorRef.AddStream(s.BridgeName)
+				log.Printf("NEAR: Created new connection (total: %d/%d) for %s", len(s.connections), s.maxConnections, s.BridgeName)
+				return newConnection, nil
 			}
 		}
+	}
 
-		// If found a suitable connection, use it
-		if selected != nil {
-			status.GlobalConnMonitorRef.AddStream(s.BridgeName)
-			return selected, nil
+	// Find the connection with the least number of active streams
+	var selected *quicConnection
+	minStreams := s.maxStreams
+	for _, conn := range s.connections {
+		if conn.draining || slices.Contains(skip, conn) {
+			continue
+		}
+		activeStreams := atomic.LoadInt32(&conn.activeStreams)
+		if activeStreams < conn.streamBudget(s.maxStreams) && activeStreams < minStreams {
+			selected = conn
+			minStreams = activeStreams
 		}
-		return nil, fmt.Errorf("all connections are at maximum stream capacity")
 	}
-}
 
-// closeConnection safely closes a connection
-func (s *SalmonQuic) CloseConnection(qconn *quicConnection) {
-	qconn.mu.Lock()
-	defer qconn.mu.Unlock()
+	// If found a suitable connection, use it
+	if selected != nil {
+		status.GlobalConnMonitorRef.AddStream(s.BridgeName)
+		return selected, nil
+	}
+	return nil, errPoolSaturated
+}
 
-	if qconn.conn != nil {
-		_ = qconn.conn.CloseWithError(0, "idle timeout")
-		qconn.conn = nil
+// streamBudget returns how many streams may be open on the connection: the
+// pool's maxStreams, or less if the far side has turned a stream down.
+func (qc *quicConnection) streamBudget(maxStreams int32) int32 {
+	if limit := qc.streamLimit.Load(); limit > 0 && limit < maxStreams {
+		return limit
 	}
-	if qconn.pconn != nil {
-		_ = qconn.pconn.Close()
-		qconn.pconn = nil
+	return maxStreams
+}
+
+// dialEndpointsLocked dials the active endpoint, failing over to the next
+// ones in order. The first endpoint that answers becomes the active one.
+// The caller must hold connectionsMu.
+func (s *SalmonQuic) dialEndpointsLocked() (*quicConnection, error) {
+	var errs []error
+	for i := range s.endpoints {
+		idx := (s.activeEndpoint + i) % len(s.endpoints)
+		host := s.endpoints[idx]
+
+		// createNewConnection bounds the dial with dialTimeout
+		conn, err := s.createNewConnection(s.dialCtx, host)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if idx != s.activeEndpoint {
+			log.Printf("NEAR: Bridge %s failed over from far host %s to %s", s.BridgeName, s.endpoints[s.activeEndpoint], host)
+			s.activeEndpoint = idx
+			status.GlobalConnMonitorRef.RegisterEndpoint(s.BridgeName, host)
+		}
+		return conn, nil
 	}
+	return nil, errors.Join(errs...)
+}
+
+// CloseConnection safely closes a connection and removes it from the pool
+func (s *SalmonQuic) CloseConnection(qconn *quicConnection) {
+	qconn.mu.Lock()
+	qconn.closeLocked("idle timeout")
+	qconn.mu.Unlock()
 
-	// // This need to remove it from the pool as well
 	s.connectionsMu.Lock()
 	defer s.connectionsMu.Unlock()
 
@@ -221,45 +529,222 @@ func (s *SalmonQuic) CloseConnection(qconn *quicConnection) {
 			break
 		}
 	}
+	s.slotFreed()
 }
 
-// // connectionCleanupLoop periodically removes idle connections
-// func (s *SalmonQuic) connectionCleanupLoop() {
-// 	ticker := time.NewTicker(5 * time.Second)
-// 	defer ticker.Stop()
+// Close shuts down the far listener (if running) and every pooled
+// connection. The SalmonQuic cannot be reused afterwards.
+func (s *SalmonQuic) Close() {
+	s.dialCancel()
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	if s.far != nil {
+		s.closeFarLocked(s.far, "bridge closed")
+		s.far = nil
+	}
+	for _, conn := range s.connections {
+		conn.mu.Lock()
+		conn.closeLocked("bridge closed")
+		conn.mu.Unlock()
+	}
+	s.connections = nil
+	log.Printf("BRIDGE: Bridge %s closed", s.BridgeName)
+}
 
-// 	for range ticker.C {
-// 		s.connectionsMu.Lock()
+// trackListener records the far listener so StopFarListen and Close can
+// stop it. It returns nil, and an error if one is already running, when the
+// listener must not be served.
+func (s *SalmonQuic) trackListener(listeners []quicListener, pcs []net.PacketConn) (*farListener, error) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	if s.closed {
+		return nil, nil
+	}
+	if s.far != nil {
+		return nil, fmt.Errorf("bridge %s is already listening", s.BridgeName)
+	}
+	s.far = &farListener{
+		listeners: listeners,
+		pcs:       pcs,
+		conns:     make(map[*quic.Conn]*farConn),
+		stopped:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	return s.far, nil
+}
 
-// 		// Check each connection for idle timeout
-// 		activeConnections := make([]*quicConnection, 0, len(s.connections))
-// 		for _, conn := range s.connections {
-// 			activeCount := atomic.LoadInt32(&conn.activeStreams)
+// ActiveStreams returns the streams currently open through this bridge,
+// whether opened by the near pool or accepted on the far side.
+func (s *SalmonQuic) ActiveStreams() int32 {
+	s.connectionsMu.RLock()
+	defer s.connectionsMu.RUnlock()
+	total := s.farStreams.Load()
+	for _, conn := range s.connections {
+		total += atomic.LoadInt32(&conn.activeStreams)
+	}
+	return total
+}
 
-// 			// Keep connection if it has active streams or was recently used
-// 			if activeCount > 0 || time.Since(conn.createdAt) < 30*time.Second {
-// 				log.Printf("NEAR: Keeping active connection for %s (active streams: %d)", s.BridgeName, activeCount)
-// 				activeConnections = append(activeConnections, conn)
-// 			} else {
-// 				// Ping through the connection
+// Shutdown stops new streams from being opened or accepted, waits for the
+// active ones to finish until ctx is done, then closes everything. It
+// returns the number of streams that were still open when it closed.
+func (s *SalmonQuic) Shutdown(ctx context.Context) int32 {
+	// Draining takes no new connections, so give up on one being dialed
+	s.dialCancel()
+	s.connectionsMu.Lock()
+	s.draining = true
+	s.connectionsMu.Unlock()
 
-// 				log.Printf("NEAR: Closing idle connection for %s (created: %v ago)", s.BridgeName, time.Since(conn.createdAt))
-// 				s.closeConnection(conn)
-// 				log.Printf("NEAR: Active connections for %s: %d", s.BridgeName, len(activeConnections))
-// 				log.Printf("NEAR: Active streams for %s: %d", s.BridgeName, status.GlobalConnMonitorRef.GetStreamCount(s.BridgeName))
-// 			}
-// 		}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		remaining := s.ActiveStreams()
+		if remaining == 0 {
+			s.Close()
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return remaining
+		case <-ticker.C:
+		}
+	}
+}
 
-// 		s.connections = activeConnections
-// 		s.connectionsMu.Unlock()
-// 	}
-// }
+func (s *SalmonQuic) isDraining() bool {
+	s.connectionsMu.RLock()
+	defer s.connectionsMu.RUnlock()
+	return s.draining || s.closed || (s.far != nil && s.far.stopping)
+}
 
-// OpenStream opens a QUIC stream using the bridge pool
+// handleFarStream runs the far side handler for a stream, refusing it if
+// the bridge is shutting down.
+func (s *SalmonQuic) handleFarStream(conn *quic.Conn, fc *farConn, stream *quic.Stream, handleIncomingStream func(*quic.Stream)) {
+	if s.isDraining() {
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+		status.GlobalConnMonitorRef.RemoveStream(s.BridgeName)
+		return
+	}
+	s.farStreams.Add(1)
+	fc.streams.Add(1)
+	s.streamCounters.Store(stream, &fc.bytes)
+	defer func() {
+		if _, detached := s.detachedFarStreams.LoadAndDelete(stream); !detached {
+			s.farStreams.Add(-1)
+		}
+		fc.streams.Add(-1)
+		s.streamCounters.Delete(stream)
+	}()
+	// Lets the handler find the connection for FarStreamDatagrams and
+	// FarStreamRemoteAddr
+	s.farStreamConns.Store(stream, conn)
+	defer s.farStreamConns.Delete(stream)
+	handleIncomingStream(stream)
+}
+
+// FarStreamRemoteAddr returns the address of the near a far side stream
+// came from, or nil once its handler has returned.
+func (s *SalmonQuic) FarStreamRemoteAddr(stream *quic.Stream) net.Addr {
+	if c, ok := s.farStreamConns.Load(stream); ok {
+		return c.(*quic.Conn).RemoteAddr()
+	}
+	return nil
+}
+
+func (s *SalmonQuic) isClosed() bool {
+	s.connectionsMu.RLock()
+	defer s.connectionsMu.RUnlock()
+	return s.closed
+}
+
+// connectionCleanupLoop closes pooled connections that have had no streams
+// for longer than the pool's idle timeout. It exits when the bridge is closed.
+func (s *SalmonQuic) connectionCleanupLoop() {
+	ticker := time.NewTicker(ConnectionCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		s.connectionsMu.Lock()
+		kept := s.connections[:0]
+		for _, conn := range s.connections {
+			idle := time.Since(time.Unix(0, conn.lastUsed.Load()))
+			active := atomic.LoadInt32(&conn.activeStreams)
+			if active > 0 || (idle < s.idleTimeout && !conn.draining) {
+				kept = append(kept, conn)
+				continue
+			}
+			conn.mu.Lock()
+			if conn.draining {
+				conn.closeLocked("drained")
+				log.Printf("NEAR: Closing drained connection for %s", s.BridgeName)
+			} else {
+				conn.closeLocked("idle timeout")
+				log.Printf("NEAR: Closing idle connection for %s (idle for %v)", s.BridgeName, idle.Round(time.Second))
+			}
+			conn.mu.Unlock()
+		}
+		for i := len(kept); i < len(s.connections); i++ {
+			s.connections[i] = nil
+		}
+		s.connections = kept
+		s.connectionsMu.Unlock()
+	}
+}
+
+// OpenStream opens a QUIC stream using the bridge pool, trying up to
+// streamOpenAttempts connections when one fails to open it.
 // Returns the stream and a cleanup function that MUST be called when done
 func (s *SalmonQuic) OpenStream() (*quic.Stream, func(), error, *quicConnection) {
+	s.connectionsMu.RLock()
+	attempts := s.streamOpenAttempts
+	s.connectionsMu.RUnlock()
+
+	var failed []*quicConnection
+	stream, cleanup, err, qconn := s.openStreamOnce(nil)
+	for attempt := 2; attempt <= attempts && err != nil && qconn != nil && !qconn.isAlive(); attempt++ {
+		// The connection died underneath us (e.g. far side restarted). It has
+		// been evicted, so the next attempt goes to another connection in
+		// the pool or dials a fresh one.
+		failed = append(failed, qconn)
+		log.Printf("NEAR: Bridge %s retrying stream on another connection (attempt %d/%d): %v", s.BridgeName, attempt, attempts, err)
+		stream, cleanup, err, qconn = s.openStreamOnce(failed)
+	}
+	if err != nil {
+		return nil, nil, err, nil
+	}
+	return stream, cleanup, nil, qconn
+}
+
+// openQuicStream opens a stream on conn. Tests replace it to fail a
+// connection on demand.
+var openQuicStream = (*quic.Conn).OpenStream
+
+// openStreamOnce makes a single attempt at opening a stream, moving on to
+// another connection if the far side turns it down for being over its
+// stream limit. Connections in skip have already turned this stream down.
+// On failure the connection that was tried (if any) is returned so the
+// caller can decide whether a retry is worthwhile. Only the first attempt
+// queues for a saturated pool, retries already have somewhere to go.
+func (s *SalmonQuic) openStreamOnce(skip []*quicConnection) (*quic.Stream, func(), error, *quicConnection) {
 	// Select or create a connection
-	qconn, err := s.selectConnection()
+	selectConnection := s.selectConnection
+	if len(skip) == 0 {
+		selectConnection = s.selectConnectionQueued
+	}
+	qconn, err := selectConnection(skip)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to select connection: %w", err), nil
 	}
@@ -267,27 +752,72 @@ func (s *SalmonQuic) OpenStream() (*quic.Stream, func(), error, *quicConnection)
 	// Increment active stream counter
 	atomic.AddInt32(&qconn.activeStreams, 1)
 
-	if qconn == nil {
+	qconn.mu.Lock()
+	conn := qconn.conn
+	qconn.mu.Unlock()
+	if conn == nil {
 		atomic.AddInt32(&qconn.activeStreams, -1)
-		return nil, nil, fmt.Errorf("connection is nil"), nil
+		status.GlobalConnMonitorRef.RemoveStream(s.BridgeName)
+		return nil, nil, fmt.Errorf("connection is closed"), qconn
 	}
 
 	// Open stream with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	s.connectionsMu.RLock()
+	streamOpenTimeout := s.streamOpenTimeout
+	s.connectionsMu.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), streamOpenTimeout)
 	defer cancel()
 
-	stream, err := qconn.conn.OpenStreamSync(ctx)
+	stream, err := openQuicStream(conn)
+	if errors.Is(err, quic.Err0RTTRejected) {
+		// The far turned 0-RTT down, the connection is fine once the
+		// handshake it fell back to completes
+		if _, err = conn.NextConnection(ctx); err == nil {
+			stream, err = conn.OpenStream()
+		}
+	}
+	var limitErr *quic.StreamLimitReachedError
+	if errors.As(err, &limitErr) {
+		// The far side's MaxIncomingStreams is below maxStreams. Remember
+		// how many streams it took so selection stops short of that, and
+		// try another connection rather than block on this one.
+		open := atomic.AddInt32(&qconn.activeStreams, -1)
+		status.GlobalConnMonitorRef.RemoveStream(s.BridgeName)
+		if open > 0 && qconn.streamLimit.Swap(open) != open {
+			log.Printf("NEAR: Bridge %s far side is limiting a connection to %d streams", s.BridgeName, open)
+		}
+		otherStream, cleanup, otherErr, other := s.openStreamOnce(append(skip, qconn))
+		if otherErr == nil || other != nil {
+			return otherStream, cleanup, otherErr, other
+		}
+		// Nowhere else to go, wait for the far side to raise its limit.
+		// The connection itself is fine, so it stays open if that times out.
+		atomic.AddInt32(&qconn.activeStreams, 1)
+		status.GlobalConnMonitorRef.AddStream(s.BridgeName)
+		stream, err = conn.OpenStreamSync(ctx)
+		if err != nil && qconn.isAlive() {
+			atomic.AddInt32(&qconn.activeStreams, -1)
+			status.GlobalConnMonitorRef.RemoveStream(s.BridgeName)
+			return nil, nil, fmt.Errorf("failed to open stream: %w", err), nil
+		}
+	}
 	if err != nil {
 		atomic.AddInt32(&qconn.activeStreams, -1)
+		status.GlobalConnMonitorRef.RemoveStream(s.BridgeName)
 		// This connection is no good, close it
 		s.CloseConnection(qconn)
-		return nil, nil, fmt.Errorf("failed to open stream: %w", err), nil
+		return nil, nil, fmt.Errorf("failed to open stream: %w", err), qconn
 	}
 
+	s.streamCounters.Store(stream, &qconn.bytes)
+
 	// Cleanup function to decrement counter
 	cleanup := func() {
+		s.streamCounters.Delete(stream)
 		status.GlobalConnMonitorRef.RemoveStream(s.BridgeName)
+		qconn.lastUsed.Store(time.Now().UnixNano())
 		atomic.AddInt32(&qconn.activeStreams, -1)
+		s.slotFreed()
 	}
 
 	return stream, cleanup, nil, qconn
@@ -302,82 +832,224 @@ func shouldBlockHost(expectedRemote string, newRemote string) bool {
 	return false
 }
 
+// farListener is one run of NewFarListen.
+type farListener struct {
+	listeners []quicListener // one per socket in pcs
+	pcs       []net.PacketConn
+	conns     map[*quic.Conn]*farConn // accepted connections, guarded by connectionsMu
+	stopping  bool                    // refusing new streams, guarded by connectionsMu
+	stopped   chan struct{}           // closed once the listeners are closed
+	done      chan struct{}           // closed when every accept loop returns
+}
+
+// close closes the listeners, leaving their sockets open.
+func (far *farListener) close() {
+	for _, l := range far.listeners {
+		_ = l.Close()
+	}
+}
+
+// NewFarListen accepts QUIC connections on BridgePort and runs
+// handleIncomingStream for each of their streams. It blocks until the
+// listener is stopped by StopFarListen or Close, and may be called again
+// after StopFarListen.
 func (s *SalmonQuic) NewFarListen(handleIncomingStream func(*quic.Stream)) error {
-	listenAddr := fmt.Sprintf(":%d", s.BridgePort)
+	s.connectionsMu.RLock()
+	port := s.BridgePort
+	sockets := s.listenSockets
+	network := s.listenNetwork
+	s.connectionsMu.RUnlock()
+	listenAddr := fmt.Sprintf(":%d", port)
 	log.Printf("FAR: Address farListenAddr: '%s' (len=%d)\n", listenAddr, len(listenAddr))
 
-	// If you specify an interface name it will fail if that interface is not present
-	// or has no usable addresses. If you don't need to configure this do not specify an interface name.
-	if s.interfaceName != "" {
-		pc, err := listenPacketOnInterfaceForListen("udp", s.interfaceName, s.BridgePort)
+	pcs, err := s.listenFarSockets(network, port, sockets)
+	if err != nil {
+		return err
+	}
+	listeners := make([]quicListener, 0, len(pcs))
+	for _, pc := range pcs {
+		s.connectionsMu.RLock()
+		l, err := s.listenQuic(pc)
+		s.connectionsMu.RUnlock()
 		if err != nil {
-			return fmt.Errorf("bind to interface %q: %w", s.interfaceName, err)
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			for _, pc := range pcs {
+				_ = pc.Close()
+			}
+			return fmt.Errorf("listen QUIC %s: %w", listenAddr, err)
 		}
-		// Keep pc open for the lifetime of the listener (do not close here).
-		l, err := quic.Listen(pc, s.tlscfg, s.qcfg)
-		if err != nil {
+		listeners = append(listeners, l)
+	}
+	receive, send := s.udpBuffers()
+	s.logUDPBuffers("FAR", pcs[0], receive, send)
+	far, err := s.trackListener(listeners, pcs)
+	if far == nil {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+		for _, pc := range pcs {
 			_ = pc.Close()
-			return fmt.Errorf("listen QUIC %s on interface %s: %w", listenAddr, s.interfaceName, err)
 		}
+		return err
+	}
+	defer close(far.done)
+	switch {
+	case s.interfaceName != "":
 		log.Printf("FAR: Bridge %s listening on %s via interface %s", s.BridgeName, listenAddr, s.interfaceName)
+	case len(pcs) > 1:
+		log.Printf("FAR: Bridge %s listening on %s with %d reuseport sockets", s.BridgeName, listenAddr, len(pcs))
+	default:
+		log.Printf("FAR: Bridge %s listening on %s", s.BridgeName, listenAddr)
+	}
 
-		for {
-			conn, err := l.Accept(context.Background())
-			// Ip filtering if BridgeAddress is set
-			remoteAddr, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-			if shouldBlockHost(s.BridgeAddress, remoteAddr) {
-				log.Printf("FAR: Bridge %s rejected connection from unexpected address %s (expected %s)", s.BridgeName, remoteAddr, s.BridgeAddress)
-				_ = conn.CloseWithError(0, "unexpected address")
-				continue
-			}
-			if err != nil {
-				log.Printf("FAR: Bridge %s accept conn error: %v", s.BridgeName, err)
-				continue
+	// Each socket gets its own accept loop, the last one runs here
+	var wg sync.WaitGroup
+	for _, l := range listeners[:len(listeners)-1] {
+		wg.Add(1)
+		go func(l quicListener) {
+			defer wg.Done()
+			s.acceptFarConns(far, l, handleIncomingStream)
+		}(l)
+	}
+	s.acceptFarConns(far, listeners[len(listeners)-1], handleIncomingStream)
+	wg.Wait()
+	return nil
+}
+
+// acceptFarConns accepts connections on l until far is stopped.
+func (s *SalmonQuic) acceptFarConns(far *farListener, l quicListener, handleIncomingStream func(*quic.Stream)) {
+	for {
+		qc, err := l.Accept(context.Background())
+		if err != nil {
+			select {
+			case <-far.stopped:
+				return
+			default:
 			}
-			go func(c *quic.Conn) {
-				for {
-					stream, err := c.AcceptStream(context.Background())
-					if err != nil {
-						log.Printf("FAR: Bridge %s AcceptStream closed: %v", s.BridgeName, err)
-						return
-					}
-					status.GlobalConnMonitorRef.AddStream(s.BridgeName)
-					go handleIncomingStream(stream)
-				}
-			}(conn)
+			log.Printf("FAR: Bridge %s accept conn error: %v", s.BridgeName, err)
+			continue
 		}
-	} else {
-		l, err := quic.ListenAddr(listenAddr, s.tlscfg, s.qcfg)
-		if err != nil {
-			return fmt.Errorf("listen QUIC %s: %w", listenAddr, err)
+		// Ip filtering if BridgeAddress is set
+		remoteAddr, _, _ := net.SplitHostPort(qc.RemoteAddr().String())
+		if shouldBlockHost(s.BridgeAddress, remoteAddr) {
+			log.Printf("FAR: Bridge %s rejected connection from unexpected address %s (expected %s)", s.BridgeName, remoteAddr, s.BridgeAddress)
+			_ = qc.CloseWithError(0, "unexpected address")
+			continue
+		}
+		fc := s.trackFarConn(far, qc)
+		if fc == nil {
+			_ = qc.CloseWithError(0, "listener stopped")
+			continue
 		}
-		log.Printf("FAR: Bridge %s listening on %s", s.BridgeName, listenAddr)
 
-		for {
-			qc, err := l.Accept(context.Background())
-			// Ip filtering if BridgeAddress is set
-			remoteAddr, _, _ := net.SplitHostPort(qc.RemoteAddr().String())
-			if shouldBlockHost(s.BridgeAddress, remoteAddr) {
-				log.Printf("FAR: Bridge %s rejected connection from unexpected address %s (expected %s)", s.BridgeName, remoteAddr, s.BridgeAddress)
-				_ = qc.CloseWithError(0, "unexpected address")
-				continue
+		go func(conn *quic.Conn) {
+			defer s.untrackFarConn(far, conn)
+			// 0-RTT streams wait here until the handshake proves they are
+			// not a replay. Without 0-RTT it is already complete.
+			select {
+			case <-conn.HandshakeComplete():
+			case <-conn.Context().Done():
+				return
 			}
-			if err != nil {
-				log.Printf("FAR: Bridge %s accept conn error: %v", s.BridgeName, err)
-				continue
+			for {
+				stream, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					log.Printf("FAR: Bridge %s AcceptStream closed: %v", s.BridgeName, err)
+					return
+				}
+				status.GlobalConnMonitorRef.AddStream(s.BridgeName)
+				go s.handleFarStream(conn, fc, stream, handleIncomingStream)
 			}
+		}(qc)
+	}
+}
 
-			go func(conn *quic.Conn) {
-				for {
-					stream, err := conn.AcceptStream(context.Background())
-					if err != nil {
-						log.Printf("FAR: Bridge %s AcceptStream closed: %v", s.BridgeName, err)
-						return
-					}
-					status.GlobalConnMonitorRef.AddStream(s.BridgeName)
-					go handleIncomingStream(stream)
-				}
-			}(qc)
+// SetListenPort changes the port the next NewFarListen listens on. It
+// fails while a listener is running.
+func (s *SalmonQuic) SetListenPort(port int) error {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	if s.far != nil {
+		return fmt.Errorf("bridge %s is already listening", s.BridgeName)
+	}
+	s.BridgePort = port
+	return nil
+}
+
+// StopFarListen stops the far listener so NewFarListen can be called
+// again, e.g. on another port. New connections and streams are refused
+// straight away; streams already open may finish until ctx is done, then
+// every accepted connection is closed. Pass a done ctx to close them at
+// once. It returns the number of streams cut off.
+func (s *SalmonQuic) StopFarListen(ctx context.Context) int32 {
+	s.connectionsMu.Lock()
+	far := s.far
+	if far == nil || far.stopping {
+		s.connectionsMu.Unlock()
+		return 0
+	}
+	far.stopping = true
+	far.close()
+	close(far.stopped)
+	s.connectionsMu.Unlock()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	var remaining int32
+	for {
+		remaining = s.farStreams.Load()
+		if remaining == 0 || ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
 		}
 	}
+
+	s.connectionsMu.Lock()
+	s.closeFarLocked(far, "listener stopped")
+	s.far = nil
+	s.connectionsMu.Unlock()
+	<-far.done
+	log.Printf("FAR: Bridge %s stopped listening", s.BridgeName)
+	return remaining
+}
+
+// closeFarLocked closes a far listener's connections and socket. The
+// caller must hold connectionsMu.
+func (s *SalmonQuic) closeFarLocked(far *farListener, reason string) {
+	if !far.stopping {
+		far.stopping = true
+		far.close()
+		close(far.stopped)
+	}
+	for conn := range far.conns {
+		_ = conn.CloseWithError(0, reason)
+	}
+	far.conns = nil
+	for _, pc := range far.pcs {
+		_ = pc.Close()
+	}
+}
+
+// trackFarConn records a connection accepted by far so stopping it can
+// close the connection. It returns false if far is already stopping.
+func (s *SalmonQuic) trackFarConn(far *farListener, conn *quic.Conn) *farConn {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	if far.stopping {
+		return nil
+	}
+	fc := &farConn{createdAt: time.Now()}
+	far.conns[conn] = fc
+	return fc
+}
+
+func (s *SalmonQuic) untrackFarConn(far *farListener, conn *quic.Conn) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	delete(far.conns, conn)
 }
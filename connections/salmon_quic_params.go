@@ -2,6 +2,27 @@ package connections
 
 import "time"
 
+// Defaults for new pools. Each SalmonQuic copies these when it is created;
+// use SetPoolLimits to tune a single bridge.
 var MaxStreamsPerConnection int32 = 100
 var MaxConnectionsPerBridge int = 500
 var ConnectionIdleTimeout time.Duration = 5 * time.Minute
+
+// Defaults for how long a new QUIC connection and a new stream on it may
+// take to open; use SetTimeouts to tune a single bridge.
+var DialTimeout time.Duration = 10 * time.Second
+var StreamOpenTimeout time.Duration = 15 * time.Second
+
+// Default for how many connections OpenStream tries, the first included,
+// before failing the stream; use SetStreamOpenAttempts to tune a single
+// bridge.
+var StreamOpenAttempts int = 3
+
+// Defaults for how many OpenStream calls may queue on a saturated pool and
+// for how long; use SetStreamQueue to tune a single bridge. The queue is
+// off unless a bridge sets a depth.
+var StreamQueueDepth int = 0
+var StreamQueueTimeout time.Duration = 5 * time.Second
+
+// How often each pool looks for connections idle past its idle timeout.
+var ConnectionCleanupInterval time.Duration = 5 * time.Second
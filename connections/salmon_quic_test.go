@@ -7,12 +7,17 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
+	"io"
 	"math/big"
 	"net"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
 
+	"salmoncannon/status"
+
 	"github.com/quic-go/quic-go"
 )
 
@@ -463,7 +468,7 @@ func TestMutexSafety(t *testing.T) {
 
 func TestListenPacketOnInterfaceInvalidInterface(t *testing.T) {
 	// This test will fail on non-Linux or if the interface doesn't exist
-	_, err := listenPacketOnInterface("udp", "nonexistent-interface-12345")
+	_, err := listenPacketOnInterface("udp", "nonexistent-interface-12345", 0, 0)
 	if err == nil {
 		t.Error("Expected error when binding to non-existent interface")
 	}
@@ -803,10 +808,6 @@ func TestMaxConcurrentStreamOpening(t *testing.T) {
 // 1. Detected (e.g., via OpenStreamSync failure or context cancellation)
 // 2. Removed from the connection pool
 // 3. Replaced with a new connection on the next OpenStream() attempt
-//
-// Actual behavior (BUG): The stale connection remains in the pool, blocking
-// new connections from being created because MaxConnectionsPerBridge=1 is reached.
-// All subsequent OpenStream() calls fail until the idle timeout expires.
 func TestStaleConnectionNotCleanedUpWithMaxBridges1(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping integration test in short mode")
@@ -959,58 +960,688 @@ func TestStaleConnectionNotCleanedUpWithMaxBridges1(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 	t.Log("Second server started")
 
-	// The old connection should still be in the pool
+	// Try to open a new stream - the stale connection must be evicted and
+	// replaced by a fresh one without the caller having to retry
+	stream2, cleanup2, err, _ := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream failed after far side restart: %v", err)
+	}
+	defer cleanup2()
+	defer stream2.Close()
+
+	testData2 := []byte("test-data-2")
+	if _, err := stream2.Write(testData2); err != nil {
+		t.Fatalf("Write on new connection failed: %v", err)
+	}
+
+	buf2 := make([]byte, 100)
+	stream2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err = stream2.Read(buf2)
+	if err != nil && err.Error() != "EOF" {
+		t.Fatalf("Read on new connection failed: %v", err)
+	}
+	if string(buf2[:n]) != string(testData2) {
+		t.Errorf("Expected %s, got %s", testData2, buf2[:n])
+	}
+
+	// The stale connection must have been replaced, not added alongside
 	sq.connectionsMu.RLock()
-	oldConnCount := len(sq.connections)
+	finalConnCount := len(sq.connections)
 	sq.connectionsMu.RUnlock()
 
-	t.Logf("Connections in pool after server restart: %d", oldConnCount)
+	if finalConnCount != 1 {
+		t.Errorf("Expected 1 connection in pool after eviction, got %d", finalConnCount)
+	}
+}
 
-	// Try to open a new stream - this should fail because it tries to use the stale connection
-	// This is the bug: the near side keeps trying the old dead connection
-	stream2, cleanup2, err, _ := sq.OpenStream()
+// startDiscardServer runs a QUIC server that accepts every stream and
+// discards what it reads. It is closed when the test ends.
+func startDiscardServer(t *testing.T) (int, *tls.Config, *quic.Config) {
+	t.Helper()
+	listener, clientTLSConfig, qcfg := startDiscardListener(t)
+	return listener.Addr().(*net.UDPAddr).Port, clientTLSConfig, qcfg
+}
 
+// startDiscardListener is startDiscardServer for tests that need to stop
+// the server early.
+func startDiscardListener(t *testing.T) (*quic.Listener, *tls.Config, *quic.Config) {
+	t.Helper()
+	serverTLSConfig, err := generateTLSConfig()
 	if err != nil {
-		t.Logf("OpenStream failed as expected due to stale connection: %v", err)
-		stream2, cleanup2, err, _ = sq.OpenStream()
+		t.Fatalf("Failed to generate server TLS config: %v", err)
+	}
+	clientTLSConfig := &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"quic-test"},
+	}
+	qcfg := &quic.Config{
+		MaxIdleTimeout:     5 * time.Second,
+		MaxIncomingStreams: 100,
+	}
+
+	listener, err := quic.ListenAddr("127.0.0.1:0", serverTLSConfig, qcfg)
+	if err != nil {
+		t.Fatalf("Failed to start QUIC listener: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					go io.Copy(io.Discard, stream)
+				}
+			}()
+		}
+	}()
+	return listener, clientTLSConfig, qcfg
+}
+
+// Two pools with different limits must not affect each other.
+func TestPerBridgePoolLimits(t *testing.T) {
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+
+	small := NewSalmonQuic(port, "127.0.0.1", "pool-small", clientTLSConfig, qcfg, "")
+	small.SetPoolLimits(1, 2, time.Minute)
+	large := NewSalmonQuic(port, "127.0.0.1", "pool-large", clientTLSConfig, qcfg, "")
+	large.SetPoolLimits(1, 50, 0)
+	defer small.Close()
+	defer large.Close()
+
+	if large.idleTimeout != ConnectionIdleTimeout {
+		t.Errorf("expected idle timeout <= 0 to keep the default, got %v", large.idleTimeout)
+	}
+
+	open := func(sq *SalmonQuic, n int) (int, error) {
+		opened := 0
+		for i := 0; i < n; i++ {
+			stream, _, err, _ := sq.OpenStream()
+			if err != nil {
+				return opened, err
+			}
+			// Write so the far side sees the stream; leave it open
+			stream.Write([]byte("x"))
+			opened++
+		}
+		return opened, nil
+	}
+
+	if n, err := open(small, 3); err == nil || n != 2 {
+		t.Fatalf("expected small pool to stop at 2 streams, opened %d (err %v)", n, err)
+	}
+	if got := status.GlobalConnMonitorRef.Rejections("pool-small").PoolSaturated; got != 1 {
+		t.Errorf("expected 1 saturated open on the small pool, got %d", got)
 	}
+	if n, err := open(large, 3); err != nil || n != 3 {
+		t.Fatalf("expected large pool to open 3 streams, opened %d (err %v)", n, err)
+	}
+}
 
+func TestStreamLimitMovesToAnotherConnection(t *testing.T) {
+	serverTLSConfig, err := generateTLSConfig()
+	if err != nil {
+		t.Fatalf("Failed to generate server TLS config: %v", err)
+	}
+	clientTLSConfig := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"quic-test"}}
+	// The far side takes fewer streams per connection than the pool wants
+	// and never finishes them, so it never raises the limit
+	listener, err := quic.ListenAddr("127.0.0.1:0", serverTLSConfig, &quic.Config{MaxIncomingStreams: 2})
+	if err != nil {
+		t.Fatalf("Failed to start QUIC listener: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			if _, err := listener.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+	port := listener.Addr().(*net.UDPAddr).Port
+
+	sq := NewSalmonQuic(port, "127.0.0.1", "stream-limit", clientTLSConfig, &quic.Config{}, "")
+	defer sq.Close()
+	sq.SetPoolLimits(2, 10, time.Minute)
+	sq.SetTimeouts(0, 3*time.Second)
+
+	open := func() (*quic.Stream, func(), *quicConnection) {
+		t.Helper()
+		stream, cleanup, err, qconn := sq.OpenStream()
+		if err != nil {
+			t.Fatalf("failed to open stream: %v", err)
+		}
+		stream.Write([]byte("x"))
+		return stream, cleanup, qconn
+	}
+
+	// Two streams on the first connection, one on the second
+	s1, cleanup1, first := open()
+	_, _, second := open()
+	s3, cleanup3, third := open()
+	if first == second || third != first {
+		t.Fatalf("unexpected stream placement")
+	}
+
+	// The near is done with both, but the far still holds them, so the
+	// pool sees an empty connection the far will not open a stream on
+	s1.Close()
+	cleanup1()
+	s3.Close()
+	cleanup3()
+
+	start := time.Now()
+	_, _, fourth := open()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the stream to open without waiting, took %v", elapsed)
+	}
+	if fourth != second {
+		t.Fatalf("expected the stream to move to the second connection")
+	}
+	if !first.isAlive() {
+		t.Fatalf("expected the full connection to stay open")
+	}
+}
+
+func TestDialTimeoutBlackhole(t *testing.T) {
+	// Nothing ever answers on this socket, so the handshake hangs
+	blackhole, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer blackhole.Close()
+	port := blackhole.LocalAddr().(*net.UDPAddr).Port
+
+	tlscfg, err := generateTLSConfig()
+	if err != nil {
+		t.Fatalf("Failed to generate TLS config: %v", err)
+	}
+	sq := NewSalmonQuic(port, "127.0.0.1", "dial-timeout", tlscfg, &quic.Config{}, "")
+	defer sq.Close()
+	if sq.dialTimeout != DialTimeout || sq.streamOpenTimeout != StreamOpenTimeout {
+		t.Fatalf("expected package defaults, got %v/%v", sq.dialTimeout, sq.streamOpenTimeout)
+	}
+	sq.SetTimeouts(300*time.Millisecond, 0)
+	if sq.streamOpenTimeout != StreamOpenTimeout {
+		t.Errorf("expected stream open timeout <= 0 to keep the default, got %v", sq.streamOpenTimeout)
+	}
+
+	start := time.Now()
+	_, _, err, _ = sq.OpenStream()
+	elapsed := time.Since(start)
 	if err == nil {
-		// If we got a stream, try to use it
-		testData2 := []byte("test-data-2")
-		_, writeErr := stream2.Write(testData2)
+		t.Fatalf("expected dial to a blackholed address to fail")
+	}
+	if elapsed > time.Second {
+		t.Errorf("expected dial to give up after ~300ms, took %v", elapsed)
+	}
+}
+
+func TestShutdownDrainsStreams(t *testing.T) {
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "drain-bridge", clientTLSConfig, qcfg, "")
+
+	stream, cleanup, err, _ := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	stream.Write([]byte("x"))
+	if got := sq.ActiveStreams(); got != 1 {
+		t.Fatalf("expected 1 active stream, got %d", got)
+	}
+
+	// Finish the stream partway through the grace period
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		stream.Close()
+		cleanup()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if remaining := sq.Shutdown(ctx); remaining != 0 {
+		t.Fatalf("expected all streams drained, %d left", remaining)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("expected shutdown to return once drained, took %v", time.Since(start))
+	}
+	if _, _, err, _ := sq.OpenStream(); err == nil {
+		t.Fatalf("expected no new streams after shutdown")
+	}
+}
+
+func TestShutdownForceClosesAfterGrace(t *testing.T) {
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "force-bridge", clientTLSConfig, qcfg, "")
+
+	for i := 0; i < 2; i++ {
+		stream, _, err, _ := sq.OpenStream()
+		if err != nil {
+			t.Fatalf("failed to open stream: %v", err)
+		}
+		stream.Write([]byte("x"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if remaining := sq.Shutdown(ctx); remaining != 2 {
+		t.Fatalf("expected 2 streams cut off, got %d", remaining)
+	}
+	if !sq.isClosed() {
+		t.Fatalf("expected pool to be closed after grace period")
+	}
+}
+
+func TestShutdownCancelsPendingDial(t *testing.T) {
+	// A far that never answers keeps the dial waiting for dialTimeout
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer silent.Close()
+	port := silent.LocalAddr().(*net.UDPAddr).Port
+
+	clientTLSConfig := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"test"}}
+	sq := NewSalmonQuic(port, "127.0.0.1", "shutdown-dial", clientTLSConfig, &quic.Config{}, "")
+	sq.dialTimeout = 5 * time.Second
 
-		if writeErr != nil {
-			t.Logf("Write failed (expected with stale connection): %v", writeErr)
+	dialed := make(chan error, 1)
+	go func() {
+		_, _, err, _ := sq.OpenStream()
+		dialed <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	sq.Shutdown(ctx)
+	if time.Since(start) > time.Second {
+		t.Fatalf("expected shutdown not to wait for the dial, took %v", time.Since(start))
+	}
+	if err := <-dialed; err == nil {
+		t.Fatalf("expected the pending dial to fail")
+	}
+}
+
+func TestCleanupLoopClosesIdleConnections(t *testing.T) {
+	oldInterval := ConnectionCleanupInterval
+	ConnectionCleanupInterval = 20 * time.Millisecond
+	defer func() { ConnectionCleanupInterval = oldInterval }()
+
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "idle-cleanup", clientTLSConfig, qcfg, "")
+	defer sq.Close()
+	sq.SetPoolLimits(0, 0, 100*time.Millisecond)
+
+	busy, busyCleanup, err, _ := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	busy.Write([]byte("x"))
+
+	time.Sleep(300 * time.Millisecond)
+	if poolSize(sq) != 1 {
+		t.Fatalf("expected connection with an active stream to be kept, pool size %d", poolSize(sq))
+	}
+
+	busy.Close()
+	busyCleanup()
+	time.Sleep(300 * time.Millisecond)
+	if poolSize(sq) != 0 {
+		t.Fatalf("expected idle connection to be closed, pool size %d", poolSize(sq))
+	}
+}
+
+func TestLoweredConnectionCapDrains(t *testing.T) {
+	oldInterval := ConnectionCleanupInterval
+	ConnectionCleanupInterval = 20 * time.Millisecond
+	defer func() { ConnectionCleanupInterval = oldInterval }()
+
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "drain-cap", clientTLSConfig, qcfg, "")
+	defer sq.Close()
+	// One stream per connection so the two streams need two connections
+	sq.SetPoolLimits(2, 1, time.Minute)
+
+	first, firstCleanup, err, firstConn := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	first.Write([]byte("x"))
+	second, secondCleanup, err, secondConn := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	second.Write([]byte("x"))
+	if firstConn == secondConn || poolSize(sq) != 2 {
+		t.Fatalf("expected two pooled connections, pool size %d", poolSize(sq))
+	}
+
+	sq.SetPoolLimits(1, 10, 0)
+	sq.connectionsMu.RLock()
+	var drained, kept *quicConnection
+	for _, conn := range sq.connections {
+		if conn.draining {
+			drained = conn
+		} else {
+			kept = conn
+		}
+	}
+	sq.connectionsMu.RUnlock()
+	if drained == nil || kept == nil {
+		t.Fatalf("expected one draining and one serving connection")
+	}
+
+	// New streams avoid the draining connection
+	for i := 0; i < 3; i++ {
+		stream, cleanup, err, qconn := sq.OpenStream()
+		if err != nil {
+			t.Fatalf("OpenStream failed: %v", err)
+		}
+		if qconn != kept {
+			t.Fatalf("expected new streams on the serving connection")
 		}
+		stream.Close()
+		cleanup()
+	}
 
-		// Try to read
-		buf2 := make([]byte, 100)
-		stream2.SetReadDeadline(time.Now().Add(2 * time.Second))
-		_, readErr := stream2.Read(buf2)
+	// The draining connection keeps its stream until it ends
+	time.Sleep(100 * time.Millisecond)
+	if !drained.isAlive() || poolSize(sq) != 2 {
+		t.Fatalf("expected the draining connection to stay open while it has a stream")
+	}
+	drainedStream, drainedCleanup, keptCleanup := first, firstCleanup, secondCleanup
+	if drained == secondConn {
+		drainedStream, drainedCleanup, keptCleanup = second, secondCleanup, firstCleanup
+	}
+	defer keptCleanup()
+	drainedStream.Close()
+	drainedCleanup()
 
-		if readErr != nil {
-			t.Logf("Read failed (expected with stale connection): %v", readErr)
+	deadline := time.Now().Add(2 * time.Second)
+	for poolSize(sq) != 1 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if poolSize(sq) != 1 || drained.isAlive() {
+		t.Fatalf("expected the drained connection to close after its last stream, pool size %d", poolSize(sq))
+	}
+	if !kept.isAlive() {
+		t.Fatalf("expected the serving connection to stay open")
+	}
+}
+
+func TestCloseStopsGoroutines(t *testing.T) {
+	oldInterval := ConnectionCleanupInterval
+	ConnectionCleanupInterval = 20 * time.Millisecond
+	defer func() { ConnectionCleanupInterval = oldInterval }()
+
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	// Let the server's goroutines settle before taking the baseline
+	time.Sleep(50 * time.Millisecond)
+	before := runtime.NumGoroutine()
+
+	sq := NewSalmonQuic(port, "127.0.0.1", "leak-check", clientTLSConfig, qcfg, "")
+	sq.SetKeepalive(20*time.Millisecond, 3, func(stream *quic.Stream) error { return nil })
+	for i := 0; i < 3; i++ {
+		stream, cleanup, err, _ := sq.OpenStream()
+		if err != nil {
+			t.Fatalf("OpenStream failed: %v", err)
 		}
+		stream.Write([]byte("x"))
+		stream.Close()
+		cleanup()
+	}
 
-		stream2.Close()
-		cleanup2()
+	// Close from several goroutines at once, racing a late OpenStream
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sq.Close()
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if stream, cleanup, err, _ := sq.OpenStream(); err == nil {
+			stream.Close()
+			cleanup()
+		}
+	}()
+	wg.Wait()
+	sq.Close()
 
-		// The issue is that the stale connection is still in the pool
-		// and we can't establish new connections because MaxConnectionsPerBridge = 1
-		//t.Error("BUG REPRODUCED: Stream opened using stale connection, but communication fails")
-	} else {
-		t.Logf("OpenStream failed (also indicates the bug): %v", err)
-		t.Error("BUG REPRODUCED: Cannot open new stream because stale connection is blocking the pool")
+	if _, _, err, _ := sq.OpenStream(); err == nil {
+		t.Fatalf("expected OpenStream to fail after Close")
 	}
 
-	// Verify the stale connection is still in the pool
-	// sq.connectionsMu.RLock()
-	// finalConnCount := len(sq.connections)
-	// sq.connectionsMu.RUnlock()
+	// quic-go tears connections down asynchronously, so allow some slack
+	deadline := time.Now().Add(3 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("goroutines leaked after Close: before %d, after %d", before, after)
+	}
+}
 
-	// if finalConnCount == 1 {
-	// 	t.Log("ISSUE CONFIRMED: Stale connection is still in the pool, preventing new connections")
-	// 	t.Log("Expected behavior: The stale/dead connection should be detected and removed from the pool")
-	// }
+func TestFarListenStopAndRestart(t *testing.T) {
+	serverTLSConfig, err := generateTLSConfig()
+	if err != nil {
+		t.Fatalf("Failed to generate server TLS config: %v", err)
+	}
+	clientTLSConfig := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"quic-test"}}
+	qcfg := &quic.Config{MaxIdleTimeout: 5 * time.Second, MaxIncomingStreams: 100}
+
+	freePort := func() int {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to reserve UDP port: %v", err)
+		}
+		defer pc.Close()
+		return pc.LocalAddr().(*net.UDPAddr).Port
+	}
+	echo := func(stream *quic.Stream) {
+		io.Copy(stream, stream)
+		stream.Close()
+	}
+	port := freePort()
+	far := NewSalmonQuic(port, "", "far-restart", serverTLSConfig, qcfg, "")
+	defer far.Close()
+	listen := func() chan error {
+		done := make(chan error, 1)
+		go func() { done <- far.NewFarListen(echo) }()
+		return done
+	}
+
+	// openEcho opens a stream to port and checks one echo round trip
+	openEcho := func(port int) (*quic.Stream, func(), error) {
+		client := NewSalmonQuic(port, "127.0.0.1", "far-restart-client", clientTLSConfig, qcfg, "")
+		client.SetTimeouts(time.Second, time.Second)
+		stream, cleanup, err, _ := client.OpenStream()
+		if err != nil {
+			client.Close()
+			return nil, nil, err
+		}
+		stream.SetDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 4)
+		if _, err = stream.Write([]byte("ping")); err == nil {
+			_, err = io.ReadFull(stream, buf)
+		}
+		closeAll := func() {
+			stream.Close()
+			cleanup()
+			client.Close()
+		}
+		if err != nil || string(buf) != "ping" {
+			closeAll()
+			return nil, nil, fmt.Errorf("echo failed: %q %v", buf, err)
+		}
+		return stream, closeAll, nil
+	}
+	waitEcho := func(port int) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			_, closeAll, err := openEcho(port)
+			if err == nil {
+				closeAll()
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("listener on %d never answered: %v", port, err)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	waitStopped := func(done chan error) {
+		t.Helper()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("expected NewFarListen to return nil once stopped, got %v", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("NewFarListen still running after StopFarListen")
+		}
+	}
+
+	done := listen()
+	waitEcho(port)
+	if err := far.NewFarListen(echo); err == nil {
+		t.Fatalf("expected a second listener to be refused")
+	}
+	if err := far.SetListenPort(freePort()); err == nil {
+		t.Fatalf("expected the port to be fixed while listening")
+	}
+
+	// A stream still open when the listener stops may finish
+	stream, closeAll, err := openEcho(port)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		stream.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if cut := far.StopFarListen(ctx); cut != 0 {
+		t.Fatalf("expected the open stream to drain, %d cut off", cut)
+	}
+	closeAll()
+	waitStopped(done)
+
+	// Same port again
+	done = listen()
+	waitEcho(port)
+
+	// A done ctx closes everything at once
+	stream, closeAll, err = openEcho(port)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer closeAll()
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if cut := far.StopFarListen(ctx); cut != 1 {
+		t.Fatalf("expected one stream to be cut off, got %d", cut)
+	}
+	waitStopped(done)
+	stream.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := stream.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expected the cut off stream to fail")
+	}
+
+	// And a new port
+	newPort := freePort()
+	if err := far.SetListenPort(newPort); err != nil {
+		t.Fatalf("failed to change port: %v", err)
+	}
+	done = listen()
+	waitEcho(newPort)
+	far.Close()
+	waitStopped(done)
+}
+
+// failOpenStream makes openQuicStream fail on connections for which fail
+// returns true, counting the failures, until the test ends.
+func failOpenStream(t *testing.T, fail func(*quic.Conn) bool) *int32 {
+	t.Helper()
+	var failures int32
+	orig := openQuicStream
+	openQuicStream = func(conn *quic.Conn) (*quic.Stream, error) {
+		if fail(conn) {
+			failures++
+			return nil, fmt.Errorf("stale connection")
+		}
+		return orig(conn)
+	}
+	t.Cleanup(func() { openQuicStream = orig })
+	return &failures
+}
+
+func TestOpenStreamRetriesOnAnotherConnection(t *testing.T) {
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "open-retry", clientTLSConfig, qcfg, "")
+	defer sq.Close()
+	sq.SetPoolLimits(1, 10, time.Minute)
+
+	stream, cleanup, err, first := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	stream.Close()
+	cleanup()
+
+	// The pooled connection still looks alive but can no longer open streams
+	failures := failOpenStream(t, func(conn *quic.Conn) bool { return conn == first.conn })
+	stream, cleanup, err, retried := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("expected the retry to open the stream, got %v", err)
+	}
+	defer cleanup()
+	defer stream.Close()
+	if *failures != 1 {
+		t.Fatalf("expected one failed attempt, got %d", *failures)
+	}
+	if retried == first || first.isAlive() {
+		t.Fatalf("expected the stream on a fresh connection and the failed one closed")
+	}
+	if _, err := stream.Write([]byte("x")); err != nil {
+		t.Fatalf("write on the retried stream failed: %v", err)
+	}
+	sq.connectionsMu.RLock()
+	pooled := len(sq.connections)
+	sq.connectionsMu.RUnlock()
+	if pooled != 1 {
+		t.Fatalf("expected only the fresh connection in the pool, got %d", pooled)
+	}
+}
+
+func TestOpenStreamAttemptsAreBounded(t *testing.T) {
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "open-bounded", clientTLSConfig, qcfg, "")
+	defer sq.Close()
+	sq.SetPoolLimits(2, 10, time.Minute)
+	sq.SetReconnectBackoff(0, 0)
+	sq.SetStreamOpenAttempts(3)
+
+	failures := failOpenStream(t, func(*quic.Conn) bool { return true })
+	if _, _, err, _ := sq.OpenStream(); err == nil {
+		t.Fatalf("expected OpenStream to fail when every connection does")
+	}
+	if *failures != 3 {
+		t.Fatalf("expected 3 attempts, got %d", *failures)
+	}
 }
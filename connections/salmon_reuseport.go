@@ -0,0 +1,112 @@
+package connections
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetListenSockets sets how many UDP sockets the next NewFarListen opens on
+// the listen port. With more than one they share the port via SO_REUSEPORT
+// and the kernel spreads incoming connections across their accept loops.
+// n <= 1 listens on a single socket, as does any platform but Linux.
+func (s *SalmonQuic) SetListenSockets(n int) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	s.listenSockets = max(n, 1)
+}
+
+// SetListenNetwork sets the network the next NewFarListen listens on: "udp"
+// follows the OS's dual stack behaviour, "udp4" and "udp6" take only IPv4 or
+// only IPv6 connections. An empty network means "udp".
+func (s *SalmonQuic) SetListenNetwork(network string) {
+	if network == "" {
+		network = "udp"
+	}
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	s.listenNetwork = network
+}
+
+// listenControl returns a socket Control function that sets SO_REUSEPORT
+// when reusePort is set, binds to ifname when it is not empty and sets the
+// receive and send buffers that are > 0.
+func listenControl(ifname string, reusePort bool, receive, send int) func(network, address string, c syscall.RawConn) error {
+	return func(_network, _address string, c syscall.RawConn) error {
+		var serr error
+		if err := c.Control(func(fd uintptr) {
+			if reusePort {
+				serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}
+			if serr == nil && ifname != "" {
+				serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
+			}
+			if serr == nil {
+				serr = setSocketBuffers(int(fd), receive, send)
+			}
+		}); err != nil {
+			// RawConn.Control returned an error
+			return err
+		}
+		return serr
+	}
+}
+
+// listenFarSockets opens the far listener's n network sockets on port. Port 0
+// picks a free port for the first socket and binds the rest to it. If the
+// first reuseport socket cannot be opened it falls back to a single socket.
+func (s *SalmonQuic) listenFarSockets(network string, port, n int) ([]net.PacketConn, error) {
+	if n > 1 && runtime.GOOS != "linux" {
+		log.Printf("FAR: Bridge %s SO_REUSEPORT is only supported on Linux, listening on one socket", s.BridgeName)
+		n = 1
+	}
+	pcs := make([]net.PacketConn, 0, n)
+	for i := 0; i < n; i++ {
+		pc, err := s.listenFarSocket(network, port, n > 1)
+		if err != nil && i == 0 && n > 1 {
+			log.Printf("FAR: Bridge %s could not open a reuseport socket, listening on one socket: %v", s.BridgeName, err)
+			return s.listenFarSockets(network, port, 1)
+		}
+		if err != nil {
+			for _, pc := range pcs {
+				_ = pc.Close()
+			}
+			return nil, err
+		}
+		if i == 0 {
+			port = pc.LocalAddr().(*net.UDPAddr).Port
+		}
+		pcs = append(pcs, pc)
+	}
+	return pcs, nil
+}
+
+func (s *SalmonQuic) listenFarSocket(network string, port int, reusePort bool) (net.PacketConn, error) {
+	listenAddr := fmt.Sprintf(":%d", port)
+	receive, send := s.udpBuffers()
+	// If you specify an interface name it will fail if that interface is not present
+	// or has no usable addresses. If you don't need to configure this do not specify an interface name.
+	if s.interfaceName != "" {
+		pc, err := listenPacketOnInterfaceForListen(network, s.interfaceName, port, reusePort, receive, send)
+		if err != nil {
+			return nil, fmt.Errorf("bind to interface %q: %w", s.interfaceName, err)
+		}
+		return pc, nil
+	}
+	// Owning the socket, rather than quic.ListenAddr, frees the port as
+	// soon as the listener stops so it can be listened on again
+	lc := net.ListenConfig{}
+	if reusePort || receive > 0 || send > 0 {
+		lc.Control = listenControl("", reusePort, receive, send)
+	}
+	pc, err := lc.ListenPacket(context.Background(), network, listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen QUIC %s %s: %w", network, listenAddr, err)
+	}
+	return pc, nil
+}
@@ -0,0 +1,239 @@
+package connections
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+func TestListenFarSocketsReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT is only used on Linux")
+	}
+	sq := NewSalmonQuic(0, "", "reuseport-bind", nil, nil, "")
+	defer sq.Close()
+
+	pcs, err := sq.listenFarSockets("udp", 0, 4)
+	if err != nil {
+		t.Fatalf("listenFarSockets failed: %v", err)
+	}
+	defer func() {
+		for _, pc := range pcs {
+			pc.Close()
+		}
+	}()
+	if len(pcs) != 4 {
+		t.Fatalf("expected 4 sockets, got %d", len(pcs))
+	}
+	port := pcs[0].LocalAddr().(*net.UDPAddr).Port
+	for i, pc := range pcs {
+		if got := pc.LocalAddr().(*net.UDPAddr).Port; got != port {
+			t.Fatalf("socket %d bound to port %d, want %d", i, got, port)
+		}
+	}
+
+	// Without SO_REUSEPORT the port is taken
+	if pc, err := sq.listenFarSocket("udp", port, false); err == nil {
+		pc.Close()
+		t.Fatalf("expected a plain socket on port %d to fail", port)
+	}
+}
+
+func TestListenFarSocketNetwork(t *testing.T) {
+	sq := NewSalmonQuic(0, "", "listen-network", nil, nil, "")
+	defer sq.Close()
+
+	pc, err := sq.listenFarSocket("udp4", 0, false)
+	if err != nil {
+		t.Fatalf("listenFarSocket udp4 failed: %v", err)
+	}
+	defer pc.Close()
+	addr := pc.LocalAddr().(*net.UDPAddr)
+	if addr.IP.To4() == nil {
+		t.Fatalf("expected udp4 to bind an IPv4 address, got %v", addr)
+	}
+
+	v6, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	v6.Close()
+
+	// The IPv6 side of the port is still free, so only IPv4 was taken
+	other, err := sq.listenFarSocket("udp6", addr.Port, false)
+	if err != nil {
+		t.Fatalf("expected udp6 on port %d to be free next to udp4, got %v", addr.Port, err)
+	}
+	other.Close()
+
+	// A dual stack socket on the port wants IPv4 too
+	if dual, err := sq.listenFarSocket("udp", addr.Port, false); err == nil {
+		dual.Close()
+		t.Fatalf("expected a dual stack socket on port %d to clash with udp4", addr.Port)
+	}
+}
+
+func TestSetListenNetwork(t *testing.T) {
+	sq := NewSalmonQuic(0, "", "listen-network", nil, nil, "")
+	defer sq.Close()
+	if sq.listenNetwork != "udp" {
+		t.Fatalf("expected udp by default, got %q", sq.listenNetwork)
+	}
+	sq.SetListenNetwork("udp6")
+	if sq.listenNetwork != "udp6" {
+		t.Fatalf("expected udp6, got %q", sq.listenNetwork)
+	}
+	sq.SetListenNetwork("")
+	if sq.listenNetwork != "udp" {
+		t.Fatalf("expected an empty network to mean udp, got %q", sq.listenNetwork)
+	}
+}
+
+func TestSetListenSockets(t *testing.T) {
+	sq := NewSalmonQuic(0, "", "listen-sockets", nil, nil, "")
+	defer sq.Close()
+	if sq.listenSockets != 1 {
+		t.Fatalf("expected one listen socket by default, got %d", sq.listenSockets)
+	}
+	sq.SetListenSockets(0)
+	if sq.listenSockets != 1 {
+		t.Fatalf("expected n <= 1 to mean one socket, got %d", sq.listenSockets)
+	}
+	sq.SetListenSockets(8)
+	if sq.listenSockets != 8 {
+		t.Fatalf("expected 8 listen sockets, got %d", sq.listenSockets)
+	}
+}
+
+// startReusePortFar runs an echo far on a free port with n listen sockets
+// and returns the port and the client side configs.
+func startReusePortFar(tb testing.TB, n int) (int, *SalmonQuic, *tls.Config, *quic.Config) {
+	tb.Helper()
+	serverTLSConfig, err := generateTLSConfig()
+	if err != nil {
+		tb.Fatalf("Failed to generate server TLS config: %v", err)
+	}
+	clientTLSConfig := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"quic-test"}}
+	qcfg := &quic.Config{MaxIdleTimeout: 5 * time.Second, MaxIncomingStreams: 1000}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("failed to reserve UDP port: %v", err)
+	}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+
+	far := NewSalmonQuic(port, "", "reuseport-far", serverTLSConfig, qcfg, "")
+	far.SetListenSockets(n)
+	done := make(chan error, 1)
+	go func() {
+		done <- far.NewFarListen(func(stream *quic.Stream) {
+			io.Copy(stream, stream)
+			stream.Close()
+		})
+	}()
+	tb.Cleanup(func() {
+		far.StopFarListen(context.Background())
+		far.Close()
+		if err := <-done; err != nil {
+			tb.Errorf("expected NewFarListen to return nil once stopped, got %v", err)
+		}
+	})
+	return port, far, clientTLSConfig, qcfg
+}
+
+// echoOnce dials port as a fresh client and checks one echo round trip.
+func echoOnce(port int, tlscfg *tls.Config, qcfg *quic.Config, payload []byte) error {
+	client := NewSalmonQuic(port, "127.0.0.1", "reuseport-client", tlscfg, qcfg, "")
+	defer client.Close()
+	client.SetTimeouts(time.Second, time.Second)
+	stream, cleanup, err, _ := client.OpenStream()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stream.Write(payload); err != nil {
+		return err
+	}
+	buf := make([]byte, len(payload))
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		return fmt.Errorf("echo failed: %w", err)
+	}
+	return nil
+}
+
+func TestFarListenReusePortSockets(t *testing.T) {
+	port, far, clientTLSConfig, qcfg := startReusePortFar(t, 4)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		err := echoOnce(port, clientTLSConfig, qcfg, []byte("ping"))
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("listener never answered: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	far.connectionsMu.RLock()
+	sockets := len(far.far.pcs)
+	far.connectionsMu.RUnlock()
+	want := 4
+	if runtime.GOOS != "linux" {
+		want = 1
+	}
+	if sockets != want {
+		t.Fatalf("expected %d listen sockets, got %d", want, sockets)
+	}
+
+	// Clients from many source ports all get answered, whichever socket
+	// the kernel hands them to
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- echoOnce(port, clientTLSConfig, qcfg, []byte("ping"))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("client failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkFarListenSockets(b *testing.B) {
+	payload := make([]byte, 64*1024)
+	for _, n := range []int{1, 4} {
+		b.Run(fmt.Sprintf("sockets=%d", n), func(b *testing.B) {
+			port, _, clientTLSConfig, qcfg := startReusePortFar(b, n)
+			time.Sleep(100 * time.Millisecond)
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := echoOnce(port, clientTLSConfig, qcfg, payload); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
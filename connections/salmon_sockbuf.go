@@ -0,0 +1,103 @@
+package connections
+
+import (
+	"context"
+	"log"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetUDPBuffers sets SO_RCVBUF and SO_SNDBUF, in bytes, on the UDP sockets
+// the pool dials new connections from and the next NewFarListen listens on.
+// 0 leaves that buffer to the OS and quic-go, which raises buffers below
+// about 7MB itself where the OS lets it. A near that neither sets buffers
+// nor binds to an interface lets quic-go open its sockets.
+func (s *SalmonQuic) SetUDPBuffers(receive, send int) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	s.udpReceiveBuffer = max(receive, 0)
+	s.udpSendBuffer = max(send, 0)
+}
+
+func (s *SalmonQuic) udpBuffers() (receive, send int) {
+	s.connectionsMu.RLock()
+	defer s.connectionsMu.RUnlock()
+	return s.udpReceiveBuffer, s.udpSendBuffer
+}
+
+// setSocketBuffers sets fd's receive and send buffers where they are > 0.
+// SO_RCVBUFFORCE and SO_SNDBUFFORCE go past net.core.rmem_max and wmem_max
+// but need CAP_NET_ADMIN, so without it the plain options are used and the
+// kernel caps the sizes at those limits.
+func setSocketBuffers(fd int, receive, send int) error {
+	if receive > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, receive); err != nil {
+			if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, receive); err != nil {
+				return err
+			}
+		}
+	}
+	if send > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUFFORCE, send); err != nil {
+			if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF, send); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listenPacketWithBuffers opens a UDP socket on a free port with the given
+// buffer sizes, for a near to dial from.
+func listenPacketWithBuffers(receive, send int) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: listenControl("", false, receive, send)}
+	return lc.ListenPacket(context.Background(), "udp", ":0")
+}
+
+// socketBuffers returns the receive and send buffer sizes of pc as the
+// kernel reports them. Linux reports twice the size asked for, the extra
+// half being its own bookkeeping.
+func socketBuffers(pc net.PacketConn) (receive, send int, err error) {
+	sc, ok := pc.(syscall.Conn)
+	if !ok {
+		return 0, 0, syscall.EINVAL
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		receive, serr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+		if serr == nil {
+			send, serr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF)
+		}
+	}); err != nil {
+		return 0, 0, err
+	}
+	return receive, send, serr
+}
+
+// logUDPBuffers logs the buffer sizes pc ended up with, once per bridge,
+// when any were asked for. It runs once quic-go has the socket, since
+// quic-go may raise them further.
+func (s *SalmonQuic) logUDPBuffers(side string, pc net.PacketConn, receive, send int) {
+	if pc == nil || (receive == 0 && send == 0) {
+		return
+	}
+	s.udpBuffersLogged.Do(func() {
+		gotReceive, gotSend, err := socketBuffers(pc)
+		if err != nil {
+			log.Printf("%s: Bridge %s could not read its UDP socket buffer sizes: %v", side, s.BridgeName, err)
+			return
+		}
+		log.Printf("%s: Bridge %s UDP socket buffers are %d bytes receive (asked for %d), %d bytes send (asked for %d)",
+			side, s.BridgeName, gotReceive, receive, gotSend, send)
+		if gotReceive < receive || gotSend < send {
+			log.Printf("%s: Bridge %s got smaller UDP socket buffers than asked for, raise net.core.rmem_max and net.core.wmem_max or run with CAP_NET_ADMIN",
+				side, s.BridgeName)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package connections
+
+import (
+	"net"
+	"runtime"
+	"testing"
+)
+
+const testUDPBuffer = 8 * 1024 * 1024
+
+// checkUDPBuffers fails unless pc has at least the test buffer sizes, and
+// skips when the kernel capped them for lack of CAP_NET_ADMIN.
+func checkUDPBuffers(t *testing.T, pc net.PacketConn) {
+	t.Helper()
+	receive, send, err := socketBuffers(pc)
+	if err != nil {
+		t.Fatalf("reading buffer sizes failed: %v", err)
+	}
+	if receive < testUDPBuffer || send < testUDPBuffer {
+		t.Skipf("kernel capped the buffers at %d/%d bytes, needs CAP_NET_ADMIN or larger net.core.rmem_max/wmem_max", receive, send)
+	}
+}
+
+func TestListenFarSocketUDPBuffers(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("socket buffer options are only set on Linux")
+	}
+	sq := NewSalmonQuic(0, "", "udp-buffers-far", nil, nil, "")
+	defer sq.Close()
+
+	pc, err := sq.listenFarSocket("udp", 0, false)
+	if err != nil {
+		t.Fatalf("listenFarSocket failed: %v", err)
+	}
+	receive, _, err := socketBuffers(pc)
+	pc.Close()
+	if err != nil {
+		t.Fatalf("reading buffer sizes failed: %v", err)
+	}
+	if receive >= testUDPBuffer {
+		t.Skipf("the default receive buffer is already %d bytes", receive)
+	}
+
+	sq.SetUDPBuffers(testUDPBuffer, testUDPBuffer)
+	pc, err = sq.listenFarSocket("udp", 0, false)
+	if err != nil {
+		t.Fatalf("listenFarSocket failed: %v", err)
+	}
+	defer pc.Close()
+	checkUDPBuffers(t, pc)
+}
+
+func TestDialUsesUDPBuffers(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("socket buffer options are only set on Linux")
+	}
+	port, clientTLSConfig, qcfg := startDiscardServer(t)
+	sq := NewSalmonQuic(port, "127.0.0.1", "udp-buffers-near", clientTLSConfig, qcfg, "")
+	defer sq.Close()
+	sq.SetUDPBuffers(testUDPBuffer, testUDPBuffer)
+
+	stream, cleanup, err, qconn := sq.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	defer cleanup()
+	defer stream.Close()
+
+	qconn.mu.Lock()
+	pc := qconn.pconn
+	qconn.mu.Unlock()
+	if pc == nil {
+		t.Fatalf("expected the near to dial from a socket of its own")
+	}
+	checkUDPBuffers(t, pc)
+}
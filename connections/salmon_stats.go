@@ -0,0 +1,97 @@
+package connections
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// ByteCounter counts the bytes streams move over one QUIC connection. A nil
+// counter ignores adds, so callers need not check for one.
+type ByteCounter struct {
+	sent     atomic.Uint64
+	received atomic.Uint64
+}
+
+func (c *ByteCounter) AddSent(n int) {
+	if c != nil && n > 0 {
+		c.sent.Add(uint64(n))
+	}
+}
+
+func (c *ByteCounter) AddReceived(n int) {
+	if c != nil && n > 0 {
+		c.received.Add(uint64(n))
+	}
+}
+
+func (c *ByteCounter) Sent() uint64     { return c.sent.Load() }
+func (c *ByteCounter) Received() uint64 { return c.received.Load() }
+
+// farConn is a connection accepted by the far listener.
+type farConn struct {
+	createdAt time.Time
+	streams   atomic.Int32
+	bytes     ByteCounter
+}
+
+// ConnectionStats is a snapshot of one QUIC connection of a bridge, pooled
+// on the near side or accepted on the far side. Sent and received are
+// stream payload bytes as seen by this side.
+type ConnectionStats struct {
+	RemoteAddr    string
+	CreatedAt     time.Time
+	ActiveStreams int32
+	BytesSent     uint64
+	BytesReceived uint64
+	Draining      bool
+}
+
+// Stats returns a snapshot of the bridge's open QUIC connections, oldest
+// first, so an uneven spread of load across the pool shows up.
+func (s *SalmonQuic) Stats() []ConnectionStats {
+	s.connectionsMu.RLock()
+	defer s.connectionsMu.RUnlock()
+	var stats []ConnectionStats
+	for _, qc := range s.connections {
+		qc.mu.Lock()
+		conn := qc.conn
+		qc.mu.Unlock()
+		if conn == nil {
+			continue
+		}
+		stats = append(stats, ConnectionStats{
+			RemoteAddr:    conn.RemoteAddr().String(),
+			CreatedAt:     qc.createdAt,
+			ActiveStreams: atomic.LoadInt32(&qc.activeStreams),
+			BytesSent:     qc.bytes.Sent(),
+			BytesReceived: qc.bytes.Received(),
+			Draining:      qc.draining,
+		})
+	}
+	if s.far != nil {
+		for conn, fc := range s.far.conns {
+			stats = append(stats, ConnectionStats{
+				RemoteAddr:    conn.RemoteAddr().String(),
+				CreatedAt:     fc.createdAt,
+				ActiveStreams: fc.streams.Load(),
+				BytesSent:     fc.bytes.Sent(),
+				BytesReceived: fc.bytes.Received(),
+			})
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].CreatedAt.Before(stats[j].CreatedAt) })
+	return stats
+}
+
+// StreamCounter returns the byte counter of the connection stream belongs
+// to, or nil once the stream has been cleaned up or was not opened by this
+// bridge.
+func (s *SalmonQuic) StreamCounter(stream *quic.Stream) *ByteCounter {
+	if c, ok := s.streamCounters.Load(stream); ok {
+		return c.(*ByteCounter)
+	}
+	return nil
+}
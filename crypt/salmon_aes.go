@@ -4,7 +4,10 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
 	"errors"
+	"io"
 	"net"
 	"salmoncannon/utils"
 	"time"
@@ -15,17 +18,144 @@ type aesCtrConn struct {
 	readInitialised  bool
 	writeInitialised bool
 	sharedSecret     string
-	ctrReadCipher    cipher.Stream
-	ctrWriteCipher   cipher.Stream
+	readCtr          *ctrKeystream
+	writeCtr         *ctrKeystream
 	encReadBuf       []byte
 	encWriteBuf      []byte
-	iv               []byte
-	key              []byte
 }
 
 const keyRandomHashSizeBytes = 32
 const aesKeySizeBytes = 32
 
+// Rotate the CTR key/IV after this many bytes in each direction so a long
+// lived tunnel doesn't run a single keystream forever.
+const updateKeyAfterBytes = 100 * 1024 * 1024
+
+var rekeyLabel = []byte("salmon-cannon-ctr-rekey")
+
+// Every rotation is announced in-band: after updateKeyAfterBytes of payload
+// the writer sends a rekeyMarkerSize marker under the old key, then both
+// sides switch. A reader that finds anything else there has lost its place
+// in the stream and fails with ErrRekeyMarker instead of decrypting garbage.
+const rekeyMarkerSize = 16
+
+// ErrRekeyMarker is returned by Read when the marker at a key rotation does
+// not match, meaning the two ends disagree on their position in the stream.
+var ErrRekeyMarker = errors.New("aes-ctr: rekey marker mismatch, tunnel out of sync")
+
+// rekeyMarker returns the marker closing key generation gen.
+func rekeyMarker(gen int) [rekeyMarkerSize]byte {
+	var m [rekeyMarkerSize]byte
+	copy(m[:], "salmon-rekey")
+	binary.BigEndian.PutUint32(m[12:], uint32(gen))
+	return m
+}
+
+// ctrKeystream is one direction of an aesCtrConn. Both ends of a tunnel see
+// the same byte sequence per direction and rotate at the same payload
+// offset, which the rekey marker between the two keys confirms.
+type ctrKeystream struct {
+	stream     cipher.Stream
+	key        []byte
+	iv         []byte
+	pos        int64 // payload bytes processed under the current key
+	generation int   // number of rotations so far
+
+	marker     [rekeyMarkerSize]byte // marker bytes read so far, reading side only
+	markerRead int
+}
+
+func newCtrKeystream(key []byte, iv []byte) (*ctrKeystream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &ctrKeystream{
+		stream: cipher.NewCTR(block, iv),
+		key:    append([]byte(nil), key...),
+		iv:     append([]byte(nil), iv...),
+	}, nil
+}
+
+// wireSize returns how many wire bytes carry the next n payload bytes,
+// counting the markers in between and the rest of one being read.
+func (k *ctrKeystream) wireSize(n int) int {
+	pending := 0
+	pos := k.pos
+	if pos == updateKeyAfterBytes {
+		pending = rekeyMarkerSize - k.markerRead
+		pos = 0
+	}
+	return pending + n + int((pos+int64(n))/updateKeyAfterBytes)*rekeyMarkerSize
+}
+
+// seal encrypts src into dst, which must hold wireSize(len(src)) bytes,
+// adding a marker and rotating the key on every updateKeyAfterBytes
+// boundary, even mid-buffer. It returns the bytes to send.
+func (k *ctrKeystream) seal(dst []byte, src []byte) []byte {
+	n := 0
+	for len(src) > 0 {
+		chunk := int(min(int64(len(src)), updateKeyAfterBytes-k.pos))
+		k.stream.XORKeyStream(dst[n:n+chunk], src[:chunk])
+		k.pos += int64(chunk)
+		n += chunk
+		src = src[chunk:]
+		if k.pos == updateKeyAfterBytes {
+			marker := rekeyMarker(k.generation)
+			k.stream.XORKeyStream(dst[n:n+rekeyMarkerSize], marker[:])
+			n += rekeyMarkerSize
+			k.rekey()
+		}
+	}
+	return dst[:n]
+}
+
+// open decrypts the wire bytes in src into dst, taking out and checking
+// each rekey marker, and returns how many payload bytes it wrote. dst must
+// hold the payload src carries, see wireSize.
+func (k *ctrKeystream) open(dst []byte, src []byte) (int, error) {
+	n := 0
+	for len(src) > 0 {
+		if k.pos == updateKeyAfterBytes {
+			take := min(len(src), rekeyMarkerSize-k.markerRead)
+			k.stream.XORKeyStream(k.marker[k.markerRead:k.markerRead+take], src[:take])
+			k.markerRead += take
+			src = src[take:]
+			if k.markerRead == rekeyMarkerSize {
+				if k.marker != rekeyMarker(k.generation) {
+					return n, ErrRekeyMarker
+				}
+				k.markerRead = 0
+				k.rekey()
+			}
+			continue
+		}
+		chunk := int(min(int64(len(src)), updateKeyAfterBytes-k.pos))
+		k.stream.XORKeyStream(dst[n:n+chunk], src[:chunk])
+		k.pos += int64(chunk)
+		n += chunk
+		src = src[chunk:]
+	}
+	return n, nil
+}
+
+// rekey derives the next key and IV from the current ones.
+func (k *ctrKeystream) rekey() {
+	h := sha512.New()
+	h.Write(rekeyLabel)
+	h.Write(k.key)
+	h.Write(k.iv)
+	sum := h.Sum(nil)
+
+	k.key = sum[:aesKeySizeBytes]
+	k.iv = sum[aesKeySizeBytes : aesKeySizeBytes+aes.BlockSize]
+	// A 32 byte key is always valid for AES-256
+	block, _ := aes.NewCipher(k.key)
+	k.stream = cipher.NewCTR(block, k.iv)
+	k.pos = 0
+	k.generation++
+}
+
 func EncryptBytesWithSecret(plainText []byte, sharedSecret string) ([]byte, error) {
 	plaintextIv := make([]byte, aes.BlockSize)
 	if _, err := rand.Read(plaintextIv); err != nil {
@@ -117,31 +247,45 @@ func DecryptBytesWithSecret(cipherText []byte, sharedSecret string) ([]byte, err
 }
 
 func (t *aesCtrConn) Read(p []byte) (int, error) {
-	// Resize enc buffer if needed
-	if t.encReadBuf == nil || len(t.encReadBuf) < len(p) {
-		t.encReadBuf = make([]byte, len(p))
+	for {
+		// Room for len(p) payload bytes and the markers among them
+		size := t.readCtr.wireSize(len(p))
+		if t.encReadBuf == nil || len(t.encReadBuf) < size {
+			t.encReadBuf = make([]byte, size)
+		}
+
+		// Bytes that arrive with an error (e.g. io.EOF after a FIN) still count
+		n, err := t.Conn.Read(t.encReadBuf[:size])
+
+		// Decrypt data, dropping rekey markers
+		m, openErr := t.readCtr.open(p, t.encReadBuf[:n])
+		if openErr != nil {
+			return m, openErr
+		}
+		if err == io.EOF && t.readCtr.markerRead > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		// A read that only carried a marker has nothing to return yet
+		if m > 0 || err != nil || len(p) == 0 {
+			return m, err
+		}
 	}
-
-	n, err := t.Conn.Read(t.encReadBuf)
-	if err != nil {
-		return n, err
-	}
-
-	// Decrypt data
-	t.ctrReadCipher.XORKeyStream(p[:n], t.encReadBuf[:n])
-
-	return n, nil
 }
 
 func (t *aesCtrConn) Write(p []byte) (int, error) {
-	// Encrypt and write data
-	if t.encWriteBuf == nil || len(t.encWriteBuf) < len(p) {
-		t.encWriteBuf = make([]byte, len(p))
+	// Encrypt and write data, with a marker at every key rotation
+	size := t.writeCtr.wireSize(len(p))
+	if t.encWriteBuf == nil || len(t.encWriteBuf) < size {
+		t.encWriteBuf = make([]byte, size)
 	}
 
-	t.ctrWriteCipher.XORKeyStream(t.encWriteBuf[:len(p)], p)
+	sealed := t.writeCtr.seal(t.encWriteBuf[:size], p)
 
-	return t.Conn.Write(t.encWriteBuf[:len(p)])
+	n, err := t.Conn.Write(sealed)
+	if err != nil {
+		return min(n, len(p)), err
+	}
+	return len(p), nil
 }
 
 func (t *aesCtrConn) Close() error {
@@ -170,15 +314,13 @@ func (t *aesCtrConn) SetWriteDeadline(tm time.Time) error {
 
 // WrapConn wraps a net.Conn so all reads/writes are encrypted/decrypted
 func AesWrapConn(c net.Conn, readIv []byte, readKey []byte, writeIv []byte, writeKey []byte) *aesCtrConn {
-	readBlock, err := aes.NewCipher(readKey)
+	readCtr, err := newCtrKeystream(readKey, readIv)
 	if err != nil {
 		return nil
 	}
-	ctrReadCipher := cipher.NewCTR(readBlock, readIv)
-	writeBlock, err := aes.NewCipher(writeKey)
+	writeCtr, err := newCtrKeystream(writeKey, writeIv)
 	if err != nil {
 		return nil
 	}
-	ctrWriteCipher := cipher.NewCTR(writeBlock, writeIv)
-	return &aesCtrConn{Conn: c, writeInitialised: false, readInitialised: false, ctrReadCipher: ctrReadCipher, ctrWriteCipher: ctrWriteCipher}
+	return &aesCtrConn{Conn: c, writeInitialised: false, readInitialised: false, readCtr: readCtr, writeCtr: writeCtr}
 }
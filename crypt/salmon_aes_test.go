@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"io"
 	"net"
 	"testing"
 	"time"
@@ -105,6 +107,59 @@ func TestAesWrapQuicStream(t *testing.T) {
 	}
 }
 
+// eofConn returns its last bytes together with io.EOF, as a QUIC stream
+// does when the data and the FIN arrive in the same frame.
+type eofConn struct {
+	*mockNetConn
+}
+
+func (c eofConn) Read(p []byte) (int, error) {
+	n, _ := c.readBuf.Read(p)
+	return n, io.EOF
+}
+
+func TestAesReadDecryptsBytesWithEOF(t *testing.T) {
+	iv := make([]byte, 16)
+	key := make([]byte, 32)
+	rand.Read(iv)
+	rand.Read(key)
+
+	wire := newMockNetConn()
+	AesWrapConn(wire, iv, key, iv, key).Write([]byte("last words"))
+
+	reader := AesWrapConn(eofConn{&mockNetConn{readBuf: wire.writeBuf}}, iv, key, iv, key)
+	buf := make([]byte, 32)
+	n, err := reader.Read(buf)
+	if err != io.EOF || string(buf[:n]) != "last words" {
+		t.Fatalf("expected decrypted bytes with EOF, got %q %v", buf[:n], err)
+	}
+}
+
+// fragmentConn hands out at most 3 bytes per Read, like a stream under loss.
+type fragmentConn struct {
+	net.Conn
+}
+
+func (c fragmentConn) Read(p []byte) (int, error) {
+	return c.Conn.Read(p[:min(len(p), 3)])
+}
+
+func TestAesReadFragmented(t *testing.T) {
+	iv := make([]byte, 16)
+	key := make([]byte, 32)
+	rand.Read(iv)
+	rand.Read(key)
+
+	msg := []byte("keys and IVs travel in the stream header, the payload may come in pieces")
+	wire := newMockNetConn()
+	AesWrapConn(wire, iv, key, iv, key).Write(msg)
+
+	got, err := io.ReadAll(AesWrapConn(fragmentConn{&mockNetConn{readBuf: wire.writeBuf}}, iv, key, iv, key))
+	if err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("expected %q from short reads, got %q %v", msg, got, err)
+	}
+}
+
 func TestAesEncryptDecrypt(t *testing.T) {
 	clientToServer := newMockNetConn()
 	serverToClient := newMockNetConn()
@@ -268,3 +323,109 @@ func TestAesEncryptDecryptLarge(t *testing.T) {
 		t.Fatalf("Decrypted data doesn't match original. Too long to print.")
 	}
 }
+
+func TestAesKeyRotationRoundTrip(t *testing.T) {
+	clientToServer := newMockNetConn()
+	serverToClient := newMockNetConn()
+
+	readIv := make([]byte, 16)
+	writeIv := make([]byte, 16)
+	readKey := make([]byte, 32)
+	writeKey := make([]byte, 32)
+	rand.Read(readIv)
+	rand.Read(writeIv)
+	rand.Read(readKey)
+	rand.Read(writeKey)
+
+	clientConn := AesWrapConn(clientToServer, readIv, readKey, writeIv, writeKey)
+	serverConn := AesWrapConn(serverToClient, writeIv, writeKey, readIv, readKey)
+
+	// Cross the rotation boundary with an odd chunk size so it lands mid-write
+	testData := make([]byte, updateKeyAfterBytes+(5*1024*1024)+17)
+	rand.Read(testData)
+
+	chunkSize := 3*1024*1024 + 7
+	for start := 0; start < len(testData); start += chunkSize {
+		end := min(start+chunkSize, len(testData))
+		if _, err := clientConn.Write(testData[start:end]); err != nil {
+			t.Fatalf("Client write failed: %v", err)
+		}
+	}
+
+	if clientConn.writeCtr.generation != 1 {
+		t.Fatalf("Expected write key to rotate once, got %d rotations", clientConn.writeCtr.generation)
+	}
+	if bytes.Equal(clientConn.writeCtr.key, writeKey) {
+		t.Fatalf("Write key did not change after rotation")
+	}
+
+	serverToClient.readBuf = bytes.NewBuffer(clientToServer.writeBuf.Bytes())
+
+	readBuf := make([]byte, len(testData))
+	total := 0
+	for total < len(testData) {
+		n, err := serverConn.Read(readBuf[total:min(total+chunkSize/2, len(testData))])
+		if err != nil {
+			t.Fatalf("Server read failed after %d bytes: %v", total, err)
+		}
+		total += n
+	}
+
+	if serverConn.readCtr.generation != 1 {
+		t.Fatalf("Expected read key to rotate once, got %d rotations", serverConn.readCtr.generation)
+	}
+	if !bytes.Equal(serverConn.readCtr.key, clientConn.writeCtr.key) {
+		t.Fatalf("Reader and writer disagree on the rotated key")
+	}
+	if !bytes.Equal(readBuf, testData) {
+		t.Fatalf("Decrypted data doesn't match original across key rotation")
+	}
+
+	// Ciphertext after the boundary must differ from what the original key produces
+	orig, _ := newCtrKeystream(writeKey, writeIv)
+	expectedWithoutRotation := make([]byte, len(testData))
+	orig.stream.XORKeyStream(expectedWithoutRotation, testData)
+	sent := clientToServer.writeBuf.Bytes()
+	if len(sent) != len(testData)+rekeyMarkerSize {
+		t.Fatalf("Expected one %d byte rekey marker on the wire, got %d extra bytes", rekeyMarkerSize, len(sent)-len(testData))
+	}
+	if !bytes.Equal(sent[:updateKeyAfterBytes], expectedWithoutRotation[:updateKeyAfterBytes]) {
+		t.Fatalf("Ciphertext before the boundary should use the original key")
+	}
+	if bytes.Equal(sent[updateKeyAfterBytes+rekeyMarkerSize:], expectedWithoutRotation[updateKeyAfterBytes:]) {
+		t.Fatalf("Ciphertext after the boundary was produced with the original key")
+	}
+}
+
+// A reader that has lost its place sees a bad marker at the rotation and
+// fails instead of decrypting the rest with the wrong key.
+func TestAesKeyRotationMarkerMismatch(t *testing.T) {
+	clientToServer := newMockNetConn()
+	serverToClient := newMockNetConn()
+
+	iv := make([]byte, 16)
+	key := make([]byte, 32)
+	rand.Read(iv)
+	rand.Read(key)
+
+	clientConn := AesWrapConn(clientToServer, iv, key, iv, key)
+	serverConn := AesWrapConn(serverToClient, iv, key, iv, key)
+
+	testData := make([]byte, updateKeyAfterBytes+1024)
+	if _, err := clientConn.Write(testData); err != nil {
+		t.Fatalf("Client write failed: %v", err)
+	}
+	sent := clientToServer.writeBuf.Bytes()
+	// Drop one byte before the boundary, as if the two ends drifted apart
+	drifted := append(append([]byte(nil), sent[:1000]...), sent[1001:]...)
+	serverToClient.readBuf = bytes.NewBuffer(drifted)
+
+	readBuf := make([]byte, 4*1024*1024)
+	var err error
+	for err == nil {
+		_, err = serverConn.Read(readBuf)
+	}
+	if !errors.Is(err, ErrRekeyMarker) {
+		t.Fatalf("Expected ErrRekeyMarker, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const CipherModeCtr = "ctr"
+const CipherModeGcm = "gcm"
+
+// Largest plaintext sealed into a single frame. Bigger writes are split.
+const gcmMaxPlaintextSize = 16 * 1024
+const gcmFrameHeaderSize = 4
+
+// ErrAuthFailed is returned by Read when a frame fails GCM authentication,
+// meaning the tunnel payload was tampered with, replayed, reordered, cut
+// short or keys don't match.
+var ErrAuthFailed = errors.New("aes-gcm: frame authentication failed")
+
+// aesGcmConn seals every Write into a length-prefixed frame of ciphertext +
+// tag, and opens/verifies each frame on Read. Each direction has its own
+// key and numbers its frames from 0; a frame's number is its nonce and is
+// not sent, so a frame that is replayed, reordered, dropped or reflected
+// back at its sender fails authentication.
+type aesGcmConn struct {
+	Conn       net.Conn
+	readAead   cipher.AEAD
+	writeAead  cipher.AEAD
+	readSeq    uint64 // number of the next frame Read opens
+	writeSeq   uint64 // number of the next frame Write seals
+	readNonce  [12]byte
+	writeNonce [12]byte
+	readBuf    []byte // opened plaintext not yet returned to the caller
+	frameBuf   []byte
+	writeBuf   []byte
+	hdrBuf     [gcmFrameHeaderSize]byte // kept here as a local escapes per frame
+}
+
+func (t *aesGcmConn) maxFrameSize() int {
+	return gcmMaxPlaintextSize + t.readAead.Overhead()
+}
+
+// seqNonce writes frame number seq into nonce, big endian in the last 8
+// bytes.
+func seqNonce(nonce *[12]byte, seq uint64) []byte {
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce[:]
+}
+
+func (t *aesGcmConn) Read(p []byte) (int, error) {
+	if len(t.readBuf) == 0 {
+		if _, err := io.ReadFull(t.Conn, t.hdrBuf[:]); err != nil {
+			return 0, err
+		}
+		size := int(binary.BigEndian.Uint32(t.hdrBuf[:]))
+		// Checked before allocating so a bad length cannot ask for 4GB
+		if size < t.readAead.Overhead() || size > t.maxFrameSize() {
+			return 0, fmt.Errorf("%w: invalid frame size %d", ErrAuthFailed, size)
+		}
+		if cap(t.frameBuf) < size {
+			t.frameBuf = make([]byte, t.maxFrameSize())
+		}
+		frame := t.frameBuf[:size]
+		if _, err := io.ReadFull(t.Conn, frame); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		plain, err := t.readAead.Open(frame[:0], seqNonce(&t.readNonce, t.readSeq), frame, nil)
+		if err != nil {
+			return 0, ErrAuthFailed
+		}
+		t.readSeq++
+		t.readBuf = plain
+	}
+
+	n := copy(p, t.readBuf)
+	t.readBuf = t.readBuf[n:]
+	return n, nil
+}
+
+func (t *aesGcmConn) Write(p []byte) (int, error) {
+	if t.writeBuf == nil {
+		t.writeBuf = make([]byte, gcmFrameHeaderSize+gcmMaxPlaintextSize+t.writeAead.Overhead())
+	}
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > gcmMaxPlaintextSize {
+			chunk = chunk[:gcmMaxPlaintextSize]
+		}
+
+		sealed := t.writeAead.Seal(t.writeBuf[gcmFrameHeaderSize:gcmFrameHeaderSize], seqNonce(&t.writeNonce, t.writeSeq), chunk, nil)
+		t.writeSeq++
+		binary.BigEndian.PutUint32(t.writeBuf[:gcmFrameHeaderSize], uint32(len(sealed)))
+
+		if _, err := t.Conn.Write(t.writeBuf[:gcmFrameHeaderSize+len(sealed)]); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+func (t *aesGcmConn) Close() error {
+	return t.Conn.Close()
+}
+
+func (t *aesGcmConn) LocalAddr() net.Addr {
+	return t.Conn.LocalAddr()
+}
+
+func (t *aesGcmConn) RemoteAddr() net.Addr {
+	return t.Conn.RemoteAddr()
+}
+
+func (t *aesGcmConn) SetDeadline(tm time.Time) error {
+	return t.Conn.SetDeadline(tm)
+}
+
+func (t *aesGcmConn) SetReadDeadline(tm time.Time) error {
+	return t.Conn.SetReadDeadline(tm)
+}
+
+func (t *aesGcmConn) SetWriteDeadline(tm time.Time) error {
+	return t.Conn.SetWriteDeadline(tm)
+}
+
+// AesGcmWrapConn wraps a net.Conn so all writes are sealed with writeKey and
+// all reads are authenticated with readKey using AES-GCM. The peer swaps
+// the two. Returns nil if either is not a valid AES key.
+func AesGcmWrapConn(c net.Conn, readKey, writeKey []byte) *aesGcmConn {
+	readAead, err := newGcm(readKey)
+	if err != nil {
+		return nil
+	}
+	writeAead, err := newGcm(writeKey)
+	if err != nil {
+		return nil
+	}
+	return &aesGcmConn{Conn: c, readAead: readAead, writeAead: writeAead}
+}
+
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
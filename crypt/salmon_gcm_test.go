@@ -0,0 +1,264 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// gcmPair wraps both ends of a tunnel with fresh per-direction keys.
+func gcmPair(client, server *mockNetConn) (*aesGcmConn, *aesGcmConn) {
+	up := make([]byte, 32)
+	down := make([]byte, 32)
+	rand.Read(up)
+	rand.Read(down)
+	return AesGcmWrapConn(client, down, up), AesGcmWrapConn(server, up, down)
+}
+
+// gcmFrames splits sealed output into its frames, length prefix included.
+func gcmFrames(t *testing.T, sealed []byte) [][]byte {
+	t.Helper()
+	var frames [][]byte
+	for len(sealed) > 0 {
+		size := gcmFrameHeaderSize + int(binary.BigEndian.Uint32(sealed))
+		if size > len(sealed) {
+			t.Fatalf("truncated frame")
+		}
+		frames = append(frames, sealed[:size])
+		sealed = sealed[size:]
+	}
+	return frames
+}
+
+func TestAesGcmEncryptDecryptBiDi(t *testing.T) {
+	clientToServer := newMockNetConn()
+	serverToClient := newMockNetConn()
+
+	clientConn, serverConn := gcmPair(clientToServer, serverToClient)
+
+	testData := []byte("Hello, World! This is a test message.")
+
+	n, err := clientConn.Write(testData)
+	if err != nil {
+		t.Fatalf("Client write failed: %v", err)
+	}
+	if n != len(testData) {
+		t.Fatalf("Client write: expected %d bytes, got %d", len(testData), n)
+	}
+
+	if bytes.Contains(clientToServer.writeBuf.Bytes(), testData) {
+		t.Fatalf("Plaintext found in sealed frame")
+	}
+
+	serverToClient.readBuf = bytes.NewBuffer(clientToServer.writeBuf.Bytes())
+
+	readBuf := make([]byte, len(testData))
+	n, err = serverConn.Read(readBuf)
+	if err != nil {
+		t.Fatalf("Server read failed: %v", err)
+	}
+	if !bytes.Equal(readBuf[:n], testData) {
+		t.Fatalf("Decrypted data doesn't match original.\nExpected: %s\nGot: %s", testData, readBuf[:n])
+	}
+
+	n, err = serverConn.Write(testData)
+	if err != nil {
+		t.Fatalf("Server write failed: %v", err)
+	}
+	if n != len(testData) {
+		t.Fatalf("Server write: expected %d bytes, got %d", len(testData), n)
+	}
+
+	clientToServer.readBuf = bytes.NewBuffer(serverToClient.writeBuf.Bytes())
+
+	readBuf = make([]byte, len(testData))
+	n, err = clientConn.Read(readBuf)
+	if err != nil {
+		t.Fatalf("Client read failed: %v", err)
+	}
+	if !bytes.Equal(readBuf[:n], testData) {
+		t.Fatalf("Decrypted data doesn't match original.\nExpected: %s\nGot: %s", testData, readBuf[:n])
+	}
+}
+
+func TestAesGcmEncryptDecryptLarge(t *testing.T) {
+	clientToServer := newMockNetConn()
+	serverToClient := newMockNetConn()
+
+	clientConn, serverConn := gcmPair(clientToServer, serverToClient)
+
+	// Spans many frames, including a partial final frame
+	testData := make([]byte, 5*1024*1024+123)
+	rand.Read(testData)
+
+	n, err := clientConn.Write(testData)
+	if err != nil {
+		t.Fatalf("Client write failed: %v", err)
+	}
+	if n != len(testData) {
+		t.Fatalf("Client write: expected %d bytes, got %d", len(testData), n)
+	}
+
+	serverToClient.readBuf = bytes.NewBuffer(clientToServer.writeBuf.Bytes())
+
+	// Read with an odd sized buffer so frames are split across reads
+	got := make([]byte, 0, len(testData))
+	readBuf := make([]byte, 7000)
+	for len(got) < len(testData) {
+		n, err := serverConn.Read(readBuf)
+		if err != nil {
+			t.Fatalf("Server read failed after %d bytes: %v", len(got), err)
+		}
+		got = append(got, readBuf[:n]...)
+	}
+
+	if !bytes.Equal(got, testData) {
+		t.Fatalf("Decrypted data doesn't match original. Too long to print.")
+	}
+}
+
+func TestAesGcmTamperedFrameFailsAuth(t *testing.T) {
+	clientToServer := newMockNetConn()
+	serverToClient := newMockNetConn()
+
+	clientConn, serverConn := gcmPair(clientToServer, serverToClient)
+
+	if _, err := clientConn.Write([]byte("do not touch")); err != nil {
+		t.Fatalf("Client write failed: %v", err)
+	}
+
+	sealed := clientToServer.writeBuf.Bytes()
+	// Flip a bit in the ciphertext (past the length prefix)
+	sealed[len(sealed)-20] ^= 0x01
+	serverToClient.readBuf = bytes.NewBuffer(sealed)
+
+	readBuf := make([]byte, 64)
+	_, err := serverConn.Read(readBuf)
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Expected ErrAuthFailed, got %v", err)
+	}
+}
+
+// Frames are numbered per direction, so replaying, reordering, dropping or
+// reflecting one breaks authentication even though every frame is genuine.
+func TestAesGcmFrameOrderIsAuthenticated(t *testing.T) {
+	clientToServer := newMockNetConn()
+	serverToClient := newMockNetConn()
+	clientConn, _ := gcmPair(clientToServer, serverToClient)
+	for _, msg := range []string{"first", "second", "third"} {
+		if _, err := clientConn.Write([]byte(msg)); err != nil {
+			t.Fatalf("Client write failed: %v", err)
+		}
+	}
+	frames := gcmFrames(t, clientToServer.writeBuf.Bytes())
+	if len(frames) != 3 {
+		t.Fatalf("Expected 3 frames, got %d", len(frames))
+	}
+
+	cases := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{"replayed", [][]byte{frames[0], frames[0]}},
+		{"reordered", [][]byte{frames[1], frames[0]}},
+		{"dropped", [][]byte{frames[0], frames[2]}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// A fresh server with the same keys reads what the client sent
+			server := &aesGcmConn{Conn: newMockNetConn(), readAead: clientConn.writeAead, writeAead: clientConn.readAead}
+			server.Conn.(*mockNetConn).readBuf = bytes.NewBuffer(bytes.Join(c.frames, nil))
+			var err error
+			for range c.frames {
+				if _, err = server.Read(make([]byte, 64)); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, ErrAuthFailed) {
+				t.Fatalf("Expected ErrAuthFailed, got %v", err)
+			}
+		})
+	}
+
+	// A frame sent back to its writer fails, the directions use other keys
+	clientToServer.readBuf = bytes.NewBuffer(frames[0])
+	if _, err := clientConn.Read(make([]byte, 64)); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Expected a reflected frame to fail with ErrAuthFailed, got %v", err)
+	}
+}
+
+func TestAesGcmOversizedFrameRejected(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	mock := newMockNetConn()
+	conn := AesGcmWrapConn(mock, key, key)
+
+	// A corrupt or hostile length prefix asking for a 4GB frame
+	mock.readBuf = bytes.NewBuffer([]byte{0xff, 0xff, 0xff, 0xff})
+	readBuf := make([]byte, 64)
+	if _, err := conn.Read(readBuf); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Expected ErrAuthFailed, got %v", err)
+	}
+	if conn.frameBuf != nil {
+		t.Fatalf("Expected no frame buffer for a rejected length, got %d bytes", cap(conn.frameBuf))
+	}
+}
+
+func TestAesGcmWrongKeyFailsAuth(t *testing.T) {
+	clientToServer := newMockNetConn()
+	serverToClient := newMockNetConn()
+
+	key := make([]byte, 32)
+	otherKey := make([]byte, 32)
+	rand.Read(key)
+	rand.Read(otherKey)
+
+	clientConn := AesGcmWrapConn(clientToServer, otherKey, key)
+	serverConn := AesGcmWrapConn(serverToClient, otherKey, otherKey)
+
+	if _, err := clientConn.Write([]byte("wrong key")); err != nil {
+		t.Fatalf("Client write failed: %v", err)
+	}
+	serverToClient.readBuf = bytes.NewBuffer(clientToServer.writeBuf.Bytes())
+
+	readBuf := make([]byte, 64)
+	if _, err := serverConn.Read(readBuf); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Expected ErrAuthFailed, got %v", err)
+	}
+}
+
+func TestAesGcmWrapConnInvalidKey(t *testing.T) {
+	key := make([]byte, 32)
+	if c := AesGcmWrapConn(newMockNetConn(), []byte("short"), key); c != nil {
+		t.Fatalf("Expected nil conn for invalid read key")
+	}
+	if c := AesGcmWrapConn(newMockNetConn(), key, []byte("short")); c != nil {
+		t.Fatalf("Expected nil conn for invalid write key")
+	}
+}
+
+// BenchmarkAesGcmFraming seals and opens one full frame per op. Each conn
+// reuses its frame buffers, so steady state relaying should not allocate.
+func BenchmarkAesGcmFraming(b *testing.B) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	loop := newMockNetConn()
+	loop.readBuf = loop.writeBuf
+	conn := AesGcmWrapConn(loop, key, key)
+
+	payload := bytes.Repeat([]byte{0x5a}, gcmMaxPlaintextSize)
+	out := make([]byte, len(payload))
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(payload); err != nil {
+			b.Fatalf("write failed: %v", err)
+		}
+		if _, err := io.ReadFull(conn, out); err != nil {
+			b.Fatalf("read failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/ratelimit"
+)
+
+// How often ClientRateLimiter drops the buckets of clients that went quiet.
+const clientSweepEvery = time.Minute
+
+// ClientRateLimiter caps how fast each client IP may open new connections,
+// with a token bucket per IP. It limits connection setup, not bandwidth.
+type ClientRateLimiter struct {
+	rate  float64
+	burst int64
+
+	mu        sync.Mutex
+	buckets   map[string]*ratelimit.Bucket
+	lastSweep time.Time
+}
+
+// NewClientRateLimiter allows each IP rate new connections per second, and
+// up to burst at once. It returns nil, which allows everything, when rate
+// is not positive.
+func NewClientRateLimiter(rate float64, burst int) *ClientRateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &ClientRateLimiter{
+		rate:      rate,
+		burst:     int64(burst),
+		buckets:   make(map[string]*ratelimit.Bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token for ip, reporting false if it is opening connections
+// too fast.
+func (l *ClientRateLimiter) Allow(ip string) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Since(l.lastSweep) >= clientSweepEvery {
+		l.sweep()
+	}
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = ratelimit.NewBucketWithRate(l.rate, l.burst)
+		l.buckets[ip] = b
+	}
+	return b.TakeAvailable(1) == 1
+}
+
+// sweep drops full buckets: their client has been idle long enough that a
+// fresh bucket would behave the same. Callers hold mu.
+func (l *ClientRateLimiter) sweep() {
+	for ip, b := range l.buckets {
+		if b.Available() >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastSweep = time.Now()
+}
+
+// Clients returns how many client IPs are being tracked.
+func (l *ClientRateLimiter) Clients() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
@@ -0,0 +1,63 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientRateLimiter_ThrottlesPerIP(t *testing.T) {
+	l := NewClientRateLimiter(1, 3)
+	for i := 0; i < 3; i++ {
+		if !l.Allow("10.0.0.1") {
+			t.Fatalf("connection %d within the burst was refused", i+1)
+		}
+	}
+	if l.Allow("10.0.0.1") {
+		t.Fatalf("expected a connection past the burst to be refused")
+	}
+	if !l.Allow("10.0.0.2") {
+		t.Fatalf("expected another IP to be unaffected")
+	}
+}
+
+func TestClientRateLimiter_Refills(t *testing.T) {
+	l := NewClientRateLimiter(20, 1)
+	if !l.Allow("10.0.0.1") || l.Allow("10.0.0.1") {
+		t.Fatalf("expected a burst of one")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !l.Allow("10.0.0.1") {
+		t.Fatalf("expected a token after the refill interval")
+	}
+}
+
+func TestClientRateLimiter_SweepsIdleClients(t *testing.T) {
+	l := NewClientRateLimiter(100, 1)
+	l.Allow("10.0.0.1")
+	l.Allow("10.0.0.2")
+	if got := l.Clients(); got != 2 {
+		t.Fatalf("expected 2 tracked clients, got %d", got)
+	}
+
+	// Both buckets are full again after 10ms
+	time.Sleep(50 * time.Millisecond)
+	l.mu.Lock()
+	l.lastSweep = time.Now().Add(-clientSweepEvery)
+	l.mu.Unlock()
+	l.Allow("10.0.0.3")
+	if got := l.Clients(); got != 1 {
+		t.Fatalf("expected idle clients to be dropped, %d tracked", got)
+	}
+}
+
+func TestClientRateLimiter_Disabled(t *testing.T) {
+	l := NewClientRateLimiter(0, 5)
+	if l != nil {
+		t.Fatalf("expected no limiter for a zero rate")
+	}
+	for i := 0; i < 100; i++ {
+		if !l.Allow("10.0.0.1") {
+			t.Fatalf("nil limiter refused a connection")
+		}
+	}
+}
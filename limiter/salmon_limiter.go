@@ -2,39 +2,68 @@ package limiter
 
 import (
 	"net"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/juju/ratelimit"
 )
 
 const theoreticalMaxBandwidth = 500 * 1024 * 1024 * 1024 // 500 GB/s - lol
 
-// throttledConn wraps net.Conn and applies a bandwidth limit on Read and Write
+// GetActiveRate averages throughput over roughly this much recent history.
+const rateWindow = 5 * time.Second
+
+// Minimum gap between the byte count snapshots GetActiveRate keeps, so
+// frequent callers don't grow the history.
+const rateSnapshotEvery = 250 * time.Millisecond
+
+// throttledConn wraps net.Conn and applies a bandwidth limit on Read and Write.
+// When limiter is set the bucket is looked up on every call so rate changes
+// made with SetRate apply to connections that are already open.
 type throttledConn struct {
 	net.Conn
 	bucket    *ratelimit.Bucket
+	limiter   *SharedLimiter
 	dataCount *uint64
 }
 
+func (t *throttledConn) currentBucket() *ratelimit.Bucket {
+	if t.limiter != nil {
+		return t.limiter.currentBucket()
+	}
+	return t.bucket
+}
+
 func (t *throttledConn) Read(p []byte) (int, error) {
 	n, err := t.Conn.Read(p)
 	if n > 0 {
-		t.bucket.Wait(int64(n))
-		atomic.AddUint64(t.dataCount, uint64(len(p)))
+		t.currentBucket().Wait(int64(n))
+		atomic.AddUint64(t.dataCount, uint64(n))
 	}
 	return n, err
 }
 
 func (t *throttledConn) Write(p []byte) (int, error) {
-	t.bucket.Wait(int64(len(p)))
+	t.currentBucket().Wait(int64(len(p)))
 	atomic.AddUint64(t.dataCount, uint64(len(p)))
 	return t.Conn.Write(p)
 }
 
 type SharedLimiter struct {
+	mu        sync.RWMutex
 	bucket    *ratelimit.Bucket
 	maxRate   int64
 	dataCount *uint64
+
+	rateMu    sync.Mutex
+	snapshots []rateSnapshot // oldest first, guarded by rateMu
+}
+
+// rateSnapshot is the byte counter at a point in time.
+type rateSnapshot struct {
+	at    time.Time
+	count uint64
 }
 
 func NewSharedLimiter(bytesPerSec int64) *SharedLimiter {
@@ -43,16 +72,71 @@ func NewSharedLimiter(bytesPerSec int64) *SharedLimiter {
 	}
 	dataCount := uint64(0)
 	b := ratelimit.NewBucketWithRate(float64(bytesPerSec), bytesPerSec)
-	return &SharedLimiter{bucket: b, maxRate: bytesPerSec, dataCount: &dataCount}
+	return &SharedLimiter{bucket: b, maxRate: bytesPerSec, dataCount: &dataCount,
+		snapshots: []rateSnapshot{{at: time.Now()}}}
+}
+
+// SetRate replaces the limit. Connections already wrapped by this limiter
+// pick up the new rate on their next read or write.
+func (l *SharedLimiter) SetRate(bytesPerSec int64) {
+	if bytesPerSec <= 0 {
+		bytesPerSec = theoreticalMaxBandwidth
+	}
+	b := ratelimit.NewBucketWithRate(float64(bytesPerSec), bytesPerSec)
+	l.mu.Lock()
+	l.bucket = b
+	l.maxRate = bytesPerSec
+	l.mu.Unlock()
+}
+
+func (l *SharedLimiter) currentBucket() *ratelimit.Bucket {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.bucket
 }
 
 // WrapConn wraps a net.Conn so all reads/writes are limited
 func (l *SharedLimiter) WrapConn(c net.Conn) net.Conn {
-	return &throttledConn{Conn: c, bucket: l.bucket, dataCount: l.dataCount}
+	return &throttledConn{Conn: c, limiter: l, dataCount: l.dataCount}
+}
+
+// WrapConnRate wraps a net.Conn in a bucket of its own, so it is held to
+// bytesPerSec whatever other connections do. Traffic through it is not
+// counted by any SharedLimiter.
+func WrapConnRate(c net.Conn, bytesPerSec int64) net.Conn {
+	dataCount := uint64(0)
+	b := ratelimit.NewBucketWithRate(float64(bytesPerSec), bytesPerSec)
+	return &throttledConn{Conn: c, bucket: b, dataCount: &dataCount}
 }
 
+// GetActiveRate returns the measured throughput in bytes per second,
+// averaged from the oldest snapshot within the last rateWindow.
 func (l *SharedLimiter) GetActiveRate() int64 {
-	return l.maxRate - l.bucket.Available()
+	return l.activeRateAt(time.Now(), atomic.LoadUint64(l.dataCount))
+}
+
+func (l *SharedLimiter) activeRateAt(now time.Time, count uint64) int64 {
+	l.rateMu.Lock()
+	defer l.rateMu.Unlock()
+
+	// Keep the newest snapshot that is at least rateWindow old as the baseline
+	cutoff := now.Add(-rateWindow)
+	drop := 0
+	for drop+1 < len(l.snapshots) && !l.snapshots[drop+1].at.After(cutoff) {
+		drop++
+	}
+	l.snapshots = l.snapshots[drop:]
+	base := l.snapshots[0]
+
+	if now.Sub(l.snapshots[len(l.snapshots)-1].at) >= rateSnapshotEvery {
+		l.snapshots = append(l.snapshots, rateSnapshot{at: now, count: count})
+	}
+
+	elapsed := now.Sub(base.at).Seconds()
+	if elapsed <= 0 || count < base.count {
+		return 0
+	}
+	return int64(float64(count-base.count) / elapsed)
 }
 
 func (l *SharedLimiter) GetBytesTransferred() uint64 {
@@ -61,5 +145,13 @@ func (l *SharedLimiter) GetBytesTransferred() uint64 {
 }
 
 func (l *SharedLimiter) GetMaxRate() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 	return l.maxRate
 }
+
+// Unlimited reports whether the limiter was created or last set without a
+// rate, so GetMaxRate is only a placeholder.
+func (l *SharedLimiter) Unlimited() bool {
+	return l.GetMaxRate() >= theoreticalMaxBandwidth
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -144,3 +145,121 @@ func TestNewSharedLimiter_NegativeZero(t *testing.T) {
 		t.Fatal("expected max bandwith SharedLimiter and bucket for a <1 limit")
 	}
 }
+
+func TestSharedLimiter_SetRateAppliesToWrappedConns(t *testing.T) {
+	sl := NewSharedLimiter(1e6)
+	fc := newFakeConn("")
+	conn := sl.WrapConn(fc)
+
+	sl.SetRate(2048)
+	if sl.GetMaxRate() != 2048 {
+		t.Fatalf("expected max rate 2048, got %d", sl.GetMaxRate())
+	}
+	tc := conn.(*throttledConn)
+	if tc.currentBucket() != sl.currentBucket() {
+		t.Fatal("expected wrapped conn to use the replaced bucket")
+	}
+	if r := tc.currentBucket().Rate(); r < 2047 || r > 2049 {
+		t.Errorf("expected bucket rate 2048, got %f", tc.currentBucket().Rate())
+	}
+
+	if sl.Unlimited() {
+		t.Errorf("expected a 2048 rate not to be unlimited")
+	}
+
+	sl.SetRate(0)
+	if sl.GetMaxRate() != theoreticalMaxBandwidth {
+		t.Errorf("expected <1 rate to fall back to max bandwidth, got %d", sl.GetMaxRate())
+	}
+	if !sl.Unlimited() {
+		t.Errorf("expected a 0 rate to be unlimited")
+	}
+}
+
+func TestSharedLimiter_GetActiveRateMeasuresThroughput(t *testing.T) {
+	sl := NewSharedLimiter(0) // unlimited, the old bucket based rate was meaningless here
+	conn := sl.WrapConn(newFakeConn(""))
+
+	if rate := sl.GetActiveRate(); rate != 0 {
+		t.Fatalf("expected 0 rate before any traffic, got %d", rate)
+	}
+
+	// 10 x 100KB over ~1s is ~1MB/s
+	chunk := make([]byte, 100*1024)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		conn.Write(chunk)
+		time.Sleep(100 * time.Millisecond)
+	}
+	want := float64(10*len(chunk)) / time.Since(start).Seconds()
+
+	rate := float64(sl.GetActiveRate())
+	if rate < want*0.8 || rate > want*1.2 {
+		t.Errorf("expected rate near %.0f B/s, got %.0f", want, rate)
+	}
+	if got := sl.GetBytesTransferred(); got != uint64(10*len(chunk)) {
+		t.Errorf("expected %d bytes transferred, got %d", 10*len(chunk), got)
+	}
+}
+
+func TestSharedLimiter_ActiveRateWindowSlides(t *testing.T) {
+	sl := NewSharedLimiter(0)
+	start := sl.snapshots[0].at
+
+	// 1MB/s for the first 10s, then idle
+	for sec := 1; sec <= 10; sec++ {
+		sl.activeRateAt(start.Add(time.Duration(sec)*time.Second), uint64(sec)*1000000)
+	}
+	if rate := sl.activeRateAt(start.Add(10*time.Second), 10000000); rate < 900000 || rate > 1100000 {
+		t.Errorf("expected ~1MB/s while busy, got %d", rate)
+	}
+
+	// Once the window has passed with no traffic the rate drops to 0
+	for sec := 11; sec <= 16; sec++ {
+		sl.activeRateAt(start.Add(time.Duration(sec)*time.Second), 10000000)
+	}
+	if rate := sl.activeRateAt(start.Add(16*time.Second), 10000000); rate != 0 {
+		t.Errorf("expected 0 after going idle, got %d", rate)
+	}
+	if len(sl.snapshots) > int(rateWindow/time.Second)+2 {
+		t.Errorf("expected old snapshots to be dropped, have %d", len(sl.snapshots))
+	}
+}
+
+func TestWrapConnRate_LayeredUnderShared(t *testing.T) {
+	const sharedRate, streamRate = 400 * 1024, 100 * 1024
+	sl := NewSharedLimiter(sharedRate)
+	limited := sl.WrapConn(WrapConnRate(newFakeConn(""), streamRate))
+	unlimited := sl.WrapConn(newFakeConn(""))
+
+	// Both streams write flat out for a second
+	deadline := time.Now().Add(time.Second)
+	var sent [2]int
+	var wg sync.WaitGroup
+	for i, c := range []net.Conn{limited, unlimited} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chunk := make([]byte, 4*1024)
+			for time.Now().Before(deadline) {
+				c.Write(chunk)
+				if time.Now().Before(deadline) {
+					sent[i] += len(chunk)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	streamSent, total := sent[0], sent[0]+sent[1]
+
+	// A full bucket plus a second's refill, with some slack
+	if streamSent > streamRate*2*11/10 {
+		t.Errorf("limited stream sent %d bytes, over its %d B/s cap", streamSent, streamRate)
+	}
+	if total > sharedRate*2*11/10 {
+		t.Errorf("streams sent %d bytes in total, over the shared %d B/s cap", total, sharedRate)
+	}
+	if sent[1] <= streamSent*2 {
+		t.Errorf("expected the unlimited stream to use the spare shared capacity, got %d and %d", sent[0], sent[1])
+	}
+}
@@ -0,0 +1,98 @@
+// Package logging emits bridge events either as the usual text log lines or
+// as structured JSON for log shippers.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Event types
+const (
+	EventOpen        = "open"
+	EventClose       = "close"
+	EventDialFailure = "dial_failure"
+	EventPing        = "ping"
+	EventAccess      = "access" // one per finished tunnel
+)
+
+// Event describes something that happened on a bridge. Zero fields are
+// left out of the structured output.
+type Event struct {
+	Bridge  string
+	Type    string
+	Target  string
+	Latency time.Duration
+	Err     error
+
+	// Access summary of a finished tunnel
+	Client    string
+	BytesUp   int64 // client to target
+	BytesDown int64 // target to client
+	Duration  time.Duration
+	Reason    string // what ended it
+}
+
+// jsonLogger is set when the json format is active
+var jsonLogger atomic.Pointer[slog.Logger]
+
+// Setup selects the log format. With json every line written through the
+// log package, not only events, becomes a JSON object on w. slog
+// serialises writes, so concurrent callers are safe.
+func Setup(format string, w io.Writer) error {
+	switch format {
+	case "", FormatText:
+		jsonLogger.Store(nil)
+	case FormatJSON:
+		l := slog.New(slog.NewJSONHandler(w, nil))
+		slog.SetDefault(l)
+		jsonLogger.Store(l)
+	default:
+		return fmt.Errorf("unknown log format %q (must be %q or %q)", format, FormatText, FormatJSON)
+	}
+	return nil
+}
+
+// Log records e. In text mode format and args are printed like log.Printf;
+// in json mode they become the message and e's fields are attached. An
+// empty format only logs in json mode, for events too chatty for text logs.
+func Log(e Event, format string, args ...any) {
+	l := jsonLogger.Load()
+	if l == nil {
+		if format != "" {
+			log.Printf(format, args...)
+		}
+		return
+	}
+
+	msg := e.Type
+	if format != "" {
+		msg = fmt.Sprintf(format, args...)
+	}
+	attrs := []any{"bridge", e.Bridge, "event", e.Type}
+	if e.Target != "" {
+		attrs = append(attrs, "target", e.Target)
+	}
+	if e.Latency > 0 {
+		attrs = append(attrs, "latency_ms", e.Latency.Milliseconds())
+	}
+	if e.Type == EventAccess {
+		attrs = append(attrs, "client", e.Client, "bytes_up", e.BytesUp, "bytes_down", e.BytesDown,
+			"duration_ms", e.Duration.Milliseconds(), "reason", e.Reason)
+	}
+	if e.Err != nil {
+		attrs = append(attrs, "error", e.Err.Error())
+		l.Warn(msg, attrs...)
+		return
+	}
+	l.Info(msg, attrs...)
+}
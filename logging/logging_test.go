@@ -0,0 +1,158 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent writers
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func restoreLogging(t *testing.T) {
+	old := slog.Default()
+	t.Cleanup(func() {
+		jsonLogger.Store(nil)
+		slog.SetDefault(old)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+}
+
+func TestSetupRejectsUnknownFormat(t *testing.T) {
+	if err := Setup("xml", os.Stderr); err == nil {
+		t.Fatalf("expected unknown format to be rejected")
+	}
+}
+
+func TestTextFormatUsesLogPackage(t *testing.T) {
+	restoreLogging(t)
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	if err := Setup(FormatText, &buf); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	Log(Event{Bridge: "b", Type: EventClose}, "NEAR: Bridge %s closed", "b")
+	Log(Event{Bridge: "b", Type: EventOpen}, "")
+	if got := buf.String(); got != "NEAR: Bridge b closed\n" {
+		t.Fatalf("unexpected text output %q", got)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	restoreLogging(t)
+	var buf syncBuffer
+	if err := Setup(FormatJSON, &buf); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	Log(Event{Bridge: "b", Type: EventDialFailure, Target: "example.com:443", Latency: 25 * time.Millisecond,
+		Err: errors.New("refused")}, "dial %s failed", "example.com:443")
+	Log(Event{Bridge: "b", Type: EventPing, Latency: 3 * time.Millisecond}, "")
+	log.Printf("plain line")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d: %q", len(lines), buf.String())
+	}
+	var first map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("invalid json %q: %v", lines[0], err)
+	}
+	want := map[string]any{"msg": "dial example.com:443 failed", "level": "WARN", "bridge": "b",
+		"event": EventDialFailure, "target": "example.com:443", "latency_ms": 25.0, "error": "refused"}
+	for k, v := range want {
+		if first[k] != v {
+			t.Errorf("field %s = %v, want %v", k, first[k], v)
+		}
+	}
+
+	var second, third map[string]any
+	json.Unmarshal([]byte(lines[1]), &second)
+	json.Unmarshal([]byte(lines[2]), &third)
+	if second["msg"] != EventPing || second["level"] != "INFO" {
+		t.Errorf("unexpected ping event %v", second)
+	}
+	if third["msg"] != "plain line" {
+		t.Errorf("expected log package output to be json, got %q", lines[2])
+	}
+}
+
+func TestJSONConcurrentWrites(t *testing.T) {
+	restoreLogging(t)
+	var buf syncBuffer
+	if err := Setup(FormatJSON, &buf); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				Log(Event{Bridge: "b", Type: EventOpen, Target: "t:1"}, "open %d", j)
+			}
+		}()
+	}
+	wg.Wait()
+
+	count := 0
+	scanner := bufio.NewScanner(strings.NewReader(buf.String()))
+	for scanner.Scan() {
+		if !json.Valid(scanner.Bytes()) {
+			t.Fatalf("interleaved line %q", scanner.Text())
+		}
+		count++
+	}
+	if count != 1000 {
+		t.Fatalf("expected 1000 lines, got %d", count)
+	}
+}
+
+func TestJSONAccessEvent(t *testing.T) {
+	restoreLogging(t)
+	var buf syncBuffer
+	if err := Setup(FormatJSON, &buf); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	Log(Event{Bridge: "b", Type: EventAccess, Target: "example.com:443", Client: "10.0.0.1:5000",
+		BytesUp: 120, BytesDown: 4096, Duration: 1500 * time.Millisecond, Reason: "client closed"}, "access")
+	var got map[string]any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(buf.String())), &got); err != nil {
+		t.Fatalf("invalid json %q: %v", buf.String(), err)
+	}
+	want := map[string]any{"event": EventAccess, "client": "10.0.0.1:5000", "target": "example.com:443",
+		"bytes_up": 120.0, "bytes_down": 4096.0, "duration_ms": 1500.0, "reason": "client closed"}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("field %s = %v, want %v", k, got[k], v)
+		}
+	}
+}
@@ -1,30 +1,37 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"io"
 	"log"
-	"net"
 	"os"
+	"os/signal"
 	"salmoncannon/api"
 	"salmoncannon/config"
-	"salmoncannon/connections"
+	"salmoncannon/logging"
 	"salmoncannon/status"
-	"strconv"
-	"sync"
-	"time"
+	"syscall"
 
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 const VERSION = "0.0.10"
 
+var configPath = flag.String("config", "scconfig.yml", "config file, or a directory of *.yml files to merge")
+var checkOnly = flag.Bool("check-config", false, "validate the config, print the effective settings and exit without starting bridges")
+
 func main() {
+	flag.Parse()
+	if *checkOnly {
+		os.Exit(checkConfig(*configPath, os.Stdout))
+	}
 	log.Printf("Salmon Cannon version %s starting...", VERSION)
 
 	// Start connection monitoring (logs every 30 seconds)
 	status.GlobalConnMonitorRef.StartPeriodicLogging()
 
-	cannonConfig, configErr := config.LoadConfig("scconfig.yml")
-	log.Printf("Loaded %d salmon bridges", len(cannonConfig.Bridges))
+	cannonConfig, configErr := config.LoadConfigPath(*configPath)
 
 	// If we cannot even read the config, log to a crash file.
 	if configErr != nil {
@@ -35,36 +42,34 @@ func main() {
 		}
 		log.Fatalf("Failed to load config: %v", configErr)
 	}
+	log.Printf("Loaded %d salmon bridges", len(cannonConfig.Bridges))
 
+	var logOutput io.Writer = os.Stderr
 	if len(cannonConfig.GlobalLog.Filename) != 0 {
-		log.SetOutput(&lumberjack.Logger{
+		logOutput = &lumberjack.Logger{
 			Filename:   cannonConfig.GlobalLog.Filename,
 			MaxSize:    cannonConfig.GlobalLog.MaxSize, // megabytes
 			MaxBackups: cannonConfig.GlobalLog.MaxBackups,
 			MaxAge:     cannonConfig.GlobalLog.MaxAge,   // days
 			Compress:   cannonConfig.GlobalLog.Compress, // optional
-		})
+		}
+		log.SetOutput(logOutput)
+	}
+	if err := logging.Setup(cannonConfig.GlobalLog.Format, logOutput); err != nil {
+		log.Fatalf("Failed to setup logging: %v", err)
+	}
+	if len(cannonConfig.GlobalLog.Filename) != 0 {
 		log.Printf("Salmon Cannon version %s starting...", VERSION)
 		log.Printf("Loaded %d salmon bridges", len(cannonConfig.Bridges))
 	}
 
-	// Setup QUIC parameters
-	if cannonConfig.QuicConfig != nil {
-		if cannonConfig.QuicConfig.MaxConnectionsPerBridge > 0 {
-			connections.MaxConnectionsPerBridge = int(cannonConfig.QuicConfig.MaxConnectionsPerBridge)
-		}
-		if cannonConfig.QuicConfig.MaxStreamsPerConnection > 0 {
-			connections.MaxStreamsPerConnection = int32(cannonConfig.QuicConfig.MaxStreamsPerConnection)
-		}
-		if cannonConfig.QuicConfig.IdleCleanupTimeout > 0 {
-			connections.ConnectionIdleTimeout = time.Duration(cannonConfig.QuicConfig.IdleCleanupTimeout)
-		}
-	}
+	bridgeRegistry := newNearRegistry() // Store references to near bridges
 
 	// Setup API server if configured
+	var apiServer *api.Server
 	if cannonConfig.ApiConfig != nil {
-		apiListenAddr := net.JoinHostPort(cannonConfig.ApiConfig.Hostname, strconv.Itoa(cannonConfig.ApiConfig.Port))
-		apiServer := api.NewServer(cannonConfig, apiListenAddr)
+		apiListenAddr := cannonConfig.ApiConfig.ListenAddr()
+		apiServer = api.NewServer(cannonConfig, apiListenAddr, bridgeRegistry)
 		err := apiServer.Start()
 		if err != nil {
 			log.Fatalf("API Server: failed to start API server: %v", err)
@@ -72,54 +77,65 @@ func main() {
 		log.Printf("API Server: HTTP API server started on %s", apiListenAddr)
 	}
 
-	var wg sync.WaitGroup
-	bridgeRegistry := make(map[string]*SalmonNear) // Store references to near bridges
-
-	for cb := range cannonConfig.Bridges {
-		wg.Add(1)
-		bridgeConfig := &cannonConfig.Bridges[cb] // Avoid closure capture bug
-		log.Printf("Setting up salmon bridge %s: %+v", bridgeConfig.Name, bridgeConfig)
-		go func(cfg *config.SalmonBridgeConfig) {
-			defer wg.Done()
-			if cfg.Connect {
-				log.Printf("NEAR: Starting bridge %s in Near mode...", cfg.Name)
-				near, err := NewSalmonNear(cfg)
-				if err != nil {
-					log.Fatalf("NEAR: Failed to setup SalmonNear: %v", err)
-				}
-				bridgeRegistry[cfg.Name] = near // Store reference
-				if cfg.HttpListenPort > 0 {
-					log.Printf("NEAR: HTTP proxy enabled on port %d", cfg.HttpListenPort)
-					go initHTTPNear(cfg, near)
-				}
-				initNear(cfg, near)
-			} else {
-				log.Printf("FAR: Starting bridge %s in Far mode...", cfg.Name)
-				far, err := NewSalmonFar(cfg)
-				if err != nil {
-					log.Fatalf("FAR: Failed to setup SalmonFar: %v", err)
-				}
-				err = far.farBridge.NewFarListen()
-				if err != nil {
-					log.Fatalf("FAR: Failed to start SalmonFar: %v", err)
-				}
-
-				select {}
-			}
-		}(bridgeConfig)
+	// Bandwidth history for /api/v1/status/history
+	historyCtx, stopHistory := context.WithCancel(context.Background())
+	defer stopHistory()
+	if cannonConfig.ApiConfig != nil {
+		status.GlobalConnMonitorRef.StartRateHistory(historyCtx,
+			cannonConfig.ApiConfig.HistoryInterval.Duration(), cannonConfig.ApiConfig.HistorySamples())
+	}
+
+	// Pushed metrics, for monitoring stacks that do not scrape /metrics
+	metricsCtx, stopMetrics := context.WithCancel(context.Background())
+	defer stopMetrics()
+	if m := cannonConfig.GlobalMetrics; m != nil {
+		err := status.GlobalConnMonitorRef.StartStatsD(metricsCtx, m.Endpoint, m.Interval.Duration(), m.Prefix)
+		if err != nil {
+			log.Fatalf("Failed to start StatsD exporter: %v", err)
+		}
+		log.Printf("Pushing metrics to StatsD at %s every %v", m.Endpoint, m.Interval.Duration())
+	}
+
+	manager := newBridgeManager(bridgeRegistry)
+	if apiServer != nil {
+		manager.onBridges = apiServer.SetBridges
+		apiServer.SetReloader(configReloader{m: manager, path: *configPath})
+	}
+	if err := manager.Start(cannonConfig.Bridges); err != nil {
+		log.Fatalf("Failed to start bridges: %v", err)
 	}
 
 	if cannonConfig.SocksRedirectConfig != nil {
-		wg.Add(1)
 		go func() {
-			defer wg.Done()
-			err := runSocksRedirector(cannonConfig.SocksRedirectConfig, &bridgeRegistry)
+			err := runSocksRedirector(cannonConfig.SocksRedirectConfig, bridgeRegistry)
 			if err != nil {
 				log.Fatalf("SOCKS Redirector: %v", err)
 			}
 		}()
 	}
 
-	wg.Wait()
+	// SIGHUP reloads the bridge list from the config file
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	go manager.watchReload(*configPath, sigs)
+
+	// SIGINT/SIGTERM drain the bridges before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	stop() // a second signal kills the process straight away
+
+	log.Printf("Salmon cannon shutting down, draining active streams...")
+	stopHistory()
+	// The API stays up while the bridges drain so /healthz can say so
+	if apiServer != nil {
+		apiServer.SetDraining()
+	}
+	manager.Shutdown(context.Background())
+	if apiServer != nil {
+		if err := apiServer.Stop(); err != nil {
+			log.Printf("API Server: shutdown error: %v", err)
+		}
+	}
 	log.Printf("Salmon cannon exiting.")
 }
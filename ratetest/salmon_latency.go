@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"salmoncannon/config"
+	"sort"
+	"time"
+)
+
+// latencyResult is one bridge's round trip profile. Times are in
+// milliseconds; jitter is the mean change between consecutive samples.
+type latencyResult struct {
+	Bridge   string  `json:"bridge"`
+	Samples  int     `json:"samples"`
+	MinMs    float64 `json:"min_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	P50Ms    float64 `json:"p50_ms"`
+	P90Ms    float64 `json:"p90_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+	JitterMs float64 `json:"jitter_ms"`
+	Error    string  `json:"error,omitempty"` // set when no sample could be taken
+}
+
+// RunLatency takes count round trips through every connecting bridge,
+// pausing interval between them, and reports percentiles per bridge.
+func (rt *SalmonRateTester) RunLatency(count int, interval time.Duration) {
+	// Echo straight back, a delay would count as latency
+	ln := startEchoResponder(0)
+	defer ln.Close()
+
+	results := []latencyResult{}
+	for _, bridge := range rt.cfg.Bridges {
+		if bridge.Connect {
+			results = append(results, rt.testLatencyBridge(bridge, count, interval))
+		}
+	}
+	log.Println("RateTester finished all latency tests.")
+
+	// Logs go to stderr, so stdout only carries the JSON
+	if rt.format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			log.Printf("Failed to write JSON results: %v", err)
+		}
+	}
+}
+
+func (rt *SalmonRateTester) testLatencyBridge(b config.SalmonBridgeConfig, count int, interval time.Duration) latencyResult {
+	addr := fmt.Sprintf("127.0.0.1:%d", b.SocksListenPort)
+	log.Printf("Testing bridge %s latency at %s with %d samples", b.Name, addr, count)
+
+	conn, err := socksConnect(addr, b.Name)
+	if err != nil {
+		log.Print(err)
+		return latencyResult{Bridge: b.Name, Error: err.Error()}
+	}
+	defer conn.Close()
+
+	pingMessage := []byte("ping")
+	buf := make([]byte, len(pingMessage))
+	samples := make([]time.Duration, 0, count)
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		start := time.Now()
+		if _, err = conn.Write(pingMessage); err != nil {
+			break
+		}
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			break
+		}
+		samples = append(samples, time.Since(start))
+	}
+
+	result := latencyStats(samples)
+	result.Bridge = b.Name
+	if err != nil {
+		log.Printf("Bridge %s: ping error after %d samples: %v", b.Name, len(samples), err)
+		if len(samples) == 0 {
+			result.Error = err.Error()
+			return result
+		}
+	}
+	log.Printf("Bridge %s: %d samples\n -   min %.2f ms, max %.2f ms\n -   p50 %.2f ms, p90 %.2f ms, p99 %.2f ms\n -   jitter %.2f ms",
+		b.Name, result.Samples, result.MinMs, result.MaxMs, result.P50Ms, result.P90Ms, result.P99Ms, result.JitterMs)
+	return result
+}
+
+// latencyStats summarises round trip samples, taken in order. Percentiles
+// use the nearest rank.
+func latencyStats(samples []time.Duration) latencyResult {
+	result := latencyResult{Samples: len(samples)}
+	if len(samples) == 0 {
+		return result
+	}
+
+	var jitter time.Duration
+	for i := 1; i < len(samples); i++ {
+		diff := samples[i] - samples[i-1]
+		if diff < 0 {
+			diff = -diff
+		}
+		jitter += diff
+	}
+	if len(samples) > 1 {
+		result.JitterMs = ms(jitter / time.Duration(len(samples)-1))
+	}
+
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := func(p int) float64 {
+		// ceil(p/100 * n), 1-based
+		i := (p*len(sorted) + 99) / 100
+		if i < 1 {
+			i = 1
+		}
+		return ms(sorted[i-1])
+	}
+	result.MinMs = ms(sorted[0])
+	result.MaxMs = ms(sorted[len(sorted)-1])
+	result.P50Ms = rank(50)
+	result.P90Ms = rank(90)
+	result.P99Ms = rank(99)
+	return result
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyStats(t *testing.T) {
+	if got := latencyStats(nil); got != (latencyResult{}) {
+		t.Fatalf("expected an empty result for no samples, got %+v", got)
+	}
+
+	// 1..100 ms, taken in order
+	var samples []time.Duration
+	for i := 1; i <= 100; i++ {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	got := latencyStats(samples)
+	want := latencyResult{Samples: 100, MinMs: 1, MaxMs: 100, P50Ms: 50, P90Ms: 90, P99Ms: 99, JitterMs: 1}
+	if got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+
+	// Order matters for jitter, not for percentiles
+	got = latencyStats([]time.Duration{10 * time.Millisecond, 30 * time.Millisecond, 10 * time.Millisecond})
+	if got.P50Ms != 10 || got.MaxMs != 30 || got.JitterMs != 20 {
+		t.Fatalf("unexpected stats for alternating samples: %+v", got)
+	}
+
+	got = latencyStats([]time.Duration{5 * time.Millisecond})
+	if got.P99Ms != 5 || got.JitterMs != 0 {
+		t.Fatalf("unexpected stats for one sample: %+v", got)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -9,6 +11,7 @@ import (
 	"net"
 	"os"
 	"salmoncannon/config"
+	"sync"
 	"time"
 )
 
@@ -21,11 +24,20 @@ func main() {
 	log.Printf("Salmon RateTest version %s starting...", VERSION)
 
 	// Define flags first before any other operations
-	mode := flag.String("mode", "test", "Mode: test, listen, pingpong")
+	mode := flag.String("mode", "test", "Mode: test, listen, pingpong, latency")
 	lp := flag.Int("lport", 5555, "Port to listen on")
 	cp := flag.Int("cport", 5555, "Port to connect to")
+	format := flag.String("format", "text", "Result format for test and latency modes: text, json")
+	streams := flag.Int("streams", 1, "Test mode: parallel SOCKS connections per bridge")
+	count := flag.Int("count", 100, "Latency mode: round trips to sample per bridge")
+	interval := flag.Duration("interval", 100*time.Millisecond, "Latency mode: pause between round trips")
 	flag.Parse()
 
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown format: %s\n", *format)
+		os.Exit(1)
+	}
+
 	LISTEN_PORT = *lp
 	CONNECT_PORT = *cp
 
@@ -37,17 +49,28 @@ func main() {
 		log.Fatalf("Failed to load config: %v", configErr)
 	}
 
-	tester := NewSalmonRateTester(cannonConfig)
+	tester := NewSalmonRateTester(cannonConfig, *format)
 	switch *mode {
 	case "test":
 		log.Printf("Starting rate test...")
-		tester.Run()
+		if *streams < 1 {
+			fmt.Fprintf(os.Stderr, "Streams must be at least 1, got %d\n", *streams)
+			os.Exit(1)
+		}
+		tester.Run(*streams)
 	case "listen":
 		log.Printf("Starting rate listen...")
 		tester.RunListen()
 	case "pingpong":
 		log.Printf("Starting pingpong mode...")
 		tester.RunPingPong()
+	case "latency":
+		if *count < 1 {
+			fmt.Fprintf(os.Stderr, "Count must be at least 1, got %d\n", *count)
+			os.Exit(1)
+		}
+		log.Printf("Starting latency mode...")
+		tester.RunLatency(*count, *interval)
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown mode: %s\n", *mode)
 		os.Exit(1)
@@ -55,23 +78,47 @@ func main() {
 }
 
 type SalmonRateTester struct {
-	cfg *config.SalmonCannonConfig
+	cfg    *config.SalmonCannonConfig
+	format string // "text" logs results, "json" also prints them to stdout
 }
 
-func NewSalmonRateTester(cfg *config.SalmonCannonConfig) *SalmonRateTester {
-	return &SalmonRateTester{cfg: cfg}
+// rateResult is one bridge's test outcome as printed with -format json.
+type rateResult struct {
+	Bridge  string  `json:"bridge"`
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+	Mbps    float64 `json:"mbps"`
+	Streams int     `json:"streams"`
+	Error   string  `json:"error,omitempty"` // set when the test could not run
+
+	PerStream []streamRate `json:"per_stream,omitempty"` // only with -streams above 1
 }
 
-func (rt *SalmonRateTester) RunPingPong() {
+// streamRate is what one of a bridge's parallel streams managed.
+type streamRate struct {
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+	Mbps    float64 `json:"mbps"`
+}
+
+func NewSalmonRateTester(cfg *config.SalmonCannonConfig, format string) *SalmonRateTester {
+	return &SalmonRateTester{cfg: cfg, format: format}
+}
+
+// startEchoResponder listens on LISTEN_PORT and echoes back whatever each
+// connection sends, pausing delay after every echo.
+func startEchoResponder(delay time.Duration) net.Listener {
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", LISTEN_PORT))
 	if err != nil {
-		log.Fatalf("PingPong responder failed to listen on %d: %v", LISTEN_PORT, err)
+		log.Fatalf("Echo responder failed to listen on %d: %v", LISTEN_PORT, err)
 	}
-	defer ln.Close()
-	log.Printf("PingPong responder listening on :%d", LISTEN_PORT)
+	log.Printf("Echo responder listening on :%d", LISTEN_PORT)
 	go func() {
 		for {
 			conn, err := ln.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			if err != nil {
 				log.Printf("Accept error: %v", err)
 				continue
@@ -94,12 +141,18 @@ func (rt *SalmonRateTester) RunPingPong() {
 						log.Printf("Write error: %v", err)
 						return
 					}
-					// wait for 3 seconds before next read
-					time.Sleep(3 * time.Second)
+					// wait before next read
+					time.Sleep(delay)
 				}
 			}(conn)
 		}
 	}()
+	return ln
+}
+
+func (rt *SalmonRateTester) RunPingPong() {
+	ln := startEchoResponder(3 * time.Second)
+	defer ln.Close()
 
 	for _, bridge := range rt.cfg.Bridges {
 		if bridge.Connect {
@@ -108,13 +161,75 @@ func (rt *SalmonRateTester) RunPingPong() {
 	}
 }
 
-func (rt *SalmonRateTester) Run() {
+// Run measures the throughput of every connecting bridge over streams
+// parallel SOCKS connections, summed into one rate per bridge.
+func (rt *SalmonRateTester) Run(streams int) {
+	results := []rateResult{}
 	for _, bridge := range rt.cfg.Bridges {
 		if bridge.Connect {
-			rt.testBridge(bridge)
+			results = append(results, rt.testBridge(bridge, streams))
 		}
 	}
 	log.Println("RateTester finished all tests.")
+
+	// Logs go to stderr, so stdout only carries the JSON
+	if rt.format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			log.Printf("Failed to write JSON results: %v", err)
+		}
+	}
+}
+
+// socksConnect opens a SOCKS5 CONNECT through the proxy at addr to
+// 127.0.0.1:CONNECT_PORT.
+func socksConnect(addr, bridgeName string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to bridge %s: %v", bridgeName, err)
+	}
+
+	// SOCKS5 handshake (no authentication)
+	handshake := []byte{0x05, 0x01, 0x00}
+	if _, err := conn.Write(handshake); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS handshake write error: %v", err)
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS handshake read error: %v", err)
+	}
+	if resp[0] != 0x05 || resp[1] != 0x00 {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS handshake failed: %v", resp)
+	}
+
+	// SOCKS5 CONNECT request to 127.0.0.1:CONNECT_PORT
+	targetPort := CONNECT_PORT
+	req := []byte{
+		0x05,         // version
+		0x01,         // CONNECT
+		0x00,         // reserved
+		0x01,         // IPv4
+		127, 0, 0, 1, // 127.0.0.1
+		byte(targetPort >> 8), byte(targetPort & 0xff), // port
+	}
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS CONNECT write error: %v", err)
+	}
+	resp = make([]byte, 10)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS CONNECT read error: %v", err)
+	}
+	if resp[1] != 0x00 {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS CONNECT failed: %v", resp)
+	}
+	return conn, nil
 }
 
 func (rt *SalmonRateTester) testPingBridge(b config.SalmonBridgeConfig) {
@@ -123,62 +238,10 @@ func (rt *SalmonRateTester) testPingBridge(b config.SalmonBridgeConfig) {
 	for {
 		log.Printf("Testing bridge %s at %s", b.Name, addr)
 
-		// 1. Connect to local SOCKS proxy
-		conn, err := net.Dial("tcp", addr)
+		// 1. Connect through the local SOCKS proxy
+		conn, err := socksConnect(addr, b.Name)
 		if err != nil {
-			log.Printf("Failed to connect to bridge %s: %v, retrying in 5 seconds...", b.Name, err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		// SOCKS5 handshake (no authentication)
-		handshake := []byte{0x05, 0x01, 0x00}
-		if _, err := conn.Write(handshake); err != nil {
-			log.Printf("SOCKS handshake write error: %v, retrying in 5 seconds...", err)
-			conn.Close()
-			time.Sleep(5 * time.Second)
-			continue
-		}
-		resp := make([]byte, 2)
-		if _, err := io.ReadFull(conn, resp); err != nil {
-			log.Printf("SOCKS handshake read error: %v, retrying in 5 seconds...", err)
-			conn.Close()
-			time.Sleep(5 * time.Second)
-			continue
-		}
-		if resp[0] != 0x05 || resp[1] != 0x00 {
-			log.Printf("SOCKS handshake failed: %v, retrying in 5 seconds...", resp)
-			conn.Close()
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		// SOCKS5 CONNECT request to 127.0.0.1:CONNECT_PORT
-		targetPort := CONNECT_PORT
-		req := []byte{
-			0x05,         // version
-			0x01,         // CONNECT
-			0x00,         // reserved
-			0x01,         // IPv4
-			127, 0, 0, 1, // 127.0.0.1
-			byte(targetPort >> 8), byte(targetPort & 0xff), // port
-		}
-		if _, err := conn.Write(req); err != nil {
-			log.Printf("SOCKS CONNECT write error: %v, retrying in 5 seconds...", err)
-			conn.Close()
-			time.Sleep(5 * time.Second)
-			continue
-		}
-		resp = make([]byte, 10)
-		if _, err := io.ReadFull(conn, resp); err != nil {
-			log.Printf("SOCKS CONNECT read error: %v, retrying in 5 seconds...", err)
-			conn.Close()
-			time.Sleep(5 * time.Second)
-			continue
-		}
-		if resp[1] != 0x00 {
-			log.Printf("SOCKS CONNECT failed: %v, retrying in 5 seconds...", resp)
-			conn.Close()
+			log.Printf("%v, retrying in 5 seconds...", err)
 			time.Sleep(5 * time.Second)
 			continue
 		}
@@ -246,64 +309,75 @@ func (rt *SalmonRateTester) RunListen() {
 	}
 }
 
-func (rt *SalmonRateTester) testBridge(b config.SalmonBridgeConfig) {
+func (rt *SalmonRateTester) testBridge(b config.SalmonBridgeConfig, streams int) rateResult {
 	addr := fmt.Sprintf("127.0.0.1:%d", b.SocksListenPort)
-	log.Printf("Testing bridge %s at %s", b.Name, addr)
+	log.Printf("Testing bridge %s at %s with %d streams", b.Name, addr, streams)
+	result := rateResult{Bridge: b.Name, Streams: streams}
 
-	// 1. Connect to local SOCKS proxy
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		log.Printf("Failed to connect to bridge %s: %v", b.Name, err)
-		return
+	// 1. Connect through the local SOCKS proxy, once per stream
+	conns := make([]net.Conn, 0, streams)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < streams; i++ {
+		conn, err := socksConnect(addr, b.Name)
+		if err != nil {
+			log.Print(err)
+			result.Error = err.Error()
+			return result
+		}
+		conns = append(conns, conn)
 	}
-	defer conn.Close()
 
-	// SOCKS5 handshake (no authentication)
-	handshake := []byte{0x05, 0x01, 0x00}
-	if _, err := conn.Write(handshake); err != nil {
-		log.Printf("SOCKS handshake write error: %v", err)
-		return
-	}
-	resp := make([]byte, 2)
-	if _, err := io.ReadFull(conn, resp); err != nil {
-		log.Printf("SOCKS handshake read error: %v", err)
-		return
-	}
-	if resp[0] != 0x05 || resp[1] != 0x00 {
-		log.Printf("SOCKS handshake failed: %v", resp)
-		return
-	}
+	timeSec := 10
+	log.Printf("Bridge %s: SOCKS CONNECT successful", b.Name)
+	log.Printf("Bridge %s: Starting %d sec test...", b.Name, timeSec)
 
-	// SOCKS5 CONNECT request to 127.0.0.1:5555
-	targetPort := CONNECT_PORT
-	req := []byte{
-		0x05,         // version
-		0x01,         // CONNECT
-		0x00,         // reserved
-		0x01,         // IPv4
-		127, 0, 0, 1, // 127.0.0.1
-		byte(targetPort >> 8), byte(targetPort & 0xff), // port
-	}
-	if _, err := conn.Write(req); err != nil {
-		log.Printf("SOCKS CONNECT write error: %v", err)
-		return
+	// 2. nSec ratetest: every stream sends garbage until the same end time
+	start := time.Now()
+	end := start.Add(time.Duration(timeSec) * time.Second)
+	perStream := make([]streamRate, streams)
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			perStream[i] = sendUntil(conn, end)
+		}()
 	}
-	resp = make([]byte, 10)
-	if _, err := io.ReadFull(conn, resp); err != nil {
-		log.Printf("SOCKS CONNECT read error: %v", err)
-		return
+	wg.Wait()
+	// Wall clock across all streams, so a slow finisher lowers the aggregate
+	secs := time.Since(start).Seconds()
+	if secs <= 0 {
+		secs = float64(timeSec)
 	}
-	if resp[1] != 0x00 {
-		log.Printf("SOCKS CONNECT failed: %v", resp)
-		return
+
+	var total int64
+	for i, sr := range perStream {
+		total += sr.Bytes
+		if streams > 1 {
+			log.Printf("Bridge %s: stream %d sent %d bytes in %.2f secs, %.2f mbps", b.Name, i+1, sr.Bytes, sr.Seconds, sr.Mbps)
+		}
 	}
+	kbps := float64(total) * 8 / 1024 / secs
+	mbps := float64(total) * 8 / (1024 * 1024) / secs
+	gbps := float64(total) * 8 / (1024 * 1024 * 1024) / secs
+	log.Printf("Bridge %s: Sent %d bytes in %.2f secs \n -   %.2f kbps\n -   %.2f mbps\n -   %.4f gbps", b.Name, total, secs, kbps, mbps, gbps)
 
-	timeSec := 10
-	log.Printf("Bridge %s: SOCKS CONNECT successful", b.Name)
-	log.Printf("Bridge %s: Starting %d sec test...", b.Name, timeSec)
+	result.Bytes = total
+	result.Seconds = secs
+	result.Mbps = mbps
+	if streams > 1 {
+		result.PerStream = perStream
+	}
+	return result
+}
 
-	// 2. nSec ratetest: send garbage
-	end := time.Now().Add(time.Duration(timeSec) * time.Second)
+// sendUntil writes random data to conn until end and reports how fast it
+// went.
+func sendUntil(conn net.Conn, end time.Time) streamRate {
 	total := 0
 	buf := make([]byte, 4096)
 	rand.Read(buf)
@@ -323,14 +397,10 @@ func (rt *SalmonRateTester) testBridge(b config.SalmonBridgeConfig) {
 			total += n
 		}
 	}
-	elapsed := time.Since(start)
-	secs := elapsed.Seconds()
-	if secs <= 0 {
-		secs = float64(timeSec)
+	secs := time.Since(start).Seconds()
+	sr := streamRate{Bytes: int64(total), Seconds: secs}
+	if secs > 0 {
+		sr.Mbps = float64(total) * 8 / (1024 * 1024) / secs
 	}
-
-	kbps := float64(total) * 8 / 1024 / secs
-	mbps := float64(total) * 8 / (1024 * 1024) / secs
-	gbps := float64(total) * 8 / (1024 * 1024 * 1024) / secs
-	log.Printf("Bridge %s: Sent %d bytes in %.2f secs \n -   %.2f kbps\n -   %.2f mbps\n -   %.4f gbps", b.Name, total, secs, kbps, mbps, gbps)
+	return sr
 }
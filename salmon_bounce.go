@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"sync"
@@ -10,21 +12,38 @@ import (
 	"salmoncannon/config"
 )
 
-// SalmonBounce is a user-space UDP relay that forwards packets based on a route map.
+const (
+	BounceProtocolUDP  = "udp"
+	BounceProtocolTCP  = "tcp"
+	BounceProtocolBoth = "both"
+)
+
+// SalmonBounce is a user-space UDP/TCP relay that forwards traffic based on a route map.
 // It maintains session state to support bidirectional forwarding without terminating QUIC.
 type SalmonBounce struct {
 	name        string
 	listenAddr  string
+	protocol    string
 	listenConn  *net.UDPConn
-	routeMap    map[string]string // client IP → backend address
+	tcpListener net.Listener
+	routeMap    map[string]string         // client IP → backend address(es)
+	pickers     map[string]*backendPicker // client IP → parsed route
 	idleTimeout time.Duration
 	sessions    map[string]*bounceSession
+	tcpConns    map[net.Conn]struct{} // open TCP client and backend conns
 	mu          sync.RWMutex
-	ctx         context.Context
-	cancel      context.CancelFunc
+
+	healthInterval time.Duration // 0 disables health checks
+	healthTimeout  time.Duration
+	health         map[string]bool // backend → passed last probe
+	healthMu       sync.RWMutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type bounceSession struct {
+	backend     string // backend picked for this session, pinned for its lifetime
 	clientAddr  *net.UDPAddr
 	backendAddr *net.UDPAddr
 	replyConn   *net.UDPConn
@@ -32,17 +51,37 @@ type bounceSession struct {
 	mu          sync.Mutex
 }
 
-// NewSalmonBounce creates a new UDP relay instance from config.
+// NewSalmonBounce creates a new relay instance from config.
 func NewSalmonBounce(cfg *config.SalmonBounceConfig) (*SalmonBounce, error) {
+	protocol := cfg.Protocol
+	switch protocol {
+	case "":
+		protocol = BounceProtocolUDP
+	case BounceProtocolUDP, BounceProtocolTCP, BounceProtocolBoth:
+	default:
+		return nil, fmt.Errorf("bounce %s: unknown protocol %q (must be %q, %q or %q)",
+			cfg.Name, cfg.Protocol, BounceProtocolUDP, BounceProtocolTCP, BounceProtocolBoth)
+	}
+	healthTimeout := cfg.HealthCheckTimeout.Duration()
+	if healthTimeout <= 0 {
+		healthTimeout = 2 * time.Second
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	return &SalmonBounce{
 		name:        cfg.Name,
 		listenAddr:  cfg.ListenAddr,
+		protocol:    protocol,
 		routeMap:    cfg.RouteMap,
 		idleTimeout: cfg.IdleTimeout.Duration(),
 		sessions:    make(map[string]*bounceSession),
+		pickers:     make(map[string]*backendPicker),
+		tcpConns:    make(map[net.Conn]struct{}),
 		ctx:         ctx,
 		cancel:      cancel,
+
+		healthInterval: cfg.HealthCheckInterval.Duration(),
+		healthTimeout:  healthTimeout,
+		health:         make(map[string]bool),
 	}, nil
 }
 
@@ -54,31 +93,56 @@ func NewSalmonBounceSimple(listenAddr string, routeMap map[string]string) (*Salm
 	return &SalmonBounce{
 		name:        "simple-bounce",
 		listenAddr:  listenAddr,
+		protocol:    BounceProtocolUDP,
 		routeMap:    routeMap,
 		idleTimeout: 60 * time.Second,
 		sessions:    make(map[string]*bounceSession),
+		pickers:     make(map[string]*backendPicker),
+		tcpConns:    make(map[net.Conn]struct{}),
 		ctx:         ctx,
 		cancel:      cancel,
+		health:      make(map[string]bool),
 	}, nil
 }
 
-// Start begins listening and forwarding UDP packets.
+// Start begins listening and forwarding on the configured protocol(s).
 func (b *SalmonBounce) Start() error {
-	addr, err := net.ResolveUDPAddr("udp", b.listenAddr)
-	if err != nil {
-		return err
+	udpAddr := b.listenAddr
+	if b.protocol == BounceProtocolTCP || b.protocol == BounceProtocolBoth {
+		ln, err := net.Listen("tcp", b.listenAddr)
+		if err != nil {
+			return err
+		}
+		b.tcpListener = ln
+		// Share the port with UDP even if the config asked for any free port
+		udpAddr = ln.Addr().String()
+		log.Printf("SalmonBounce[%s]: listening on tcp %s", b.name, ln.Addr())
+		go b.tcpListenLoop()
 	}
 
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		return err
-	}
-	b.listenConn = conn
+	if b.protocol == BounceProtocolUDP || b.protocol == BounceProtocolBoth {
+		addr, err := net.ResolveUDPAddr("udp", udpAddr)
+		if err != nil {
+			b.Stop()
+			return err
+		}
+
+		conn, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			b.Stop()
+			return err
+		}
+		b.listenConn = conn
 
-	log.Printf("SalmonBounce[%s]: listening on %s", b.name, b.listenAddr)
+		log.Printf("SalmonBounce[%s]: listening on %s", b.name, b.listenAddr)
 
-	go b.listenLoop()
-	go b.cleanupLoop()
+		go b.listenLoop()
+		go b.cleanupLoop()
+	}
+
+	if b.healthInterval > 0 {
+		go b.healthCheckLoop()
+	}
 
 	return nil
 }
@@ -86,10 +150,19 @@ func (b *SalmonBounce) Start() error {
 // Stop gracefully shuts down the bounce server.
 func (b *SalmonBounce) Stop() error {
 	b.cancel()
+	var err error
+	if b.tcpListener != nil {
+		err = b.tcpListener.Close()
+		b.mu.Lock()
+		for c := range b.tcpConns {
+			c.Close()
+		}
+		b.mu.Unlock()
+	}
 	if b.listenConn != nil {
 		return b.listenConn.Close()
 	}
-	return nil
+	return err
 }
 
 // listenLoop reads packets from the listen socket and forwards them.
@@ -111,15 +184,8 @@ func (b *SalmonBounce) listenLoop() {
 			continue
 		}
 
-		// Look up backend for this packet
-		backend := b.lookupRoute(clientAddr.IP.String())
-		if backend == "" {
-			log.Printf("SalmonBounce[%s]: no route for client %s", b.name, clientAddr)
-			continue
-		}
-
 		// Get or create session
-		sess, err := b.getOrCreateSession(clientAddr, backend)
+		sess, err := b.getOrCreateSession(clientAddr)
 		if err != nil {
 			log.Printf("SalmonBounce[%s]: session error: %v", b.name, err)
 			continue
@@ -145,7 +211,8 @@ func (b *SalmonBounce) lookupRoute(clientIP string) string {
 }
 
 // getOrCreateSession returns an existing session or creates a new one.
-func (b *SalmonBounce) getOrCreateSession(clientAddr *net.UDPAddr, backend string) (*bounceSession, error) {
+// New sessions pick a backend from the client's route.
+func (b *SalmonBounce) getOrCreateSession(clientAddr *net.UDPAddr) (*bounceSession, error) {
 	key := clientAddr.String()
 
 	b.mu.RLock()
@@ -156,6 +223,14 @@ func (b *SalmonBounce) getOrCreateSession(clientAddr *net.UDPAddr, backend strin
 		return sess, nil
 	}
 
+	backend, err := b.pickBackend(clientAddr.IP.String())
+	if err != nil {
+		return nil, err
+	}
+	if backend == "" {
+		return nil, fmt.Errorf("no route for client %s", clientAddr)
+	}
+
 	// Create new session
 	backendAddr, err := net.ResolveUDPAddr("udp", backend)
 	if err != nil {
@@ -169,6 +244,7 @@ func (b *SalmonBounce) getOrCreateSession(clientAddr *net.UDPAddr, backend strin
 	}
 
 	sess = &bounceSession{
+		backend:     backend,
 		clientAddr:  clientAddr,
 		backendAddr: backendAddr,
 		replyConn:   replyConn,
@@ -261,18 +337,158 @@ func (b *SalmonBounce) cleanupStaleSessions() {
 	}
 }
 
+// tcpListenLoop accepts TCP clients and relays each to its routed backend.
+func (b *SalmonBounce) tcpListenLoop() {
+	for {
+		conn, err := b.tcpListener.Accept()
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			log.Printf("SalmonBounce[%s]: tcp accept error: %v", b.name, err)
+			continue
+		}
+		go b.handleTCP(conn)
+	}
+}
+
+// trackTCP records an open conn so Stop can close it. It returns false if
+// the bounce is already stopping.
+func (b *SalmonBounce) trackTCP(c net.Conn) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ctx.Err() != nil {
+		return false
+	}
+	b.tcpConns[c] = struct{}{}
+	return true
+}
+
+func (b *SalmonBounce) untrackTCP(c net.Conn) {
+	b.mu.Lock()
+	delete(b.tcpConns, c)
+	b.mu.Unlock()
+	c.Close()
+}
+
+// handleTCP dials the backend for a client and splices the two together.
+func (b *SalmonBounce) handleTCP(client net.Conn) {
+	if !b.trackTCP(client) {
+		client.Close()
+		return
+	}
+	defer b.untrackTCP(client)
+
+	clientIP, _, _ := net.SplitHostPort(client.RemoteAddr().String())
+	backend, err := b.pickBackend(clientIP)
+	if err != nil {
+		log.Printf("SalmonBounce[%s]: bad route for tcp client %s: %v", b.name, client.RemoteAddr(), err)
+		return
+	}
+	if backend == "" {
+		log.Printf("SalmonBounce[%s]: no route for tcp client %s", b.name, client.RemoteAddr())
+		return
+	}
+
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	server, err := dialer.DialContext(b.ctx, "tcp", backend)
+	if err != nil {
+		log.Printf("SalmonBounce[%s]: tcp dial %s failed: %v", b.name, backend, err)
+		return
+	}
+	if !b.trackTCP(server) {
+		server.Close()
+		return
+	}
+	defer b.untrackTCP(server)
+
+	log.Printf("SalmonBounce[%s]: new tcp session %s → %s", b.name, client.RemoteAddr(), backend)
+	b.spliceTCP(client, server)
+}
+
+// spliceTCP copies both directions until either side closes or neither has
+// carried data for idleTimeout.
+func (b *SalmonBounce) spliceTCP(client, server net.Conn) {
+	var lastSeen activityClock
+	lastSeen.touch()
+
+	done := make(chan struct{}, 2)
+	copyDir := func(dst, src net.Conn) {
+		buf := make([]byte, 32*1024)
+		for {
+			src.SetReadDeadline(time.Now().Add(b.idleTimeout))
+			n, err := src.Read(buf)
+			if n > 0 {
+				lastSeen.touch()
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					break
+				}
+			}
+			if err != nil {
+				// A read timeout only ends the session if the other
+				// direction has been quiet as well
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() && lastSeen.since() < b.idleTimeout {
+					continue
+				}
+				if err != io.EOF {
+					break
+				}
+				if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+					cw.CloseWrite()
+				}
+				break
+			}
+		}
+		done <- struct{}{}
+	}
+
+	go copyDir(server, client)
+	go copyDir(client, server)
+
+	// Wait for the first direction to finish, then give the other the idle
+	// window to drain before tearing both down
+	<-done
+	select {
+	case <-done:
+	case <-time.After(b.idleTimeout):
+	case <-b.ctx.Done():
+	}
+}
+
+// activityClock is the last-activity timestamp shared by both splice directions.
+type activityClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (a *activityClock) touch() {
+	a.mu.Lock()
+	a.t = time.Now()
+	a.mu.Unlock()
+}
+
+func (a *activityClock) since() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return time.Since(a.t)
+}
+
 // AddRoute adds or updates a route in the route map.
 func (b *SalmonBounce) AddRoute(clientIP string, backend string) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
 	b.routeMap[clientIP] = backend
+	b.mu.Unlock()
 	log.Printf("SalmonBounce[%s]: added route %s → %s", b.name, clientIP, backend)
+	// The route may have replaced backends that nothing else uses
+	b.pruneHealth()
 }
 
 // RemoveRoute removes a route from the route map.
 func (b *SalmonBounce) RemoveRoute(clientIP string) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
 	delete(b.routeMap, clientIP)
+	delete(b.pickers, clientIP)
+	b.mu.Unlock()
 	log.Printf("SalmonBounce[%s]: removed route for IP %s", b.name, clientIP)
+	b.pruneHealth()
 }
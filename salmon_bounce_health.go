@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// isHealthy reports whether backend passed its last health check. Backends
+// that have not been probed yet are assumed healthy.
+func (b *SalmonBounce) isHealthy(backend string) bool {
+	b.healthMu.RLock()
+	defer b.healthMu.RUnlock()
+	healthy, probed := b.health[backend]
+	return !probed || healthy
+}
+
+// BackendHealth returns the result of the last probe for every backend that
+// has been checked.
+func (b *SalmonBounce) BackendHealth() map[string]bool {
+	b.healthMu.RLock()
+	defer b.healthMu.RUnlock()
+	out := make(map[string]bool, len(b.health))
+	for backend, healthy := range b.health {
+		out[backend] = healthy
+	}
+	return out
+}
+
+// routedBackends returns every distinct backend named in the route map.
+func (b *SalmonBounce) routedBackends() map[string]struct{} {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	backends := make(map[string]struct{})
+	for _, route := range b.routeMap {
+		parsed, err := parseBackends(route)
+		if err != nil {
+			continue
+		}
+		for _, wb := range parsed {
+			backends[wb.addr] = struct{}{}
+		}
+	}
+	return backends
+}
+
+// pruneHealth forgets backends that are no longer referenced by any route.
+func (b *SalmonBounce) pruneHealth() {
+	backends := b.routedBackends()
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+	for backend := range b.health {
+		if _, ok := backends[backend]; !ok {
+			delete(b.health, backend)
+		}
+	}
+}
+
+// healthCheckLoop probes every routed backend each healthInterval.
+func (b *SalmonBounce) healthCheckLoop() {
+	ticker := time.NewTicker(b.healthInterval)
+	defer ticker.Stop()
+
+	b.checkBackends()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+			b.checkBackends()
+		}
+	}
+}
+
+// checkBackends probes all routed backends once and records the results.
+func (b *SalmonBounce) checkBackends() {
+	for backend := range b.routedBackends() {
+		err := b.probeBackend(backend)
+		healthy := err == nil
+
+		b.healthMu.Lock()
+		was, probed := b.health[backend]
+		b.health[backend] = healthy
+		b.healthMu.Unlock()
+
+		if probed && was == healthy {
+			continue
+		}
+		if healthy {
+			log.Printf("SalmonBounce[%s]: backend %s is healthy", b.name, backend)
+		} else {
+			log.Printf("SalmonBounce[%s]: backend %s is unhealthy: %v", b.name, backend, err)
+		}
+	}
+	b.pruneHealth()
+}
+
+// probeBackend checks a single backend. TCP (and "both") bounces dial it;
+// UDP bounces send an empty datagram and only fail if the host actively
+// refuses it, since a silent UDP service is indistinguishable from a slow one.
+func (b *SalmonBounce) probeBackend(backend string) error {
+	if b.protocol != BounceProtocolUDP {
+		conn, err := net.DialTimeout("tcp", backend, b.healthTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	conn, err := net.DialTimeout("udp", backend, b.healthTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(nil); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(b.healthTimeout))
+	_, err = conn.Read(make([]byte, 1))
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+	return nil
+}
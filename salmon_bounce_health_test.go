@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"salmoncannon/config"
+)
+
+// closedAddr returns an address on localhost with nothing listening.
+func closedAddr(t *testing.T, network string) string {
+	t.Helper()
+	if network == "udp" {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to reserve udp port: %v", err)
+		}
+		addr := pc.LocalAddr().String()
+		pc.Close()
+		return addr
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve tcp port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func waitForHealth(t *testing.T, b *SalmonBounce, backend string, want bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if healthy, ok := b.BackendHealth()[backend]; ok && healthy == want {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("backend %s never reported healthy=%v (health: %v)", backend, want, b.BackendHealth())
+}
+
+func TestSalmonBounce_HealthCheckSkipsDeadBackend(t *testing.T) {
+	live := startTCPEcho(t)
+	defer live.Close()
+	dead := closedAddr(t, "tcp")
+
+	cfg := &config.SalmonBounceConfig{
+		Name:                "test-health",
+		ListenAddr:          "127.0.0.1:0",
+		RouteMap:            map[string]string{"127.0.0.1": dead + "=5," + live.Addr().String()},
+		IdleTimeout:         config.DurationString(60 * time.Second),
+		Protocol:            "tcp",
+		HealthCheckInterval: config.DurationString(50 * time.Millisecond),
+		HealthCheckTimeout:  config.DurationString(500 * time.Millisecond),
+	}
+	bounce, err := NewSalmonBounce(cfg)
+	if err != nil {
+		t.Fatalf("failed to create bounce: %v", err)
+	}
+	if err := bounce.Start(); err != nil {
+		t.Fatalf("failed to start bounce: %v", err)
+	}
+	defer bounce.Stop()
+
+	waitForHealth(t, bounce, dead, false)
+	waitForHealth(t, bounce, live.Addr().String(), true)
+
+	for i := 0; i < 10; i++ {
+		backend, err := bounce.pickBackend("127.0.0.1")
+		if err != nil {
+			t.Fatalf("pick failed: %v", err)
+		}
+		if backend != live.Addr().String() {
+			t.Fatalf("expected dead backend to be skipped, got %s", backend)
+		}
+	}
+
+	// Everything down: no backend rather than a black hole
+	live.Close()
+	waitForHealth(t, bounce, live.Addr().String(), false)
+	if _, err := bounce.pickBackend("127.0.0.1"); err == nil {
+		t.Fatalf("expected error when every backend is unhealthy")
+	}
+
+	bounce.RemoveRoute("127.0.0.1")
+	if len(bounce.BackendHealth()) != 0 {
+		t.Fatalf("expected health state to be cleared with the route, got %v", bounce.BackendHealth())
+	}
+}
+
+func TestSalmonBounce_UDPProbe(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start backend: %v", err)
+	}
+	defer backend.Close()
+
+	bounce, err := NewSalmonBounce(&config.SalmonBounceConfig{
+		Name:               "test-udp-probe",
+		Protocol:           "udp",
+		HealthCheckTimeout: config.DurationString(200 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatalf("failed to create bounce: %v", err)
+	}
+
+	if err := bounce.probeBackend(backend.LocalAddr().String()); err != nil {
+		t.Errorf("expected listening udp backend to pass, got %v", err)
+	}
+	if err := bounce.probeBackend(closedAddr(t, "udp")); err == nil {
+		t.Errorf("expected refused udp backend to fail")
+	}
+}
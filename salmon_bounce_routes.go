@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// weightedBackend is one entry of a route value like "b1:9090=3,b2:9090=1".
+type weightedBackend struct {
+	addr    string
+	weight  int
+	current int
+}
+
+// backendPicker spreads new sessions across a route's backends using smooth
+// weighted round-robin, so "a=3,b=1" yields a a b a, a a b a, ...
+type backendPicker struct {
+	route    string // raw route value this picker was built from
+	mu       sync.Mutex
+	backends []weightedBackend
+}
+
+// parseBackends splits a route value into backends. Weights default to 1.
+func parseBackends(route string) ([]weightedBackend, error) {
+	var backends []weightedBackend
+	for _, entry := range strings.Split(route, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		addr, weightStr, hasWeight := strings.Cut(entry, "=")
+		addr = strings.TrimSpace(addr)
+		weight := 1
+		if hasWeight {
+			w, err := strconv.Atoi(strings.TrimSpace(weightStr))
+			if err != nil || w < 1 {
+				return nil, fmt.Errorf("invalid weight in backend %q", entry)
+			}
+			weight = w
+		}
+		if addr == "" {
+			return nil, fmt.Errorf("empty backend address in %q", entry)
+		}
+		backends = append(backends, weightedBackend{addr: addr, weight: weight})
+	}
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no backends in route %q", route)
+	}
+	return backends, nil
+}
+
+func newBackendPicker(route string) (*backendPicker, error) {
+	backends, err := parseBackends(route)
+	if err != nil {
+		return nil, err
+	}
+	return &backendPicker{route: route, backends: backends}, nil
+}
+
+// next returns the backend for a new session, skipping any that healthy
+// rejects. It returns "" if none are usable.
+func (p *backendPicker) next(healthy func(string) bool) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	total := 0
+	best := -1
+	for i := range p.backends {
+		b := &p.backends[i]
+		if !healthy(b.addr) {
+			continue
+		}
+		b.current += b.weight
+		total += b.weight
+		if best < 0 || b.current > p.backends[best].current {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	p.backends[best].current -= total
+	return p.backends[best].addr
+}
+
+// pickBackend chooses a backend for a new session from clientIP. It returns
+// "" if there is no route for the client and an error if every backend on
+// the route is unhealthy.
+func (b *SalmonBounce) pickBackend(clientIP string) (string, error) {
+	route := b.lookupRoute(clientIP)
+	if route == "" {
+		return "", nil
+	}
+
+	b.mu.Lock()
+	picker := b.pickers[clientIP]
+	if picker == nil || picker.route != route {
+		var err error
+		picker, err = newBackendPicker(route)
+		if err != nil {
+			b.mu.Unlock()
+			return "", err
+		}
+		b.pickers[clientIP] = picker
+	}
+	b.mu.Unlock()
+
+	backend := picker.next(b.isHealthy)
+	if backend == "" {
+		return "", fmt.Errorf("no healthy backend in route %q", route)
+	}
+	return backend, nil
+}
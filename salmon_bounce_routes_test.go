@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"salmoncannon/config"
+)
+
+func TestParseBackends(t *testing.T) {
+	backends, err := parseBackends("backend1:9090=3, backend2:9090=1,backend3:9090")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []weightedBackend{
+		{addr: "backend1:9090", weight: 3},
+		{addr: "backend2:9090", weight: 1},
+		{addr: "backend3:9090", weight: 1},
+	}
+	if len(backends) != len(want) {
+		t.Fatalf("expected %d backends, got %d", len(want), len(backends))
+	}
+	for i := range want {
+		if backends[i] != want[i] {
+			t.Errorf("backend %d: got %+v, want %+v", i, backends[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"", "b:1=0", "b:1=x", "=2"} {
+		if _, err := parseBackends(bad); err == nil {
+			t.Errorf("expected error for route %q", bad)
+		}
+	}
+}
+
+func TestSalmonBounce_WeightedDistribution(t *testing.T) {
+	cfg := &config.SalmonBounceConfig{
+		Name:        "test-weighted",
+		ListenAddr:  "127.0.0.1:0",
+		RouteMap:    map[string]string{"127.0.0.1": "127.0.0.1:9001=3,127.0.0.1:9002=1"},
+		IdleTimeout: config.DurationString(60 * time.Second),
+	}
+	bounce, err := NewSalmonBounce(cfg)
+	if err != nil {
+		t.Fatalf("failed to create bounce: %v", err)
+	}
+	defer bounce.Stop()
+
+	counts := make(map[string]int)
+	const sessions = 400
+	for i := 0; i < sessions; i++ {
+		clientAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 20000 + i}
+		sess, err := bounce.getOrCreateSession(clientAddr)
+		if err != nil {
+			t.Fatalf("session %d: %v", i, err)
+		}
+		counts[sess.backend]++
+	}
+
+	if counts["127.0.0.1:9001"] != 300 || counts["127.0.0.1:9002"] != 100 {
+		t.Fatalf("expected 3:1 split over %d sessions, got %v", sessions, counts)
+	}
+}
+
+func TestSalmonBounce_SessionPinnedToBackend(t *testing.T) {
+	cfg := &config.SalmonBounceConfig{
+		Name:        "test-pinned",
+		ListenAddr:  "127.0.0.1:0",
+		RouteMap:    map[string]string{"127.0.0.1": "127.0.0.1:9001,127.0.0.1:9002"},
+		IdleTimeout: config.DurationString(60 * time.Second),
+	}
+	bounce, err := NewSalmonBounce(cfg)
+	if err != nil {
+		t.Fatalf("failed to create bounce: %v", err)
+	}
+	defer bounce.Stop()
+
+	clientAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 30000}
+	first, err := bounce.getOrCreateSession(clientAddr)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		sess, err := bounce.getOrCreateSession(clientAddr)
+		if err != nil {
+			t.Fatalf("failed to get session: %v", err)
+		}
+		if sess != first || sess.backend != first.backend {
+			t.Fatalf("expected session to stay pinned to %s, got %s", first.backend, sess.backend)
+		}
+	}
+
+	// A different client moves on to the next backend
+	other, err := bounce.getOrCreateSession(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 30001})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if other.backend == first.backend {
+		t.Fatalf("expected round-robin to pick the other backend, both got %s", first.backend)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"net"
 	"testing"
 	"time"
@@ -193,3 +194,141 @@ func TestSalmonBounce_ConfigConstructor(t *testing.T) {
 		t.Errorf("expected idleTimeout 30s, got %v", bounce.idleTimeout)
 	}
 }
+
+func startTCPEcho(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start tcp backend: %v", err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestSalmonBounce_BasicTCPForwarding(t *testing.T) {
+	backend := startTCPEcho(t)
+	defer backend.Close()
+
+	cfg := &config.SalmonBounceConfig{
+		Name:       "test-bounce-tcp",
+		ListenAddr: "127.0.0.1:0",
+		RouteMap: map[string]string{
+			"127.0.0.1": backend.Addr().String(),
+		},
+		IdleTimeout: config.DurationString(60 * time.Second),
+		Protocol:    "tcp",
+	}
+
+	bounce, err := NewSalmonBounce(cfg)
+	if err != nil {
+		t.Fatalf("failed to create bounce: %v", err)
+	}
+	if err := bounce.Start(); err != nil {
+		t.Fatalf("failed to start bounce: %v", err)
+	}
+	defer bounce.Stop()
+
+	if bounce.listenConn != nil {
+		t.Fatalf("expected no UDP listener in tcp mode")
+	}
+
+	clientConn, err := net.Dial("tcp", bounce.tcpListener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial bounce: %v", err)
+	}
+	defer clientConn.Close()
+
+	testMsg := []byte("hello tcp bounce")
+	if _, err := clientConn.Write(testMsg); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	buf := make([]byte, len(testMsg))
+	clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(clientConn, buf); err != nil {
+		t.Fatalf("failed to read reply: %v", err)
+	}
+	if string(buf) != string(testMsg) {
+		t.Fatalf("unexpected reply: got %q, want %q", buf, testMsg)
+	}
+}
+
+func TestSalmonBounce_TCPIdleTeardown(t *testing.T) {
+	backend := startTCPEcho(t)
+	defer backend.Close()
+
+	cfg := &config.SalmonBounceConfig{
+		Name:        "test-bounce-tcp-idle",
+		ListenAddr:  "127.0.0.1:0",
+		RouteMap:    map[string]string{"127.0.0.1": backend.Addr().String()},
+		IdleTimeout: config.DurationString(200 * time.Millisecond),
+		Protocol:    "tcp",
+	}
+	bounce, err := NewSalmonBounce(cfg)
+	if err != nil {
+		t.Fatalf("failed to create bounce: %v", err)
+	}
+	if err := bounce.Start(); err != nil {
+		t.Fatalf("failed to start bounce: %v", err)
+	}
+	defer bounce.Stop()
+
+	clientConn, err := net.Dial("tcp", bounce.tcpListener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial bounce: %v", err)
+	}
+	defer clientConn.Close()
+
+	// Stay quiet past the idle timeout; the bounce should hang up
+	clientConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := clientConn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expected idle connection to be closed")
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatalf("expected bounce to close idle connection, read timed out instead")
+	}
+}
+
+func TestSalmonBounce_BothProtocols(t *testing.T) {
+	cfg := &config.SalmonBounceConfig{
+		Name:        "test-bounce-both",
+		ListenAddr:  "127.0.0.1:0",
+		RouteMap:    map[string]string{},
+		IdleTimeout: config.DurationString(60 * time.Second),
+		Protocol:    "both",
+	}
+	bounce, err := NewSalmonBounce(cfg)
+	if err != nil {
+		t.Fatalf("failed to create bounce: %v", err)
+	}
+	if err := bounce.Start(); err != nil {
+		t.Fatalf("failed to start bounce: %v", err)
+	}
+	defer bounce.Stop()
+
+	tcpPort := bounce.tcpListener.Addr().(*net.TCPAddr).Port
+	udpPort := bounce.listenConn.LocalAddr().(*net.UDPAddr).Port
+	if tcpPort != udpPort {
+		t.Fatalf("expected tcp and udp on the same port, got %d and %d", tcpPort, udpPort)
+	}
+}
+
+func TestSalmonBounce_InvalidProtocol(t *testing.T) {
+	cfg := &config.SalmonBounceConfig{
+		Name:     "test-bounce-bad",
+		Protocol: "sctp",
+	}
+	if _, err := NewSalmonBounce(cfg); err == nil {
+		t.Fatalf("expected error for unknown protocol")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/netip"
+	"salmoncannon/api"
+	"salmoncannon/config"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// checkConfig loads and validates the config at path without starting
+// anything, then writes each bridge's effective settings and any insecure
+// ones to out. It returns the process exit code: 0 when the config is
+// usable, warnings or not, and 1 when it does not load.
+func checkConfig(path string, out io.Writer) int {
+	cfg, err := config.LoadConfigPath(path)
+	if err != nil {
+		fmt.Fprintf(out, "Config %s is invalid:\n%v\n", path, err)
+		return 1
+	}
+	fmt.Fprintf(out, "Config %s is valid, %d bridges\n", path, len(cfg.Bridges))
+
+	for _, b := range cfg.Bridges {
+		fmt.Fprintf(out, "\nBridge %s (%s)\n", b.Name, bridgeSummary(b))
+		fields := api.BridgeConfigFields(b)
+		for _, name := range slices.Sorted(maps.Keys(fields)) {
+			value, err := json.Marshal(fields[name])
+			if err != nil {
+				value = []byte(fmt.Sprint(fields[name]))
+			}
+			fmt.Fprintf(out, "  %s: %s\n", name, value)
+		}
+	}
+
+	warnings := configWarnings(cfg)
+	if len(warnings) > 0 {
+		fmt.Fprintln(out)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(out, "WARNING: %s\n", w)
+	}
+	return 0
+}
+
+// bridgeSummary says what a bridge listens on and where it connects.
+func bridgeSummary(b config.SalmonBridgeConfig) string {
+	if !b.Connect {
+		return fmt.Sprintf("far, QUIC on %s port %d", b.FarListenNetwork, b.NearPort)
+	}
+	listen := net.JoinHostPort(b.SocksListenAddress, strconv.Itoa(b.SocksListenPort))
+	if strings.HasPrefix(b.SocksListenAddress, config.UnixSocketPrefix) {
+		listen = b.SocksUnixPath()
+	}
+	far := b.FarIps
+	if len(far) == 0 {
+		far = []string{b.FarIp}
+	}
+	return fmt.Sprintf("near, SOCKS on %s, far %s port %d", listen, strings.Join(far, ", "), b.FarPort)
+}
+
+// configWarnings lists settings that work but leave the tunnel or the API
+// open to more than intended.
+func configWarnings(cfg *config.SalmonCannonConfig) []string {
+	var warnings []string
+	for _, b := range cfg.Bridges {
+		if b.Connect {
+			if b.TlsCaFile == "" && b.FarCertFingerprint == "" {
+				warnings = append(warnings, fmt.Sprintf("bridge %s: no SBTlsCaFile or SBFarCertFingerprint, the far certificate is not verified (InsecureSkipVerify)", b.Name))
+			}
+			for _, user := range slices.Sorted(maps.Keys(b.SocksUsers)) {
+				if _, err := bcrypt.Cost([]byte(b.SocksUsers[user])); err != nil {
+					warnings = append(warnings, fmt.Sprintf("bridge %s: SBSocksUsers password of %q is plaintext, use a bcrypt hash", b.Name, user))
+				}
+			}
+		} else if b.FarCertFile == "" {
+			warnings = append(warnings, fmt.Sprintf("bridge %s: no SBFarCertFile, a self-signed certificate nears cannot pin is used", b.Name))
+		}
+	}
+
+	// A UNIX socket is only reachable through the filesystem
+	if a := cfg.ApiConfig; a != nil && a.Socket == "" && !isLoopbackHost(a.Hostname) {
+		listen := net.JoinHostPort(a.Hostname, strconv.Itoa(a.Port))
+		if a.AuthToken == "" {
+			warnings = append(warnings, fmt.Sprintf("API: listens on %s with no AuthToken, anyone who can reach it can disable bridges and change their rate limits", listen))
+		} else if a.TLSCert == "" {
+			warnings = append(warnings, "API: AuthToken is sent in plaintext, set TLSCert and TLSKey")
+		}
+	}
+	return warnings
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
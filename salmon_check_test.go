@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeCheckConfig(t *testing.T, yamlData string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scconfig.yml")
+	if err := os.WriteFile(path, []byte(yamlData), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestCheckConfig_Valid(t *testing.T) {
+	path := writeCheckConfig(t, `SalmonBridges:
+  - SBName: "check-near"
+    SBConnect: true
+    SBFarIp: "10.0.0.1"
+    SBFarPort: 1111
+    SBSocksListenPort: 1080
+    SBSharedSecret: "very-secret"
+    SBSocksUsers:
+      alice: "plain-pass"
+  - SBName: "check-far"
+    SBNearPort: 1111
+ApiConfig:
+  Hostname: "0.0.0.0"
+  Port: 8080
+`)
+	var out bytes.Buffer
+	if code := checkConfig(path, &out); code != 0 {
+		t.Fatalf("expected exit code 0, got %d:\n%s", code, out.String())
+	}
+	got := out.String()
+	for _, want := range []string{
+		"is valid, 2 bridges",
+		"Bridge check-near (near, SOCKS on 127.0.0.1:1080, far 10.0.0.1 port 1111)",
+		"Bridge check-far (far, QUIC on udp port 1111)",
+		`SBIdleTimeout: "1m0s"`, // a default filled in
+		`SBSharedSecret: "REDACTED"`,
+		"bridge check-near: no SBTlsCaFile or SBFarCertFingerprint",
+		`SBSocksUsers password of "alice" is plaintext`,
+		"bridge check-far: no SBFarCertFile",
+		"API: listens on 0.0.0.0:8080 with no AuthToken",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "very-secret") || strings.Contains(got, "plain-pass") {
+		t.Errorf("expected secrets to be redacted, got:\n%s", got)
+	}
+}
+
+func TestCheckConfig_Invalid(t *testing.T) {
+	path := writeCheckConfig(t, `SalmonBridges:
+  - SBName: "dup"
+    SBConnect: true
+    SBFarPort: 1111
+    SBSocksListenPort: 1080
+    SBMaxRecieveBufferSize: 1MB
+`)
+	var out bytes.Buffer
+	if code := checkConfig(path, &out); code != 1 {
+		t.Fatalf("expected exit code 1, got %d:\n%s", code, out.String())
+	}
+	for _, want := range []string{"is invalid", "SBFarIp must be set", "below the 7MB minimum"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	if code := checkConfig(filepath.Join(t.TempDir(), "missing.yml"), &out); code != 1 {
+		t.Fatalf("expected a missing config to fail, got %d", code)
+	}
+}
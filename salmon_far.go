@@ -1,17 +1,13 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
 	"fmt"
 	"log"
 	"salmoncannon/bridge"
 	"salmoncannon/config"
 	"salmoncannon/limiter"
-	"salmoncannon/socks"
 	"salmoncannon/status"
-	"salmoncannon/utils"
-
-	quic "github.com/quic-go/quic-go"
 )
 
 type SalmonFar struct {
@@ -20,31 +16,44 @@ type SalmonFar struct {
 
 func NewSalmonFar(config *config.SalmonBridgeConfig) (*SalmonFar, error) {
 
-	tlscfg := &tls.Config{
-		Certificates: []tls.Certificate{utils.GenerateSelfSignedCert()},
-		NextProtos:   []string{config.Name},
+	tlscfg, err := farTLSConfig(config)
+	if err != nil {
+		return nil, err
 	}
 
 	sl := limiter.NewSharedLimiter(int64(config.TotalBandwidthLimit))
 	status.GlobalConnMonitorRef.RegisterLimiter(config.Name, sl)
 
-	qcfg := &quic.Config{
-		MaxIdleTimeout:                 config.IdleTimeout.Duration(),
-		InitialStreamReceiveWindow:     uint64(1024 * 1024 * 50),
-		MaxStreamReceiveWindow:         uint64(config.MaxRecieveBufferSize),
-		InitialConnectionReceiveWindow: uint64(1024 * 1024 * 25),
-		MaxConnectionReceiveWindow:     uint64(config.MaxRecieveBufferSize),
-		InitialPacketSize:              uint16(config.InitialPacketSize),
-		MaxIncomingStreams:             socks.MaxConnections,
-		MaxIncomingUniStreams:          socks.MaxConnections,
-		EnableDatagrams:                false,
-	}
+	qcfg := newQuicConfig(config)
 
 	farListenAddr := fmt.Sprintf(":%d", config.NearPort)
 	log.Printf("FAR: Listen address for bridge %s is '%s' (len=%d)\n", config.Name, farListenAddr, len(farListenAddr))
 
 	farBridge := bridge.NewSalmonBridge(config.Name, config.FarIp, config.NearPort,
 		tlscfg, qcfg, sl, config.Connect, config.InterfaceName, config.AllowedOutAddresses, config.SharedSecret)
+	farBridge.Quic().SetPoolLimits(config.MaxConnectionsPerBridge, int32(config.MaxStreamsPerConnection),
+		config.ConnectionIdleTimeout.Duration())
+	farBridge.Quic().SetListenSockets(config.ListenSockets)
+	farBridge.Quic().SetListenNetwork(config.FarListenNetwork)
+	farBridge.Quic().SetUDPBuffers(int(config.UDPReceiveBuffer), int(config.UDPSendBuffer))
+	farBridge.Quic().SetEnable0RTT(config.Enable0RTT)
+	farBridge.SetBindAddress(config.BindAddress)
+	farBridge.SetEgressInterface(config.FarEgressInterface)
+	farBridge.SetStreamIdleTimeout(config.StreamIdleTimeout.Duration())
+	farBridge.SetRelayBufferSize(int(config.RelayBufferSize))
+	farBridge.SetCoalesceDelay(config.CoalesceDelay.Duration())
+	farBridge.SetStreamBandwidthLimit(int64(config.PerStreamBandwidthLimit))
+	farBridge.SetSendProxyProtocol(config.SendProxyProtocol)
+	farBridge.SetDialBreaker(config.DialFailureThreshold, config.DialFailureWindow.Duration(), config.DialFailureCooldown.Duration())
+	farBridge.SetTargetPool(config.TargetPoolSize, config.TargetPoolIdleTimeout.Duration())
+	if err := farBridge.SetCipherMode(config.CipherMode); err != nil {
+		return nil, err
+	}
+	if err := farBridge.SetAllowedOutAddressTypes(config.AllowedOutAddressTypes); err != nil {
+		return nil, err
+	}
+	farBridge.SetOutPorts(config.AllowedOutPorts, config.DeniedOutPorts)
+	farBridge.SetMaxOutPerClient(config.MaxOutPerClient)
 
 	far := &SalmonFar{
 		farBridge: farBridge,
@@ -52,3 +61,14 @@ func NewSalmonFar(config *config.SalmonBridgeConfig) (*SalmonFar, error) {
 
 	return far, nil
 }
+
+// Close stops the far listener and drops every connection from the near.
+func (f *SalmonFar) Close() {
+	f.farBridge.Close()
+}
+
+// Shutdown refuses new streams, waits for open ones to finish until ctx is
+// done and then closes the bridge. It returns the streams cut off.
+func (f *SalmonFar) Shutdown(ctx context.Context) int32 {
+	return f.farBridge.Shutdown(ctx)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// listenTCPOnInterface opens a listener on addr for network, one of "tcp",
+// "tcp4" or "tcp6". With ifname set the socket is also tied to that
+// interface via SO_BINDTODEVICE on Linux; other platforms only get the
+// address binding.
+func listenTCPOnInterface(network, addr, ifname string) (net.Listener, error) {
+	if ifname == "" {
+		return net.Listen(network, addr)
+	}
+	if _, err := net.InterfaceByName(ifname); err != nil {
+		return nil, fmt.Errorf("interface %q: %w", ifname, err)
+	}
+	if runtime.GOOS != "linux" {
+		log.Printf("NEAR: binding to interface %s is only supported on Linux, listening on %s only", ifname, addr)
+		return net.Listen(network, addr)
+	}
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var serr error
+			if err := c.Control(func(fd uintptr) {
+				serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
+			}); err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+	return lc.Listen(context.Background(), network, addr)
+}
+
+// listenUnix opens a UNIX socket listener on path. A socket file left by a
+// near that did not shut down cleanly is removed first, one that still
+// accepts connections is left alone. The file is removed again when the
+// listener is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("socket %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("remove stale socket %s: %w", path, err)
+		}
+	}
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestListenTCPOnInterface_Nonexistent(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_BINDTODEVICE is Linux only")
+	}
+	ln, err := listenTCPOnInterface("tcp", "127.0.0.1:0", "sc-no-such-if0")
+	if err == nil {
+		ln.Close()
+		t.Fatalf("expected binding to a nonexistent interface to fail")
+	}
+
+	// No interface keeps plain address binding
+	ln, err = listenTCPOnInterface("tcp", "127.0.0.1:0", "")
+	if err != nil {
+		t.Fatalf("failed to listen without an interface: %v", err)
+	}
+	ln.Close()
+}
+
+func TestListenTCPOnInterface_Network(t *testing.T) {
+	ln, err := listenTCPOnInterface("tcp4", ":0", "")
+	if err != nil {
+		t.Fatalf("failed to listen tcp4: %v", err)
+	}
+	defer ln.Close()
+	if ip := ln.Addr().(*net.TCPAddr).IP; ip.To4() == nil {
+		t.Fatalf("expected tcp4 to bind an IPv4 address, got %v", ip)
+	}
+}
+
+func TestListenUnix_StaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "near.sock")
+
+	// A socket file nothing accepts on, as a crashed near leaves behind
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatalf("expected the stale socket file to remain: %v", err)
+	}
+
+	ln, err := listenUnix(path)
+	if err != nil {
+		t.Fatalf("expected the stale socket to be replaced, got %v", err)
+	}
+
+	// One still accepting is not taken over
+	if other, err := listenUnix(path); err == nil || !strings.Contains(err.Error(), "in use") {
+		if other != nil {
+			other.Close()
+		}
+		t.Fatalf("expected a live socket to be refused, got %v", err)
+	}
+
+	ln.Close()
+	if _, err := os.Lstat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the socket file to be removed on close, got %v", err)
+	}
+}
@@ -1,94 +1,141 @@
 package main
 
 import (
-	"crypto/tls"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"salmoncannon/bridge"
 	"salmoncannon/config"
 	"salmoncannon/limiter"
+	"salmoncannon/logging"
 	"salmoncannon/socks"
 	"salmoncannon/status"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"slices"
-
-	quic "github.com/quic-go/quic-go"
 )
 
-func initNear(cfg *config.SalmonBridgeConfig, near *SalmonNear) {
+// statusCheckMaxBackoff caps the gap between status checks while the far
+// side is down.
+const statusCheckMaxBackoff = time.Minute
+
+// initNear opens the SOCKS listener for the bridge and serves it in the
+// background until the near is closed.
+func initNear(cfg *config.SalmonBridgeConfig, near *SalmonNear) error {
 	log.Printf("NEAR: Initializing near side SOCKS listener for bridge %s", cfg.Name)
 	listenAddr := cfg.SocksListenAddress + ":" + strconv.Itoa(cfg.SocksListenPort)
-	ln, err := net.Listen("tcp", listenAddr)
+	if cfg.SocksUnixPath() != "" {
+		listenAddr = cfg.SocksListenAddress
+	}
+	ln, err := near.listen(listenAddr)
 	if err != nil {
-		log.Fatalf("NEAR: Failed to listen on %s: %v", listenAddr, err)
+		return fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
 	}
 	log.Printf("NEAR: SOCKS proxy listening on %s", listenAddr)
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Printf("NEAR: Local SOCKS TCP accept error: %v", err)
-			continue
-		}
-		go near.HandleRequest(conn)
-	}
+	go near.serve(ln, "Local SOCKS TCP", near.HandleRequest)
+	return nil
 }
 
-func initHTTPNear(cfg *config.SalmonBridgeConfig, near *SalmonNear) {
+// initHTTPNear opens the HTTP CONNECT listener if one is configured.
+func initHTTPNear(cfg *config.SalmonBridgeConfig, near *SalmonNear) error {
 	if cfg.HttpListenPort <= 0 {
-		return
+		return nil
 	}
 	addr := cfg.SocksListenAddress + ":" + strconv.Itoa(cfg.HttpListenPort)
 	log.Printf("NEAR: Initializing HTTP proxy listener for bridge %s on %s", cfg.Name, addr)
-	ln, err := net.Listen("tcp", addr)
+	ln, err := near.listen(addr)
 	if err != nil {
-		log.Fatalf("NEAR: Failed to listen HTTP on %s: %v", addr, err)
+		return fmt.Errorf("failed to listen HTTP on %s: %w", addr, err)
 	}
 	log.Printf("NEAR: HTTP proxy listening on %s", addr)
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Printf("NEAR: HTTP accept error: %v", err)
-			continue
-		}
-		go near.HandleHTTP(conn)
-	}
+	go near.serve(ln, "HTTP", near.HandleHTTP)
+	return nil
+}
+
+// Reasons a relay ended, for access logs.
+const (
+	closeByClient = "client closed"
+	closeByTarget = "target closed"
+	closeByIdle   = "idle timeout"
+)
+
+// relayStats summarises a finished relay: bytes each way and which side
+// ended it first.
+type relayStats struct {
+	up, down int64 // src to dst, dst to src
+	reason   string
 }
 
-func relayConnData(src net.Conn, dst net.Conn) {
+// relayConnData copies both ways between src and dst until both finish,
+// using buffers from bufs. A side that sends EOF is half-closed on the
+// other when it supports CloseWrite, so replies still flow back; otherwise
+// the relay is torn down. With idleTimeout > 0 both are closed once neither
+// side has sent data for that long.
+func relayConnData(src net.Conn, dst net.Conn, idleTimeout time.Duration, bufs *bridge.BufferPool) relayStats {
 	var wg sync.WaitGroup
 	wg.Add(2)
 
+	var stats relayStats
+	var ended sync.Once
+	end := func(reason string, err error) {
+		ended.Do(func() {
+			if err != nil {
+				reason = "error: " + err.Error()
+			}
+			stats.reason = reason
+		})
+	}
+
 	// Signal channel to coordinate shutdown
 	done := make(chan struct{})
+	idle := bridge.NewIdleTimer(idleTimeout)
 
 	// Copy src -> dst
 	go func() {
 		defer wg.Done()
-		io.Copy(dst, src)
+		n, err := bufs.Copy(dst, idle.Reader(src, src.SetReadDeadline))
+		stats.up = n
+		if idle.Expired() {
+			end(closeByIdle, nil)
+		} else {
+			end(closeByClient, err)
+		}
+		// On a clean EOF half-close dst and let it finish replying
+		if conn, ok := dst.(interface{ CloseWrite() error }); ok && err == nil && !idle.Expired() {
+			conn.CloseWrite()
+			return
+		}
 		// Signal other goroutine to stop by setting deadline
+		idle.Stop()
 		dst.SetReadDeadline(time.Now())
 		src.SetWriteDeadline(time.Now())
-		// Try to signal the other direction by closing write side if supported
-		if conn, ok := dst.(interface{ CloseWrite() error }); ok {
-			conn.CloseWrite()
-		}
 	}()
 
 	// Copy dst -> src
 	go func() {
 		defer wg.Done()
-		io.Copy(src, dst)
+		n, err := bufs.Copy(src, idle.Reader(dst, dst.SetReadDeadline))
+		stats.down = n
+		if idle.Expired() {
+			end(closeByIdle, nil)
+		} else {
+			end(closeByTarget, err)
+		}
+		if conn, ok := src.(interface{ CloseWrite() error }); ok && err == nil && !idle.Expired() {
+			conn.CloseWrite()
+			return
+		}
 		// Signal other goroutine to stop by setting deadline
+		idle.Stop()
 		src.SetReadDeadline(time.Now())
 		dst.SetWriteDeadline(time.Now())
-		// Try to signal the other direction by closing write side if supported
-		if conn, ok := src.(interface{ CloseWrite() error }); ok {
-			conn.CloseWrite()
-		}
 	}()
 
 	// Wait for BOTH directions to complete
@@ -98,182 +145,734 @@ func relayConnData(src net.Conn, dst net.Conn) {
 	// Close both connections
 	src.Close()
 	dst.Close()
+	return stats
+}
+
+// logAccess writes the one line summary of a finished tunnel from client
+// to target.
+func (n *SalmonNear) logAccess(client net.Addr, target string, opened time.Time, stats relayStats) {
+	duration := time.Since(opened)
+	logging.Log(logging.Event{Bridge: n.bridgeName, Type: logging.EventAccess, Target: target, Client: client.String(),
+		BytesUp: stats.up, BytesDown: stats.down, Duration: duration, Reason: stats.reason},
+		"NEAR: Bridge %s access client=%s target=%s up=%d down=%d duration=%s reason=%q",
+		n.bridgeName, client, target, stats.up, stats.down, duration.Round(time.Millisecond), stats.reason)
 }
 
 type SalmonNear struct {
 	currentBridge *bridge.SalmonBridge
 	bridgeName    string
 	config        *config.SalmonBridgeConfig
+	allowedIn     atomic.Pointer[config.AddressFilter]
+	disabled      atomic.Bool
+	relayBufs     *bridge.BufferPool
+	clients       chan struct{} // SBMaxConcurrentClients slots, nil when unlimited
+	clientRate    *limiter.ClientRateLimiter
+
+	auth socks.Authenticator
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	closed    bool
+	done      chan struct{}
 }
 
-func (n *SalmonNear) runStatusChecks(intervalMs int) {
-	ticker := time.NewTicker(time.Duration(intervalMs) * time.Millisecond)
-	defer ticker.Stop()
+// runStatusChecks pings the far side every interval until the near closes.
+// While the far does not answer the gap doubles, up to statusCheckMaxBackoff,
+// so a dead far is not hammered with dials.
+func (n *SalmonNear) runStatusChecks(interval time.Duration) {
+	// The first check runs straight away so a bad far shows up at startup
+	err := n.currentBridge.StatusCheck()
+	if err != nil {
+		log.Printf("NEAR: Bridge %s far side unreachable at startup: %v", n.bridgeName, err)
+	} else {
+		log.Printf("NEAR: Bridge %s far side reachable", n.bridgeName)
+	}
+
+	wait := interval
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
 	for {
-		<-ticker.C
-		n.currentBridge.StatusCheck()
+		select {
+		case <-n.done:
+			return
+		case <-timer.C:
+		}
+		err = n.currentBridge.StatusCheck()
+		if err != nil {
+			wait = min(wait*2, max(interval, statusCheckMaxBackoff))
+		} else {
+			wait = interval
+		}
+		timer.Reset(wait)
+	}
+}
+
+// listen opens a TCP listener that is closed along with the near. It is
+// bound to SBSocksListenInterface when one is set. An addr of "unix:/path"
+// listens on a UNIX socket instead.
+func (n *SalmonNear) listen(addr string) (net.Listener, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return nil, fmt.Errorf("bridge %s is closed", n.bridgeName)
+	}
+	var ln net.Listener
+	var err error
+	if path, ok := strings.CutPrefix(addr, config.UnixSocketPrefix); ok {
+		ln, err = listenUnix(path)
+	} else {
+		ln, err = listenTCPOnInterface(n.config.SocksListenNetwork, addr, n.config.SocksListenInterface)
+	}
+	if err != nil {
+		return nil, err
+	}
+	n.listeners = append(n.listeners, ln)
+	return ln, nil
+}
+
+// serve accepts connections on ln until the near is closed.
+func (n *SalmonNear) serve(ln net.Listener, kind string, handle func(net.Conn)) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if n.isClosed() {
+				return
+			}
+			log.Printf("NEAR: %s accept error: %v", kind, err)
+			continue
+		}
+		go handle(conn)
 	}
 }
 
+// acquireClient takes a client slot, or returns false if the bridge already
+// serves SBMaxConcurrentClients clients. Slots go back via releaseClient.
+func (n *SalmonNear) acquireClient() bool {
+	if n.clients == nil {
+		return true
+	}
+	select {
+	case n.clients <- struct{}{}:
+		return true
+	default:
+		status.GlobalConnMonitorRef.IncClientLimit(n.bridgeName)
+		return false
+	}
+}
+
+func (n *SalmonNear) releaseClient() {
+	if n.clients != nil {
+		<-n.clients
+	}
+}
+
+// allowClientRate returns false if the client's IP is opening connections
+// faster than SBPerClientConnRate allows.
+func (n *SalmonNear) allowClientRate(addr net.Addr) bool {
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if n.clientRate.Allow(ip) {
+		return true
+	}
+	status.GlobalConnMonitorRef.IncClientRateLimit(n.bridgeName)
+	log.Printf("NEAR: Bridge %s client %s is over its connection rate, refusing", n.bridgeName, ip)
+	return false
+}
+
+func (n *SalmonNear) isClosed() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.closed
+}
+
+// SetAllowedIn swaps the filter for client addresses. New connections are
+// checked against it; already accepted ones are left alone.
+func (n *SalmonNear) SetAllowedIn(filter *config.AddressFilter) {
+	if filter == nil {
+		filter = &config.AddressFilter{}
+	}
+	n.allowedIn.Store(filter)
+}
+
+// SetEnabled pauses or resumes the bridge. While disabled new client
+// connections are refused; streams already open carry on until they close.
+func (n *SalmonNear) SetEnabled(enabled bool) {
+	n.disabled.Store(!enabled)
+}
+
+func (n *SalmonNear) Enabled() bool {
+	return !n.disabled.Load()
+}
+
+// closeListeners stops the SOCKS/HTTP listeners and status checks. It
+// returns false if the near was already closed.
+func (n *SalmonNear) closeListeners() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return false
+	}
+	n.closed = true
+	for _, ln := range n.listeners {
+		ln.Close()
+	}
+	n.listeners = nil
+	close(n.done)
+	return true
+}
+
+// Close stops the SOCKS/HTTP listeners and status checks and tears down the
+// bridge to the far side.
+func (n *SalmonNear) Close() {
+	if n.closeListeners() {
+		n.currentBridge.Close()
+	}
+}
+
+// Shutdown stops accepting clients, waits for open streams to finish until
+// ctx is done and then closes the bridge. It returns the streams cut off.
+func (n *SalmonNear) Shutdown(ctx context.Context) int32 {
+	if !n.closeListeners() {
+		return 0
+	}
+	return n.currentBridge.Shutdown(ctx)
+}
+
+// socksAuth builds the SOCKS5 credential check from SBSocksUsers, SBAuthFile
+// and SBAuthCommand. A client gets in if any configured one accepts it.
+func socksAuth(cfg *config.SalmonBridgeConfig) (socks.Authenticator, error) {
+	auth := socks.Authenticators{socks.Users(cfg.SocksUsers)}
+	if cfg.AuthFile != "" {
+		fileUsers, err := socks.NewFileUsers(cfg.AuthFile)
+		if err != nil {
+			return nil, fmt.Errorf("bridge %s: SBAuthFile: %w", cfg.Name, err)
+		}
+		auth = append(auth, fileUsers)
+	}
+	if cfg.AuthCommand != "" {
+		cmdAuth, err := socks.NewCommandAuth(cfg.AuthCommand)
+		if err != nil {
+			return nil, fmt.Errorf("bridge %s: SBAuthCommand: %w", cfg.Name, err)
+		}
+		auth = append(auth, cmdAuth)
+	}
+	return auth, nil
+}
+
 func NewSalmonNear(config *config.SalmonBridgeConfig) (*SalmonNear, error) {
 	bridgeAddress := config.FarIp
+	if len(config.FarIps) > 0 {
+		bridgeAddress = config.FarIps[0]
+	}
 	bridgePort := config.FarPort
 
-	qcfg := &quic.Config{
-		MaxIdleTimeout:                 config.IdleTimeout.Duration(),
-		InitialStreamReceiveWindow:     uint64(1024 * 1024 * 50),
-		MaxStreamReceiveWindow:         uint64(config.MaxRecieveBufferSize),
-		InitialConnectionReceiveWindow: uint64(1024 * 1024 * 25),
-		MaxConnectionReceiveWindow:     uint64(config.MaxRecieveBufferSize),
-		InitialPacketSize:              uint16(config.InitialPacketSize),
-		MaxIncomingStreams:             socks.MaxConnections,
-		MaxIncomingUniStreams:          socks.MaxConnections,
-		EnableDatagrams:                false,
+	if config.AllowedInFilter == nil {
+		if err := config.ParseAddressFilters(); err != nil {
+			return nil, err
+		}
 	}
+	auth, err := socksAuth(config)
+	if err != nil {
+		return nil, err
+	}
+
+	qcfg := newQuicConfig(config)
 
 	sl := limiter.NewSharedLimiter(int64(config.TotalBandwidthLimit))
 	status.GlobalConnMonitorRef.RegisterLimiter(config.Name, sl)
 
-	tlscfg := &tls.Config{
-		InsecureSkipVerify: true, // for prototype
-		NextProtos:         []string{config.Name},
+	tlscfg, err := nearTLSConfig(config)
+	if err != nil {
+		return nil, err
 	}
 
 	salmonBridge := bridge.NewSalmonBridge(config.Name, bridgeAddress, bridgePort,
 		tlscfg, qcfg, sl, config.Connect, config.InterfaceName, config.AllowedOutAddresses, config.SharedSecret)
+	salmonBridge.Quic().SetFarEndpoints(config.FarIps)
+	salmonBridge.Quic().SetEnable0RTT(config.Enable0RTT)
+	salmonBridge.Quic().SetReconnectBackoff(config.ReconnectBackoffMin.Duration(), config.ReconnectBackoffMax.Duration())
+	salmonBridge.Quic().SetTimeouts(config.DialTimeout.Duration(), config.StreamOpenTimeout.Duration())
+	salmonBridge.Quic().SetStreamOpenAttempts(config.StreamOpenAttempts)
+	salmonBridge.Quic().SetStreamQueue(config.StreamQueueDepth, config.StreamQueueTimeout.Duration())
+	salmonBridge.Quic().SetUDPBuffers(int(config.UDPReceiveBuffer), int(config.UDPSendBuffer))
+	salmonBridge.Quic().SetPoolLimits(config.MaxConnectionsPerBridge, int32(config.MaxStreamsPerConnection),
+		config.ConnectionIdleTimeout.Duration())
+	salmonBridge.SetStreamIdleTimeout(config.StreamIdleTimeout.Duration())
+	salmonBridge.SetRelayBufferSize(int(config.RelayBufferSize))
+	salmonBridge.SetCoalesceDelay(config.CoalesceDelay.Duration())
+	salmonBridge.SetStreamBandwidthLimit(int64(config.PerStreamBandwidthLimit))
+	salmonBridge.SetKeepalive(config.KeepaliveInterval.Duration(), config.KeepaliveFailures)
+	if err := salmonBridge.SetCipherMode(config.CipherMode); err != nil {
+		return nil, err
+	}
+	if err := salmonBridge.SetCompression(config.Compression); err != nil {
+		return nil, err
+	}
+	// Only used for SBFallbackDirect dials
+	salmonBridge.SetRemoteDNS(config.RemoteDNS)
+	salmonBridge.SetSendProxyProtocol(config.SendProxyProtocol)
+	if err := salmonBridge.SetAllowedOutAddressTypes(config.AllowedOutAddressTypes); err != nil {
+		return nil, err
+	}
+	salmonBridge.SetOutPorts(config.AllowedOutPorts, config.DeniedOutPorts)
+	salmonBridge.SetMaxOutPerClient(config.MaxOutPerClient)
+
+	if config.RequireFarOnStart {
+		if err := salmonBridge.StatusCheck(); err != nil {
+			salmonBridge.Close()
+			return nil, fmt.Errorf("far side of bridge %s unreachable at startup: %w", config.Name, err)
+		}
+		log.Printf("NEAR: Bridge %s far side reachable", config.Name)
+	}
 
 	near := &SalmonNear{
 		currentBridge: salmonBridge,
 		bridgeName:    config.Name,
 		config:        config,
+		auth:          auth,
+		relayBufs:     bridge.RelayBufferPool(int(config.RelayBufferSize)),
+		clientRate:    limiter.NewClientRateLimiter(config.PerClientConnRate, config.PerClientConnBurst),
+		done:          make(chan struct{}),
+	}
+	near.SetAllowedIn(config.AllowedInFilter)
+	if config.MaxConcurrentClients > 0 {
+		near.clients = make(chan struct{}, config.MaxConcurrentClients)
 	}
 
 	if config.StatusCheckFrequency > 0 {
 		log.Printf("NEAR: Bridge %s starting status checks every %d ms", near.bridgeName, config.StatusCheckFrequency.Duration().Milliseconds())
-		go near.runStatusChecks(int(config.StatusCheckFrequency.Duration().Milliseconds()))
+		go near.runStatusChecks(config.StatusCheckFrequency.Duration())
 	}
 
 	return near, nil
 }
 
 func (n *SalmonNear) shouldBlockNearConn(nearHostFull string) bool {
-	if len(n.config.AllowedInAddresses) == 0 {
+	allowedIn := n.allowedIn.Load()
+	if allowedIn.Empty() {
 		return false
 	}
 	nearAddr, _, _ := net.SplitHostPort(nearHostFull)
-	return !slices.Contains(n.config.AllowedInAddresses, nearAddr)
+	return !allowedIn.Matches(nearAddr)
 }
 
 func (n *SalmonNear) HandleRequest(conn net.Conn) {
-	status.GlobalConnMonitorRef.IncSOCKS()
+	status.GlobalConnMonitorRef.IncSOCKS(n.bridgeName)
 	defer func() {
 		conn.Close()
-		status.GlobalConnMonitorRef.DecSOCKS()
+		status.GlobalConnMonitorRef.DecSOCKS(n.bridgeName)
 	}()
 	//log.Printf("NEAR: Bridge %s accepted connection from %s", n.bridgeName, conn.RemoteAddr())
+	if !n.allowClientRate(conn.RemoteAddr()) {
+		return
+	}
+	if !n.acquireClient() {
+		log.Printf("NEAR: Bridge %s at %d clients, refusing %s", n.bridgeName, cap(n.clients), conn.RemoteAddr())
+		return
+	}
+	defer n.releaseClient()
 	if n.shouldBlockNearConn(conn.RemoteAddr().String()) {
+		status.GlobalConnMonitorRef.IncAllowlistBlock(n.bridgeName)
 		log.Printf("NEAR: Bridge %s recieved request unallowed near IP: %s", n.bridgeName, conn.RemoteAddr())
 		return
 	}
 
-	host, port, err := socks.HandleSocksHandshake(conn, n.bridgeName)
+	req, err := socks.HandleSocksRequestTimeout(conn, n.bridgeName, n.auth, n.config.HandshakeTimeout.Duration())
 	if err != nil {
 		// Only log non-EOF errors - EOF just means client disconnected (common with health checks)
-		if err != io.EOF {
+		if errors.Is(err, socks.ErrNoAcceptableAuth) {
+			status.GlobalConnMonitorRef.IncAuthMismatch(n.bridgeName)
+			log.Printf("NEAR: Bridge %s refused SOCKS client %s: %v", n.bridgeName, conn.RemoteAddr(), err)
+		} else if err != io.EOF {
+			status.GlobalConnMonitorRef.IncHandshakeFailure(n.bridgeName)
 			log.Printf("NEAR: Bridge %s Failed to handle SOCKS handshake: %v", n.bridgeName, err)
 		}
 		return
 	}
+	host, port := req.Host, req.Port
+
+	if !n.Enabled() {
+		conn.Write(req.Reply(socks.RepGeneralFailure, ""))
+		log.Printf("NEAR: Bridge %s is disabled, refusing %s:%d", n.bridgeName, host, port)
+		return
+	}
+
+	if req.Cmd == socks.CmdBind {
+		n.handleBind(conn, req)
+		return
+	}
+	if req.Cmd == socks.CmdUDPAssociate {
+		n.handleUDPAssociate(conn, req)
+		return
+	}
 
 	// 4. Open a streaming session to far
-	stream, err := n.currentBridge.NewNearConn(host, port)
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	opened := time.Now()
+	// SOCKS5 ATYP values are the bridge's address types
+	stream, err := n.openStream(host, port, req.AddrType, conn.RemoteAddr())
 	if err != nil {
-		conn.Write(socks.ReplyFail)
-		log.Printf("NEAR: Bridge %s Failed to open stream to far: %v", n.bridgeName, err)
+		conn.Write(dialFailureReply(req, err))
+		logging.Log(logging.Event{Bridge: n.bridgeName, Type: logging.EventDialFailure, Target: target, Latency: time.Since(opened), Err: err},
+			"NEAR: Bridge %s Failed to open stream to far: %v", n.bridgeName, err)
 		return
 	}
+	logging.Log(logging.Event{Bridge: n.bridgeName, Type: logging.EventOpen, Target: target, Latency: time.Since(opened)}, "")
 	defer func() {
 		stream.Close()
-		log.Printf("NEAR: Bridge %s closed stream to %s:%d", n.bridgeName, host, port)
+		logging.Log(logging.Event{Bridge: n.bridgeName, Type: logging.EventClose, Target: target, Latency: time.Since(opened)},
+			"NEAR: Bridge %s closed stream to %s:%d", n.bridgeName, host, port)
 	}()
 
 	// 5. Reply: success
-	conn.Write(socks.ReplySuccess)
+	conn.Write(req.Reply(socks.RepSucceeded, ""))
+
+	stats := relayConnData(conn, stream, n.config.StreamIdleTimeout.Duration(), n.relayBufs)
+	n.logAccess(conn.RemoteAddr(), target, opened, stats)
+}
+
+// openStream opens a stream to host:port through the far side. With
+// SBFallbackDirect set, a target the tunnel cannot reach is dialed from the
+// near host instead; a far that answered but could not connect is final.
+func (n *SalmonNear) openStream(host string, port int, addrType byte, client net.Addr) (net.Conn, error) {
+	stream, err := n.currentBridge.NewNearConnFrom(host, port, addrType, client)
+	var dialErr *bridge.DialError
+	if err == nil || !n.config.FallbackDirect || errors.As(err, &dialErr) {
+		return stream, err
+	}
+	log.Printf("NEAR: Bridge %s tunnel unavailable (%v), connecting to %s directly from the near host",
+		n.bridgeName, err, net.JoinHostPort(host, strconv.Itoa(port)))
+	return n.currentBridge.NewDirectConn(host, port, addrType)
+}
+
+// dialFailureReply picks the reply to req for a stream that failed to open.
+// The far side's dial reasons are SOCKS5 reply codes already.
+func dialFailureReply(req *socks.Request, err error) []byte {
+	var dialErr *bridge.DialError
+	if errors.As(err, &dialErr) {
+		return req.Reply(dialErr.Code, "")
+	}
+	return req.Reply(socks.RepGeneralFailure, "")
+}
+
+// handleBind serves a SOCKS BIND: the far side listens for one inbound
+// connection and the client gets two replies, first the listening address
+// and then the peer that connected.
+func (n *SalmonNear) handleBind(conn net.Conn, req *socks.Request) {
+	bind, err := n.currentBridge.NewNearBind(req.Host, req.Port)
+	if err != nil {
+		conn.Write(req.Reply(socks.RepGeneralFailure, ""))
+		log.Printf("NEAR: Bridge %s Failed to open bind on far: %v", n.bridgeName, err)
+		return
+	}
+	defer bind.Close()
+	conn.Write(req.Reply(socks.RepSucceeded, bind.BoundAddr))
+	log.Printf("NEAR: Bridge %s far listening on %s for bind", n.bridgeName, bind.BoundAddr)
+
+	stream, peer, err := bind.Accept()
+	if err != nil {
+		conn.Write(req.Reply(socks.RepGeneralFailure, ""))
+		log.Printf("NEAR: Bridge %s bind failed: %v", n.bridgeName, err)
+		return
+	}
+	defer stream.Close()
+	conn.Write(req.Reply(socks.RepSucceeded, peer))
+	log.Printf("NEAR: Bridge %s bind accepted %s", n.bridgeName, peer)
+
+	// The peer that connected in stands in for the target
+	accepted := time.Now()
+	stats := relayConnData(conn, stream, n.config.StreamIdleTimeout.Duration(), n.relayBufs)
+	n.logAccess(conn.RemoteAddr(), peer, accepted, stats)
+}
+
+// handleUDPAssociate serves a SOCKS5 UDP ASSOCIATE: the client's datagrams
+// arrive on a local relay socket and travel to the far side as QUIC
+// datagrams. The association lasts as long as the client's TCP connection.
+func (n *SalmonNear) handleUDPAssociate(conn net.Conn, req *socks.Request) {
+	if !n.config.DatagramMode {
+		conn.Write(req.Reply(socks.RepCommandNotSupported, ""))
+		log.Printf("NEAR: Bridge %s refused UDP associate, SBDatagramMode is off", n.bridgeName)
+		return
+	}
+
+	// Relay on the address the client reached us on, it can route back to it
+	relayIP := net.ParseIP(n.config.SocksListenAddress)
+	switch local := conn.LocalAddr().(type) {
+	case *net.TCPAddr:
+		relayIP = local.IP
+	case *net.UnixAddr:
+		// Clients of a UNIX socket are on this host
+		relayIP = net.IPv4(127, 0, 0, 1)
+	}
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: relayIP})
+	if err != nil {
+		conn.Write(req.Reply(socks.RepGeneralFailure, ""))
+		log.Printf("NEAR: Bridge %s failed to open UDP relay: %v", n.bridgeName, err)
+		return
+	}
+	defer relay.Close()
+
+	assoc, err := n.currentBridge.NewNearUDPAssociation()
+	if err != nil {
+		conn.Write(dialFailureReply(req, err))
+		log.Printf("NEAR: Bridge %s failed to open UDP associate on far: %v", n.bridgeName, err)
+		return
+	}
+	defer assoc.Close()
+	conn.Write(req.Reply(socks.RepSucceeded, relay.LocalAddr().String()))
+	log.Printf("NEAR: Bridge %s relaying UDP on %s", n.bridgeName, relay.LocalAddr())
+
+	// Only the client that asked may use the relay
+	var clientIP net.IP
+	if remote, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		clientIP = remote.IP
+	}
+	var client atomic.Pointer[net.UDPAddr]
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			read, from, err := relay.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if clientIP != nil && !from.IP.Equal(clientIP) {
+				continue
+			}
+			pkt, ok := socks.UDPPacket(buf[:read])
+			if !ok {
+				continue
+			}
+			client.Store(from)
+			// Lost like any datagram when too large for the path
+			assoc.Send(pkt)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			pkt, err := assoc.Receive(ctx)
+			if err != nil {
+				// The far side went away, so does the association
+				conn.Close()
+				return
+			}
+			if to := client.Load(); to != nil {
+				relay.WriteToUDP(socks.UDPDatagram(pkt), to)
+			}
+		}
+	}()
+
+	// The client sends nothing more on TCP, closing it ends the association
+	io.Copy(io.Discard, conn)
+}
+
+// bufferedConn reads through a bufio.Reader so bytes the client sent after
+// the request headers are not lost when the connection becomes a tunnel.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// parsePort reads a numeric TCP port. Unlike net.LookupPort it never
+// consults the local services database, so nothing about the target is
+// looked up on the near host.
+func parsePort(s string) (int, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return int(port), nil
+}
 
-	relayConnData(conn, stream)
+func writeHTTPStatus(conn net.Conn, code int) {
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", code, http.StatusText(code))
 }
 
-// HandleHTTP implements a minimal HTTP CONNECT proxy
+// HandleHTTP serves one HTTP proxy request: CONNECT is tunneled, and plain
+// absolute-form requests (GET http://host/path) are forwarded to the origin
+// in origin-form. The connection is closed after a forwarded response.
 func (n *SalmonNear) HandleHTTP(conn net.Conn) {
-	status.GlobalConnMonitorRef.IncHTTP()
+	status.GlobalConnMonitorRef.IncHTTP(n.bridgeName)
 	defer func() {
 		conn.Close()
-		status.GlobalConnMonitorRef.DecHTTP()
+		status.GlobalConnMonitorRef.DecHTTP(n.bridgeName)
 	}()
-	// Minimal parse: read first line
-	buf := make([]byte, 4096)
-	nread, err := conn.Read(buf)
-	if err != nil {
+	if !n.allowClientRate(conn.RemoteAddr()) {
+		writeHTTPStatus(conn, http.StatusTooManyRequests)
 		return
 	}
-	lineEnd := -1
-	for i := 0; i < nread-1; i++ {
-		if buf[i] == '\r' && buf[i+1] == '\n' {
-			lineEnd = i
-			break
-		}
+	if !n.acquireClient() {
+		writeHTTPStatus(conn, http.StatusServiceUnavailable)
+		log.Printf("NEAR: Bridge %s at %d clients, refusing %s", n.bridgeName, cap(n.clients), conn.RemoteAddr())
+		return
 	}
-	if lineEnd < 0 {
+	defer n.releaseClient()
+	if n.shouldBlockNearConn(conn.RemoteAddr().String()) {
+		status.GlobalConnMonitorRef.IncAllowlistBlock(n.bridgeName)
+		log.Printf("NEAR: Bridge %s recieved HTTP request unallowed near IP: %s", n.bridgeName, conn.RemoteAddr())
+		writeHTTPStatus(conn, http.StatusForbidden)
 		return
 	}
-	line := string(buf[:lineEnd])
-	// Expect: CONNECT host:port HTTP/1.1
-	var method, target, proto string
-	_, _ = method, proto
-	// naive split
-	parts := make([]string, 0, 3)
-	start := 0
-	for i := 0; i <= len(line); i++ {
-		if i == len(line) || line[i] == ' ' {
-			if i > start {
-				parts = append(parts, line[start:i])
-			}
-			start = i + 1
+
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		if err != io.EOF {
+			writeHTTPStatus(conn, http.StatusBadRequest)
 		}
+		return
 	}
-	if len(parts) < 2 || parts[0] != "CONNECT" {
-		conn.Write([]byte("HTTP/1.1 405 Method Not Allowed\r\n\r\n"))
+
+	if req.Method == http.MethodConnect {
+		n.handleHTTPConnect(&bufferedConn{Conn: conn, r: br}, req)
 		return
 	}
-	target = parts[1]
+	n.handleHTTPForward(conn, req)
+}
+
+func (n *SalmonNear) handleHTTPConnect(conn net.Conn, req *http.Request) {
 	// parse host:port
-	host, portStr, err := net.SplitHostPort(target)
+	host, portStr, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		writeHTTPStatus(conn, http.StatusBadRequest)
+		return
+	}
+	port, err := parsePort(portStr)
 	if err != nil {
-		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		writeHTTPStatus(conn, http.StatusBadRequest)
+		return
+	}
+	if !n.Enabled() {
+		writeHTTPStatus(conn, http.StatusServiceUnavailable)
 		return
 	}
-	// drain remaining headers until CRLFCRLF
-	// simplistic: if more bytes were read beyond first line, keep them in a buffer to forward after connect
-	// For CONNECT, there should be only headers and then raw tunnel.
 
 	// Open QUIC stream to far
-	// parse port
-	port, err := net.LookupPort("tcp", portStr)
+	opened := time.Now()
+	stream, done, err := n.openHTTPStream(host, port, conn.RemoteAddr())
 	if err != nil {
-		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		writeHTTPStatus(conn, http.StatusBadGateway)
 		return
 	}
-	stream, err := n.currentBridge.NewNearConn(host, port)
+	defer done()
+
+	// respond OK
+	conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+	stats := relayConnData(conn, stream, n.config.StreamIdleTimeout.Duration(), n.relayBufs)
+	n.logAccess(conn.RemoteAddr(), net.JoinHostPort(host, strconv.Itoa(port)), opened, stats)
+}
+
+// openHTTPStream opens a stream for an HTTP proxy request and logs its
+// open, close or dial failure. done closes the stream.
+func (n *SalmonNear) openHTTPStream(host string, port int, client net.Addr) (net.Conn, func(), error) {
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	opened := time.Now()
+	stream, err := n.openStream(host, port, bridge.AddrTypeOf(host), client)
 	if err != nil {
-		conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+		logging.Log(logging.Event{Bridge: n.bridgeName, Type: logging.EventDialFailure, Target: target, Latency: time.Since(opened), Err: err},
+			"NEAR: Bridge %s HTTP failed to open stream to far: %v", n.bridgeName, err)
+		return nil, nil, err
+	}
+	logging.Log(logging.Event{Bridge: n.bridgeName, Type: logging.EventOpen, Target: target, Latency: time.Since(opened)}, "")
+	done := func() {
+		stream.Close()
+		logging.Log(logging.Event{Bridge: n.bridgeName, Type: logging.EventClose, Target: target, Latency: time.Since(opened)}, "")
+	}
+	return stream, done, nil
+}
+
+// handleHTTPForward relays a plain proxy request to its origin through the
+// bridge and copies the response back.
+func (n *SalmonNear) handleHTTPForward(conn net.Conn, req *http.Request) {
+	if !req.URL.IsAbs() || req.URL.Scheme != "http" {
+		writeHTTPStatus(conn, http.StatusBadRequest)
 		return
 	}
+	if req.URL.Host == "" {
+		req.URL.Host = req.Host
+	}
+	if req.URL.Host == "" {
+		writeHTTPStatus(conn, http.StatusBadRequest)
+		return
+	}
+
+	host, portStr, err := net.SplitHostPort(req.URL.Host)
+	if err != nil {
+		host, portStr = req.URL.Hostname(), "80"
+	}
+	port, err := parsePort(portStr)
+	if err != nil || host == "" {
+		writeHTTPStatus(conn, http.StatusBadRequest)
+		return
+	}
+	if !n.Enabled() {
+		writeHTTPStatus(conn, http.StatusServiceUnavailable)
+		return
+	}
+
+	opened := time.Now()
+	stream, done, err := n.openHTTPStream(host, port, conn.RemoteAddr())
+	if err != nil {
+		writeHTTPStatus(conn, http.StatusBadGateway)
+		return
+	}
+	defer done()
+
+	// Count what goes each way the same as a relayed tunnel
+	up := &countingWriter{w: stream}
+	down := &countingWriter{w: conn}
+	stats := relayStats{reason: closeByTarget}
 	defer func() {
-		stream.Close()
-		//log.Printf("NEAR: Bridge %s closed HTTP stream to %s:%d", n.bridgeName, host, port)
+		stats.up, stats.down = up.n, down.n
+		n.logAccess(conn.RemoteAddr(), net.JoinHostPort(host, strconv.Itoa(port)), opened, stats)
 	}()
-	// respond OK
-	conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
 
-	relayConnData(conn, stream)
+	// Request.Write sends origin-form; drop proxy hop-by-hop headers and
+	// ask the origin to close so one request maps to one stream
+	req.Header.Del("Proxy-Connection")
+	req.Header.Del("Proxy-Authorization")
+	req.Header.Del("Connection")
+	req.Close = true
+	if err := req.Write(up); err != nil {
+		log.Printf("NEAR: Bridge %s HTTP forward to %s failed: %v", n.bridgeName, req.URL.Host, err)
+		stats.reason = "error: " + err.Error()
+		writeHTTPStatus(conn, http.StatusBadGateway)
+		return
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(stream), req)
+	if err != nil {
+		log.Printf("NEAR: Bridge %s HTTP response from %s failed: %v", n.bridgeName, req.URL.Host, err)
+		stats.reason = "error: " + err.Error()
+		writeHTTPStatus(conn, http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	resp.Close = true
+	if err := resp.Write(down); err != nil {
+		stats.reason = "error: " + err.Error()
+	}
+}
+
+// countingWriter counts the bytes written through it, for access logs of
+// requests that are not relayed by relayConnData.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
@@ -0,0 +1,1239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"salmoncannon/config"
+	"salmoncannon/socks"
+	"salmoncannon/status"
+)
+
+func TestSalmonNear_DisabledRefusesSocks(t *testing.T) {
+	cfg := &config.SalmonBridgeConfig{
+		Name:    "disabled-near",
+		Connect: true,
+		FarIp:   "127.0.0.1",
+		FarPort: 55140,
+	}
+	near, err := NewSalmonNear(cfg)
+	if err != nil {
+		t.Fatalf("failed to create near: %v", err)
+	}
+	defer near.Close()
+
+	registry := newNearRegistry()
+	registry.set(cfg.Name, near)
+	if !registry.SetBridgeEnabled(cfg.Name, false) {
+		t.Fatalf("expected registry to find bridge")
+	}
+	if registry.SetBridgeEnabled("missing", false) {
+		t.Fatalf("expected unknown bridge to be reported")
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go near.HandleRequest(server)
+
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	// Greeting: version 5, one method, no auth
+	client.Write([]byte{0x05, 0x01, 0x00})
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(client, method); err != nil {
+		t.Fatalf("failed to read method reply: %v", err)
+	}
+	// CONNECT 127.0.0.1:80
+	client.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0, 80})
+	reply := make([]byte, len(socks.ReplyFail))
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("failed to read connect reply: %v", err)
+	}
+	if !bytes.Equal(reply, socks.ReplyFail) {
+		t.Fatalf("expected general failure reply, got %v", reply)
+	}
+
+	near.SetEnabled(true)
+	if !near.Enabled() {
+		t.Fatalf("expected near to be enabled again")
+	}
+}
+
+// startNearFar runs a far bridge on farPort and a near bridge dialing it,
+// matching the far's datagram mode. Both are closed when the test ends.
+func startNearFar(t *testing.T, name string, farPort int, farCfg config.SalmonBridgeConfig) *SalmonNear {
+	t.Helper()
+	farCfg.Name = name
+	farCfg.Connect = false
+	farCfg.NearPort = farPort
+	cfg := &config.SalmonCannonConfig{
+		Bridges: []config.SalmonBridgeConfig{
+			farCfg,
+			{Name: name, Connect: true, FarIp: "127.0.0.1", FarPort: farPort, DatagramMode: farCfg.DatagramMode},
+		},
+	}
+	cfg.SetDefaults()
+
+	far, err := NewSalmonFar(&cfg.Bridges[0])
+	if err != nil {
+		t.Fatalf("failed to create far: %v", err)
+	}
+	t.Cleanup(far.Close)
+	go far.farBridge.NewFarListen()
+	time.Sleep(700 * time.Millisecond)
+
+	near, err := NewSalmonNear(&cfg.Bridges[1])
+	if err != nil {
+		t.Fatalf("failed to create near: %v", err)
+	}
+	t.Cleanup(near.Close)
+	return near
+}
+
+func TestSalmonNear_SocksBind(t *testing.T) {
+	near := startNearFar(t, "bind-socks", 55160, config.SalmonBridgeConfig{BindAddress: "127.0.0.1"})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go near.HandleRequest(server)
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.Write([]byte{0x05, 0x01, 0x00})
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(client, method); err != nil {
+		t.Fatalf("failed to read method reply: %v", err)
+	}
+	// BIND, expecting a peer from any address
+	client.Write([]byte{0x05, 0x02, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+
+	// First reply: where the far side is listening
+	first := make([]byte, 10)
+	if _, err := io.ReadFull(client, first); err != nil {
+		t.Fatalf("failed to read first bind reply: %v", err)
+	}
+	if first[1] != socks.RepSucceeded || !net.IP(first[4:8]).Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("unexpected first bind reply %v", first)
+	}
+	boundPort := int(first[8])<<8 | int(first[9])
+
+	peer, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(boundPort)))
+	if err != nil {
+		t.Fatalf("failed to connect to bound port: %v", err)
+	}
+	defer peer.Close()
+
+	// Second reply: who connected
+	second := make([]byte, 10)
+	if _, err := io.ReadFull(client, second); err != nil {
+		t.Fatalf("failed to read second bind reply: %v", err)
+	}
+	peerPort := peer.LocalAddr().(*net.TCPAddr).Port
+	if second[1] != socks.RepSucceeded || int(second[8])<<8|int(second[9]) != peerPort {
+		t.Fatalf("unexpected second bind reply %v, peer port %d", second, peerPort)
+	}
+
+	peer.SetDeadline(time.Now().Add(5 * time.Second))
+	peer.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("unexpected relayed data %q: %v", buf, err)
+	}
+}
+
+func TestSalmonNear_SocksUDPAssociate(t *testing.T) {
+	near := startNearFar(t, "udp-socks", 55172, config.SalmonBridgeConfig{DatagramMode: true})
+
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteToUDP(buf[:n], from)
+		}
+	}()
+
+	// A real TCP connection, the relay is opened on its local address
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			near.HandleRequest(conn)
+		}
+	}()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial near: %v", err)
+	}
+	defer client.Close()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.Write([]byte{0x05, 0x01, 0x00})
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(client, method); err != nil {
+		t.Fatalf("failed to read method reply: %v", err)
+	}
+	client.Write([]byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("failed to read associate reply: %v", err)
+	}
+	if reply[1] != socks.RepSucceeded {
+		t.Fatalf("unexpected associate reply %v", reply)
+	}
+	relay := &net.UDPAddr{IP: net.IP(reply[4:8]), Port: int(reply[8])<<8 | int(reply[9])}
+
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer udp.Close()
+	echoPort := echo.LocalAddr().(*net.UDPAddr).Port
+	pkt := append([]byte{0x00, 0x00, 0x00, 0x01, 127, 0, 0, 1, byte(echoPort >> 8), byte(echoPort)}, "ping"...)
+
+	// Datagrams may be lost, so retry until one round trip makes it
+	buf := make([]byte, 1500)
+	for i := 0; i < 5; i++ {
+		udp.WriteToUDP(pkt, relay)
+		udp.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := udp.ReadFromUDP(buf)
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(buf[:n], pkt) {
+			t.Fatalf("unexpected reply datagram %v, want %v", buf[:n], pkt)
+		}
+		return
+	}
+	t.Fatal("no UDP reply through the bridge")
+}
+
+func TestSalmonNear_SocksUDPAssociateNeedsDatagramMode(t *testing.T) {
+	near := &SalmonNear{bridgeName: "udp-off", config: &config.SalmonBridgeConfig{}}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go near.handleUDPAssociate(server, &socks.Request{Version: 5, Cmd: socks.CmdUDPAssociate})
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("failed to read reply: %v", err)
+	}
+	if reply[1] != socks.RepCommandNotSupported {
+		t.Fatalf("unexpected reply %v", reply)
+	}
+}
+
+// socksConnect sends a no-auth SOCKS5 CONNECT for addr (ATYP and DST.ADDR)
+// and port, and returns the whole reply.
+func socksConnect(t *testing.T, near *SalmonNear, addr []byte, port int) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	go near.HandleRequest(server)
+
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	client.Write([]byte{0x05, 0x01, 0x00})
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(client, method); err != nil {
+		t.Fatalf("failed to read method reply: %v", err)
+	}
+	req := append([]byte{0x05, 0x01, 0x00}, addr...)
+	client.Write(append(req, byte(port>>8), byte(port)))
+	reply := make([]byte, 5)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("failed to read connect reply: %v", err)
+	}
+	// BND.ADDR less the byte already read, then BND.PORT
+	rest := 2
+	switch reply[3] {
+	case socks.AddrTypeIPv4:
+		rest += 3
+	case socks.AddrTypeIPv6:
+		rest += 15
+	case socks.AddrTypeDomain:
+		rest += int(reply[4])
+	}
+	tail := make([]byte, rest)
+	if _, err := io.ReadFull(client, tail); err != nil {
+		t.Fatalf("failed to read connect reply: %v", err)
+	}
+	return append(reply, tail...)
+}
+
+// socksConnectReply is socksConnect returning just the reply code.
+func socksConnectReply(t *testing.T, near *SalmonNear, addr []byte, port int) byte {
+	t.Helper()
+	return socksConnect(t, near, addr, port)[1]
+}
+
+func TestSalmonNear_SocksDialFailureReplies(t *testing.T) {
+	near := startNearFar(t, "dial-replies", 55167, config.SalmonBridgeConfig{
+		AllowedOutAddressTypes: []string{config.AddressTypeIPv4, config.AddressTypeDomain},
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	openPort := ln.Addr().(*net.TCPAddr).Port
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	ipv4 := []byte{0x01, 127, 0, 0, 1}
+	tests := []struct {
+		name string
+		addr []byte
+		port int
+		want byte
+	}{
+		{"connected", ipv4, openPort, socks.RepSucceeded},
+		{"refused", ipv4, closedPort, socks.RepConnectionRefused},
+		{"unresolvable", append([]byte{0x03, 19}, "nonexistent.invalid"...), 80, socks.RepHostUnreachable},
+		{"not allowed", append([]byte{0x04}, net.IPv6loopback...), 80, socks.RepNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := socksConnectReply(t, near, tt.addr, tt.port); got != tt.want {
+				t.Fatalf("expected reply 0x%02x, got 0x%02x", tt.want, got)
+			}
+		})
+	}
+
+	// The far counts its refusals before replying
+	rejects := status.GlobalConnMonitorRef.Rejections("dial-replies")
+	if rejects.DialFailures != 2 || rejects.AllowlistBlocks != 1 {
+		t.Fatalf("expected 2 dial failures and 1 allowlist block, got %+v", rejects)
+	}
+}
+
+func TestSalmonNear_SocksReplyAddressFamily(t *testing.T) {
+	near := startNearFar(t, "reply-family", 55170, config.SalmonBridgeConfig{})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	openPort := ln.Addr().(*net.TCPAddr).Port
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	ipv4 := []byte{0x01, 127, 0, 0, 1}
+	tests := []struct {
+		name string
+		port int
+		want []byte
+	}{
+		{"connected", openPort, []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}},
+		{"refused", closedPort, []byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run("ipv4 "+tt.name, func(t *testing.T) {
+			if got := socksConnect(t, near, ipv4, tt.port); !bytes.Equal(got, tt.want) {
+				t.Fatalf("expected reply %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	// Refused, or unreachable where there is no IPv6, so only the layout
+	// is checked
+	got := socksConnect(t, near, append([]byte{0x04}, net.IPv6loopback...), closedPort)
+	if len(got) != 22 || got[1] == socks.RepSucceeded || got[3] != socks.AddrTypeIPv6 || !bytes.Equal(got[4:], make([]byte, 18)) {
+		t.Fatalf("expected a zero IPv6 failure reply, got %v", got)
+	}
+}
+
+func TestSalmonNear_RejectionCounters(t *testing.T) {
+	near := startNearFar(t, "near-rejects", 55169, config.SalmonBridgeConfig{})
+
+	handleWith := func(serve func(net.Conn), payload []byte) {
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			serve(server)
+			close(done)
+		}()
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+		// A refused HTTP client is answered before its request is read
+		go client.Write(payload)
+		io.Copy(io.Discard, client)
+		client.Close()
+		<-done
+	}
+	handle := func(payload []byte) { handleWith(near.HandleRequest, payload) }
+
+	// Not a SOCKS version
+	handle([]byte{0x09, 0x01, 0x00})
+	// Only GSSAPI offered
+	handle([]byte{0x05, 0x01, 0x01})
+
+	filter, err := config.ParseAddressFilter([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("failed to parse filter: %v", err)
+	}
+	near.SetAllowedIn(filter)
+	handle(nil)
+	// The HTTP proxy listener is held to the same allow list
+	handleWith(near.HandleHTTP, []byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+
+	rejects := status.GlobalConnMonitorRef.Rejections("near-rejects")
+	if rejects.HandshakeFailures != 1 || rejects.AuthMismatch != 1 || rejects.AllowlistBlocks != 2 {
+		t.Fatalf("expected 1 handshake failure, 1 auth mismatch and 2 allowlist blocks, got %+v", rejects)
+	}
+}
+
+// remoteConn is a net.Conn that reports addr as its peer.
+type remoteConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c remoteConn) RemoteAddr() net.Addr { return c.addr }
+
+func TestSalmonNear_PerClientConnRate(t *testing.T) {
+	cfg := &config.SalmonCannonConfig{Bridges: []config.SalmonBridgeConfig{
+		{Name: "near-conn-rate", Connect: true, FarIp: "127.0.0.1", FarPort: 55174, PerClientConnRate: 0.1, PerClientConnBurst: 3},
+	}}
+	cfg.SetDefaults()
+	near, err := NewSalmonNear(&cfg.Bridges[0])
+	if err != nil {
+		t.Fatalf("failed to create near: %v", err)
+	}
+	defer near.Close()
+
+	// open starts a client from ip and reports whether the near answered
+	// its greeting
+	open := func(ip string) bool {
+		client, server := net.Pipe()
+		defer client.Close()
+		go near.HandleRequest(remoteConn{server, &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}})
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := client.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+			return false
+		}
+		method := make([]byte, 2)
+		_, err := io.ReadFull(client, method)
+		return err == nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if !open("10.1.0.1") {
+			t.Fatalf("expected connection %d within the burst to be served", i+1)
+		}
+	}
+	if open("10.1.0.1") {
+		t.Fatalf("expected a rapid fourth connection to be throttled")
+	}
+	if !open("10.1.0.2") {
+		t.Fatalf("expected a second IP to be unaffected")
+	}
+	if got := status.GlobalConnMonitorRef.Rejections("near-conn-rate").ClientRateLimit; got != 1 {
+		t.Fatalf("expected 1 client rate rejection, got %d", got)
+	}
+}
+
+func TestSalmonNear_MaxConcurrentClients(t *testing.T) {
+	cfg := &config.SalmonCannonConfig{Bridges: []config.SalmonBridgeConfig{
+		{Name: "near-clients", Connect: true, FarIp: "127.0.0.1", FarPort: 55171, MaxConcurrentClients: 2},
+	}}
+	cfg.SetDefaults()
+	near, err := NewSalmonNear(&cfg.Bridges[0])
+	if err != nil {
+		t.Fatalf("failed to create near: %v", err)
+	}
+	defer near.Close()
+
+	// open starts a client and reports whether the near answered its greeting
+	open := func() (net.Conn, bool) {
+		client, server := net.Pipe()
+		go near.HandleRequest(server)
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := client.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+			return client, false
+		}
+		method := make([]byte, 2)
+		_, err := io.ReadFull(client, method)
+		return client, err == nil
+	}
+
+	var clients []net.Conn
+	for i := 0; i < 2; i++ {
+		client, ok := open()
+		if !ok {
+			t.Fatalf("expected client %d within the limit to be served", i+1)
+		}
+		clients = append(clients, client)
+	}
+	extra, ok := open()
+	extra.Close()
+	if ok {
+		t.Fatalf("expected the client past the limit to be refused")
+	}
+	if got := status.GlobalConnMonitorRef.Rejections("near-clients").ClientLimit; got != 1 {
+		t.Fatalf("expected 1 client limit rejection, got %d", got)
+	}
+
+	// A finished client frees its slot
+	clients[0].Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		client, ok := open()
+		client.Close()
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a freed slot to serve a new client")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	clients[1].Close()
+}
+
+func TestSalmonNear_Socks4Connect(t *testing.T) {
+	near := startNearFar(t, "socks4", 55168, config.SalmonBridgeConfig{})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	// SOCKS4a CONNECT localhost:port
+	client, server := net.Pipe()
+	defer client.Close()
+	go near.HandleRequest(server)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.Write(append([]byte{0x04, 0x01, byte(port >> 8), byte(port), 0, 0, 0, 1, 0x00}, "localhost\x00"...))
+
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("failed to read connect reply: %v", err)
+	}
+	if reply[0] != 0x00 || reply[1] != 0x5a {
+		t.Fatalf("expected SOCKS4 granted reply, got %v", reply)
+	}
+	client.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("unexpected relayed data %q: %v", buf, err)
+	}
+
+	// A refused dial is reported as rejected
+	ln.Close()
+	refused, server := net.Pipe()
+	defer refused.Close()
+	go near.HandleRequest(server)
+	refused.SetDeadline(time.Now().Add(5 * time.Second))
+	refused.Write([]byte{0x04, 0x01, byte(port >> 8), byte(port), 127, 0, 0, 1, 0x00})
+	if _, err := io.ReadFull(refused, reply); err != nil {
+		t.Fatalf("failed to read connect reply: %v", err)
+	}
+	if reply[0] != 0x00 || reply[1] != 0x5b {
+		t.Fatalf("expected SOCKS4 rejected reply, got %v", reply)
+	}
+}
+
+func TestSalmonNear_FallbackDirect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	// No far is listening on this port
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve UDP port: %v", err)
+	}
+	farPort := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+
+	newNear := func(fallback bool, allowedOut []string) *SalmonNear {
+		cfg := &config.SalmonCannonConfig{Bridges: []config.SalmonBridgeConfig{{
+			Name: "fallback-direct", Connect: true, FarIp: "127.0.0.1", FarPort: farPort,
+			DialTimeout: config.DurationString(300 * time.Millisecond), FallbackDirect: fallback,
+			AllowedOutAddresses: allowedOut,
+		}}}
+		cfg.SetDefaults()
+		near, err := NewSalmonNear(&cfg.Bridges[0])
+		if err != nil {
+			t.Fatalf("failed to create near: %v", err)
+		}
+		t.Cleanup(near.Close)
+		return near
+	}
+	ipv4 := []byte{0x01, 127, 0, 0, 1}
+
+	if got := socksConnectReply(t, newNear(false, nil), ipv4, port); got != socks.RepGeneralFailure {
+		t.Fatalf("expected failure without fallback, got reply 0x%02x", got)
+	}
+	if got := socksConnectReply(t, newNear(true, []string{"10.0.0.0/8"}), ipv4, port); got != socks.RepNotAllowed {
+		t.Fatalf("expected fallback to honor allowed out addresses, got reply 0x%02x", got)
+	}
+
+	near := newNear(true, nil)
+	client, server := net.Pipe()
+	defer client.Close()
+	go near.HandleRequest(server)
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	client.Write([]byte{0x05, 0x01, 0x00})
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(client, method); err != nil {
+		t.Fatalf("failed to read method reply: %v", err)
+	}
+	client.Write(append([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1}, byte(port>>8), byte(port)))
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil || reply[1] != socks.RepSucceeded {
+		t.Fatalf("expected fallback to connect, got reply %v: %v", reply, err)
+	}
+	client.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("unexpected relayed data %q: %v", buf, err)
+	}
+}
+
+func TestSalmonNear_RemoteDNS(t *testing.T) {
+	// No far is listening on this port, so every connect falls back
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve UDP port: %v", err)
+	}
+	farPort := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+
+	// Records every query the near makes instead of sending it
+	var lookups atomic.Int32
+	resolver := &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		lookups.Add(1)
+		return nil, errors.New("local DNS lookup")
+	}}
+
+	newNear := func(remoteDNS bool) *SalmonNear {
+		cfg := &config.SalmonCannonConfig{Bridges: []config.SalmonBridgeConfig{{
+			Name: "remote-dns", Connect: true, FarIp: "127.0.0.1", FarPort: farPort,
+			DialTimeout: config.DurationString(300 * time.Millisecond), FallbackDirect: true,
+			RemoteDNS: remoteDNS,
+		}}}
+		cfg.SetDefaults()
+		near, err := NewSalmonNear(&cfg.Bridges[0])
+		if err != nil {
+			t.Fatalf("failed to create near: %v", err)
+		}
+		near.currentBridge.SetResolver(resolver)
+		t.Cleanup(near.Close)
+		return near
+	}
+	name := "remote-dns.invalid"
+	domain := append([]byte{0x03, byte(len(name))}, name...)
+
+	if got := socksConnectReply(t, newNear(true), domain, 80); got != socks.RepNotAllowed {
+		t.Fatalf("expected the direct dial to be refused, got reply 0x%02x", got)
+	}
+	if n := lookups.Load(); n != 0 {
+		t.Fatalf("expected no local DNS lookups with SBRemoteDNS, got %d", n)
+	}
+
+	// Without it the fallback resolves locally, which the resolver sees
+	socksConnectReply(t, newNear(false), domain, 80)
+	if lookups.Load() == 0 {
+		t.Fatalf("expected the recording resolver to see the local lookup")
+	}
+}
+
+func TestSalmonNear_IngressCounts(t *testing.T) {
+	// No far is listening, every entry type is counted before it fails
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve UDP port: %v", err)
+	}
+	farPort := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+
+	cfg := &config.SalmonCannonConfig{Bridges: []config.SalmonBridgeConfig{{
+		Name: "ingress-types", Connect: true, FarIp: "127.0.0.1", FarPort: farPort,
+		DialTimeout: config.DurationString(300 * time.Millisecond),
+	}}}
+	cfg.SetDefaults()
+	near, err := NewSalmonNear(&cfg.Bridges[0])
+	if err != nil {
+		t.Fatalf("failed to create near: %v", err)
+	}
+	defer near.Close()
+	registry := newNearRegistry()
+	registry.set(near.bridgeName, near)
+
+	waitIngress := func(want status.IngressCounts) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got := status.GlobalConnMonitorRef.Ingress(near.bridgeName)
+			if got == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %+v, got %+v", want, got)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// An open SOCKS client shows as active until it goes away
+	client, server := net.Pipe()
+	go near.HandleRequest(server)
+	waitIngress(status.IngressCounts{ActiveSOCKS: 1, TotalSOCKS: 1})
+	client.Close()
+	waitIngress(status.IngressCounts{TotalSOCKS: 1})
+
+	client, server = net.Pipe()
+	go near.HandleHTTP(server)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	go client.Write([]byte("CONNECT 127.0.0.1:1 HTTP/1.1\r\nHost: 127.0.0.1:1\r\n\r\n"))
+	if _, err := http.ReadResponse(bufio.NewReader(client), &http.Request{Method: http.MethodConnect}); err != nil {
+		t.Fatalf("failed to read CONNECT response: %v", err)
+	}
+	client.Close()
+	waitIngress(status.IngressCounts{TotalSOCKS: 1, TotalHTTP: 1})
+
+	redirects := &config.SocksRedirectConfig{Redirects: map[string]string{"127.0.0.1": near.bridgeName}}
+	client, server = net.Pipe()
+	go handleSocksRedirect(server, redirects, registry)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.Write([]byte{0x05, 0x01, 0x00})
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(client, method); err != nil {
+		t.Fatalf("failed to read method reply: %v", err)
+	}
+	client.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0, 1})
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("failed to read redirect reply: %v", err)
+	}
+	client.Close()
+	waitIngress(status.IngressCounts{TotalSOCKS: 1, TotalHTTP: 1, TotalRedirect: 1})
+}
+
+func TestSalmonNear_HTTPForwardsPlainRequests(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.RequestURI != "/hello?x=1" {
+			t.Errorf("expected origin-form request URI, got %q", r.RequestURI)
+		}
+		if r.Header.Get("Proxy-Connection") != "" {
+			t.Errorf("expected Proxy-Connection to be stripped")
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Write([]byte("origin:" + string(body)))
+	}))
+	defer origin.Close()
+
+	near := startNearFar(t, "http-forward", 55162, config.SalmonBridgeConfig{})
+
+	for _, tc := range []struct {
+		method, body string
+	}{
+		{http.MethodGet, ""},
+		{http.MethodPost, "payload"},
+	} {
+		client, server := net.Pipe()
+		go near.HandleHTTP(server)
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+
+		req, _ := http.NewRequest(tc.method, origin.URL+"/hello?x=1", strings.NewReader(tc.body))
+		req.Header.Set("Proxy-Connection", "keep-alive")
+		go req.WriteProxy(client)
+
+		resp, err := http.ReadResponse(bufio.NewReader(client), req)
+		if err != nil {
+			t.Fatalf("%s: failed to read response: %v", tc.method, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		client.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Method") != tc.method || string(body) != "origin:"+tc.body {
+			t.Fatalf("%s: unexpected response %d %q", tc.method, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestSalmonNear_HTTPRejectsBadRequests(t *testing.T) {
+	cfg := &config.SalmonBridgeConfig{
+		Name:    "http-bad",
+		Connect: true,
+		FarIp:   "127.0.0.1",
+		FarPort: 55164,
+	}
+	near, err := NewSalmonNear(cfg)
+	if err != nil {
+		t.Fatalf("failed to create near: %v", err)
+	}
+	defer near.Close()
+
+	for name, raw := range map[string]string{
+		"origin-form":  "GET /path HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"missing host": "GET http:///path HTTP/1.1\r\n\r\n",
+		"https scheme": "GET https://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"named port":   "CONNECT example.com:https HTTP/1.1\r\nHost: example.com:https\r\n\r\n",
+	} {
+		client, server := net.Pipe()
+		go near.HandleHTTP(server)
+		client.SetDeadline(time.Now().Add(2 * time.Second))
+		go client.Write([]byte(raw))
+
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		client.Close()
+		if err != nil {
+			t.Fatalf("%s: failed to read response: %v", name, err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, resp.StatusCode)
+		}
+	}
+}
+
+func TestSalmonNear_HTTPConnectTunnel(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tunneled"))
+	}))
+	defer origin.Close()
+
+	near := startNearFar(t, "http-connect", 55166, config.SalmonBridgeConfig{})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go near.HandleHTTP(server)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Pipeline the tunneled request right behind the CONNECT headers
+	originHost := strings.TrimPrefix(origin.URL, "http://")
+	go client.Write([]byte("CONNECT " + originHost + " HTTP/1.1\r\nHost: " + originHost + "\r\n\r\n" +
+		"GET / HTTP/1.1\r\nHost: " + originHost + "\r\nConnection: close\r\n\r\n"))
+
+	br := bufio.NewReader(client)
+	established, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil || established.StatusCode != http.StatusOK {
+		t.Fatalf("expected tunnel to be established: %v", err)
+	}
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("failed to read tunneled response: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "tunneled" {
+		t.Fatalf("unexpected tunneled body %q", body)
+	}
+}
+
+func TestRelayConnData_IdleTimeout(t *testing.T) {
+	// Both peers open and go silent
+	client, clientSide := net.Pipe()
+	stream, streamSide := net.Pipe()
+	defer client.Close()
+	defer stream.Close()
+
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		relayConnData(clientSide, streamSide, 200*time.Millisecond, nil)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Fatalf("relay torn down before the idle timeout: %v", elapsed)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("silent relay was not torn down")
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expected client side to be closed")
+	}
+}
+
+func TestRelayConnData_OneWayTrafficIsNotIdle(t *testing.T) {
+	client, clientSide := net.Pipe()
+	stream, streamSide := net.Pipe()
+	defer client.Close()
+	defer stream.Close()
+
+	done := make(chan struct{})
+	go func() {
+		relayConnData(clientSide, streamSide, 200*time.Millisecond, nil)
+		close(done)
+	}()
+
+	// The stream sends for longer than the timeout while the client stays quiet
+	go io.Copy(io.Discard, client)
+	for i := 0; i < 10; i++ {
+		if _, err := stream.Write([]byte("data")); err != nil {
+			t.Fatalf("relay closed during one-way traffic: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatalf("relay torn down while data was flowing")
+	default:
+	}
+}
+
+func TestSalmonNear_SocksHalfClose(t *testing.T) {
+	near := startNearFar(t, "half-close", 55173, config.SalmonBridgeConfig{})
+
+	// Answers only once the client has finished sending
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer target.Close()
+	go func() {
+		c, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		req, _ := io.ReadAll(c)
+		c.Write(append([]byte("got "), req...))
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			near.HandleRequest(conn)
+		}
+	}()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial near: %v", err)
+	}
+	defer client.Close()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.Write([]byte{0x05, 0x01, 0x00})
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(client, method); err != nil {
+		t.Fatalf("failed to read method reply: %v", err)
+	}
+	port := target.Addr().(*net.TCPAddr).Port
+	client.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, byte(port >> 8), byte(port)})
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil || reply[1] != socks.RepSucceeded {
+		t.Fatalf("unexpected connect reply %v: %v", reply, err)
+	}
+
+	client.Write([]byte("request"))
+	client.(*net.TCPConn).CloseWrite()
+	got, err := io.ReadAll(client)
+	if err != nil || string(got) != "got request" {
+		t.Fatalf("expected the full response after half-close, got %q %v", got, err)
+	}
+}
+
+func TestSalmonNear_UnixSocketListener(t *testing.T) {
+	near := startNearFar(t, "unix-socks", 55181, config.SalmonBridgeConfig{})
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer target.Close()
+	go func() {
+		c, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	path := filepath.Join(t.TempDir(), "near.sock")
+	cfg := *near.config
+	cfg.SocksListenAddress = config.UnixSocketPrefix + path
+	if err := initNear(&cfg, near); err != nil {
+		t.Fatalf("failed to listen on UNIX socket: %v", err)
+	}
+
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to dial near: %v", err)
+	}
+	defer client.Close()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.Write([]byte{0x05, 0x01, 0x00})
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(client, method); err != nil {
+		t.Fatalf("failed to read method reply: %v", err)
+	}
+	port := target.Addr().(*net.TCPAddr).Port
+	client.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, byte(port >> 8), byte(port)})
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil || reply[1] != socks.RepSucceeded {
+		t.Fatalf("unexpected connect reply %v: %v", reply, err)
+	}
+
+	client.Write([]byte("ping"))
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(client, got); err != nil || string(got) != "ping" {
+		t.Fatalf("expected echo over the UNIX socket, got %q %v", got, err)
+	}
+
+	// Closing the near removes the socket file
+	near.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the socket file to be removed when the near closed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSalmonNear_RequireFarOnStart(t *testing.T) {
+	cfg := &config.SalmonCannonConfig{
+		Bridges: []config.SalmonBridgeConfig{{
+			Name: "require-far", Connect: true, FarIp: "127.0.0.1", FarPort: 55177,
+			RequireFarOnStart: true, DialTimeout: config.DurationString(500 * time.Millisecond),
+		}},
+	}
+	cfg.SetDefaults()
+
+	// Nothing listens on the far port
+	start := time.Now()
+	near, err := NewSalmonNear(&cfg.Bridges[0])
+	if err == nil {
+		near.Close()
+		t.Fatalf("expected startup to fail with the far side down")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected startup to fail within the dial timeout, took %v", elapsed)
+	}
+
+	// Once the far is up the same config starts
+	startNearFar(t, "require-far", 55177, config.SalmonBridgeConfig{})
+	near, err = NewSalmonNear(&cfg.Bridges[0])
+	if err != nil {
+		t.Fatalf("expected startup to succeed with the far side up: %v", err)
+	}
+	near.Close()
+}
+
+func TestSalmonNear_StatusChecksMarkBridgeAlive(t *testing.T) {
+	near := startNearFar(t, "status-sched", 55178, config.SalmonBridgeConfig{})
+	if near.config.StatusCheckFrequency.Duration() != 10*time.Second {
+		t.Fatalf("expected the default status check frequency, got %v", near.config.StatusCheckFrequency.Duration())
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for !status.GlobalConnMonitorRef.GetStatus(near.bridgeName) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !status.GlobalConnMonitorRef.GetStatus(near.bridgeName) {
+		t.Fatalf("expected the bridge to be reported alive once status checks ran")
+	}
+	if ping := status.GlobalConnMonitorRef.GetPing(near.bridgeName); ping < 0 {
+		t.Fatalf("expected a ping time, got %d", ping)
+	}
+	if got := near.currentBridge.Limiter().GetBytesTransferred(); got != 0 {
+		t.Fatalf("expected status checks to bypass the bandwidth limiter, %d bytes counted", got)
+	}
+}
+
+func TestSalmonNear_AlpnMismatchExplained(t *testing.T) {
+	near := startNearFar(t, "alpn-mismatch", 55179, config.SalmonBridgeConfig{Alpn: "other"})
+
+	_, err := near.openStream("127.0.0.1", 80, 0x01, nil)
+	if err == nil || !strings.Contains(err.Error(), "SBAlpn must match") {
+		t.Fatalf("expected the dial error to point at SBAlpn, got %v", err)
+	}
+}
+
+func TestSalmonNear_AccessLog(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.ReadFull(c, make([]byte, 5))
+		c.Write([]byte("hello world"))
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	near := startNearFar(t, "access-log", 55180, config.SalmonBridgeConfig{})
+	var logs lockedBuffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		near.HandleRequest(server)
+		close(done)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.Write([]byte{0x05, 0x01, 0x00})
+	io.ReadFull(client, make([]byte, 2))
+	client.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, byte(port >> 8), byte(port)})
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil || reply[1] != socks.RepSucceeded {
+		t.Fatalf("expected connect to succeed, got %v: %v", reply, err)
+	}
+	client.Write([]byte("hello"))
+	go io.Copy(io.Discard, client)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("tunnel did not finish after the target closed")
+	}
+	want := fmt.Sprintf("NEAR: Bridge access-log access client=pipe target=127.0.0.1:%d up=5 down=11 duration=", port)
+	if got := logs.String(); !strings.Contains(got, want) || !strings.Contains(got, `reason="target closed"`) {
+		t.Fatalf("expected an access line %q with the target closing, got logs:\n%s", want, got)
+	}
+}
+
+func TestSalmonNear_HTTPForwardAccessLog(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("forwarded"))
+	}))
+	defer origin.Close()
+
+	near := startNearFar(t, "http-forward-log", 55187, config.SalmonBridgeConfig{})
+	var logs lockedBuffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		near.HandleHTTP(server)
+		close(done)
+	}()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest(http.MethodGet, origin.URL+"/", nil)
+	go req.WriteProxy(client)
+	resp, err := http.ReadResponse(bufio.NewReader(client), req)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	io.ReadAll(resp.Body)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("forwarded request did not finish")
+	}
+	target := strings.TrimPrefix(origin.URL, "http://")
+	want := regexp.MustCompile(`NEAR: Bridge http-forward-log access client=pipe target=` + regexp.QuoteMeta(target) +
+		` up=[1-9][0-9]* down=[1-9][0-9]* duration=\S+ reason="target closed"`)
+	if got := logs.String(); !want.MatchString(got) {
+		t.Fatalf("expected an access line for %s, got logs:\n%s", target, got)
+	}
+}
+
+func TestSocksAuth_Backends(t *testing.T) {
+	cfg := &config.SalmonBridgeConfig{Name: "auth-bridge"}
+	auth, err := socksAuth(cfg)
+	if err != nil {
+		t.Fatalf("socksAuth: %v", err)
+	}
+	if auth.Required() {
+		t.Fatalf("expected no auth without SBSocksUsers, SBAuthFile or SBAuthCommand")
+	}
+
+	path := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(path, []byte("bob:file-pass\n"), 0600); err != nil {
+		t.Fatalf("failed to write auth file: %v", err)
+	}
+	cfg.SocksUsers = map[string]string{"alice": "static-pass"}
+	cfg.AuthFile = path
+	auth, err = socksAuth(cfg)
+	if err != nil {
+		t.Fatalf("socksAuth: %v", err)
+	}
+	if !auth.Verify("alice", "static-pass") || !auth.Verify("bob", "file-pass") || auth.Verify("bob", "static-pass") {
+		t.Fatalf("expected both SBSocksUsers and SBAuthFile users to be accepted")
+	}
+
+	cfg.AuthFile = filepath.Join(t.TempDir(), "missing")
+	if _, err := socksAuth(cfg); err == nil || !strings.Contains(err.Error(), "SBAuthFile") {
+		t.Fatalf("expected a missing SBAuthFile to fail, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"salmoncannon/config"
+	"salmoncannon/socks"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+// Receive windows a QUIC connection starts with before auto-tuning grows them
+// towards SBMaxRecieveBufferSize.
+const (
+	initialStreamReceiveWindow     = 50 * 1024 * 1024
+	initialConnectionReceiveWindow = 25 * 1024 * 1024
+)
+
+// newQuicConfig builds the QUIC config both ends of a bridge use, so a near
+// and its far agree on flow control. The initial windows are clamped to
+// SBMaxRecieveBufferSize; a window starting above its maximum would never be
+// tuned and would let a peer buffer more than configured.
+func newQuicConfig(cfg *config.SalmonBridgeConfig) *quic.Config {
+	maxWindow := uint64(cfg.MaxRecieveBufferSize)
+	return &quic.Config{
+		MaxIdleTimeout:                 cfg.IdleTimeout.Duration(),
+		InitialStreamReceiveWindow:     min(initialStreamReceiveWindow, maxWindow),
+		MaxStreamReceiveWindow:         maxWindow,
+		InitialConnectionReceiveWindow: min(initialConnectionReceiveWindow, maxWindow),
+		MaxConnectionReceiveWindow:     maxWindow,
+		InitialPacketSize:              uint16(cfg.InitialPacketSize),
+		MaxIncomingStreams:             socks.MaxConnections,
+		MaxIncomingUniStreams:          socks.MaxConnections,
+		EnableDatagrams:                cfg.DatagramMode,
+	}
+}
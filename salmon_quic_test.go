@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"salmoncannon/config"
+)
+
+func TestNewQuicConfig_ClampsInitialWindows(t *testing.T) {
+	cfg := &config.SalmonBridgeConfig{MaxRecieveBufferSize: config.SizeString(8 * 1024 * 1024)}
+	qcfg := newQuicConfig(cfg)
+	if qcfg.InitialStreamReceiveWindow != 8*1024*1024 || qcfg.InitialConnectionReceiveWindow != 8*1024*1024 {
+		t.Fatalf("expected initial windows clamped to 8MB, got stream=%d conn=%d",
+			qcfg.InitialStreamReceiveWindow, qcfg.InitialConnectionReceiveWindow)
+	}
+
+	cfg.MaxRecieveBufferSize = config.SizeString(400 * 1024 * 1024)
+	qcfg = newQuicConfig(cfg)
+	if qcfg.InitialStreamReceiveWindow != initialStreamReceiveWindow || qcfg.InitialConnectionReceiveWindow != initialConnectionReceiveWindow {
+		t.Fatalf("expected default initial windows, got stream=%d conn=%d",
+			qcfg.InitialStreamReceiveWindow, qcfg.InitialConnectionReceiveWindow)
+	}
+	if qcfg.MaxStreamReceiveWindow != qcfg.MaxConnectionReceiveWindow {
+		t.Fatalf("expected stream and connection maximums to match")
+	}
+}
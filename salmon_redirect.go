@@ -5,48 +5,60 @@ import (
 	"net"
 	"salmoncannon/config"
 	"salmoncannon/socks"
+	"salmoncannon/status"
 	"strconv"
-	"strings"
+	"time"
 )
 
-func handleSocksRedirect(conn net.Conn, socksConfig *config.SocksRedirectConfig, bridgeRegistry *map[string]*SalmonNear) {
+func handleSocksRedirect(conn net.Conn, socksConfig *config.SocksRedirectConfig, bridgeRegistry *nearRegistry) {
 	defer conn.Close()
 	dummyBridgeName := "SocksRedirectBridge"
 	//log.Printf("NEAR: Bridge %s accepted connection from %s", dummyBridgeName, conn.RemoteAddr())
 
-	host, port, err := socks.HandleSocksHandshake(conn, dummyBridgeName)
+	req, err := socks.HandleSocksRequest(conn, dummyBridgeName, nil)
 	if err != nil {
 		log.Printf("NEAR: Bridge %s Failed to handle SOCKS handshake: %v", dummyBridgeName, err)
 		return
 	}
+	if req.Cmd != socks.CmdConnect {
+		conn.Write(req.Reply(socks.RepGeneralFailure, ""))
+		log.Printf("NEAR: Bridge %s Failed to handle SOCKS handshake: unsupported command: %d", dummyBridgeName, req.Cmd)
+		return
+	}
+	host, port := req.Host, req.Port
 
 	// Check to see if we have a redirect for this destination
-	var bridgeName string
-	for addrPart, bName := range socksConfig.Redirects {
-		if strings.Contains(host, addrPart) {
-			bridgeName = bName
-			break
-		}
-	}
+	bridgeName := socksConfig.BridgeFor(host)
 
-	if bridgeName == "" || (*bridgeRegistry)[bridgeName] == nil {
+	near := bridgeRegistry.Get(bridgeName)
+	if bridgeName == "" || near == nil {
 		log.Printf("SOCKS Redirector: No redirect found for destination %s", host)
-		conn.Write(socks.ReplyFail)
+		conn.Write(req.Reply(socks.RepGeneralFailure, ""))
 		return
 	}
 	log.Printf("SOCKS Redirector: Redirecting %s:%d to bridge %s", host, port, bridgeName)
+	status.GlobalConnMonitorRef.IncRedirect(near.bridgeName)
+	defer status.GlobalConnMonitorRef.DecRedirect(near.bridgeName)
 
 	// Do our block check here
-	if (*bridgeRegistry)[bridgeName].shouldBlockNearConn(conn.RemoteAddr().String()) {
-		log.Printf("NEAR: Bridge %s recieved request unallowed near IP: %s", (*bridgeRegistry)[bridgeName].bridgeName, conn.RemoteAddr())
+	if near.shouldBlockNearConn(conn.RemoteAddr().String()) {
+		status.GlobalConnMonitorRef.IncAllowlistBlock(near.bridgeName)
+		log.Printf("NEAR: Bridge %s recieved request unallowed near IP: %s", near.bridgeName, conn.RemoteAddr())
+		return
+	}
+
+	if !near.Enabled() {
+		conn.Write(req.Reply(socks.RepGeneralFailure, ""))
+		log.Printf("SOCKS Redirector: Bridge %s is disabled, refusing %s:%d", bridgeName, host, port)
 		return
 	}
 
 	// 4. Open a streaming session to far
-	stream, err := (*bridgeRegistry)[bridgeName].currentBridge.NewNearConn(host, port)
+	opened := time.Now()
+	stream, err := near.openStream(host, port, req.AddrType, conn.RemoteAddr())
 
 	if err != nil {
-		conn.Write(socks.ReplyFail)
+		conn.Write(dialFailureReply(req, err))
 		log.Printf("NEAR: Bridge %s Failed to open stream to far: %v", dummyBridgeName, err)
 		return
 	}
@@ -58,11 +70,12 @@ func handleSocksRedirect(conn net.Conn, socksConfig *config.SocksRedirectConfig,
 	}()
 
 	// 5. Reply: success
-	conn.Write(socks.ReplySuccess)
+	conn.Write(req.Reply(socks.RepSucceeded, ""))
 
-	relayConnData(conn, stream)
+	stats := relayConnData(conn, stream, near.config.StreamIdleTimeout.Duration(), near.relayBufs)
+	near.logAccess(conn.RemoteAddr(), net.JoinHostPort(host, strconv.Itoa(port)), opened, stats)
 }
-func runSocksRedirector(socksConfig *config.SocksRedirectConfig, bridgeRegistry *map[string]*SalmonNear) error {
+func runSocksRedirector(socksConfig *config.SocksRedirectConfig, bridgeRegistry *nearRegistry) error {
 	listenAddr := socksConfig.Hostname + ":" + strconv.Itoa(socksConfig.Port)
 	ln, err := net.Listen("tcp", listenAddr)
 	if err != nil {
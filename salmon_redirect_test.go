@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"salmoncannon/config"
+	"salmoncannon/socks"
+	"salmoncannon/status"
+)
+
+func TestSocksRedirect_ReleasesStreamOnClientClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	near := startNearFar(t, "redirect-release", 55175, config.SalmonBridgeConfig{})
+	registry := newNearRegistry()
+	registry.set(near.bridgeName, near)
+	redirects := &config.SocksRedirectConfig{Redirects: map[string]string{"127.0.0.1": near.bridgeName}}
+
+	// One echo round trip through the redirector, then the client goes away
+	redirect := func() {
+		t.Helper()
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			handleSocksRedirect(server, redirects, registry)
+			close(done)
+		}()
+
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+		client.Write([]byte{0x05, 0x01, 0x00})
+		method := make([]byte, 2)
+		if _, err := io.ReadFull(client, method); err != nil {
+			t.Fatalf("failed to read method reply: %v", err)
+		}
+		client.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, byte(port >> 8), byte(port)})
+		reply := make([]byte, 10)
+		if _, err := io.ReadFull(client, reply); err != nil || reply[1] != socks.RepSucceeded {
+			t.Fatalf("expected redirect to connect, got reply %v: %v", reply, err)
+		}
+		client.Write([]byte("hello"))
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "hello" {
+			t.Fatalf("unexpected relayed data %q: %v", buf, err)
+		}
+		client.Close()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("redirect handler still running after the client closed")
+		}
+	}
+
+	// The first redirect dials the far, whose connection outlives it
+	redirect()
+	time.Sleep(100 * time.Millisecond)
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 5; i++ {
+		redirect()
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for (runtime.NumGoroutine() > before || status.GlobalConnMonitorRef.GetStreamCount(near.bridgeName) != 0) &&
+		time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := status.GlobalConnMonitorRef.GetStreamCount(near.bridgeName); n != 0 {
+		t.Fatalf("expected every far stream to be released, %d still open", n)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("goroutines leaked after redirects closed: before %d, after %d", before, after)
+	}
+	if got := status.GlobalConnMonitorRef.Ingress(near.bridgeName); got.ActiveRedirect != 0 || got.TotalRedirect != 6 {
+		t.Fatalf("expected 6 finished redirects, got %+v", got)
+	}
+}
+
+// socksRedirectReply sends a SOCKS5 CONNECT to 127.0.0.1:port through the
+// redirector and returns the reply code.
+func socksRedirectReply(t *testing.T, redirects *config.SocksRedirectConfig, registry *nearRegistry, port int) byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	go handleSocksRedirect(server, redirects, registry)
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.Write([]byte{0x05, 0x01, 0x00})
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(client, method); err != nil {
+		t.Fatalf("failed to read method reply: %v", err)
+	}
+	client.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, byte(port >> 8), byte(port)})
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("failed to read redirect reply: %v", err)
+	}
+	return reply[1]
+}
+
+func TestSocksRedirect_DefaultRoute(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	near := startNearFar(t, "redirect-default", 55176, config.SalmonBridgeConfig{})
+	registry := newNearRegistry()
+	registry.set(near.bridgeName, near)
+
+	cases := []struct {
+		name      string
+		redirects map[string]string
+		want      byte
+	}{
+		{"specific", map[string]string{"127.0.0.1": near.bridgeName, config.RedirectDefault: "missing"}, socks.RepSucceeded},
+		{"default", map[string]string{"example.com": "missing", config.RedirectDefault: near.bridgeName}, socks.RepSucceeded},
+		{"no default", map[string]string{"example.com": near.bridgeName}, socks.RepGeneralFailure},
+	}
+	for _, tc := range cases {
+		redirects := &config.SocksRedirectConfig{Redirects: tc.redirects}
+		if got := socksRedirectReply(t, redirects, registry, port); got != tc.want {
+			t.Errorf("%s: expected reply 0x%02x, got 0x%02x", tc.name, tc.want, got)
+		}
+	}
+}
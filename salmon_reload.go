@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"salmoncannon/api"
+	"salmoncannon/bridge"
+	"salmoncannon/config"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// nearRegistry maps bridge names to running near bridges so the SOCKS
+// redirector can find them while bridges come and go on reload.
+type nearRegistry struct {
+	mu    sync.RWMutex
+	nears map[string]*SalmonNear
+}
+
+func newNearRegistry() *nearRegistry {
+	return &nearRegistry{nears: make(map[string]*SalmonNear)}
+}
+
+func (r *nearRegistry) Get(name string) *SalmonNear {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.nears[name]
+}
+
+// SetBridgeEnabled pauses or resumes a near bridge. It returns false if no
+// near bridge with that name is running.
+func (r *nearRegistry) SetBridgeEnabled(name string, enabled bool) bool {
+	near := r.Get(name)
+	if near == nil {
+		return false
+	}
+	near.SetEnabled(enabled)
+	return true
+}
+
+func (r *nearRegistry) set(name string, near *SalmonNear) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nears[name] = near
+}
+
+func (r *nearRegistry) remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.nears, name)
+}
+
+// bridgeKey identifies a running bridge. A near and a far may share a name,
+// so the mode is part of the key.
+type bridgeKey struct {
+	name    string
+	connect bool
+}
+
+func keyOf(cfg *config.SalmonBridgeConfig) bridgeKey {
+	return bridgeKey{cfg.Name, cfg.Connect}
+}
+
+// runningBridge is one started bridge; exactly one of near or far is set.
+type runningBridge struct {
+	cfg  *config.SalmonBridgeConfig
+	near *SalmonNear
+	far  *SalmonFar
+}
+
+func (rb *runningBridge) close() {
+	if rb.near != nil {
+		rb.near.Close()
+	}
+	if rb.far != nil {
+		rb.far.Close()
+	}
+}
+
+// bridgeManager owns the running bridges and applies config reloads to them.
+// Only the SalmonBridges section is reloaded (QuicConfig reaches bridges via
+// their defaults); the API, SOCKS redirector and logging need a restart.
+type bridgeManager struct {
+	mu       sync.Mutex
+	bridges  map[bridgeKey]*runningBridge
+	registry *nearRegistry
+
+	reloading atomic.Bool // a Reload is running, see Reload
+
+	// onBridges is called with the new bridge list after each reload
+	onBridges func([]config.SalmonBridgeConfig)
+}
+
+func newBridgeManager(registry *nearRegistry) *bridgeManager {
+	return &bridgeManager{
+		bridges:  make(map[bridgeKey]*runningBridge),
+		registry: registry,
+	}
+}
+
+// checkUniqueBridges rejects two nears or two fars with the same name.
+func checkUniqueBridges(bridges []config.SalmonBridgeConfig) error {
+	seen := make(map[bridgeKey]bool, len(bridges))
+	for i := range bridges {
+		key := keyOf(&bridges[i])
+		if seen[key] {
+			return fmt.Errorf("duplicate bridge name %q", key.name)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// Start brings up every bridge in cfg. It fails on the first bridge that
+// cannot be started.
+func (m *bridgeManager) Start(bridges []config.SalmonBridgeConfig) error {
+	if err := checkUniqueBridges(bridges); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range bridges {
+		cfg := &bridges[i] // Avoid closure capture bug
+		// Not the whole config, which holds SBSharedSecret and SBSocksUsers
+		log.Printf("Setting up salmon bridge %s: %s", cfg.Name, bridgeSummary(*cfg))
+		if err := m.startLocked(cfg, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startLocked starts a single bridge. When fatal is set a far listener that
+// dies later takes the process down, matching startup behaviour; on reload
+// it is only logged. The caller must hold mu.
+func (m *bridgeManager) startLocked(cfg *config.SalmonBridgeConfig, fatal bool) error {
+	if cfg.Connect {
+		log.Printf("NEAR: Starting bridge %s in Near mode...", cfg.Name)
+		near, err := NewSalmonNear(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to setup SalmonNear %s: %w", cfg.Name, err)
+		}
+		if cfg.HttpListenPort > 0 {
+			log.Printf("NEAR: HTTP proxy enabled on port %d", cfg.HttpListenPort)
+		}
+		if err := initHTTPNear(cfg, near); err != nil {
+			near.Close()
+			return fmt.Errorf("bridge %s: %w", cfg.Name, err)
+		}
+		if err := initNear(cfg, near); err != nil {
+			near.Close()
+			return fmt.Errorf("bridge %s: %w", cfg.Name, err)
+		}
+		m.bridges[keyOf(cfg)] = &runningBridge{cfg: cfg, near: near}
+		m.registry.set(cfg.Name, near)
+		return nil
+	}
+
+	log.Printf("FAR: Starting bridge %s in Far mode...", cfg.Name)
+	far, err := NewSalmonFar(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to setup SalmonFar %s: %w", cfg.Name, err)
+	}
+	go func() {
+		if err := far.farBridge.NewFarListen(); err != nil {
+			if fatal {
+				log.Fatalf("FAR: Failed to start SalmonFar: %v", err)
+			}
+			log.Printf("FAR: Bridge %s listener stopped: %v", cfg.Name, err)
+		}
+	}()
+	m.bridges[keyOf(cfg)] = &runningBridge{cfg: cfg, far: far}
+	return nil
+}
+
+// stopLocked tears down a running bridge. The caller must hold mu.
+func (m *bridgeManager) stopLocked(key bridgeKey) {
+	rb, ok := m.bridges[key]
+	if !ok {
+		return
+	}
+	if rb.near != nil {
+		m.registry.remove(key.name)
+	}
+	rb.close()
+	delete(m.bridges, key)
+}
+
+// mutableFieldsCleared returns a copy of cfg with the fields that can be
+// changed on a live bridge zeroed, so the remainder can be compared.
+func mutableFieldsCleared(cfg config.SalmonBridgeConfig) config.SalmonBridgeConfig {
+	cfg.TotalBandwidthLimit = 0
+	cfg.AllowedInAddresses = nil
+	cfg.AllowedOutAddresses = nil
+	cfg.AllowedInFilter = nil
+	cfg.AllowedOutFilter = nil
+	cfg.MaxConnectionsPerBridge = 0
+	return cfg
+}
+
+// needsRecreate reports whether moving from old to new touches anything that
+// cannot be updated in place (ports, addresses, secrets, mode, ...).
+func needsRecreate(old, new *config.SalmonBridgeConfig) bool {
+	return !reflect.DeepEqual(mutableFieldsCleared(*old), mutableFieldsCleared(*new))
+}
+
+// updateLocked applies the in-place fields of cfg to a running bridge and
+// reports whether anything changed. The caller must hold mu.
+func (m *bridgeManager) updateLocked(rb *runningBridge, cfg *config.SalmonBridgeConfig) bool {
+	changed := false
+	var sb *bridge.SalmonBridge
+	if rb.near != nil {
+		sb = rb.near.currentBridge
+	} else {
+		sb = rb.far.farBridge
+	}
+	if rb.cfg.TotalBandwidthLimit != cfg.TotalBandwidthLimit {
+		sb.Limiter().SetRate(int64(cfg.TotalBandwidthLimit))
+		log.Printf("Bridge %s bandwidth limit %d -> %d bytes/s", cfg.Name, rb.cfg.TotalBandwidthLimit, cfg.TotalBandwidthLimit)
+		changed = true
+	}
+	if rb.cfg.MaxConnectionsPerBridge != cfg.MaxConnectionsPerBridge {
+		// A lower cap drains the excess connections instead of cutting them
+		sb.Quic().SetPoolLimits(cfg.MaxConnectionsPerBridge, 0, 0)
+		log.Printf("Bridge %s max connections %d -> %d", cfg.Name, rb.cfg.MaxConnectionsPerBridge, cfg.MaxConnectionsPerBridge)
+		changed = true
+	}
+	if !slices.Equal(rb.cfg.AllowedInAddresses, cfg.AllowedInAddresses) {
+		if rb.near != nil {
+			rb.near.SetAllowedIn(cfg.AllowedInFilter)
+		}
+		log.Printf("Bridge %s allowed in addresses now %v", cfg.Name, cfg.AllowedInAddresses)
+		changed = true
+	}
+	if !slices.Equal(rb.cfg.AllowedOutAddresses, cfg.AllowedOutAddresses) {
+		sb.SetAllowedOut(cfg.AllowedOutFilter)
+		log.Printf("Bridge %s allowed out addresses now %v", cfg.Name, cfg.AllowedOutAddresses)
+		changed = true
+	}
+	rb.cfg = cfg
+	return changed
+}
+
+// Apply moves the running bridges to match bridges. Removed bridges are torn
+// down, bridges whose fixed settings changed are recreated, new bridges are
+// started and everything else is updated in place without dropping streams.
+// The summary lists the bridges it touched, including ones that failed.
+func (m *bridgeManager) Apply(bridges []config.SalmonBridgeConfig) (api.ReloadSummary, error) {
+	summary := api.ReloadSummary{Added: []string{}, Removed: []string{}, Recreated: []string{}, Updated: []string{}}
+	if err := checkUniqueBridges(bridges); err != nil {
+		return summary, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := make(map[bridgeKey]*config.SalmonBridgeConfig, len(bridges))
+	for i := range bridges {
+		wanted[keyOf(&bridges[i])] = &bridges[i]
+	}
+
+	// Tear down first so recreated and new bridges can reuse freed ports
+	var recreate []*config.SalmonBridgeConfig
+	for key, rb := range m.bridges {
+		cfg, ok := wanted[key]
+		if !ok {
+			m.stopLocked(key)
+			log.Printf("Reload: removed bridge %s", key.name)
+			summary.Removed = append(summary.Removed, key.name)
+			continue
+		}
+		if needsRecreate(rb.cfg, cfg) {
+			m.stopLocked(key)
+			recreate = append(recreate, cfg)
+		}
+	}
+
+	var errs []error
+	for _, cfg := range recreate {
+		summary.Recreated = append(summary.Recreated, cfg.Name)
+		if err := m.startLocked(cfg, false); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("Reload: recreated bridge %s", cfg.Name)
+	}
+
+	for i := range bridges {
+		cfg := &bridges[i]
+		rb, ok := m.bridges[keyOf(cfg)]
+		if ok {
+			if rb.cfg != cfg && m.updateLocked(rb, cfg) {
+				log.Printf("Reload: updated bridge %s", cfg.Name)
+				summary.Updated = append(summary.Updated, cfg.Name)
+			}
+			continue
+		}
+		if slices.Contains(recreate, cfg) {
+			continue // already attempted above
+		}
+		summary.Added = append(summary.Added, cfg.Name)
+		if err := m.startLocked(cfg, false); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("Reload: added bridge %s", cfg.Name)
+	}
+
+	if m.onBridges != nil {
+		m.onBridges(bridges)
+	}
+	if len(errs) > 0 {
+		return summary, fmt.Errorf("reload finished with errors: %v", errs)
+	}
+	return summary, nil
+}
+
+// Shutdown drains every running bridge in parallel, giving each its
+// SBShutdownGracePeriod, and logs any streams that had to be cut off.
+func (m *bridgeManager) Shutdown(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for key, rb := range m.bridges {
+		wg.Add(1)
+		go func(name string, rb *runningBridge) {
+			defer wg.Done()
+			grace := rb.cfg.ShutdownGracePeriod.Duration()
+			bctx, cancel := context.WithTimeout(ctx, grace)
+			defer cancel()
+
+			var remaining int32
+			if rb.near != nil {
+				m.registry.remove(name)
+				remaining = rb.near.Shutdown(bctx)
+			} else {
+				remaining = rb.far.Shutdown(bctx)
+			}
+			if remaining > 0 {
+				log.Printf("Shutdown: bridge %s force closed with %d active stream(s) after %v", name, remaining, grace)
+			} else {
+				log.Printf("Shutdown: bridge %s drained", name)
+			}
+		}(key.name, rb)
+	}
+	wg.Wait()
+	clear(m.bridges)
+}
+
+// Reload re-reads the config file or directory at path and applies its
+// bridges. A config that fails to load leaves the running bridges
+// untouched. SIGHUP and the API's /api/v1/reload both come through here,
+// and while one reload runs another fails with api.ErrReloadInProgress.
+func (m *bridgeManager) Reload(path string) (api.ReloadSummary, error) {
+	if !m.reloading.CompareAndSwap(false, true) {
+		return api.ReloadSummary{}, api.ErrReloadInProgress
+	}
+	defer m.reloading.Store(false)
+	cfg, err := config.LoadConfigPath(path)
+	if err != nil {
+		return api.ReloadSummary{}, fmt.Errorf("failed to load config: %w", err)
+	}
+	return m.Apply(cfg.Bridges)
+}
+
+// configReloader reloads path for the API's /api/v1/reload.
+type configReloader struct {
+	m    *bridgeManager
+	path string
+}
+
+func (r configReloader) Reload() (api.ReloadSummary, error) {
+	return r.m.Reload(r.path)
+}
+
+// watchReload reloads path every time a signal arrives on sigs.
+func (m *bridgeManager) watchReload(path string, sigs <-chan os.Signal) {
+	for sig := range sigs {
+		log.Printf("Reload: received %v, reloading %s", sig, path)
+		if _, err := m.Reload(path); err != nil {
+			log.Printf("Reload: %v", err)
+			continue
+		}
+		log.Printf("Reload: done")
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"salmoncannon/api"
+	"salmoncannon/config"
+)
+
+func writeReloadConfig(t *testing.T, path string, yamlData string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(yamlData), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+}
+
+func TestNeedsRecreate(t *testing.T) {
+	old := &config.SalmonBridgeConfig{
+		Name:                "b",
+		NearPort:            55100,
+		TotalBandwidthLimit: 1024,
+		AllowedOutAddresses: []string{"10.0.0.0/8"},
+	}
+
+	same := *old
+	same.TotalBandwidthLimit = 4096
+	same.AllowedOutAddresses = []string{"example.com"}
+	same.MaxConnectionsPerBridge = 2
+	if needsRecreate(old, &same) {
+		t.Errorf("bandwidth/allowed address/connection cap changes should be applied in place")
+	}
+
+	moved := *old
+	moved.NearPort = 55101
+	if !needsRecreate(old, &moved) {
+		t.Errorf("port change should recreate the bridge")
+	}
+
+	rekeyed := *old
+	rekeyed.SharedSecret = "new-secret"
+	if !needsRecreate(old, &rekeyed) {
+		t.Errorf("shared secret change should recreate the bridge")
+	}
+}
+
+func TestBridgeManager_ReloadAddUpdateRecreateRemove(t *testing.T) {
+	path := t.TempDir() + "/scconfig.yml"
+	writeReloadConfig(t, path, `
+SalmonBridges:
+  - SBName: "reload-far"
+    SBConnect: false
+    SBNearPort: 55110
+    SBTotalBandwidthLimit: "1MB"
+`)
+
+	m := newBridgeManager(newNearRegistry())
+	if _, err := m.Reload(path); err != nil {
+		t.Fatalf("initial reload failed: %v", err)
+	}
+	rb := m.bridges[bridgeKey{"reload-far", false}]
+	if rb == nil || rb.far == nil {
+		t.Fatalf("expected far bridge to be running")
+	}
+
+	// In place: limiter and filter change, bridge object survives
+	writeReloadConfig(t, path, `
+SalmonBridges:
+  - SBName: "reload-far"
+    SBConnect: false
+    SBNearPort: 55110
+    SBTotalBandwidthLimit: "2MB"
+    SBAllowedOutAddresses:
+      - "10.0.0.0/8"
+`)
+	if _, err := m.Reload(path); err != nil {
+		t.Fatalf("update reload failed: %v", err)
+	}
+	updated := m.bridges[bridgeKey{"reload-far", false}]
+	if updated != rb {
+		t.Fatalf("expected bridge to be updated in place, not recreated")
+	}
+	if got := updated.far.farBridge.Limiter().GetMaxRate(); got != 2*1024*1024 {
+		t.Errorf("expected limiter rate 2MB, got %d", got)
+	}
+
+	// Port change recreates; a second bridge is added
+	writeReloadConfig(t, path, `
+SalmonBridges:
+  - SBName: "reload-far"
+    SBConnect: false
+    SBNearPort: 55111
+  - SBName: "reload-far-2"
+    SBConnect: false
+    SBNearPort: 55112
+`)
+	if _, err := m.Reload(path); err != nil {
+		t.Fatalf("recreate reload failed: %v", err)
+	}
+	if m.bridges[bridgeKey{"reload-far", false}] == rb {
+		t.Errorf("expected bridge to be recreated after port change")
+	}
+	if m.bridges[bridgeKey{"reload-far", false}].cfg.NearPort != 55111 {
+		t.Errorf("expected recreated bridge on port 55111")
+	}
+	if m.bridges[bridgeKey{"reload-far-2", false}] == nil {
+		t.Errorf("expected reload-far-2 to be added")
+	}
+
+	// Removal
+	writeReloadConfig(t, path, `
+SalmonBridges:
+  - SBName: "reload-far-2"
+    SBConnect: false
+    SBNearPort: 55112
+`)
+	if _, err := m.Reload(path); err != nil {
+		t.Fatalf("remove reload failed: %v", err)
+	}
+	if _, ok := m.bridges[bridgeKey{"reload-far", false}]; ok {
+		t.Errorf("expected reload-far to be removed")
+	}
+	if len(m.bridges) != 1 {
+		t.Errorf("expected 1 running bridge, got %d", len(m.bridges))
+	}
+
+	m.Apply(nil)
+}
+
+func TestBridgeManager_NearRemovedFromRegistry(t *testing.T) {
+	path := t.TempDir() + "/scconfig.yml"
+	writeReloadConfig(t, path, `
+SalmonBridges:
+  - SBName: "reload-near"
+    SBConnect: true
+    SBSocksListenAddress: "127.0.0.1"
+    SBSocksListenPort: 55120
+    SBFarIp: "127.0.0.1"
+    SBFarPort: 55121
+`)
+
+	registry := newNearRegistry()
+	m := newBridgeManager(registry)
+	if _, err := m.Reload(path); err != nil {
+		t.Fatalf("initial reload failed: %v", err)
+	}
+	if registry.Get("reload-near") == nil {
+		t.Fatalf("expected near to be registered")
+	}
+
+	writeReloadConfig(t, path, "SalmonBridges: []\n")
+	if _, err := m.Reload(path); err != nil {
+		t.Fatalf("remove reload failed: %v", err)
+	}
+	if registry.Get("reload-near") != nil {
+		t.Errorf("expected near to be unregistered")
+	}
+
+	// The SOCKS port must be released so it can be reused
+	time.Sleep(50 * time.Millisecond)
+	ln, err := net.Listen("tcp", "127.0.0.1:55120")
+	if err != nil {
+		t.Fatalf("expected SOCKS port to be free after removal: %v", err)
+	}
+	ln.Close()
+}
+
+func TestBridgeManager_BadConfigKeepsBridges(t *testing.T) {
+	path := t.TempDir() + "/scconfig.yml"
+	writeReloadConfig(t, path, `
+SalmonBridges:
+  - SBName: "reload-keep"
+    SBConnect: false
+    SBNearPort: 55130
+`)
+	m := newBridgeManager(newNearRegistry())
+	if _, err := m.Reload(path); err != nil {
+		t.Fatalf("initial reload failed: %v", err)
+	}
+	defer m.Apply(nil)
+
+	writeReloadConfig(t, path, "SalmonBridges: [ this is not yaml")
+	if _, err := m.Reload(path); err == nil {
+		t.Fatalf("expected reload of invalid config to fail")
+	}
+	if m.bridges[bridgeKey{"reload-keep", false}] == nil {
+		t.Errorf("expected running bridge to survive a bad reload")
+	}
+
+	writeReloadConfig(t, path, `
+SalmonBridges:
+  - SBName: "dup"
+    SBConnect: false
+    SBNearPort: 55131
+  - SBName: "dup"
+    SBConnect: false
+    SBNearPort: 55132
+`)
+	if _, err := m.Reload(path); err == nil {
+		t.Fatalf("expected duplicate bridge names to be rejected")
+	}
+	if m.bridges[bridgeKey{"reload-keep", false}] == nil {
+		t.Errorf("expected running bridge to survive a rejected reload")
+	}
+}
+
+func TestBridgeManager_Shutdown(t *testing.T) {
+	path := t.TempDir() + "/scconfig.yml"
+	writeReloadConfig(t, path, `
+SalmonBridges:
+  - SBName: "shutdown-far"
+    SBConnect: false
+    SBNearPort: 55150
+    SBShutdownGracePeriod: "1s"
+  - SBName: "shutdown-near"
+    SBConnect: true
+    SBSocksListenAddress: "127.0.0.1"
+    SBSocksListenPort: 55151
+    SBFarIp: "127.0.0.1"
+    SBFarPort: 55150
+    SBShutdownGracePeriod: "1s"
+`)
+	registry := newNearRegistry()
+	m := newBridgeManager(registry)
+	if _, err := m.Reload(path); err != nil {
+		t.Fatalf("initial reload failed: %v", err)
+	}
+
+	start := time.Now()
+	m.Shutdown(context.Background())
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("expected idle bridges to shut down quickly, took %v", time.Since(start))
+	}
+	if len(m.bridges) != 0 || registry.Get("shutdown-near") != nil {
+		t.Fatalf("expected all bridges to be removed after shutdown")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	ln, err := net.Listen("tcp", "127.0.0.1:55151")
+	if err != nil {
+		t.Fatalf("expected SOCKS port to be free after shutdown: %v", err)
+	}
+	ln.Close()
+}
+
+func TestBridgeManager_NearAndFarShareName(t *testing.T) {
+	path := t.TempDir() + "/scconfig.yml"
+	writeReloadConfig(t, path, `
+SalmonBridges:
+  - SBName: "same"
+    SBConnect: false
+    SBNearPort: 55183
+  - SBName: "same"
+    SBConnect: true
+    SBSocksListenAddress: "127.0.0.1"
+    SBSocksListenPort: 55182
+    SBFarIp: "127.0.0.1"
+    SBFarPort: 55183
+`)
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("expected a near and a far with the same name to be valid: %v", err)
+	}
+
+	registry := newNearRegistry()
+	m := newBridgeManager(registry)
+	if err := m.Start(cfg.Bridges); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Apply(nil)
+	far, near := m.bridges[bridgeKey{"same", false}], m.bridges[bridgeKey{"same", true}]
+	if far == nil || far.far == nil || near == nil || near.near == nil {
+		t.Fatalf("expected both the near and the far to run, got %d bridges", len(m.bridges))
+	}
+
+	// Reloading the same config leaves both alone
+	if _, err := m.Reload(path); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if m.bridges[bridgeKey{"same", false}] != far || m.bridges[bridgeKey{"same", true}] != near {
+		t.Fatalf("expected an unchanged reload to keep both bridges")
+	}
+
+	// Removing the far leaves the near running and registered
+	writeReloadConfig(t, path, `
+SalmonBridges:
+  - SBName: "same"
+    SBConnect: true
+    SBSocksListenAddress: "127.0.0.1"
+    SBSocksListenPort: 55182
+    SBFarIp: "127.0.0.1"
+    SBFarPort: 55183
+`)
+	if _, err := m.Reload(path); err != nil {
+		t.Fatalf("remove reload failed: %v", err)
+	}
+	if _, ok := m.bridges[bridgeKey{"same", false}]; ok {
+		t.Errorf("expected the far to be removed")
+	}
+	if m.bridges[bridgeKey{"same", true}] != near || registry.Get("same") != near.near {
+		t.Errorf("expected the near to keep running")
+	}
+}
+
+// lockedBuffer collects log output written from several goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestBridgeManager_StartLogsNoSecrets(t *testing.T) {
+	var logs lockedBuffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	m := newBridgeManager(newNearRegistry())
+	err := m.Start([]config.SalmonBridgeConfig{
+		{Name: "log-far", NearPort: 55184, FarPort: 55184, SharedSecret: "very-secret",
+			SocksUsers: map[string]string{"alice": "plain-pass"}},
+	})
+	if err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	defer m.Apply(nil)
+
+	got := logs.String()
+	if !strings.Contains(got, "Setting up salmon bridge log-far: far") {
+		t.Errorf("expected the bridge to be logged, got:\n%s", got)
+	}
+	if strings.Contains(got, "very-secret") || strings.Contains(got, "plain-pass") {
+		t.Errorf("expected no secrets in the log, got:\n%s", got)
+	}
+}
+
+func TestReloadEndpoint(t *testing.T) {
+	path := t.TempDir() + "/scconfig.yml"
+	writeReloadConfig(t, path, `
+SalmonBridges:
+  - SBName: "api-reload"
+    SBConnect: false
+    SBNearPort: 55186
+`)
+	m := newBridgeManager(newNearRegistry())
+	defer m.Apply(nil)
+
+	cfg := &config.SalmonCannonConfig{ApiConfig: &config.ApiConfig{AuthToken: "reload-token"}}
+	srv := api.NewServer(cfg, "127.0.0.1:55185", nil)
+	srv.SetReloader(configReloader{m: m, path: path})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("failed to start API: %v", err)
+	}
+	defer srv.Stop()
+
+	post := func() (int, map[string]any) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:55185/api/v1/reload", nil)
+		req.Header.Set("Authorization", "Bearer reload-token")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("reload request failed: %v", err)
+		}
+		defer res.Body.Close()
+		var body map[string]any
+		json.NewDecoder(res.Body).Decode(&body)
+		return res.StatusCode, body
+	}
+
+	code, body := post()
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %v", code, body)
+	}
+	if added, _ := body["added"].([]any); len(added) != 1 || added[0] != "api-reload" {
+		t.Fatalf("expected api-reload to be added, got %v", body)
+	}
+	rb := m.bridges[bridgeKey{"api-reload", false}]
+	if rb == nil {
+		t.Fatalf("expected the bridge to be started")
+	}
+
+	// An invalid config is reported and the bridges stay up
+	writeReloadConfig(t, path, "SalmonBridges: [ this is not yaml")
+	code, body = post()
+	if code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 for an invalid config, got %d: %v", code, body)
+	}
+	if msg, _ := body["error"].(string); !strings.Contains(msg, "failed to load config") {
+		t.Fatalf("expected the load error in the response, got %v", body)
+	}
+	if m.bridges[bridgeKey{"api-reload", false}] != rb {
+		t.Fatalf("expected the running bridge to survive an invalid config")
+	}
+
+	// One reload at a time, whether from SIGHUP or the API
+	m.reloading.Store(true)
+	code, body = post()
+	m.reloading.Store(false)
+	if code != http.StatusConflict {
+		t.Fatalf("expected 409 while a reload runs, got %d: %v", code, body)
+	}
+	if _, err := m.Reload(path); errors.Is(err, api.ErrReloadInProgress) {
+		t.Fatalf("expected the next reload to run once the first finished")
+	}
+}
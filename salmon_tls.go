@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"salmoncannon/config"
+	"salmoncannon/utils"
+)
+
+// bridgeAlpn is the ALPN protocol the bridge's QUIC tunnel negotiates.
+func bridgeAlpn(cfg *config.SalmonBridgeConfig) string {
+	if cfg.Alpn == "" {
+		return config.DefaultAlpn
+	}
+	return cfg.Alpn
+}
+
+// farTLSConfig loads the far certificate from SBFarCertFile/SBFarKeyFile,
+// falling back to a fresh self-signed certificate when they are not set.
+func farTLSConfig(cfg *config.SalmonBridgeConfig) (*tls.Config, error) {
+	var cert tls.Certificate
+	if cfg.FarCertFile != "" || cfg.FarKeyFile != "" {
+		if cfg.FarCertFile == "" || cfg.FarKeyFile == "" {
+			return nil, fmt.Errorf("bridge %s: SBFarCertFile and SBFarKeyFile must be set together", cfg.Name)
+		}
+		var err error
+		cert, err = tls.LoadX509KeyPair(cfg.FarCertFile, cfg.FarKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("bridge %s: load far certificate: %w", cfg.Name, err)
+		}
+	} else {
+		log.Printf("FAR: WARNING bridge %s has no SBFarCertFile, using a self-signed certificate that nears cannot pin", cfg.Name)
+		cert = utils.GenerateSelfSignedCert()
+	}
+	log.Printf("FAR: Bridge %s certificate SHA-256 fingerprint %s", cfg.Name, utils.CertFingerprint(cert.Certificate[0]))
+
+	tlscfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{bridgeAlpn(cfg)},
+	}
+	if cfg.Enable0RTT {
+		// Random keys by default, which would void every near's tickets on
+		// restart
+		key, err := sessionTicketKey(cert)
+		if err != nil {
+			return nil, fmt.Errorf("bridge %s: session ticket key: %w", cfg.Name, err)
+		}
+		tlscfg.SetSessionTicketKeys([][32]byte{key})
+	}
+	return tlscfg, nil
+}
+
+// sessionTicketKey derives the far's TLS session ticket key from its private
+// key, so it is secret and the same on every start with that key.
+func sessionTicketKey(cert tls.Certificate) ([32]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	mac := hmac.New(sha256.New, der)
+	mac.Write([]byte("salmon-cannon session ticket key"))
+	var key [32]byte
+	copy(key[:], mac.Sum(nil))
+	return key, nil
+}
+
+// nearTLSConfig verifies the far certificate against the SBTlsCaFile bundle
+// and name, and pins it to SBFarCertFingerprint; either, both or neither may
+// be set. Without either any far certificate is accepted.
+func nearTLSConfig(cfg *config.SalmonBridgeConfig) (*tls.Config, error) {
+	tlscfg := &tls.Config{
+		NextProtos: []string{bridgeAlpn(cfg)},
+	}
+	if cfg.TlsServerName != "" && cfg.TlsCaFile == "" {
+		return nil, fmt.Errorf("bridge %s: SBTlsServerName needs SBTlsCaFile", cfg.Name)
+	}
+	if cfg.TlsCaFile != "" {
+		pemData, err := os.ReadFile(cfg.TlsCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("bridge %s: load CA file: %w", cfg.Name, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("bridge %s: no certificates found in SBTlsCaFile %s", cfg.Name, cfg.TlsCaFile)
+		}
+		tlscfg.RootCAs = pool
+		// Empty lets quic-go use the host being dialed
+		tlscfg.ServerName = cfg.TlsServerName
+	} else {
+		// No chain to verify against, the fingerprint pin (if any) replaces it
+		tlscfg.InsecureSkipVerify = true
+	}
+
+	if cfg.FarCertFingerprint == "" {
+		if cfg.TlsCaFile == "" {
+			log.Printf("NEAR: WARNING bridge %s has no SBTlsCaFile or SBFarCertFingerprint, the far side's identity is not verified", cfg.Name)
+		}
+		return tlscfg, nil
+	}
+	fp, err := utils.ParseCertFingerprint(cfg.FarCertFingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("bridge %s: %w", cfg.Name, err)
+	}
+	tlscfg.VerifyPeerCertificate = utils.PinnedCertVerifier(fp)
+	return tlscfg, nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"salmoncannon/config"
+	"salmoncannon/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCertFiles stores a fresh self-signed cert as PEM files and returns
+// their paths along with the cert's fingerprint.
+func writeCertFiles(t *testing.T) (string, string, string) {
+	t.Helper()
+	cert := utils.GenerateSelfSignedCert()
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "far.crt")
+	keyFile := filepath.Join(dir, "far.key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(cert.PrivateKey.(*rsa.PrivateKey))})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile, utils.CertFingerprint(cert.Certificate[0])
+}
+
+// writeCAFiles makes a CA and a far certificate it signs for dnsName. It
+// returns the CA bundle path and the far certificate and key paths.
+func writeCAFiles(t *testing.T, dnsName string) (string, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name string, block *pem.Block) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "salmon test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	farKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	farTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	farDER, err := x509.CreateCertificate(rand.Reader, farTmpl, caCert, &farKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create far cert: %v", err)
+	}
+	farKeyDER, _ := x509.MarshalECPrivateKey(farKey)
+
+	return write("ca.crt", &pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		write("far.crt", &pem.Block{Type: "CERTIFICATE", Bytes: farDER}),
+		write("far.key", &pem.Block{Type: "EC PRIVATE KEY", Bytes: farKeyDER})
+}
+
+// tlsHandshake runs a handshake between the far and near configs.
+func tlsHandshake(t *testing.T, farCfg, nearCfg *config.SalmonBridgeConfig) error {
+	t.Helper()
+	serverCfg, err := farTLSConfig(farCfg)
+	if err != nil {
+		t.Fatalf("farTLSConfig: %v", err)
+	}
+	clientCfg, err := nearTLSConfig(nearCfg)
+	if err != nil {
+		t.Fatalf("nearTLSConfig: %v", err)
+	}
+
+	// Loopback TCP rather than net.Pipe: its buffering lets the client send
+	// an alert while the server is still writing its handshake flight
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		s, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.SetDeadline(time.Now().Add(5 * time.Second))
+		srv := tls.Server(s, serverCfg)
+		srv.Handshake()
+		srv.Close()
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	return tls.Client(c, clientCfg).Handshake()
+}
+
+func TestTLS_PinnedFingerprintMatches(t *testing.T) {
+	certFile, keyFile, fp := writeCertFiles(t)
+	farCfg := &config.SalmonBridgeConfig{Name: "pin", FarCertFile: certFile, FarKeyFile: keyFile}
+	// Colon separated upper case, as printed by openssl
+	var parts []string
+	for i := 0; i < len(fp); i += 2 {
+		parts = append(parts, strings.ToUpper(fp[i:i+2]))
+	}
+	nearCfg := &config.SalmonBridgeConfig{Name: "pin", FarCertFingerprint: strings.Join(parts, ":")}
+
+	if err := tlsHandshake(t, farCfg, nearCfg); err != nil {
+		t.Fatalf("expected handshake to succeed with matching fingerprint: %v", err)
+	}
+}
+
+func TestTLS_PinnedFingerprintMismatch(t *testing.T) {
+	certFile, keyFile, _ := writeCertFiles(t)
+	_, _, otherFp := writeCertFiles(t)
+	farCfg := &config.SalmonBridgeConfig{Name: "pin", FarCertFile: certFile, FarKeyFile: keyFile}
+	nearCfg := &config.SalmonBridgeConfig{Name: "pin", FarCertFingerprint: otherFp}
+
+	err := tlsHandshake(t, farCfg, nearCfg)
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("expected fingerprint mismatch, got %v", err)
+	}
+}
+
+func TestTLS_NoFingerprintAcceptsAnyCert(t *testing.T) {
+	farCfg := &config.SalmonBridgeConfig{Name: "pin"}
+	nearCfg := &config.SalmonBridgeConfig{Name: "pin"}
+	if err := tlsHandshake(t, farCfg, nearCfg); err != nil {
+		t.Fatalf("expected unpinned handshake to succeed: %v", err)
+	}
+}
+
+func TestTLS_CAVerifiesFarCert(t *testing.T) {
+	caFile, certFile, keyFile := writeCAFiles(t, "far.example")
+	farCfg := &config.SalmonBridgeConfig{Name: "ca", FarCertFile: certFile, FarKeyFile: keyFile}
+	nearCfg := &config.SalmonBridgeConfig{Name: "ca", TlsCaFile: caFile, TlsServerName: "far.example"}
+
+	if err := tlsHandshake(t, farCfg, nearCfg); err != nil {
+		t.Fatalf("expected handshake to succeed with matching CA and name: %v", err)
+	}
+}
+
+func TestTLS_CAWrongServerName(t *testing.T) {
+	caFile, certFile, keyFile := writeCAFiles(t, "far.example")
+	farCfg := &config.SalmonBridgeConfig{Name: "ca", FarCertFile: certFile, FarKeyFile: keyFile}
+	nearCfg := &config.SalmonBridgeConfig{Name: "ca", TlsCaFile: caFile, TlsServerName: "other.example"}
+
+	var hostErr x509.HostnameError
+	if err := tlsHandshake(t, farCfg, nearCfg); !errors.As(err, &hostErr) {
+		t.Fatalf("expected hostname error, got %v", err)
+	}
+}
+
+func TestTLS_CAUntrustedCert(t *testing.T) {
+	caFile, _, _ := writeCAFiles(t, "far.example")
+	// Signed by a different CA
+	_, certFile, keyFile := writeCAFiles(t, "far.example")
+	farCfg := &config.SalmonBridgeConfig{Name: "ca", FarCertFile: certFile, FarKeyFile: keyFile}
+	nearCfg := &config.SalmonBridgeConfig{Name: "ca", TlsCaFile: caFile, TlsServerName: "far.example"}
+
+	var authErr x509.UnknownAuthorityError
+	if err := tlsHandshake(t, farCfg, nearCfg); !errors.As(err, &authErr) {
+		t.Fatalf("expected unknown authority error, got %v", err)
+	}
+
+	// A self-signed far is untrusted too
+	farCfg = &config.SalmonBridgeConfig{Name: "ca"}
+	if err := tlsHandshake(t, farCfg, nearCfg); err == nil {
+		t.Fatal("expected self-signed far certificate to be rejected")
+	}
+}
+
+func TestTLS_ConfigErrors(t *testing.T) {
+	if _, err := farTLSConfig(&config.SalmonBridgeConfig{Name: "x", FarCertFile: "a.crt"}); err == nil {
+		t.Error("expected error when SBFarKeyFile is missing")
+	}
+	if _, err := farTLSConfig(&config.SalmonBridgeConfig{Name: "x", FarCertFile: "/nonexistent.crt", FarKeyFile: "/nonexistent.key"}); err == nil {
+		t.Error("expected error for missing certificate files")
+	}
+	if _, err := nearTLSConfig(&config.SalmonBridgeConfig{Name: "x", FarCertFingerprint: "abcd"}); err == nil {
+		t.Error("expected error for short fingerprint")
+	}
+	if _, err := nearTLSConfig(&config.SalmonBridgeConfig{Name: "x", FarCertFingerprint: strings.Repeat("zz", 32)}); err == nil {
+		t.Error("expected error for non-hex fingerprint")
+	}
+	if _, err := nearTLSConfig(&config.SalmonBridgeConfig{Name: "x", TlsServerName: "far.example"}); err == nil {
+		t.Error("expected error for SBTlsServerName without SBTlsCaFile")
+	}
+	if _, err := nearTLSConfig(&config.SalmonBridgeConfig{Name: "x", TlsCaFile: "/nonexistent.crt"}); err == nil {
+		t.Error("expected error for missing CA file")
+	}
+	_, keyFile, _ := writeCertFiles(t)
+	if _, err := nearTLSConfig(&config.SalmonBridgeConfig{Name: "x", TlsCaFile: keyFile}); err == nil {
+		t.Error("expected error for CA file without certificates")
+	}
+}
+
+func TestTLS_Alpn(t *testing.T) {
+	// Names no longer matter, only the ALPN does
+	farCfg := &config.SalmonBridgeConfig{Name: "far-name"}
+	nearCfg := &config.SalmonBridgeConfig{Name: "near-name"}
+	if err := tlsHandshake(t, farCfg, nearCfg); err != nil {
+		t.Fatalf("expected the default ALPN to match: %v", err)
+	}
+
+	farCfg.Alpn, nearCfg.Alpn = "custom", "custom"
+	if err := tlsHandshake(t, farCfg, nearCfg); err != nil {
+		t.Fatalf("expected matching ALPNs to succeed: %v", err)
+	}
+
+	nearCfg.Alpn = "other"
+	if err := tlsHandshake(t, farCfg, nearCfg); err == nil || !strings.Contains(err.Error(), "no application protocol") {
+		t.Fatalf("expected mismatched ALPNs to fail the handshake, got %v", err)
+	}
+}
+
+// tlsResumes does two TLS handshakes with one client session cache, the
+// first against first and the second against second, and reports whether
+// the second resumed the session of the first.
+func tlsResumes(t *testing.T, first, second *tls.Config) bool {
+	t.Helper()
+	clientCfg := &tls.Config{InsecureSkipVerify: true, ClientSessionCache: tls.NewLRUClientSessionCache(1)}
+	var resumed bool
+	for _, serverCfg := range []*tls.Config{first, second} {
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		go func() {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			c.Write([]byte("x"))
+		}()
+		c, err := tls.Dial("tcp", ln.Addr().String(), clientCfg)
+		if err != nil {
+			ln.Close()
+			t.Fatalf("dial: %v", err)
+		}
+		// The ticket arrives ahead of the data
+		c.Read(make([]byte, 1))
+		resumed = c.ConnectionState().DidResume
+		c.Close()
+		ln.Close()
+	}
+	return resumed
+}
+
+func TestTLS_SessionTicketsSurviveFarRestart(t *testing.T) {
+	certFile, keyFile, _ := writeCertFiles(t)
+	cfg := &config.SalmonBridgeConfig{Name: "0rtt", FarCertFile: certFile, FarKeyFile: keyFile, Enable0RTT: true}
+	first, err := farTLSConfig(cfg)
+	if err != nil {
+		t.Fatalf("far TLS config: %v", err)
+	}
+	// A restart builds the config again from the same key
+	second, err := farTLSConfig(cfg)
+	if err != nil {
+		t.Fatalf("far TLS config: %v", err)
+	}
+	if !tlsResumes(t, first, second) {
+		t.Fatalf("expected a ticket from before the restart to resume the session")
+	}
+
+	// Without SBEnable0RTT each start has keys of its own
+	cfg.Enable0RTT = false
+	first, _ = farTLSConfig(cfg)
+	second, _ = farTLSConfig(cfg)
+	if tlsResumes(t, first, second) {
+		t.Fatalf("expected random ticket keys without SBEnable0RTT")
+	}
+}
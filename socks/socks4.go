@@ -0,0 +1,102 @@
+package socks
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socksVersion4       = 0x04
+	socks4ReplyVersion  = 0x00
+	socks4ReplyGranted  = 0x5a
+	socks4ReplyRejected = 0x5b
+	socks4MaxField      = 255 // longest USERID or hostname we accept
+)
+
+// handleSocks4Request reads the rest of a SOCKS4 or SOCKS4a request once the
+// version and command bytes have been read.
+func handleSocks4Request(conn net.Conn, bridgeName string, cmd byte, auth Authenticator, deadline time.Time) (*Request, error) {
+	// DSTPORT + DSTIP
+	addrBuf := make([]byte, portLen+ipv4Len)
+	if _, err := readExact(conn, addrBuf, portLen+ipv4Len, deadline); err != nil {
+		return nil, fmt.Errorf("read SOCKS4 address: %w", err)
+	}
+	req := &Request{
+		Version:  socksVersion4,
+		Cmd:      cmd,
+		AddrType: socksAddrTypeIPv4,
+		Host:     net.IP(addrBuf[portLen:]).String(),
+		Port:     int(addrBuf[0])<<8 | int(addrBuf[1]),
+	}
+
+	if _, err := readNulString(conn, deadline); err != nil {
+		return nil, fmt.Errorf("read SOCKS4 user ID: %w", err)
+	}
+	// SOCKS4a: 0.0.0.x with x != 0 means a hostname follows the user ID
+	if addrBuf[2] == 0 && addrBuf[3] == 0 && addrBuf[4] == 0 && addrBuf[5] != 0 {
+		host, err := readNulString(conn, deadline)
+		if err != nil {
+			return nil, fmt.Errorf("read SOCKS4a hostname: %w", err)
+		}
+		if host == "" {
+			conn.Write(req.Reply(socksReplyGeneralFail, ""))
+			return nil, fmt.Errorf("empty SOCKS4a hostname")
+		}
+		req.AddrType = socksAddrTypeDomain
+		req.Host = host
+	}
+
+	if authRequired(auth) {
+		log.Printf("NEAR: Bridge %s refused SOCKS4 request, authentication is required", bridgeName)
+		conn.Write(req.Reply(socksReplyGeneralFail, ""))
+		return nil, fmt.Errorf("SOCKS4 cannot authenticate")
+	}
+	if cmd != socksCmdConnect && cmd != socksCmdBind {
+		conn.Write(req.Reply(socksReplyGeneralFail, ""))
+		return nil, fmt.Errorf("unsupported command: %d", cmd)
+	}
+	return req, nil
+}
+
+// readNulString reads a NUL terminated string one byte at a time so nothing
+// past the request is consumed.
+func readNulString(conn net.Conn, deadline time.Time) (string, error) {
+	var buf []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := readExact(conn, b, 1, deadline); err != nil {
+			return "", err
+		}
+		if b[0] == 0 {
+			return string(buf), nil
+		}
+		if len(buf) == socks4MaxField {
+			return "", fmt.Errorf("field longer than %d bytes", socks4MaxField)
+		}
+		buf = append(buf, b[0])
+	}
+}
+
+// socks4Reply builds a SOCKS4 reply. Any SOCKS5 code other than success is
+// reported as rejected, and addr is only carried when it is IPv4.
+func socks4Reply(rep byte, addr string) []byte {
+	reply := make([]byte, 8)
+	reply[0] = socks4ReplyVersion
+	reply[1] = socks4ReplyRejected
+	if rep == socksReplySucceeded {
+		reply[1] = socks4ReplyGranted
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return reply
+	}
+	if ip := net.ParseIP(host).To4(); ip != nil {
+		port, _ := strconv.Atoi(portStr)
+		reply[2], reply[3] = byte(port>>8), byte(port)
+		copy(reply[4:], ip)
+	}
+	return reply
+}
@@ -0,0 +1,218 @@
+package socks
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestHandleSocksHandshake_Socks4AllDataAtOnce tests SOCKS4 and SOCKS4a
+// requests sent in one go
+func TestHandleSocksHandshake_Socks4AllDataAtOnce(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		expectHost string
+		expectPort int
+	}{
+		{
+			name: "SOCKS4 IPv4 with user ID",
+			data: buildSocksRequest(
+				[]byte{0x04, 0x01, 0x1f, 0x90}, // version 4, CONNECT, port 8080
+				[]byte{10, 0, 0, 1},
+				[]byte("alice\x00"),
+			),
+			expectHost: "10.0.0.1",
+			expectPort: 8080,
+		},
+		{
+			name: "SOCKS4 IPv4 without user ID",
+			data: buildSocksRequest(
+				[]byte{0x04, 0x01, 0x00, 0x50},
+				[]byte{192, 168, 1, 1},
+				[]byte{0x00},
+			),
+			expectHost: "192.168.1.1",
+			expectPort: 80,
+		},
+		{
+			name: "SOCKS4a hostname",
+			data: buildSocksRequest(
+				[]byte{0x04, 0x01, 0x01, 0xbb}, // port 443
+				[]byte{0, 0, 0, 1},             // 0.0.0.x marks SOCKS4a
+				[]byte("bob\x00"),
+				[]byte("example.com\x00"),
+			),
+			expectHost: "example.com",
+			expectPort: 443,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &mockConn{readBuf: tt.data}
+			host, port, err := HandleSocksHandshake(conn, "test-bridge")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if host != tt.expectHost {
+				t.Errorf("expected host %q, got %q", tt.expectHost, host)
+			}
+			if port != tt.expectPort {
+				t.Errorf("expected port %d, got %d", tt.expectPort, port)
+			}
+			if len(conn.writeBuf) != 0 {
+				t.Errorf("expected nothing written before the reply, got %v", conn.writeBuf)
+			}
+		})
+	}
+}
+
+// TestHandleSocksHandshake_Socks4FragmentedData tests SOCKS4 and SOCKS4a
+// requests split over several reads
+func TestHandleSocksHandshake_Socks4FragmentedData(t *testing.T) {
+	tests := []struct {
+		name       string
+		fragments  [][]byte
+		expectHost string
+		expectPort int
+		expectType byte
+	}{
+		{
+			name: "SOCKS4 fragmented - header, address, user ID",
+			fragments: [][]byte{
+				{0x04, 0x01},
+				{0x1f, 0x90, 10, 0},
+				{0, 1},
+				[]byte("ali"),
+				[]byte("ce\x00"),
+			},
+			expectHost: "10.0.0.1",
+			expectPort: 8080,
+			expectType: AddrTypeIPv4,
+		},
+		{
+			name: "SOCKS4a highly fragmented - one byte at a time for some parts",
+			fragments: [][]byte{
+				{0x04},
+				{0x01},
+				{0x00, 0x50},
+				{0, 0, 0, 7},
+				{0x00}, // empty user ID
+				[]byte("foo"),
+				[]byte(".bar"),
+				{0x00},
+			},
+			expectHost: "foo.bar",
+			expectPort: 80,
+			expectType: AddrTypeDomain,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var allData []byte
+			for _, frag := range tt.fragments {
+				allData = append(allData, frag...)
+			}
+			// Bytes the client sends after the request belong to the tunnel
+			allData = append(allData, "payload"...)
+			conn := &mockConn{readBuf: allData}
+
+			req, err := HandleSocksRequest(conn, "test-bridge", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if req.Version != 4 || req.Cmd != CmdConnect || req.AddrType != tt.expectType ||
+				req.Host != tt.expectHost || req.Port != tt.expectPort {
+				t.Fatalf("unexpected request %+v", req)
+			}
+			if rest := string(conn.readBuf[conn.readPos:]); rest != "payload" {
+				t.Errorf("expected only the request to be consumed, %q left", rest)
+			}
+		})
+	}
+}
+
+func TestHandleSocksRequest_Socks4Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		users     Users
+		wantReply bool
+	}{
+		{
+			name: "Truncated address",
+			data: []byte{0x04, 0x01, 0x00, 0x50, 10},
+		},
+		{
+			name: "Missing user ID terminator",
+			data: buildSocksRequest([]byte{0x04, 0x01, 0x00, 0x50, 10, 0, 0, 1}, []byte("alice")),
+		},
+		{
+			name: "User ID too long",
+			data: buildSocksRequest([]byte{0x04, 0x01, 0x00, 0x50, 10, 0, 0, 1}, []byte(strings.Repeat("a", 300)+"\x00")),
+		},
+		{
+			name:      "Empty SOCKS4a hostname",
+			data:      buildSocksRequest([]byte{0x04, 0x01, 0x00, 0x50, 0, 0, 0, 1}, []byte{0x00, 0x00}),
+			wantReply: true,
+		},
+		{
+			name:      "Unsupported command",
+			data:      buildSocksRequest([]byte{0x04, 0x03, 0x00, 0x50, 10, 0, 0, 1}, []byte{0x00}),
+			wantReply: true,
+		},
+		{
+			name:      "Authentication required",
+			data:      buildSocksRequest([]byte{0x04, 0x01, 0x00, 0x50, 10, 0, 0, 1}, []byte("alice\x00")),
+			users:     Users{"alice": "secret"},
+			wantReply: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &mockConn{readBuf: tt.data}
+			if _, err := HandleSocksRequest(conn, "test-bridge", tt.users); err == nil {
+				t.Fatalf("expected error but got none")
+			}
+			rejected := []byte{0x00, 0x5b, 0, 0, 0, 0, 0, 0}
+			if tt.wantReply && !bytes.Equal(conn.writeBuf, rejected) {
+				t.Errorf("expected SOCKS4 rejection, got %v", conn.writeBuf)
+			}
+			if !tt.wantReply && len(conn.writeBuf) != 0 {
+				t.Errorf("expected no reply, got %v", conn.writeBuf)
+			}
+		})
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	v4 := &Request{Version: 4}
+	v5 := &Request{Version: 5}
+	v6 := &Request{Version: 5, AddrType: AddrTypeIPv6, Host: "2001:db8::1"}
+	v6Literal := &Request{Version: 5, AddrType: AddrTypeDomain, Host: "2001:db8::1"}
+	tests := []struct {
+		req  *Request
+		rep  byte
+		addr string
+		want []byte
+	}{
+		{v4, RepSucceeded, "", []byte{0x00, 0x5a, 0, 0, 0, 0, 0, 0}},
+		{v4, RepSucceeded, "10.0.0.7:2121", []byte{0x00, 0x5a, 0x08, 0x49, 10, 0, 0, 7}},
+		{v4, RepConnectionRefused, "", []byte{0x00, 0x5b, 0, 0, 0, 0, 0, 0}},
+		{v4, RepSucceeded, "[::1]:80", []byte{0x00, 0x5a, 0, 0, 0, 0, 0, 0}},
+		{v5, RepSucceeded, "", ReplySuccess},
+		{v5, RepGeneralFailure, "", ReplyFail},
+		{v6, RepSucceeded, "", append([]byte{0x05, 0x00, 0x00, 0x04}, make([]byte, 18)...)},
+		{v6, RepConnectionRefused, "", append([]byte{0x05, 0x05, 0x00, 0x04}, make([]byte, 18)...)},
+		{v6Literal, RepSucceeded, "", append([]byte{0x05, 0x00, 0x00, 0x04}, make([]byte, 18)...)},
+		{v6, RepSucceeded, "10.0.0.7:2121", []byte{0x05, 0x00, 0x00, 0x01, 10, 0, 0, 7, 0x08, 0x49}},
+	}
+	for _, tt := range tests {
+		if got := tt.req.Reply(tt.rep, tt.addr); !bytes.Equal(got, tt.want) {
+			t.Errorf("Reply(v%d, 0x%02x, %q) = %v, want %v", tt.req.Version, tt.rep, tt.addr, got, tt.want)
+		}
+	}
+}
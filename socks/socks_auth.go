@@ -0,0 +1,71 @@
+package socks
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Users maps SOCKS usernames to either a bcrypt hash or a plaintext password.
+// An empty or nil Users disables username/password verification.
+type Users map[string]string
+
+func isBcryptHash(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+// Verify reports whether password is correct for username.
+func (u Users) Verify(username, password string) bool {
+	stored, ok := u[username]
+	if !ok {
+		// Burn comparable time so unknown users aren't distinguishable
+		subtle.ConstantTimeCompare([]byte(password), []byte(password))
+		return false
+	}
+	if isBcryptHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// Authenticator checks the credentials of the RFC 1929 sub-negotiation.
+// When Required is false, or the Authenticator is nil, clients may skip
+// authentication and any credentials are accepted.
+type Authenticator interface {
+	Required() bool
+	Verify(username, password string) bool
+}
+
+// Required reports whether any users are configured.
+func (u Users) Required() bool {
+	return len(u) > 0
+}
+
+// authRequired reports whether clients must authenticate against auth.
+func authRequired(auth Authenticator) bool {
+	return auth != nil && auth.Required()
+}
+
+// Authenticators combines backends, e.g. static Users with a FileUsers.
+// Credentials are accepted as soon as one backend that is in use accepts
+// them.
+type Authenticators []Authenticator
+
+func (a Authenticators) Required() bool {
+	for _, auth := range a {
+		if authRequired(auth) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a Authenticators) Verify(username, password string) bool {
+	for _, auth := range a {
+		if authRequired(auth) && auth.Verify(username, password) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package socks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// authCommandTimeout bounds one run of an auth command.
+	authCommandTimeout = 10 * time.Second
+	// authCacheTTL is how long accepted credentials skip the command.
+	authCacheTTL = 30 * time.Second
+)
+
+// CommandAuth checks credentials with an external program, accepting them
+// when it exits with status 0. The program reads the username and the
+// password as two lines on its stdin, so they never show up in a process
+// list. Accepted credentials are cached for authCacheTTL so a client opening
+// many connections does not start a process for each; rejections are not
+// cached.
+type CommandAuth struct {
+	args []string
+	key  []byte // keys the cached password digests
+
+	mu    sync.Mutex
+	cache map[string]cachedAuth
+}
+
+type cachedAuth struct {
+	digest  []byte
+	expires time.Time
+}
+
+// NewCommandAuth runs command, split on spaces into a program and its
+// arguments, for every check.
+func NewCommandAuth(command string) (*CommandAuth, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("empty auth command")
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("auth cache key: %w", err)
+	}
+	return &CommandAuth{args: args, key: key, cache: make(map[string]cachedAuth)}, nil
+}
+
+func (c *CommandAuth) Required() bool {
+	return true
+}
+
+func (c *CommandAuth) Verify(username, password string) bool {
+	// One line each on stdin, so neither may hold a line break
+	if strings.ContainsAny(username, "\r\n") || strings.ContainsAny(password, "\r\n") {
+		return false
+	}
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(password))
+	digest := mac.Sum(nil)
+
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.cache[username]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) && hmac.Equal(entry.digest, digest) {
+		return true
+	}
+
+	if !c.run(username, password) {
+		return false
+	}
+	c.mu.Lock()
+	for user, e := range c.cache {
+		if !now.Before(e.expires) {
+			delete(c.cache, user)
+		}
+	}
+	c.cache[username] = cachedAuth{digest: digest, expires: now.Add(authCacheTTL)}
+	c.mu.Unlock()
+	return true
+}
+
+func (c *CommandAuth) run(username, password string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), authCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.args[0], c.args[1:]...)
+	cmd.Stdin = strings.NewReader(username + "\n" + password + "\n")
+	err := cmd.Run()
+	if err == nil {
+		return true
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || ctx.Err() != nil {
+		// Not a verdict, the command could not run or hung
+		log.Printf("NEAR: SOCKS auth command %s failed: %v", c.args[0], err)
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package socks
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// stubAuthCommand writes a script accepting alice/secret that appends a line
+// to the returned log file on every run.
+func stubAuthCommand(t *testing.T) (command, runs string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+	dir := t.TempDir()
+	runs = filepath.Join(dir, "runs")
+	script := filepath.Join(dir, "auth.sh")
+	body := "#!/bin/sh\nread user\nread pass\necho \"$user\" >> \"$1\"\n" +
+		"[ \"$user\" = alice ] && [ \"$pass\" = secret ]\n"
+	if err := os.WriteFile(script, []byte(body), 0700); err != nil {
+		t.Fatalf("failed to write stub command: %v", err)
+	}
+	return script + " " + runs, runs
+}
+
+func countRuns(t *testing.T, runs string) int {
+	t.Helper()
+	data, err := os.ReadFile(runs)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatalf("failed to read runs: %v", err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+func TestCommandAuth_VerifyAndCache(t *testing.T) {
+	command, runs := stubAuthCommand(t)
+	c, err := NewCommandAuth(command)
+	if err != nil {
+		t.Fatalf("NewCommandAuth: %v", err)
+	}
+
+	if !c.Verify("alice", "secret") {
+		t.Fatalf("expected alice to be accepted")
+	}
+	if !c.Verify("alice", "secret") {
+		t.Fatalf("expected the cached alice to be accepted")
+	}
+	if n := countRuns(t, runs); n != 1 {
+		t.Fatalf("expected the second check to hit the cache, command ran %d times", n)
+	}
+
+	// A different password for a cached user still asks the command
+	if c.Verify("alice", "wrong") {
+		t.Fatalf("expected a wrong password to be rejected")
+	}
+	if c.Verify("mallory", "secret") {
+		t.Fatalf("expected mallory to be rejected")
+	}
+	if n := countRuns(t, runs); n != 3 {
+		t.Fatalf("expected rejections to run the command, ran %d times", n)
+	}
+
+	// Line breaks would let a client forge the password line
+	if c.Verify("alice\nsecret", "x") {
+		t.Fatalf("expected a username with a line break to be rejected")
+	}
+	if n := countRuns(t, runs); n != 3 {
+		t.Fatalf("expected a username with a line break not to run the command")
+	}
+}
+
+func TestCommandAuth_Errors(t *testing.T) {
+	if _, err := NewCommandAuth("  "); err == nil {
+		t.Fatalf("expected an error for an empty command")
+	}
+	c, err := NewCommandAuth(filepath.Join(t.TempDir(), "missing"))
+	if err != nil {
+		t.Fatalf("NewCommandAuth: %v", err)
+	}
+	if c.Verify("alice", "secret") {
+		t.Fatalf("expected a command that cannot run to reject")
+	}
+}
+
+func TestHandleSocksHandshakeAuth_CommandBackend(t *testing.T) {
+	command, _ := stubAuthCommand(t)
+	c, err := NewCommandAuth(command)
+	if err != nil {
+		t.Fatalf("NewCommandAuth: %v", err)
+	}
+	conn := &mockConn{readBuf: userPassRequest([]byte{0x02}, "alice", "secret")}
+	if _, _, err := HandleSocksHandshakeAuth(conn, "test-bridge", c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn = &mockConn{readBuf: userPassRequest([]byte{0x02}, "alice", "wrong")}
+	if _, _, err := HandleSocksHandshakeAuth(conn, "test-bridge", c); err == nil {
+		t.Fatalf("expected error for wrong password")
+	}
+}
@@ -0,0 +1,110 @@
+package socks
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authFileCheckInterval is how often a FileUsers looks for changes to its
+// file.
+const authFileCheckInterval = time.Second
+
+// FileUsers serves Users from an htpasswd-style file: one "user:password"
+// per line, where the password is a bcrypt hash (htpasswd -B) or plaintext.
+// Blank lines and lines starting with # are skipped. The file is read again
+// once it changes, so users can be added or removed without a restart.
+type FileUsers struct {
+	path string
+
+	mu      sync.Mutex
+	users   Users
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// NewFileUsers reads the users in path.
+func NewFileUsers(path string) (*FileUsers, error) {
+	f := &FileUsers{path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.load(info); err != nil {
+		return nil, err
+	}
+	f.checked = time.Now()
+	return f, nil
+}
+
+// Required is always true: an empty file lets nobody in.
+func (f *FileUsers) Required() bool {
+	return true
+}
+
+func (f *FileUsers) Verify(username, password string) bool {
+	return f.current().Verify(username, password)
+}
+
+// current returns the users, reading the file again if it changed. If the
+// new contents cannot be read the users from before are kept.
+func (f *FileUsers) current() Users {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Since(f.checked) < authFileCheckInterval {
+		return f.users
+	}
+	f.checked = time.Now()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		log.Printf("NEAR: SOCKS auth file %s: %v, keeping the previous users", f.path, err)
+		return f.users
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.users
+	}
+	if err := f.load(info); err != nil {
+		log.Printf("NEAR: SOCKS auth file %s: %v, keeping the previous users", f.path, err)
+		return f.users
+	}
+	log.Printf("NEAR: Reloaded SOCKS auth file %s, %d users", f.path, len(f.users))
+	return f.users
+}
+
+func (f *FileUsers) load(info os.FileInfo) error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	users, err := parseAuthFile(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.users = users
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return nil
+}
+
+func parseAuthFile(data []byte) (Users, error) {
+	users := Users{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, password, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: expected user:password", n)
+		}
+		users[user] = password
+	}
+	return users, scanner.Err()
+}
@@ -0,0 +1,112 @@
+package socks
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeAuthFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("failed to write auth file: %v", err)
+	}
+}
+
+func TestFileUsers_VerifyAndReload(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hashed-pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "users")
+	writeAuthFile(t, path, "# SOCKS users\n\nalice:plain-pass\nbob:"+string(hash)+"\n")
+
+	f, err := NewFileUsers(path)
+	if err != nil {
+		t.Fatalf("NewFileUsers: %v", err)
+	}
+	if !f.Required() {
+		t.Fatalf("expected a file backend to require auth")
+	}
+	for _, c := range []struct {
+		user, pass string
+		ok         bool
+	}{
+		{"alice", "plain-pass", true},
+		{"bob", "hashed-pass", true},
+		{"bob", "wrong", false},
+		{"mallory", "plain-pass", false},
+	} {
+		if got := f.Verify(c.user, c.pass); got != c.ok {
+			t.Fatalf("Verify(%s, %s) = %v, want %v", c.user, c.pass, got, c.ok)
+		}
+	}
+
+	// Drop alice and add carol
+	writeAuthFile(t, path, "carol:new-pass\n")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	f.mu.Lock()
+	f.checked = time.Time{}
+	f.mu.Unlock()
+	if f.Verify("alice", "plain-pass") || !f.Verify("carol", "new-pass") {
+		t.Fatalf("expected the changed file to be picked up")
+	}
+
+	// A broken file keeps the users from before
+	writeAuthFile(t, path, "no colon here\n")
+	later = later.Add(time.Minute)
+	os.Chtimes(path, later, later)
+	f.mu.Lock()
+	f.checked = time.Time{}
+	f.mu.Unlock()
+	if !f.Verify("carol", "new-pass") {
+		t.Fatalf("expected a broken file to keep the previous users")
+	}
+}
+
+func TestNewFileUsers_Errors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewFileUsers(filepath.Join(dir, "missing")); err == nil {
+		t.Fatalf("expected an error for a missing file")
+	}
+	path := filepath.Join(dir, "bad")
+	writeAuthFile(t, path, "alice:pass\n:nouser\n")
+	if _, err := NewFileUsers(path); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected a line 2 error, got %v", err)
+	}
+}
+
+func TestHandleSocksHandshakeAuth_FileBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	writeAuthFile(t, path, "alice:plain-pass\n")
+	f, err := NewFileUsers(path)
+	if err != nil {
+		t.Fatalf("NewFileUsers: %v", err)
+	}
+	// No static users, so only the file decides
+	auth := Authenticators{Users(nil), f}
+
+	conn := &mockConn{readBuf: userPassRequest([]byte{0x00, 0x02}, "alice", "plain-pass")}
+	if _, _, err := HandleSocksHandshakeAuth(conn, "test-bridge", auth); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn = &mockConn{readBuf: userPassRequest([]byte{0x02}, "alice", "wrong")}
+	if _, _, err := HandleSocksHandshakeAuth(conn, "test-bridge", auth); err == nil {
+		t.Fatalf("expected error for wrong password")
+	}
+}
+
+func TestAuthenticators_Required(t *testing.T) {
+	if (Authenticators{Users(nil)}).Required() {
+		t.Fatalf("expected no backends in use not to require auth")
+	}
+	auth := Authenticators{Users{"alice": "a"}, Users{"bob": "b"}}
+	if !auth.Required() || !auth.Verify("bob", "b") || auth.Verify("bob", "a") {
+		t.Fatalf("expected any backend to accept its own users")
+	}
+}
@@ -0,0 +1,182 @@
+package socks
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// userPassRequest builds a greeting offering user/pass, the RFC 1929
+// sub-negotiation and a CONNECT to 127.0.0.1:80.
+func userPassRequest(methods []byte, username, password string) []byte {
+	greeting := append([]byte{0x05, byte(len(methods))}, methods...)
+	auth := []byte{0x01, byte(len(username))}
+	auth = append(auth, username...)
+	auth = append(auth, byte(len(password)))
+	auth = append(auth, password...)
+	return buildSocksRequest(
+		greeting,
+		auth,
+		[]byte{0x05, 0x01, 0x00, 0x01},
+		[]byte{127, 0, 0, 1, 0x00, 0x50},
+	)
+}
+
+func testUsers(t *testing.T) Users {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("hashed-pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	return Users{
+		"alice": "plain-pass",
+		"bob":   string(hash),
+	}
+}
+
+func TestHandleSocksHandshakeAuth_CorrectCredentials(t *testing.T) {
+	users := testUsers(t)
+	for _, c := range []struct{ user, pass string }{
+		{"alice", "plain-pass"},
+		{"bob", "hashed-pass"},
+	} {
+		conn := &mockConn{readBuf: userPassRequest([]byte{0x02}, c.user, c.pass)}
+		host, port, err := HandleSocksHandshakeAuth(conn, "test-bridge", users)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.user, err)
+		}
+		if host != "127.0.0.1" || port != 80 {
+			t.Fatalf("%s: unexpected target %s:%d", c.user, host, port)
+		}
+		want := append(append([]byte{}, handshakeUserPass...), authReplySuccess...)
+		if !bytes.Equal(conn.writeBuf, want) {
+			t.Fatalf("%s: unexpected replies %v", c.user, conn.writeBuf)
+		}
+	}
+}
+
+func TestHandleSocksHandshakeAuth_WrongPassword(t *testing.T) {
+	users := testUsers(t)
+	for _, user := range []string{"alice", "bob"} {
+		conn := &mockConn{readBuf: userPassRequest([]byte{0x02}, user, "wrong")}
+		if _, _, err := HandleSocksHandshakeAuth(conn, "test-bridge", users); err == nil {
+			t.Fatalf("%s: expected error for wrong password", user)
+		}
+		if !bytes.HasSuffix(conn.writeBuf, authReplyFail) {
+			t.Fatalf("%s: expected auth failure reply, got %v", user, conn.writeBuf)
+		}
+	}
+}
+
+func TestHandleSocksHandshakeAuth_UnknownUser(t *testing.T) {
+	conn := &mockConn{readBuf: userPassRequest([]byte{0x02}, "mallory", "plain-pass")}
+	if _, _, err := HandleSocksHandshakeAuth(conn, "test-bridge", testUsers(t)); err == nil {
+		t.Fatalf("expected error for unknown user")
+	}
+	if !bytes.HasSuffix(conn.writeBuf, authReplyFail) {
+		t.Fatalf("expected auth failure reply, got %v", conn.writeBuf)
+	}
+}
+
+func TestHandleSocksHandshakeAuth_RequiresAuthWhenUsersSet(t *testing.T) {
+	// Client offers only no-auth
+	conn := &mockConn{readBuf: buildSocksRequest(
+		[]byte{0x05, 0x01, 0x00},
+		[]byte{0x05, 0x01, 0x00, 0x01},
+		[]byte{127, 0, 0, 1, 0x00, 0x50},
+	)}
+	if _, _, err := HandleSocksHandshakeAuth(conn, "test-bridge", testUsers(t)); err == nil {
+		t.Fatalf("expected no-auth to be refused when users are configured")
+	}
+	if !bytes.Equal(conn.writeBuf, handshakeNoAcceptable) {
+		t.Fatalf("expected no acceptable methods reply, got %v", conn.writeBuf)
+	}
+
+	// Client offers both: user/pass must be picked
+	conn = &mockConn{readBuf: userPassRequest([]byte{0x00, 0x02}, "alice", "plain-pass")}
+	if _, _, err := HandleSocksHandshakeAuth(conn, "test-bridge", testUsers(t)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.HasPrefix(conn.writeBuf, handshakeUserPass) {
+		t.Fatalf("expected user/pass to be selected, got %v", conn.writeBuf)
+	}
+}
+
+func TestHandleSocksHandshakeAuth_NoUsersPrefersNoAuth(t *testing.T) {
+	conn := &mockConn{readBuf: buildSocksRequest(
+		[]byte{0x05, 0x02, 0x00, 0x02},
+		[]byte{0x05, 0x01, 0x00, 0x01},
+		[]byte{127, 0, 0, 1, 0x00, 0x50},
+	)}
+	if _, _, err := HandleSocksHandshakeAuth(conn, "test-bridge", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(conn.writeBuf, handshakeNoAuth) {
+		t.Fatalf("expected no-auth to be selected, got %v", conn.writeBuf)
+	}
+}
+
+func TestHandleSocksRequest_GSSAPIOnly(t *testing.T) {
+	conn := &mockConn{readBuf: []byte{0x05, 0x01, 0x01}}
+	_, err := HandleSocksRequest(conn, "test-bridge", nil)
+	if !errors.Is(err, ErrNoAcceptableAuth) {
+		t.Fatalf("expected ErrNoAcceptableAuth, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "GSSAPI") {
+		t.Fatalf("expected the offered methods in the error, got %v", err)
+	}
+	if !bytes.Equal(conn.writeBuf, handshakeNoAcceptable) {
+		t.Fatalf("expected no acceptable methods reply, got %v", conn.writeBuf)
+	}
+}
+
+func TestHandleSocksRequest_NoAcceptableClosesWrite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	release := make(chan struct{})
+	defer close(release)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		// Held open until the client is done, so only the half close
+		// can end its read
+		defer conn.Close()
+		HandleSocksRequest(conn, "test-bridge", nil)
+		<-release
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	client.Write([]byte{0x05, 0x02, 0x01, 0x80})
+	reply, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("expected EOF after the reply, got %v", err)
+	}
+	if !bytes.Equal(reply, handshakeNoAcceptable) {
+		t.Fatalf("expected no acceptable methods reply, got %v", reply)
+	}
+}
+
+func TestMethodNames(t *testing.T) {
+	if got := methodNames([]byte{0x00, 0x01, 0x02, 0x80}); got != "no-auth, GSSAPI, user/pass, 0x80" {
+		t.Fatalf("unexpected method names %q", got)
+	}
+	if got := methodNames(nil); got != "none" {
+		t.Fatalf("expected none, got %q", got)
+	}
+}
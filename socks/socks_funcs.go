@@ -7,11 +7,23 @@ import (
 	"time"
 )
 
-// Helper function to read exact number of bytes
-func readExact(conn net.Conn, buf []byte, n int) (int, error) {
+// DefaultHandshakeTimeout bounds a whole SOCKS handshake when the caller
+// does not pick a timeout.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// readTimeout bounds each read within a handshake.
+const readTimeout = 5 * time.Second
+
+// readExact reads exactly n bytes into buf. Each call waits at most
+// readTimeout, and never past deadline, the end of the whole handshake.
+func readExact(conn net.Conn, buf []byte, n int, deadline time.Time) (int, error) {
 	defer conn.SetReadDeadline(time.Time{}) // Clear deadline after read
 
-	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+	readDeadline := time.Now().Add(readTimeout)
+	if deadline.Before(readDeadline) {
+		readDeadline = deadline
+	}
+	if err := conn.SetReadDeadline(readDeadline); err != nil {
 		return 0, err
 	}
 
@@ -26,7 +38,9 @@ func readExact(conn net.Conn, buf []byte, n int) (int, error) {
 	return total, nil
 }
 
-func handleUserPassAuth(conn net.Conn) error {
+// handleUserPassAuth runs the RFC 1929 sub-negotiation. When auth is required
+// the credentials must pass it; otherwise any credentials are accepted.
+func handleUserPassAuth(conn net.Conn, bridgeName string, auth Authenticator, deadline time.Time) error {
 	// Accept USER/PASS authentication
 	if _, err := conn.Write(handshakeUserPass); err != nil {
 		return fmt.Errorf("write handshake: %w", err)
@@ -34,7 +48,7 @@ func handleUserPassAuth(conn net.Conn) error {
 
 	// Read version
 	verBuf := make([]byte, 1)
-	if _, err := readExact(conn, verBuf, 1); err != nil {
+	if _, err := readExact(conn, verBuf, 1, deadline); err != nil {
 		return fmt.Errorf("read auth version: %w", err)
 	}
 	if verBuf[0] != 0x01 {
@@ -44,51 +58,111 @@ func handleUserPassAuth(conn net.Conn) error {
 
 	// Read username
 	ulenBuf := make([]byte, 1)
-	if _, err := readExact(conn, ulenBuf, 1); err != nil {
+	if _, err := readExact(conn, ulenBuf, 1, deadline); err != nil {
 		return fmt.Errorf("read username length: %w", err)
 	}
 	ulen := int(ulenBuf[0])
 	usernameBuf := make([]byte, ulen)
-	if _, err := readExact(conn, usernameBuf, ulen); err != nil {
+	if _, err := readExact(conn, usernameBuf, ulen, deadline); err != nil {
 		return fmt.Errorf("read username: %w", err)
 	}
 
 	// Read password
 	plenBuf := make([]byte, 1)
-	if _, err := readExact(conn, plenBuf, 1); err != nil {
+	if _, err := readExact(conn, plenBuf, 1, deadline); err != nil {
 		return fmt.Errorf("read password length: %w", err)
 	}
 	plen := int(plenBuf[0])
 	passwordBuf := make([]byte, plen)
-	if _, err := readExact(conn, passwordBuf, plen); err != nil {
+	if _, err := readExact(conn, passwordBuf, plen, deadline); err != nil {
 		return fmt.Errorf("read password: %w", err)
 	}
 
-	log.Printf("NEAR: Received auth - Username: %s, Password: %s", string(usernameBuf), string(passwordBuf))
+	username := string(usernameBuf)
+	if authRequired(auth) && !auth.Verify(username, string(passwordBuf)) {
+		log.Printf("NEAR: Bridge %s rejected SOCKS auth for user %q", bridgeName, username)
+		conn.Write(authReplyFail)
+		return fmt.Errorf("invalid credentials for user %q", username)
+	}
 
-	// TODO handle username/password verification here
 	if _, err := conn.Write(authReplySuccess); err != nil {
 		return fmt.Errorf("write auth success: %w", err)
 	}
 	return nil
 }
 
+// HandleSocksHandshake negotiates a SOCKS5 CONNECT without authentication.
 func HandleSocksHandshake(conn net.Conn, bridgeName string) (string, int, error) {
+	return HandleSocksHandshakeAuth(conn, bridgeName, nil)
+}
+
+// HandleSocksHandshakeAuth negotiates a SOCKS5 CONNECT. When auth is
+// required the client must authenticate with username/password; otherwise
+// no-auth is preferred and any username/password is accepted.
+func HandleSocksHandshakeAuth(conn net.Conn, bridgeName string, auth Authenticator) (string, int, error) {
+	cmd, host, port, err := HandleSocksRequestAuth(conn, bridgeName, auth)
+	if err != nil {
+		return "", 0, err
+	}
+	if cmd != CmdConnect {
+		return "", 0, fmt.Errorf("unsupported command: %d", cmd)
+	}
+	return host, port, nil
+}
+
+// HandleSocksRequestAuth negotiates auth like HandleSocksHandshakeAuth and
+// reads a CONNECT or BIND request, returning the command and its address.
+func HandleSocksRequestAuth(conn net.Conn, bridgeName string, auth Authenticator) (byte, string, int, error) {
+	cmd, _, host, port, err := HandleSocksRequestAuthType(conn, bridgeName, auth)
+	return cmd, host, port, err
+}
+
+// HandleSocksRequestAuthType is HandleSocksRequestAuth that also returns the
+// address type the client used (AddrTypeIPv4, AddrTypeDomain or AddrTypeIPv6).
+func HandleSocksRequestAuthType(conn net.Conn, bridgeName string, auth Authenticator) (byte, byte, string, int, error) {
+	req, err := HandleSocksRequest(conn, bridgeName, auth)
+	if err != nil {
+		return 0, 0, "", 0, err
+	}
+	return req.Cmd, req.AddrType, req.Host, req.Port, nil
+}
+
+// HandleSocksRequest reads a SOCKS4, SOCKS4a or SOCKS5 CONNECT or BIND, or a
+// SOCKS5 UDP ASSOCIATE, negotiating SOCKS5 auth like
+// HandleSocksHandshakeAuth. SOCKS4 has no passwords, so it is refused when
+// auth is required. Replies to the request must be built with its Reply
+// method. The handshake must finish within DefaultHandshakeTimeout.
+func HandleSocksRequest(conn net.Conn, bridgeName string, auth Authenticator) (*Request, error) {
+	return HandleSocksRequestTimeout(conn, bridgeName, auth, DefaultHandshakeTimeout)
+}
+
+// HandleSocksRequestTimeout is HandleSocksRequest with the whole handshake,
+// however the client paces it, bounded by timeout. A timeout of 0 uses
+// DefaultHandshakeTimeout.
+func HandleSocksRequestTimeout(conn net.Conn, bridgeName string, auth Authenticator, timeout time.Duration) (*Request, error) {
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
 	// 1. Read greeting header (version + num methods)
 	headerBuf := make([]byte, 2)
-	read, err := readExact(conn, headerBuf, 2)
+	read, err := readExact(conn, headerBuf, 2, deadline)
 	if err != nil {
 		// Don't wrap EOF errors - they just mean client disconnected before sending data
 		// This is common with health checks, port scanners, or cancelled connections
-		return "", 0, err
+		return nil, err
 	}
 	if read != 2 {
-		return "", 0, fmt.Errorf("incomplete SOCKS greeting header")
+		return nil, fmt.Errorf("incomplete SOCKS greeting header")
 	}
 
+	if headerBuf[0] == socksVersion4 {
+		return handleSocks4Request(conn, bridgeName, headerBuf[1], auth, deadline)
+	}
 	if headerBuf[0] != socksVersion5 {
 		log.Printf("NEAR: Bridge %s recieved unsupported SOCKS version: %d", bridgeName, headerBuf[0])
-		return "", 0, fmt.Errorf("unsupported SOCKS version: %d", headerBuf[0])
+		return nil, fmt.Errorf("unsupported SOCKS version: %d", headerBuf[0])
 	}
 
 	// Read the methods
@@ -96,12 +170,12 @@ func HandleSocksHandshake(conn net.Conn, bridgeName string) (string, int, error)
 	// log.Printf("NEAR: Bridge %s SOCKS number of auth methods: %d", bridgeName, numMethods)
 	methodsBuf := make([]byte, numMethods)
 	if numMethods > 0 {
-		read, err = readExact(conn, methodsBuf, numMethods)
+		read, err = readExact(conn, methodsBuf, numMethods, deadline)
 		if err != nil {
-			return "", 0, fmt.Errorf("read auth methods: %w", err)
+			return nil, fmt.Errorf("read auth methods: %w", err)
 		}
 		if read != numMethods {
-			return "", 0, fmt.Errorf("incomplete SOCKS methods")
+			return nil, fmt.Errorf("incomplete SOCKS methods")
 		}
 	}
 
@@ -118,76 +192,82 @@ func HandleSocksHandshake(conn net.Conn, bridgeName string) (string, int, error)
 		}
 	}
 
-	if foundNoAuth {
+	requireAuth := authRequired(auth)
+	if foundNoAuth && !requireAuth {
 		if _, err := conn.Write(handshakeNoAuth); err != nil {
-			return "", 0, fmt.Errorf("write no auth response: %w", err)
+			return nil, fmt.Errorf("write no auth response: %w", err)
 		}
 	} else if foundUserPass {
-		err = handleUserPassAuth(conn)
+		err = handleUserPassAuth(conn, bridgeName, auth, deadline)
 		if err != nil {
-			return "", 0, fmt.Errorf("user/pass auth failed: %w", err)
+			return nil, fmt.Errorf("user/pass auth failed: %w", err)
 		}
 	} else {
 		conn.Write(handshakeNoAcceptable)
-		return "", 0, fmt.Errorf("no acceptable SOCKS authentication methods")
+		// The client must hang up now, send our FIN straight away so it
+		// does not sit waiting for one until the caller closes
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+		return nil, fmt.Errorf("%w, client offered %s", ErrNoAcceptableAuth, methodNames(methodsBuf))
 	}
 
 	// 3. Read request header (version + cmd + reserved + addr type)
 	requestHeader := make([]byte, 4)
-	read, err = readExact(conn, requestHeader, 4)
+	read, err = readExact(conn, requestHeader, 4, deadline)
 	if err != nil {
-		return "", 0, fmt.Errorf("read request header: %w", err)
+		return nil, fmt.Errorf("read request header: %w", err)
 	}
 	if read != 4 {
-		return "", 0, fmt.Errorf("incomplete SOCKS request header")
+		return nil, fmt.Errorf("incomplete SOCKS request header")
 	}
 
 	if requestHeader[0] != socksVersion5 {
-		return "", 0, fmt.Errorf("unsupported SOCKS version: %d", requestHeader[0])
+		return nil, fmt.Errorf("unsupported SOCKS version: %d", requestHeader[0])
 	}
 
 	var host string
 	var port int
 
 	switch requestHeader[1] {
-	case socksCmdConnect:
+	case socksCmdConnect, socksCmdBind, socksCmdUDPAssociate:
 		switch requestHeader[3] {
 		case socksAddrTypeIPv4:
 			addrBuf := make([]byte, ipv4Len+portLen)
-			if _, err := readExact(conn, addrBuf, ipv4Len+portLen); err != nil {
-				return "", 0, fmt.Errorf("read IPv4 address: %w", err)
+			if _, err := readExact(conn, addrBuf, ipv4Len+portLen, deadline); err != nil {
+				return nil, fmt.Errorf("read IPv4 address: %w", err)
 			}
 			host = net.IP(addrBuf[:ipv4Len]).String()
 			port = int(addrBuf[ipv4Len])<<8 | int(addrBuf[ipv4Len+1])
 
 		case socksAddrTypeDomain:
 			dlenBuf := make([]byte, 1)
-			if _, err := readExact(conn, dlenBuf, 1); err != nil {
-				return "", 0, fmt.Errorf("read domain length: %w", err)
+			if _, err := readExact(conn, dlenBuf, 1, deadline); err != nil {
+				return nil, fmt.Errorf("read domain length: %w", err)
 			}
 			dlen := int(dlenBuf[0])
 
 			domainPortBuf := make([]byte, dlen+portLen)
-			if _, err := readExact(conn, domainPortBuf, dlen+portLen); err != nil {
-				return "", 0, fmt.Errorf("read domain and port: %w", err)
+			if _, err := readExact(conn, domainPortBuf, dlen+portLen, deadline); err != nil {
+				return nil, fmt.Errorf("read domain and port: %w", err)
 			}
 			host = string(domainPortBuf[:dlen])
 			port = int(domainPortBuf[dlen])<<8 | int(domainPortBuf[dlen+1])
 
 		case socksAddrTypeIPv6:
 			addrBuf := make([]byte, ipv6Len+portLen)
-			if _, err := readExact(conn, addrBuf, ipv6Len+portLen); err != nil {
-				return "", 0, fmt.Errorf("read IPv6 address: %w", err)
+			if _, err := readExact(conn, addrBuf, ipv6Len+portLen, deadline); err != nil {
+				return nil, fmt.Errorf("read IPv6 address: %w", err)
 			}
 			host = net.IP(addrBuf[:ipv6Len]).String()
 			port = int(addrBuf[ipv6Len])<<8 | int(addrBuf[ipv6Len+1])
 
 		default:
-			return "", 0, fmt.Errorf("unsupported address type: %d", requestHeader[3])
+			return nil, fmt.Errorf("unsupported address type: %d", requestHeader[3])
 		}
 	default:
-		return "", 0, fmt.Errorf("unsupported command: %d", requestHeader[1])
+		return nil, fmt.Errorf("unsupported command: %d", requestHeader[1])
 	}
 
-	return host, port, nil
+	return &Request{Version: socksVersion5, Cmd: requestHeader[1], AddrType: requestHeader[3], Host: host, Port: port}, nil
 }
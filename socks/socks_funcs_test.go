@@ -1,6 +1,8 @@
 package socks
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -272,7 +274,7 @@ func TestHandleSocksHandshake_ErrorCases(t *testing.T) {
 	}{
 		{
 			name: "Unsupported SOCKS version",
-			data: []byte{0x04, 0x01, 0x00}, // SOCKS4
+			data: []byte{0x03, 0x01, 0x00}, // SOCKS3 never existed
 		},
 		// Note: "Incomplete greeting" removed - readExact will just hang/block on real connection
 		// EOF behavior on mock is acceptable for incomplete data
@@ -291,6 +293,14 @@ func TestHandleSocksHandshake_ErrorCases(t *testing.T) {
 				[]byte{127, 0, 0, 1, 0x00, 0x50},
 			),
 		},
+		{
+			name: "BIND via the CONNECT-only handshake",
+			data: buildSocksRequest(
+				[]byte{0x05, 0x01, 0x00},
+				[]byte{0x05, 0x02, 0x00, 0x01},
+				[]byte{127, 0, 0, 1, 0x00, 0x50},
+			),
+		},
 		{
 			name: "Unsupported address type",
 			data: buildSocksRequest(
@@ -313,6 +323,98 @@ func TestHandleSocksHandshake_ErrorCases(t *testing.T) {
 	}
 }
 
+func TestHandleSocksRequestAuth_Bind(t *testing.T) {
+	conn := &mockConn{readBuf: buildSocksRequest(
+		[]byte{0x05, 0x01, 0x00},
+		[]byte{0x05, 0x02, 0x00, 0x01}, // BIND, IPv4
+		[]byte{10, 0, 0, 7, 0x00, 0x15},
+	)}
+	cmd, host, port, err := HandleSocksRequestAuth(conn, "test-bridge", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cmd != CmdBind || host != "10.0.0.7" || port != 21 {
+		t.Fatalf("unexpected request: cmd=%d %s:%d", cmd, host, port)
+	}
+}
+
+func TestHandleSocksRequest_UDPAssociate(t *testing.T) {
+	conn := &mockConn{readBuf: buildSocksRequest(
+		[]byte{0x05, 0x01, 0x00},
+		[]byte{0x05, 0x03, 0x00, 0x01}, // UDP ASSOCIATE, IPv4
+		[]byte{0, 0, 0, 0, 0x00, 0x00},
+	)}
+	req, err := HandleSocksRequest(conn, "test-bridge", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.Cmd != CmdUDPAssociate || req.Host != "0.0.0.0" || req.Port != 0 {
+		t.Fatalf("unexpected request: cmd=%d %s:%d", req.Cmd, req.Host, req.Port)
+	}
+}
+
+func TestUDPPacket(t *testing.T) {
+	pkt := []byte{0x01, 10, 0, 0, 7, 0x00, 0x35, 'h', 'i'}
+	got, ok := UDPPacket(UDPDatagram(pkt))
+	if !ok || !bytes.Equal(got, pkt) {
+		t.Fatalf("UDPPacket(UDPDatagram(pkt)) = %v, %v, want %v", got, ok, pkt)
+	}
+	fragment := append([]byte{0x00, 0x00, 0x01}, pkt...)
+	if _, ok := UDPPacket(fragment); ok {
+		t.Error("fragmented datagram accepted")
+	}
+	if _, ok := UDPPacket([]byte{0x00, 0x00}); ok {
+		t.Error("short datagram accepted")
+	}
+}
+
+func TestHandleSocksRequestAuthType(t *testing.T) {
+	tests := []struct {
+		name     string
+		addr     []byte
+		wantType byte
+		wantHost string
+	}{
+		{"ipv4", []byte{0x01, 10, 0, 0, 7}, AddrTypeIPv4, "10.0.0.7"},
+		{"domain", append([]byte{0x03, 11}, "far.example"...), AddrTypeDomain, "far.example"},
+		{"ipv6", append([]byte{0x04}, net.IPv6loopback...), AddrTypeIPv6, "::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &mockConn{readBuf: buildSocksRequest(
+				[]byte{0x05, 0x01, 0x00},
+				[]byte{0x05, 0x01, 0x00},
+				tt.addr,
+				[]byte{0x00, 0x50},
+			)}
+			cmd, atyp, host, port, err := HandleSocksRequestAuthType(conn, "test-bridge", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cmd != CmdConnect || atyp != tt.wantType || host != tt.wantHost || port != 80 {
+				t.Fatalf("unexpected request: cmd=%d atyp=%d %s:%d", cmd, atyp, host, port)
+			}
+		})
+	}
+}
+
+func TestReply(t *testing.T) {
+	tests := []struct {
+		addr string
+		want []byte
+	}{
+		{"10.0.0.7:2121", []byte{0x05, 0x00, 0x00, 0x01, 10, 0, 0, 7, 0x08, 0x49}},
+		{"[::1]:80", append(append([]byte{0x05, 0x00, 0x00, 0x04}, net.IPv6loopback...), 0x00, 0x50)},
+		{"far.example:443", append(append([]byte{0x05, 0x00, 0x00, 0x03, 11}, "far.example"...), 0x01, 0xbb)},
+		{"garbage", []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		if got := Reply(RepSucceeded, tt.addr); !bytes.Equal(got, tt.want) {
+			t.Errorf("Reply(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
 // buildSocksRequest concatenates multiple byte slices into a single SOCKS request
 func buildSocksRequest(parts ...[]byte) []byte {
 	var result []byte
@@ -321,3 +423,31 @@ func buildSocksRequest(parts ...[]byte) []byte {
 	}
 	return result
 }
+
+func TestHandleSocksRequest_DripFedHandshakeTimesOut(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// A full request, one byte every 50ms: no single read waits long
+	data := []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0, 80}
+	go io.Copy(io.Discard, client) // the method reply
+	go func() {
+		for _, b := range data {
+			if _, err := client.Write([]byte{b}); err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	_, err := HandleSocksRequestTimeout(server, "test", nil, 200*time.Millisecond)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected the handshake to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the handshake deadline to cut it off, took %v", elapsed)
+	}
+}
@@ -1,17 +1,32 @@
 package socks
 
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
 const (
 	socksVersion5     = 0x05
 	socksAuthNoAuth   = 0x00
+	socksAuthGSSAPI   = 0x01
 	socksAuthUserPass = 0x02
 
 	socksCmdConnect       = 0x01
+	socksCmdBind          = 0x02
 	socksCmdUDPAssociate  = 0x03
 	socksAddrTypeIPv4     = 0x01
 	socksAddrTypeDomain   = 0x03
 	socksAddrTypeIPv6     = 0x04
 	socksReplySucceeded   = 0x00
 	socksReplyGeneralFail = 0x01
+	socksReplyNotAllowed  = 0x02
+	socksReplyNetUnreach  = 0x03
+	socksReplyHostUnreach = 0x04
+	socksReplyConnRefused = 0x05
+	socksReplyCmdNotSupp  = 0x07
 	socksReserved         = 0x00
 	maxMethods            = 255
 	handshakeMinLen       = 2
@@ -21,6 +36,21 @@ const (
 	portLen               = 2
 
 	MaxConnections = 2000
+
+	CmdConnect             = socksCmdConnect
+	CmdBind                = socksCmdBind
+	CmdUDPAssociate        = socksCmdUDPAssociate
+	RepSucceeded           = socksReplySucceeded
+	RepGeneralFailure      = socksReplyGeneralFail
+	RepNotAllowed          = socksReplyNotAllowed
+	RepNetworkUnreachable  = socksReplyNetUnreach
+	RepHostUnreachable     = socksReplyHostUnreach
+	RepConnectionRefused   = socksReplyConnRefused
+	RepCommandNotSupported = socksReplyCmdNotSupp
+
+	AddrTypeIPv4   = socksAddrTypeIPv4
+	AddrTypeDomain = socksAddrTypeDomain
+	AddrTypeIPv6   = socksAddrTypeIPv6
 )
 
 var (
@@ -32,3 +62,106 @@ var (
 	ReplySuccess          = []byte{socksVersion5, socksReplySucceeded, socksReserved, socksAddrTypeIPv4, 0, 0, 0, 0, 0, 0}
 	ReplyFail             = []byte{socksVersion5, socksReplyGeneralFail, socksReserved, socksAddrTypeIPv4, 0, 0, 0, 0, 0, 0}
 )
+
+// ErrNoAcceptableAuth is returned by the handshake when the client offered
+// no SOCKS5 auth method the bridge takes, e.g. only GSSAPI, or only no-auth
+// while SBSocksUsers are set.
+var ErrNoAcceptableAuth = errors.New("no acceptable SOCKS authentication methods")
+
+// methodNames lists the auth methods of a SOCKS5 greeting for logging.
+func methodNames(methods []byte) string {
+	if len(methods) == 0 {
+		return "none"
+	}
+	names := make([]string, len(methods))
+	for i, m := range methods {
+		switch m {
+		case socksAuthNoAuth:
+			names[i] = "no-auth"
+		case socksAuthGSSAPI:
+			names[i] = "GSSAPI"
+		case socksAuthUserPass:
+			names[i] = "user/pass"
+		default:
+			names[i] = fmt.Sprintf("0x%02x", m)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// Request is a CONNECT, BIND or UDP ASSOCIATE read by HandleSocksRequest.
+type Request struct {
+	Version  byte // 4 for SOCKS4 and SOCKS4a, 5 for SOCKS5
+	Cmd      byte // CmdConnect, CmdBind or CmdUDPAssociate (SOCKS5 only)
+	AddrType byte // AddrTypeIPv4, AddrTypeDomain or AddrTypeIPv6
+	Host     string
+	Port     int
+}
+
+// Reply builds the reply to r in its SOCKS version. rep is a SOCKS5 reply
+// code and addr ("host:port") the bound address, see Reply. With no addr a
+// SOCKS5 reply carries the zero address in the family of the request's
+// target, so IPv6 targets get "[::]:0" rather than "0.0.0.0:0".
+func (r *Request) Reply(rep byte, addr string) []byte {
+	if r.Version == socksVersion4 {
+		return socks4Reply(rep, addr)
+	}
+	if addr == "" && r.isIPv6() {
+		addr = "[::]:0"
+	}
+	return Reply(rep, addr)
+}
+
+// isIPv6 reports whether the target is an IPv6 address, given as one or as
+// a literal in a domain name.
+func (r *Request) isIPv6() bool {
+	if r.AddrType == socksAddrTypeIPv6 {
+		return true
+	}
+	ip := net.ParseIP(r.Host)
+	return ip != nil && ip.To4() == nil
+}
+
+// Reply builds a SOCKS5 reply carrying addr ("host:port") as BND.ADDR and
+// BND.PORT. Hosts that are not IPs are sent as domain names.
+func Reply(rep byte, addr string) []byte {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, portStr = "0.0.0.0", "0"
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	reply := []byte{socksVersion5, rep, socksReserved}
+	ip := net.ParseIP(host)
+	switch {
+	case ip != nil && ip.To4() != nil:
+		reply = append(reply, socksAddrTypeIPv4)
+		reply = append(reply, ip.To4()...)
+	case ip != nil:
+		reply = append(reply, socksAddrTypeIPv6)
+		reply = append(reply, ip.To16()...)
+	default:
+		if len(host) > 255 {
+			host = host[:255]
+		}
+		reply = append(reply, socksAddrTypeDomain, byte(len(host)))
+		reply = append(reply, host...)
+	}
+	return append(reply, byte(port>>8), byte(port))
+}
+
+// UDPPacket strips RSV and FRAG off a SOCKS5 UDP request datagram, leaving
+// ATYP, DST.ADDR, DST.PORT and DATA. Fragments are not supported and, as
+// RFC 1928 allows, come back with ok false so they can be dropped.
+func UDPPacket(datagram []byte) ([]byte, bool) {
+	if len(datagram) < 4 || datagram[0] != 0 || datagram[1] != 0 || datagram[2] != 0 {
+		return nil, false
+	}
+	return datagram[3:], true
+}
+
+// UDPDatagram prepends RSV and FRAG to pkt (ATYP, address, port and data)
+// to make a SOCKS5 UDP reply datagram.
+func UDPDatagram(pkt []byte) []byte {
+	return append([]byte{socksReserved, socksReserved, 0}, pkt...)
+}
@@ -0,0 +1,61 @@
+package status
+
+import "sync/atomic"
+
+// CompressionCounts is a point-in-time copy of a bridge's compression
+// counters. Both directions are counted: what the bridge compressed before
+// sending and what it decompressed after receiving.
+type CompressionCounts struct {
+	UncompressedBytes int64 // payload bytes before compression
+	CompressedBytes   int64 // the same bytes as they crossed the tunnel
+}
+
+// Ratio returns uncompressed over compressed bytes, so 2.0 means the tunnel
+// carried half the payload. It is 1.0 until compressed streams have moved
+// data, including for bridges that do not compress.
+func (c CompressionCounts) Ratio() float64 {
+	if c.CompressedBytes <= 0 || c.UncompressedBytes <= 0 {
+		return 1.0
+	}
+	return float64(c.UncompressedBytes) / float64(c.CompressedBytes)
+}
+
+// CompressionCounter collects the compression counters of one bridge. A nil
+// counter ignores adds.
+type CompressionCounter struct {
+	uncompressed atomic.Int64
+	compressed   atomic.Int64
+}
+
+// Add records uncompressed payload bytes that crossed the tunnel as
+// compressed bytes.
+func (c *CompressionCounter) Add(uncompressed, compressed int64) {
+	if c == nil {
+		return
+	}
+	c.uncompressed.Add(uncompressed)
+	c.compressed.Add(compressed)
+}
+
+// CompressionCounter returns the compression counters of a bridge, creating
+// them on first use.
+func (cm *ConnectionMonitor) CompressionCounter(bridgeName string) *CompressionCounter {
+	if cc, ok := cm.compressMap.Load(bridgeName); ok {
+		return cc.(*CompressionCounter)
+	}
+	cc, _ := cm.compressMap.LoadOrStore(bridgeName, &CompressionCounter{})
+	return cc.(*CompressionCounter)
+}
+
+// Compression returns the compression counters of a bridge since start.
+func (cm *ConnectionMonitor) Compression(bridgeName string) CompressionCounts {
+	cc, ok := cm.compressMap.Load(bridgeName)
+	if !ok {
+		return CompressionCounts{}
+	}
+	c := cc.(*CompressionCounter)
+	return CompressionCounts{
+		UncompressedBytes: c.uncompressed.Load(),
+		CompressedBytes:   c.compressed.Load(),
+	}
+}
@@ -0,0 +1,32 @@
+package status
+
+import "testing"
+
+func TestCompressionRatio(t *testing.T) {
+	cm := &ConnectionMonitor{}
+	if got := cm.Compression("unknown"); got != (CompressionCounts{}) || got.Ratio() != 1.0 {
+		t.Fatalf("expected zero counts and a 1.0 ratio for an unknown bridge, got %+v ratio %v", got, got.Ratio())
+	}
+
+	c := cm.CompressionCounter("a")
+	if cm.CompressionCounter("a") != c {
+		t.Fatalf("expected one counter per bridge")
+	}
+	c.Add(3000, 1000)
+	c.Add(1000, 500)
+	got := cm.Compression("a")
+	if got != (CompressionCounts{UncompressedBytes: 4000, CompressedBytes: 1500}) {
+		t.Fatalf("unexpected counts %+v", got)
+	}
+	if r := got.Ratio(); r < 2.666 || r > 2.667 {
+		t.Errorf("expected a ratio of 4000/1500, got %v", r)
+	}
+
+	// Incompressible data sent stored comes out just under 1.0
+	if r := (CompressionCounts{UncompressedBytes: 1000, CompressedBytes: 1010}).Ratio(); r >= 1.0 {
+		t.Errorf("expected a ratio below 1.0 when compression grew the data, got %v", r)
+	}
+
+	var nilCounter *CompressionCounter
+	nilCounter.Add(10, 5)
+}
@@ -11,17 +11,27 @@ import (
 
 // ConnectionMonitor tracks active connections for debugging
 type ConnectionMonitor struct {
-	activeSOCKS atomic.Int64
-	activeHTTP  atomic.Int64
-	activeOUT   atomic.Int64
-	totalSOCKS  atomic.Int64
-	totalHTTP   atomic.Int64
-	totalOUT    atomic.Int64
-
-	limiterMap sync.Map
-	statusMap  sync.Map
-	streamMap  sync.Map
-	pingMap    sync.Map
+	activeSOCKS    atomic.Int64
+	activeHTTP     atomic.Int64
+	activeRedirect atomic.Int64
+	activeOUT      atomic.Int64
+	totalSOCKS     atomic.Int64
+	totalHTTP      atomic.Int64
+	totalRedirect  atomic.Int64
+	totalOUT       atomic.Int64
+
+	limiterMap  sync.Map
+	quicMap     sync.Map // bridge name -> its QUIC pool, see RegisterConnStats
+	statusMap   sync.Map
+	streamMap   sync.Map
+	pingMap     sync.Map
+	endpointMap sync.Map
+	rejectMap   sync.Map // bridge name -> *rejectCounters
+	ingressMap  sync.Map // bridge name -> *ingressCounters
+	errorMap    sync.Map // bridge name -> BridgeError
+	compressMap sync.Map // bridge name -> *CompressionCounter
+
+	history rateHistory
 }
 
 var GlobalConnMonitorRef = &ConnectionMonitor{}
@@ -34,11 +44,34 @@ func (cm *ConnectionMonitor) GetLimiter(name string) (interface{}, bool) {
 	return cm.limiterMap.Load(name)
 }
 
+// RegisterConnStats records the QUIC pool of a bridge, whose per connection
+// stats the API serves. It is an interface{} as connections imports status.
+func (cm *ConnectionMonitor) RegisterConnStats(name string, pool interface{}) {
+	cm.quicMap.Store(name, pool)
+}
+
+func (cm *ConnectionMonitor) GetConnStats(name string) (interface{}, bool) {
+	return cm.quicMap.Load(name)
+}
+
 func (cm *ConnectionMonitor) RegisterPing(name string, ping int64) {
 	cm.statusMap.Store(name, time.Now())
 	cm.pingMap.Store(name, ping)
 }
 
+// RegisterEndpoint records the far host a near bridge is currently dialing.
+func (cm *ConnectionMonitor) RegisterEndpoint(name string, endpoint string) {
+	cm.endpointMap.Store(name, endpoint)
+}
+
+func (cm *ConnectionMonitor) GetEndpoint(name string) string {
+	endpoint, exists := cm.endpointMap.Load(name)
+	if !exists {
+		return ""
+	}
+	return endpoint.(string)
+}
+
 func (cm *ConnectionMonitor) AddStream(bridgeName string) {
 	pval, _ := cm.streamMap.LoadOrStore(bridgeName, int64(0))
 	cm.streamMap.Store(bridgeName, pval.(int64)+1)
@@ -86,22 +119,38 @@ func (cm *ConnectionMonitor) GetPing(name string) int64 {
 	return ping.(int64)
 }
 
-func (cm *ConnectionMonitor) IncSOCKS() {
+func (cm *ConnectionMonitor) IncSOCKS(bridgeName string) {
 	cm.activeSOCKS.Add(1)
 	cm.totalSOCKS.Add(1)
+	cm.ingress(bridgeName).socks.inc()
 }
 
-func (cm *ConnectionMonitor) DecSOCKS() {
+func (cm *ConnectionMonitor) DecSOCKS(bridgeName string) {
 	cm.activeSOCKS.Add(-1)
+	cm.ingress(bridgeName).socks.dec()
 }
 
-func (cm *ConnectionMonitor) IncHTTP() {
+func (cm *ConnectionMonitor) IncHTTP(bridgeName string) {
 	cm.activeHTTP.Add(1)
 	cm.totalHTTP.Add(1)
+	cm.ingress(bridgeName).http.inc()
 }
 
-func (cm *ConnectionMonitor) DecHTTP() {
+func (cm *ConnectionMonitor) DecHTTP(bridgeName string) {
 	cm.activeHTTP.Add(-1)
+	cm.ingress(bridgeName).http.dec()
+}
+
+// IncRedirect counts a SOCKS redirector connection handed to bridgeName.
+func (cm *ConnectionMonitor) IncRedirect(bridgeName string) {
+	cm.activeRedirect.Add(1)
+	cm.totalRedirect.Add(1)
+	cm.ingress(bridgeName).redirect.inc()
+}
+
+func (cm *ConnectionMonitor) DecRedirect(bridgeName string) {
+	cm.activeRedirect.Add(-1)
+	cm.ingress(bridgeName).redirect.dec()
 }
 
 func (cm *ConnectionMonitor) IncOUT() {
@@ -113,6 +162,31 @@ func (cm *ConnectionMonitor) DecOUT() {
 	cm.activeOUT.Add(-1)
 }
 
+// ConnCounts is a point-in-time copy of the connection counters.
+type ConnCounts struct {
+	ActiveSOCKS    int64
+	ActiveHTTP     int64
+	ActiveRedirect int64
+	ActiveOUT      int64
+	TotalSOCKS     int64
+	TotalHTTP      int64
+	TotalRedirect  int64
+	TotalOUT       int64
+}
+
+func (cm *ConnectionMonitor) Counts() ConnCounts {
+	return ConnCounts{
+		ActiveSOCKS:    cm.activeSOCKS.Load(),
+		ActiveHTTP:     cm.activeHTTP.Load(),
+		ActiveRedirect: cm.activeRedirect.Load(),
+		ActiveOUT:      cm.activeOUT.Load(),
+		TotalSOCKS:     cm.totalSOCKS.Load(),
+		TotalHTTP:      cm.totalHTTP.Load(),
+		TotalRedirect:  cm.totalRedirect.Load(),
+		TotalOUT:       cm.totalOUT.Load(),
+	}
+}
+
 func (cm *ConnectionMonitor) StartPeriodicLogging() {
 	go func() {
 		ticker := time.NewTicker(15 * time.Second)
@@ -122,12 +196,14 @@ func (cm *ConnectionMonitor) StartPeriodicLogging() {
 			var m runtime.MemStats
 			runtime.ReadMemStats(&m)
 
-			log.Printf("MONITOR: Active connections - SOCKS: %d, HTTP: %d, OUT: %d | Total served - SOCKS: %d, HTTP: %d, OUT: %d | Goroutines: %d | HeapAlloc: %d MB",
+			log.Printf("MONITOR: Active connections - SOCKS: %d, HTTP: %d, REDIRECT: %d, OUT: %d | Total served - SOCKS: %d, HTTP: %d, REDIRECT: %d, OUT: %d | Goroutines: %d | HeapAlloc: %d MB",
 				cm.activeSOCKS.Load(),
 				cm.activeHTTP.Load(),
+				cm.activeRedirect.Load(),
 				cm.activeOUT.Load(),
 				cm.totalSOCKS.Load(),
 				cm.totalHTTP.Load(),
+				cm.totalRedirect.Load(),
 				cm.totalOUT.Load(),
 				runtime.NumGoroutine(),
 				m.HeapAlloc/1024/1024,
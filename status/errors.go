@@ -0,0 +1,35 @@
+package status
+
+import "time"
+
+// BridgeError is the last failure seen talking to a bridge's far side.
+type BridgeError struct {
+	Message string
+	Time    time.Time
+}
+
+// RegisterError records err as the bridge's last error. A nil err clears it,
+// as does ClearError.
+func (cm *ConnectionMonitor) RegisterError(name string, err error) {
+	if err == nil {
+		cm.ClearError(name)
+		return
+	}
+	cm.errorMap.Store(name, BridgeError{Message: err.Error(), Time: time.Now()})
+}
+
+// ClearError forgets the bridge's last error, e.g. once a stream opens or a
+// status check succeeds again.
+func (cm *ConnectionMonitor) ClearError(name string) {
+	cm.errorMap.Delete(name)
+}
+
+// LastError returns the bridge's last error, if it has one that has not been
+// cleared by a later success.
+func (cm *ConnectionMonitor) LastError(name string) (BridgeError, bool) {
+	e, ok := cm.errorMap.Load(name)
+	if !ok {
+		return BridgeError{}, false
+	}
+	return e.(BridgeError), true
+}
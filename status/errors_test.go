@@ -0,0 +1,36 @@
+package status
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegisterError(t *testing.T) {
+	cm := &ConnectionMonitor{}
+	if _, ok := cm.LastError("a"); ok {
+		t.Fatalf("expected no error for an unknown bridge")
+	}
+
+	before := time.Now()
+	cm.RegisterError("a", errors.New("connection refused"))
+	cm.RegisterError("a", errors.New("timeout"))
+	got, ok := cm.LastError("a")
+	if !ok || got.Message != "timeout" || got.Time.Before(before) {
+		t.Fatalf("expected the latest error to overwrite, got %+v (%v)", got, ok)
+	}
+	if _, ok := cm.LastError("b"); ok {
+		t.Fatalf("expected errors to be per bridge")
+	}
+
+	// A success clears it
+	cm.ClearError("a")
+	if _, ok := cm.LastError("a"); ok {
+		t.Fatalf("expected the error to be cleared")
+	}
+	cm.RegisterError("a", errors.New("timeout"))
+	cm.RegisterError("a", nil)
+	if _, ok := cm.LastError("a"); ok {
+		t.Fatalf("expected a nil error to clear it")
+	}
+}
@@ -0,0 +1,59 @@
+package status
+
+import "sync/atomic"
+
+// IngressCounts is a point-in-time copy of a bridge's client connections,
+// split by how they came in.
+type IngressCounts struct {
+	ActiveSOCKS    int64 // on the bridge's own SOCKS listener
+	ActiveHTTP     int64 // on the bridge's HTTP proxy listener
+	ActiveRedirect int64 // sent to the bridge by the SOCKS redirector
+	TotalSOCKS     int64
+	TotalHTTP      int64
+	TotalRedirect  int64
+}
+
+type ingressCounter struct {
+	active atomic.Int64
+	total  atomic.Int64
+}
+
+func (c *ingressCounter) inc() {
+	c.active.Add(1)
+	c.total.Add(1)
+}
+
+func (c *ingressCounter) dec() {
+	c.active.Add(-1)
+}
+
+type ingressCounters struct {
+	socks    ingressCounter
+	http     ingressCounter
+	redirect ingressCounter
+}
+
+func (cm *ConnectionMonitor) ingress(bridgeName string) *ingressCounters {
+	if ic, ok := cm.ingressMap.Load(bridgeName); ok {
+		return ic.(*ingressCounters)
+	}
+	ic, _ := cm.ingressMap.LoadOrStore(bridgeName, &ingressCounters{})
+	return ic.(*ingressCounters)
+}
+
+// Ingress returns the client connection counters of a bridge since start.
+func (cm *ConnectionMonitor) Ingress(bridgeName string) IngressCounts {
+	ic, ok := cm.ingressMap.Load(bridgeName)
+	if !ok {
+		return IngressCounts{}
+	}
+	c := ic.(*ingressCounters)
+	return IngressCounts{
+		ActiveSOCKS:    c.socks.active.Load(),
+		ActiveHTTP:     c.http.active.Load(),
+		ActiveRedirect: c.redirect.active.Load(),
+		TotalSOCKS:     c.socks.total.Load(),
+		TotalHTTP:      c.http.total.Load(),
+		TotalRedirect:  c.redirect.total.Load(),
+	}
+}
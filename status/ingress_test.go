@@ -0,0 +1,30 @@
+package status
+
+import "testing"
+
+func TestIngress(t *testing.T) {
+	cm := &ConnectionMonitor{}
+	if got := cm.Ingress("unknown"); got != (IngressCounts{}) {
+		t.Fatalf("expected zero counts for an unknown bridge, got %+v", got)
+	}
+
+	cm.IncSOCKS("a")
+	cm.IncSOCKS("a")
+	cm.DecSOCKS("a")
+	cm.IncHTTP("a")
+	cm.IncRedirect("b")
+	cm.DecRedirect("b")
+
+	want := IngressCounts{ActiveSOCKS: 1, ActiveHTTP: 1, TotalSOCKS: 2, TotalHTTP: 1}
+	if got := cm.Ingress("a"); got != want {
+		t.Errorf("expected %+v for a, got %+v", want, got)
+	}
+	if got := cm.Ingress("b"); got != (IngressCounts{TotalRedirect: 1}) {
+		t.Errorf("expected one finished redirect for b, got %+v", got)
+	}
+
+	counts := cm.Counts()
+	if counts.ActiveSOCKS != 1 || counts.TotalSOCKS != 2 || counts.ActiveRedirect != 0 || counts.TotalRedirect != 1 {
+		t.Errorf("expected global counts to include every bridge, got %+v", counts)
+	}
+}
@@ -0,0 +1,112 @@
+package status
+
+import (
+	"context"
+	"salmoncannon/limiter"
+	"sync"
+	"time"
+)
+
+// RateSample is one point of a bridge's bandwidth history.
+type RateSample struct {
+	Time             time.Time `json:"time"`
+	RateBitsPerSec   float64   `json:"rate_bps"`
+	TransferredBytes uint64    `json:"transferred_bytes"`
+}
+
+// rateRing keeps the most recent samples for one bridge.
+type rateRing struct {
+	samples []RateSample
+	next    int
+	full    bool
+}
+
+func (r *rateRing) add(s RateSample) {
+	r.samples[r.next] = s
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// ordered returns a copy of the samples, oldest first.
+func (r *rateRing) ordered() []RateSample {
+	if !r.full {
+		return append([]RateSample(nil), r.samples[:r.next]...)
+	}
+	out := make([]RateSample, 0, len(r.samples))
+	out = append(out, r.samples[r.next:]...)
+	return append(out, r.samples[:r.next]...)
+}
+
+type rateHistory struct {
+	once     sync.Once
+	mu       sync.Mutex
+	interval time.Duration
+	size     int
+	bridges  map[string]*rateRing
+}
+
+// StartRateHistory samples every registered limiter's rate each interval,
+// keeping the last size samples per bridge. Only the first call starts the
+// sampler; it stops when ctx is done.
+func (cm *ConnectionMonitor) StartRateHistory(ctx context.Context, interval time.Duration, size int) {
+	if interval <= 0 || size <= 0 {
+		return
+	}
+	cm.history.once.Do(func() {
+		cm.history.mu.Lock()
+		cm.history.interval = interval
+		cm.history.size = size
+		cm.history.bridges = make(map[string]*rateRing)
+		cm.history.mu.Unlock()
+
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case now := <-ticker.C:
+					cm.sampleRates(now)
+				}
+			}
+		}()
+	})
+}
+
+func (cm *ConnectionMonitor) sampleRates(now time.Time) {
+	cm.history.mu.Lock()
+	defer cm.history.mu.Unlock()
+	cm.limiterMap.Range(func(key, value interface{}) bool {
+		sl, ok := value.(*limiter.SharedLimiter)
+		if !ok {
+			return true
+		}
+		name := key.(string)
+		ring, ok := cm.history.bridges[name]
+		if !ok {
+			ring = &rateRing{samples: make([]RateSample, cm.history.size)}
+			cm.history.bridges[name] = ring
+		}
+		ring.add(RateSample{
+			Time:             now,
+			RateBitsPerSec:   float64(sl.GetActiveRate()) * 8.0,
+			TransferredBytes: sl.GetBytesTransferred(),
+		})
+		return true
+	})
+}
+
+// GetRateHistory returns a bridge's samples, oldest first, and the sample
+// interval. The interval is 0 if the sampler was never started.
+func (cm *ConnectionMonitor) GetRateHistory(name string) ([]RateSample, time.Duration) {
+	cm.history.mu.Lock()
+	defer cm.history.mu.Unlock()
+	ring, ok := cm.history.bridges[name]
+	if !ok {
+		return []RateSample{}, cm.history.interval
+	}
+	return ring.ordered(), cm.history.interval
+}
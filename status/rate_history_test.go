@@ -0,0 +1,64 @@
+package status
+
+import (
+	"context"
+	"salmoncannon/limiter"
+	"testing"
+	"time"
+)
+
+func TestRateRing_KeepsNewestInOrder(t *testing.T) {
+	r := &rateRing{samples: make([]RateSample, 3)}
+	base := time.Now()
+	for i := 0; i < 5; i++ {
+		r.add(RateSample{Time: base.Add(time.Duration(i) * time.Second), TransferredBytes: uint64(i)})
+	}
+	got := r.ordered()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 samples, got %d", len(got))
+	}
+	for i, want := range []uint64{2, 3, 4} {
+		if got[i].TransferredBytes != want {
+			t.Errorf("sample %d: expected %d, got %d", i, want, got[i].TransferredBytes)
+		}
+	}
+}
+
+func TestRateHistory_SamplesRegisteredLimiters(t *testing.T) {
+	cm := &ConnectionMonitor{}
+	cm.RegisterLimiter("hist-bridge", limiter.NewSharedLimiter(1024))
+
+	if samples, interval := cm.GetRateHistory("hist-bridge"); len(samples) != 0 || interval != 0 {
+		t.Fatalf("expected no history before the sampler starts, got %d samples", len(samples))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cm.StartRateHistory(ctx, 10*time.Millisecond, 4)
+	// A second start must not replace the first sampler's settings
+	cm.StartRateHistory(ctx, time.Hour, 100)
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	time.Sleep(30 * time.Millisecond)
+
+	samples, interval := cm.GetRateHistory("hist-bridge")
+	if interval != 10*time.Millisecond {
+		t.Errorf("expected interval 10ms, got %v", interval)
+	}
+	if len(samples) != 4 {
+		t.Fatalf("expected retention to cap history at 4 samples, got %d", len(samples))
+	}
+	for i := 1; i < len(samples); i++ {
+		if !samples[i].Time.After(samples[i-1].Time) {
+			t.Errorf("samples out of order at %d", i)
+		}
+	}
+
+	// The sampler is stopped, so history no longer grows
+	last := samples[len(samples)-1].Time
+	time.Sleep(50 * time.Millisecond)
+	samples, _ = cm.GetRateHistory("hist-bridge")
+	if !samples[len(samples)-1].Time.Equal(last) {
+		t.Errorf("expected sampler to stop after cancel")
+	}
+}
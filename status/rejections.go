@@ -0,0 +1,85 @@
+package status
+
+import "sync/atomic"
+
+// RejectCounts is a point-in-time copy of a bridge's rejection counters.
+type RejectCounts struct {
+	HandshakeFailures int64 // SOCKS requests that could not be parsed or authenticated
+	AuthMismatch      int64 // SOCKS5 clients that offered no auth method the bridge takes
+	AllowlistBlocks   int64 // clients or targets refused by an allowed in/out list
+	PoolSaturated     int64 // streams refused because every connection was full
+	DialFailures      int64 // targets that could not be connected to
+	ClientLimit       int64 // clients refused by SBMaxConcurrentClients
+	ClientRateLimit   int64 // connections refused by SBPerClientConnRate
+	OutClientLimit    int64 // target connections refused by SBMaxOutPerClient
+}
+
+type rejectCounters struct {
+	handshakeFailures atomic.Int64
+	authMismatch      atomic.Int64
+	allowlistBlocks   atomic.Int64
+	poolSaturated     atomic.Int64
+	dialFailures      atomic.Int64
+	clientLimit       atomic.Int64
+	clientRateLimit   atomic.Int64
+	outClientLimit    atomic.Int64
+}
+
+func (cm *ConnectionMonitor) rejects(bridgeName string) *rejectCounters {
+	if rc, ok := cm.rejectMap.Load(bridgeName); ok {
+		return rc.(*rejectCounters)
+	}
+	rc, _ := cm.rejectMap.LoadOrStore(bridgeName, &rejectCounters{})
+	return rc.(*rejectCounters)
+}
+
+func (cm *ConnectionMonitor) IncHandshakeFailure(bridgeName string) {
+	cm.rejects(bridgeName).handshakeFailures.Add(1)
+}
+
+func (cm *ConnectionMonitor) IncAuthMismatch(bridgeName string) {
+	cm.rejects(bridgeName).authMismatch.Add(1)
+}
+
+func (cm *ConnectionMonitor) IncAllowlistBlock(bridgeName string) {
+	cm.rejects(bridgeName).allowlistBlocks.Add(1)
+}
+
+func (cm *ConnectionMonitor) IncPoolSaturated(bridgeName string) {
+	cm.rejects(bridgeName).poolSaturated.Add(1)
+}
+
+func (cm *ConnectionMonitor) IncDialFailure(bridgeName string) {
+	cm.rejects(bridgeName).dialFailures.Add(1)
+}
+
+func (cm *ConnectionMonitor) IncClientLimit(bridgeName string) {
+	cm.rejects(bridgeName).clientLimit.Add(1)
+}
+
+func (cm *ConnectionMonitor) IncClientRateLimit(bridgeName string) {
+	cm.rejects(bridgeName).clientRateLimit.Add(1)
+}
+
+func (cm *ConnectionMonitor) IncOutClientLimit(bridgeName string) {
+	cm.rejects(bridgeName).outClientLimit.Add(1)
+}
+
+// Rejections returns the rejection counters of a bridge since start.
+func (cm *ConnectionMonitor) Rejections(bridgeName string) RejectCounts {
+	rc, ok := cm.rejectMap.Load(bridgeName)
+	if !ok {
+		return RejectCounts{}
+	}
+	c := rc.(*rejectCounters)
+	return RejectCounts{
+		HandshakeFailures: c.handshakeFailures.Load(),
+		AuthMismatch:      c.authMismatch.Load(),
+		AllowlistBlocks:   c.allowlistBlocks.Load(),
+		PoolSaturated:     c.poolSaturated.Load(),
+		DialFailures:      c.dialFailures.Load(),
+		ClientLimit:       c.clientLimit.Load(),
+		ClientRateLimit:   c.clientRateLimit.Load(),
+		OutClientLimit:    c.outClientLimit.Load(),
+	}
+}
@@ -0,0 +1,27 @@
+package status
+
+import "testing"
+
+func TestRejections(t *testing.T) {
+	cm := &ConnectionMonitor{}
+	if got := cm.Rejections("unknown"); got != (RejectCounts{}) {
+		t.Fatalf("expected zero counts for an unknown bridge, got %+v", got)
+	}
+
+	cm.IncHandshakeFailure("a")
+	cm.IncAuthMismatch("a")
+	cm.IncAllowlistBlock("a")
+	cm.IncAllowlistBlock("a")
+	cm.IncPoolSaturated("a")
+	cm.IncClientLimit("a")
+	cm.IncClientRateLimit("a")
+	cm.IncDialFailure("b")
+
+	want := RejectCounts{HandshakeFailures: 1, AuthMismatch: 1, AllowlistBlocks: 2, PoolSaturated: 1, ClientLimit: 1, ClientRateLimit: 1}
+	if got := cm.Rejections("a"); got != want {
+		t.Errorf("expected %+v for a, got %+v", want, got)
+	}
+	if got := cm.Rejections("b"); got != (RejectCounts{DialFailures: 1}) {
+		t.Errorf("expected one dial failure for b, got %+v", got)
+	}
+}
@@ -0,0 +1,147 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"regexp"
+	"salmoncannon/limiter"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxStatsDPacket keeps each datagram within a typical path MTU, so metrics
+// are not lost to fragmentation.
+const maxStatsDPacket = 1432
+
+var statsDUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// statsDExporter pushes the monitor's metrics to a StatsD server. Gauges
+// carry current values; counters carry what was added since the last flush.
+type statsDExporter struct {
+	cm     *ConnectionMonitor
+	conn   net.Conn
+	prefix string
+	sent   map[string]int64 // counter totals already sent, by metric
+}
+
+// StartStatsD sends the connection, stream, bandwidth, rejection and
+// compression metrics of every bridge to the StatsD server at endpoint
+// (host:port, UDP) every interval, until ctx is done. Metric names start
+// with prefix, then "bridge.<name>." for per-bridge ones.
+func (cm *ConnectionMonitor) StartStatsD(ctx context.Context, endpoint string, interval time.Duration, prefix string) error {
+	if interval <= 0 {
+		return fmt.Errorf("StatsD interval must be positive, got %v", interval)
+	}
+	conn, err := net.Dial("udp", endpoint)
+	if err != nil {
+		return err
+	}
+	e := &statsDExporter{cm: cm, conn: conn, prefix: prefix, sent: make(map[string]int64)}
+	go func() {
+		defer conn.Close()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.flush()
+			}
+		}
+	}()
+	return nil
+}
+
+// flush sends one round of metrics, packed into as few datagrams as fit.
+func (e *statsDExporter) flush() {
+	var packet strings.Builder
+	send := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := e.conn.Write([]byte(packet.String())); err != nil {
+			log.Printf("MONITOR: StatsD send to %s failed: %v", e.conn.RemoteAddr(), err)
+		}
+		packet.Reset()
+	}
+	for _, line := range e.lines() {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsDPacket {
+			send()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	send()
+}
+
+func (e *statsDExporter) lines() []string {
+	var lines []string
+	gauge := func(name string, v int64) {
+		// A signed gauge is an adjustment in StatsD, not a value
+		if v >= 0 {
+			lines = append(lines, fmt.Sprintf("%s.%s:%d|g", e.prefix, name, v))
+		}
+	}
+	counter := func(name string, total int64) {
+		delta := total - e.sent[name]
+		if delta < 0 {
+			// Reset, e.g. a bridge recreated under the same name
+			delta = total
+		}
+		e.sent[name] = total
+		lines = append(lines, fmt.Sprintf("%s.%s:%d|c", e.prefix, name, delta))
+	}
+
+	c := e.cm.Counts()
+	gauge("connections.active_socks", c.ActiveSOCKS)
+	gauge("connections.active_http", c.ActiveHTTP)
+	gauge("connections.active_redirect", c.ActiveRedirect)
+	gauge("connections.active_out", c.ActiveOUT)
+	counter("connections.socks", c.TotalSOCKS)
+	counter("connections.http", c.TotalHTTP)
+	counter("connections.redirect", c.TotalRedirect)
+	counter("connections.out", c.TotalOUT)
+
+	limiters := make(map[string]*limiter.SharedLimiter)
+	e.cm.limiterMap.Range(func(key, value interface{}) bool {
+		if sl, ok := value.(*limiter.SharedLimiter); ok {
+			limiters[key.(string)] = sl
+		}
+		return true
+	})
+	for _, name := range slices.Sorted(maps.Keys(limiters)) {
+		sl := limiters[name]
+		b := "bridge." + statsDUnsafe.ReplaceAllString(name, "_") + "."
+		alive := int64(0)
+		if e.cm.GetStatus(name) {
+			alive = 1
+		}
+		gauge(b+"alive", alive)
+		gauge(b+"active_streams", e.cm.GetStreamCount(name))
+		gauge(b+"last_ping_ms", e.cm.GetPing(name))
+		gauge(b+"rate_bps", sl.GetActiveRate()*8)
+		counter(b+"transferred_bytes", int64(sl.GetBytesTransferred()))
+
+		r := e.cm.Rejections(name)
+		counter(b+"handshake_failures", r.HandshakeFailures)
+		counter(b+"auth_method_mismatch", r.AuthMismatch)
+		counter(b+"allowlist_blocks", r.AllowlistBlocks)
+		counter(b+"pool_saturated", r.PoolSaturated)
+		counter(b+"dial_failures", r.DialFailures)
+		counter(b+"client_limit", r.ClientLimit)
+		counter(b+"client_rate_limit", r.ClientRateLimit)
+		counter(b+"out_client_limit", r.OutClientLimit)
+
+		comp := e.cm.Compression(name)
+		counter(b+"uncompressed_bytes", comp.UncompressedBytes)
+		counter(b+"compressed_bytes", comp.CompressedBytes)
+	}
+	return lines
+}
@@ -0,0 +1,112 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"salmoncannon/limiter"
+	"strings"
+	"testing"
+	"time"
+)
+
+// statsDSink is a fake StatsD server collecting the datagrams it gets.
+func statsDSink(t *testing.T) (net.PacketConn, func() string) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	buf := make([]byte, 65535)
+	return pc, func() string {
+		pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("no StatsD packet: %v", err)
+		}
+		return string(buf[:n])
+	}
+}
+
+func TestStartStatsD_SendsMetrics(t *testing.T) {
+	pc, next := statsDSink(t)
+	cm := &ConnectionMonitor{}
+	cm.RegisterLimiter("sink bridge", limiter.NewSharedLimiter(0))
+	cm.RegisterPing("sink bridge", 12)
+	cm.AddStream("sink bridge")
+	cm.IncSOCKS("sink bridge")
+	cm.IncDialFailure("sink bridge")
+	cm.IncDialFailure("sink bridge")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := cm.StartStatsD(ctx, pc.LocalAddr().String(), 50*time.Millisecond, "sc"); err != nil {
+		t.Fatalf("StartStatsD: %v", err)
+	}
+
+	first := next()
+	for _, want := range []string{
+		"sc.connections.active_socks:1|g",
+		"sc.connections.socks:1|c",
+		// The space is not allowed in a StatsD name
+		"sc.bridge.sink_bridge.alive:1|g",
+		"sc.bridge.sink_bridge.active_streams:1|g",
+		"sc.bridge.sink_bridge.last_ping_ms:12|g",
+		"sc.bridge.sink_bridge.dial_failures:2|c",
+		"sc.bridge.sink_bridge.uncompressed_bytes:0|c",
+	} {
+		if !strings.Contains(first+"\n", want+"\n") {
+			t.Errorf("expected %q in the first flush, got:\n%s", want, first)
+		}
+	}
+
+	// Counters only send what was added since the last flush
+	cm.IncDialFailure("sink bridge")
+	second := next()
+	if !strings.Contains(second, "sc.bridge.sink_bridge.dial_failures:1|c") ||
+		!strings.Contains(second, "sc.connections.socks:0|c") {
+		t.Errorf("expected counter deltas in the second flush, got:\n%s", second)
+	}
+	cm.DecSOCKS("sink bridge")
+}
+
+func TestStatsDExporter_BatchesPackets(t *testing.T) {
+	pc, next := statsDSink(t)
+	cm := &ConnectionMonitor{}
+	for i := 0; i < 20; i++ {
+		cm.RegisterLimiter(fmt.Sprintf("batch-%02d", i), limiter.NewSharedLimiter(0))
+	}
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	e := &statsDExporter{cm: cm, conn: conn, prefix: "sc", sent: make(map[string]int64)}
+	want := len(e.lines())
+	e.sent = make(map[string]int64)
+
+	e.flush()
+	got, packets := 0, 0
+	for got < want {
+		packet := next()
+		if len(packet) > maxStatsDPacket {
+			t.Fatalf("packet of %d bytes exceeds %d", len(packet), maxStatsDPacket)
+		}
+		got += len(strings.Split(packet, "\n"))
+		packets++
+	}
+	if got != want || packets < 2 {
+		t.Fatalf("expected %d lines over several packets, got %d in %d", want, got, packets)
+	}
+}
+
+func TestStartStatsD_BadEndpoint(t *testing.T) {
+	cm := &ConnectionMonitor{}
+	if err := cm.StartStatsD(context.Background(), "no-port", time.Second, "sc"); err == nil {
+		t.Fatalf("expected an endpoint without a port to fail")
+	}
+	if err := cm.StartStatsD(context.Background(), "127.0.0.1:8125", 0, "sc"); err == nil {
+		t.Fatalf("expected a zero interval to fail")
+	}
+}
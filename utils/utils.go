@@ -5,12 +5,17 @@ import (
 	"crypto/pbkdf2"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/sha512"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/pem"
+	"fmt"
 	"math/big"
+	"strings"
 	"time"
 )
 
@@ -71,3 +76,38 @@ func DeriveEncKeyFromBytesAndSalt(sharedSecret string, salt []byte) ([]byte, err
 	return dk, nil
 
 }
+
+// CertFingerprint returns the hex SHA-256 of a DER encoded certificate.
+func CertFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseCertFingerprint decodes a hex SHA-256 fingerprint. Colons and case
+// are ignored, so the output of openssl x509 -fingerprint -sha256 works.
+func ParseCertFingerprint(fingerprint string) ([]byte, error) {
+	clean := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+	fp, err := hex.DecodeString(clean)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate fingerprint: %w", err)
+	}
+	if len(fp) != sha256.Size {
+		return nil, fmt.Errorf("certificate fingerprint must be %d bytes of SHA-256, got %d", sha256.Size, len(fp))
+	}
+	return fp, nil
+}
+
+// PinnedCertVerifier returns a tls.Config.VerifyPeerCertificate func that
+// only accepts a peer whose leaf certificate matches fingerprint.
+func PinnedCertVerifier(fingerprint []byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("peer sent no certificate")
+		}
+		sum := sha256.Sum256(rawCerts[0])
+		if subtle.ConstantTimeCompare(sum[:], fingerprint) != 1 {
+			return fmt.Errorf("peer certificate fingerprint %s does not match the pinned fingerprint", hex.EncodeToString(sum[:]))
+		}
+		return nil
+	}
+}